@@ -7,33 +7,87 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/unicornultrafoundation/zerogo/internal/agent"
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
 )
 
 var version = "dev"
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rotate-identity" {
+		if err := cmdRotateIdentity(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "zerogo-agent: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tap" {
+		if err := cmdTAP(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "zerogo-agent: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		if err := cmdPreflight(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "zerogo-agent: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// CLI flags
 	var (
+		configPath   = flag.String("config", "", "path to agent config file (flags override its values)")
 		identityPath = flag.String("identity", "/etc/zerogo/identity.key", "path to identity key file")
 		listenPort   = flag.Int("port", 9993, "UDP listen port for VL1 transport")
 		tapName      = flag.String("tap", "zt0", "TAP device name")
 		tapIP        = flag.String("tap-ip", "", "IP/mask to assign to TAP (e.g., 10.147.17.1/24)")
-		tapMTU       = flag.Int("mtu", 2800, "TAP device MTU")
+		tapMTU       = flag.Int("mtu", 2800, "TAP device MTU, clamped to what fits the underlay")
+		underlayMTU  = flag.Int("underlay-mtu", agent.DefaultUnderlayMTU, "MTU of the network path to peers, used to clamp -mtu")
+		noMTUClamp   = flag.Bool("no-mtu-clamp", false, "use -mtu as is, even if encapsulated frames exceed the underlay MTU")
+		tapQueues    = flag.Int("tap-queues", 1, "TAP queues; more than 1 opens the device multiqueue with a reader per queue (Linux)")
+		txQueueLen   = flag.Int("txqueuelen", 0, "TAP transmit queue length (0=OS default)")
+		persistTAP   = flag.Bool("persist-tap", false, "keep the TAP device and its addresses across agent restarts (Linux)")
+		tunMode      = flag.Bool("tun", false, "L3 mode: use a TUN device and route IP packets to peers by member IP instead of switching Ethernet frames")
 		networkID    = flag.Int("network", 1, "network ID (for static mode)")
 		networks     = flag.String("networks", "", "comma-separated network IDs to join via controller")
 		peers        = flag.String("peer", "", "static peer(s): pubkey@host:port,pubkey@host:port")
+		allowPeers   = flag.String("allow-peers", "", "comma-separated public keys of the only peers allowed to connect (default: any)")
+		denyPeers    = flag.String("deny-peers", "", "comma-separated public keys of peers refused")
 		pskHex       = flag.String("psk", "", "pre-shared key (hex, 64 chars)")
 		controller   = flag.String("controller", "", "controller URL (ws://host:port or http://host:port)")
+		tlsCert      = flag.String("tls-cert", "", "client certificate (PEM) for mTLS with the controller, issued for the node address")
+		tlsKey       = flag.String("tls-key", "", "private key (PEM) of -tls-cert")
+		tlsCA        = flag.String("tls-ca", "", "CA certificate (PEM) verifying the controller (default: system roots)")
+		ctrlPins     = flag.String("controller-pin", "", "comma-separated controller certificate pins (sha256/<base64 public key hash> or hex SHA-256 fingerprint)")
 		stunServers  = flag.String("stun", "", "comma-separated STUN server URIs (e.g., stun:stun.l.google.com:19302)")
+		turnServers  = flag.String("turn", "", "comma-separated TURN server URIs for relay fallback (e.g., turn:relay.example.com:3478)")
+		turnUser     = flag.String("turn-user", "", "TURN username")
+		turnPass     = flag.String("turn-pass", "", "TURN password")
+		portMap      = flag.Bool("portmap", false, "request a UDP port forward from the gateway (NAT-PMP/UPnP)")
+		fullTunnel   = flag.Bool("full-tunnel", false, "route all traffic via the network's default gateway member")
+		dhcpRange    = flag.String("dhcp-range", "", "serve DHCP on the network from this pool (e.g., 10.147.17.100-10.147.17.199)")
+		statusListen = flag.String("status-listen", protocol.DefaultAgentStatusAddr, "local status endpoint address for zerogo-cli (empty to disable)")
+		captureFile  = flag.String("capture", "", "write frames passing through the switch to this pcap file (debugging)")
+		captureFilt  = flag.String("capture-filter", "", "capture filter, e.g. \"ether host 02:01:02:03:04:05 and arp\"")
+		captureMax   = flag.Int64("capture-max-bytes", 0, "stop capturing after this many bytes (0=no limit)")
 		logLevel     = flag.String("log-level", "info", "log level: debug, info, warn, error")
 		gaming       = flag.Bool("gaming", false, "enable gaming optimization mode (large socket buffers, DSCP EF, fast keepalive)")
 		dscp         = flag.Int("dscp", 0, "DSCP marking value (0=default, 46=EF; gaming mode defaults to 46)")
 		sndBuf       = flag.Int("sndbuf", 0, "UDP send buffer size in bytes (0=OS default; gaming mode defaults to 4MB)")
 		rcvBuf       = flag.Int("rcvbuf", 0, "UDP receive buffer size in bytes (0=OS default; gaming mode defaults to 4MB)")
+		keepalive    = flag.Duration("keepalive", 0, "peer keepalive interval (0=default 15s; gaming mode defaults to 5s)")
+		peerTimeout  = flag.Duration("peer-timeout", 0, "time without traffic before a peer is considered dead (0=default 60s)")
+		hsRetry      = flag.Duration("handshake-retry", 0, "delay between handshake retries (0=default 3s)")
+		maxPeers     = flag.Int("max-peers", 0, "most peers tracked at once; peers learned only from incoming hellos are dropped first (0=default 1024)")
+		preferFamily = flag.String("prefer-family", agent.FamilyIPv6, "address family tried first for peers with both: ipv6 or ipv4")
 		showVersion  = flag.Bool("version", false, "show version and exit")
 		showIdentity = flag.Bool("show-identity", false, "show identity and exit")
 	)
@@ -44,6 +98,26 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Config file values, then ZEROGO_* environment variables, fill in
+	// flags not given on the command line
+	fileCfg := &config.AgentConfig{}
+	if *configPath != "" {
+		var err error
+		fileCfg, err = config.LoadAgentConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "zerogo-agent: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := config.ApplyEnv(fileCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "zerogo-agent: %v\n", err)
+		os.Exit(1)
+	}
+	if err := applyConfigFile(flag.CommandLine, fileCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "zerogo-agent: config: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Setup logging
 	var level slog.Level
 	switch strings.ToLower(*logLevel) {
@@ -58,6 +132,11 @@ func main() {
 	}
 	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 
+	if *preferFamily != agent.FamilyIPv4 && *preferFamily != agent.FamilyIPv6 {
+		log.Error("invalid -prefer-family: must be ipv4 or ipv6", "value", *preferFamily)
+		os.Exit(1)
+	}
+
 	// Parse PSK
 	var psk [32]byte
 	if *pskHex != "" {
@@ -76,14 +155,39 @@ func main() {
 		TAPName:       *tapName,
 		TAPIPv4:       *tapIP,
 		TAPMTU:        *tapMTU,
+		UnderlayMTU:   *underlayMTU,
+		NoMTUClamp:    *noMTUClamp,
+		TAPQueues:     *tapQueues,
+		TxQueueLen:    *txQueueLen,
+		PersistentTAP: *persistTAP,
+		TUNMode:       *tunMode,
 		NetworkID:     uint32(*networkID),
 		PSK:           psk,
 		ControllerURL: *controller,
+		TLSCert:       *tlsCert,
+		TLSKey:        *tlsKey,
+		TLSCA:         *tlsCA,
 		Gaming:        *gaming,
 		DSCP:          *dscp,
 		SndBuf:        *sndBuf,
 		RcvBuf:        *rcvBuf,
-		LogLevel:      *logLevel,
+
+		KeepaliveInterval:      *keepalive,
+		PeerTimeout:            *peerTimeout,
+		HandshakeRetryInterval: *hsRetry,
+		MaxPeers:               *maxPeers,
+		PreferFamily:           *preferFamily,
+
+		StatusListen: *statusListen,
+		PortMap:      *portMap,
+		FullTunnel:   *fullTunnel,
+		DHCPRange:    *dhcpRange,
+		LogLevel:     *logLevel,
+		Version:      version,
+
+		CaptureFile:     *captureFile,
+		CaptureFilter:   *captureFilt,
+		CaptureMaxBytes: *captureMax,
 	}
 
 	// Gaming mode defaults
@@ -97,6 +201,9 @@ func main() {
 		if cfg.RcvBuf == 0 {
 			cfg.RcvBuf = 4 * 1024 * 1024 // 4MB
 		}
+		if cfg.KeepaliveInterval == 0 {
+			cfg.KeepaliveInterval = vl1.GamingKeepaliveInterval
+		}
 		if cfg.LogLevel == "" || cfg.LogLevel == "debug" {
 			cfg.LogLevel = "info" // suppress debug noise in gaming mode
 			level = slog.LevelInfo
@@ -114,6 +221,27 @@ func main() {
 		}
 	}
 
+	// Parse controller pins
+	for _, s := range strings.Split(*ctrlPins, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			cfg.ControllerPins = append(cfg.ControllerPins, s)
+		}
+	}
+
+	// Parse TURN servers
+	if *turnServers != "" {
+		for _, s := range strings.Split(*turnServers, ",") {
+			s = strings.TrimSpace(s)
+			if s != "" {
+				cfg.TURNServers = append(cfg.TURNServers, vl1.TURNServer{
+					URL:      s,
+					Username: *turnUser,
+					Password: *turnPass,
+				})
+			}
+		}
+	}
+
 	// Parse network IDs for controller mode
 	if *networks != "" {
 		cfg.Networks = strings.Split(*networks, ",")
@@ -144,6 +272,13 @@ func main() {
 		}
 	}
 
+	if *allowPeers != "" {
+		cfg.AllowPeers = strings.Split(*allowPeers, ",")
+	}
+	if *denyPeers != "" {
+		cfg.DenyPeers = strings.Split(*denyPeers, ",")
+	}
+
 	// Create and start agent
 	a, err := agent.New(cfg, log)
 	if err != nil {
@@ -170,3 +305,66 @@ func main() {
 
 	a.Stop()
 }
+
+// applyConfigFile sets each flag from the config file (with environment
+// overrides applied) unless it was given explicitly on the command line.
+func applyConfigFile(fs *flag.FlagSet, cfg *config.AgentConfig) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	values := map[string]string{
+		"identity":   cfg.IdentityPath,
+		"controller": cfg.Controller,
+		"stun":       strings.Join(cfg.STUNServers, ","),
+		"log-level":  cfg.LogLevel,
+		"psk":        cfg.PSK,
+		"dhcp-range": cfg.DHCPRange,
+		"tls-cert":   cfg.TLSCert,
+		"tls-key":    cfg.TLSKey,
+		"tls-ca":     cfg.TLSCA,
+
+		"controller-pin": strings.Join(cfg.ControllerPins, ","),
+		"allow-peers":    strings.Join(cfg.AllowPeers, ","),
+		"deny-peers":     strings.Join(cfg.DenyPeers, ","),
+
+		"keepalive":       cfg.KeepaliveInterval,
+		"peer-timeout":    cfg.PeerTimeout,
+		"handshake-retry": cfg.HandshakeRetryInterval,
+		"prefer-family":   cfg.PreferFamily,
+	}
+	if cfg.ListenPort != 0 {
+		values["port"] = strconv.Itoa(cfg.ListenPort)
+	}
+	if cfg.FullTunnel {
+		values["full-tunnel"] = "true"
+	}
+	if cfg.MaxPeers != 0 {
+		values["max-peers"] = strconv.Itoa(cfg.MaxPeers)
+	}
+	if cfg.UnderlayMTU != 0 {
+		values["underlay-mtu"] = strconv.Itoa(cfg.UnderlayMTU)
+	}
+	if cfg.NoMTUClamp {
+		values["no-mtu-clamp"] = "true"
+	}
+	ids := make([]string, 0, len(cfg.Networks))
+	for _, n := range cfg.Networks {
+		ids = append(ids, n.ID)
+	}
+	values["networks"] = strings.Join(ids, ",")
+	peers := make([]string, 0, len(cfg.StaticPeers))
+	for _, p := range cfg.StaticPeers {
+		peers = append(peers, p.PublicKey+"@"+p.Address)
+	}
+	values["peer"] = strings.Join(peers, ",")
+
+	for name, value := range values {
+		if value == "" || explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+)
+
+// agentFlags is the part of the agent's command line the config tests use.
+type agentFlags struct {
+	fs          *flag.FlagSet
+	identity    *string
+	controller  *string
+	port        *int
+	logLevel    *string
+	networks    *string
+	stun        *string
+	peer        *string
+	psk         *string
+	fullTunnel  *bool
+	keepalive   *time.Duration
+	preferFam   *string
+	underlayMTU *int
+}
+
+func newAgentFlags(args ...string) (*agentFlags, error) {
+	fs := flag.NewFlagSet("zerogo-agent", flag.ContinueOnError)
+	f := &agentFlags{
+		fs:          fs,
+		identity:    fs.String("identity", "/etc/zerogo/identity.key", ""),
+		controller:  fs.String("controller", "", ""),
+		port:        fs.Int("port", 9993, ""),
+		logLevel:    fs.String("log-level", "info", ""),
+		networks:    fs.String("networks", "", ""),
+		stun:        fs.String("stun", "", ""),
+		peer:        fs.String("peer", "", ""),
+		psk:         fs.String("psk", "", ""),
+		fullTunnel:  fs.Bool("full-tunnel", false, ""),
+		keepalive:   fs.Duration("keepalive", 0, ""),
+		preferFam:   fs.String("prefer-family", "ipv6", ""),
+		underlayMTU: fs.Int("underlay-mtu", 1500, ""),
+	}
+	return f, fs.Parse(args)
+}
+
+const testAgentYAML = `
+identity_path: /var/lib/zerogo/identity.key
+controller: ws://file.example:9394
+listen_port: 9995
+log_level: debug
+networks:
+  - id: "1"
+  - id: "2"
+stun_servers: [stun:a.example:3478, stun:b.example:3478]
+static_peers:
+  - public_key: abcd
+    address: 192.0.2.1:9993
+full_tunnel: true
+keepalive_interval: 5s
+prefer_family: ipv4
+`
+
+func loadTestAgentConfig(t *testing.T) *config.AgentConfig {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	if err := os.WriteFile(path, []byte(testAgentYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadAgentConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestConfigFilePopulatesFlags(t *testing.T) {
+	f, err := newAgentFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(f.fs, loadTestAgentConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, ok := range map[string]bool{
+		"identity":      *f.identity == "/var/lib/zerogo/identity.key",
+		"controller":    *f.controller == "ws://file.example:9394",
+		"port":          *f.port == 9995,
+		"log-level":     *f.logLevel == "debug",
+		"networks":      *f.networks == "1,2",
+		"stun":          *f.stun == "stun:a.example:3478,stun:b.example:3478",
+		"peer":          *f.peer == "abcd@192.0.2.1:9993",
+		"full-tunnel":   *f.fullTunnel,
+		"keepalive":     *f.keepalive == 5*time.Second,
+		"prefer-family": *f.preferFam == "ipv4",
+	} {
+		if !ok {
+			t.Errorf("-%s not set from the config file", name)
+		}
+	}
+	// Unset file values leave the flag defaults alone
+	if *f.psk != "" || *f.underlayMTU != 1500 {
+		t.Errorf("psk = %q, underlay-mtu = %d, want the defaults", *f.psk, *f.underlayMTU)
+	}
+}
+
+func TestFlagsOverrideConfigFile(t *testing.T) {
+	f, err := newAgentFlags("-controller", "ws://flag.example:9394", "-port", "7000", "-full-tunnel=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(f.fs, loadTestAgentConfig(t)); err != nil {
+		t.Fatal(err)
+	}
+	if *f.controller != "ws://flag.example:9394" || *f.port != 7000 || *f.fullTunnel {
+		t.Errorf("controller = %q, port = %d, full-tunnel = %v, want the command line values", *f.controller, *f.port, *f.fullTunnel)
+	}
+	if *f.logLevel != "debug" {
+		t.Errorf("log-level = %q, want the config file value", *f.logLevel)
+	}
+}
+
+func TestConfigFileInvalidValue(t *testing.T) {
+	f, err := newAgentFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.AgentConfig{KeepaliveInterval: "often"}
+	if err := applyConfigFile(f.fs, cfg); err == nil {
+		t.Fatal("invalid keepalive interval accepted")
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/tap"
+)
+
+// preflightTAPName is the throwaway device created to test TAP access.
+const preflightTAPName = "zgpreflight0"
+
+// preflightTimeout bounds each network check.
+const preflightTimeout = 5 * time.Second
+
+// capNetAdmin is the CAP_NET_ADMIN bit in /proc/self/status capability masks.
+const capNetAdmin = 12
+
+// Preflight check outcomes.
+const (
+	checkPass = "PASS"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// checkResult is one line of the preflight report.
+type checkResult struct {
+	name   string
+	status string
+	detail string
+}
+
+// cmdPreflight checks that this host can run the agent: privileges, TAP
+// access, the UDP port, the identity file and the controller. It prints a
+// report and fails if any check fails.
+func cmdPreflight(args []string) error {
+	fs := flag.NewFlagSet("preflight", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to agent config file")
+	identityPath := fs.String("identity", "", "path to identity key file (default /etc/zerogo/identity.key)")
+	listenPort := fs.Int("port", 0, "UDP listen port to test (default 9993)")
+	controller := fs.String("controller", "", "controller URL to test (ws://host:port or http://host:port)")
+	tlsCA := fs.String("tls-ca", "", "CA certificate (PEM) verifying the controller (default: system roots)")
+	tlsCert := fs.String("tls-cert", "", "client certificate (PEM) for mTLS with the controller")
+	tlsKey := fs.String("tls-key", "", "private key (PEM) of -tls-cert")
+	statusAddr := fs.String("status-listen", protocol.DefaultAgentStatusAddr, "status endpoint of a running agent, to detect one")
+	fs.Parse(args)
+
+	fileCfg := &config.AgentConfig{}
+	if *configPath != "" {
+		var err error
+		if fileCfg, err = config.LoadAgentConfig(*configPath); err != nil {
+			return err
+		}
+	}
+	*identityPath = firstNonEmpty(*identityPath, fileCfg.IdentityPath, "/etc/zerogo/identity.key")
+	*controller = firstNonEmpty(*controller, fileCfg.Controller)
+	*tlsCA = firstNonEmpty(*tlsCA, fileCfg.TLSCA)
+	*tlsCert = firstNonEmpty(*tlsCert, fileCfg.TLSCert)
+	*tlsKey = firstNonEmpty(*tlsKey, fileCfg.TLSKey)
+	if *listenPort == 0 {
+		*listenPort = fileCfg.ListenPort
+	}
+	if *listenPort == 0 {
+		*listenPort = protocol.DefaultAgentPort
+	}
+
+	running := agentRunning(*statusAddr)
+	if running {
+		fmt.Printf("An agent is already running (status at %s); its port and TAP device may make checks fail.\n\n", *statusAddr)
+	}
+
+	id, idResult := checkIdentity(*identityPath)
+	results := []checkResult{
+		checkPrivileges(),
+		checkTAP(preflightTAPName),
+		checkUDPPort(*listenPort),
+		idResult,
+		checkController(*controller, *tlsCA, *tlsCert, *tlsKey, id, running),
+	}
+
+	failed := 0
+	for _, r := range results {
+		fmt.Printf("[%s] %-12s %s\n", r.status, r.name, r.detail)
+		if r.status == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d preflight check(s) failed", failed)
+	}
+	fmt.Println("\nAll critical checks passed.")
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// checkPrivileges reports whether the process may configure network
+// devices: root, or CAP_NET_ADMIN on Linux.
+func checkPrivileges() checkResult {
+	r := checkResult{name: "privileges"}
+	if runtime.GOOS == "windows" {
+		r.status, r.detail = checkSkip, "not checked on Windows; run as Administrator"
+		return r
+	}
+	if os.Geteuid() == 0 {
+		r.status, r.detail = checkPass, "running as root"
+		return r
+	}
+	hasCap, err := hasCapability(capNetAdmin)
+	switch {
+	case err != nil:
+		r.status, r.detail = checkFail, "not root and capabilities unknown ("+err.Error()+")"
+	case hasCap:
+		r.status, r.detail = checkPass, "CAP_NET_ADMIN"
+	default:
+		r.status, r.detail = checkFail, "neither root nor CAP_NET_ADMIN; run as root or: setcap cap_net_admin+ep $(which zerogo-agent)"
+	}
+	return r
+}
+
+// hasCapability reports whether bit is set in the process's effective
+// capabilities (Linux).
+func hasCapability(bit uint) (bool, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		mask, ok := strings.CutPrefix(sc.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(mask), 16, 64)
+		if err != nil {
+			return false, err
+		}
+		return caps&(1<<bit) != 0, nil
+	}
+	return false, errors.New("no CapEff in /proc/self/status")
+}
+
+// checkTAP creates and removes a TAP device.
+func checkTAP(name string) checkResult {
+	r := checkResult{name: "tap"}
+	dev, err := tap.NewTAP(name)
+	if err != nil {
+		r.status, r.detail = checkFail, "cannot create a TAP device: "+err.Error()
+		return r
+	}
+	created := dev.Name()
+	if err := dev.Close(); err != nil {
+		r.status, r.detail = checkWarn, fmt.Sprintf("created %s but could not remove it: %v", created, err)
+		return r
+	}
+	r.status, r.detail = checkPass, fmt.Sprintf("created and removed %s", created)
+	return r
+}
+
+// checkUDPPort binds the VL1 port.
+func checkUDPPort(port int) checkResult {
+	r := checkResult{name: "udp port"}
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		r.status, r.detail = checkFail, fmt.Sprintf("cannot bind UDP %d: %v", port, err)
+		return r
+	}
+	conn.Close()
+	r.status, r.detail = checkPass, fmt.Sprintf("UDP %d is free", port)
+	return r
+}
+
+// checkIdentity loads the identity file. A missing file is only a warning:
+// the agent creates it on first start.
+func checkIdentity(path string) (*identity.Identity, checkResult) {
+	r := checkResult{name: "identity"}
+	id, err := identity.Load(path)
+	switch {
+	case err == nil:
+		r.status, r.detail = checkPass, fmt.Sprintf("%s (%s)", path, id.Address)
+	case errors.Is(err, os.ErrNotExist):
+		r.status, r.detail = checkWarn, fmt.Sprintf("%s does not exist; the agent will create it", path)
+	default:
+		r.status, r.detail = checkFail, err.Error()
+	}
+	return id, r
+}
+
+// checkController checks that the controller answers /readyz and accepts
+// the agent WebSocket handshake. The handshake is skipped while an agent
+// runs, as it would replace that agent's connection.
+func checkController(ctrlURL, caPath, certPath, keyPath string, id *identity.Identity, agentRunning bool) checkResult {
+	r := checkResult{name: "controller"}
+	if ctrlURL == "" {
+		r.status, r.detail = checkSkip, "no controller configured (static mode)"
+		return r
+	}
+	tlsCfg, err := preflightTLSConfig(caPath, certPath, keyPath)
+	if err != nil {
+		r.status, r.detail = checkFail, err.Error()
+		return r
+	}
+
+	base := controllerHTTPURL(ctrlURL)
+	client := &http.Client{Timeout: preflightTimeout, Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+	resp, err := client.Get(base + "/readyz")
+	if err != nil {
+		r.status, r.detail = checkFail, "unreachable: "+err.Error()
+		return r
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		r.status, r.detail = checkFail, fmt.Sprintf("%s/readyz answered %s", base, resp.Status)
+		return r
+	}
+
+	if agentRunning {
+		r.status, r.detail = checkPass, base+" is ready (WebSocket handshake skipped: an agent is running)"
+		return r
+	}
+	if id == nil {
+		// Not saved: a key pair that is never used to join registers nothing
+		if id, err = identity.Generate(); err != nil {
+			r.status, r.detail = checkFail, err.Error()
+			return r
+		}
+	}
+	header := http.Header{}
+	header.Set("X-Node-Address", id.Address.String())
+	header.Set("X-Public-Key", id.PublicKeyHex())
+	dialer := websocket.Dialer{HandshakeTimeout: preflightTimeout, TLSClientConfig: tlsCfg}
+	wsURL := strings.Replace(strings.Replace(base, "https://", "wss://", 1), "http://", "ws://", 1)
+	conn, resp, err := dialer.Dial(wsURL+"/api/v1/agent/connect", header)
+	if err != nil {
+		if resp != nil {
+			err = fmt.Errorf("%w (%s)", err, resp.Status)
+		}
+		r.status, r.detail = checkFail, "WebSocket handshake failed: "+err.Error()
+		return r
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "preflight"), time.Now().Add(time.Second))
+	conn.Close()
+	r.status, r.detail = checkPass, base+" is ready and accepted the WebSocket handshake"
+	return r
+}
+
+// preflightTLSConfig loads the controller CA and client certificate the
+// agent would use.
+func preflightTLSConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caPath != "" {
+		data, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("load controller CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("load controller CA: %s: no PEM certificates", caPath)
+		}
+	}
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// agentRunning reports whether an agent answers on its status endpoint.
+func agentRunning(statusAddr string) bool {
+	if statusAddr == "" {
+		return false
+	}
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get("http://" + statusAddr + "/status")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+func TestCheckUDPPort(t *testing.T) {
+	held, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := held.LocalAddr().(*net.UDPAddr).Port
+	if r := checkUDPPort(port); r.status != checkFail || !strings.Contains(r.detail, "cannot bind") {
+		t.Errorf("held port: %+v", r)
+	}
+	held.Close()
+	if r := checkUDPPort(port); r.status != checkPass {
+		t.Errorf("free port: %+v", r)
+	}
+}
+
+func TestCheckIdentity(t *testing.T) {
+	dir := t.TempDir()
+	saved, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := filepath.Join(dir, "identity.key")
+	if err := saved.Save(good); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := filepath.Join(dir, "corrupt.key")
+	if err := os.WriteFile(corrupt, []byte("0123456789"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, r := checkIdentity(good); r.status != checkPass || id == nil || id.Address != saved.Address {
+		t.Errorf("saved identity: %v, %+v", id, r)
+	}
+	if id, r := checkIdentity(filepath.Join(dir, "missing.key")); r.status != checkWarn || id != nil {
+		t.Errorf("missing identity: %v, %+v", id, r)
+	}
+	if _, r := checkIdentity(corrupt); r.status != checkFail {
+		t.Errorf("corrupt identity: %+v", r)
+	}
+}
+
+// fakeController answers /readyz with ready and upgrades agent connections.
+func fakeController(t *testing.T, ready int) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(ready)
+	})
+	mux.HandleFunc("GET /api/v1/agent/connect", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Node-Address") == "" || r.Header.Get("X-Public-Key") == "" {
+			http.Error(w, "missing identity headers", http.StatusBadRequest)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.ReadMessage() // until the preflight closes
+		conn.Close()
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckController(t *testing.T) {
+	ready := fakeController(t, http.StatusOK)
+	wsURL := "ws" + strings.TrimPrefix(ready.URL, "http")
+	unready := fakeController(t, http.StatusServiceUnavailable)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		running bool
+		status  string
+		detail  string
+	}{
+		{"static mode", "", false, checkSkip, "no controller"},
+		{"ready", wsURL, false, checkPass, "accepted the WebSocket handshake"},
+		{"agent running", wsURL, true, checkPass, "handshake skipped"},
+		{"not ready", unready.URL, false, checkFail, "503"},
+		{"unreachable", closed.URL, false, checkFail, "unreachable"},
+	}
+	for _, tt := range tests {
+		r := checkController(tt.url, "", "", "", nil, tt.running)
+		if r.status != tt.status || !strings.Contains(r.detail, tt.detail) {
+			t.Errorf("%s: %+v, want %s with %q", tt.name, r, tt.status, tt.detail)
+		}
+	}
+
+	if r := checkController(wsURL, filepath.Join(t.TempDir(), "missing-ca.pem"), "", "", nil, false); r.status != checkFail || !strings.Contains(r.detail, "controller CA") {
+		t.Errorf("missing CA: %+v", r)
+	}
+}
+
+func TestAgentRunning(t *testing.T) {
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer status.Close()
+	if !agentRunning(strings.TrimPrefix(status.URL, "http://")) {
+		t.Error("agent answering on its status endpoint not detected")
+	}
+	status.Close()
+	if agentRunning(strings.TrimPrefix(status.URL, "http://")) || agentRunning("") {
+		t.Error("agent detected with nothing listening")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// cmdRotateIdentity replaces the node's identity with a new keypair. The
+// controller moves the node's memberships to the new address once both the
+// old and new keys have signed the rotation; only then is the identity file
+// replaced.
+func cmdRotateIdentity(args []string) error {
+	fs := flag.NewFlagSet("rotate-identity", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to agent config file")
+	identityPath := fs.String("identity", "", "path to identity key file (default /etc/zerogo/identity.key)")
+	controller := fs.String("controller", "", "controller URL (http://host:port)")
+	tlsCA := fs.String("tls-ca", "", "CA certificate (PEM) verifying the controller (default: system roots)")
+	fs.Parse(args)
+
+	fileCfg := &config.AgentConfig{}
+	if *configPath != "" {
+		var err error
+		if fileCfg, err = config.LoadAgentConfig(*configPath); err != nil {
+			return err
+		}
+	}
+	if *identityPath == "" {
+		*identityPath = fileCfg.IdentityPath
+	}
+	if *identityPath == "" {
+		*identityPath = "/etc/zerogo/identity.key"
+	}
+	if *controller == "" {
+		*controller = fileCfg.Controller
+	}
+	if *controller == "" {
+		return fmt.Errorf("-controller is required")
+	}
+	if *tlsCA == "" {
+		*tlsCA = fileCfg.TLSCA
+	}
+	client, err := rotationClient(*tlsCA)
+	if err != nil {
+		return err
+	}
+
+	oldID, err := identity.Load(*identityPath)
+	if err != nil {
+		return err
+	}
+	newID, err := identity.Generate()
+	if err != nil {
+		return err
+	}
+
+	// Keep the new key on disk before the controller forgets the old one,
+	// so a failure below cannot lose both
+	pending := *identityPath + ".new"
+	if err := newID.Save(pending); err != nil {
+		return err
+	}
+
+	req := protocol.RotateIdentityRequest{
+		OldAddress:    oldID.Address.String(),
+		NewAddress:    newID.Address.String(),
+		NewPublicKey:  newID.PublicKeyHex(),
+		NewSigningKey: hex.EncodeToString(newID.SigningPublicKey()),
+	}
+	data := protocol.RotationSignedData(req.OldAddress, req.NewAddress, req.NewPublicKey, req.NewSigningKey)
+	req.OldSignature = hex.EncodeToString(oldID.Sign(data))
+	req.NewSignature = hex.EncodeToString(newID.Sign(data))
+
+	resp, err := postRotation(client, controllerHTTPURL(*controller), req)
+	if err != nil {
+		os.Remove(pending)
+		return err
+	}
+
+	if err := os.Rename(pending, *identityPath); err != nil {
+		return fmt.Errorf("controller accepted the rotation but the identity file could not be replaced; move %s to %s: %w", pending, *identityPath, err)
+	}
+
+	fmt.Printf("Identity rotated: %s -> %s\n", oldID.Address, newID.Address)
+	fmt.Printf("Memberships moved: %d network(s)\n", len(resp.Networks))
+	fmt.Println("Restart the agent to connect with the new identity.")
+	if fileCfg.TLSCert != "" {
+		fmt.Printf("The client certificate %s is for the old address; issue one for %s first.\n", fileCfg.TLSCert, newID.Address)
+	}
+	return nil
+}
+
+// rotationClient returns the HTTP client for the rotation request. The
+// request is signed, so no client certificate is presented; caPath, if set,
+// verifies the controller.
+func rotationClient(caPath string) (*http.Client, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if caPath == "" {
+		return client, nil
+	}
+	data, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("load controller CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("load controller CA: %s: no PEM certificates", caPath)
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	return client, nil
+}
+
+func postRotation(client *http.Client, base string, req protocol.RotateIdentityRequest) (*protocol.RotateIdentityResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Post(base+"/api/v1/agent/rotate-identity", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("contact controller: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		msg, _ := io.ReadAll(resp.Body)
+		var apiErr protocol.APIError
+		if json.Unmarshal(msg, &apiErr) == nil && apiErr.Code != "" {
+			return nil, fmt.Errorf("controller rejected rotation: HTTP %d: %w (%s)", resp.StatusCode, &apiErr, apiErr.Code)
+		}
+		return nil, fmt.Errorf("controller rejected rotation: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	var result protocol.RotateIdentityResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// controllerHTTPURL turns a ws:// or wss:// controller URL into its HTTP
+// equivalent.
+func controllerHTTPURL(u string) string {
+	switch {
+	case strings.HasPrefix(u, "ws://"):
+		u = "http://" + u[len("ws://"):]
+	case strings.HasPrefix(u, "wss://"):
+		u = "https://" + u[len("wss://"):]
+	}
+	return strings.TrimSuffix(u, "/")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/unicornultrafoundation/zerogo/internal/tap"
+)
+
+// cmdTAP lists the host's TUN/TAP devices or removes the stale ones an
+// agent left behind, which would otherwise make the next start fail with
+// "device exists". Only devices named like the agent's and held open by no
+// process are removed.
+func cmdTAP(args []string) error {
+	fs := flag.NewFlagSet("tap", flag.ExitOnError)
+	list := fs.Bool("list", false, "list TUN/TAP devices and whether each is stale")
+	cleanup := fs.Bool("cleanup", false, "delete stale agent devices")
+	dryRun := fs.Bool("dry-run", false, "with -cleanup, show what would be deleted")
+	fs.Parse(args)
+
+	if *list == *cleanup {
+		return fmt.Errorf("usage: zerogo-agent tap -list | -cleanup [-dry-run]")
+	}
+
+	links, err := tap.ListLinks()
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		stale := make(map[string]bool)
+		for _, l := range tap.StaleLinks(links) {
+			stale[l.Name] = true
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tTYPE\tIN USE\tAGENT\tSTALE")
+		for _, l := range links {
+			kind := "tap"
+			if l.TUN {
+				kind = "tun"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%v\n", l.Name, kind, l.Attached, tap.IsAgentDevice(l.Name), stale[l.Name])
+		}
+		return w.Flush()
+	}
+
+	stale := tap.StaleLinks(links)
+	if len(stale) == 0 {
+		fmt.Println("No stale devices.")
+		return nil
+	}
+	failed := 0
+	for _, l := range stale {
+		if *dryRun {
+			fmt.Printf("would delete %s\n", l.Name)
+			continue
+		}
+		if err := tap.DeleteLink(l.Name); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed++
+			continue
+		}
+		fmt.Printf("deleted %s\n", l.Name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d device(s) could not be deleted", failed)
+	}
+	return nil
+}
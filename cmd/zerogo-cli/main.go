@@ -2,12 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
@@ -37,6 +48,16 @@ func main() {
 		cmdJoin()
 	case "peers":
 		cmdPeers()
+	case "status":
+		cmdStatus()
+	case "ping":
+		cmdPing()
+	case "capture":
+		cmdCapture()
+	case "psk":
+		cmdPSK()
+	case "rebind":
+		cmdRebind()
 	case "version":
 		fmt.Printf("zerogo-cli %s\n", version)
 	case "help":
@@ -53,10 +74,15 @@ func printUsage() {
 
 Commands:
   identity    Show or generate node identity
-  networks    List/create/delete networks
+  networks    List/create/delete/restore networks
   members     List/authorize/remove network members
   join        Join a network (authorize this node)
   peers       List connected peers
+  status      Show local agent status
+  ping        Ping a peer over the overlay via the local agent
+  capture     Capture frames through the local agent's switch as pcap
+  psk         Generate or validate a pre-shared key for the agent's --psk
+  rebind      Move the local agent to another UDP port without restarting
   version     Show version
   help        Show this help`)
 }
@@ -67,6 +93,7 @@ func cmdIdentity() {
 	fs := flag.NewFlagSet("identity", flag.ExitOnError)
 	path := fs.String("identity", "/etc/zerogo/identity.key", "identity key path")
 	generate := fs.Bool("generate", false, "generate new identity")
+	format := addressFormatFlag(fs)
 	fs.Parse(os.Args[1:])
 
 	if *generate {
@@ -75,7 +102,7 @@ func cmdIdentity() {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Address:    %s\n", id.Address)
+		fmt.Printf("Address:    %s\n", format.address(id.Address))
 		fmt.Printf("Public Key: %s\n", id.PublicKeyHex())
 		return
 	}
@@ -85,27 +112,58 @@ func cmdIdentity() {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Address:    %s\n", id.Address)
+	fmt.Printf("Address:    %s\n", format.address(id.Address))
 	fmt.Printf("Public Key: %s\n", id.PublicKeyHex())
 }
 
 // --- Networks command ---
 
+// fetchNetworkPSK returns the PSK of a network; the controller only tells
+// admins.
+func fetchNetworkPSK(client *apiClient, networkID string) (string, error) {
+	var result protocol.NetworkPSK
+	if err := client.get("/api/v1/networks/"+networkID+"/psk", &result); err != nil {
+		return "", err
+	}
+	return result.PSK, nil
+}
+
+// rotateNetworkPSK replaces the PSK of a network and returns its ID. The
+// controller's response holds the new key too, but it is not passed on so
+// rotating does not print it; see fetchNetworkPSK.
+func rotateNetworkPSK(client *apiClient, networkID string) (uint32, error) {
+	var result protocol.NetworkPSK
+	if err := client.post("/api/v1/networks/"+networkID+"/psk/rotate", struct{}{}, &result); err != nil {
+		return 0, err
+	}
+	return result.NetworkID, nil
+}
+
 func cmdNetworks() {
 	fs := flag.NewFlagSet("networks", flag.ExitOnError)
 	controller := fs.String("controller", "http://localhost:9394", "controller URL")
 	token := fs.String("token", "", "JWT auth token")
+	refresh := fs.String("refresh-token", "", "refresh token used to renew an expired JWT")
 	create := fs.String("create", "", "create network with name")
 	ipRange := fs.String("ip-range", "10.147.17.0/24", "IP range for new network")
+	private := fs.Bool("private", false, "new network refuses nodes an admin has not added")
+	sourceValidation := fs.Bool("source-validation", false, "new network drops frames spoofing another member's MAC or IP")
 	del := fs.String("delete", "", "delete network by ID")
+	restore := fs.String("restore", "", "restore a deleted network by ID")
+	showPSK := fs.String("show-psk", "", "show the PSK of a network by ID (admin)")
+	rotatePSK := fs.String("rotate-psk", "", "replace the PSK of a network by ID (admin)")
+	yes := fs.Bool("yes", false, "confirm -rotate-psk")
 	fs.Parse(os.Args[1:])
 
-	client := &apiClient{base: *controller, token: *token}
+	client := &apiClient{base: *controller, token: *token, refreshToken: *refresh}
 
 	if *create != "" {
 		body := protocol.CreateNetworkRequest{
 			Name:    *create,
 			IPRange: *ipRange,
+			Private: private,
+
+			SourceValidation: sourceValidation,
 		}
 		var result protocol.Network
 		if err := client.post("/api/v1/networks", body, &result); err != nil {
@@ -121,7 +179,45 @@ func cmdNetworks() {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("Network deleted")
+		fmt.Printf("Network deleted (restore with -restore %s)\n", *del)
+		return
+	}
+
+	if *restore != "" {
+		var result protocol.Network
+		if err := client.post("/api/v1/networks/"+*restore+"/restore", struct{}{}, &result); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Restored network: %d (%s)\n", result.ID, result.Name)
+		return
+	}
+
+	if *showPSK != "" {
+		psk, err := fetchNetworkPSK(client, *showPSK)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(psk)
+		return
+	}
+
+	if *rotatePSK != "" {
+		fmt.Fprintf(os.Stderr, "WARNING: rotating the PSK of network %s briefly disrupts connectivity.\n", *rotatePSK)
+		fmt.Fprintln(os.Stderr, "Online members get the new key right away, but members that reconnect before")
+		fmt.Fprintln(os.Stderr, "every peer has it cannot complete handshakes; offline or static-mode members")
+		fmt.Fprintln(os.Stderr, "stay cut off until they are given the new key.")
+		if !*yes {
+			fmt.Fprintln(os.Stderr, "Re-run with -yes to rotate.")
+			os.Exit(1)
+		}
+		networkID, err := rotateNetworkPSK(client, *rotatePSK)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("PSK rotated for network %d (see -show-psk)\n", networkID)
 		return
 	}
 
@@ -146,10 +242,12 @@ func cmdMembers() {
 	fs := flag.NewFlagSet("members", flag.ExitOnError)
 	controller := fs.String("controller", "http://localhost:9394", "controller URL")
 	token := fs.String("token", "", "JWT auth token")
+	refresh := fs.String("refresh-token", "", "refresh token used to renew an expired JWT")
 	networkID := fs.String("network", "", "network ID")
 	authorize := fs.String("authorize", "", "node address to authorize")
 	remove := fs.String("remove", "", "node address to remove")
 	ip := fs.String("ip", "", "IP to assign when authorizing")
+	format := addressFormatFlag(fs)
 	fs.Parse(os.Args[1:])
 
 	if *networkID == "" {
@@ -157,11 +255,11 @@ func cmdMembers() {
 		os.Exit(1)
 	}
 
-	client := &apiClient{base: *controller, token: *token}
+	client := &apiClient{base: *controller, token: *token, refreshToken: *refresh}
 
 	if *authorize != "" {
 		body := protocol.AuthorizeMemberRequest{
-			NodeAddress: *authorize,
+			NodeAddress: addressArg(*authorize),
 			Authorized:  true,
 			IPAddress:   *ip,
 		}
@@ -170,12 +268,12 @@ func cmdMembers() {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Authorized: %s (IP: %s)\n", result.NodeAddress, result.IPAddress)
+		fmt.Printf("Authorized: %s (IP: %s)\n", format.show(result.NodeAddress), result.IPAddress)
 		return
 	}
 
 	if *remove != "" {
-		if err := client.delete("/api/v1/networks/" + *networkID + "/members/" + *remove); err != nil {
+		if err := client.delete("/api/v1/networks/" + *networkID + "/members/" + addressArg(*remove)); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
@@ -198,7 +296,7 @@ func cmdMembers() {
 			lastSeen = m.LastSeen.Format(time.RFC3339)
 		}
 		fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%s\t%s\n",
-			m.NodeAddress, m.IPAddress, m.Authorized, m.Online, m.Platform, lastSeen)
+			format.show(m.NodeAddress), m.IPAddress, m.Authorized, m.Online, m.Platform, lastSeen)
 	}
 	w.Flush()
 }
@@ -209,8 +307,12 @@ func cmdJoin() {
 	fs := flag.NewFlagSet("join", flag.ExitOnError)
 	controller := fs.String("controller", "http://localhost:9394", "controller URL")
 	token := fs.String("token", "", "JWT auth token")
+	refresh := fs.String("refresh-token", "", "refresh token used to renew an expired JWT")
 	networkID := fs.String("network", "", "network ID to join")
 	identityPath := fs.String("identity", "/etc/zerogo/identity.key", "identity key path")
+	format := addressFormatFlag(fs)
+	wait := fs.Bool("wait", false, "wait until an admin authorizes the node")
+	timeout := fs.Duration("timeout", 10*time.Minute, "how long -wait waits for authorization")
 	fs.Parse(os.Args[1:])
 
 	if *networkID == "" {
@@ -224,7 +326,7 @@ func cmdJoin() {
 		os.Exit(1)
 	}
 
-	client := &apiClient{base: *controller, token: *token}
+	client := &apiClient{base: *controller, token: *token, refreshToken: *refresh}
 	body := protocol.AuthorizeMemberRequest{
 		NodeAddress: id.Address.String(),
 		Authorized:  false, // Needs admin approval
@@ -236,8 +338,163 @@ func cmdJoin() {
 	}
 
 	fmt.Printf("Join request sent for network %s\n", *networkID)
-	fmt.Printf("Node address: %s\n", id.Address)
-	fmt.Printf("Status: waiting for admin authorization\n")
+	fmt.Printf("Node address: %s\n", format.address(id.Address))
+	if !*wait {
+		fmt.Printf("Status: waiting for admin authorization\n")
+		return
+	}
+
+	fmt.Printf("Waiting up to %s for admin authorization (Ctrl-C to stop)...\n", *timeout)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	client.ctx = ctx
+
+	member, err := waitAuthorized(ctx, client, *networkID, id.Address.String())
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "error: not authorized within %s; the join request stays pending\n", *timeout)
+		os.Exit(1)
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintln(os.Stderr, "interrupted; the join request stays pending")
+		os.Exit(130)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	printAuthorized(os.Stdout, member)
+}
+
+// printAuthorized reports the membership join -wait was waiting for.
+func printAuthorized(out io.Writer, member protocol.Member) {
+	ip := member.IPAddress
+	if ip == "" {
+		ip = "-"
+	}
+	fmt.Fprintf(out, "Status: authorized (IP: %s)\n", ip)
+}
+
+// joinPollInterval is how often join -wait checks the membership; a variable
+// so tests can poll faster.
+var joinPollInterval = 3 * time.Second
+
+// waitAuthorized polls the network's members until the node at addr is
+// authorized. Failed polls are reported and retried; the wait ends with
+// ctx or when the join request is removed.
+func waitAuthorized(ctx context.Context, client *apiClient, networkID, addr string) (protocol.Member, error) {
+	ticker := time.NewTicker(joinPollInterval)
+	defer ticker.Stop()
+	for {
+		var members []protocol.Member
+		err := client.get("/api/v1/networks/"+networkID+"/members", &members)
+		if ctx.Err() != nil {
+			return protocol.Member{}, ctx.Err()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: check membership: %v\n", err)
+		} else {
+			found := false
+			for _, m := range members {
+				if m.NodeAddress != addr {
+					continue
+				}
+				if m.Authorized {
+					return m, nil
+				}
+				found = true
+			}
+			if !found {
+				return protocol.Member{}, errors.New("the join request was removed")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return protocol.Member{}, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// --- Rebind command ---
+
+func cmdRebind() {
+	fs := flag.NewFlagSet("rebind", flag.ExitOnError)
+	url := fs.String("url", "http://"+protocol.DefaultAgentStatusAddr+"/rebind", "local agent rebind URL")
+	port := fs.Int("port", -1, "new UDP listen port (0 = any free port)")
+	fs.Parse(os.Args[1:])
+	if *port < 0 {
+		fmt.Fprintln(os.Stderr, "usage: zerogo-cli rebind -port <port>")
+		os.Exit(1)
+	}
+
+	client := &apiClient{base: *url}
+	var res protocol.AgentRebindResult
+	if err := client.post(fmt.Sprintf("?port=%d", *port), nil, &res); err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			fmt.Fprintf(os.Stderr, "error: cannot reach agent at %s (is zerogo-agent running?)\n", *url)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if res.Port == res.OldPort {
+		fmt.Printf("Already listening on port %d\n", res.Port)
+		return
+	}
+	fmt.Printf("Moved from port %d to %d\n", res.OldPort, res.Port)
+}
+
+// --- PSK command ---
+
+func cmdPSK() {
+	fs := flag.NewFlagSet("psk", flag.ExitOnError)
+	generate := fs.Bool("generate", false, "print a new random PSK")
+	validate := fs.String("validate", "", "check that a PSK is in the agent's --psk format")
+	fs.Parse(os.Args[1:])
+
+	switch {
+	case *generate:
+		psk, err := generatePSK()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(psk)
+	case *validate != "":
+		if err := validatePSK(*validate); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid PSK: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("PSK is valid")
+	default:
+		fmt.Fprintln(os.Stderr, "usage: zerogo-cli psk --generate | --validate <hex>")
+		os.Exit(1)
+	}
+}
+
+// generatePSK returns a random 32-byte pre-shared key, hex encoded.
+func generatePSK() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate PSK: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// validatePSK checks that s is a hex-encoded 32-byte key, as the agent's
+// --psk flag expects.
+func validatePSK(s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("not hex: %w", err)
+	}
+	if len(b) != 32 {
+		return fmt.Errorf("got %d bytes, want 32 (64 hex characters)", len(b))
+	}
+	return nil
 }
 
 // --- Peers command ---
@@ -246,60 +503,318 @@ func cmdPeers() {
 	fs := flag.NewFlagSet("peers", flag.ExitOnError)
 	controller := fs.String("controller", "http://localhost:9394", "controller URL")
 	token := fs.String("token", "", "JWT auth token")
+	refresh := fs.String("refresh-token", "", "refresh token used to renew an expired JWT")
+	format := addressFormatFlag(fs)
 	fs.Parse(os.Args[1:])
 
-	client := &apiClient{base: *controller, token: *token}
+	client := &apiClient{base: *controller, token: *token, refreshToken: *refresh}
 
-	var peers []json.RawMessage
+	var peers []protocol.Peer
 	if err := client.get("/api/v1/peers", &peers); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "ADDRESS\tPLATFORM\tONLINE\tLAST SEEN")
-	for _, raw := range peers {
-		var p struct {
-			Address  string    `json:"address"`
-			Platform string    `json:"platform"`
-			Online   bool      `json:"online"`
-			LastSeen time.Time `json:"last_seen"`
-		}
-		json.Unmarshal(raw, &p)
-		lastSeen := "-"
-		if !p.LastSeen.IsZero() {
-			lastSeen = p.LastSeen.Format(time.RFC3339)
+	fmt.Fprintln(w, "ADDRESS\tPLATFORM\tSTATUS\tLATENCY\tPATH\tNETWORKS\tLAST SEEN")
+	for _, p := range peers {
+		fmt.Fprintln(w, formatPeerRow(p, format))
+	}
+	w.Flush()
+}
+
+// formatPeerRow renders one peer as a tab-separated row of the peers table.
+func formatPeerRow(p protocol.Peer, format *addressFormat) string {
+	status := p.Status
+	if status == "" {
+		// Controllers that predate presence only report online
+		status = protocol.PresenceOffline
+		if p.Online {
+			status = protocol.PresenceConnected
+		}
+	}
+	latency := "-"
+	if p.LatencyMs > 0 {
+		latency = fmt.Sprintf("%dms", p.LatencyMs)
+	}
+	path := "-"
+	if p.Path != "" {
+		path = p.Path
+	}
+	networks := make([]string, 0, len(p.Networks))
+	for _, n := range p.Networks {
+		entry := strconv.FormatUint(uint64(n.NetworkID), 10)
+		if n.IPAddress != "" {
+			entry += "=" + n.IPAddress
+		}
+		if !n.Authorized {
+			entry += "(pending)"
+		}
+		networks = append(networks, entry)
+	}
+	lastSeen := "-"
+	if !p.LastSeen.IsZero() {
+		lastSeen = p.LastSeen.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s",
+		format.show(p.Address), p.Platform, status, latency, path, joinOrDash(networks), lastSeen)
+}
+
+// --- Status command ---
+
+func cmdStatus() {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	url := fs.String("url", "http://"+protocol.DefaultAgentStatusAddr+"/status", "local agent status URL")
+	format := addressFormatFlag(fs)
+	fs.Parse(os.Args[1:])
+
+	status, err := fetchStatus(*url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	printStatus(os.Stdout, status, format)
+}
+
+// fetchStatus reads the status of the agent serving url.
+func fetchStatus(url string) (protocol.AgentStatus, error) {
+	client := &apiClient{base: url}
+
+	var status protocol.AgentStatus
+	if err := client.get("", &status); err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			return status, fmt.Errorf("cannot reach agent at %s (is zerogo-agent running?)", url)
 		}
-		fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", p.Address, p.Platform, p.Online, lastSeen)
+		return status, err
+	}
+	return status, nil
+}
+
+// printStatus writes the agent status and its peer table to out.
+func printStatus(out io.Writer, status protocol.AgentStatus, format *addressFormat) {
+	fmt.Fprintf(out, "Address:  %s\n", format.show(status.Address))
+	if status.Version != "" {
+		fmt.Fprintf(out, "Version:  %s\n", status.Version)
+	}
+	if status.DeviceError != "" {
+		fmt.Fprintf(out, "Device:   %s (retrying)\n", status.DeviceError)
+	}
+	fmt.Fprintf(out, "IPs:      %s\n", joinOrDash(status.AssignedIPs))
+	fmt.Fprintf(out, "Networks: %s\n", joinOrDash(status.Networks))
+	if t := status.Transport; t != nil {
+		fmt.Fprintf(out, "Traffic:  %d pkts / %d bytes out, %d pkts / %d bytes in", t.PacketsSent, t.BytesSent, t.PacketsReceived, t.BytesReceived)
+		if t.ReadErrors > 0 || t.WriteErrors > 0 {
+			fmt.Fprintf(out, " (%d read, %d write errors)", t.ReadErrors, t.WriteErrors)
+		}
+		fmt.Fprintln(out)
+		if len(t.Drops) > 0 {
+			reasons := make([]string, 0, len(t.Drops))
+			for reason := range t.Drops {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			drops := make([]string, 0, len(reasons))
+			for _, reason := range reasons {
+				drops = append(drops, fmt.Sprintf("%s=%d", reason, t.Drops[reason]))
+			}
+			fmt.Fprintf(out, "Dropped:  %s\n", strings.Join(drops, ", "))
+		}
+	}
+	if sw := status.Switch; sw != nil {
+		fmt.Fprintf(out, "Switch:   %d unicast, %d unknown floods, %d broadcast floods, %d drops, %d MACs\n",
+			sw.UnicastHits, sw.UnknownFloods, sw.BroadcastFloods, sw.Drops, sw.MACTableSize)
+	}
+	fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tSTATE\tLATENCY\tPATH\tENDPOINT")
+	for _, p := range status.Peers {
+		latency := "-"
+		if p.LatencyMs > 0 {
+			latency = fmt.Sprintf("%dms", p.LatencyMs)
+		}
+		endpoint := p.Endpoint
+		if endpoint == "" {
+			endpoint = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", format.show(p.Address), p.State, latency, p.Path, endpoint)
 	}
 	w.Flush()
+	if len(status.Rules) > 0 {
+		fmt.Fprintln(out)
+		fmt.Fprintln(w, "RULE\tPRIORITY\tACTION\tHITS")
+		for _, r := range status.Rules {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%d\n", r.ID, r.Priority, r.Action, r.Hits)
+		}
+		w.Flush()
+	}
 }
 
-// --- HTTP client helper ---
+func cmdPing() {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	url := fs.String("url", "http://"+protocol.DefaultAgentStatusAddr+"/ping", "local agent ping URL")
+	count := fs.Int("c", 4, "number of pings")
+	timeout := fs.Duration("timeout", 5*time.Second, "time to wait for each reply")
+	format := addressFormatFlag(fs)
+	fs.Parse(os.Args[1:])
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: zerogo-cli ping [-c count] [-timeout 5s] <peer-address>")
+		os.Exit(1)
+	}
+	peer := addressArg(fs.Arg(0))
 
-type apiClient struct {
-	base  string
-	token string
+	client := &apiClient{base: *url}
+	failed := 0
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		var res protocol.AgentPingResult
+		path := fmt.Sprintf("?peer=%s&timeout=%s", peer, *timeout)
+		if err := client.post(path, nil, &res); err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				fmt.Fprintf(os.Stderr, "error: cannot reach agent at %s (is zerogo-agent running?)\n", *url)
+				os.Exit(1)
+			}
+			fmt.Printf("%s: %v\n", format.show(peer), err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: seq=%d rtt=%.2fms path=%s\n", format.show(res.Peer), i+1, res.RTTMs, res.Path)
+	}
+	if failed == *count {
+		os.Exit(1)
+	}
 }
 
-func (c *apiClient) get(path string, out interface{}) error {
-	req, err := http.NewRequest("GET", c.base+path, nil)
-	if err != nil {
-		return err
+func cmdCapture() {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	url := fs.String("url", "http://"+protocol.DefaultAgentStatusAddr+"/capture", "local agent capture URL")
+	out := fs.String("w", "-", "pcap file to write; - writes to stdout (e.g. | tcpdump -r -)")
+	filter := fs.String("filter", "", "filter, e.g. \"ether host 02:01:02:03:04:05 and arp\"")
+	maxBytes := fs.Int64("max-bytes", 0, "stop after this many bytes (0=until interrupted)")
+	fs.Parse(os.Args[1:])
+
+	q := neturl.Values{}
+	if *filter != "" {
+		q.Set("filter", *filter)
 	}
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
+	if *maxBytes > 0 {
+		q.Set("max_bytes", fmt.Sprint(*maxBytes))
+	}
+	target := *url
+	if len(q) > 0 {
+		target += "?" + q.Encode()
 	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		var opErr *net.OpError
+		if errors.As(err, &opErr) {
+			fmt.Fprintf(os.Stderr, "error: cannot reach agent at %s (is zerogo-agent running?)\n", *url)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode >= 400 {
+	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+		fmt.Fprintf(os.Stderr, "error: %s\n", strings.TrimSpace(string(body)))
+		os.Exit(1)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return
+	}
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "wrote %d bytes to %s\n", n, *out)
+	}
+}
+
+// addressFormat is the -address-format flag: how node addresses are shown.
+// Addresses are always sent to the controller and agent in hex.
+type addressFormat string
+
+func addressFormatFlag(fs *flag.FlagSet) *addressFormat {
+	f := addressFormat("hex")
+	fs.Var(&f, "address-format", "node address format: hex or base32")
+	return &f
+}
+
+func (f *addressFormat) String() string { return string(*f) }
+
+func (f *addressFormat) Set(s string) error {
+	if s != "hex" && s != "base32" {
+		return errors.New("must be hex or base32")
 	}
-	return json.NewDecoder(resp.Body).Decode(out)
+	*f = addressFormat(s)
+	return nil
+}
+
+func (f *addressFormat) address(addr identity.Address) string {
+	if *f == "base32" {
+		return addr.Base32()
+	}
+	return addr.String()
+}
+
+// show renders a hex address from an API response, leaving anything that is
+// not an address as is.
+func (f *addressFormat) show(s string) string {
+	addr, err := identity.AddressFromHex(s)
+	if err != nil {
+		return s
+	}
+	return f.address(addr)
+}
+
+// addressArg converts a node address given on the command line in either
+// format to hex.
+func addressArg(s string) string {
+	addr, err := identity.ParseAddress(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s: %v\n", s, err)
+		os.Exit(1)
+	}
+	return addr.String()
+}
+
+func joinOrDash(items []string) string {
+	if len(items) == 0 {
+		return "-"
+	}
+	return strings.Join(items, ", ")
+}
+
+// --- HTTP client helper ---
+
+type apiClient struct {
+	base         string
+	token        string
+	refreshToken string          // renews token on a 401, if set
+	ctx          context.Context // cancels requests, if set
+}
+
+func (c *apiClient) get(path string, out interface{}) error {
+	return c.do("GET", path, nil, out)
 }
 
 func (c *apiClient) post(path string, body interface{}, out interface{}) error {
@@ -307,22 +822,32 @@ func (c *apiClient) post(path string, body interface{}, out interface{}) error {
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", c.base+path, bytes.NewReader(data))
+	return c.do("POST", path, data, out)
+}
+
+func (c *apiClient) delete(path string) error {
+	return c.do("DELETE", path, nil, nil)
+}
+
+// do sends a request, refreshing the access token and retrying once if the
+// controller rejects it as expired.
+func (c *apiClient) do(method, path string, body []byte, out interface{}) error {
+	resp, err := c.send(method, path, body)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if c.token != "" {
-		req.Header.Set("Authorization", "Bearer "+c.token)
-	}
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	if resp.StatusCode == http.StatusUnauthorized && c.refreshToken != "" {
+		resp.Body.Close()
+		if err := c.refresh(); err != nil {
+			return fmt.Errorf("refresh token: %w", err)
+		}
+		if resp, err = c.send(method, path, body); err != nil {
+			return err
+		}
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+		return responseError(resp)
 	}
 	if out != nil {
 		return json.NewDecoder(resp.Body).Decode(out)
@@ -330,22 +855,65 @@ func (c *apiClient) post(path string, body interface{}, out interface{}) error {
 	return nil
 }
 
-func (c *apiClient) delete(path string) error {
-	req, err := http.NewRequest("DELETE", c.base+path, nil)
+// responseError returns an error wrapping the response's *protocol.APIError,
+// or one with the raw body if the controller did not send an APIError. It
+// names the request ID, which finds the request in the controller's logs.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	reqID := ""
+	if id := resp.Header.Get("X-Request-ID"); id != "" {
+		reqID = ", request ID " + id
+	}
+	var apiErr protocol.APIError
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Code != "" {
+		return fmt.Errorf("HTTP %d: %w (%s%s)", resp.StatusCode, &apiErr, apiErr.Code, reqID)
+	}
+	if reqID != "" {
+		return fmt.Errorf("HTTP %d: %s (%s)", resp.StatusCode, strings.TrimSpace(string(body)), reqID[2:])
+	}
+	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+}
+
+func (c *apiClient) send(method, path string, body []byte) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
 	if c.token != "" {
 		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	return http.DefaultClient.Do(req)
+}
+
+// refresh exchanges the refresh token for a new access token.
+func (c *apiClient) refresh() error {
+	data, err := json.Marshal(protocol.RefreshRequest{RefreshToken: c.refreshToken})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(c.base+"/api/v1/auth/refresh", "application/json", bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+		return responseError(resp)
+	}
+	var result protocol.LoginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
 	}
+	c.token = result.Token
 	return nil
 }
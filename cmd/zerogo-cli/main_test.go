@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func TestStatusFromFakeAgent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(protocol.AgentStatus{
+			Address:     "0123456789",
+			AssignedIPs: []string{"10.147.17.5/24", "fd00::5/64"},
+			Networks:    []string{"42"},
+			Peers: []protocol.AgentPeerStatus{
+				{Address: "abcdef0123", State: "connected", LatencyMs: 12, Path: "direct", Endpoint: "192.0.2.1:9993"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	status, err := fetchStatus(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	format := addressFormat("hex")
+	printStatus(&out, status, &format)
+
+	for _, want := range []string{
+		"Address:  0123456789",
+		"IPs:      10.147.17.5/24, fd00::5/64",
+		"Networks: 42",
+		"abcdef0123  connected  12ms",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestStatusAgentNotRunning(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String() + "/status"
+	ln.Close() // nothing listens there any more
+
+	_, err = fetchStatus(url)
+	if err == nil || !strings.Contains(err.Error(), "is zerogo-agent running?") {
+		t.Fatalf("err = %v, want the agent-not-running hint", err)
+	}
+}
+
+// mockController serves the PSK endpoints, answering only the admin token.
+func mockController(t *testing.T, rotated *int) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer admin-token" {
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(protocol.APIError{Code: protocol.ErrCodeAdminRequired, Message: "admin role required"})
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("GET /api/v1/networks/7/psk", admin(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(protocol.NetworkPSK{NetworkID: 7, PSK: "ab12"})
+	}))
+	mux.HandleFunc("POST /api/v1/networks/7/psk/rotate", admin(func(w http.ResponseWriter, r *http.Request) {
+		*rotated++
+		json.NewEncoder(w).Encode(protocol.NetworkPSK{NetworkID: 7, PSK: "cd34"})
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNetworkPSKCommands(t *testing.T) {
+	var rotated int
+	srv := mockController(t, &rotated)
+	client := &apiClient{base: srv.URL, token: "admin-token"}
+
+	psk, err := fetchNetworkPSK(client, "7")
+	if err != nil || psk != "ab12" {
+		t.Fatalf("show PSK = %q, %v", psk, err)
+	}
+	id, err := rotateNetworkPSK(client, "7")
+	if err != nil || id != 7 || rotated != 1 {
+		t.Fatalf("rotate PSK = %d, %v (%d rotations)", id, err, rotated)
+	}
+
+	// Non-admins get the controller's error and rotate nothing
+	client.token = "user-token"
+	var apiErr *protocol.APIError
+	if _, err := fetchNetworkPSK(client, "7"); !errors.As(err, &apiErr) || apiErr.Code != protocol.ErrCodeAdminRequired {
+		t.Fatalf("show PSK as a user: err = %v", err)
+	}
+	if _, err := rotateNetworkPSK(client, "7"); !errors.As(err, &apiErr) || rotated != 1 {
+		t.Fatalf("rotate PSK as a user: err = %v (%d rotations)", err, rotated)
+	}
+}
+
+func TestClientRefreshesExpiredToken(t *testing.T) {
+	var refreshes int
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		var req protocol.RefreshRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.RefreshToken != "refresh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(protocol.APIError{Code: protocol.ErrCodeInvalidToken, Message: "invalid refresh token"})
+			return
+		}
+		refreshes++
+		json.NewEncoder(w).Encode(protocol.LoginResponse{Token: "fresh-token"})
+	})
+	mux.HandleFunc("GET /api/v1/networks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(protocol.APIError{Code: protocol.ErrCodeInvalidToken, Message: "token expired"})
+			return
+		}
+		json.NewEncoder(w).Encode([]protocol.Network{{ID: 1, Name: "office"}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := &apiClient{base: srv.URL, token: "expired-token", refreshToken: "refresh-token"}
+	var networks []protocol.Network
+	if err := client.get("/api/v1/networks", &networks); err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 1 || client.token != "fresh-token" || refreshes != 1 {
+		t.Fatalf("networks = %+v, token = %q after %d refreshes", networks, client.token, refreshes)
+	}
+	// The renewed token is kept for later requests
+	if err := client.get("/api/v1/networks", &networks); err != nil || refreshes != 1 {
+		t.Fatalf("second request: %v (%d refreshes)", err, refreshes)
+	}
+
+	// Without a usable refresh token the 401 is reported
+	client = &apiClient{base: srv.URL, token: "expired-token", refreshToken: "revoked"}
+	var apiErr *protocol.APIError
+	if err := client.get("/api/v1/networks", &networks); !errors.As(err, &apiErr) || apiErr.Code != protocol.ErrCodeInvalidToken {
+		t.Fatalf("err = %v, want the refresh failure", err)
+	}
+	client = &apiClient{base: srv.URL, token: "expired-token"}
+	if err := client.get("/api/v1/networks", &networks); err == nil || !strings.Contains(err.Error(), "HTTP 401") {
+		t.Fatalf("err = %v, want HTTP 401", err)
+	}
+}
+
+func TestAddressFormatFlag(t *testing.T) {
+	status := protocol.AgentStatus{
+		Address: "0123456789",
+		Peers:   []protocol.AgentPeerStatus{{Address: "abcdef0123", State: "connected"}},
+	}
+	for format, want := range map[string][]string{
+		"hex":    {"Address:  0123456789", "abcdef0123  connected"},
+		"base32": {"Address:  04HMASW9", "NF6YY093  connected"},
+	} {
+		fs := flag.NewFlagSet("status", flag.ContinueOnError)
+		f := addressFormatFlag(fs)
+		if err := fs.Parse([]string{"-address-format", format}); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		printStatus(&out, status, f)
+		for _, w := range want {
+			if !strings.Contains(out.String(), w) {
+				t.Errorf("-address-format %s: output lacks %q:\n%s", format, w, out.String())
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addressFormatFlag(fs)
+	if err := fs.Parse([]string{"-address-format", "decimal"}); err == nil {
+		t.Error("unknown address format accepted")
+	}
+
+	// Addresses given in either format reach the API in hex
+	for _, s := range []string{"0123456789", "04HMASW9", "04hm-asw9"} {
+		if got := addressArg(s); got != "0123456789" {
+			t.Errorf("addressArg(%q) = %q", s, got)
+		}
+	}
+}
+
+func TestJoinWaitPollsUntilAuthorized(t *testing.T) {
+	joinPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { joinPollInterval = 3 * time.Second })
+
+	var polls int
+	members := func(m ...protocol.Member) []protocol.Member {
+		return append([]protocol.Member{{NodeAddress: "0000000002", Authorized: true, IPAddress: "10.1.0.2/24"}}, m...)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/networks/{id}/members", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		switch {
+		case r.PathValue("id") == "2":
+			json.NewEncoder(w).Encode(members())
+		case polls == 2:
+			w.WriteHeader(http.StatusBadGateway) // a failed poll only warns
+		case polls < 4:
+			json.NewEncoder(w).Encode(members(protocol.Member{NodeAddress: "0123456789"}))
+		default:
+			json.NewEncoder(w).Encode(members(protocol.Member{NodeAddress: "0123456789", Authorized: true, IPAddress: "10.1.0.7/24"}))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	client := &apiClient{base: srv.URL, token: "token", ctx: t.Context()}
+
+	member, err := waitAuthorized(t.Context(), client, "1", "0123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls != 4 || member.IPAddress != "10.1.0.7/24" {
+		t.Fatalf("member = %+v after %d polls, want authorized on the 4th", member, polls)
+	}
+	var out bytes.Buffer
+	printAuthorized(&out, member)
+	if got := out.String(); got != "Status: authorized (IP: 10.1.0.7/24)\n" {
+		t.Errorf("output = %q", got)
+	}
+	out.Reset()
+	printAuthorized(&out, protocol.Member{Authorized: true})
+	if got := out.String(); got != "Status: authorized (IP: -)\n" {
+		t.Errorf("output without an IP = %q", got)
+	}
+
+	// A rejected request stops the wait, and so does the timeout
+	if _, err := waitAuthorized(t.Context(), client, "2", "0123456789"); err == nil || !strings.Contains(err.Error(), "removed") {
+		t.Errorf("removed request: err = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	mux2 := http.NewServeMux()
+	mux2.HandleFunc("GET /api/v1/networks/{id}/members", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(members(protocol.Member{NodeAddress: "0123456789"}))
+	})
+	pending := httptest.NewServer(mux2)
+	defer pending.Close()
+	client = &apiClient{base: pending.URL, token: "token", ctx: ctx}
+	if _, err := waitAuthorized(ctx, client, "1", "0123456789"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("pending past the timeout: err = %v", err)
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	resp := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	err := responseError(resp(http.StatusNotFound, `{"code":"network_not_found","message":"network not found"}`))
+	var apiErr *protocol.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != protocol.ErrCodeNetworkNotFound {
+		t.Fatalf("err = %v, want the APIError", err)
+	}
+	if got := err.Error(); got != "HTTP 404: network not found (network_not_found)" {
+		t.Errorf("message = %q", got)
+	}
+
+	// A proxy in front of the controller may answer with anything
+	err = responseError(resp(http.StatusBadGateway, "<html>bad gateway</html>"))
+	if errors.As(err, &apiErr) || err.Error() != "HTTP 502: <html>bad gateway</html>" {
+		t.Errorf("non-API body: err = %v", err)
+	}
+
+	// The controller's request ID is passed on for support
+	tagged := resp(http.StatusNotFound, `{"code":"network_not_found","message":"network not found"}`)
+	tagged.Header = http.Header{"X-Request-Id": {"4f2a"}}
+	if got := responseError(tagged).Error(); got != "HTTP 404: network not found (network_not_found, request ID 4f2a)" {
+		t.Errorf("message = %q", got)
+	}
+	tagged = resp(http.StatusInternalServerError, "oops\n")
+	tagged.Header = http.Header{"X-Request-Id": {"4f2b"}}
+	if got := responseError(tagged).Error(); got != "HTTP 500: oops (request ID 4f2b)" {
+		t.Errorf("message = %q", got)
+	}
+}
+
+func TestGenerateAndValidatePSK(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 4 {
+		psk, err := generatePSK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(psk) != 64 || strings.ToLower(psk) != psk {
+			t.Fatalf("generated %q, want 64 lowercase hex characters", psk)
+		}
+		if err := validatePSK(psk); err != nil {
+			t.Fatalf("generated PSK %q rejected: %v", psk, err)
+		}
+		if seen[psk] {
+			t.Fatalf("PSK %q generated twice", psk)
+		}
+		seen[psk] = true
+	}
+
+	for _, bad := range []string{
+		"",
+		strings.Repeat("ab", 31),
+		strings.Repeat("ab", 33),
+		strings.Repeat("a", 63),
+		strings.Repeat("zz", 32),
+	} {
+		if err := validatePSK(bad); err == nil {
+			t.Errorf("validatePSK(%q) accepted", bad)
+		}
+	}
+}
+
+func TestFormatPeerRow(t *testing.T) {
+	// As listed by the controller
+	body := `[
+		{"address":"0123456789","platform":"linux","online":true,"status":"connected","latency_ms":12,"path":"direct",
+		 "networks":[{"network_id":1,"ip_address":"10.1.0.2/24","authorized":true},{"network_id":2,"authorized":false}],
+		 "last_seen":"2026-10-15T12:00:00Z"},
+		{"address":"abcdef0123","platform":"darwin","online":false,"status":"offline","last_seen":"0001-01-01T00:00:00Z"},
+		{"address":"fedcba9876","platform":"linux","online":true}
+	]`
+	var peers []protocol.Peer
+	if err := json.Unmarshal([]byte(body), &peers); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("peers", flag.ContinueOnError)
+	format := addressFormatFlag(fs)
+	want := []string{
+		"0123456789\tlinux\tconnected\t12ms\tdirect\t1=10.1.0.2/24, 2(pending)\t2026-10-15T12:00:00Z",
+		"abcdef0123\tdarwin\toffline\t-\t-\t-\t-",
+		"fedcba9876\tlinux\tconnected\t-\t-\t-\t-", // from a controller without presence
+	}
+	for i, p := range peers {
+		if got := formatPeerRow(p, format); got != want[i] {
+			t.Errorf("row %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/controller"
+)
+
+// defaultConfigPath is where init writes the config when -config is not set.
+const defaultConfigPath = "/etc/zerogo/controller.yaml"
+
+// cmdInit writes a starter config with a random JWT secret and the initial
+// admin account, whose password is stored only as a bcrypt hash.
+func cmdInit(configPath string, args []string) int {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	dataDir := fs.String("data-dir", "/var/lib/zerogo", "directory holding the controller database")
+	adminUser := fs.String("admin-user", "admin", "initial admin username")
+	adminPassword := fs.String("admin-password", "", "initial admin password (prompted for if empty)")
+	force := fs.Bool("force", false, "overwrite an existing config file")
+	fs.Parse(args)
+
+	if configPath == "" {
+		configPath = defaultConfigPath
+	}
+	if _, err := os.Stat(configPath); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "zerogo-controller: %s already exists (use -force to overwrite)\n", configPath)
+		return 1
+	}
+
+	password := *adminPassword
+	if password == "" {
+		var err error
+		password, err = promptLine(os.Stdin, os.Stderr, fmt.Sprintf("Password for %s: ", *adminUser))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "zerogo-controller: read password: %v\n", err)
+			return 1
+		}
+	}
+
+	cfg, err := initConfig(*dataDir, *adminUser, password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "zerogo-controller: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(*dataDir, 0o700); err != nil {
+		fmt.Fprintf(os.Stderr, "zerogo-controller: create data directory: %v\n", err)
+		return 1
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "zerogo-controller: create config directory: %v\n", err)
+		return 1
+	}
+	if err := config.SaveControllerConfig(configPath, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "zerogo-controller: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Wrote %s\n", configPath)
+	fmt.Printf("Start the controller with: zerogo-controller -config %s\n", configPath)
+	return 0
+}
+
+// initConfig returns the default config with its database under dataDir, a
+// random JWT secret, and the admin account with a hashed password.
+func initConfig(dataDir, username, password string) (*config.ControllerConfig, error) {
+	if username == "" {
+		return nil, errors.New("admin username is required")
+	}
+	if err := controller.ValidatePassword(password); err != nil {
+		return nil, fmt.Errorf("admin password: %w", err)
+	}
+	dir, err := filepath.Abs(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("data directory: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("generate jwt secret: %w", err)
+	}
+	hash, err := controller.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("hash admin password: %w", err)
+	}
+
+	cfg := config.DefaultControllerConfig()
+	cfg.Database = "sqlite://" + filepath.Join(dir, "controller.db")
+	cfg.JWTSecret = hex.EncodeToString(secret)
+	cfg.Admin = config.AdminConfig{Username: username, PasswordHash: hash}
+	return cfg, nil
+}
+
+// promptLine writes prompt to w and reads one line from r. The input is
+// echoed; pass -admin-password to script init instead.
+func promptLine(r io.Reader, w io.Writer, prompt string) (string, error) {
+	fmt.Fprint(w, prompt)
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
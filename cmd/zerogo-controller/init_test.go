@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/controller"
+)
+
+const testAdminPassword = "Correct-Horse-42"
+
+func TestInitWritesValidConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "etc", "controller.yaml")
+	dataDir := filepath.Join(dir, "data")
+	if code := cmdInit(path, []string{"-data-dir", dataDir, "-admin-user", "root", "-admin-password", testAdminPassword}); code != 0 {
+		t.Fatalf("init exited %d", code)
+	}
+
+	if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0o600 {
+		t.Fatalf("config file: %v, %v, want mode 0600", st, err)
+	}
+	if st, err := os.Stat(dataDir); err != nil || !st.IsDir() {
+		t.Fatalf("data directory not created: %v", err)
+	}
+	cfg, err := config.LoadControllerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.JWTSecret == config.DefaultControllerConfig().JWTSecret || len(cfg.JWTSecret) != 64 {
+		t.Errorf("jwt secret = %q, want 32 random bytes in hex", cfg.JWTSecret)
+	}
+	if cfg.Database != "sqlite://"+filepath.Join(dataDir, "controller.db") {
+		t.Errorf("database = %q, want one under the data directory", cfg.Database)
+	}
+	if cfg.Admin.Username != "root" || cfg.Admin.Password != "" {
+		t.Errorf("admin = %+v, want root without a plain password", cfg.Admin)
+	}
+	if !strings.HasPrefix(cfg.Admin.PasswordHash, "$2") || !controller.CheckPassword(testAdminPassword, cfg.Admin.PasswordHash) {
+		t.Errorf("admin password hash %q does not match the password", cfg.Admin.PasswordHash)
+	}
+	if raw, _ := os.ReadFile(path); strings.Contains(string(raw), testAdminPassword) {
+		t.Error("config file holds the plain admin password")
+	}
+}
+
+func TestInitSecretsDiffer(t *testing.T) {
+	a, err := initConfig(t.TempDir(), "admin", testAdminPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := initConfig(t.TempDir(), "admin", testAdminPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.JWTSecret == b.JWTSecret {
+		t.Error("two inits produced the same jwt secret")
+	}
+}
+
+func TestInitRefusesOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "controller.yaml")
+	if err := os.WriteFile(path, []byte("listen: 0.0.0.0:9394\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-data-dir", filepath.Join(dir, "data"), "-admin-password", testAdminPassword}
+
+	if code := cmdInit(path, args); code == 0 {
+		t.Fatal("init replaced an existing config without -force")
+	}
+	if raw, _ := os.ReadFile(path); string(raw) != "listen: 0.0.0.0:9394\n" {
+		t.Fatalf("refused init changed the config:\n%s", raw)
+	}
+	if code := cmdInit(path, append([]string{"-force"}, args...)); code != 0 {
+		t.Fatalf("init -force exited %d", code)
+	}
+	if cfg, err := config.LoadControllerConfig(path); err != nil || cfg.Admin.PasswordHash == "" {
+		t.Fatalf("forced init left %+v, %v", cfg, err)
+	}
+}
+
+func TestInitRejectsBadAdmin(t *testing.T) {
+	for _, tt := range []struct{ user, password string }{
+		{"admin", "short"},
+		{"admin", "alllowercaseletters"},
+		{"", testAdminPassword},
+	} {
+		if _, err := initConfig(t.TempDir(), tt.user, tt.password); err == nil {
+			t.Errorf("initConfig(%q, %q) accepted", tt.user, tt.password)
+		}
+	}
+}
+
+func TestPromptLine(t *testing.T) {
+	var out strings.Builder
+	got, err := promptLine(strings.NewReader("s3cret pass\r\nignored\n"), &out, "Password: ")
+	if err != nil || got != "s3cret pass" || out.String() != "Password: " {
+		t.Fatalf("promptLine = %q, %v, prompt %q", got, err, out.String())
+	}
+	if got, err := promptLine(strings.NewReader("no newline"), &out, ""); err != nil || got != "no newline" {
+		t.Fatalf("promptLine at EOF = %q, %v", got, err)
+	}
+	if _, err := promptLine(strings.NewReader(""), &out, ""); err == nil {
+		t.Fatal("empty input accepted")
+	}
+}
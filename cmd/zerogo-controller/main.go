@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/unicornultrafoundation/zerogo/internal/config"
 	"github.com/unicornultrafoundation/zerogo/internal/controller"
@@ -19,7 +23,8 @@ func main() {
 		listen      = flag.String("listen", "", "override listen address (e.g., 0.0.0.0:9394)")
 		database    = flag.String("database", "", "override database DSN")
 		jwtSecret   = flag.String("jwt-secret", "", "override JWT secret")
-		logLevel    = flag.String("log-level", "info", "log level: debug, info, warn, error")
+		logLevel    = flag.String("log-level", "", "override log level: debug, info, warn, error")
+		webUI       = flag.Bool("web-ui", false, "serve the embedded admin web UI at /")
 		showVersion = flag.Bool("version", false, "show version and exit")
 	)
 	flag.Parse()
@@ -29,32 +34,27 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Setup logging
-	var level slog.Level
-	switch strings.ToLower(*logLevel) {
-	case "debug":
-		level = slog.LevelDebug
-	case "warn":
-		level = slog.LevelWarn
-	case "error":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
+	// init writes the config, so it runs before one is loaded
+	if flag.Arg(0) == "init" {
+		os.Exit(cmdInit(*configPath, flag.Args()[1:]))
 	}
-	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
 
-	// Load config
+	// Load config: file (or defaults), then ZEROGO_* environment, then flags
 	var cfg *config.ControllerConfig
 	if *configPath != "" {
 		var err error
 		cfg, err = config.LoadControllerConfig(*configPath)
 		if err != nil {
-			log.Error("load config", "err", err)
+			fmt.Fprintf(os.Stderr, "zerogo-controller: %v\n", err)
 			os.Exit(1)
 		}
 	} else {
 		cfg = config.DefaultControllerConfig()
 	}
+	if err := config.ApplyEnv(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "zerogo-controller: %v\n", err)
+		os.Exit(1)
+	}
 
 	// Apply CLI overrides
 	if *listen != "" {
@@ -66,7 +66,31 @@ func main() {
 	if *jwtSecret != "" {
 		cfg.JWTSecret = *jwtSecret
 	}
-	cfg.LogLevel = *logLevel
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
+	if *webUI {
+		cfg.WebUI = true
+	}
+
+	// Setup logging
+	var level slog.Level
+	switch strings.ToLower(cfg.LogLevel) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	// Subcommands: backup <file>, restore [-force] <file> (init is above)
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(cfg, args, log))
+	}
 
 	// Create and run controller
 	ctrl, err := controller.New(cfg, log)
@@ -75,8 +99,56 @@ func main() {
 		os.Exit(1)
 	}
 
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigCh
+		log.Info("received signal, shutting down", "signal", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := ctrl.Shutdown(ctx); err != nil {
+			log.Warn("shutdown", "err", err)
+		}
+	}()
+
 	if err := ctrl.Run(); err != nil {
 		log.Error("controller stopped", "err", err)
 		os.Exit(1)
 	}
 }
+
+func runCommand(cfg *config.ControllerConfig, args []string, log *slog.Logger) int {
+	switch args[0] {
+	case "backup":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: zerogo-controller [flags] backup <file>")
+			return 2
+		}
+		if err := controller.BackupDatabase(cfg.Database, args[1]); err != nil {
+			log.Error("backup failed", "err", err)
+			return 1
+		}
+		log.Info("backup written", "file", args[1])
+		return 0
+
+	case "restore":
+		fs := flag.NewFlagSet("restore", flag.ExitOnError)
+		force := fs.Bool("force", false, "replace the contents of a non-empty database")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: zerogo-controller [flags] restore [-force] <file>")
+			return 2
+		}
+		if err := controller.RestoreDatabase(cfg.Database, fs.Arg(0), *force); err != nil {
+			log.Error("restore failed", "err", err)
+			return 1
+		}
+		log.Info("backup restored", "file", fs.Arg(0))
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s (expected init, backup or restore)\n", args[0])
+		return 2
+	}
+}
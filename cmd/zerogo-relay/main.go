@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -19,9 +20,12 @@ func main() {
 		listen      = flag.String("listen", "0.0.0.0:3478", "STUN/TURN listen address")
 		realm       = flag.String("realm", "zerogo", "TURN realm")
 		publicIP    = flag.String("public-ip", "", "public IP for TURN relay")
-		user        = flag.String("user", "zerogo", "TURN username")
+		user        = flag.String("user", "zerogo", "static TURN username (empty = ephemeral credentials only)")
 		password    = flag.String("password", "zerogo", "TURN password")
+		secret      = flag.String("secret", "", "shared secret for ephemeral credentials issued by the controller (turn.secret)")
 		logLevel    = flag.String("log-level", "info", "log level")
+		drain       = flag.Duration("drain-timeout", 0, "on shutdown, refuse new allocations and wait this long for active ones to end (0 = close at once)")
+		status      = flag.String("status-listen", "", "serve relay status JSON on this address, e.g. 127.0.0.1:3479 (empty = off)")
 		showVersion = flag.Bool("version", false, "show version and exit")
 	)
 	flag.Parse()
@@ -50,9 +54,17 @@ func main() {
 		ListenAddr:  *listen,
 		Realm:       *realm,
 		PublicIP:    *publicIP,
-		Credentials: map[string]string{
-			*user: *password,
-		},
+		Credentials: map[string]string{},
+
+		Secret:       *secret,
+		StatusListen: *status,
+	}
+	if *user != "" {
+		cfg.Credentials[*user] = *password
+	}
+	if len(cfg.Credentials) == 0 && cfg.Secret == "" {
+		log.Error("no TURN credentials: set -user or -secret")
+		os.Exit(1)
 	}
 
 	srv := relay.New(cfg, log)
@@ -65,6 +77,20 @@ func main() {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 
+	if *drain > 0 {
+		// A second signal stops waiting
+		ctx, cancel := context.WithTimeout(context.Background(), *drain)
+		go func() {
+			select {
+			case <-sigCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		srv.Drain(ctx)
+		cancel()
+	}
+
 	log.Info("shutting down relay server")
 	srv.Stop()
 }
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"net"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl2"
+)
+
+// applyRules installs the network's ACL rules from the controller. Invalid
+// rules are logged and skipped rather than rejecting the whole set.
+func (c *ControllerClient) applyRules(infos []protocol.RuleInfo) {
+	a := c.agent
+	if a.network == nil {
+		return
+	}
+
+	rules := make([]vl2.Rule, 0, len(infos))
+	for _, ri := range infos {
+		window, err := vl2.ParseTimeWindow(ri.Days, ri.StartTime, ri.EndTime, ri.Timezone)
+		if err == nil {
+			var rule vl2.Rule
+			rule, err = vl2.ParseRule(ri.ID, ri.Priority, ri.Action, ri.Src, ri.Dst, ri.Protocol, ri.PortRange, window)
+			if err == nil {
+				rules = append(rules, rule)
+				continue
+			}
+		}
+		c.log.Warn("skipping invalid ACL rule", "rule", ri.ID, "priority", ri.Priority, "action", ri.Action, "err", err)
+	}
+	a.network.ACL.SetRules(rules)
+}
+
+// applySourceValidation loads each peer's assigned MAC and IPs into the
+// network's spoof guard and turns validation on or off as configured.
+func (c *ControllerClient) applySourceValidation(msg *protocol.NetworkConfigMessage) {
+	a := c.agent
+	if a.network == nil {
+		return
+	}
+	peers := make(map[identity.Address]vl2.PeerAddresses, len(msg.Peers))
+	for _, p := range msg.Peers {
+		if addr, pa, ok := c.peerAddresses(p); ok {
+			peers[addr] = pa
+		}
+	}
+	a.network.Guard.SetPeers(peers)
+	a.network.Guard.SetEnabled(msg.SourceValidation)
+}
+
+// peerAddresses returns the source addresses a peer may send from. The MAC
+// falls back to the derived one for controllers that do not send it.
+func (c *ControllerClient) peerAddresses(info protocol.PeerInfo) (identity.Address, vl2.PeerAddresses, bool) {
+	ips, addr := peerRoutes(info)
+	if addr.IsZero() {
+		return addr, vl2.PeerAddresses{}, false
+	}
+	mac, err := net.ParseMAC(info.MAC)
+	if err != nil {
+		mac = vl2.GenerateMAC(c.agent.config.NetworkID, addr)
+	}
+	return addr, vl2.PeerAddresses{MAC: mac, IPs: ips}, true
+}
@@ -3,15 +3,20 @@ package agent
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"runtime"
 
 	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/sdnotify"
 	"github.com/unicornultrafoundation/zerogo/internal/tap"
 	"github.com/unicornultrafoundation/zerogo/internal/vl1"
 	"github.com/unicornultrafoundation/zerogo/internal/vl2"
@@ -21,14 +26,34 @@ import (
 type Agent struct {
 	config    Config
 	identity  *identity.Identity
-	transport *vl1.Transport
+	transport vl1.Transport
 	peers     *vl1.PeerManager
 	network   *vl2.Network
 	tapDev    tap.Device
 	ctrlCli   *ControllerClient
+	statusSrv *http.Server
+	portmap   atomic.Pointer[vl1.PortMapper] // replaced when the transport is rebound
 	log       *slog.Logger
 	localIPv4 [4]byte    // our assigned IPv4, used to detect TUN bounce-back
 	localNet  *net.IPNet // VPN subnet, used to distinguish bounce-back from forwarded traffic
+	probes    sync.Map   // identity.Address → *endpointProbe while selecting an endpoint
+	pinger    vl1.Pinger // overlay ping/pong for diagnostics
+	peerPSKs  sync.Map   // pairKey → [32]byte pair PSK overriding the network PSK
+
+	networkPSKs sync.Map // uint32 network ID → [32]byte PSK of each network joined
+
+	// addrMu guards the config fields the controller assigns at run time
+	// (NetworkID, TAPIPv4, TAPIPv6) against the status endpoint reading them
+	addrMu sync.RWMutex
+
+	peerFilter *peerFilter // public keys allowed to connect
+	drops      *dropLogger // rate-limited logging of dropped packets
+	members    networkMembers
+
+	pathProbes sync.Map // "ip:port" → *vl1.Peer while probing a path to it
+
+	// statusKick asks the maintenance loop to report status now
+	statusKick chan struct{}
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -43,49 +68,79 @@ func New(cfg Config, log *slog.Logger) (*Agent, error) {
 	}
 	log.Info("identity loaded", "address", id.Address, "pubkey", id.PublicKeyHex()[:16]+"...")
 
+	timers := vl1.Timers{
+		KeepaliveInterval:      cfg.KeepaliveInterval,
+		PeerTimeout:            cfg.PeerTimeout,
+		HandshakeRetryInterval: cfg.HandshakeRetryInterval,
+	}
+	if err := timers.Validate(); err != nil {
+		return nil, err
+	}
+	filter, err := newPeerFilter(cfg.AllowPeers, cfg.DenyPeers)
+	if err != nil {
+		return nil, err
+	}
+	peers := vl1.NewPeerManager(log)
+	peers.SetTimers(timers)
+	peers.SetMaxPeers(cfg.MaxPeers)
+
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Agent{
-		config:   cfg,
-		identity: id,
-		peers:    vl1.NewPeerManager(log),
-		log:      log,
-		ctx:      ctx,
-		cancel:   cancel,
-	}, nil
+	a := &Agent{
+		config:     cfg,
+		identity:   id,
+		peers:      peers,
+		log:        log,
+		peerFilter: filter,
+		drops:      newDropLogger(log),
+		statusKick: make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	if cfg.ControllerURL == "" {
+		// Without a controller the configured network is the only one
+		a.networkPSKs.Store(cfg.NetworkID, cfg.PSK)
+	}
+	peers.Subscribe(a.onPeerEvent)
+	return a, nil
+}
+
+// onPeerEvent reports peers connecting, disconnecting or dying to the
+// controller right away instead of at the next maintenance tick. Bursts of
+// changes coalesce into one report.
+func (a *Agent) onPeerEvent(ev vl1.PeerEvent) {
+	if !ev.Connected() && !ev.Disconnected() && ev.To != vl1.PeerStateDead {
+		return
+	}
+	select {
+	case a.statusKick <- struct{}{}:
+	default: // a report is already pending
+	}
 }
 
 // Start initializes all subsystems and begins processing.
 func (a *Agent) Start() error {
-	// 1. Start VL1 UDP transport
-	transport, err := vl1.NewTransport(a.config.ListenPort, a.log)
+	// Check the controller certificates before binding anything
+	ctrlTLS, err := a.controllerTLSConfig()
 	if err != nil {
-		return fmt.Errorf("start transport: %w", err)
+		return fmt.Errorf("controller tls: %w", err)
 	}
-	a.transport = transport
 
-	// Protect UDP socket from VPN routing (Android)
-	if a.config.SocketProtect != nil {
-		transport.SocketProtect = a.config.SocketProtect
-		if err := transport.ProtectSocket(); err != nil {
-			a.log.Warn("protect socket failed", "err", err)
-		}
+	// 1. Start VL1 UDP transport, unless one was injected
+	if a.config.Transport != nil {
+		a.transport = a.config.Transport
+	} else if err := a.startUDPTransport(); err != nil {
+		return err
 	}
 
-	// Apply socket buffer tuning
-	if a.config.RcvBuf > 0 || a.config.SndBuf > 0 {
-		if err := transport.SetSocketBuffers(a.config.RcvBuf, a.config.SndBuf); err != nil {
-			a.log.Warn("set socket buffers failed", "err", err)
-		} else {
-			a.log.Info("socket buffers configured", "rcvbuf", a.config.RcvBuf, "sndbuf", a.config.SndBuf)
-		}
+	// Ask the gateway to forward our UDP port (UPnP/NAT-PMP)
+	if a.config.PortMap {
+		a.startPortMap(a.transport.Port())
 	}
 
-	// Apply DSCP marking
-	if a.config.DSCP > 0 {
-		if err := transport.SetDSCP(a.config.DSCP); err != nil {
-			a.log.Warn("set DSCP failed", "err", err)
-		} else {
-			a.log.Info("DSCP marking configured", "dscp", a.config.DSCP)
+	// Local status endpoint for zerogo-cli
+	if a.config.StatusListen != "" {
+		if err := a.startStatusServer(); err != nil {
+			a.log.Warn("start status endpoint failed", "err", err)
 		}
 	}
 
@@ -100,11 +155,13 @@ func (a *Agent) Start() error {
 	// Controller mode: connect to controller, TAP will be created on NetworkConfig
 	if a.config.ControllerURL != "" {
 		a.ctrlCli = NewControllerClient(a.config.ControllerURL, a, a.log)
+		a.ctrlCli.tlsConfig = ctrlTLS
 
 		// Start goroutines (no TAP read loop yet, will start on network config)
-		a.wg.Add(2)
+		a.wg.Add(3)
 		go a.udpReadLoop()
 		go a.maintenanceLoop()
+		go a.pathQualityLoop()
 
 		// Start controller connection in background
 		a.wg.Add(1)
@@ -129,23 +186,30 @@ func (a *Agent) Start() error {
 	case "android":
 		tapDev, err = tap.NewTUNFromFD(a.config.TUNFD, a.config.TAPName)
 	default:
-		tapDev, err = tap.NewTAP(a.config.TAPName)
+		if a.config.TUNMode {
+			tapDev, err = tap.NewTUN(a.config.TAPName)
+		} else if a.config.TAPQueues > 1 || a.config.PersistentTAP {
+			tapDev, err = tap.NewTAPWithOptions(a.config.TAPName, a.tapOptions())
+		} else {
+			tapDev, err = tap.NewTAP(a.config.TAPName)
+		}
 	}
 	if err != nil {
 		a.transport.Close()
-		return fmt.Errorf("create network device: %w", err)
+		return deviceError(a.config.TAPName, err)
 	}
 	a.tapDev = tapDev
 	a.log.Info("network device created", "name", tapDev.Name(), "tun", tapDev.IsTUN())
+	if a.config.TUNMode {
+		a.log.Warn("TUN mode routes by controller-assigned member IPs; static peers are unreachable")
+	}
 
 	// 3. Configure TAP: set MTU, MAC, IP
-	mtu := a.config.TAPMTU
-	if mtu == 0 {
-		mtu = 2800
-	}
+	mtu := a.deviceMTU(a.config.TAPMTU)
 	if err := tapDev.SetMTU(mtu); err != nil {
 		a.log.Warn("set TAP MTU failed", "err", err)
 	}
+	a.tuneTAPQueue()
 
 	// 4. Create VL2 network with virtual switch
 	netConfig := vl2.NetworkConfig{
@@ -155,6 +219,7 @@ func (a *Agent) Start() error {
 		Multicast: true,
 	}
 	a.network = vl2.NewNetwork(netConfig, a.identity.Address, a, a.log)
+	a.startCaptureFile()
 
 	// Set MAC address on TAP
 	mac := vl2.GenerateMAC(a.config.NetworkID, a.identity.Address)
@@ -193,6 +258,12 @@ func (a *Agent) Start() error {
 		a.log.Warn("bring TAP up failed", "err", err)
 	}
 
+	if a.config.DHCPRange != "" {
+		if err := a.enableDHCP(a.config.TAPIPv4, ""); err != nil {
+			a.log.Error("enable DHCP", "err", err)
+		}
+	}
+
 	// 5. Add static peers and initiate handshakes
 	for _, sp := range a.config.StaticPeers {
 		endpoint, err := net.ResolveUDPAddr("udp", sp.Address)
@@ -201,26 +272,31 @@ func (a *Agent) Start() error {
 			continue
 		}
 		pubKeyBytes, err := hex.DecodeString(sp.PublicKey)
+		if err == nil {
+			err = identity.ValidatePublicKey(pubKeyBytes)
+		}
 		if err != nil {
-			a.log.Error("decode peer public key", "err", err)
+			a.log.Error("invalid peer public key", "key", sp.PublicKey, "err", err)
 			continue
 		}
 		var pubKey [32]byte
 		copy(pubKey[:], pubKeyBytes)
 		peerAddr := identity.AddressFromPublicKey(pubKey[:])
+		if ok, reason := a.peerFilter.permits(pubKey); !ok {
+			a.log.Warn("static peer not permitted, skipping", "peer", peerAddr, "reason", reason)
+			continue
+		}
 
 		peer := a.peers.AddPeer(peerAddr, pubKey, endpoint)
-		if a.config.Gaming {
-			peer.KeepaliveInterval = vl1.GamingKeepaliveInterval
-		}
 		a.initiateHandshake(peer)
 	}
 
 	// 6. Start goroutines
+	a.startTAPReaders()
 	a.wg.Add(3)
-	go a.tapReadLoop()
 	go a.udpReadLoop()
 	go a.maintenanceLoop()
+	go a.pathQualityLoop()
 
 	a.log.Info("agent started",
 		"address", a.identity.Address,
@@ -228,17 +304,65 @@ func (a *Agent) Start() error {
 		"tap", tapDev.Name(),
 		"peers", len(a.config.StaticPeers),
 	)
+	a.notifySystemd(sdnotify.Ready)
 	return nil
 }
 
+// startUDPTransport binds the VL1 UDP socket and applies the socket options
+// from the config.
+func (a *Agent) startUDPTransport() error {
+	transport, err := vl1.NewUDPTransport(a.config.ListenPort, a.log)
+	if err != nil {
+		return fmt.Errorf("start transport: %w", err)
+	}
+	a.transport = transport
+
+	// Protect UDP socket from VPN routing (Android)
+	if a.config.SocketProtect != nil {
+		transport.SocketProtect = a.config.SocketProtect
+		if err := transport.ProtectSocket(); err != nil {
+			a.log.Warn("protect socket failed", "err", err)
+		}
+	}
+
+	// Apply socket buffer tuning
+	if a.config.RcvBuf > 0 || a.config.SndBuf > 0 {
+		if err := transport.SetSocketBuffers(a.config.RcvBuf, a.config.SndBuf); err != nil {
+			a.log.Warn("set socket buffers failed", "err", err)
+		} else {
+			a.log.Info("socket buffers configured", "rcvbuf", a.config.RcvBuf, "sndbuf", a.config.SndBuf)
+		}
+	}
+
+	// Apply DSCP marking
+	if a.config.DSCP > 0 {
+		if err := transport.SetDSCP(a.config.DSCP); err != nil {
+			a.log.Warn("set DSCP failed", "err", err)
+		} else {
+			a.log.Info("DSCP marking configured", "dscp", a.config.DSCP)
+		}
+	}
+	return nil
+}
+
+// notifySystemd reports a state change to systemd when running under a
+// Type=notify unit.
+func (a *Agent) notifySystemd(state string) {
+	if _, err := sdnotify.Notify(state); err != nil {
+		a.log.Debug("systemd notify failed", "state", state, "err", err)
+	}
+}
+
 // Stop gracefully shuts down the agent.
 func (a *Agent) Stop() {
 	a.log.Info("agent stopping...")
+	a.notifySystemd(sdnotify.Stopping)
 	a.cancel()
 
-	// Clean managed routes before closing the device
+	// Clean managed routes and DNS before closing the device
 	if a.ctrlCli != nil {
 		a.ctrlCli.cleanupRoutes()
+		a.ctrlCli.cleanupDNS()
 	}
 
 	// Close TAP/TUN first to unblock tapReadLoop
@@ -251,9 +375,15 @@ func (a *Agent) Stop() {
 			peer.CloseICE()
 		}
 	}
+	if pm := a.portmap.Swap(nil); pm != nil {
+		pm.Close()
+	}
 	if a.transport != nil {
 		a.transport.Close()
 	}
+	if a.statusSrv != nil {
+		a.statusSrv.Close()
+	}
 
 	// Wait for goroutines with timeout
 	done := make(chan struct{})
@@ -309,6 +439,12 @@ func (a *Agent) injectFrame(frame []byte) {
 		return
 	}
 
+	if a.network.DHCP != nil {
+		if parsed, err := vl2.ParseEthernetFrame(frame); err == nil && a.serveDHCP(parsed, true) {
+			return
+		}
+	}
+
 	if a.tapDev.IsTUN() {
 		parsed, err := vl2.ParseEthernetFrame(frame)
 		if err == nil && parsed.IsARP() {
@@ -320,6 +456,15 @@ func (a *Agent) injectFrame(frame []byte) {
 			}
 			return // Don't inject ARP into TUN
 		}
+		if err == nil && parsed.IsNDP() {
+			// Likewise answer neighbor solicitations for our IPv6 address
+			if reply := a.network.NDP.HandleNDP(parsed); reply != nil {
+				if err := a.network.Switch.HandleLocalFrame(reply); err != nil {
+					a.log.Debug("NDP reply via switch", "err", err)
+				}
+			}
+			return
+		}
 	}
 
 	if _, err := a.tapDev.Write(frame); err != nil {
@@ -329,8 +474,43 @@ func (a *Agent) injectFrame(frame []byte) {
 
 // --- Goroutine loops ---
 
-// tapReadLoop reads Ethernet frames from the TAP device and forwards via VL2 switch.
-func (a *Agent) tapReadLoop() {
+// tapOptions returns the TAP device options from the config.
+func (a *Agent) tapOptions() tap.Options {
+	return tap.Options{Queues: a.config.TAPQueues, Persistent: a.config.PersistentTAP}
+}
+
+// tuneTAPQueue applies the configured transmit queue length.
+func (a *Agent) tuneTAPQueue() {
+	if a.config.TxQueueLen <= 0 {
+		return
+	}
+	qd, ok := a.tapDev.(tap.QueueDevice)
+	if !ok {
+		a.log.Warn("TAP device does not support txqueuelen", "tap", a.tapDev.Name())
+		return
+	}
+	if err := qd.SetTxQueueLen(a.config.TxQueueLen); err != nil {
+		a.log.Warn("set TAP txqueuelen failed", "err", err)
+	}
+}
+
+// startTAPReaders starts a tapReadLoop per device queue.
+func (a *Agent) startTAPReaders() {
+	if qd, ok := a.tapDev.(tap.QueueDevice); ok && qd.NumQueues() > 1 {
+		for q := 0; q < qd.NumQueues(); q++ {
+			a.wg.Add(1)
+			go a.tapReadLoop(func(buf []byte) (int, error) { return qd.ReadQueue(q, buf) })
+		}
+		a.log.Info("TAP multiqueue enabled", "queues", qd.NumQueues())
+		return
+	}
+	a.wg.Add(1)
+	go a.tapReadLoop(a.tapDev.Read)
+}
+
+// tapReadLoop reads Ethernet frames from the TAP device (or one of its
+// queues) and forwards via VL2 switch.
+func (a *Agent) tapReadLoop(read func(buf []byte) (int, error)) {
 	defer a.wg.Done()
 	buf := make([]byte, vl2.MaxFrameSize)
 	for {
@@ -339,7 +519,7 @@ func (a *Agent) tapReadLoop() {
 			return
 		default:
 		}
-		n, err := a.tapDev.Read(buf)
+		n, err := read(buf)
 		if err != nil {
 			if a.ctx.Err() != nil {
 				return
@@ -358,6 +538,13 @@ func (a *Agent) tapReadLoop() {
 		if err != nil {
 			continue
 		}
+		if a.config.TUNMode {
+			// L3: route by destination IP, no MAC learning or ARP
+			if err := a.network.Router.HandleLocalPacket(buf[:n]); err != nil {
+				a.log.Debug("router handle local packet", "err", err)
+			}
+			continue
+		}
 		if frame.IsARP() {
 			// Extract peer IP→MAC from the ARP frame so we can proactively
 			// populate the kernel ARP table below.
@@ -375,6 +562,15 @@ func (a *Agent) tapReadLoop() {
 				_ = a.tapDev.SetPeerARP(peerIP, peerMAC)
 			}
 		}
+		if frame.IsNDP() {
+			if reply := a.network.NDP.HandleNDP(frame); reply != nil {
+				a.tapDev.Write(reply)
+				continue
+			}
+		}
+		if a.serveDHCP(frame, false) {
+			continue
+		}
 
 		if a.tapDev.IsTUN() {
 			// Drop kernel bounce-back packets: when we inject a remote peer's packet
@@ -412,6 +608,11 @@ func (a *Agent) tapReadLoop() {
 							copy(buf[0:6], mac)
 						}
 					}
+				} else if frame.EtherType == vl2.EtherTypeIPv6 && n >= vl2.EthernetHeaderSize+vl2.IPv6HeaderSize {
+					dstIP := net.IP(buf[38:54]) // IPv6 dst at offset 24 in IP header + 14 Ethernet
+					if mac := a.network.NDP.Lookup(dstIP); mac != nil {
+						copy(buf[0:6], mac)
+					}
 				}
 			}
 		}
@@ -460,13 +661,17 @@ func (a *Agent) udpReadLoop() {
 func (a *Agent) handleUDPPacket(data []byte, from *net.UDPAddr) {
 	var pkt vl1.Packet
 	if err := vl1.DecodePacketInto(&pkt, data); err != nil {
-		a.log.Debug("decode packet", "err", err, "from", from)
+		if errors.Is(err, vl1.ErrVersion) {
+			a.drops.Drop(dropVersion, from.IP.String(), "packet from another protocol version", "err", err, "from", from)
+			return
+		}
+		a.drops.Drop(dropDecode, from.IP.String(), "decode packet", "err", err, "from", from)
 		return
 	}
 
 	switch pkt.Header.Type {
 	case vl1.PacketTypeHandshake:
-		a.handleHandshake(pkt.Payload, from)
+		a.handleHandshake(pkt.Header.NetworkID, pkt.Payload, from)
 
 	case vl1.PacketTypeData:
 		a.handleDataPacket(&pkt, from)
@@ -477,72 +682,261 @@ func (a *Agent) handleUDPPacket(data []byte, from *net.UDPAddr) {
 			peer.Touch()
 		}
 
+	case vl1.PacketTypeControl:
+		peer := a.peers.GetPeerByEndpoint(from)
+		if peer == nil {
+			// A pong to a probe of one of the peer's other endpoints
+			if v, ok := a.pathProbes.Load(from.String()); ok {
+				peer = v.(*vl1.Peer)
+			}
+		}
+		if peer != nil {
+			a.handleControlPacket(&pkt, peer, from)
+		}
+
 	default:
-		a.log.Debug("unknown packet type", "type", pkt.Header.Type, "from", from)
+		a.drops.Drop(dropUnknownType, from.IP.String(), "unknown packet type", "type", pkt.Header.Type, "from", from)
 	}
 }
 
-// handleHandshake processes a handshake/hello message from a peer.
-func (a *Agent) handleHandshake(payload []byte, from *net.UDPAddr) {
-	// Hello format: [pubkey(32 bytes)]
-	if len(payload) < 32 {
-		a.log.Debug("handshake too short", "len", len(payload), "from", from)
+// handleHandshake dispatches a handshake packet for networkID by its type.
+func (a *Agent) handleHandshake(networkID uint32, payload []byte, from *net.UDPAddr) {
+	typ, err := vl1.ParseHandshakeType(payload)
+	if err != nil {
+		a.drops.Drop(handshakeDropReason(err), from.IP.String(), "malformed handshake", "from", from, "len", len(payload), "err", err)
 		return
 	}
+	switch typ {
+	case vl1.HandshakeHello:
+		a.handleHello(networkID, payload, from)
+	case vl1.HandshakeInit, vl1.HandshakeResponse, vl1.HandshakeCookie:
+		// Sessions are keyed from the PSK hello; the Noise messages have
+		// their types reserved but no handler yet.
+		a.drops.Drop(dropUnsupportedHandshake, from.IP.String(), "unsupported handshake type", "type", typ, "from", from)
+	}
+}
 
-	var remotePubKey [32]byte
-	copy(remotePubKey[:], payload[:32])
+// handshakeDropReason tells a handshake of a type we do not speak, e.g.
+// from a newer version, from a malformed one.
+func handshakeDropReason(err error) string {
+	if errors.Is(err, vl1.ErrUnknownHandshake) {
+		return dropUnsupportedHandshake
+	}
+	return dropBadHandshake
+}
+
+// handleHello processes a hello from a peer, which ParseHandshakeType has
+// checked, keying the session for networkID from its header.
+func (a *Agent) handleHello(networkID uint32, payload []byte, from *net.UDPAddr) {
+	remotePubKey, flags, nonce, sent := vl1.ParseHello(payload)
+	if err := identity.ValidatePublicKey(remotePubKey[:]); err != nil {
+		a.drops.Drop(dropInvalidKey, from.IP.String(), "hello with invalid public key", "from", from, "err", err)
+		return
+	}
 
 	remoteAddr := identity.AddressFromPublicKey(remotePubKey[:])
+	if ok, reason := a.peerFilter.permits(remotePubKey); !ok {
+		a.drops.Drop(dropRefusedPeer, from.IP.String(), "hello from refused peer", "peer", remoteAddr, "from", from, "reason", reason)
+		return
+	}
+	if err := vl1.CheckClockSkew(sent, a.clock(), vl1.DefaultMaxClockSkew); err != nil {
+		a.drops.Warn(dropClockSkew, from.IP.String(), "hello rejected", "peer", remoteAddr, "from", from, "err", err)
+		return
+	}
+	psk, ok := a.peerPSK(networkID, remoteAddr)
+	if !ok {
+		a.drops.Drop(dropWrongNetwork, from.IP.String(), "hello for a network we are not in", "peer", remoteAddr, "network", networkID, "from", from)
+		return
+	}
 
 	// Find existing peer
 	peer := a.peers.GetPeer(remoteAddr)
 	if peer != nil {
-		// Update endpoint and touch — keys are already derived
+		// While probing, only the first candidate to answer becomes the endpoint
+		if !a.acceptProbeReply(peer, from) {
+			peer.Touch()
+			return
+		}
+
+		// Hellos are not authenticated, so one never moves an established
+		// session: the endpoint changes once the peer proves itself there,
+		// by answering a ping (checkHelloPath) or sending data (Roam).
+		if peer.IsConnected() && (peer.Endpoint == nil || peer.Endpoint.String() != from.String()) {
+			if a.keySession(peer, networkID, psk, nonce) {
+				a.log.Info("peer connected via PSK handshake", "peer", peer.Address, "network", networkID, "endpoint", peer.Endpoint)
+			}
+			a.checkHelloPath(peer, networkID, from, flags&vl1.HelloFlagAwaitingReply != 0)
+			return
+		}
+
+		// Migrate endpoint and touch
+		moved := peer.Endpoint == nil || peer.Endpoint.String() != from.String()
 		a.peers.UpdatePeerEndpoint(remoteAddr, from)
+		peer.EndpointSucceeded(from)
 		peer.Touch()
 
-		// If not yet connected, derive keys now
-		if !peer.IsConnected() {
-			sendKey, recvKey := vl1.DeriveKeysFromPSK(a.config.PSK, a.identity.PublicKey, remotePubKey)
-			cipher := vl1.NewNoiseCipher(sendKey, recvKey)
-			peer.SetCipher(cipher)
-			a.log.Info("peer connected via PSK handshake", "peer", peer.Address, "endpoint", from)
+		// Answer on a newly working path so a probing peer stops trying
+		// its other candidates, and answer a peer still waiting for one.
+		// Deferred so the reply reflects the handshake completed below.
+		if moved || flags&vl1.HelloFlagAwaitingReply != 0 {
+			defer a.replyHello(peer)
+		}
+
+		// Key a session in this network if there is none yet, or prepare
+		// a new one if the peer has restarted
+		if a.keySession(peer, networkID, psk, nonce) {
+			a.log.Info("peer connected via PSK handshake", "peer", peer.Address, "network", networkID, "endpoint", from)
 		}
 		return
 	}
 
 	// Unknown peer sending hello — create and connect
-	peer = a.peers.AddPeer(remoteAddr, remotePubKey, from)
-	if a.config.Gaming {
-		peer.KeepaliveInterval = vl1.GamingKeepaliveInterval
+	peer = a.peers.AddDiscoveredPeer(remoteAddr, remotePubKey, from)
+	if peer == nil {
+		return // peer limit reached
 	}
-	sendKey, recvKey := vl1.DeriveKeysFromPSK(a.config.PSK, a.identity.PublicKey, remotePubKey)
-	cipher := vl1.NewNoiseCipher(sendKey, recvKey)
-	peer.SetCipher(cipher)
-	a.log.Info("new peer connected via PSK handshake", "peer", peer.Address, "endpoint", from)
+	peer.EndpointSucceeded(from)
+	a.keySession(peer, networkID, psk, nonce)
+	a.log.Info("new peer connected via PSK handshake", "peer", peer.Address, "network", networkID, "endpoint", from)
 
 	// Send hello back so the remote side learns our endpoint
+	a.replyHello(peer)
+}
+
+// keySession keys the session with peer in networkID from its hello (see
+// vl1.Peer.KeySession) and reports whether the peer became connected there.
+// A new session is confirmed with a ping, so a peer still holding a previous
+// one switches to it without waiting for data.
+func (a *Agent) keySession(peer *vl1.Peer, networkID uint32, psk [32]byte, nonce vl1.SessionNonce) bool {
+	if !peer.KeySession(networkID, psk, a.identity.PublicKey, nonce) {
+		return false
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ctx, cancel := context.WithTimeout(a.ctx, pathProbeTimeout)
+		defer cancel()
+		_, err := a.pinger.Ping(ctx, peer.Address, func(payload []byte) error {
+			return a.sendControlIn(peer, networkID, payload, nil)
+		})
+		if err != nil {
+			a.log.Debug("new session not confirmed", "peer", peer.Address, "network", networkID, "err", err)
+		}
+	}()
+	return true
+}
+
+// replyHello answers a peer's hello, at most once per vl1.HelloReplyInterval.
+// Hellos on an unchanged path of a known peer get no reply unless the sender
+// is still handshaking, so only path changes, first contact and handshake
+// retries cost a packet.
+func (a *Agent) replyHello(peer *vl1.Peer) {
+	if !peer.AllowHelloReply() {
+		a.log.Debug("hello reply suppressed", "peer", peer.Address)
+		return
+	}
 	a.sendHello(peer)
 }
 
-// handleDataPacket processes an encrypted data packet.
-func (a *Agent) handleDataPacket(pkt *vl1.Packet, from *net.UDPAddr) {
-	peer := a.peers.GetPeerByEndpoint(from)
-	if peer == nil {
-		a.log.Debug("data from unknown peer", "from", from)
+// checkHelloPath handles a hello that claims to come from a connected peer
+// but arrived from an address other than its endpoint: the peer may have
+// moved or restarted, or someone may be spoofing it. A peer waiting for a
+// reply gets one at that address, so a restarted peer can reconnect, and the
+// address is pinged over the session in networkID; the endpoint moves there
+// only if the peer answers. Both are limited to one per
+// vl1.HelloReplyInterval. A restarted peer cannot answer over the old
+// session, so if the hello prepared a new one the ping is repeated once the
+// peer has switched to it.
+func (a *Agent) checkHelloPath(peer *vl1.Peer, networkID uint32, ep *net.UDPAddr, awaitingReply bool) {
+	if !peer.AllowHelloReply() {
+		a.log.Debug("hello from new endpoint ignored", "peer", peer.Address, "endpoint", ep)
 		return
 	}
-	peer.Touch()
+	if awaitingReply {
+		for _, hello := range a.helloPackets(peer) {
+			if err := a.transport.SendTo(hello, ep); err != nil {
+				a.log.Debug("hello reply failed", "peer", peer.Address, "endpoint", ep, "err", err)
+			}
+		}
+	}
+	if _, busy := a.pathProbes.LoadOrStore(ep.String(), peer); busy {
+		return
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer a.pathProbes.Delete(ep.String())
+		probe := func() error {
+			ctx, cancel := context.WithTimeout(a.ctx, pathProbeTimeout)
+			defer cancel()
+			_, err := a.pinger.Ping(ctx, peer.Address, func(payload []byte) error {
+				return a.sendControlIn(peer, networkID, payload, ep)
+			})
+			return err
+		}
 
+		rekeying := peer.Rekeying(networkID)
+		err := probe()
+		if err != nil && rekeying && !peer.Rekeying(networkID) {
+			err = probe()
+		}
+		if err != nil {
+			a.log.Debug("new endpoint of peer did not answer", "peer", peer.Address, "endpoint", ep, "err", err)
+			return
+		}
+		if !peer.IsConnected() {
+			return
+		}
+		old := peer.Endpoint
+		a.peers.UpdatePeerEndpoint(peer.Address, ep)
+		peer.EndpointSucceeded(ep)
+		a.log.Info("peer moved to verified endpoint", "peer", peer.Address, "old", old, "new", ep)
+	}()
+}
+
+// handleDataPacket processes an encrypted data packet.
+func (a *Agent) handleDataPacket(pkt *vl1.Packet, from *net.UDPAddr) {
 	// Decrypt payload into a pool buffer
 	bufp := vl1.GetPacketBuf()
 	defer vl1.PutPacketBuf(bufp)
-	plaintext, err := peer.DecryptTo(*bufp, pkt.Payload)
-	if err != nil {
-		a.log.Debug("decrypt failed", "peer", peer.Address, "err", err, "payload_len", len(pkt.Payload))
-		return
+
+	// The header is authenticated as associated data, so a tampered
+	// version, type or network ID fails decryption.
+	ad := pkt.Header.Bytes()
+	var plaintext []byte
+	peer := a.peers.GetPeerByEndpoint(from)
+	if peer == nil {
+		// The sender may be a known peer whose NAT mapping changed. Only roam
+		// if the packet authenticates under one of our peers' ciphers.
+		if a.network != nil && pkt.Header.NetworkID != a.network.Config.ID {
+			a.drops.Drop(dropWrongNetwork, from.IP.String(), "data for unknown network", "network", pkt.Header.NetworkID, "from", from)
+			return
+		}
+		var err error
+		peer, plaintext, err = a.peers.Roam(from, pkt.Header.NetworkID, *bufp, pkt.Payload, ad[:])
+		if errors.Is(err, vl1.ErrRoamLimited) {
+			a.drops.Drop(dropRoamLimited, from.IP.String(), "data from unknown endpoint rate limited", "from", from)
+			return
+		}
+		if peer == nil {
+			a.drops.Drop(dropUnknownPeer, from.IP.String(), "data from unknown peer", "from", from)
+			return
+		}
+	} else {
+		var err error
+		plaintext, err = peer.DecryptTo(pkt.Header.NetworkID, *bufp, pkt.Payload, ad[:])
+		if errors.Is(err, vl1.ErrReplay) {
+			a.drops.Drop(dropReplay, peer.Address.String(), "replayed packet", "peer", peer.Address, "from", from)
+			return
+		}
+		if err != nil {
+			a.drops.Drop(dropDecrypt, peer.Address.String(), "decrypt failed", "peer", peer.Address, "err", err, "payload_len", len(pkt.Payload))
+			return
+		}
 	}
+	peer.Touch()
+	peer.CountRecv(len(plaintext))
 
 	if a.log.Enabled(a.ctx, slog.LevelDebug) {
 		a.log.Debug("received encrypted frame", "peer", peer.Address, "frame_len", len(plaintext))
@@ -550,14 +944,14 @@ func (a *Agent) handleDataPacket(pkt *vl1.Packet, from *net.UDPAddr) {
 
 	// Check if network is ready
 	if a.network == nil {
-		a.log.Debug("network not ready, dropping frame")
+		a.drops.Drop(dropNoNetwork, peer.Address.String(), "network not ready, dropping frame", "peer", peer.Address)
 		return
 	}
 
 	// Process through VL2 switch
-	frameToInject, err := a.network.Switch.HandleRemoteFrame(peer.Address, plaintext)
+	frameToInject, err := a.handleRemoteFrame(peer.Address, plaintext)
 	if err != nil {
-		a.log.Debug("switch handle remote frame", "err", err)
+		a.drops.Drop(dropSwitchRefused, peer.Address.String(), "switch handle remote frame", "peer", peer.Address, "err", err)
 		return
 	}
 
@@ -580,20 +974,79 @@ func (a *Agent) handleDataPacket(pkt *vl1.Packet, from *net.UDPAddr) {
 	}
 }
 
-// sendHello sends a hello handshake packet carrying our public key.
+// handleRemoteFrame passes a frame from a peer to the switch, or to the router
+// in TUN mode, and returns the frame to inject locally, if any.
+func (a *Agent) handleRemoteFrame(peerAddr identity.Address, frame []byte) ([]byte, error) {
+	if a.config.TUNMode {
+		return a.network.Router.HandleRemotePacket(peerAddr, frame)
+	}
+	return a.network.Switch.HandleRemoteFrame(peerAddr, frame)
+}
+
+// helloNetworks returns the networks to say hello to the peer at addr in:
+// those we hold a PSK for and it is a member of.
+func (a *Agent) helloNetworks(addr identity.Address) []uint32 {
+	var ids []uint32
+	a.networkPSKs.Range(func(k, _ any) bool {
+		if id := k.(uint32); a.inNetwork(id, addr) {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	slices.Sort(ids)
+	return ids
+}
+
+// helloPacket returns an encoded hello for peer in networkID: our public
+// key, flagged as awaiting a reply until the peer has a session there.
+func (a *Agent) helloPacket(peer *vl1.Peer, networkID uint32) []byte {
+	var flags byte
+	if !peer.HasSession(networkID) {
+		flags = vl1.HelloFlagAwaitingReply
+	}
+	return vl1.NewHandshakePacket(networkID, vl1.NewHelloPayload(a.identity.PublicKey, flags, peer.HelloNonce(networkID), a.clock())).Encode()
+}
+
+// clock returns the time to stamp and judge hellos by: the controller's
+// clock when we have one, so nodes without a real-time clock still agree
+// with their peers.
+func (a *Agent) clock() time.Time {
+	now := time.Now()
+	if a.ctrlCli != nil {
+		now = now.Add(a.ctrlCli.ClockOffset())
+	}
+	return now
+}
+
+// helloPackets returns a hello for peer in each network we share with it.
+func (a *Agent) helloPackets(peer *vl1.Peer) [][]byte {
+	networks := a.helloNetworks(peer.Address)
+	hellos := make([][]byte, 0, len(networks))
+	for _, id := range networks {
+		hellos = append(hellos, a.helloPacket(peer, id))
+	}
+	return hellos
+}
+
+// sendHello sends the peer a hello handshake packet carrying our public key
+// for each network we share with it.
 func (a *Agent) sendHello(peer *vl1.Peer) {
-	// Hello payload: our public key (32 bytes)
-	pkt := vl1.NewHandshakePacket(a.identity.PublicKey[:])
-	encoded := pkt.Encode()
-
-	// Prefer ICE connection if available
-	if iceConn := peer.ICEConn(); iceConn != nil {
-		if _, err := iceConn.Write(encoded); err != nil {
-			a.log.Debug("send hello via ICE failed", "peer", peer.Address, "err", err)
-			return
+	hellos := a.helloPackets(peer)
+	if len(hellos) == 0 {
+		a.log.Debug("send hello skipped: no shared network", "peer", peer.Address)
+		return
+	}
+
+	// Prefer ICE or relay connection if available
+	if conn := peer.TunnelConn(); conn != nil {
+		for _, hello := range hellos {
+			if _, err := conn.Write(hello); err != nil {
+				a.log.Debug("send hello via tunnel failed", "peer", peer.Address, "err", err)
+				return
+			}
 		}
-		peer.LastSend = time.Now()
-		a.log.Info("hello sent via ICE", "peer", peer.Address)
+		peer.MarkSent()
+		a.log.Info("hello sent via tunnel", "peer", peer.Address, "relay", peer.HasRelay())
 		return
 	}
 
@@ -602,58 +1055,129 @@ func (a *Agent) sendHello(peer *vl1.Peer) {
 		return
 	}
 
-	if err := a.transport.SendTo(encoded, peer.Endpoint); err != nil {
-		a.log.Debug("send hello failed", "peer", peer.Address, "err", err)
-		return
+	for _, hello := range hellos {
+		if err := a.transport.SendTo(hello, peer.Endpoint); err != nil {
+			a.log.Debug("send hello failed", "peer", peer.Address, "err", err)
+			return
+		}
 	}
-	peer.LastSend = time.Now()
+	peer.MarkSent()
 	a.log.Info("hello sent", "peer", peer.Address, "endpoint", peer.Endpoint)
 }
 
-// initiateHandshake starts the PSK key exchange with a peer.
+// initiateHandshake starts the PSK key exchange with a peer. The peer
+// connects once its hello arrives (see handleHandshake); until then the
+// maintenance loop retries and eventually gives up (see driveHandshakes).
 func (a *Agent) initiateHandshake(peer *vl1.Peer) {
-	// Derive keys immediately from PSK (deterministic, no round-trip needed)
-	sendKey, recvKey := vl1.DeriveKeysFromPSK(a.config.PSK, a.identity.PublicKey, peer.PublicKey)
-	cipher := vl1.NewNoiseCipher(sendKey, recvKey)
-	peer.SetCipher(cipher)
-
+	peer.StartHandshake()
 	// Send hello so remote side knows our endpoint and can derive matching keys
 	a.sendHello(peer)
 }
 
-// maintenanceLoop runs periodic maintenance tasks.
+// driveHandshakes resends unanswered handshake initiations every retry
+// interval and gives up on peers silent past the handshake deadline.
+func (a *Agent) driveHandshakes() {
+	timers := a.peers.Timers()
+	deadline := timers.HandshakeDeadline()
+	for _, peer := range a.peers.AllPeers() {
+		switch peer.HandshakeTick(timers.HandshakeRetryInterval, deadline) {
+		case vl1.HandshakeRetry:
+			a.sendHello(peer)
+		case vl1.HandshakeExpired:
+			a.log.Warn("handshake timed out", "peer", peer.Address,
+				"attempts", peer.HandshakeAttempts(), "after", deadline)
+		}
+	}
+}
+
+// pairKey names a peer within a network, which pair PSKs are assigned for.
+type pairKey struct {
+	networkID uint32
+	addr      identity.Address
+}
+
+// peerPSK returns the PSK to derive session keys with the given peer in
+// networkID: the pair key the controller assigned when either side has a
+// per-member override, otherwise the network PSK. It reports false for a
+// network we have not joined.
+func (a *Agent) peerPSK(networkID uint32, addr identity.Address) ([32]byte, bool) {
+	if v, ok := a.peerPSKs.Load(pairKey{networkID, addr}); ok {
+		return v.([32]byte), true
+	}
+	if v, ok := a.networkPSKs.Load(networkID); ok {
+		return v.([32]byte), true
+	}
+	return [32]byte{}, false
+}
+
+// forgetNetworkKeys drops the PSKs of a network we are no longer in and the
+// sessions keyed from them.
+func (a *Agent) forgetNetworkKeys(networkID uint32) {
+	a.networkPSKs.Delete(networkID)
+	a.peerPSKs.Range(func(k, _ any) bool {
+		if k.(pairKey).networkID == networkID {
+			a.peerPSKs.Delete(k)
+		}
+		return true
+	})
+	for _, peer := range a.peers.AllPeers() {
+		peer.DropSession(networkID)
+	}
+}
+
+// maintenanceInterval is the longest period between maintenance passes.
+const maintenanceInterval = 10 * time.Second
+
+// maintenanceLoop runs periodic maintenance tasks. It ticks at least twice
+// per keepalive interval so short configured keepalives are honoured.
 func (a *Agent) maintenanceLoop() {
 	defer a.wg.Done()
-	ticker := time.NewTicker(10 * time.Second)
+	interval := maintenanceInterval
+	if half := a.peers.Timers().KeepaliveInterval / 2; half < interval {
+		interval = half
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	// Handshakes are checked twice per retry interval
+	handshakes := time.NewTicker(a.peers.Timers().HandshakeRetryInterval / 2)
+	defer handshakes.Stop()
+
+	// Ping the systemd watchdog if enabled; a nil channel never fires
+	var watchdog <-chan time.Time
+	if interval := sdnotify.WatchdogInterval(); interval > 0 {
+		wt := time.NewTicker(interval)
+		defer wt.Stop()
+		watchdog = wt.C
+	}
 
 	for {
 		select {
 		case <-a.ctx.Done():
 			return
+		case <-watchdog:
+			a.notifySystemd(sdnotify.Watchdog)
+		case <-handshakes.C:
+			a.driveHandshakes()
+		case <-a.statusKick:
+			if a.ctrlCli != nil {
+				a.ctrlCli.SendStatus()
+			}
 		case <-ticker.C:
 			// Send keepalives
 			for _, peer := range a.peers.ConnectedPeers() {
 				if peer.NeedsKeepalive() {
 					pkt := vl1.NewKeepalivePacket()
 					encoded := pkt.Encode()
-					if iceConn := peer.ICEConn(); iceConn != nil {
-						if _, err := iceConn.Write(encoded); err != nil {
-							a.log.Debug("ICE keepalive failed", "peer", peer.Address, "err", err)
+					if conn := peer.TunnelConn(); conn != nil {
+						if _, err := conn.Write(encoded); err != nil {
+							a.log.Debug("tunnel keepalive failed", "peer", peer.Address, "err", err)
 						}
 					} else if peer.Endpoint != nil {
 						if err := a.transport.SendTo(encoded, peer.Endpoint); err != nil {
 							a.log.Debug("keepalive send failed", "peer", peer.Address, "err", err)
 						}
 					}
-					peer.LastSend = time.Now()
-				}
-			}
-
-			// Re-send hello for peers that aren't connected yet
-			for _, peer := range a.peers.AllPeers() {
-				if !peer.IsConnected() && !peer.HasICE() {
-					a.sendHello(peer)
+					peer.MarkSent()
 				}
 			}
 
@@ -671,15 +1195,11 @@ func (a *Agent) maintenanceLoop() {
 				}
 			}
 
-			// Re-initiate ICE for peers that lost their connection (before CleanDead removes them)
-			if a.ctrlCli != nil && a.ctrlCli.nat != nil {
+			// Fall back to a TURN relay for peers with no working path
+			if a.ctrlCli != nil {
 				for _, peer := range a.peers.AllPeers() {
-					if !peer.IsConnected() && !peer.HasICE() && peer.PublicKey != [32]byte{} {
-						remoteNodeAddr := peer.Address.String()
-						if _, pending := a.ctrlCli.pendingICE.Load(remoteNodeAddr); !pending {
-							a.log.Info("re-initiating ICE for disconnected peer", "peer", remoteNodeAddr)
-							a.ctrlCli.initiateICE(peer.Address, remoteNodeAddr, a.config.PSK)
-						}
+					if peer.NeedsRelay() {
+						a.ctrlCli.startRelay(peer)
 					}
 				}
 			}
@@ -690,11 +1210,7 @@ func (a *Agent) maintenanceLoop() {
 			if a.network != nil {
 				a.network.Switch.CleanExpired()
 				a.network.ARP.CleanExpired()
-			}
-
-			// Clean stale ICE sessions
-			if a.ctrlCli != nil {
-				a.ctrlCli.CleanStaleICE()
+				a.network.NDP.CleanExpired()
 			}
 
 			// Send status to controller
@@ -738,7 +1254,11 @@ func (a *Agent) iceReadLoop(peer *vl1.Peer, conn net.Conn) {
 func (a *Agent) handleICEPacket(data []byte, peer *vl1.Peer) {
 	var pkt vl1.Packet
 	if err := vl1.DecodePacketInto(&pkt, data); err != nil {
-		a.log.Debug("ICE decode packet", "peer", peer.Address, "err", err, "raw_len", len(data))
+		if errors.Is(err, vl1.ErrVersion) {
+			a.drops.Drop(dropVersion, peer.Address.String(), "ICE packet from another protocol version", "peer", peer.Address, "err", err)
+			return
+		}
+		a.drops.Drop(dropDecode, peer.Address.String(), "ICE decode packet", "peer", peer.Address, "err", err, "raw_len", len(data))
 		return
 	}
 
@@ -748,33 +1268,58 @@ func (a *Agent) handleICEPacket(data []byte, peer *vl1.Peer) {
 
 	switch pkt.Header.Type {
 	case vl1.PacketTypeHandshake:
+		typ, err := vl1.ParseHandshakeType(pkt.Payload)
+		if err != nil {
+			a.drops.Drop(handshakeDropReason(err), peer.Address.String(), "ICE malformed handshake", "peer", peer.Address, "err", err)
+			return
+		}
+		if typ != vl1.HandshakeHello {
+			a.drops.Drop(dropUnsupportedHandshake, peer.Address.String(), "ICE unsupported handshake type", "peer", peer.Address, "type", typ)
+			return
+		}
+
 		// Hello from peer via ICE — derive keys if needed
-		if len(pkt.Payload) >= 32 && !peer.IsConnected() {
-			var remotePubKey [32]byte
-			copy(remotePubKey[:], pkt.Payload[:32])
-			sendKey, recvKey := vl1.DeriveKeysFromPSK(a.config.PSK, a.identity.PublicKey, remotePubKey)
-			cipher := vl1.NewNoiseCipher(sendKey, recvKey)
-			peer.SetCipher(cipher)
-			a.log.Info("peer connected via ICE handshake", "peer", peer.Address)
+		remotePubKey, flags, nonce, sent := vl1.ParseHello(pkt.Payload)
+		networkID := pkt.Header.NetworkID
+		if err := vl1.CheckClockSkew(sent, a.clock(), vl1.DefaultMaxClockSkew); err != nil {
+			a.drops.Warn(dropClockSkew, peer.Address.String(), "ICE hello rejected", "peer", peer.Address, "err", err)
+			return
+		}
+		if err := identity.ValidatePublicKey(remotePubKey[:]); err != nil {
+			a.drops.Drop(dropInvalidKey, peer.Address.String(), "ICE hello with invalid public key", "peer", peer.Address, "err", err)
+			return
+		}
+		psk, ok := a.peerPSK(networkID, peer.Address)
+		if !ok {
+			a.drops.Drop(dropWrongNetwork, peer.Address.String(), "ICE hello for a network we are not in", "peer", peer.Address, "network", networkID)
+			return
+		}
+		if a.keySession(peer, networkID, psk, nonce) {
+			a.log.Info("peer connected via ICE handshake", "peer", peer.Address, "network", networkID)
+		}
+		if flags&vl1.HelloFlagAwaitingReply != 0 {
+			a.replyHello(peer)
 		}
 
 	case vl1.PacketTypeData:
 		bufp := vl1.GetPacketBuf()
 		defer vl1.PutPacketBuf(bufp)
-		plaintext, err := peer.DecryptTo(*bufp, pkt.Payload)
+		ad := pkt.Header.Bytes()
+		plaintext, err := peer.DecryptTo(pkt.Header.NetworkID, *bufp, pkt.Payload, ad[:])
 		if err != nil {
-			a.log.Debug("ICE decrypt failed", "peer", peer.Address, "err", err)
+			a.drops.Drop(dropDecrypt, peer.Address.String(), "ICE decrypt failed", "peer", peer.Address, "err", err)
 			return
 		}
+		peer.CountRecv(len(plaintext))
 
 		if a.network == nil {
-			a.log.Debug("ICE data: no network", "peer", peer.Address)
+			a.drops.Drop(dropNoNetwork, peer.Address.String(), "ICE data: no network", "peer", peer.Address)
 			return
 		}
 
-		frameToInject, err := a.network.Switch.HandleRemoteFrame(peer.Address, plaintext)
+		frameToInject, err := a.handleRemoteFrame(peer.Address, plaintext)
 		if err != nil {
-			a.log.Debug("ICE switch handle remote frame", "err", err)
+			a.drops.Drop(dropSwitchRefused, peer.Address.String(), "ICE switch handle remote frame", "peer", peer.Address, "err", err)
 			return
 		}
 
@@ -786,8 +1331,11 @@ func (a *Agent) handleICEPacket(data []byte, peer *vl1.Peer) {
 	case vl1.PacketTypeKeepalive:
 		// Already touched above
 
+	case vl1.PacketTypeControl:
+		a.handleControlPacket(&pkt, peer, nil)
+
 	default:
-		a.log.Debug("ICE unknown packet type", "type", pkt.Header.Type, "peer", peer.Address)
+		a.drops.Drop(dropUnknownType, peer.Address.String(), "ICE unknown packet type", "type", pkt.Header.Type, "peer", peer.Address)
 	}
 }
 
@@ -802,6 +1350,9 @@ func (a *Agent) SendToPeer(peerAddr identity.Address, networkID uint32, frame []
 	if !peer.IsConnected() {
 		return fmt.Errorf("peer not connected: %s", peerAddr)
 	}
+	if !a.inNetwork(networkID, peerAddr) {
+		return fmt.Errorf("peer %s is not a member of network %d", peerAddr, networkID)
+	}
 
 	bufp := vl1.GetPacketBuf()
 	defer vl1.PutPacketBuf(bufp)
@@ -812,25 +1363,31 @@ func (a *Agent) SendToPeer(peerAddr identity.Address, networkID uint32, frame []
 	hdr.Encode(buf[:vl1.HeaderSize])
 
 	// Encrypt directly into buf[HeaderSize:]
-	n, err := peer.EncryptTo(buf[vl1.HeaderSize:], frame)
+	n, err := peer.EncryptTo(networkID, buf[vl1.HeaderSize:], frame, buf[:vl1.HeaderSize])
 	if err != nil {
 		return err
 	}
 	total := vl1.HeaderSize + n
 
-	// Prefer ICE connection if available
-	if iceConn := peer.ICEConn(); iceConn != nil {
-		_, err := iceConn.Write(buf[:total])
-		peer.LastSend = time.Now()
-		a.log.Debug("sent data via ICE", "peer", peerAddr, "frame_len", len(frame), "total", total)
+	// Prefer ICE or relay connection if available
+	if conn := peer.TunnelConn(); conn != nil {
+		_, err := conn.Write(buf[:total])
+		peer.MarkSent()
+		if err == nil {
+			peer.CountSent(len(frame))
+		}
+		a.log.Debug("sent data via tunnel", "peer", peerAddr, "frame_len", len(frame), "total", total)
 		return err
 	}
 
 	if peer.Endpoint == nil {
-		return fmt.Errorf("peer %s: no endpoint and no ICE connection", peerAddr)
+		return fmt.Errorf("peer %s: no endpoint and no ICE or relay connection", peerAddr)
 	}
 	err = a.transport.SendTo(buf[:total], peer.Endpoint)
-	peer.LastSend = time.Now()
+	peer.MarkSent()
+	if err == nil {
+		peer.CountSent(len(frame))
+	}
 	return err
 }
 
@@ -845,26 +1402,30 @@ func (a *Agent) BroadcastToPeers(networkID uint32, frame []byte, excludePeer ide
 	hdr.Encode(buf[:vl1.HeaderSize])
 
 	for _, peer := range a.peers.ConnectedPeers() {
-		if peer.Address == excludePeer {
+		if peer.Address == excludePeer || !a.inNetwork(networkID, peer.Address) {
 			continue
 		}
 
 		// Encrypt directly into buf[HeaderSize:] (each peer has different cipher)
-		n, err := peer.EncryptTo(buf[vl1.HeaderSize:], frame)
+		n, err := peer.EncryptTo(networkID, buf[vl1.HeaderSize:], frame, buf[:vl1.HeaderSize])
 		if err != nil {
 			a.log.Debug("encrypt for broadcast", "peer", peer.Address, "err", err)
 			continue
 		}
 		total := vl1.HeaderSize + n
 
-		if iceConn := peer.ICEConn(); iceConn != nil {
-			if _, err := iceConn.Write(buf[:total]); err != nil {
-				a.log.Debug("broadcast send via ICE", "peer", peer.Address, "err", err)
+		if conn := peer.TunnelConn(); conn != nil {
+			if _, err := conn.Write(buf[:total]); err != nil {
+				a.log.Debug("broadcast send via tunnel", "peer", peer.Address, "err", err)
+				continue
 			}
+			peer.CountSent(len(frame))
 		} else if peer.Endpoint != nil {
 			if err := a.transport.SendTo(buf[:total], peer.Endpoint); err != nil {
 				a.log.Debug("broadcast send", "peer", peer.Address, "err", err)
+				continue
 			}
+			peer.CountSent(len(frame))
 		}
 	}
 	return nil
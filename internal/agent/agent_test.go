@@ -0,0 +1,777 @@
+package agent
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/tap"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// memNet is an in-memory UDP network connecting test agents by address.
+type memNet struct {
+	mu    sync.Mutex
+	nodes map[string]*memTransport
+	delay map[string]time.Duration // by destination "ip:port"
+	sent  []memPacket              // every packet sent, delivered or not
+}
+
+type memPacket struct {
+	data     []byte
+	from, to *net.UDPAddr
+	at       time.Time
+}
+
+func newMemNet() *memNet {
+	return &memNet{nodes: make(map[string]*memTransport), delay: make(map[string]time.Duration)}
+}
+
+// route delivers packets sent to addr to tr after delay, as another path
+// to the same host would. tr's own address can be routed to slow it down.
+func (n *memNet) route(t *testing.T, addr string, tr *memTransport, delay time.Duration) {
+	t.Helper()
+	udp, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.mu.Lock()
+	n.nodes[udp.String()] = tr
+	n.delay[udp.String()] = delay
+	n.mu.Unlock()
+}
+
+// listen returns a transport receiving packets sent to addr ("ip:port").
+func (n *memNet) listen(t *testing.T, addr string) *memTransport {
+	t.Helper()
+	udp, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &memTransport{
+		net:    n,
+		addr:   udp,
+		in:     make(chan memPacket, 256),
+		closed: make(chan struct{}),
+	}
+	n.mu.Lock()
+	n.nodes[udp.String()] = tr
+	n.mu.Unlock()
+	return tr
+}
+
+// sentTo returns the packets sent to addr so far.
+func (n *memNet) sentTo(addr *net.UDPAddr) []memPacket {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var out []memPacket
+	for _, p := range n.sent {
+		if p.to.String() == addr.String() {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// memTransport is a vl1.Transport on a memNet.
+type memTransport struct {
+	net       *memNet
+	addr      *net.UDPAddr // guarded by net.mu
+	in        chan memPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *memTransport) Port() int                 { return t.localAddr().Port }
+func (t *memTransport) LocalAddr() net.Addr       { return t.localAddr() }
+func (t *memTransport) Stats() vl1.TransportStats { return vl1.TransportStats{} }
+
+func (t *memTransport) localAddr() *net.UDPAddr {
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	return t.addr
+}
+
+// rebind moves the transport to addr, as a NAT picking a new mapping would.
+func (t *memTransport) rebind(tb testing.TB, addr string) {
+	tb.Helper()
+	udp, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	t.net.mu.Lock()
+	delete(t.net.nodes, t.addr.String())
+	t.addr = udp
+	t.net.nodes[udp.String()] = t
+	t.net.mu.Unlock()
+}
+
+func (t *memTransport) ReadFrom(buf []byte) (int, *net.UDPAddr, error) {
+	select {
+	case p := <-t.in:
+		return copy(buf, p.data), p.from, nil
+	case <-t.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// SendTo delivers data to the transport listening on addr, if any; packets
+// to nowhere or to a full queue are lost, as on a real network.
+func (t *memTransport) SendTo(data []byte, addr *net.UDPAddr) error {
+	p := memPacket{data: append([]byte(nil), data...), to: addr, at: time.Now()}
+	t.net.mu.Lock()
+	p.from = t.addr
+	t.net.sent = append(t.net.sent, p)
+	dst := t.net.nodes[addr.String()]
+	delay := t.net.delay[addr.String()]
+	t.net.mu.Unlock()
+	if dst == nil {
+		return nil
+	}
+	deliver := func() {
+		select {
+		case dst.in <- p:
+		default:
+		}
+	}
+	if delay > 0 {
+		time.AfterFunc(delay, deliver)
+	} else {
+		deliver()
+	}
+	return nil
+}
+
+func (t *memTransport) Close() error {
+	t.closeOnce.Do(func() {
+		t.net.mu.Lock()
+		if t.net.nodes[t.addr.String()] == t {
+			delete(t.net.nodes, t.addr.String())
+		}
+		t.net.mu.Unlock()
+		close(t.closed)
+	})
+	return nil
+}
+
+// newTestAgent returns an agent with a fresh identity on tr, reading packets
+// but without a device or controller connection. It stops with the test.
+func newTestAgent(t *testing.T, tr vl1.Transport) *Agent {
+	t.Helper()
+	return startTestAgent(t, tr, filepath.Join(t.TempDir(), "identity.key"))
+}
+
+// restartTestAgent stops a and starts it again on tr, with its identity but
+// no other state, as a restarted process would be.
+func restartTestAgent(t *testing.T, a *Agent, tr vl1.Transport) *Agent {
+	t.Helper()
+	stopTestAgent(t, a)
+	return startTestAgent(t, tr, a.config.IdentityPath)
+}
+
+func startTestAgent(t *testing.T, tr vl1.Transport, identityPath string) *Agent {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a, err := New(Config{
+		IdentityPath: identityPath,
+		Transport:    tr,
+	}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.transport = tr
+	a.ctrlCli = NewControllerClient("ws://controller.invalid", a, log)
+	a.wg.Add(1)
+	go a.udpReadLoop()
+	t.Cleanup(func() { stopTestAgent(t, a) })
+	return a
+}
+
+// stopTestAgent stops a test agent's goroutines, failing the test if any
+// outlives the stop.
+func stopTestAgent(t *testing.T, a *Agent) {
+	t.Helper()
+	a.cancel()
+	a.transport.Close()
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("agent goroutines still running after stop")
+	}
+}
+
+// peerInfo describes a test agent as the controller would to its peers.
+func peerInfo(a *Agent, endpoints ...string) protocol.PeerInfo {
+	return protocol.PeerInfo{
+		Address:   a.identity.Address.String(),
+		PublicKey: a.identity.PublicKeyHex(),
+		Endpoints: endpoints,
+	}
+}
+
+// testNetwork is the network test agents join unless a test needs others.
+const testNetwork = 1
+
+// joinNetwork makes the agents members of networkID keyed by psk and of
+// each other there, as the controller's network config would.
+func joinNetwork(networkID uint32, psk [32]byte, agents ...*Agent) {
+	for _, a := range agents {
+		a.networkPSKs.Store(networkID, psk)
+		for _, b := range agents {
+			if b != a {
+				a.members.add(networkID, b.identity.Address)
+			}
+		}
+	}
+}
+
+// connectPair has a say hello to b at its address and waits until both are
+// connected. It returns each side's view of the other.
+func connectPair(t *testing.T, a, b *Agent) (peerOfA, peerOfB *vl1.Peer) {
+	t.Helper()
+	bAddr := b.transport.LocalAddr().(*net.UDPAddr)
+	peerOfA = a.peers.AddPeer(b.identity.Address, b.identity.PublicKey, bAddr)
+	a.initiateHandshake(peerOfA)
+	waitFor(t, 2*time.Second, "handshake", func() bool {
+		peerOfB = b.peers.GetPeer(a.identity.Address)
+		return peerOfA.IsConnected() && peerOfB != nil && peerOfB.IsConnected()
+	})
+	return peerOfA, peerOfB
+}
+
+// waitFor polls cond until it holds or the timeout passes.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHelloDoesNotMoveConnectedPeer(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	peerB, _ := connectPair(t, a, b)
+
+	// Anyone can send a hello carrying b's public key
+	attacker := mn.listen(t, "203.0.113.5:666")
+	spoofed := vl1.NewHandshakePacket(testNetwork, vl1.NewHelloPayload(b.identity.PublicKey, vl1.HelloFlagAwaitingReply, vl1.NewSessionNonce(), time.Now())).Encode()
+	if err := attacker.SendTo(spoofed, trA.addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pings the claimed address over the session; nobody there can answer
+	waitFor(t, time.Second, "the path check", func() bool {
+		_, probing := a.pathProbes.Load(attacker.addr.String())
+		return probing
+	})
+	waitFor(t, 2*pathProbeTimeout, "the path check to expire", func() bool {
+		_, probing := a.pathProbes.Load(attacker.addr.String())
+		return !probing
+	})
+	if ep := peerB.Endpoint; ep.String() != trB.addr.String() {
+		t.Fatalf("spoofed hello moved the peer to %v", ep)
+	}
+	// Nor did its session nonce replace the session
+	if _, err := a.Ping(t.Context(), b.identity.Address); err != nil {
+		t.Fatalf("ping after spoofed hello: %v", err)
+	}
+}
+
+func TestHelloFromNewEndpointMovesAfterPing(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	peerB, peerA := connectPair(t, a, b)
+	oldEP := peerB.Endpoint
+
+	// b's NAT mapping changes and b says hello from the new port
+	trB.rebind(t, "192.0.2.2:40000")
+	b.sendHello(peerA)
+
+	waitFor(t, 2*time.Second, "the endpoint to move", func() bool {
+		return a.peers.GetPeerByEndpoint(trB.localAddr()) == peerB
+	})
+	if oldEP.String() == peerB.Endpoint.String() {
+		t.Fatal("endpoint unchanged")
+	}
+	// The session carried over: a ping still round-trips both ways
+	if _, err := a.Ping(t.Context(), b.identity.Address); err != nil {
+		t.Fatalf("ping after move: %v", err)
+	}
+	if _, err := b.Ping(t.Context(), a.identity.Address); err != nil {
+		t.Fatalf("ping back after move: %v", err)
+	}
+}
+
+func TestRestartedPeerReconnects(t *testing.T) {
+	for _, tt := range []struct{ name, addr string }{
+		{"same endpoint", "192.0.2.2:9993"},
+		{"new endpoint", "192.0.2.2:40000"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mn := newMemNet()
+			trA := mn.listen(t, "192.0.2.1:9993")
+			a, b := newTestAgent(t, trA), newTestAgent(t, mn.listen(t, "192.0.2.2:9993"))
+			joinNetwork(testNetwork, [32]byte{1}, a, b)
+			peerB, peerA := connectPair(t, a, b)
+			hdr := vl1.Header{Version: vl1.Version, Type: vl1.PacketTypeData, NetworkID: testNetwork}
+			ad := hdr.Bytes()
+			captured, err := peerA.Encrypt(testNetwork, []byte("before"), ad[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// b comes back with its identity and nothing else, while a
+			// still holds the old session
+			b = restartTestAgent(t, b, mn.listen(t, tt.addr))
+			joinNetwork(testNetwork, [32]byte{1}, a, b)
+			b.initiateHandshake(b.peers.AddPeer(a.identity.Address, a.identity.PublicKey, trA.addr))
+
+			waitFor(t, 3*pathProbeTimeout, "pings both ways over the new session", func() bool {
+				ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+				defer cancel()
+				_, errA := a.Ping(ctx, b.identity.Address)
+				_, errB := b.Ping(ctx, a.identity.Address)
+				return errA == nil && errB == nil
+			})
+			if a.peers.GetPeer(b.identity.Address) != peerB || peerB.Rekeying(testNetwork) {
+				t.Fatal("a did not switch its record of b to the new session")
+			}
+			if _, err := peerB.Decrypt(testNetwork, captured, ad[:]); err == nil {
+				t.Fatal("packet from before the restart accepted")
+			}
+		})
+	}
+}
+
+func TestHelloKeysSessionPerNetwork(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	shared := [32]byte{1} // e.g. networks created from the same import
+	joinNetwork(1, shared, a, b)
+	joinNetwork(2, shared, a, b)
+
+	// Whichever network config arrived last must not pick the keys
+	a.config.NetworkID, a.config.PSK = 2, [32]byte{9}
+
+	peerB, peerA := connectPair(t, a, b)
+	waitFor(t, 2*time.Second, "sessions in both networks", func() bool {
+		return peerB.HasSession(1) && peerB.HasSession(2) && peerA.HasSession(1) && peerA.HasSession(2)
+	})
+
+	// A frame a sends in network 2 authenticates only as network 2 traffic
+	hdr := vl1.Header{Version: vl1.Version, Type: vl1.PacketTypeData, NetworkID: 2}
+	ad := hdr.Bytes()
+	ct, err := peerB.Encrypt(2, []byte("frame"), ad[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := peerA.Decrypt(1, ct, ad[:]); err == nil {
+		t.Fatal("network 2 frame decrypted under the network 1 session")
+	}
+	if pt, err := peerA.Decrypt(2, ct, ad[:]); err != nil || string(pt) != "frame" {
+		t.Fatalf("network 2 frame: %q, %v", pt, err)
+	}
+}
+
+func TestHelloForUnjoinedNetworkDropped(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	joinNetwork(2, [32]byte{2}, b) // only b is in network 2
+
+	hello := vl1.NewHandshakePacket(2, vl1.NewHelloPayload(b.identity.PublicKey, vl1.HelloFlagAwaitingReply, vl1.NewSessionNonce(), time.Now())).Encode()
+	if err := trB.SendTo(hello, trA.addr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, "the hello to be dropped", func() bool {
+		return a.drops.Counts()[dropWrongNetwork] == 1
+	})
+	if a.peers.GetPeer(b.identity.Address) != nil {
+		t.Fatal("hello for a network a is not in added the peer")
+	}
+}
+
+func TestHelloClockSkew(t *testing.T) {
+	tests := []struct {
+		name    string
+		offset  time.Duration // how far b's clock is off
+		connect bool
+	}{
+		{"within tolerance", vl1.DefaultMaxClockSkew - time.Minute, true},
+		{"outside tolerance", vl1.DefaultMaxClockSkew + time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mn := newMemNet()
+			trA := mn.listen(t, "192.0.2.1:9993")
+			trB := mn.listen(t, "192.0.2.2:9993")
+			a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+			joinNetwork(testNetwork, [32]byte{1}, a, b)
+
+			// b stamps its hellos by a clock that is off by offset
+			b.ctrlCli.recordClockOffset(tt.offset)
+			peerA := b.peers.AddPeer(a.identity.Address, a.identity.PublicKey, trA.addr)
+			b.initiateHandshake(peerA)
+
+			if tt.connect {
+				waitFor(t, 2*time.Second, "handshake", func() bool {
+					peerB := a.peers.GetPeer(b.identity.Address)
+					return peerA.IsConnected() && peerB != nil && peerB.IsConnected()
+				})
+				return
+			}
+			waitFor(t, time.Second, "the hello to be rejected", func() bool {
+				return a.drops.Counts()[dropClockSkew] > 0
+			})
+			if a.peers.GetPeer(b.identity.Address) != nil {
+				t.Fatal("hello outside the clock skew tolerance added the peer")
+			}
+		})
+	}
+}
+
+func TestHandshakeDispatch(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+
+	hello := vl1.NewHelloPayload(b.identity.PublicKey, 0, vl1.NewSessionNonce(), time.Now())
+	send := func(payload []byte) {
+		t.Helper()
+		if err := trB.SendTo(vl1.NewHandshakePacket(testNetwork, payload).Encode(), trA.addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Malformed handshakes, including one too short for its type, are dropped
+	send(nil)
+	send(hello[:vl1.HelloSize-1])
+	send(append([]byte{byte(vl1.HandshakeInit)}, hello[1:]...))
+	waitFor(t, time.Second, "the malformed handshakes", func() bool {
+		return a.drops.Counts()[dropBadHandshake] == 3
+	})
+
+	// The Noise and cookie messages, which have no handler, and types this
+	// version does not know are each dropped as unsupported
+	for i, typ := range []struct {
+		typ  vl1.HandshakeType
+		size int
+	}{
+		{vl1.HandshakeInit, vl1.HandshakeInitiationSize},
+		{vl1.HandshakeResponse, vl1.HandshakeResponseSize},
+		{vl1.HandshakeCookie, vl1.HandshakeCookieSize},
+		{0xff, vl1.HelloSize},
+	} {
+		payload := make([]byte, typ.size)
+		payload[0] = byte(typ.typ)
+		send(payload)
+		waitFor(t, time.Second, typ.typ.String()+" to be dropped", func() bool {
+			c := a.drops.Counts()
+			return c[dropUnsupportedHandshake] == uint64(i+1) && c[dropBadHandshake] == 3
+		})
+	}
+	if a.peers.GetPeer(b.identity.Address) != nil {
+		t.Fatal("a dropped handshake added the peer")
+	}
+
+	// A hello reaches handleHello, which connects the peer
+	send(hello)
+	waitFor(t, time.Second, "the hello to connect b", func() bool {
+		p := a.peers.GetPeer(b.identity.Address)
+		return p != nil && p.HasSession(testNetwork)
+	})
+}
+
+func TestShortKeepaliveSendsSooner(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	a.peers.SetTimers(vl1.Timers{KeepaliveInterval: 50 * time.Millisecond, PeerTimeout: time.Second})
+	connectPair(t, a, b)
+	for _, ag := range []*Agent{a, b} {
+		ag.wg.Add(1)
+		go ag.maintenanceLoop()
+	}
+
+	keepalives := func(to *net.UDPAddr) int {
+		n := 0
+		for _, p := range mn.sentTo(to) {
+			if hdr, err := vl1.DecodeHeader(p.data); err == nil && hdr.Type == vl1.PacketTypeKeepalive {
+				n++
+			}
+		}
+		return n
+	}
+	waitFor(t, time.Second, "keepalives on the short interval", func() bool { return keepalives(trB.addr) >= 3 })
+	// b keeps the default interval and has nothing to send yet
+	if n := keepalives(trA.addr); n != 0 {
+		t.Errorf("peer on the default interval sent %d keepalives", n)
+	}
+}
+
+func TestPerMemberPSK(t *testing.T) {
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+	b := newTestAgent(t, mn.listen(t, "192.0.2.2:9993"))
+	c := newTestAgent(t, mn.listen(t, "192.0.2.3:9993"))
+	joinNetwork(testNetwork, [32]byte{1}, a, b, c)
+
+	// a has an override, which the controller hands to a and each peer of a
+	pair := peerInfo(b)
+	pair.PSK = strings.Repeat("ab", 32)
+	a.ctrlCli.setPeerPSK(testNetwork, b.identity.Address, pair)
+	pair = peerInfo(a)
+	pair.PSK = strings.Repeat("ab", 32)
+	b.ctrlCli.setPeerPSK(testNetwork, a.identity.Address, pair)
+
+	if psk, _ := a.peerPSK(testNetwork, b.identity.Address); psk == [32]byte{1} {
+		t.Fatal("a uses the network PSK with b")
+	}
+	if psk, _ := b.peerPSK(testNetwork, c.identity.Address); psk != [32]byte{1} {
+		t.Fatal("b does not use the network PSK with c")
+	}
+
+	connectPair(t, a, b)
+	connectPair(t, b, c)
+	for _, p := range [][2]*Agent{{a, b}, {b, a}, {b, c}, {c, b}} {
+		if _, err := p[0].Ping(t.Context(), p[1].identity.Address); err != nil {
+			t.Fatalf("ping: %v", err)
+		}
+	}
+
+	// The keys really differ: if only a used the override with d, the two
+	// could not understand each other
+	d := newTestAgent(t, mn.listen(t, "192.0.2.4:9993"))
+	joinNetwork(testNetwork, [32]byte{1}, a, d)
+	a.ctrlCli.setPeerPSK(testNetwork, d.identity.Address, protocol.PeerInfo{PSK: strings.Repeat("ab", 32)})
+	connectPair(t, a, d)
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := a.Ping(ctx, d.identity.Address); err == nil {
+		t.Fatal("ping succeeded with different PSKs on each side")
+	}
+}
+
+func TestSimultaneousHellosConvergeQuickly(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	hellos := func() int {
+		n := 0
+		for _, to := range []*net.UDPAddr{trA.addr, trB.addr} {
+			for _, p := range mn.sentTo(to) {
+				if hdr, err := vl1.DecodeHeader(p.data); err == nil && hdr.Type == vl1.PacketTypeHandshake {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	// Both learn of each other at once and say hello unprompted
+	peerOfA := a.peers.AddPeer(b.identity.Address, b.identity.PublicKey, trB.addr)
+	peerOfB := b.peers.AddPeer(a.identity.Address, a.identity.PublicKey, trA.addr)
+	start := time.Now()
+	a.initiateHandshake(peerOfA)
+	b.initiateHandshake(peerOfB)
+	waitFor(t, time.Second, "both sides to connect", func() bool {
+		return peerOfA.IsConnected() && peerOfB.IsConnected()
+	})
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("connecting took %v", d)
+	}
+
+	// Each side's hello gets at most one reply, and then it goes quiet
+	time.Sleep(200 * time.Millisecond)
+	if n := hellos(); n > 4 {
+		t.Fatalf("%d hellos exchanged, want at most 4", n)
+	}
+}
+
+func TestLowOrderPeerKeyIgnored(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trM := mn.listen(t, "192.0.2.66:9993")
+	a := newTestAgent(t, trA)
+	joinNetwork(testNetwork, [32]byte{1}, a)
+	var zero [32]byte
+
+	hello := vl1.NewHandshakePacket(testNetwork, vl1.NewHelloPayload(zero, vl1.HelloFlagAwaitingReply, vl1.NewSessionNonce(), time.Now())).Encode()
+	if err := trM.SendTo(hello, trA.addr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, "the hello to be dropped", func() bool {
+		return a.drops.Counts()[dropInvalidKey] == 1
+	})
+
+	// Nor does the controller get to add one
+	a.ctrlCli.addPeerFromInfo(testNetwork, protocol.PeerInfo{
+		Address:   identity.AddressFromPublicKey(zero[:]).String(),
+		PublicKey: hex.EncodeToString(zero[:]),
+		Endpoints: []string{trM.addr.String()},
+	})
+	if peers := a.peers.AllPeers(); len(peers) != 0 {
+		t.Fatalf("%d peers with a low-order key added", len(peers))
+	}
+	if n := len(mn.sentTo(trM.addr)); n != 0 {
+		t.Fatalf("%d packets sent to the low-order key's endpoint", n)
+	}
+}
+
+// fakeDevice is an in-memory tap.Device: the agent reads the frames sent to
+// in and its writes arrive on out.
+type fakeDevice struct {
+	mu        sync.Mutex
+	addrs     []string // assigned addresses, as CIDRs
+	up        bool
+	in, out   chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// useFakeDevices makes agents open fake devices for their network configs
+// until the test ends.
+func useFakeDevices(t *testing.T) {
+	orig := openDevice
+	openDevice = func(a *Agent, name string) (tap.Device, error) {
+		d := &fakeDevice{in: make(chan []byte, 16), out: make(chan []byte, 64), closed: make(chan struct{})}
+		t.Cleanup(func() { d.Close() })
+		return d, nil
+	}
+	t.Cleanup(func() { openDevice = orig })
+}
+
+func (d *fakeDevice) IsTUN() bool                               { return false }
+func (d *fakeDevice) Name() string                              { return "zt-test" }
+func (d *fakeDevice) SetMTU(int) error                          { return nil }
+func (d *fakeDevice) SetMACAddress(net.HardwareAddr) error      { return nil }
+func (d *fakeDevice) AddRoute(string, string, int) error        { return nil }
+func (d *fakeDevice) RemoveRoute(string) error                  { return nil }
+func (d *fakeDevice) AddBypassRoute(string) error               { return nil }
+func (d *fakeDevice) RemoveBypassRoute(string) error            { return nil }
+func (d *fakeDevice) EnableIPForwarding() error                 { return nil }
+func (d *fakeDevice) SetPeerARP(net.IP, net.HardwareAddr) error { return nil }
+
+func (d *fakeDevice) AddIPAddress(ip net.IP, mask net.IPMask) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.addrs = append(d.addrs, (&net.IPNet{IP: ip, Mask: mask}).String())
+	return nil
+}
+
+func (d *fakeDevice) SetUp() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.up = true
+	return nil
+}
+
+func (d *fakeDevice) Read(buf []byte) (int, error) {
+	select {
+	case frame := <-d.in:
+		return copy(buf, frame), nil
+	case <-d.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (d *fakeDevice) Write(buf []byte) (int, error) {
+	select {
+	case d.out <- append([]byte(nil), buf...):
+	default:
+	}
+	return len(buf), nil
+}
+
+func (d *fakeDevice) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+	return nil
+}
+
+func TestHandshakeRetriesUntilDead(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	a := newTestAgent(t, trA)
+	const retry = 50 * time.Millisecond
+	a.peers.SetTimers(vl1.Timers{HandshakeRetryInterval: retry})
+
+	// A peer that never answers gets a hello every retry interval, however
+	// often the maintenance loop looks
+	to, _ := net.ResolveUDPAddr("udp", "192.0.2.9:9993")
+	a.networkPSKs.Store(uint32(testNetwork), [32]byte{1})
+	a.members.add(testNetwork, identity.Address{9})
+	peer := a.peers.AddPeer(identity.Address{9}, [32]byte{9}, to)
+	a.initiateHandshake(peer)
+	for range 3 {
+		a.driveHandshakes()
+		time.Sleep(retry)
+		a.driveHandshakes()
+	}
+	hellos := mn.sentTo(to)
+	if len(hellos) != 4 || peer.HandshakeAttempts() != 4 || peer.State != vl1.PeerStateHandshake {
+		t.Fatalf("%d hellos sent, %d attempts, state %v; want 4 while handshaking", len(hellos), peer.HandshakeAttempts(), peer.State)
+	}
+
+	// At the deadline it is given up, and the retries stop
+	peer.HandshakeAt = peer.HandshakeAt.Add(-vl1.HandshakeTimeout)
+	a.driveHandshakes()
+	if peer.State != vl1.PeerStateDead {
+		t.Fatalf("state %v past the deadline, want dead", peer.State)
+	}
+	time.Sleep(retry)
+	a.driveHandshakes()
+	if n := len(mn.sentTo(to)); n != 4 {
+		t.Fatalf("%d hellos after the handshake expired, want none", n-4)
+	}
+}
+
+func TestPeerConnectKicksStatusReport(t *testing.T) {
+	mn := newMemNet()
+	a, b := newTestAgent(t, mn.listen(t, "192.0.2.1:9993")), newTestAgent(t, mn.listen(t, "192.0.2.2:9993"))
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	select {
+	case <-a.statusKick:
+		t.Fatal("status report requested before any peer changed")
+	default:
+	}
+	connectPair(t, a, b)
+	select {
+	case <-a.statusKick:
+	case <-time.After(time.Second):
+		t.Fatal("connecting a peer did not request a status report")
+	}
+}
@@ -0,0 +1,142 @@
+package agent
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/vl2"
+)
+
+// errCaptureBusy is returned when a capture is already attached to the switch.
+var errCaptureBusy = errors.New("a capture is already running")
+
+// startCapture attaches a pcap capture of the network's frames to the
+// switch. Callers detach it with stopCapture.
+func (a *Agent) startCapture(w io.Writer, filter string, maxBytes int64) (*vl2.Capture, error) {
+	if a.network == nil {
+		return nil, errors.New("no network configured yet")
+	}
+	if a.config.TUNMode {
+		return nil, errors.New("capture needs TAP mode")
+	}
+	f, err := vl2.ParseCaptureFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if a.network.Switch.Mirroring() {
+		return nil, errCaptureBusy
+	}
+	c, err := vl2.NewCapture(w, vl2.CaptureOptions{Filter: f, MaxBytes: maxBytes})
+	if err != nil {
+		return nil, err
+	}
+	if !a.network.Switch.AttachMirror(c) {
+		c.Close()
+		return nil, errCaptureBusy
+	}
+	return c, nil
+}
+
+// stopCapture detaches a capture from the switch and flushes it.
+func (a *Agent) stopCapture(c *vl2.Capture) error {
+	a.network.Switch.DetachMirror(c)
+	captured, dropped := c.Stats()
+	a.log.Info("capture stopped", "frames", captured, "dropped", dropped)
+	return c.Close()
+}
+
+// startCaptureFile mirrors the network's frames into Config.CaptureFile
+// until the agent stops or the size cap is reached.
+func (a *Agent) startCaptureFile() {
+	if a.config.CaptureFile == "" {
+		return
+	}
+	file, err := os.Create(a.config.CaptureFile)
+	if err != nil {
+		a.log.Warn("open capture file failed", "err", err)
+		return
+	}
+	c, err := a.startCapture(file, a.config.CaptureFilter, a.config.CaptureMaxBytes)
+	if err != nil {
+		file.Close()
+		a.log.Warn("start capture failed", "err", err)
+		return
+	}
+	a.log.Info("capturing frames", "file", a.config.CaptureFile, "filter", a.config.CaptureFilter)
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		<-a.ctx.Done()
+		if err := a.stopCapture(c); err != nil {
+			a.log.Warn("write capture file failed", "err", err)
+		}
+		file.Close()
+	}()
+}
+
+// handleCapture streams a pcap of the network's frames until the client
+// disconnects or max_bytes is reached. Query parameters: filter (see
+// vl2.ParseCaptureFilter) and max_bytes.
+func (a *Agent) handleCapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var maxBytes int64
+	if s := r.URL.Query().Get("max_bytes"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid max_bytes", http.StatusBadRequest)
+			return
+		}
+		maxBytes = n
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	fw := &flushWriter{w: w}
+	fw.f, _ = w.(http.Flusher)
+	c, err := a.startCapture(fw, r.URL.Query().Get("filter"), maxBytes)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errCaptureBusy) {
+			status = http.StatusConflict
+		}
+		w.Header().Del("Content-Type")
+		http.Error(w, "capture: "+err.Error(), status)
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+wait:
+	for !c.Full() {
+		select {
+		case <-r.Context().Done():
+			break wait
+		case <-a.ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
+	}
+	a.stopCapture(c)
+}
+
+// flushWriter flushes after every write so captured frames reach the
+// client as they arrive.
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
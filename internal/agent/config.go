@@ -1,6 +1,11 @@
 package agent
 
-import "net"
+import (
+	"net"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
 
 // PeerEndpoint defines a static peer endpoint for Phase 1 (no controller).
 type PeerEndpoint struct {
@@ -15,25 +20,99 @@ type Config struct {
 	ListenPort   int
 	TAPName      string // desired TAP device name (e.g., "zt0")
 	TAPMTU       int
+	UnderlayMTU  int    // MTU of the physical path to peers (0 = DefaultUnderlayMTU)
+	NoMTUClamp   bool   // use TAPMTU as is, even if frames will fragment
 	TAPIPv4      string // IP/mask to assign (e.g., "10.147.17.1/24")
+	TAPIPv6      string // IPv6/prefix to assign (e.g., "fd00:1::a1:b2c3:d4e5/64")
+	TAPQueues    int    // TAP queues (>1 opens the device multiqueue, Linux only)
+	TxQueueLen   int    // TAP transmit queue length (0 = OS default)
 	NetworkID    uint32
 	PSK          [32]byte // Pre-shared key for Noise handshake
 
+	// Keep the TAP device (and its addresses and routes) across restarts
+	PersistentTAP bool
+
+	// Use a TUN device and route IP packets by destination instead of
+	// switching Ethernet frames (controller mode; needs member IPs)
+	TUNMode bool
+
+	// Android: the TUN descriptor from VpnService.Builder.establish(), and
+	// VpnService.protect for the UDP socket so it bypasses the VPN
+	TUNFD         int
+	SocketProtect func(fd int) bool
+
 	// Phase 1: static peers (no controller)
 	StaticPeers []PeerEndpoint
 
+	// Public keys (hex) of the only peers allowed to connect (empty = any),
+	// and of peers refused even if allowed
+	AllowPeers []string
+	DenyPeers  []string
+
 	// Phase 3: controller
 	ControllerURL string
 	Networks      []string // network IDs to join via controller
 
+	// mTLS with the controller: PEM client certificate and key issued for
+	// the node address, and the CA verifying the controller (empty = system)
+	TLSCert string
+	TLSKey  string
+	TLSCA   string
+
+	// Accept only controller certificates whose chain matches one of these
+	// pins ("sha256/<base64 SPKI hash>" or a hex certificate fingerprint)
+	ControllerPins []string
+
 	// ICE NAT traversal
 	STUNServers []string
 
+	// TURN relays used when a peer is unreachable directly; the controller
+	// may advertise more.
+	TURNServers []vl1.TURNServer
+
+	// Request a UDP port forward from the gateway via NAT-PMP or UPnP-IGD
+	PortMap bool
+
+	// Transport replaces the UDP socket bound on ListenPort, e.g. with a
+	// vl1.DroppingTransport to test relay fallback; socket buffer and DSCP
+	// settings are not applied to it
+	Transport vl1.Transport
+
+	// Route all traffic via the network's default gateway member, if one is
+	// designated (controller mode)
+	FullTunnel bool
+
+	// Serve DHCP to hosts on the network from this pool ("first-last"),
+	// ideally a reserved range of the network
+	DHCPRange string
+
 	// Gaming optimization
 	Gaming bool // Enable gaming mode (large socket buffers, DSCP EF, reduced keepalive)
 	DSCP   int  // DSCP marking value (default 0, gaming mode default 46/EF)
 	SndBuf int  // UDP send buffer size in bytes (0 = OS default)
 	RcvBuf int  // UDP receive buffer size in bytes (0 = OS default)
 
+	// Peer liveness; zero uses the vl1 defaults
+	KeepaliveInterval      time.Duration
+	PeerTimeout            time.Duration
+	HandshakeRetryInterval time.Duration
+
+	// Most peers tracked at once (0 = vl1.DefaultMaxPeers)
+	MaxPeers int
+
+	// Address family said hello to first when a peer advertises both:
+	// FamilyIPv6 (the default when empty) or FamilyIPv4
+	PreferFamily string
+
+	// Local status endpoint for zerogo-cli (empty = disabled)
+	StatusListen string
+
+	// Mirror frames through the switch into this pcap file (empty = off),
+	// keeping those matching CaptureFilter until CaptureMaxBytes (0 = no cap)
+	CaptureFile     string
+	CaptureFilter   string
+	CaptureMaxBytes int64
+
 	LogLevel string
+	Version  string
 }
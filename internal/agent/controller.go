@@ -2,19 +2,24 @@ package agent
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/unicornultrafoundation/zerogo/internal/identity"
 	"github.com/unicornultrafoundation/zerogo/internal/protocol"
-	"github.com/unicornultrafoundation/zerogo/internal/tap"
+	"github.com/unicornultrafoundation/zerogo/internal/sdnotify"
 	"github.com/unicornultrafoundation/zerogo/internal/vl1"
 	"github.com/unicornultrafoundation/zerogo/internal/vl2"
 )
@@ -26,14 +31,53 @@ const (
 	controllerMaxReconnectDelay = 60 * time.Second
 )
 
+// controllerKickedCooldown is how long the agent stays away after an admin
+// disconnected it.
+const controllerKickedCooldown = 10 * time.Minute
+
 // ControllerClient manages the WebSocket connection to the controller.
 type ControllerClient struct {
 	url       string
+	tlsConfig *tls.Config // nil uses the system defaults
 	agent     *Agent
 	conn      *websocket.Conn
 	mu        sync.Mutex
 	connected bool
 	log       *slog.Logger
+
+	relayServers []vl1.TURNServer // advertised by the controller (guarded by mu)
+	relays       sync.Map         // node address → *vl1.RelayAllocation
+	relayPending sync.Map         // node address → struct{} while allocating
+
+	seq uint64 // last signed message sequence number on this connection (guarded by mu)
+
+	// controllerProtocol is the protocol version the controller welcomed us
+	// with; 0 until then, or if it predates version negotiation
+	controllerProtocol atomic.Int32
+
+	// clockOffset is the controller's clock minus ours in nanoseconds, as
+	// of the last welcome: the correction for handshake timestamps
+	clockOffset atomic.Int64
+
+	// Managed routes and gateway NAT rules currently installed
+	routeMu  sync.Mutex
+	routes   routePlan
+	natRules [][]string
+
+	// DNS settings currently applied for the network, and by which manager
+	dnsMu      sync.Mutex
+	dns        dnsConfig
+	dnsManager string
+
+	// configMu serializes applying network configs, which device retries
+	// also do
+	configMu sync.Mutex
+
+	// Failure to create the network device and its pending retry (guarded
+	// by mu); see deviceFailed
+	deviceErr   string
+	deviceDelay time.Duration
+	deviceRetry *time.Timer
 }
 
 // NewControllerClient creates a new controller client.
@@ -72,15 +116,65 @@ func (c *ControllerClient) Run(ctx context.Context) {
 
 		delay = controllerReconnectDelay
 
+		var wait time.Duration
 		if err := c.readLoop(ctx); err != nil {
-			c.log.Warn("controller connection lost", "err", err)
+			wait = c.closeReason(err)
 		}
 		c.close()
+
+		if wait == noReconnect {
+			return
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// noReconnect is returned by closeReason when reconnecting cannot succeed.
+const noReconnect time.Duration = -1
+
+// closeReason logs why the controller connection ended and returns how long
+// to wait before reconnecting, or noReconnect.
+func (c *ControllerClient) closeReason(err error) time.Duration {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		c.log.Warn("controller connection lost", "err", err)
+		return 0
+	}
+
+	switch closeErr.Code {
+	case protocol.CloseUnauthorized, protocol.CloseDecommissioned:
+		// Retrying right away would just be rejected again
+		c.log.Error("controller rejected this agent", "reason", closeErr.Text, "retry_in", controllerMaxReconnectDelay)
+		return controllerMaxReconnectDelay
+	case protocol.CloseIncompatible:
+		c.log.Error("controller no longer supports this agent's protocol version; upgrade the agent", "reason", closeErr.Text, "protocol_version", protocol.ProtocolVersion)
+		return noReconnect
+	case protocol.CloseKicked:
+		c.log.Error("disconnected by the controller admin", "reason", closeErr.Text, "retry_in", controllerKickedCooldown)
+		return controllerKickedCooldown
+	case protocol.CloseRotated:
+		c.log.Error("this identity was rotated; restart the agent to connect with the new one")
+		return noReconnect
+	case protocol.CloseReplaced:
+		c.log.Warn("controller connection replaced by another agent with this identity", "retry_in", controllerReconnectDelay)
+		return controllerReconnectDelay
+	case websocket.CloseGoingAway:
+		c.log.Warn("controller going away", "reason", closeErr.Text, "retry_in", controllerReconnectDelay)
+		return controllerReconnectDelay
+	default:
+		c.log.Warn("controller closed connection", "code", closeErr.Code, "reason", closeErr.Text)
+		return 0
 	}
 }
 
 func (c *ControllerClient) connect(ctx context.Context) error {
-	wsURL := c.url + "/api/v1/agent/connect"
+	wsURL := controllerWSURL(c.url) + "/api/v1/agent/connect"
 	c.log.Info("connecting to controller", "url", wsURL)
 
 	header := http.Header{}
@@ -89,9 +183,10 @@ func (c *ControllerClient) connect(ctx context.Context) error {
 
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:  c.tlsConfig,
 	}
 
-	conn, _, err := dialer.DialContext(ctx, wsURL, header)
+	conn, resp, err := dialer.DialContext(ctx, wsURL, header)
 	if err != nil {
 		return fmt.Errorf("dial controller: %w", err)
 	}
@@ -99,6 +194,7 @@ func (c *ControllerClient) connect(ctx context.Context) error {
 	c.mu.Lock()
 	c.conn = conn
 	c.connected = true
+	c.seq = 0
 	c.mu.Unlock()
 
 	// Determine which networks to join
@@ -108,20 +204,36 @@ func (c *ControllerClient) connect(ctx context.Context) error {
 	}
 
 	// Send join message
+	c.controllerProtocol.Store(0)
+	hostname, _ := os.Hostname()
 	joinMsg := protocol.JoinMessage{
 		Type:      protocol.MsgTypeJoin,
 		NodeAddr:  c.agent.identity.Address.String(),
 		PublicKey: c.agent.identity.PublicKeyHex(),
 		Networks:  networks,
-		Endpoints: []string{fmt.Sprintf(":%d", c.agent.transport.Port())},
+		Endpoints: c.agent.localEndpoints(),
 		Platform:  "linux",
-		Version:   "0.1.0",
+		Version:   c.agent.config.Version,
+		Hostname:  hostname,
+
+		SigningKey:      hex.EncodeToString(c.agent.identity.SigningPublicKey()),
+		ProtocolVersion: protocol.ProtocolVersion,
+	}
+	// Prove the signing key is ours, so the controller can register it
+	if challenge, err := hex.DecodeString(resp.Header.Get("X-Key-Challenge")); err == nil && len(challenge) > 0 {
+		proof, err := c.agent.identity.KeyProof(challenge, protocol.SigningKeyProofData(joinMsg.NodeAddr, joinMsg.SigningKey))
+		if err != nil {
+			c.log.Warn("invalid key challenge from controller", "err", err)
+		} else {
+			joinMsg.SigningKeyProof = hex.EncodeToString(proof)
+		}
 	}
-	if err := c.sendJSON(joinMsg); err != nil {
+	if err := c.sendSigned(joinMsg); err != nil {
 		return fmt.Errorf("send join: %w", err)
 	}
 
 	c.log.Info("connected to controller", "networks", networks)
+	c.agent.notifySystemd(sdnotify.Ready)
 	return nil
 }
 
@@ -161,6 +273,46 @@ func (c *ControllerClient) readLoop(ctx context.Context) error {
 			}
 			c.handlePeerUpdate(&msg)
 
+		case protocol.MsgTypePunch:
+			var msg protocol.PunchMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				c.log.Debug("unmarshal punch", "err", err)
+				continue
+			}
+			c.handlePunch(&msg)
+
+		case protocol.MsgTypeRelayOffer:
+			var msg protocol.RelayOfferMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				c.log.Debug("unmarshal relay offer", "err", err)
+				continue
+			}
+			c.handleRelayOffer(&msg)
+
+		case protocol.MsgTypeRelayUpdate:
+			var msg protocol.RelayUpdateMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				c.log.Debug("unmarshal relay update", "err", err)
+				continue
+			}
+			c.setRelayServers(msg.Relays)
+
+		case protocol.MsgTypeNetworkDeleted:
+			var msg protocol.NetworkDeletedMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				c.log.Debug("unmarshal network deleted", "err", err)
+				continue
+			}
+			c.handleNetworkDeleted(&msg)
+
+		case protocol.MsgTypeWelcome:
+			var msg protocol.WelcomeMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				c.log.Debug("unmarshal welcome", "err", err)
+				continue
+			}
+			c.handleWelcome(&msg)
+
 		case protocol.MsgTypeError:
 			var msg protocol.ErrorMessage
 			if err := json.Unmarshal(message, &msg); err == nil {
@@ -173,18 +325,73 @@ func (c *ControllerClient) readLoop(ctx context.Context) error {
 	}
 }
 
+// handleWelcome records the controller's protocol version and how far our
+// clock is from the controller's.
+func (c *ControllerClient) handleWelcome(msg *protocol.WelcomeMessage) {
+	c.controllerProtocol.Store(int32(msg.ProtocolVersion))
+	if !msg.Time.IsZero() {
+		c.recordClockOffset(time.Until(msg.Time))
+	}
+	if msg.ProtocolVersion < protocol.ProtocolVersion {
+		c.log.Warn("controller speaks an older protocol version", "controller", msg.ProtocolVersion, "agent", protocol.ProtocolVersion)
+		return
+	}
+	c.log.Debug("joined controller", "protocol_version", msg.ProtocolVersion)
+}
+
+// recordClockOffset stores the controller's clock minus ours. The welcome
+// left the controller up to a round trip ago, which is well within the
+// hello's skew tolerance. Hellos are stamped and judged by the corrected
+// clock (Agent.clock), so peers of the same controller agree even if ours
+// is off; a large offset is still worth a warning, since static peers and
+// everything else on the node see the local clock.
+func (c *ControllerClient) recordClockOffset(offset time.Duration) {
+	c.clockOffset.Store(int64(offset))
+	abs := offset
+	if abs < 0 {
+		abs = -abs
+	}
+	if abs > vl1.DefaultMaxClockSkew/2 {
+		c.log.Warn("local clock differs from the controller's; hello timestamps are corrected by the offset",
+			"offset", offset.Round(time.Second), "tolerance", vl1.DefaultMaxClockSkew)
+		return
+	}
+	c.log.Debug("clock offset to controller", "offset", offset)
+}
+
+// ClockOffset returns the controller's clock minus ours, for correcting
+// hello timestamps (see Agent.clock); 0 before the first welcome or with a
+// controller that sends no time.
+func (c *ControllerClient) ClockOffset() time.Duration {
+	return time.Duration(c.clockOffset.Load())
+}
+
 // handleNetworkConfig applies the network configuration from the controller.
 func (c *ControllerClient) handleNetworkConfig(msg *protocol.NetworkConfigMessage) {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+
 	c.log.Info("received network config",
 		"network", msg.NetworkID,
 		"name", msg.Name,
 		"assigned_ip", msg.AssignedIP,
+		"assigned_ip6", msg.AssignedIP6,
+		"gateway_ip", msg.GatewayIP,
 		"peers", len(msg.Peers),
 	)
 
 	a := c.agent
 
-	// Parse PSK
+	if len(msg.Relays) > 0 {
+		c.setRelayServers(msg.Relays)
+	}
+
+	// Parse network ID
+	var networkID uint32
+	fmt.Sscanf(msg.NetworkID, "%d", &networkID)
+
+	// Parse PSK. Sessions are keyed per network, so each network's PSK is
+	// kept under its ID rather than replacing the last one received.
 	var psk [32]byte
 	if msg.PSK != "" {
 		b, err := hex.DecodeString(msg.PSK)
@@ -193,38 +400,41 @@ func (c *ControllerClient) handleNetworkConfig(msg *protocol.NetworkConfigMessag
 			return
 		}
 		copy(psk[:], b)
-		a.config.PSK = psk
 	}
-
-	// Parse network ID
-	var networkID uint32
-	fmt.Sscanf(msg.NetworkID, "%d", &networkID)
+	a.networkPSKs.Store(networkID, psk)
+	a.addrMu.Lock()
 	a.config.NetworkID = networkID
+	a.addrMu.Unlock()
+
+	limits := tableLimits(msg.Tables)
+	if err := limits.Validate(); err != nil {
+		c.log.Warn("ignoring invalid table limits", "err", err)
+		limits = vl2.TableLimits{}
+	}
 
 	// Setup TAP device if not already created
 	if a.tapDev == nil {
-		mtu := msg.MTU
-		if mtu == 0 {
-			mtu = 2800
-		}
-		a.config.TAPMTU = mtu
+		a.config.TAPMTU = msg.MTU
+		mtu := a.deviceMTU(msg.MTU)
 
 		tapName := a.config.TAPName
 		if tapName == "" {
 			tapName = "zt0"
 		}
 
-		tapDev, err := tap.NewLinuxTAP(tapName)
+		tapDev, err := openDevice(a, tapName)
 		if err != nil {
-			c.log.Error("create TAP device", "err", err)
+			c.deviceFailed(msg, deviceError(tapName, err))
 			return
 		}
+		c.deviceCreated()
 		a.tapDev = tapDev
 		c.log.Info("TAP device created", "name", tapDev.Name())
 
 		if err := tapDev.SetMTU(mtu); err != nil {
 			c.log.Warn("set TAP MTU", "err", err)
 		}
+		a.tuneTAPQueue()
 
 		// Create VL2 network
 		netConfig := vl2.NetworkConfig{
@@ -232,8 +442,10 @@ func (c *ControllerClient) handleNetworkConfig(msg *protocol.NetworkConfigMessag
 			Name:      msg.Name,
 			MTU:       mtu,
 			Multicast: msg.Multicast,
+			Limits:    limits,
 		}
 		a.network = vl2.NewNetwork(netConfig, a.identity.Address, a, a.log)
+		a.startCaptureFile()
 
 		// Set MAC
 		mac := vl2.GenerateMAC(networkID, a.identity.Address)
@@ -250,31 +462,108 @@ func (c *ControllerClient) handleNetworkConfig(msg *protocol.NetworkConfigMessag
 				if err := tapDev.AddIPAddress(ip, ipNet.Mask); err != nil {
 					c.log.Warn("add TAP IP", "err", err)
 				}
+				a.addrMu.Lock()
+				a.config.TAPIPv4 = msg.AssignedIP
+				a.addrMu.Unlock()
 				c.log.Info("TAP IP configured", "ip", msg.AssignedIP)
 			}
 		}
+		if msg.AssignedIP6 != "" {
+			ip, ipNet, err := net.ParseCIDR(msg.AssignedIP6)
+			if err != nil || ip.To4() != nil {
+				c.log.Warn("invalid assigned IPv6", "ip", msg.AssignedIP6, "err", err)
+			} else {
+				if err := tapDev.AddIPAddress(ip, ipNet.Mask); err != nil {
+					c.log.Warn("add TAP IPv6", "err", err)
+				}
+				a.addrMu.Lock()
+				a.config.TAPIPv6 = msg.AssignedIP6
+				a.addrMu.Unlock()
+				// A TUN device cannot answer neighbor solicitations from
+				// TAP members itself; the NDP proxy answers for it.
+				if tapDev.IsTUN() {
+					a.network.NDP.Learn(ip, mac)
+				}
+				c.log.Info("TAP IPv6 configured", "ip", msg.AssignedIP6)
+			}
+		}
 
 		// Bring up
 		if err := tapDev.SetUp(); err != nil {
 			c.log.Warn("bring TAP up", "err", err)
 		}
 
-		// Start TAP read loop
-		a.wg.Add(1)
-		go a.tapReadLoop()
+		// Start TAP read loops
+		a.startTAPReaders()
 
 		c.log.Info("network configured",
 			"network_id", networkID,
 			"name", msg.Name,
 			"ip", msg.AssignedIP,
+			"ip6", msg.AssignedIP6,
 			"tap", tapDev.Name(),
 		)
 	}
 
+	if a.network != nil {
+		if limits != a.network.Config.Limits {
+			a.network.SetLimits(limits)
+		}
+	}
+	c.applyRules(msg.Rules)
+	c.applySourceValidation(msg)
+	c.applyRoutes(msg)
+	c.applyDNS(msg)
+	c.applyDHCP(msg)
+
 	// Connect to peers
+	members := make([]identity.Address, 0, len(msg.Peers))
+	for _, peerInfo := range msg.Peers {
+		if addr, err := identity.AddressFromHex(peerInfo.Address); err == nil {
+			members = append(members, addr)
+		}
+	}
+	a.members.set(networkID, members)
 	for _, peerInfo := range msg.Peers {
-		c.addPeerFromInfo(peerInfo, psk)
+		c.addPeerFromInfo(networkID, peerInfo)
+	}
+	if a.config.TUNMode && a.network != nil {
+		routes := make(map[netip.Addr]identity.Address, len(msg.Peers))
+		for _, p := range msg.Peers {
+			ips, addr := peerRoutes(p)
+			for _, ip := range ips {
+				routes[ip] = addr
+			}
+		}
+		a.network.Router.SetRoutes(routes)
+	}
+}
+
+// tableLimits converts the table limits pushed by the controller, whose
+// expiries are in seconds.
+func tableLimits(t protocol.TableLimits) vl2.TableLimits {
+	return vl2.TableLimits{
+		MACTableSize:   t.MACTableSize,
+		MACTableExpiry: time.Duration(t.MACTableExpiry) * time.Second,
+		ARPCacheSize:   t.ARPCacheSize,
+		ARPCacheExpiry: time.Duration(t.ARPCacheExpiry) * time.Second,
+	}
+}
+
+// peerRoutes returns the overlay IPs (IPv4 and, if assigned, IPv6) a
+// TUN-mode router sends to the peer, and the peer's address.
+func peerRoutes(info protocol.PeerInfo) ([]netip.Addr, identity.Address) {
+	addr, err := identity.AddressFromHex(info.Address)
+	if err != nil {
+		return nil, identity.Address{}
+	}
+	var ips []netip.Addr
+	for _, s := range []string{info.IP, info.IP6} {
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			ips = append(ips, prefix.Addr())
+		}
 	}
+	return ips, addr
 }
 
 // handlePeerUpdate processes a peer add/remove notification from the controller.
@@ -285,24 +574,121 @@ func (c *ControllerClient) handlePeerUpdate(msg *protocol.PeerUpdateMessage) {
 		"endpoints", msg.Peer.Endpoints,
 	)
 
+	networkID := c.agent.config.NetworkID
+	if msg.NetworkID != "" {
+		fmt.Sscanf(msg.NetworkID, "%d", &networkID)
+	}
+
 	switch msg.Action {
 	case "add":
-		c.addPeerFromInfo(msg.Peer, c.agent.config.PSK)
+		if addr, err := identity.AddressFromHex(msg.Peer.Address); err == nil {
+			c.agent.members.add(networkID, addr)
+		}
+		c.addPeerFromInfo(networkID, msg.Peer)
+		if n := c.agent.network; n != nil && n.DHCP != nil && msg.Peer.IP != "" {
+			if prefix, err := netip.ParsePrefix(msg.Peer.IP); err == nil {
+				n.DHCP.AddInUse(prefix.Addr())
+			}
+		}
+		if n := c.agent.network; n != nil {
+			if addr, pa, ok := c.peerAddresses(msg.Peer); ok {
+				n.Guard.SetPeer(addr, pa)
+			}
+		}
+		if n := c.agent.network; n != nil && c.agent.config.TUNMode {
+			ips, addr := peerRoutes(msg.Peer)
+			for _, ip := range ips {
+				n.Router.AddRoute(ip, addr)
+			}
+		}
 	case "remove":
 		addr, err := identity.AddressFromHex(msg.Peer.Address)
 		if err != nil {
 			c.log.Warn("invalid peer address", "addr", msg.Peer.Address)
 			return
 		}
-		c.agent.peers.RemovePeer(addr)
+		c.agent.peerPSKs.Delete(pairKey{networkID, addr})
+		if c.agent.members.remove(networkID, addr) {
+			if peer := c.agent.peers.GetPeer(addr); peer != nil {
+				peer.DropSession(networkID)
+			}
+			c.log.Info("peer left network, still a member of another", "addr", msg.Peer.Address, "network", networkID)
+			return
+		}
+		c.removePeer(addr)
 		c.log.Info("peer removed", "addr", msg.Peer.Address)
 	}
 }
 
-// addPeerFromInfo adds a peer from PeerInfo and initiates handshake.
-func (c *ControllerClient) addPeerFromInfo(info protocol.PeerInfo, psk [32]byte) {
+// removePeer forgets a peer the controller removed and closes its data
+// plane: its session and tunnels go away with the peer, and the switch stops
+// forwarding to the MACs learned behind it.
+func (c *ControllerClient) removePeer(addr identity.Address) {
+	a := c.agent
+	a.peers.RemovePeer(addr)
+	if v, ok := c.relays.LoadAndDelete(addr.String()); ok {
+		v.(*vl1.RelayAllocation).Close() // an offer may still be pending
+	}
+	a.peerPSKs.Range(func(k, _ any) bool {
+		if k.(pairKey).addr == addr {
+			a.peerPSKs.Delete(k)
+		}
+		return true
+	})
+	if n := a.network; n != nil {
+		n.RemovePeer(addr)
+	}
+}
+
+// handleNetworkDeleted tears down the data plane of a network the controller
+// deleted. The TAP device stays up so a restore only needs a new config.
+func (c *ControllerClient) handleNetworkDeleted(msg *protocol.NetworkDeletedMessage) {
+	a := c.agent
+	var networkID uint32
+	fmt.Sscanf(msg.NetworkID, "%d", &networkID)
+	a.forgetNetworkKeys(networkID)
+	if networkID != a.config.NetworkID {
+		return
+	}
+	c.log.Warn("network deleted by controller", "network", msg.NetworkID)
+
+	c.cleanupRoutes()
+	c.cleanupDNS()
+	a.members.removeNetwork(networkID)
+	for _, peer := range a.peers.AllPeers() {
+		if !a.members.inAny(peer.Address) {
+			c.removePeer(peer.Address)
+		}
+	}
+}
+
+// setPeerPSK records the pair PSK the controller assigned for a peer in
+// networkID, or forgets an earlier one so the network PSK applies again.
+func (c *ControllerClient) setPeerPSK(networkID uint32, addr identity.Address, info protocol.PeerInfo) {
+	key := pairKey{networkID, addr}
+	if info.PSK == "" {
+		c.agent.peerPSKs.Delete(key)
+		return
+	}
+	b, err := hex.DecodeString(info.PSK)
+	if err != nil || len(b) != 32 {
+		c.log.Warn("invalid peer PSK from controller", "peer", info.Address, "err", err)
+		c.agent.peerPSKs.Delete(key)
+		return
+	}
+	var psk [32]byte
+	copy(psk[:], b)
+	c.agent.peerPSKs.Store(key, psk)
+}
+
+// addPeerFromInfo adds a peer of networkID from PeerInfo and initiates the
+// handshake in that network.
+func (c *ControllerClient) addPeerFromInfo(networkID uint32, info protocol.PeerInfo) {
 	pubKeyBytes, err := hex.DecodeString(info.PublicKey)
-	if err != nil || len(pubKeyBytes) != 32 {
+	if err == nil {
+		err = identity.ValidatePublicKey(pubKeyBytes)
+	}
+	if err != nil {
 		c.log.Warn("invalid peer public key", "peer", info.Address, "err", err)
 		return
 	}
@@ -310,36 +696,41 @@ func (c *ControllerClient) addPeerFromInfo(info protocol.PeerInfo, psk [32]byte)
 	var pubKey [32]byte
 	copy(pubKey[:], pubKeyBytes)
 	peerAddr := identity.AddressFromPublicKey(pubKey[:])
-
-	// Already connected?
-	if existing := c.agent.peers.GetPeer(peerAddr); existing != nil && existing.IsConnected() {
-		return
-	}
-
-	// Resolve endpoint
-	var endpoint *net.UDPAddr
-	for _, ep := range info.Endpoints {
-		resolved, err := net.ResolveUDPAddr("udp", ep)
-		if err == nil && resolved.IP != nil {
-			endpoint = resolved
-			break
+	c.setPeerPSK(networkID, peerAddr, info)
+
+	// Already connected? A session in another network keeps its endpoint;
+	// the hello keys this one over the same path.
+	existing := c.agent.peers.GetPeer(peerAddr)
+	if existing != nil && existing.IsConnected() {
+		c.agent.peers.Trust(peerAddr)
+		if !existing.HasSession(networkID) {
+			c.agent.sendHello(existing)
 		}
+		return
 	}
 
-	if endpoint == nil {
+	candidates := c.resolvePeerEndpoints(info.Address, info.Endpoints)
+	if len(candidates) == 0 {
 		c.log.Debug("no valid endpoint for peer", "peer", info.Address, "endpoints", info.Endpoints)
 		return
 	}
+	// Preferred address family first; when reconnecting, endpoints that
+	// worked before go ahead of both
+	candidates = orderByFamily(candidates, c.agent.config.PreferFamily == FamilyIPv4)
+	if existing != nil {
+		candidates = existing.OrderEndpoints(candidates)
+	}
 
-	peer := c.agent.peers.AddPeer(peerAddr, pubKey, endpoint)
-
-	// Derive keys from PSK and initiate handshake
-	sendKey, recvKey := vl1.DeriveKeysFromPSK(psk, c.agent.identity.PublicKey, pubKey)
-	cipher := vl1.NewNoiseCipher(sendKey, recvKey)
-	peer.SetCipher(cipher)
+	peer := c.agent.peers.AddPeer(peerAddr, pubKey, candidates[0])
 
-	c.agent.sendHello(peer)
-	c.log.Info("peer connected via controller", "peer", info.Address, "endpoint", endpoint)
+	// Initiate the handshake; the peer connects when its hello arrives
+	peer.StartHandshake()
+	if len(candidates) == 1 {
+		c.agent.sendHello(peer)
+	} else {
+		go c.probeEndpoints(peer, candidates)
+	}
+	c.log.Info("handshaking with peer from controller", "peer", info.Address, "endpoints", candidates)
 }
 
 // SendStatus sends a status report to the controller.
@@ -354,16 +745,35 @@ func (c *ControllerClient) SendStatus() error {
 	peers := c.agent.peers.ConnectedPeers()
 	peerStatuses := make([]protocol.PeerStatus, 0, len(peers))
 	for _, p := range peers {
+		sent, recv := p.Bytes()
+		path := "direct"
+		if p.HasRelay() && !p.HasICE() {
+			path = "relay"
+		}
 		peerStatuses = append(peerStatuses, protocol.PeerStatus{
 			Address:   p.Address.String(),
 			LatencyMs: p.LatencyMs,
-			Path:      "direct",
+			Path:      path,
+			BytesSent: int64(sent),
+			BytesRecv: int64(recv),
 		})
 	}
 
-	return c.sendJSON(protocol.StatusMessage{
-		Type:  protocol.MsgTypeStatus,
-		Peers: peerStatuses,
+	// Any packet from a peer counts, so a peer still mid-handshake shows
+	// the data plane is at least reachable.
+	var lastFrame time.Time
+	for _, p := range c.agent.peers.AllPeers() {
+		if p.LastSeen.After(lastFrame) {
+			lastFrame = p.LastSeen
+		}
+	}
+
+	return c.sendSigned(protocol.StatusMessage{
+		Type:        protocol.MsgTypeStatus,
+		Peers:       peerStatuses,
+		Endpoints:   c.agent.localEndpoints(),
+		LastFrameAt: lastFrame,
+		DeviceError: c.deviceError(),
 	})
 }
 
@@ -377,10 +787,38 @@ func (c *ControllerClient) sendJSON(v interface{}) error {
 	return c.conn.WriteJSON(v)
 }
 
+// sendSigned sends v inside a SignedMessage envelope signed with the agent's
+// identity signing key.
+func (c *ControllerClient) sendSigned(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	c.seq++
+	addr := c.agent.identity.Address.String()
+	env := protocol.SignedMessage{
+		Type:      protocol.MsgTypeSigned,
+		NodeAddr:  addr,
+		Seq:       c.seq,
+		Payload:   payload,
+		Signature: hex.EncodeToString(c.agent.identity.Sign(protocol.SignedData(addr, c.seq, payload))),
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(controllerWriteTimeout))
+	return c.conn.WriteJSON(env)
+}
+
 func (c *ControllerClient) close() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn != nil {
+		c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
 		c.conn.Close()
 		c.conn = nil
 	}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl2"
+)
+
+func TestCloseReasonDelay(t *testing.T) {
+	c := &ControllerClient{log: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	tests := []struct {
+		err  error
+		want time.Duration
+	}{
+		{errors.New("unexpected EOF"), 0},
+		{&websocket.CloseError{Code: protocol.CloseReplaced, Text: "replaced by newer connection"}, controllerReconnectDelay},
+		{&websocket.CloseError{Code: websocket.CloseGoingAway, Text: "server shutting down"}, controllerReconnectDelay},
+		{&websocket.CloseError{Code: protocol.CloseUnauthorized, Text: "unauthorized"}, controllerMaxReconnectDelay},
+		{&websocket.CloseError{Code: protocol.CloseKicked}, controllerKickedCooldown},
+		{&websocket.CloseError{Code: protocol.CloseIncompatible}, noReconnect},
+		{&websocket.CloseError{Code: websocket.CloseAbnormalClosure}, 0},
+	}
+	for _, tt := range tests {
+		if got := c.closeReason(tt.err); got != tt.want {
+			t.Errorf("closeReason(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestNetworkConfigIPv6(t *testing.T) {
+	useFakeDevices(t)
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+
+	ip6 := map[*Agent]string{a: "fd00:1::a:1/64", b: "fd00:1::b:1/64"}
+	configure := func(ag, other *Agent, otherAddr string) *fakeDevice {
+		t.Helper()
+		peer := peerInfo(other, otherAddr)
+		peer.IP6 = ip6[other]
+		ag.ctrlCli.handleNetworkConfig(&protocol.NetworkConfigMessage{
+			Type:        protocol.MsgTypeNetworkConfig,
+			NetworkID:   "1",
+			MTU:         1400,
+			PSK:         hex.EncodeToString(make([]byte, 32)),
+			AssignedIP:  "10.1.0.2/24",
+			AssignedIP6: ip6[ag],
+			Peers:       []protocol.PeerInfo{peer},
+		})
+		dev, ok := ag.tapDev.(*fakeDevice)
+		if !ok {
+			t.Fatalf("device = %T", ag.tapDev)
+		}
+		return dev
+	}
+	devA := configure(a, b, trB.addr.String())
+	devB := configure(b, a, trA.addr.String())
+
+	devA.mu.Lock()
+	addrs, up := devA.addrs, devA.up
+	devA.mu.Unlock()
+	if !reflect.DeepEqual(addrs, []string{"10.1.0.2/24", "fd00:1::a:1/64"}) || !up {
+		t.Fatalf("device addresses = %q (up %v), want the IPv4 and IPv6 address", addrs, up)
+	}
+	if st := a.Status(); !slices.Contains(st.AssignedIPs, "fd00:1::a:1/64") {
+		t.Fatalf("status addresses = %q", st.AssignedIPs)
+	}
+	waitFor(t, 2*time.Second, "peers to connect", func() bool {
+		p := a.peers.GetPeer(b.identity.Address)
+		return p != nil && p.IsConnected()
+	})
+
+	// An IPv6 packet from a's device comes out of b's
+	frame := make([]byte, vl2.EthernetHeaderSize+vl2.IPv6HeaderSize)
+	copy(frame[0:6], vl2.GenerateMAC(testNetwork, b.identity.Address))
+	copy(frame[6:12], vl2.GenerateMAC(testNetwork, a.identity.Address))
+	binary.BigEndian.PutUint16(frame[12:14], vl2.EtherTypeIPv6)
+	ip := frame[vl2.EthernetHeaderSize:]
+	ip[0] = 0x60
+	ip[6] = 59 // no next header
+	ip[7] = 64
+	copy(ip[8:24], net.ParseIP("fd00:1::a:1"))
+	copy(ip[24:40], net.ParseIP("fd00:1::b:1"))
+	devA.in <- frame
+
+	select {
+	case got := <-devB.out:
+		if !bytes.Equal(got, frame) {
+			t.Fatalf("b's device got % x, want % x", got, frame)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("IPv6 frame not forwarded")
+	}
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/tap"
+)
+
+// Backoff between attempts to create the network device in controller mode.
+const (
+	deviceRetryDelay    = 2 * time.Second
+	deviceMaxRetryDelay = 2 * time.Minute
+)
+
+// openDevice creates the network device named name for a network config;
+// a variable so tests can substitute a fake device.
+var openDevice = func(a *Agent, name string) (tap.Device, error) {
+	switch {
+	case a.config.TUNMode:
+		return tap.NewTUN(name)
+	case a.config.TAPQueues > 1 || a.config.PersistentTAP:
+		return tap.NewTAPWithOptions(name, a.tapOptions())
+	}
+	return tap.NewTAP(name)
+}
+
+// deviceError adds what to do about a failure to create the network
+// device, for the causes an operator can fix.
+func deviceError(name string, err error) error {
+	var hint string
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		hint = "run the agent as root or grant it CAP_NET_ADMIN"
+	case errors.Is(err, syscall.EBUSY), errors.Is(err, os.ErrExist):
+		hint = fmt.Sprintf("%s is in use; choose another name with -tap or remove a stale device with `zerogo-agent tap -cleanup`", name)
+	case errors.Is(err, os.ErrNotExist):
+		hint = "the TUN/TAP driver is missing; load it with `modprobe tun`"
+	}
+	if hint == "" {
+		return fmt.Errorf("create network device %s: %w", name, err)
+	}
+	return fmt.Errorf("create network device %s: %w (%s)", name, err, hint)
+}
+
+// deviceFailed records that the device for msg could not be created,
+// reports it to the controller and retries the whole config with backoff.
+// The caller holds configMu.
+func (c *ControllerClient) deviceFailed(msg *protocol.NetworkConfigMessage, err error) {
+	c.mu.Lock()
+	c.deviceErr = err.Error()
+	if c.deviceDelay == 0 {
+		c.deviceDelay = deviceRetryDelay
+	}
+	delay := c.deviceDelay
+	c.deviceDelay = min(c.deviceDelay*2, deviceMaxRetryDelay)
+	if c.deviceRetry != nil {
+		c.deviceRetry.Stop()
+	}
+	c.deviceRetry = time.AfterFunc(delay, func() {
+		if c.agent.ctx.Err() != nil {
+			return
+		}
+		c.handleNetworkConfig(msg)
+	})
+	c.mu.Unlock()
+
+	c.log.Error("network device unavailable", "err", err, "retry_in", delay)
+	c.SendStatus()
+}
+
+// deviceCreated clears a failure recorded by deviceFailed, if any. The
+// caller holds configMu.
+func (c *ControllerClient) deviceCreated() {
+	c.mu.Lock()
+	failed := c.deviceErr != ""
+	c.deviceErr = ""
+	c.deviceDelay = 0
+	if c.deviceRetry != nil {
+		c.deviceRetry.Stop()
+		c.deviceRetry = nil
+	}
+	c.mu.Unlock()
+
+	if failed {
+		c.log.Info("network device recovered")
+		c.SendStatus()
+	}
+}
+
+// deviceError returns why the network device could not be created, or ""
+// if it was or has not been tried.
+func (c *ControllerClient) deviceError() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deviceErr
+}
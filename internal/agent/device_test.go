@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/tap"
+)
+
+func TestDeviceErrorHints(t *testing.T) {
+	tests := []struct {
+		err  error
+		hint string
+	}{
+		{fmt.Errorf("open /dev/net/tun: %w", os.ErrPermission), "CAP_NET_ADMIN"},
+		{syscall.EBUSY, "zt-test is in use"},
+		{fmt.Errorf("open /dev/net/tun: %w", os.ErrNotExist), "modprobe tun"},
+		{errors.New("something else"), ""},
+	}
+	for _, tt := range tests {
+		err := deviceError("zt-test", tt.err)
+		if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), "zt-test") {
+			t.Errorf("deviceError(%v) = %v, want it wrapped with the device name", tt.err, err)
+		}
+		if tt.hint != "" && !strings.Contains(err.Error(), tt.hint) {
+			t.Errorf("deviceError(%v) = %v, want hint %q", tt.err, err, tt.hint)
+		}
+		if tt.hint == "" && strings.Contains(err.Error(), "(") {
+			t.Errorf("deviceError(%v) = %v, want no hint", tt.err, err)
+		}
+	}
+}
+
+func TestDeviceFailureReportedAndRetried(t *testing.T) {
+	useFakeDevices(t)
+	fake := openDevice
+	var attempts atomic.Int32
+	openDevice = func(a *Agent, name string) (tap.Device, error) {
+		if attempts.Add(1) <= 2 {
+			return nil, fmt.Errorf("open /dev/net/tun: %w", os.ErrPermission)
+		}
+		return fake(a, name)
+	}
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+	c := a.ctrlCli
+
+	// retryNow fires the pending retry instead of waiting out the backoff
+	retryNow := func(next time.Duration) {
+		t.Helper()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.deviceRetry == nil || c.deviceDelay != next {
+			t.Fatalf("retry = %v, next delay %v, want one pending with next delay %v", c.deviceRetry, c.deviceDelay, next)
+		}
+		c.deviceRetry.Reset(0)
+	}
+
+	c.handleNetworkConfig(&protocol.NetworkConfigMessage{
+		Type:       protocol.MsgTypeNetworkConfig,
+		NetworkID:  "1",
+		MTU:        1400,
+		PSK:        hex.EncodeToString(make([]byte, 32)),
+		AssignedIP: "10.1.0.2/24",
+	})
+	if a.tapDev != nil {
+		t.Fatal("device set after a failure")
+	}
+	if st := a.Status(); !strings.Contains(st.DeviceError, "CAP_NET_ADMIN") {
+		t.Fatalf("status device error = %q, want the failure with its hint", st.DeviceError)
+	}
+
+	// Each failed retry doubles the backoff; the agent keeps running
+	retryNow(2 * deviceRetryDelay)
+	waitFor(t, 2*time.Second, "the second attempt", func() bool { return attempts.Load() == 2 })
+	waitFor(t, 2*time.Second, "the next retry", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.deviceDelay == 4*deviceRetryDelay
+	})
+	if a.ctx.Err() != nil {
+		t.Fatal("agent stopped by the device failure")
+	}
+
+	retryNow(4 * deviceRetryDelay)
+	waitFor(t, 2*time.Second, "the device to be created", func() bool { return c.deviceError() == "" })
+	// The retry applies the rest of the config under configMu
+	c.configMu.Lock()
+	dev := a.tapDev
+	c.configMu.Unlock()
+	if _, ok := dev.(*fakeDevice); !ok {
+		t.Fatalf("device = %T after the retry", dev)
+	}
+	if st := a.Status(); len(st.AssignedIPs) != 1 || st.AssignedIPs[0] != "10.1.0.2/24" {
+		t.Fatalf("status addresses = %q, want the config applied", st.AssignedIPs)
+	}
+	c.mu.Lock()
+	delay, retry := c.deviceDelay, c.deviceRetry
+	c.mu.Unlock()
+	if delay != 0 || retry != nil {
+		t.Fatalf("backoff not reset: delay %v, retry %v", delay, retry)
+	}
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl2"
+)
+
+// parseDHCPRange parses a DHCP pool given as "first-last".
+func parseDHCPRange(s string) (netip.Addr, netip.Addr, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid DHCP range %q (want first-last)", s)
+	}
+	first, err1 := netip.ParseAddr(strings.TrimSpace(lo))
+	last, err2 := netip.ParseAddr(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil || last.Less(first) {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid DHCP range %q", s)
+	}
+	return first, last, nil
+}
+
+// enableDHCP starts the DHCP server configured by DHCPRange on the network.
+// assignedIP is this member's overlay IP/mask; router is an optional gateway
+// IP advertised to clients.
+func (a *Agent) enableDHCP(assignedIP, router string) error {
+	if a.tapDev.IsTUN() {
+		return fmt.Errorf("DHCP needs a TAP device")
+	}
+	prefix, err := netip.ParsePrefix(assignedIP)
+	if err != nil {
+		return fmt.Errorf("DHCP needs an overlay IP: %w", err)
+	}
+	first, last, err := parseDHCPRange(a.config.DHCPRange)
+	if err != nil {
+		return err
+	}
+	cfg := vl2.DHCPConfig{
+		ServerIP:  prefix.Addr(),
+		Subnet:    prefix.Masked(),
+		PoolStart: first,
+		PoolEnd:   last,
+	}
+	if router != "" {
+		ip, _, _ := strings.Cut(router, "/")
+		cfg.Router, _ = netip.ParseAddr(ip)
+	}
+	if err := a.network.EnableDHCP(cfg); err != nil {
+		return err
+	}
+	a.network.DHCP.SetInUse([]netip.Addr{prefix.Addr()})
+	a.log.Info("DHCP server enabled", "pool", a.config.DHCPRange, "server", prefix.Addr())
+	return nil
+}
+
+// serveDHCP answers a DHCP client message if this member serves DHCP. Replies
+// to hosts behind the local TAP are written to it; replies to remote hosts go
+// through the switch, which knows the client's peer from the request.
+func (a *Agent) serveDHCP(frame *vl2.EthernetFrame, remote bool) bool {
+	if a.network.DHCP == nil || !vl2.IsDHCPRequest(frame) {
+		return false
+	}
+	reply := a.network.DHCP.HandleDHCP(frame)
+	if reply == nil {
+		return true
+	}
+	if remote {
+		if err := a.network.Switch.HandleLocalFrame(reply); err != nil {
+			a.log.Debug("DHCP reply via switch", "err", err)
+		}
+	} else if _, err := a.tapDev.Write(reply); err != nil {
+		a.log.Debug("DHCP reply", "err", err)
+	}
+	return true
+}
+
+// applyDHCP starts DHCP on the first network config and keeps the addresses
+// the controller assigned to members out of the pool.
+func (c *ControllerClient) applyDHCP(msg *protocol.NetworkConfigMessage) {
+	a := c.agent
+	if a.config.DHCPRange == "" || a.network == nil {
+		return
+	}
+	if a.network.DHCP == nil {
+		if err := a.enableDHCP(msg.AssignedIP, msg.GatewayIP); err != nil {
+			c.log.Error("enable DHCP", "err", err)
+			return
+		}
+		if first, last, err := parseDHCPRange(a.config.DHCPRange); err == nil && !rangeReserved(first, last, msg.ReservedRanges) {
+			c.log.Warn("DHCP range is not a reserved range of the network; the controller may assign its addresses to members",
+				"range", a.config.DHCPRange)
+		}
+	}
+
+	inUse := make([]netip.Addr, 0, len(msg.Peers)+1)
+	for _, ip := range append([]string{msg.AssignedIP}, peerIPs(msg.Peers)...) {
+		if prefix, err := netip.ParsePrefix(ip); err == nil {
+			inUse = append(inUse, prefix.Addr())
+		}
+	}
+	a.network.DHCP.SetInUse(inUse)
+}
+
+func peerIPs(peers []protocol.PeerInfo) []string {
+	ips := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if p.IP != "" {
+			ips = append(ips, p.IP)
+		}
+	}
+	return ips
+}
+
+// rangeReserved reports whether first-last lies within one of the reserved
+// ranges (CIDRs or "first-last").
+func rangeReserved(first, last netip.Addr, reserved []string) bool {
+	for _, r := range reserved {
+		var lo, hi netip.Addr
+		if prefix, err := netip.ParsePrefix(r); err == nil {
+			lo = prefix.Masked().Addr()
+			b := lo.AsSlice()
+			for i := prefix.Bits(); i < len(b)*8; i++ {
+				b[i/8] |= 0x80 >> (i % 8)
+			}
+			hi, _ = netip.AddrFromSlice(b)
+		} else if lo, hi, err = parseDHCPRange(r); err != nil {
+			continue
+		}
+		if !first.Less(lo) && !hi.Less(last) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+const resolvConfHeader = "# Generated by zerogo-agent"
+
+// The resolv.conf the agent manages without systemd-resolved, and where the
+// original is kept meanwhile. Variables so tests can use temporary files.
+var (
+	resolvConfPath   = "/etc/resolv.conf"
+	resolvConfBackup = "/etc/resolv.conf.zerogo"
+)
+
+// DNS managers the agent can configure.
+const (
+	dnsManagerResolved   = "systemd-resolved"
+	dnsManagerResolvConf = "resolv.conf"
+)
+
+// dnsConfig is the DNS configuration a network pushes to its members.
+type dnsConfig struct {
+	Servers []string
+	Domains []string
+}
+
+func (d dnsConfig) empty() bool {
+	return len(d.Servers) == 0
+}
+
+func (d dnsConfig) equal(o dnsConfig) bool {
+	return slices.Equal(d.Servers, o.Servers) && slices.Equal(d.Domains, o.Domains)
+}
+
+// resolvectlCommands returns the resolvectl arguments that give dev
+// link-specific DNS. With search domains the link stops being a default
+// route, so only those domains are resolved via the network's servers
+// (split DNS); without, the servers take part in all lookups.
+func resolvectlCommands(dev string, cfg dnsConfig) [][]string {
+	cmds := [][]string{append([]string{"dns", dev}, cfg.Servers...)}
+	if len(cfg.Domains) > 0 {
+		cmds = append(cmds,
+			append([]string{"domain", dev}, cfg.Domains...),
+			[]string{"default-route", dev, "false"},
+		)
+	}
+	return cmds
+}
+
+// resolvectlRevert returns the resolvectl arguments that drop all DNS
+// settings of dev.
+func resolvectlRevert(dev string) []string {
+	return []string{"revert", dev}
+}
+
+// buildResolvConf returns orig with the network's servers placed ahead of
+// the existing nameservers and its search domains ahead of the existing
+// ones. resolv.conf has no per-interface servers, so unlike with
+// systemd-resolved the network's servers see all lookups.
+func buildResolvConf(orig, dev string, cfg dnsConfig) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s for %s; the original is in %s\n", resolvConfHeader, dev, resolvConfBackup)
+	for _, s := range cfg.Servers {
+		fmt.Fprintf(&b, "nameserver %s\n", s)
+	}
+	search := slices.Clone(cfg.Domains)
+	var rest []string
+	for _, line := range strings.Split(orig, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (fields[0] == "search" || fields[0] == "domain") {
+			// Only the last search or domain line counts; merge into ours
+			search = append(slices.Clone(cfg.Domains), fields[1:]...)
+			continue
+		}
+		rest = append(rest, line)
+	}
+	for i := len(rest) - 1; i >= 0 && strings.TrimSpace(rest[i]) == ""; i-- {
+		rest = rest[:i]
+	}
+	for _, line := range rest {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	return b.String()
+}
+
+func isManagedResolvConf(content []byte) bool {
+	return bytes.HasPrefix(content, []byte(resolvConfHeader))
+}
+
+// resolvedActive reports whether systemd-resolved is running and can be
+// configured with resolvectl.
+func resolvedActive() bool {
+	if _, err := exec.LookPath("resolvectl"); err != nil {
+		return false
+	}
+	fi, err := os.Stat("/run/systemd/resolve")
+	return err == nil && fi.IsDir()
+}
+
+func runResolvectl(args []string) error {
+	cmd := exec.Command("resolvectl", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("resolvectl %s: %w (stderr: %s)", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// writeResolvConf installs the network's DNS settings into resolv.conf,
+// saving the original for restoreResolvConf.
+func writeResolvConf(dev string, cfg dnsConfig) error {
+	cur, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return err
+	}
+	orig := cur
+	if isManagedResolvConf(cur) {
+		// Left behind by an earlier run; build on the saved original
+		if orig, err = os.ReadFile(resolvConfBackup); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(resolvConfBackup, cur, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(resolvConfPath, []byte(buildResolvConf(string(orig), dev, cfg)), 0o644)
+}
+
+// restoreResolvConf puts back the resolv.conf saved by writeResolvConf,
+// unless something else has rewritten the file since.
+func restoreResolvConf() error {
+	orig, err := os.ReadFile(resolvConfBackup)
+	if err != nil {
+		return err
+	}
+	if cur, err := os.ReadFile(resolvConfPath); err == nil && isManagedResolvConf(cur) {
+		if err := os.WriteFile(resolvConfPath, orig, 0o644); err != nil {
+			return err
+		}
+	}
+	return os.Remove(resolvConfBackup)
+}
+
+// applyDNS points the overlay interface at the network's DNS servers,
+// replacing what an earlier config applied.
+func (c *ControllerClient) applyDNS(msg *protocol.NetworkConfigMessage) {
+	a := c.agent
+	if a.tapDev == nil {
+		return
+	}
+	cfg := dnsConfig{Servers: msg.DNSServers, Domains: msg.SearchDomains}
+
+	c.dnsMu.Lock()
+	defer c.dnsMu.Unlock()
+	if cfg.equal(c.dns) {
+		return
+	}
+	c.revertDNSLocked()
+	if cfg.empty() {
+		return
+	}
+	if runtime.GOOS != "linux" {
+		c.log.Warn("network DNS servers are only applied on Linux", "servers", cfg.Servers)
+		return
+	}
+
+	dev := a.tapDev.Name()
+	if resolvedActive() {
+		for _, args := range resolvectlCommands(dev, cfg) {
+			if err := runResolvectl(args); err != nil {
+				c.log.Warn("configure link DNS", "err", err)
+				runResolvectl(resolvectlRevert(dev))
+				return
+			}
+		}
+		c.dnsManager = dnsManagerResolved
+	} else {
+		if err := writeResolvConf(dev, cfg); err != nil {
+			c.log.Warn("configure resolv.conf", "err", err)
+			return
+		}
+		c.dnsManager = dnsManagerResolvConf
+	}
+	c.dns = cfg
+	c.log.Info("network DNS configured", "servers", cfg.Servers, "domains", cfg.Domains, "via", c.dnsManager)
+}
+
+// cleanupDNS reverts the DNS configuration applied by applyDNS.
+func (c *ControllerClient) cleanupDNS() {
+	c.dnsMu.Lock()
+	defer c.dnsMu.Unlock()
+	c.revertDNSLocked()
+}
+
+func (c *ControllerClient) revertDNSLocked() {
+	switch c.dnsManager {
+	case dnsManagerResolved:
+		if a := c.agent; a.tapDev != nil {
+			if err := runResolvectl(resolvectlRevert(a.tapDev.Name())); err != nil {
+				c.log.Debug("revert link DNS", "err", err)
+			}
+		}
+	case dnsManagerResolvConf:
+		if err := restoreResolvConf(); err != nil {
+			c.log.Warn("restore resolv.conf", "err", err)
+		}
+	}
+	c.dns = dnsConfig{}
+	c.dnsManager = ""
+}
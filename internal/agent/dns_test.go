@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolvectlCommands(t *testing.T) {
+	// Servers alone take part in all lookups
+	got := resolvectlCommands("zt0", dnsConfig{Servers: []string{"10.1.0.53", "10.1.0.54"}})
+	want := [][]string{{"dns", "zt0", "10.1.0.53", "10.1.0.54"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+
+	// Search domains make it split DNS
+	got = resolvectlCommands("zt0", dnsConfig{Servers: []string{"10.1.0.53"}, Domains: []string{"corp.example", "lab.example"}})
+	want = [][]string{
+		{"dns", "zt0", "10.1.0.53"},
+		{"domain", "zt0", "corp.example", "lab.example"},
+		{"default-route", "zt0", "false"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("split DNS commands = %q, want %q", got, want)
+	}
+	if got := resolvectlRevert("zt0"); !reflect.DeepEqual(got, []string{"revert", "zt0"}) {
+		t.Errorf("revert = %q", got)
+	}
+}
+
+const testResolvConf = `# from DHCP
+nameserver 192.168.1.1
+options edns0
+search home.example
+
+`
+
+func TestBuildResolvConf(t *testing.T) {
+	got := buildResolvConf(testResolvConf, "zt0", dnsConfig{Servers: []string{"10.1.0.53"}, Domains: []string{"corp.example"}})
+	want := resolvConfHeader + " for zt0; the original is in " + resolvConfBackup + `
+nameserver 10.1.0.53
+# from DHCP
+nameserver 192.168.1.1
+options edns0
+search corp.example home.example
+`
+	if got != want {
+		t.Errorf("resolv.conf:\n%s\nwant:\n%s", got, want)
+	}
+	if !isManagedResolvConf([]byte(got)) || isManagedResolvConf([]byte(testResolvConf)) {
+		t.Error("managed resolv.conf not recognized")
+	}
+}
+
+func TestResolvConfRestore(t *testing.T) {
+	dir := t.TempDir()
+	resolvConfPath, resolvConfBackup = filepath.Join(dir, "resolv.conf"), filepath.Join(dir, "resolv.conf.zerogo")
+	t.Cleanup(func() { resolvConfPath, resolvConfBackup = "/etc/resolv.conf", "/etc/resolv.conf.zerogo" })
+	if err := os.WriteFile(resolvConfPath, []byte(testResolvConf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	read := func() string {
+		b, _ := os.ReadFile(resolvConfPath)
+		return string(b)
+	}
+
+	cfg := dnsConfig{Servers: []string{"10.1.0.53"}}
+	if err := writeResolvConf("zt0", cfg); err != nil {
+		t.Fatal(err)
+	}
+	// A second write, as after a crash, builds on the saved original
+	if err := writeResolvConf("zt0", cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read(), buildResolvConf(testResolvConf, "zt0", cfg); got != want {
+		t.Fatalf("managed resolv.conf:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := restoreResolvConf(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != testResolvConf {
+		t.Fatalf("restored resolv.conf:\n%s", got)
+	}
+	if _, err := os.Stat(resolvConfBackup); !os.IsNotExist(err) {
+		t.Fatal("backup left behind")
+	}
+
+	// A file rewritten by something else since is left alone
+	writeResolvConf("zt0", cfg)
+	os.WriteFile(resolvConfPath, []byte("nameserver 9.9.9.9\n"), 0o644)
+	if err := restoreResolvConf(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "nameserver 9.9.9.9\n" {
+		t.Fatalf("restore overwrote a foreign resolv.conf:\n%s", got)
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// dropLogInterval is how often drops with the same reason and source are
+// logged; the ones in between are only counted.
+const dropLogInterval = 10 * time.Second
+
+// dropLogMaxKeys bounds the (reason, source) pairs remembered, so a flood
+// from spoofed sources cannot grow the table without limit.
+const dropLogMaxKeys = 4096
+
+// Reasons the agent drops an incoming packet, as counted in its status.
+const (
+	dropDecode        = "decode"
+	dropUnknownType   = "unknown_type"
+	dropBadHandshake  = "bad_handshake"
+	dropInvalidKey    = "invalid_key"
+	dropRefusedPeer   = "refused_peer"
+	dropWrongNetwork  = "wrong_network"
+	dropUnknownPeer   = "unknown_peer"
+	dropDecrypt       = "decrypt"
+	dropNoNetwork     = "no_network"
+	dropSwitchRefused = "switch"
+
+	dropUnsupportedHandshake = "unsupported_handshake"
+	dropReplay               = "replay"
+	dropRoamLimited          = "roam_limited"
+	dropVersion              = "version"
+	dropClockSkew            = "clock_skew"
+)
+
+type dropKey struct {
+	reason string
+	source string
+}
+
+type dropEntry struct {
+	logged     time.Time
+	suppressed uint64
+}
+
+// dropLogger logs dropped packets on the receive path. Every drop is
+// counted, but each (reason, source) pair is logged at most once per
+// dropLogInterval, so a broken or hostile peer cannot flood the log while
+// an occasional drop still shows up at once.
+type dropLogger struct {
+	log *slog.Logger
+
+	mu      sync.Mutex
+	entries map[dropKey]*dropEntry
+	counts  map[string]uint64
+}
+
+func newDropLogger(log *slog.Logger) *dropLogger {
+	return &dropLogger{
+		log:     log,
+		entries: make(map[dropKey]*dropEntry),
+		counts:  make(map[string]uint64),
+	}
+}
+
+// Drop counts a packet from source dropped for reason and logs it at debug
+// level with msg and args unless one like it was logged recently.
+func (d *dropLogger) Drop(reason, source, msg string, args ...any) {
+	d.drop(slog.LevelDebug, reason, source, msg, args...)
+}
+
+// Warn is Drop for drops an operator needs to see to fix a misconfigured
+// node, such as a peer whose clock is too far off: it logs at warn level.
+func (d *dropLogger) Warn(reason, source, msg string, args ...any) {
+	d.drop(slog.LevelWarn, reason, source, msg, args...)
+}
+
+func (d *dropLogger) drop(level slog.Level, reason, source, msg string, args ...any) {
+	now := time.Now()
+	d.mu.Lock()
+	d.counts[reason]++
+	if !d.log.Enabled(context.Background(), level) {
+		d.mu.Unlock()
+		return
+	}
+	key := dropKey{reason, source}
+	e := d.entries[key]
+	if e == nil {
+		if len(d.entries) >= dropLogMaxKeys {
+			clear(d.entries)
+		}
+		e = &dropEntry{}
+		d.entries[key] = e
+	} else if now.Sub(e.logged) < dropLogInterval {
+		e.suppressed++
+		d.mu.Unlock()
+		return
+	}
+	suppressed := e.suppressed
+	e.logged, e.suppressed = now, 0
+	d.mu.Unlock()
+
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	d.log.Log(context.Background(), level, msg, args...)
+}
+
+// Counts returns the number of packets dropped for each reason.
+func (d *dropLogger) Counts() map[string]uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	counts := make(map[string]uint64, len(d.counts))
+	for reason, n := range d.counts {
+		counts[reason] = n
+	}
+	return counts
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDropLoggerSamplesBursts(t *testing.T) {
+	var logs bytes.Buffer
+	d := newDropLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	lines := func() int { return strings.Count(logs.String(), "\n") }
+
+	for range 1000 {
+		d.Drop(dropDecrypt, "203.0.113.5", "decrypt failed")
+	}
+	if n := lines(); n != 1 {
+		t.Fatalf("%d log lines for a burst, want 1:\n%s", n, logs.String())
+	}
+	if n := d.Counts()[dropDecrypt]; n != 1000 {
+		t.Fatalf("counted %d drops, want 1000", n)
+	}
+
+	// A rare drop of another kind or from another source still shows at once
+	d.Drop(dropDecrypt, "198.51.100.9", "decrypt failed")
+	d.Drop(dropUnknownPeer, "203.0.113.5", "data from unknown peer")
+	if n := lines(); n != 3 {
+		t.Fatalf("%d log lines, want 3:\n%s", n, logs.String())
+	}
+
+	// After the interval the next drop is logged with the suppressed count
+	d.mu.Lock()
+	d.entries[dropKey{dropDecrypt, "203.0.113.5"}].logged = time.Now().Add(-dropLogInterval)
+	d.mu.Unlock()
+	d.Drop(dropDecrypt, "203.0.113.5", "decrypt failed")
+	if n := lines(); n != 4 || !strings.Contains(logs.String(), "suppressed=999") {
+		t.Fatalf("%d log lines, want a 4th with suppressed=999:\n%s", n, logs.String())
+	}
+	if n := d.Counts()[dropDecrypt]; n != 1002 {
+		t.Fatalf("counted %d decrypt drops, want 1002", n)
+	}
+}
+
+func TestDropLoggerCountsWhenQuiet(t *testing.T) {
+	var logs bytes.Buffer
+	d := newDropLogger(slog.New(slog.NewTextHandler(&logs, nil))) // info and up
+	for range 10 {
+		d.Drop(dropDecode, "203.0.113.5", "decode packet")
+	}
+	d.Warn(dropClockSkew, "203.0.113.5", "hello rejected")
+	d.Warn(dropClockSkew, "203.0.113.5", "hello rejected")
+
+	if n := strings.Count(logs.String(), "\n"); n != 1 || !strings.Contains(logs.String(), "level=WARN") {
+		t.Fatalf("log:\n%s\nwant only the first clock skew warning", logs.String())
+	}
+	if c := d.Counts(); c[dropDecode] != 10 || c[dropClockSkew] != 2 {
+		t.Fatalf("counts = %v", c)
+	}
+	if len(d.entries) != 1 {
+		t.Fatalf("%d entries kept for unlogged drops", len(d.entries))
+	}
+}
+
+func TestDropLoggerBoundsSources(t *testing.T) {
+	d := newDropLogger(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	for i := range dropLogMaxKeys + 10 {
+		d.Drop(dropDecode, "10.0.0."+strconv.Itoa(i), "decode packet")
+	}
+	if n := len(d.entries); n > dropLogMaxKeys {
+		t.Fatalf("%d entries, want at most %d", n, dropLogMaxKeys)
+	}
+}
+
+func TestGarbageCountedInStatus(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	a := newTestAgent(t, trA)
+	attacker := mn.listen(t, "203.0.113.5:666")
+	for range 50 {
+		if err := attacker.SendTo([]byte{0xde, 0xad}, trA.addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, time.Second, "the drops to be counted", func() bool {
+		st := a.Status()
+		return st.Transport != nil && st.Transport.Drops[dropDecode] == 50
+	})
+}
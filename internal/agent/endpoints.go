@@ -0,0 +1,270 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// localEndpoints returns the endpoints reported to the controller: the bare
+// listen port (the controller fills in the observed address), the gateway
+// port mapping if any, then the port on every usable LAN address, so peers
+// behind the same NAT can connect over private addresses.
+func (a *Agent) localEndpoints() []string {
+	port := a.transport.Port()
+	endpoints := []string{fmt.Sprintf(":%d", port)}
+
+	if pm := a.portmap.Load(); pm != nil {
+		if ext := pm.External(); ext != nil {
+			endpoints = append(endpoints, ext.String())
+		}
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		a.log.Debug("list interfaces", "err", err)
+		return endpoints
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		// Never advertise addresses on our own virtual device.
+		if iface.Name == a.config.TAPName {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !usableEndpointIP(ipNet.IP) {
+				continue
+			}
+			endpoints = append(endpoints, net.JoinHostPort(ipNet.IP.String(), strconv.Itoa(port)))
+		}
+	}
+	return endpoints
+}
+
+// usableEndpointIP reports whether ip can reach peers on another host.
+func usableEndpointIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified()
+}
+
+// peerCandidates resolves a peer's advertised endpoints into the addresses
+// worth saying hello to, in order and without duplicates. Loopback and
+// unspecified addresses and this node's own endpoints are dropped; reject is
+// called with each dropped endpoint and why.
+func peerCandidates(endpoints []string, self map[string]bool, reject func(ep, reason string)) []*net.UDPAddr {
+	var candidates []*net.UDPAddr
+	seen := make(map[string]bool)
+	for _, ep := range endpoints {
+		resolved, err := net.ResolveUDPAddr("udp", ep)
+		switch {
+		case err != nil || resolved.IP == nil:
+			reject(ep, "unresolvable")
+			continue
+		case resolved.IP.IsLoopback():
+			reject(ep, "loopback")
+			continue
+		case resolved.IP.IsUnspecified():
+			reject(ep, "unspecified")
+			continue
+		case resolved.Port == 0:
+			reject(ep, "no port")
+			continue
+		}
+		key := resolved.String()
+		if self[key] {
+			reject(ep, "own endpoint")
+			continue
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		candidates = append(candidates, resolved)
+	}
+	return candidates
+}
+
+// selfEndpoints returns this node's own reported endpoints, which a peer's
+// advertised endpoints must never point at.
+func (a *Agent) selfEndpoints() map[string]bool {
+	self := make(map[string]bool)
+	for _, ep := range a.localEndpoints() {
+		if resolved, err := net.ResolveUDPAddr("udp", ep); err == nil && resolved.IP != nil {
+			self[resolved.String()] = true
+		}
+	}
+	return self
+}
+
+// resolvePeerEndpoints returns the hello candidates for a peer, logging the
+// endpoints it skips.
+func (c *ControllerClient) resolvePeerEndpoints(peer string, endpoints []string) []*net.UDPAddr {
+	return peerCandidates(endpoints, c.agent.selfEndpoints(), func(ep, reason string) {
+		c.log.Debug("peer endpoint rejected", "peer", peer, "endpoint", ep, "reason", reason)
+	})
+}
+
+// Address families for Config.PreferFamily.
+const (
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// familyStagger is how long hellos to the other address family wait behind
+// the first (happy eyeballs, RFC 8305): a working first family wins without
+// racing, and a broken one delays the connection by only this much.
+const familyStagger = 250 * time.Millisecond
+
+// orderByFamily puts the preferred address family first, keeping the
+// advertised order within each family.
+func orderByFamily(candidates []*net.UDPAddr, preferV4 bool) []*net.UDPAddr {
+	ordered := make([]*net.UDPAddr, 0, len(candidates))
+	for _, first := range []bool{true, false} {
+		for _, ep := range candidates {
+			if (ep.IP.To4() != nil == preferV4) == first {
+				ordered = append(ordered, ep)
+			}
+		}
+	}
+	return ordered
+}
+
+// splitByFamily separates the candidates in the family of the first one
+// from the rest.
+func splitByFamily(candidates []*net.UDPAddr) (lead, rest []*net.UDPAddr) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	v4 := candidates[0].IP.To4() != nil
+	for _, ep := range candidates {
+		if ep.IP.To4() != nil == v4 {
+			lead = append(lead, ep)
+		} else {
+			rest = append(rest, ep)
+		}
+	}
+	return lead, rest
+}
+
+// endpointProbeWindow bounds how long replies from candidate endpoints are
+// raced against each other before normal endpoint roaming resumes.
+const endpointProbeWindow = 5 * time.Second
+
+// endpointProbe tracks one in-flight endpoint selection for a peer.
+type endpointProbe struct {
+	until    time.Time
+	mu       sync.Mutex
+	chosen   *net.UDPAddr
+	answered map[string]bool // addresses the peer answered from
+}
+
+// probeEndpoints says hello to every candidate and keeps the first one the
+// peer answers from, resending within the probe window until then. The
+// first round reaches the family of the first candidate at once and the
+// other family familyStagger later. Candidates the peer never answered from
+// are recorded as failed, so later attempts try them last or skip them
+// until they are due for a re-probe.
+func (c *ControllerClient) probeEndpoints(peer *vl1.Peer, candidates []*net.UDPAddr) {
+	a := c.agent
+	probe := &endpointProbe{until: time.Now().Add(endpointProbeWindow), answered: make(map[string]bool)}
+	a.probes.Store(peer.Address, probe)
+	defer a.probes.CompareAndDelete(peer.Address, probe)
+	defer func() {
+		probe.mu.Lock()
+		defer probe.mu.Unlock()
+		for _, ep := range candidates {
+			if !probe.answered[ep.String()] {
+				peer.EndpointFailed(ep)
+			}
+		}
+	}()
+
+	hellos := a.helloPackets(peer)
+	send := func(eps []*net.UDPAddr) {
+		for _, ep := range eps {
+			for _, hello := range hellos {
+				if err := a.transport.SendTo(hello, ep); err != nil {
+					c.log.Debug("probe send failed", "peer", peer.Address, "endpoint", ep, "err", err)
+				}
+			}
+		}
+		peer.MarkSent()
+	}
+	selected := func(chosen *net.UDPAddr) {
+		c.log.Info("peer endpoint selected", "peer", peer.Address, "endpoint", chosen, "candidates", len(candidates))
+	}
+
+	lead, rest := splitByFamily(candidates)
+	for round := 0; time.Now().Before(probe.until); round++ {
+		send(lead)
+		if round == 0 && len(rest) > 0 {
+			chosen, ok := a.awaitProbe(probe, familyStagger)
+			if !ok {
+				return
+			}
+			if chosen != nil {
+				selected(chosen)
+				return
+			}
+		}
+		send(rest)
+
+		chosen, ok := a.awaitProbe(probe, a.peers.Timers().HandshakeRetryInterval)
+		if !ok {
+			return
+		}
+		if chosen != nil {
+			selected(chosen)
+			return
+		}
+	}
+	c.log.Debug("no endpoint answered", "peer", peer.Address, "candidates", len(candidates))
+}
+
+// awaitProbe waits d and returns the endpoint the probe chose, if any; ok
+// is false if the agent is stopping.
+func (a *Agent) awaitProbe(probe *endpointProbe, d time.Duration) (chosen *net.UDPAddr, ok bool) {
+	select {
+	case <-a.ctx.Done():
+		return nil, false
+	case <-time.After(d):
+	}
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	return probe.chosen, true
+}
+
+// acceptProbeReply reports whether a hello from addr may move the peer's
+// endpoint. During a probe only the first answering address is accepted.
+func (a *Agent) acceptProbeReply(peer *vl1.Peer, addr *net.UDPAddr) bool {
+	v, ok := a.probes.Load(peer.Address)
+	if !ok {
+		return true
+	}
+	probe := v.(*endpointProbe)
+	if time.Now().After(probe.until) {
+		return true
+	}
+
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	probe.answered[addr.String()] = true
+	if probe.chosen == nil {
+		probe.chosen = addr
+		return true
+	}
+	return probe.chosen.String() == addr.String()
+}
@@ -0,0 +1,314 @@
+package agent
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+func TestUsableEndpointIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.168.1.10", true},
+		{"10.0.0.1", true},
+		{"203.0.113.7", true},
+		{"fd00::1", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"169.254.10.1", false},
+		{"fe80::1", false},
+		{"224.0.0.251", false},
+		{"0.0.0.0", false},
+		{"::", false},
+	}
+	for _, tt := range tests {
+		if got := usableEndpointIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("usableEndpointIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestPeerCandidates(t *testing.T) {
+	self := map[string]bool{"192.168.1.2:9993": true}
+	rejected := make(map[string]string)
+	got := peerCandidates([]string{
+		"192.168.1.10:9993",
+		"127.0.0.1:9993",
+		"0.0.0.0:9993",
+		":9993",
+		"192.168.1.10",
+		"bogus",
+		"192.168.1.2:9993",
+		"203.0.113.7:41000",
+		"192.168.1.10:9993",
+	}, self, func(ep, reason string) { rejected[ep] = reason })
+
+	var eps []string
+	for _, ep := range got {
+		eps = append(eps, ep.String())
+	}
+	if want := []string{"192.168.1.10:9993", "203.0.113.7:41000"}; !reflect.DeepEqual(eps, want) {
+		t.Errorf("candidates = %v, want %v", eps, want)
+	}
+	for ep, reason := range map[string]string{
+		"127.0.0.1:9993":   "loopback",
+		"0.0.0.0:9993":     "unspecified",
+		":9993":            "unresolvable",
+		"192.168.1.10":     "unresolvable",
+		"bogus":            "unresolvable",
+		"192.168.1.2:9993": "own endpoint",
+	} {
+		if rejected[ep] != reason {
+			t.Errorf("%s rejected as %q, want %q", ep, rejected[ep], reason)
+		}
+	}
+}
+
+func TestLocalEndpointsSkipUnusableAddresses(t *testing.T) {
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+
+	endpoints := a.localEndpoints()
+	if len(endpoints) == 0 || endpoints[0] != ":9993" {
+		t.Fatalf("endpoints = %v, want the bare port first", endpoints)
+	}
+	for _, ep := range endpoints[1:] {
+		addr, err := net.ResolveUDPAddr("udp", ep)
+		if err != nil {
+			t.Fatalf("endpoint %q: %v", ep, err)
+		}
+		if !usableEndpointIP(addr.IP) || addr.Port != 9993 {
+			t.Errorf("unusable endpoint %s reported", ep)
+		}
+	}
+}
+
+func TestAddPeerTriesEveryCandidate(t *testing.T) {
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+	b := newTestAgent(t, mn.listen(t, "192.0.2.2:9993"))
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	stopTestAgent(t, b) // nobody answers, so the probe keeps every candidate
+
+	lan := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 9993}
+	public := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 41000}
+	a.ctrlCli.addPeerFromInfo(testNetwork, peerInfo(b, lan.String(), "127.0.0.1:9993", public.String()))
+
+	waitFor(t, 2*time.Second, "hellos to both candidates", func() bool {
+		return len(mn.sentTo(lan)) > 0 && len(mn.sentTo(public)) > 0
+	})
+	if n := len(mn.sentTo(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9993})); n != 0 {
+		t.Errorf("%d hellos sent to a loopback candidate", n)
+	}
+}
+
+func TestAddPeerSkipsDeadFirstEndpoint(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "198.51.100.7:41000")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+
+	// b's first advertised endpoint is a stale LAN address nobody listens on
+	dead := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 9993}
+	a.ctrlCli.addPeerFromInfo(testNetwork, peerInfo(b, dead.String(), trB.addr.String()))
+	peer := a.peers.GetPeer(b.identity.Address)
+	if peer == nil {
+		t.Fatal("peer not added")
+	}
+
+	waitFor(t, 2*time.Second, "the peer to connect", func() bool {
+		peerOfB := b.peers.GetPeer(a.identity.Address)
+		return peer.IsConnected() && peerOfB != nil && peerOfB.IsConnected()
+	})
+	if ep := peer.Endpoint; ep.String() != trB.addr.String() {
+		t.Fatalf("connected via %v, want %v", ep, trB.addr)
+	}
+	if _, err := a.Ping(t.Context(), b.identity.Address); err != nil {
+		t.Fatalf("ping via the second endpoint: %v", err)
+	}
+}
+
+func TestAddPeerFromInfoSkipsSelfAndLoopback(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "198.51.100.7:41000")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+
+	// A misconfigured peer advertises garbage ahead of its real endpoint,
+	// which it also lists twice
+	bad := []string{"127.0.0.1:9993", "[::1]:9993", "0.0.0.0:9993"}
+	if own := a.localEndpoints(); len(own) > 1 {
+		bad = append(bad, own[1]) // one of a's interface addresses
+	}
+	a.ctrlCli.addPeerFromInfo(testNetwork, peerInfo(b, append(bad, trB.addr.String(), trB.addr.String())...))
+
+	peer := a.peers.GetPeer(b.identity.Address)
+	if peer == nil {
+		t.Fatal("peer not added")
+	}
+	if ep := peer.Endpoint; ep.String() != trB.addr.String() {
+		t.Fatalf("peer endpoint = %v, want %v", ep, trB.addr)
+	}
+	waitFor(t, 2*time.Second, "the peer to connect", peer.IsConnected)
+	for _, ep := range bad {
+		addr, err := net.ResolveUDPAddr("udp", ep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(mn.sentTo(addr)); n != 0 {
+			t.Errorf("%d packets sent to rejected endpoint %s", n, ep)
+		}
+	}
+}
+
+func TestReconnectTriesProvenEndpointFirst(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "198.51.100.7:41000")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	dead := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 9993}
+	a.peers.SetTimers(vl1.Timers{HandshakeRetryInterval: 100 * time.Millisecond}) // probe rounds
+
+	// The first connection finds out which endpoint works
+	a.ctrlCli.addPeerFromInfo(testNetwork, peerInfo(b, dead.String(), trB.addr.String()))
+	peer := a.peers.GetPeer(b.identity.Address)
+	waitFor(t, 2*time.Second, "the probe to finish", func() bool {
+		_, probing := a.probes.Load(b.identity.Address)
+		return peer.IsConnected() && !probing
+	})
+	states := peer.EndpointStates()
+	if st := states[trB.addr.String()]; st.LastSuccess.IsZero() || st.Failures != 0 {
+		t.Fatalf("working endpoint state = %+v", st)
+	}
+	if st := states[dead.String()]; st.Failures != 1 {
+		t.Fatalf("dead endpoint state = %+v", st)
+	}
+
+	// On reconnection the working endpoint goes first, ahead of a new
+	// candidate, and the dead one waits for its re-probe
+	peer.MarkDead()
+	fresh := &net.UDPAddr{IP: net.ParseIP("192.168.1.11"), Port: 9993}
+	sentToDead := len(mn.sentTo(dead))
+	start := time.Now()
+	a.ctrlCli.addPeerFromInfo(testNetwork, peerInfo(b, dead.String(), fresh.String(), trB.addr.String()))
+	if ep := peer.Endpoint; ep.String() != trB.addr.String() {
+		t.Fatalf("reconnecting via %v, want %v", ep, trB.addr)
+	}
+	waitFor(t, 2*time.Second, "the peer to reconnect", peer.IsConnected)
+
+	first := func(addr *net.UDPAddr) time.Time {
+		for _, p := range mn.sentTo(addr) {
+			if p.at.After(start) && p.from.String() == trA.addr.String() {
+				return p.at
+			}
+		}
+		return time.Time{}
+	}
+	if good, other := first(trB.addr), first(fresh); good.IsZero() || (!other.IsZero() && other.Before(good)) {
+		t.Errorf("first hello to the working endpoint at %v, to the new one at %v", good, other)
+	}
+	if n := len(mn.sentTo(dead)) - sentToDead; n != 0 {
+		t.Errorf("%d packets sent to the failed endpoint before its re-probe", n)
+	}
+}
+
+func TestOrderByFamily(t *testing.T) {
+	addrs := func(eps ...string) []*net.UDPAddr {
+		var out []*net.UDPAddr
+		for _, ep := range eps {
+			out = append(out, net.UDPAddrFromAddrPort(netip.MustParseAddrPort(ep)))
+		}
+		return out
+	}
+	strs := func(eps []*net.UDPAddr) []string {
+		var out []string
+		for _, ep := range eps {
+			out = append(out, ep.String())
+		}
+		return out
+	}
+	candidates := addrs("192.0.2.1:9993", "[2001:db8::1]:9993", "198.51.100.7:9993", "[2001:db8::2]:9993")
+
+	if got, want := strs(orderByFamily(candidates, false)), []string{"[2001:db8::1]:9993", "[2001:db8::2]:9993", "192.0.2.1:9993", "198.51.100.7:9993"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv6 first = %v, want %v", got, want)
+	}
+	ordered := orderByFamily(candidates, true)
+	if got, want := strs(ordered), []string{"192.0.2.1:9993", "198.51.100.7:9993", "[2001:db8::1]:9993", "[2001:db8::2]:9993"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("IPv4 first = %v, want %v", got, want)
+	}
+	lead, rest := splitByFamily(ordered)
+	if len(lead) != 2 || len(rest) != 2 || lead[0].IP.To4() == nil || rest[0].IP.To4() != nil {
+		t.Errorf("split = %v / %v", strs(lead), strs(rest))
+	}
+	if lead, rest := splitByFamily(addrs("[2001:db8::1]:9993")); len(lead) != 1 || rest != nil {
+		t.Errorf("single family split = %v / %v", strs(lead), strs(rest))
+	}
+}
+
+func TestHappyEyeballsFallsBackToIPv4(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "198.51.100.7:41000")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+
+	// IPv6 is preferred, but b's IPv6 endpoint is unreachable
+	deadV6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 9993}
+	start := time.Now()
+	a.ctrlCli.addPeerFromInfo(testNetwork, peerInfo(b, trB.addr.String(), deadV6.String()))
+	peer := a.peers.GetPeer(b.identity.Address)
+	if peer == nil {
+		t.Fatal("peer not added")
+	}
+	waitFor(t, time.Second, "the peer to connect over IPv4", peer.IsConnected)
+	if took := time.Since(start); took > familyStagger+500*time.Millisecond {
+		t.Errorf("connecting took %v", took)
+	}
+	if ep := peer.Endpoint; ep.String() != trB.addr.String() {
+		t.Fatalf("connected via %v, want %v", ep, trB.addr)
+	}
+
+	v6, v4 := mn.sentTo(deadV6), mn.sentTo(trB.addr)
+	if len(v6) == 0 || len(v4) == 0 {
+		t.Fatalf("%d hellos to IPv6, %d to IPv4", len(v6), len(v4))
+	}
+	if gap := v4[0].at.Sub(v6[0].at); gap < familyStagger*4/5 {
+		t.Errorf("IPv4 hello %v after the IPv6 one, want about %v", gap, familyStagger)
+	}
+}
+
+func TestPreferIPv4SkipsStagger(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "198.51.100.7:41000")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	a.config.PreferFamily = FamilyIPv4
+
+	deadV6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 9993}
+	a.ctrlCli.addPeerFromInfo(testNetwork, peerInfo(b, deadV6.String(), trB.addr.String()))
+	peer := a.peers.GetPeer(b.identity.Address)
+	if peer == nil {
+		t.Fatal("peer not added")
+	}
+	waitFor(t, time.Second, "the peer to connect over IPv4", peer.IsConnected)
+
+	// IPv4 goes first; IPv6 would only follow after the stagger
+	v4, v6 := mn.sentTo(trB.addr), mn.sentTo(deadV6)
+	if len(v4) == 0 {
+		t.Fatal("no hello to IPv4")
+	}
+	if len(v6) > 0 && v6[0].at.Sub(v4[0].at) < familyStagger*4/5 {
+		t.Errorf("IPv6 hello %v after the IPv4 one, want no sooner than %v", v6[0].at.Sub(v4[0].at), familyStagger)
+	}
+}
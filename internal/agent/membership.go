@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// networkMembers tracks which peers the controller placed in which network,
+// so a network's frames only go to its own members. The TAP readers consult
+// it while the controller client updates it.
+type networkMembers struct {
+	mu    sync.RWMutex
+	peers map[uint32]map[identity.Address]struct{} // network ID → members
+}
+
+// set replaces the members of a network, as listed in its config.
+func (m *networkMembers) set(networkID uint32, addrs []identity.Address) {
+	members := make(map[identity.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		members[addr] = struct{}{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.peers == nil {
+		m.peers = make(map[uint32]map[identity.Address]struct{})
+	}
+	m.peers[networkID] = members
+}
+
+func (m *networkMembers) add(networkID uint32, addr identity.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.peers == nil {
+		m.peers = make(map[uint32]map[identity.Address]struct{})
+	}
+	if m.peers[networkID] == nil {
+		m.peers[networkID] = make(map[identity.Address]struct{})
+	}
+	m.peers[networkID][addr] = struct{}{}
+}
+
+// remove takes addr out of a network and reports whether it is still a
+// member of another one.
+func (m *networkMembers) remove(networkID uint32, addr identity.Address) (elsewhere bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.peers[networkID], addr)
+	return m.inAnyLocked(addr)
+}
+
+// removeNetwork forgets a network and its members.
+func (m *networkMembers) removeNetwork(networkID uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.peers, networkID)
+}
+
+// inAny reports whether addr is a member of any network.
+func (m *networkMembers) inAny(addr identity.Address) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.inAnyLocked(addr)
+}
+
+func (m *networkMembers) inAnyLocked(addr identity.Address) bool {
+	for _, members := range m.peers {
+		if _, ok := members[addr]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *networkMembers) contains(networkID uint32, addr identity.Address) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.peers[networkID][addr]
+	return ok
+}
+
+// inNetwork reports whether frames of networkID may go to addr. Without a
+// controller there is a single network of which every peer is a member.
+func (a *Agent) inNetwork(networkID uint32, addr identity.Address) bool {
+	return a.ctrlCli == nil || a.members.contains(networkID, addr)
+}
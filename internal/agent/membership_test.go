@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+func TestNetworkMembers(t *testing.T) {
+	var m networkMembers
+	b, c := identity.Address{2}, identity.Address{3}
+	m.set(1, []identity.Address{b, c})
+	m.add(2, c)
+
+	if !m.contains(1, b) || !m.contains(2, c) || m.contains(2, b) {
+		t.Fatalf("members = %v", m.peers)
+	}
+	if !m.remove(1, c) {
+		t.Error("c still in network 2 reported as in no network")
+	}
+	if m.remove(1, b) || m.inAny(b) {
+		t.Error("b still reported in a network")
+	}
+	m.removeNetwork(2)
+	if m.inAny(c) {
+		t.Error("c still a member after its network was removed")
+	}
+}
+
+func TestBroadcastStaysInNetwork(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	trC := mn.listen(t, "192.0.2.3:9993")
+	a, b, c := newTestAgent(t, trA), newTestAgent(t, trB), newTestAgent(t, trC)
+	joinNetwork(testNetwork, [32]byte{1}, a, b, c)
+	connectPair(t, a, b)
+	connectPair(t, a, c)
+
+	// c moves to another network; a and b stay in the test network
+	const otherNetwork = 2
+	a.members.set(testNetwork, []identity.Address{b.identity.Address})
+	a.members.add(otherNetwork, c.identity.Address)
+
+	sentSince := func(to *net.UDPAddr, since time.Time) int {
+		n := 0
+		for _, p := range mn.sentTo(to) {
+			if p.at.After(since) && p.from.String() == trA.addr.String() {
+				n++
+			}
+		}
+		return n
+	}
+	frame := make([]byte, 60) // the agent forwards frames without parsing them
+	start := time.Now()
+	if err := a.BroadcastToPeers(testNetwork, frame, identity.Address{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := sentSince(trB.addr, start); n != 1 {
+		t.Errorf("%d packets to the member, want 1", n)
+	}
+	if n := sentSince(trC.addr, start); n != 0 {
+		t.Errorf("%d packets to a peer only in another network", n)
+	}
+
+	if err := a.SendToPeer(c.identity.Address, testNetwork, frame); err == nil {
+		t.Error("unicast to a peer outside the network sent")
+	}
+	if err := a.SendToPeer(b.identity.Address, testNetwork, frame); err != nil {
+		t.Errorf("unicast to a member: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// DefaultUnderlayMTU is the path MTU assumed to peers, that of Ethernet.
+const DefaultUnderlayMTU = 1500
+
+// Headers the underlay and the device add around each overlay packet.
+const (
+	ipv4HeaderSize     = 20
+	ipv6HeaderSize     = 40
+	udpHeaderSize      = 8
+	ethernetHeaderSize = 14
+)
+
+// TunnelMTU returns the largest device MTU whose packets, once carried by
+// VL1, still fit in a single underlay packet. TAP frames also carry their
+// Ethernet header; TUN packets do not.
+func TunnelMTU(underlayMTU int, ipv6, tun bool) int {
+	overhead := udpHeaderSize + vl1.DataOverhead
+	if ipv6 {
+		overhead += ipv6HeaderSize
+	} else {
+		overhead += ipv4HeaderSize
+	}
+	if !tun {
+		overhead += ethernetHeaderSize
+	}
+	return underlayMTU - overhead
+}
+
+// deviceMTU clamps the requested device MTU to what fits the underlay.
+// The transport is dual-stack, so the clamp assumes the larger IPv6 header.
+func (a *Agent) deviceMTU(requested int) int {
+	if requested == 0 {
+		requested = protocol.DefaultMTU
+	}
+	if a.config.NoMTUClamp {
+		return requested
+	}
+	underlay := a.config.UnderlayMTU
+	if underlay == 0 {
+		underlay = DefaultUnderlayMTU
+	}
+	limit := TunnelMTU(underlay, true, a.config.TUNMode)
+	if requested > limit {
+		a.log.Info("clamping device MTU to fit the underlay", "requested", requested, "mtu", limit, "underlay_mtu", underlay)
+		return limit
+	}
+	return requested
+}
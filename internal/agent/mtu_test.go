@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+func TestTunnelMTU(t *testing.T) {
+	tests := []struct {
+		underlay  int
+		ipv6, tun bool
+		want      int
+	}{
+		{1500, false, false, 1426}, // 20 IPv4 + 8 UDP + 32 VL1 + 14 Ethernet
+		{1500, false, true, 1440},
+		{1500, true, false, 1406}, // 40 IPv6 instead of 20 IPv4
+		{1500, true, true, 1420},
+		{9000, true, false, 8906},
+		{1280, true, true, 1200}, // the IPv6 minimum
+	}
+	for _, tt := range tests {
+		if got := TunnelMTU(tt.underlay, tt.ipv6, tt.tun); got != tt.want {
+			t.Errorf("TunnelMTU(%d, ipv6=%v, tun=%v) = %d, want %d", tt.underlay, tt.ipv6, tt.tun, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceMTU(t *testing.T) {
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+
+	tests := []struct {
+		name      string
+		underlay  int
+		tun, keep bool
+		requested int
+		want      int
+	}{
+		{"default network MTU", 0, false, false, 0, 1406},
+		{"large network MTU", 1500, false, false, 2800, 1406},
+		{"small network MTU", 1500, false, false, 1200, 1200},
+		{"TUN mode", 1500, true, false, 2800, 1420},
+		{"jumbo underlay", 9000, false, false, 2800, 2800},
+		{"clamp disabled", 1500, false, true, 2800, 2800},
+		{"clamp disabled, default", 1500, false, true, 0, 2800},
+	}
+	for _, tt := range tests {
+		a.config.UnderlayMTU, a.config.TUNMode, a.config.NoMTUClamp = tt.underlay, tt.tun, tt.keep
+		if got := a.deviceMTU(tt.requested); got != tt.want {
+			t.Errorf("%s: deviceMTU(%d) = %d, want %d", tt.name, tt.requested, got, tt.want)
+		}
+	}
+}
+
+func TestClampedFrameFitsUnderlay(t *testing.T) {
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+	b := newTestAgent(t, mn.listen(t, "192.0.2.2:9993"))
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	peer, _ := connectPair(t, a, b)
+
+	// A full-size TAP frame, encrypted, in an IPv6 UDP datagram
+	frame := make([]byte, TunnelMTU(DefaultUnderlayMTU, true, false)+ethernetHeaderSize)
+	buf := make([]byte, vl1.MaxPacketSize)
+	hdr := make([]byte, vl1.HeaderSize)
+	n, err := peer.EncryptTo(testNetwork, buf, frame, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := ipv6HeaderSize + udpHeaderSize + vl1.HeaderSize + n; size != DefaultUnderlayMTU {
+		t.Fatalf("underlay packet of %d bytes, want exactly %d", size, DefaultUnderlayMTU)
+	}
+}
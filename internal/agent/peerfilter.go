@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// peerFilter decides which public keys may connect to the agent. A denied
+// key is always refused; with an allow-list, so is every key not on it.
+// Without either list any key may connect.
+type peerFilter struct {
+	allow map[[32]byte]bool // nil allows any key not denied
+	deny  map[[32]byte]bool
+}
+
+// newPeerFilter parses the hex public keys of Config.AllowPeers and
+// Config.DenyPeers.
+func newPeerFilter(allow, deny []string) (*peerFilter, error) {
+	f := &peerFilter{deny: make(map[[32]byte]bool, len(deny))}
+	if len(allow) > 0 {
+		f.allow = make(map[[32]byte]bool, len(allow))
+	}
+	for _, k := range allow {
+		key, err := parsePeerKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("allowed peer %q: %w", k, err)
+		}
+		f.allow[key] = true
+	}
+	for _, k := range deny {
+		key, err := parsePeerKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("denied peer %q: %w", k, err)
+		}
+		f.deny[key] = true
+	}
+	return f, nil
+}
+
+func parsePeerKey(s string) ([32]byte, error) {
+	var key [32]byte
+	b, err := hex.DecodeString(s)
+	if err == nil {
+		err = identity.ValidatePublicKey(b)
+	}
+	if err != nil {
+		return key, err
+	}
+	copy(key[:], b)
+	return key, nil
+}
+
+// permits reports whether key may connect, and if not, which list refused
+// it.
+func (f *peerFilter) permits(key [32]byte) (ok bool, reason string) {
+	if f.deny[key] {
+		return false, "deny-list"
+	}
+	if f.allow != nil && !f.allow[key] {
+		return false, "not on allow-list"
+	}
+	return true, ""
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+func TestPeerFilter(t *testing.T) {
+	var ids [3]*identity.Identity
+	for i := range ids {
+		id, err := identity.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	listed, both, unlisted := ids[0], ids[1], ids[2]
+
+	tests := []struct {
+		name        string
+		allow, deny []string
+		permitted   []*identity.Identity
+		refused     []*identity.Identity
+	}{
+		{"no lists", nil, nil, []*identity.Identity{listed, both, unlisted}, nil},
+		{"allow-list", []string{listed.PublicKeyHex(), both.PublicKeyHex()}, nil, []*identity.Identity{listed, both}, []*identity.Identity{unlisted}},
+		{"deny-list", nil, []string{both.PublicKeyHex()}, []*identity.Identity{listed, unlisted}, []*identity.Identity{both}},
+		{"deny wins", []string{listed.PublicKeyHex(), both.PublicKeyHex()}, []string{both.PublicKeyHex()}, []*identity.Identity{listed}, []*identity.Identity{both, unlisted}},
+	}
+	for _, tt := range tests {
+		f, err := newPeerFilter(tt.allow, tt.deny)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, id := range tt.permitted {
+			if ok, reason := f.permits(id.PublicKey); !ok {
+				t.Errorf("%s: %s refused (%s)", tt.name, id.Address, reason)
+			}
+		}
+		for _, id := range tt.refused {
+			if ok, _ := f.permits(id.PublicKey); ok {
+				t.Errorf("%s: %s permitted", tt.name, id.Address)
+			}
+		}
+	}
+
+	for _, bad := range []string{"not-hex", "abcd", strings.Repeat("00", 32)} {
+		if _, err := newPeerFilter([]string{bad}, nil); err == nil {
+			t.Errorf("allowed peer %q accepted", bad)
+		}
+		if _, err := newPeerFilter(nil, []string{bad}); err == nil {
+			t.Errorf("denied peer %q accepted", bad)
+		}
+	}
+}
+
+func TestAllowListRefusesUnlistedPeer(t *testing.T) {
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+	friend := newTestAgent(t, mn.listen(t, "192.0.2.2:9993"))
+	stranger := newTestAgent(t, mn.listen(t, "192.0.2.3:9993"))
+	joinNetwork(testNetwork, [32]byte{1}, a, friend, stranger)
+
+	filter, err := newPeerFilter([]string{friend.identity.PublicKeyHex()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.peerFilter = filter
+
+	connectPair(t, friend, a)
+
+	aAddr := a.transport.LocalAddr().(*net.UDPAddr)
+	peerOfStranger := stranger.peers.AddPeer(a.identity.Address, a.identity.PublicKey, aAddr)
+	stranger.initiateHandshake(peerOfStranger)
+	waitFor(t, time.Second, "the stranger's hello to arrive", func() bool {
+		for _, p := range mn.sentTo(aAddr) {
+			if p.from.String() == stranger.transport.LocalAddr().String() {
+				return true
+			}
+		}
+		return false
+	})
+	time.Sleep(100 * time.Millisecond)
+	if a.peers.GetPeer(stranger.identity.Address) != nil {
+		t.Fatal("peer created for a key not on the allow-list")
+	}
+	if peerOfStranger.IsConnected() {
+		t.Fatal("stranger connected")
+	}
+}
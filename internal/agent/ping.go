@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// defaultPingTimeout bounds a ping when the request sets no timeout.
+const defaultPingTimeout = 5 * time.Second
+
+// Ping measures the round-trip time to a connected peer over the encrypted
+// overlay path. It does not need the TAP device.
+func (a *Agent) Ping(ctx context.Context, addr identity.Address) (time.Duration, error) {
+	peer := a.peers.GetPeer(addr)
+	if peer == nil {
+		return 0, fmt.Errorf("unknown peer: %s", addr)
+	}
+	if !peer.IsConnected() {
+		return 0, fmt.Errorf("peer not connected: %s", addr)
+	}
+
+	rtt, err := a.pinger.Ping(ctx, addr, func(payload []byte) error {
+		return a.sendControl(peer, payload)
+	})
+	if err != nil {
+		return 0, err
+	}
+	peer.LatencyMs = rtt.Milliseconds()
+	return rtt, nil
+}
+
+// sendControl encrypts a control payload and sends it to peer over its
+// current path.
+func (a *Agent) sendControl(peer *vl1.Peer, payload []byte) error {
+	return a.sendControlTo(peer, payload, nil)
+}
+
+// sendControlTo sends a control payload to peer at the direct endpoint ep,
+// or over its current path if ep is nil, under its session in the lowest
+// network ID it has one in.
+func (a *Agent) sendControlTo(peer *vl1.Peer, payload []byte, ep *net.UDPAddr) error {
+	networks := peer.Networks()
+	if len(networks) == 0 {
+		return fmt.Errorf("peer not connected: %s", peer.Address)
+	}
+	return a.sendControlIn(peer, networks[0], payload, ep)
+}
+
+// sendControlIn is sendControlTo under the peer's session in networkID.
+func (a *Agent) sendControlIn(peer *vl1.Peer, networkID uint32, payload []byte, ep *net.UDPAddr) error {
+	bufp := vl1.GetPacketBuf()
+	defer vl1.PutPacketBuf(bufp)
+	buf := *bufp
+
+	hdr := vl1.Header{Version: vl1.Version, Type: vl1.PacketTypeControl, NetworkID: networkID}
+	hdr.Encode(buf[:vl1.HeaderSize])
+	n, err := peer.EncryptTo(networkID, buf[vl1.HeaderSize:], payload, buf[:vl1.HeaderSize])
+	if err != nil {
+		return err
+	}
+	total := vl1.HeaderSize + n
+
+	if ep != nil {
+		return a.transport.SendTo(buf[:total], ep)
+	}
+	if conn := peer.TunnelConn(); conn != nil {
+		_, err := conn.Write(buf[:total])
+		return err
+	}
+	if peer.Endpoint == nil {
+		return fmt.Errorf("peer %s: no endpoint and no ICE or relay connection", peer.Address)
+	}
+	return a.transport.SendTo(buf[:total], peer.Endpoint)
+}
+
+// handleControlPacket decrypts a control packet from peer and answers pings.
+// Replies to packets that came over UDP go back to the address they came
+// from (nil for ICE and relay), so a probe measures the path it was sent on.
+func (a *Agent) handleControlPacket(pkt *vl1.Packet, peer *vl1.Peer, from *net.UDPAddr) {
+	bufp := vl1.GetPacketBuf()
+	defer vl1.PutPacketBuf(bufp)
+
+	ad := pkt.Header.Bytes()
+	plaintext, err := peer.DecryptTo(pkt.Header.NetworkID, *bufp, pkt.Payload, ad[:])
+	if err != nil {
+		a.log.Debug("control decrypt failed", "peer", peer.Address, "err", err)
+		return
+	}
+	peer.Touch()
+
+	reply, err := a.pinger.HandleControl(peer.Address, plaintext)
+	if err != nil {
+		a.log.Debug("control message", "peer", peer.Address, "err", err)
+		return
+	}
+	if reply != nil {
+		if err := a.sendControlIn(peer, pkt.Header.NetworkID, reply, from); err != nil {
+			a.log.Debug("control reply failed", "peer", peer.Address, "err", err)
+		}
+	}
+}
+
+// handlePing serves POST /ping?peer=<addr>[&timeout=5s] on the local status
+// endpoint.
+func (a *Agent) handlePing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	addr, err := identity.AddressFromHex(r.URL.Query().Get("peer"))
+	if err != nil {
+		http.Error(w, "invalid peer address", http.StatusBadRequest)
+		return
+	}
+	timeout := defaultPingTimeout
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		if timeout, err = time.ParseDuration(s); err != nil || timeout <= 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	rtt, err := a.Ping(ctx, addr)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, "ping timed out", http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	path := "direct"
+	if peer := a.peers.GetPeer(addr); peer != nil {
+		if peer.HasICE() {
+			path = "ice"
+		} else if peer.HasRelay() {
+			path = "relay"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(protocol.AgentPingResult{
+		Peer:  addr.String(),
+		RTTMs: float64(rtt.Microseconds()) / 1000,
+		Path:  path,
+	})
+}
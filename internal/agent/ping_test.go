@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+func TestPingPeer(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	connectPair(t, a, b)
+	if a.network != nil || b.network != nil {
+		t.Fatal("test agents have a TAP device")
+	}
+
+	ping := func(method, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.handlePing(w, httptest.NewRequest(method, "/ping?"+query, nil))
+		return w
+	}
+	peer := "peer=" + b.identity.Address.String()
+
+	w := ping("POST", peer)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ping: HTTP %d: %s", w.Code, w.Body)
+	}
+	var res protocol.AgentPingResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Peer != b.identity.Address.String() || res.Path != "direct" || res.RTTMs <= 0 {
+		t.Fatalf("ping result = %+v", res)
+	}
+
+	// The exchange is an encrypted control ping and pong, not keepalives
+	controls := func(tr *memTransport) int {
+		n := 0
+		for _, p := range mn.sentTo(tr.addr) {
+			if hdr, err := vl1.DecodeHeader(p.data); err == nil && hdr.Type == vl1.PacketTypeControl {
+				n++
+			}
+		}
+		return n
+	}
+	if controls(trA) == 0 || controls(trB) == 0 {
+		t.Fatalf("control packets: %d to b, %d back to a", controls(trB), controls(trA))
+	}
+
+	for _, tt := range []struct {
+		method, query string
+		code          int
+	}{
+		{"GET", peer, http.StatusMethodNotAllowed},
+		{"POST", "peer=xyz", http.StatusBadRequest},
+		{"POST", peer + "&timeout=soon", http.StatusBadRequest},
+		{"POST", "peer=00000000ff", http.StatusNotFound},
+	} {
+		if w := ping(tt.method, tt.query); w.Code != tt.code {
+			t.Errorf("%s /ping?%s: HTTP %d, want %d", tt.method, tt.query, w.Code, tt.code)
+		}
+	}
+
+	// A peer that stops answering times out
+	trB.Close()
+	start := time.Now()
+	if w := ping("POST", peer+"&timeout=100ms"); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("ping of a silent peer: HTTP %d: %s", w.Code, w.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ping took %v with a 100ms timeout", elapsed)
+	}
+}
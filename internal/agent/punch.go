@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+const (
+	punchBurstCount    = 10                     // hellos sent to each endpoint per punch
+	punchBurstInterval = 200 * time.Millisecond // spacing between bursts
+	punchMaxDelay      = 10 * time.Second       // cap on waiting for the coordinated time
+	punchTimeout       = 5 * time.Second        // wait after the burst before falling back
+)
+
+// handlePunch schedules a simultaneous hello burst to a peer at the time
+// chosen by the controller. The peer connects when its hello arrives; if it
+// stays silent, the peer falls back to a TURN relay.
+func (c *ControllerClient) handlePunch(msg *protocol.PunchMessage) {
+	pubKeyBytes, err := hex.DecodeString(msg.Peer.PublicKey)
+	if err == nil {
+		err = identity.ValidatePublicKey(pubKeyBytes)
+	}
+	if err != nil {
+		c.log.Warn("invalid punch peer public key", "peer", msg.Peer.Address, "err", err)
+		return
+	}
+
+	var pubKey [32]byte
+	copy(pubKey[:], pubKeyBytes)
+	peerAddr := identity.AddressFromPublicKey(pubKey[:])
+
+	endpoints := c.resolvePeerEndpoints(msg.Peer.Address, msg.Peer.Endpoints)
+	if len(endpoints) == 0 {
+		c.log.Debug("no punchable endpoint for peer", "peer", msg.Peer.Address, "endpoints", msg.Peer.Endpoints)
+		return
+	}
+
+	var networkID uint32
+	fmt.Sscanf(msg.NetworkID, "%d", &networkID)
+	c.setPeerPSK(networkID, peerAddr, msg.Peer)
+	peer := c.agent.peers.GetPeer(peerAddr)
+	if peer == nil {
+		peer = c.agent.peers.AddPeer(peerAddr, pubKey, endpoints[0])
+		if peer == nil {
+			return // peer limit reached
+		}
+	}
+	if peer.IsConnected() {
+		c.log.Debug("hole punch skipped: already connected", "peer", msg.Peer.Address)
+		if !peer.HasSession(networkID) {
+			c.agent.sendHello(peer)
+		}
+		return
+	}
+
+	delay := time.Until(msg.At)
+	if delay < 0 {
+		delay = 0
+	} else if delay > punchMaxDelay {
+		delay = punchMaxDelay
+	}
+
+	c.log.Info("hole punch scheduled", "peer", msg.Peer.Address, "endpoints", endpoints, "in", delay)
+	a := c.agent
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		c.punch(peer, endpoints, delay)
+	}()
+}
+
+// punch waits delay, then sends hello bursts to every candidate endpoint until
+// the peer connects, which happens when its hello reaches handleHello. If it
+// has not within punchTimeout of the last burst, the peer falls back to a
+// relay. punch returns early when the agent stops.
+func (c *ControllerClient) punch(peer *vl1.Peer, endpoints []*net.UDPAddr, delay time.Duration) {
+	a := c.agent
+	if !sleepCtx(a.ctx, delay) {
+		return
+	}
+
+	hellos := a.helloPackets(peer)
+	for i := 0; i < punchBurstCount && !peer.IsConnected(); i++ {
+		if i > 0 && !sleepCtx(a.ctx, punchBurstInterval) {
+			return
+		}
+		for _, ep := range endpoints {
+			for _, hello := range hellos {
+				if err := a.transport.SendTo(hello, ep); err != nil {
+					c.log.Debug("punch send failed", "peer", peer.Address, "endpoint", ep, "err", err)
+				}
+			}
+		}
+		peer.MarkSent()
+	}
+
+	deadline := time.Now().Add(punchTimeout)
+	for !peer.IsConnected() {
+		if time.Now().After(deadline) {
+			c.log.Warn("hole punch failed, falling back to relay", "peer", peer.Address)
+			c.startRelay(peer)
+			return
+		}
+		if !sleepCtx(a.ctx, punchBurstInterval) {
+			return
+		}
+	}
+	c.log.Info("hole punch succeeded", "peer", peer.Address, "endpoint", peer.Endpoint)
+}
+
+// sleepCtx waits for d and reports whether ctx is still live afterwards.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func TestPunchConnectsAtCoordinatedTime(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "198.51.100.7:41000")
+	a := newTestAgent(t, trA)
+	b := newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+
+	// The controller tells both sides to start at the same moment
+	at := time.Now().Add(100 * time.Millisecond)
+	network := fmt.Sprint(testNetwork)
+	a.ctrlCli.handlePunch(&protocol.PunchMessage{Type: protocol.MsgTypePunch, NetworkID: network, Peer: peerInfo(b, trB.addr.String()), At: at})
+	b.ctrlCli.handlePunch(&protocol.PunchMessage{Type: protocol.MsgTypePunch, NetworkID: network, Peer: peerInfo(a, trA.addr.String()), At: at})
+
+	peerOfA := a.peers.GetPeer(b.identity.Address)
+	peerOfB := b.peers.GetPeer(a.identity.Address)
+	if peerOfA == nil || peerOfB == nil {
+		t.Fatal("punch did not add the peer")
+	}
+	if peerOfA.IsConnected() || peerOfB.IsConnected() {
+		t.Fatal("peer connected before any packet was exchanged")
+	}
+
+	waitFor(t, 2*time.Second, "both sides to connect", func() bool {
+		return peerOfA.IsConnected() && peerOfB.IsConnected()
+	})
+	for _, p := range append(mn.sentTo(trA.addr), mn.sentTo(trB.addr)...) {
+		if p.at.Before(at) {
+			t.Fatalf("hello sent %v before the coordinated time", at.Sub(p.at))
+		}
+	}
+	if ep := peerOfA.Endpoint; ep == nil || ep.String() != trB.addr.String() {
+		t.Errorf("endpoint of b = %v, want %v", ep, trB.addr)
+	}
+}
+
+func TestPunchWithoutReplyStaysUnconnected(t *testing.T) {
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+	silent := newTestAgent(t, mn.listen(t, "192.0.2.9:9993"))
+	joinNetwork(testNetwork, [32]byte{1}, a, silent)
+	silentAddr := silent.transport.LocalAddr()
+	stopTestAgent(t, silent) // nothing answers at the peer's endpoint
+
+	a.ctrlCli.handlePunch(&protocol.PunchMessage{
+		Type:      protocol.MsgTypePunch,
+		NetworkID: fmt.Sprint(testNetwork),
+		Peer:      peerInfo(silent, silentAddr.String()),
+		At:        time.Now(),
+	})
+	peer := a.peers.GetPeer(silent.identity.Address)
+	ep := peer.Endpoint
+
+	waitFor(t, 2*time.Second, "two bursts", func() bool { return len(mn.sentTo(ep)) >= 2 })
+	if peer.IsConnected() {
+		t.Fatal("peer connected without a reply")
+	}
+
+	// Stopping the agent ends the burst; stopTestAgent fails the test if
+	// the punch goroutine outlives it
+	stopTestAgent(t, a)
+	sent := len(mn.sentTo(ep))
+	time.Sleep(2 * punchBurstInterval)
+	if n := len(mn.sentTo(ep)); n != sent {
+		t.Errorf("%d hellos sent after the agent stopped", n-sent)
+	}
+}
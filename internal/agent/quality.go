@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+const (
+	// pathProbeInterval is how often the paths of each connected peer are
+	// probed and the best one selected.
+	pathProbeInterval = 30 * time.Second
+	// pathProbeTimeout is how long a probe waits for its pong before it
+	// counts as lost.
+	pathProbeTimeout = 2 * time.Second
+)
+
+// pathQualityLoop periodically measures every connected peer's paths and
+// moves peers to better ones.
+func (a *Agent) pathQualityLoop() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(pathProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, peer := range a.peers.ConnectedPeers() {
+				a.wg.Add(1)
+				go func() {
+					defer a.wg.Done()
+					a.probePaths(peer)
+					a.selectPath(peer)
+				}()
+			}
+		}
+	}
+}
+
+// probePaths pings the peer once over its current path and once at each
+// other direct endpoint it has answered from, recording RTT or loss per path.
+func (a *Agent) probePaths(peer *vl1.Peer) {
+	current := peer.CurrentPath()
+	if current == "" {
+		return
+	}
+	paths := map[string]*net.UDPAddr{current: nil}
+	for ep, st := range peer.EndpointStates() {
+		if ep == current || st.Failures > 0 || st.LastSuccess.IsZero() {
+			continue
+		}
+		if addr, err := net.ResolveUDPAddr("udp", ep); err == nil {
+			paths[ep] = addr
+		}
+	}
+
+	var wg sync.WaitGroup
+	for path, ep := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.probePath(peer, path, ep)
+		}()
+	}
+	wg.Wait()
+}
+
+// probePath pings peer over path: at the direct endpoint ep, or over the
+// current path if ep is nil.
+func (a *Agent) probePath(peer *vl1.Peer, path string, ep *net.UDPAddr) {
+	ctx, cancel := context.WithTimeout(a.ctx, pathProbeTimeout)
+	defer cancel()
+	if ep != nil {
+		a.pathProbes.Store(ep.String(), peer)
+		defer a.pathProbes.Delete(ep.String())
+	}
+
+	rtt, err := a.pinger.Ping(ctx, peer.Address, func(payload []byte) error {
+		return a.sendControlTo(peer, payload, ep)
+	})
+	if a.ctx.Err() != nil {
+		return
+	}
+	peer.ObservePath(path, rtt, err != nil)
+}
+
+// selectPath moves the peer to the direct endpoint with the best score if
+// it clearly beats the current path (see vl1.BetterPath). A TURN relay is
+// given up for a better direct path; an ICE connection is kept, since the
+// peer keeps sending over its end of it.
+func (a *Agent) selectPath(peer *vl1.Peer) {
+	current := peer.CurrentPath()
+	qualities := peer.PathQualities()
+	cq := qualities[current]
+	if cq.RTT > 0 {
+		peer.LatencyMs = cq.RTT.Milliseconds()
+	}
+	if current == "" || current == vl1.PathICE {
+		return
+	}
+
+	states := peer.EndpointStates()
+	var best string
+	var bq vl1.PathQuality
+	for path, q := range qualities {
+		st, direct := states[path]
+		if path == current || !direct || st.Failures > 0 || time.Since(q.LastProbe) > 2*pathProbeInterval {
+			continue
+		}
+		if best == "" || q.Score() < bq.Score() {
+			best, bq = path, q
+		}
+	}
+	if best == "" || !vl1.BetterPath(cq, bq) {
+		return
+	}
+	ep, err := net.ResolveUDPAddr("udp", best)
+	if err != nil {
+		return
+	}
+
+	if current == vl1.PathRelay {
+		peer.CloseRelay()
+		if a.ctrlCli != nil {
+			a.ctrlCli.relays.Delete(peer.Address.String())
+		}
+	}
+	a.peers.UpdatePeerEndpoint(peer.Address, ep)
+	peer.LatencyMs = bq.RTT.Milliseconds()
+	a.log.Info("peer path switched", "peer", peer.Address,
+		"from", current, "to", best,
+		"score", bq.Score().Round(time.Millisecond), "previous_score", cq.Score().Round(time.Millisecond))
+}
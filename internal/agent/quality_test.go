@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+func TestSelectPathPrefersLowerLatency(t *testing.T) {
+	mn := newMemNet()
+	trA := mn.listen(t, "192.0.2.1:9993")
+	trB := mn.listen(t, "192.0.2.2:9993")
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	peer, _ := connectPair(t, a, b)
+
+	// b's current address turns slow; a second one it has answered from
+	// stays fast
+	mn.route(t, trB.addr.String(), trB, 40*time.Millisecond)
+	fast, _ := net.ResolveUDPAddr("udp", "198.51.100.2:9993")
+	mn.route(t, fast.String(), trB, 0)
+	peer.EndpointSucceeded(fast)
+	slow := peer.CurrentPath()
+
+	for i := range vl1.PathMinSamples {
+		a.probePaths(peer)
+		if i < vl1.PathMinSamples-1 {
+			// Too few samples to trust the fast path yet
+			a.selectPath(peer)
+			if got := peer.CurrentPath(); got != slow {
+				t.Fatalf("switched to %s after %d probes", got, i+1)
+			}
+		}
+	}
+	q := peer.PathQualities()
+	if q[slow].RTT < 40*time.Millisecond || q[fast.String()].RTT >= 20*time.Millisecond {
+		t.Fatalf("path qualities = %+v", q)
+	}
+
+	a.selectPath(peer)
+	if got := peer.CurrentPath(); got != fast.String() {
+		t.Fatalf("current path = %s, want the faster %s", got, fast)
+	}
+	st := a.Status()
+	if len(st.Peers) != 1 || st.Peers[0].Quality == nil || st.Peers[0].Quality.ScoreMs <= 0 || st.Peers[0].Quality.ScoreMs >= 20 {
+		t.Fatalf("status quality = %+v", st.Peers)
+	}
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// startPortMap asks the gateway to forward port to us (UPnP/NAT-PMP),
+// replacing any earlier mapping.
+func (a *Agent) startPortMap(port int) {
+	pm := vl1.NewPortMapper(port, a.log)
+	if err := pm.Start(); err != nil {
+		a.log.Warn("port mapping unavailable", "err", err)
+		pm = nil
+	}
+	if old := a.portmap.Swap(pm); old != nil {
+		old.Close()
+	}
+}
+
+// Rebind moves the VL1 transport to another UDP port (0 = any) without
+// restarting. Peer sessions are kept: their ciphers do not depend on our
+// endpoint, and each peer moves to the new source port when our hello or
+// data reaches it. If the new port cannot be bound the old one stays in use.
+func (a *Agent) Rebind(port int) (protocol.AgentRebindResult, error) {
+	rt, ok := a.transport.(vl1.RebindableTransport)
+	if !ok {
+		return protocol.AgentRebindResult{}, errors.New("transport cannot be rebound")
+	}
+	result := protocol.AgentRebindResult{OldPort: rt.Port()}
+	if err := rt.Rebind(port); err != nil {
+		return result, err
+	}
+	result.Port = rt.Port()
+	if result.Port == result.OldPort {
+		return result, nil
+	}
+
+	if a.config.PortMap {
+		a.startPortMap(result.Port)
+	}
+
+	// Tell peers our new endpoint, then the controller, which hands it to
+	// peers that have not heard from us yet
+	for _, peer := range a.peers.AllPeers() {
+		if peer.Endpoint != nil || peer.TunnelConn() != nil {
+			a.sendHello(peer)
+		}
+	}
+	select {
+	case a.statusKick <- struct{}{}:
+	default: // a report is already pending
+	}
+	return result, nil
+}
+
+// handleRebind serves POST /rebind?port=N for `zerogo-cli rebind`.
+func (a *Agent) handleRebind(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	port, err := strconv.Atoi(r.URL.Query().Get("port"))
+	if err != nil || port < 0 || port > 65535 {
+		http.Error(w, "invalid port", http.StatusBadRequest)
+		return
+	}
+
+	result, err := a.Rebind(port)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// newUDPTestAgent is newTestAgent on a real UDP socket, for tests that need
+// a rebindable transport.
+func newUDPTestAgent(t *testing.T) *Agent {
+	t.Helper()
+	tr, err := vl1.NewUDPTransport(0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestAgent(t, tr)
+}
+
+func loopback(port int) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+}
+
+func TestRebindKeepsPeers(t *testing.T) {
+	a, b := newUDPTestAgent(t), newUDPTestAgent(t)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	peerOfA := a.peers.AddPeer(b.identity.Address, b.identity.PublicKey, loopback(b.transport.Port()))
+	a.initiateHandshake(peerOfA)
+	var peerOfB *vl1.Peer
+	waitFor(t, 2*time.Second, "handshake", func() bool {
+		peerOfB = b.peers.GetPeer(a.identity.Address)
+		return peerOfA.IsConnected() && peerOfB != nil && peerOfB.IsConnected()
+	})
+	oldPort := a.transport.Port()
+
+	// Rebinding comes well after the handshake; b checks a hello from a new
+	// path at most once per HelloReplyInterval
+	time.Sleep(vl1.HelloReplyInterval)
+	result, err := a.Rebind(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.OldPort != oldPort || result.Port == oldPort || result.Port != a.transport.Port() {
+		t.Fatalf("rebind result %+v, old port %d, now %d", result, oldPort, a.transport.Port())
+	}
+	select {
+	case <-a.statusKick:
+	default:
+		t.Error("no status report queued for the controller")
+	}
+
+	// b follows a to its new port on the hello, and traffic flows both ways
+	waitFor(t, 2*time.Second, "b to see the new port", func() bool {
+		for ep, st := range peerOfB.EndpointStates() {
+			if strings.HasSuffix(ep, ":"+strconv.Itoa(result.Port)) && !st.LastSuccess.IsZero() {
+				return true
+			}
+		}
+		return false
+	})
+	if _, err := b.Ping(t.Context(), a.identity.Address); err != nil {
+		t.Fatalf("ping to the rebound agent: %v", err)
+	}
+	if _, err := a.Ping(t.Context(), b.identity.Address); err != nil {
+		t.Fatalf("ping from the rebound agent: %v", err)
+	}
+	if !peerOfA.IsConnected() || !peerOfB.IsConnected() {
+		t.Fatal("session lost over the rebind")
+	}
+}
+
+func TestRebindEndpoint(t *testing.T) {
+	a, busy := newUDPTestAgent(t), newUDPTestAgent(t)
+	post := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.handleRebind(w, httptest.NewRequest(http.MethodPost, "/rebind"+query, nil))
+		return w
+	}
+
+	oldPort := a.transport.Port()
+	w := post("?port=0")
+	var result protocol.AgentRebindResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &result) != nil || result.OldPort != oldPort || result.Port != a.transport.Port() {
+		t.Fatalf("rebind: HTTP %d %s", w.Code, w.Body)
+	}
+
+	// Binding fails: the agent stays on its port
+	current := a.transport.Port()
+	if w := post("?port=" + strconv.Itoa(busy.transport.Port())); w.Code != http.StatusConflict || a.transport.Port() != current {
+		t.Fatalf("rebind to a busy port: HTTP %d, now on %d", w.Code, a.transport.Port())
+	}
+	for _, q := range []string{"", "?port=x", "?port=70000", "?port=-1"} {
+		if w := post(q); w.Code != http.StatusBadRequest {
+			t.Errorf("rebind%s: HTTP %d", q, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	a.handleRebind(w, httptest.NewRequest(http.MethodGet, "/rebind?port=0", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /rebind: HTTP %d", w.Code)
+	}
+
+	// A transport that cannot move is reported
+	mem := newTestAgent(t, newMemNet().listen(t, "192.0.2.1:9993"))
+	if _, err := mem.Rebind(0); err == nil {
+		t.Error("rebind of a fixed transport succeeded")
+	}
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"net"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// relayAnswerTimeout is how long an unanswered relay allocation is kept.
+const relayAnswerTimeout = 15 * time.Second
+
+// relayServerList returns the configured TURN servers followed by those
+// advertised by the controller.
+func (c *ControllerClient) relayServerList() []vl1.TURNServer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	servers := make([]vl1.TURNServer, 0, len(c.agent.config.TURNServers)+len(c.relayServers))
+	servers = append(servers, c.agent.config.TURNServers...)
+	return append(servers, c.relayServers...)
+}
+
+// setRelayServers records the TURN servers advertised by the controller.
+func (c *ControllerClient) setRelayServers(relays []protocol.RelayInfo) {
+	servers := make([]vl1.TURNServer, 0, len(relays))
+	for _, r := range relays {
+		servers = append(servers, vl1.TURNServer{URL: r.URL, Username: r.Username, Password: r.Password})
+	}
+	c.mu.Lock()
+	c.relayServers = servers
+	c.mu.Unlock()
+}
+
+// allocateRelay allocates on the first TURN server that accepts us.
+func (c *ControllerClient) allocateRelay() *vl1.RelayAllocation {
+	for _, server := range c.relayServerList() {
+		alloc, err := vl1.AllocateRelay(server, c.log)
+		if err != nil {
+			c.log.Debug("TURN allocation failed", "server", server.URL, "err", err)
+			continue
+		}
+		return alloc
+	}
+	return nil
+}
+
+// startRelay allocates a TURN relay for an unreachable peer and offers the
+// relayed address to it through the controller.
+func (c *ControllerClient) startRelay(peer *vl1.Peer) {
+	remoteNodeAddr := peer.Address.String()
+	if _, ok := c.relays.Load(remoteNodeAddr); ok {
+		return
+	}
+	if _, pending := c.relayPending.LoadOrStore(remoteNodeAddr, struct{}{}); pending {
+		return
+	}
+
+	go func() {
+		defer c.relayPending.Delete(remoteNodeAddr)
+
+		alloc := c.allocateRelay()
+		if alloc == nil {
+			c.log.Debug("no TURN relay available", "peer", remoteNodeAddr)
+			return
+		}
+		if _, loaded := c.relays.LoadOrStore(remoteNodeAddr, alloc); loaded {
+			alloc.Close() // the peer's offer arrived first
+			return
+		}
+
+		c.log.Info("falling back to TURN relay", "peer", remoteNodeAddr, "relayed", alloc.RelayedAddr())
+		c.sendJSON(protocol.RelayOfferMessage{
+			Type:      protocol.MsgTypeRelayOffer,
+			To:        remoteNodeAddr,
+			RelayAddr: alloc.RelayedAddr().String(),
+		})
+
+		time.AfterFunc(relayAnswerTimeout, func() {
+			if !peer.HasRelay() && c.relays.CompareAndDelete(remoteNodeAddr, alloc) {
+				c.log.Debug("relay offer unanswered", "peer", remoteNodeAddr)
+				alloc.Close()
+			}
+		})
+	}()
+}
+
+// handleRelayOffer attaches the peer's relayed address to our allocation,
+// allocating (and answering) first if we did not initiate.
+func (c *ControllerClient) handleRelayOffer(msg *protocol.RelayOfferMessage) {
+	a := c.agent
+	var peer *vl1.Peer
+	for _, p := range a.peers.AllPeers() {
+		if p.Address.String() == msg.From {
+			peer = p
+			break
+		}
+	}
+	if peer == nil {
+		c.log.Debug("relay offer from unknown peer", "peer", msg.From)
+		return
+	}
+
+	remote, err := net.ResolveUDPAddr("udp", msg.RelayAddr)
+	if err != nil || remote.IP == nil {
+		c.log.Warn("invalid relay address", "peer", msg.From, "addr", msg.RelayAddr)
+		return
+	}
+
+	if conn := peer.RelayConn(); conn != nil {
+		if conn.RemoteAddr().String() == remote.String() {
+			return
+		}
+		// Peer re-allocated; drop our old allocation and answer afresh.
+		peer.CloseRelay()
+		c.relays.Delete(msg.From)
+	}
+
+	var alloc *vl1.RelayAllocation
+	if v, ok := c.relays.Load(msg.From); ok {
+		alloc = v.(*vl1.RelayAllocation)
+	} else {
+		alloc = c.allocateRelay()
+		if alloc == nil {
+			c.log.Warn("relay offer received but no TURN relay available", "peer", msg.From)
+			return
+		}
+		c.relays.Store(msg.From, alloc)
+		c.sendJSON(protocol.RelayOfferMessage{
+			Type:      protocol.MsgTypeRelayOffer,
+			To:        msg.From,
+			RelayAddr: alloc.RelayedAddr().String(),
+		})
+	}
+
+	conn := alloc.Conn(remote)
+	peer.SetRelayConn(conn)
+	a.wg.Add(1)
+	go a.relayReadLoop(peer, conn)
+	peer.StartHandshake() // a new path restarts an unanswered handshake
+	a.sendHello(peer)
+}
+
+// relayReadLoop reads VL1 packets from a peer's TURN relay connection.
+func (a *Agent) relayReadLoop(peer *vl1.Peer, conn net.Conn) {
+	defer a.wg.Done()
+	buf := make([]byte, vl1.MaxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if a.ctx.Err() == nil {
+				a.log.Debug("relay read error", "peer", peer.Address, "err", err)
+			}
+			if peer.RelayConn() == conn {
+				peer.CloseRelay()
+				if a.ctrlCli != nil {
+					a.ctrlCli.relays.Delete(peer.Address.String())
+				}
+			}
+			return
+		}
+		// Relayed packets use the same framing as ICE.
+		a.handleICEPacket(buf[:n], peer)
+	}
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/relay"
+	"github.com/unicornultrafoundation/zerogo/internal/vl1"
+)
+
+// startTestRelay runs a TURN relay on loopback and returns a server entry
+// for it.
+func startTestRelay(t *testing.T) vl1.TURNServer {
+	t.Helper()
+	ln, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.LocalAddr().String()
+	ln.Close()
+
+	srv := relay.New(relay.Config{
+		TURNEnabled: true,
+		ListenAddr:  addr,
+		Realm:       "zerogo.test",
+		PublicIP:    "127.0.0.1",
+		Credentials: map[string]string{"agent": "secret"},
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return vl1.TURNServer{URL: "turn:" + addr, Username: "agent", Password: "secret"}
+}
+
+func TestRelayFallback(t *testing.T) {
+	server := startTestRelay(t)
+	mn := newMemNet()
+	a := newTestAgent(t, mn.listen(t, "192.0.2.1:9993"))
+	b := newTestAgent(t, mn.listen(t, "198.51.100.7:41000"))
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	a.config.TURNServers = []vl1.TURNServer{server}
+	b.config.TURNServers = []vl1.TURNServer{server}
+
+	// Neither side can reach the other's direct endpoint
+	dead := &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 9993}
+	peerOfA := a.peers.AddPeer(b.identity.Address, b.identity.PublicKey, dead)
+	peerOfB := b.peers.AddPeer(a.identity.Address, a.identity.PublicKey, dead)
+	t.Cleanup(func() {
+		peerOfA.CloseRelay()
+		peerOfB.CloseRelay()
+	})
+
+	// a allocates and offers; the controller forwards each offer
+	allocA := a.ctrlCli.allocateRelay()
+	if allocA == nil {
+		t.Fatal("no relay allocation")
+	}
+	a.ctrlCli.relays.Store(b.identity.Address.String(), allocA)
+	b.ctrlCli.handleRelayOffer(&protocol.RelayOfferMessage{
+		Type:      protocol.MsgTypeRelayOffer,
+		From:      a.identity.Address.String(),
+		RelayAddr: allocA.RelayedAddr().String(),
+	})
+	v, ok := b.ctrlCli.relays.Load(a.identity.Address.String())
+	if !ok {
+		t.Fatal("b did not answer the relay offer")
+	}
+	a.ctrlCli.handleRelayOffer(&protocol.RelayOfferMessage{
+		Type:      protocol.MsgTypeRelayOffer,
+		From:      b.identity.Address.String(),
+		RelayAddr: v.(*vl1.RelayAllocation).RelayedAddr().String(),
+	})
+
+	waitFor(t, 5*time.Second, "both sides to connect over the relay", func() bool {
+		return peerOfA.IsConnected() && peerOfB.IsConnected()
+	})
+	if !peerOfA.HasRelay() || !peerOfB.HasRelay() {
+		t.Fatal("peers connected without the relay")
+	}
+	if _, err := a.Ping(t.Context(), b.identity.Address); err != nil {
+		t.Fatalf("ping over the relay: %v", err)
+	}
+	if _, err := b.Ping(t.Context(), a.identity.Address); err != nil {
+		t.Fatalf("ping back over the relay: %v", err)
+	}
+	if n := len(mn.sentTo(dead)); n != 0 {
+		t.Errorf("%d packets sent to the dead endpoint", n)
+	}
+}
+
+func TestLossyPathFallsBackToRelay(t *testing.T) {
+	server := startTestRelay(t)
+	mn := newMemNet()
+	trB := mn.listen(t, "198.51.100.7:41000")
+	trA := vl1.NewDroppingTransport(mn.listen(t, "192.0.2.1:9993"), vl1.Fault{})
+	a, b := newTestAgent(t, trA), newTestAgent(t, trB)
+	joinNetwork(testNetwork, [32]byte{1}, a, b)
+	a.config.TURNServers = []vl1.TURNServer{server}
+	a.peers.SetTimers(vl1.Timers{KeepaliveInterval: 50 * time.Millisecond})
+	peer, _ := connectPair(t, a, b)
+
+	// The direct path goes dark; backdate the last packet from b instead of
+	// waiting out the fallback timeout
+	trA.SetEndpointFault(trB.addr, vl1.Fault{Loss: 1})
+	sent := len(mn.sentTo(trB.addr))
+	peer.LastSeen = time.Now().Add(-vl1.RelayFallbackTimeout)
+	a.wg.Add(1)
+	go a.maintenanceLoop()
+
+	waitFor(t, 5*time.Second, "a relay allocation for b", func() bool {
+		_, ok := a.ctrlCli.relays.Load(b.identity.Address.String())
+		return ok
+	})
+	waitFor(t, time.Second, "a keepalive to be dropped", func() bool { return trA.Dropped() > 0 })
+	t.Cleanup(func() {
+		if v, ok := a.ctrlCli.relays.Load(b.identity.Address.String()); ok {
+			v.(*vl1.RelayAllocation).Close()
+		}
+	})
+	if n := len(mn.sentTo(trB.addr)) - sent; n != 0 {
+		t.Errorf("%d packets reached b through the dropped path", n)
+	}
+}
@@ -0,0 +1,235 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// fullTunnelRoutes cover the whole IPv4 space in two halves, so they win over
+// the underlay default route without replacing it.
+var fullTunnelRoutes = []string{"0.0.0.0/1", "128.0.0.0/1"}
+
+// fullTunnelMetric is the metric of the full-tunnel routes.
+const fullTunnelMetric = 10
+
+// routePlan is the set of routes the agent manages for a network.
+type routePlan struct {
+	Via    string   // overlay gateway IP for Routes
+	Routes []string // CIDRs routed via the overlay
+	Bypass []string // underlay host IPs kept on the physical default route
+}
+
+// planRoutes computes the managed routes. Split tunnel (the default) needs
+// none: the kernel's connected route already covers the overlay subnet.
+// Full tunnel routes everything via the gateway member, except the underlay
+// addresses the agent itself talks to (controller, relays, peer endpoints),
+// which would otherwise loop back into the tunnel. Private and loopback
+// addresses are never bypassed since they are reached via more specific
+// routes anyway.
+func planRoutes(fullTunnel bool, gateway string, underlay []net.IP) routePlan {
+	if !fullTunnel || gateway == "" {
+		return routePlan{}
+	}
+	plan := routePlan{Via: gateway, Routes: fullTunnelRoutes}
+	for _, ip := range underlay {
+		ip4 := ip.To4()
+		if ip4 == nil || !ip4.IsGlobalUnicast() || ip4.IsPrivate() {
+			continue
+		}
+		if s := ip4.String(); !slices.Contains(plan.Bypass, s) {
+			plan.Bypass = append(plan.Bypass, s)
+		}
+	}
+	slices.Sort(plan.Bypass)
+	return plan
+}
+
+func (p routePlan) equal(o routePlan) bool {
+	return p.Via == o.Via && slices.Equal(p.Routes, o.Routes) && slices.Equal(p.Bypass, o.Bypass)
+}
+
+// gatewayNATRules are the iptables rules (table, chain, match...) a gateway
+// member installs to forward and masquerade overlay traffic to the internet.
+func gatewayNATRules(subnet, dev string) [][]string {
+	return [][]string{
+		{"-t", "nat", "POSTROUTING", "-s", subnet, "!", "-o", dev, "-j", "MASQUERADE"},
+		{"-t", "filter", "FORWARD", "-i", dev, "-j", "ACCEPT"},
+		{"-t", "filter", "FORWARD", "-o", dev, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+}
+
+// iptablesArgs turns a rule from gatewayNATRules into iptables arguments for
+// op (-C check, -A append, -D delete).
+func iptablesArgs(op string, rule []string) []string {
+	args := append([]string{}, rule[:2]...)
+	args = append(args, op, rule[2])
+	return append(args, rule[3:]...)
+}
+
+func runIPTables(args []string) error {
+	cmd := exec.Command("iptables", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("iptables %s: %w (stderr: %s)", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// underlayHosts resolves the underlay addresses the agent must keep reaching
+// outside a full tunnel.
+func (c *ControllerClient) underlayHosts(peers []protocol.PeerInfo) []net.IP {
+	var hosts []string
+	if u, err := url.Parse(c.url); err == nil {
+		hosts = append(hosts, u.Hostname())
+	}
+	for _, s := range c.relayServerList() {
+		// turn:host:port or turn:host
+		rest := strings.TrimPrefix(strings.TrimPrefix(s.URL, "turns:"), "turn:")
+		host, _, err := net.SplitHostPort(rest)
+		if err != nil {
+			host = rest
+		}
+		hosts = append(hosts, host)
+	}
+	for _, p := range peers {
+		for _, ep := range p.Endpoints {
+			if host, _, err := net.SplitHostPort(ep); err == nil {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	var ips []net.IP
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		addrs, err := net.LookupIP(h)
+		if err != nil {
+			c.log.Warn("resolve underlay host for bypass route", "host", h, "err", err)
+			continue
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips
+}
+
+// applyRoutes installs the routes and gateway NAT for a network config,
+// replacing what an earlier config installed.
+func (c *ControllerClient) applyRoutes(msg *protocol.NetworkConfigMessage) {
+	a := c.agent
+	if a.tapDev == nil {
+		return
+	}
+
+	var plan routePlan
+	if a.config.FullTunnel && !msg.IsGateway {
+		plan = planRoutes(true, msg.DefaultGateway, c.underlayHosts(msg.Peers))
+		if msg.DefaultGateway == "" {
+			c.log.Warn("full tunnel enabled but the network has no default gateway; using split tunnel")
+		}
+	}
+	var nat [][]string
+	if msg.IsGateway && msg.IPRange != "" {
+		nat = gatewayNATRules(msg.IPRange, a.tapDev.Name())
+	}
+
+	c.routeMu.Lock()
+	defer c.routeMu.Unlock()
+	if plan.equal(c.routes) && slices.EqualFunc(nat, c.natRules, slices.Equal[[]string]) {
+		return
+	}
+	c.removeRoutesLocked()
+
+	for _, host := range plan.Bypass {
+		if err := a.tapDev.AddBypassRoute(host); err != nil {
+			c.log.Warn("add bypass route", "host", host, "err", err)
+		}
+	}
+	for _, dst := range plan.Routes {
+		if err := a.tapDev.AddRoute(dst, plan.Via, fullTunnelMetric); err != nil {
+			c.log.Warn("add full-tunnel route", "dst", dst, "err", err)
+		}
+	}
+	c.routes = plan
+	if len(plan.Routes) > 0 {
+		c.log.Info("full tunnel enabled", "via", plan.Via, "bypass", len(plan.Bypass))
+	}
+
+	if len(nat) > 0 {
+		if err := a.tapDev.EnableIPForwarding(); err != nil {
+			c.log.Warn("enable IP forwarding", "err", err)
+		}
+		for _, rule := range nat {
+			if runIPTables(iptablesArgs("-C", rule)) == nil {
+				continue // already present
+			}
+			if err := runIPTables(iptablesArgs("-A", rule)); err != nil {
+				c.log.Warn("add gateway NAT rule", "err", err)
+			}
+		}
+		c.natRules = nat
+		c.log.Info("acting as default gateway", "subnet", msg.IPRange)
+	}
+}
+
+// LookupGatewayMAC returns the MAC of the gateway member when dst is covered
+// by a managed route, or nil. A TUN device hands the agent packets with a
+// broadcast destination MAC; this lets those bound for the gateway go out
+// unicast.
+func (c *ControllerClient) LookupGatewayMAC(dst net.IP) net.HardwareAddr {
+	c.routeMu.Lock()
+	plan := c.routes
+	c.routeMu.Unlock()
+
+	via := net.ParseIP(plan.Via)
+	if via == nil || c.agent.network == nil {
+		return nil
+	}
+	for _, r := range plan.Routes {
+		if _, cidr, err := net.ParseCIDR(r); err == nil && cidr.Contains(dst) {
+			return c.agent.network.ARP.Lookup(via)
+		}
+	}
+	return nil
+}
+
+// cleanupRoutes removes all managed routes and gateway NAT rules.
+func (c *ControllerClient) cleanupRoutes() {
+	c.routeMu.Lock()
+	defer c.routeMu.Unlock()
+	c.removeRoutesLocked()
+}
+
+func (c *ControllerClient) removeRoutesLocked() {
+	a := c.agent
+	if a.tapDev != nil {
+		for _, dst := range c.routes.Routes {
+			if err := a.tapDev.RemoveRoute(dst); err != nil {
+				c.log.Debug("remove full-tunnel route", "dst", dst, "err", err)
+			}
+		}
+		for _, host := range c.routes.Bypass {
+			if err := a.tapDev.RemoveBypassRoute(host); err != nil {
+				c.log.Debug("remove bypass route", "host", host, "err", err)
+			}
+		}
+	}
+	c.routes = routePlan{}
+
+	for _, rule := range c.natRules {
+		if err := runIPTables(iptablesArgs("-D", rule)); err != nil {
+			c.log.Debug("remove gateway NAT rule", "err", err)
+		}
+	}
+	c.natRules = nil
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestPlanRoutes(t *testing.T) {
+	underlay := []net.IP{
+		net.ParseIP("198.51.100.7"),
+		net.ParseIP("203.0.113.9"),
+		net.ParseIP("198.51.100.7"), // controller and relay on one host
+		net.ParseIP("192.168.1.20"),
+		net.ParseIP("127.0.0.1"),
+		net.ParseIP("2001:db8::1"),
+	}
+
+	// Split tunnel leaves routing to the overlay subnet's connected route
+	if plan := planRoutes(false, "10.1.0.1", underlay); !plan.equal(routePlan{}) {
+		t.Errorf("split tunnel plan = %+v, want no routes", plan)
+	}
+	if plan := planRoutes(true, "", underlay); !plan.equal(routePlan{}) {
+		t.Errorf("full tunnel without a gateway = %+v, want no routes", plan)
+	}
+
+	want := routePlan{
+		Via:    "10.1.0.1",
+		Routes: []string{"0.0.0.0/1", "128.0.0.0/1"},
+		Bypass: []string{"198.51.100.7", "203.0.113.9"},
+	}
+	if plan := planRoutes(true, "10.1.0.1", underlay); !plan.equal(want) {
+		t.Errorf("full tunnel plan = %+v, want %+v", plan, want)
+	}
+}
+
+func TestGatewayNATCommands(t *testing.T) {
+	var got [][]string
+	for _, rule := range gatewayNATRules("10.1.0.0/24", "zt0") {
+		got = append(got, iptablesArgs("-A", rule))
+	}
+	want := [][]string{
+		{"-t", "nat", "-A", "POSTROUTING", "-s", "10.1.0.0/24", "!", "-o", "zt0", "-j", "MASQUERADE"},
+		{"-t", "filter", "-A", "FORWARD", "-i", "zt0", "-j", "ACCEPT"},
+		{"-t", "filter", "-A", "FORWARD", "-o", "zt0", "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("iptables commands:\n%q\nwant:\n%q", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// startStatusServer serves the local status endpoint used by `zerogo-cli status`.
+// It should only ever listen on a loopback address.
+func (a *Agent) startStatusServer() error {
+	ln, err := net.Listen("tcp", a.config.StatusListen)
+	if err != nil {
+		return fmt.Errorf("listen status %s: %w", a.config.StatusListen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", a.handleStatus)
+	mux.HandleFunc("/ping", a.handlePing)
+	mux.HandleFunc("/capture", a.handleCapture)
+	mux.HandleFunc("/rebind", a.handleRebind)
+
+	a.statusSrv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := a.statusSrv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.log.Warn("status server stopped", "err", err)
+		}
+	}()
+
+	a.log.Info("status endpoint listening", "addr", ln.Addr())
+	return nil
+}
+
+// handleStatus reports the agent's identity, networks, and peer connectivity.
+func (a *Agent) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Status())
+}
+
+// Status returns a snapshot of the agent's local state.
+func (a *Agent) Status() protocol.AgentStatus {
+	status := protocol.AgentStatus{
+		Address:     a.identity.Address.String(),
+		Version:     a.config.Version,
+		AssignedIPs: []string{},
+		Networks:    []string{},
+	}
+
+	a.addrMu.RLock()
+	if a.config.TAPIPv4 != "" {
+		status.AssignedIPs = append(status.AssignedIPs, a.config.TAPIPv4)
+	}
+	if a.config.TAPIPv6 != "" {
+		status.AssignedIPs = append(status.AssignedIPs, a.config.TAPIPv6)
+	}
+	if len(a.config.Networks) > 0 {
+		status.Networks = append(status.Networks, a.config.Networks...)
+	} else if a.config.NetworkID > 0 {
+		status.Networks = append(status.Networks, fmt.Sprintf("%d", a.config.NetworkID))
+	}
+	a.addrMu.RUnlock()
+
+	peers := a.peers.AllPeers()
+	status.Peers = make([]protocol.AgentPeerStatus, 0, len(peers))
+	for _, p := range peers {
+		ps := protocol.AgentPeerStatus{
+			Address:   p.Address.String(),
+			State:     p.State.String(),
+			LatencyMs: p.LatencyMs,
+			Path:      "direct",
+			LastSeen:  p.LastSeen,
+		}
+		if p.HasICE() {
+			ps.Path = "ice"
+		} else if p.HasRelay() {
+			ps.Path = "relay"
+		}
+		if p.Endpoint != nil {
+			ps.Endpoint = p.Endpoint.String()
+		}
+		for ep, st := range p.EndpointStates() {
+			ps.Candidates = append(ps.Candidates, protocol.AgentEndpointStatus{
+				Endpoint:    ep,
+				LastSuccess: st.LastSuccess,
+				LastFailure: st.LastFailure,
+				Failures:    st.Failures,
+			})
+		}
+		sort.Slice(ps.Candidates, func(i, j int) bool { return ps.Candidates[i].Endpoint < ps.Candidates[j].Endpoint })
+		if q, ok := p.PathQualities()[p.CurrentPath()]; ok {
+			ps.Quality = &protocol.AgentPathQuality{
+				RTTMs:   float64(q.RTT.Microseconds()) / 1000,
+				Loss:    q.Loss,
+				Samples: q.Samples,
+			}
+			if q.RTT > 0 {
+				ps.Quality.ScoreMs = float64(q.Score().Microseconds()) / 1000
+			}
+		}
+		status.Peers = append(status.Peers, ps)
+	}
+
+	if a.transport != nil {
+		ts := a.transport.Stats()
+		status.Transport = &protocol.AgentTransportStats{
+			PacketsSent:     ts.PacketsSent,
+			PacketsReceived: ts.PacketsReceived,
+			BytesSent:       ts.BytesSent,
+			BytesReceived:   ts.BytesReceived,
+			ReadErrors:      ts.ReadErrors,
+			WriteErrors:     ts.WriteErrors,
+			Drops:           a.drops.Counts(),
+		}
+	}
+
+	if a.ctrlCli != nil {
+		status.ClockOffsetMs = a.ctrlCli.ClockOffset().Milliseconds()
+		status.DeviceError = a.ctrlCli.deviceError()
+	}
+
+	if a.network != nil && !a.config.TUNMode {
+		ss := a.network.Switch.Stats()
+		status.Switch = &protocol.AgentSwitchStats{
+			UnicastHits:     ss.UnicastHits,
+			UnknownFloods:   ss.UnknownFloods,
+			BroadcastFloods: ss.BroadcastFloods,
+			Drops:           ss.Drops,
+			MACFlaps:        ss.MACFlaps,
+			MACTableSize:    ss.MACTableSize,
+		}
+	}
+
+	if a.network != nil {
+		for _, h := range a.network.ACL.Hits() {
+			status.Rules = append(status.Rules, protocol.AgentRuleStatus{
+				ID:       h.ID,
+				Priority: h.Priority,
+				Action:   string(h.Action),
+				Hits:     h.Hits,
+			})
+		}
+	}
+	return status
+}
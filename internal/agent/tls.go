@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// controllerTLSConfig builds the TLS configuration for the controller
+// connection from the configured client certificate, CA and pins, or
+// returns nil to use the system defaults. The client certificate must be
+// issued for the node address, which the controller checks when it
+// requires mTLS.
+func (a *Agent) controllerTLSConfig() (*tls.Config, error) {
+	cfg := a.config
+	if cfg.TLSCert == "" && cfg.TLSKey == "" && cfg.TLSCA == "" && len(cfg.ControllerPins) == 0 {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(cfg.ControllerPins) > 0 {
+		if u := controllerWSURL(cfg.ControllerURL); !strings.HasPrefix(u, "wss://") {
+			return nil, fmt.Errorf("controller pins need a wss:// or https:// controller URL, got %q", cfg.ControllerURL)
+		}
+		pins := make([]controllerPin, 0, len(cfg.ControllerPins))
+		for _, s := range cfg.ControllerPins {
+			pin, err := parseControllerPin(s)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, pin)
+		}
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyControllerPins(cs, pins)
+		}
+	}
+
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse client certificate: %w", err)
+		}
+		if !certNamesAddress(leaf, a.identity.Address) {
+			return nil, fmt.Errorf("client certificate %q is not issued for node address %s", leaf.Subject.CommonName, a.identity.Address)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.TLSCA != "" {
+		data, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("load controller CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("load controller CA: %s: no PEM certificates", cfg.TLSCA)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	return tlsCfg, nil
+}
+
+// controllerPin is a parsed controller pin: the SHA-256 of a certificate's
+// public key (SPKI), which survives renewals with the same key, or of the
+// whole DER certificate.
+type controllerPin struct {
+	spki bool
+	sum  [sha256.Size]byte
+}
+
+// parseControllerPin parses "sha256/<base64>" as a public key pin, as
+// printed by
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// and anything else as a hex certificate fingerprint, with or without
+// colons, as printed by openssl x509 -fingerprint -sha256.
+func parseControllerPin(s string) (controllerPin, error) {
+	var pin controllerPin
+	var b []byte
+	var err error
+	if b64, ok := strings.CutPrefix(s, "sha256/"); ok {
+		pin.spki = true
+		b, err = base64.StdEncoding.DecodeString(b64)
+	} else {
+		b, err = hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	}
+	if err != nil || len(b) != sha256.Size {
+		return pin, fmt.Errorf("invalid controller pin %q: want sha256/<base64 public key hash> or a hex SHA-256 certificate fingerprint", s)
+	}
+	copy(pin.sum[:], b)
+	return pin, nil
+}
+
+func (p controllerPin) matches(cert *x509.Certificate) bool {
+	data := cert.Raw
+	if p.spki {
+		data = cert.RawSubjectPublicKeyInfo
+	}
+	return sha256.Sum256(data) == p.sum
+}
+
+// verifyControllerPins accepts the controller's certificate if it, or any
+// certificate of its verified chains, matches one of the pins, so a CA or
+// intermediate can be pinned too, and several pins allow rotating the
+// controller's key. It runs after the usual chain verification, which it
+// does not replace.
+func verifyControllerPins(cs tls.ConnectionState, pins []controllerPin) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("controller presented no certificate")
+	}
+	chains := append([][]*x509.Certificate{cs.PeerCertificates}, cs.VerifiedChains...)
+	for _, chain := range chains {
+		for _, cert := range chain {
+			for _, pin := range pins {
+				if pin.matches(cert) {
+					return nil
+				}
+			}
+		}
+	}
+	leaf := cs.PeerCertificates[0]
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return fmt.Errorf("controller certificate %q matches no pin (its public key pin is sha256/%s)",
+		leaf.Subject.CommonName, base64.StdEncoding.EncodeToString(spki[:]))
+}
+
+// certNamesAddress reports whether cert carries addr as its common name or
+// one of its DNS SANs, as the controller requires.
+func certNamesAddress(cert *x509.Certificate, addr identity.Address) bool {
+	if strings.EqualFold(cert.Subject.CommonName, addr.String()) {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, addr.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// controllerWSURL turns an http:// or https:// controller URL into its
+// WebSocket equivalent.
+func controllerWSURL(u string) string {
+	switch {
+	case strings.HasPrefix(u, "http://"):
+		u = "ws://" + u[len("http://"):]
+	case strings.HasPrefix(u, "https://"):
+		u = "wss://" + u[len("https://"):]
+	}
+	return strings.TrimSuffix(u, "/")
+}
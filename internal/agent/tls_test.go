@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestControllerPins(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	cert := srv.Certificate()
+	if err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	spkiPin := "sha256/" + base64.StdEncoding.EncodeToString(spki[:])
+	fp := sha256.Sum256(cert.Raw)
+	otherPin := "sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	// connect dials the server with the TLS config built from pins
+	connect := func(pins ...string) error {
+		a := &Agent{config: Config{ControllerURL: srv.URL, TLSCA: caPath, ControllerPins: pins}}
+		tlsCfg, err := a.controllerTLSConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := tls.Dial("tcp", strings.TrimPrefix(srv.URL, "https://"), tlsCfg)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	for name, pins := range map[string][]string{
+		"public key":            {spkiPin},
+		"fingerprint":           {strings.ToUpper(hex.EncodeToString(fp[:]))},
+		"colon fingerprint":     {colonHex(fp[:])},
+		"rotation to a new key": {otherPin, spkiPin},
+	} {
+		if err := connect(pins...); err != nil {
+			t.Errorf("%s pin refused: %v", name, err)
+		}
+	}
+	err := connect(otherPin)
+	if err == nil || !strings.Contains(err.Error(), spkiPin) {
+		t.Errorf("non-matching pin: err = %v, want a refusal naming the server's pin", err)
+	}
+
+	for _, cfg := range []Config{
+		{ControllerURL: srv.URL, ControllerPins: []string{"sha256/short"}},
+		{ControllerURL: srv.URL, ControllerPins: []string{"not-hex"}},
+		{ControllerURL: "ws://controller.example:9394", ControllerPins: []string{spkiPin}},
+	} {
+		if _, err := (&Agent{config: cfg}).controllerTLSConfig(); err == nil {
+			t.Errorf("config %+v accepted", cfg)
+		}
+	}
+}
+
+// colonHex formats b as an openssl fingerprint, AB:CD:...
+func colonHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, c := range b {
+		parts[i] = strings.ToUpper(hex.EncodeToString([]byte{c}))
+	}
+	return strings.Join(parts, ":")
+}
@@ -9,12 +9,43 @@ import (
 
 // AgentConfig is the configuration for the zerogo-agent.
 type AgentConfig struct {
-	IdentityPath string   `yaml:"identity_path"`
-	Controller   string   `yaml:"controller"`
+	IdentityPath string       `yaml:"identity_path"`
+	Controller   string       `yaml:"controller"`
 	Networks     []NetworkRef `yaml:"networks"`
-	STUNServers  []string `yaml:"stun_servers"`
-	ListenPort   int      `yaml:"listen_port"`
-	LogLevel     string   `yaml:"log_level"`
+	STUNServers  []string     `yaml:"stun_servers"`
+	ListenPort   int          `yaml:"listen_port"`
+	LogLevel     string       `yaml:"log_level"`
+	// PSK (hex) and StaticPeers are for static mode, without a controller
+	PSK         string          `yaml:"psk"`
+	StaticPeers []StaticPeerRef `yaml:"static_peers"`
+	// AllowPeers, if set, are the public keys (hex) of the only peers that
+	// may connect; DenyPeers are refused even if allowed
+	AllowPeers []string `yaml:"allow_peers"`
+	DenyPeers  []string `yaml:"deny_peers"`
+	// FullTunnel routes all traffic via the network's default gateway member
+	FullTunnel bool `yaml:"full_tunnel"`
+	// DHCPRange ("first-last") makes the agent serve DHCP from that pool
+	DHCPRange string `yaml:"dhcp_range"`
+	// Peer liveness intervals as Go durations ("15s"); empty uses the defaults
+	KeepaliveInterval      string `yaml:"keepalive_interval"`
+	PeerTimeout            string `yaml:"peer_timeout"`
+	HandshakeRetryInterval string `yaml:"handshake_retry_interval"`
+	// MaxPeers bounds the peers tracked at once; 0 uses the default
+	MaxPeers int `yaml:"max_peers"`
+	// UnderlayMTU is the path MTU to peers the device MTU is clamped to fit
+	// (0 = 1500); NoMTUClamp turns the clamp off
+	UnderlayMTU int  `yaml:"underlay_mtu"`
+	NoMTUClamp  bool `yaml:"no_mtu_clamp"`
+	// PreferFamily ("ipv6" or "ipv4") is the address family tried first
+	// when a peer advertises both
+	PreferFamily string `yaml:"prefer_family"`
+	// Client certificate for mTLS with the controller, with the node address
+	// as its CN or a DNS SAN, and the CA that verifies the controller
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+	TLSCA   string `yaml:"tls_ca"`
+	// ControllerPins restricts the controller's certificate to these pins
+	ControllerPins []string `yaml:"controller_pins"`
 }
 
 // NetworkRef is a reference to a network in the agent config.
@@ -22,15 +53,36 @@ type NetworkRef struct {
 	ID string `yaml:"id"`
 }
 
+// StaticPeerRef is a statically configured peer in the agent config.
+type StaticPeerRef struct {
+	PublicKey string `yaml:"public_key"`
+	Address   string `yaml:"address"` // host:port
+}
+
 // ControllerConfig is the configuration for the zerogo-controller.
 type ControllerConfig struct {
-	Listen    string        `yaml:"listen"`
-	Database  string        `yaml:"database"`
-	JWTSecret string        `yaml:"jwt_secret"`
-	STUN      STUNConfig    `yaml:"stun"`
-	TURN      TURNConfig    `yaml:"turn"`
-	Admin     AdminConfig   `yaml:"admin"`
-	LogLevel  string        `yaml:"log_level"`
+	Listen    string      `yaml:"listen"`
+	Database  string      `yaml:"database"`
+	JWTSecret string      `yaml:"jwt_secret"`
+	STUN      STUNConfig  `yaml:"stun"`
+	TURN      TURNConfig  `yaml:"turn"`
+	Admin     AdminConfig `yaml:"admin"`
+	LogLevel  string      `yaml:"log_level"`
+	// AllowedOrigins lists browser origins allowed cross-origin API access
+	// (e.g. "https://admin.example.com"); "*" allows any origin without
+	// credentials. Empty allows same-origin requests only.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// WebUI serves the embedded admin web UI at /
+	WebUI bool `yaml:"web_ui"`
+	// TLS serves the API over HTTPS, optionally verifying agent certificates
+	TLS TLSConfig `yaml:"tls"`
+	// LongAddresses records each node's 80-bit long address alongside its
+	// 40-bit address, to tell nodes with colliding addresses apart
+	LongAddresses bool `yaml:"long_addresses"`
+	// AgentOrigins lists browser origins allowed to open the agent
+	// WebSocket; "*" allows any. Agents send no Origin header and are
+	// always allowed, so the default (empty) refuses every browser.
+	AgentOrigins []string `yaml:"agent_origins"`
 }
 
 // STUNConfig configures the built-in STUN server.
@@ -45,12 +97,35 @@ type TURNConfig struct {
 	Listen      string            `yaml:"listen"`
 	Realm       string            `yaml:"realm"`
 	Credentials map[string]string `yaml:"credentials"`
+	// Advertise is the TURN URI pushed to agents for relay fallback
+	// (e.g. "turn:relay.example.com:3478"). Empty disables advertising.
+	Advertise string `yaml:"advertise"`
+	// Secret is shared with the relay (zerogo-relay -secret). When set,
+	// agents get per-node credentials that expire after CredentialTTL
+	// seconds (default a day) instead of the static Credentials.
+	Secret        string `yaml:"secret"`
+	CredentialTTL int    `yaml:"credential_ttl"`
+}
+
+// TLSConfig configures HTTPS and mutual TLS for the controller.
+type TLSConfig struct {
+	Cert string `yaml:"cert"` // server certificate (PEM)
+	Key  string `yaml:"key"`  // server private key (PEM)
+	// ClientCA verifies agent client certificates, whose CN or a DNS SAN
+	// must be the agent's node address
+	ClientCA string `yaml:"client_ca"`
+	// RequireAgentCert refuses agents without a valid client certificate;
+	// otherwise certificates are only checked when presented
+	RequireAgentCert bool `yaml:"require_agent_cert"`
 }
 
 // AdminConfig is the default admin account.
 type AdminConfig struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	// PasswordHash is a bcrypt hash used instead of Password, as written
+	// by `zerogo-controller init`
+	PasswordHash string `yaml:"password_hash,omitempty"`
 }
 
 // DefaultAgentConfig returns a config with sensible defaults.
@@ -106,6 +181,20 @@ func LoadControllerConfig(path string) (*ControllerConfig, error) {
 	return cfg, nil
 }
 
+// SaveControllerConfig writes cfg to path as YAML, readable only by the
+// owner since it holds the JWT secret. An existing file is replaced.
+func SaveControllerConfig(path string, cfg *ControllerConfig) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("save controller config: %w", err)
+	}
+	data = append([]byte("## ZeroGo Controller Configuration\n\n"), data...)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("save controller config: %w", err)
+	}
+	return nil
+}
+
 func loadYAML(path string, out interface{}) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
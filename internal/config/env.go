@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix prefixes the environment variables that override config values.
+const EnvPrefix = "ZEROGO_"
+
+// ApplyEnv overrides fields of a config struct from environment variables.
+// Variable names are derived from the YAML keys: EnvPrefix followed by the
+// key path in upper case joined by "_", e.g. listen -> ZEROGO_LISTEN and
+// admin.password -> ZEROGO_ADMIN_PASSWORD. String, integer and boolean fields
+// are supported, and string lists are comma-separated. Call it after loading
+// the file and before applying command-line flags.
+func ApplyEnv(cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("apply env: need a pointer to a struct, got %T", cfg)
+	}
+	return applyEnv(v.Elem(), strings.TrimSuffix(EnvPrefix, "_"))
+}
+
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "" || key == "-" || !field.IsExported() {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		// Errors name the variable but never echo its value, which may be a secret
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(value)
+		case reflect.Int, reflect.Int64, reflect.Int32:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: invalid integer", name)
+			}
+			fv.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%s: invalid boolean", name)
+			}
+			fv.SetBool(b)
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("%s: cannot be set from the environment", name)
+			}
+			var list []string
+			for _, s := range strings.Split(value, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+			fv.Set(reflect.ValueOf(list))
+		default:
+			return fmt.Errorf("%s: cannot be set from the environment", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnvOverridesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "controller.yaml")
+	yaml := "listen: 0.0.0.0:9394\ndatabase: sqlite:///file.db\nlog_level: warn\nadmin:\n  username: root\n  password: from-file\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZEROGO_DATABASE", "sqlite:///env.db")
+	t.Setenv("ZEROGO_ADMIN_PASSWORD", "from-env")
+	t.Setenv("ZEROGO_WEB_UI", "true")
+	t.Setenv("ZEROGO_TURN_CREDENTIAL_TTL", "3600")
+	t.Setenv("ZEROGO_ALLOWED_ORIGINS", "https://a.example, https://b.example,")
+
+	cfg, err := LoadControllerConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyEnv(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Set variables win over the file, which wins over the defaults
+	if cfg.Database != "sqlite:///env.db" || cfg.Admin.Password != "from-env" || !cfg.WebUI || cfg.TURN.CredentialTTL != 3600 {
+		t.Errorf("environment not applied: %+v", cfg)
+	}
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(cfg.AllowedOrigins, want) {
+		t.Errorf("allowed origins = %q, want %q", cfg.AllowedOrigins, want)
+	}
+	if cfg.Listen != "0.0.0.0:9394" || cfg.LogLevel != "warn" || cfg.Admin.Username != "root" {
+		t.Errorf("file values lost: %+v", cfg)
+	}
+	if cfg.JWTSecret != DefaultControllerConfig().JWTSecret {
+		t.Errorf("unset variable changed the default JWT secret")
+	}
+}
+
+func TestEnvAgentConfig(t *testing.T) {
+	t.Setenv("ZEROGO_CONTROLLER", "ws://env.example:9394")
+	t.Setenv("ZEROGO_LISTEN_PORT", "9995")
+	t.Setenv("ZEROGO_FULL_TUNNEL", "1")
+	cfg := DefaultAgentConfig()
+	if err := ApplyEnv(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Controller != "ws://env.example:9394" || cfg.ListenPort != 9995 || !cfg.FullTunnel {
+		t.Errorf("environment not applied: %+v", cfg)
+	}
+}
+
+func TestEnvErrorsHideValues(t *testing.T) {
+	const secret = "hunter2-do-not-log"
+	for _, name := range []string{"ZEROGO_TURN_CREDENTIAL_TTL", "ZEROGO_WEB_UI"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, secret)
+			err := ApplyEnv(DefaultControllerConfig())
+			if err == nil {
+				t.Fatal("invalid value accepted")
+			}
+			if !strings.Contains(err.Error(), name) || strings.Contains(err.Error(), secret) {
+				t.Errorf("err = %q, want the variable name without its value", err)
+			}
+		})
+	}
+	if err := ApplyEnv(*DefaultControllerConfig()); err == nil {
+		t.Error("non-pointer config accepted")
+	}
+}
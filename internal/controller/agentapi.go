@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"gorm.io/gorm"
+)
+
+// agentRequestMaxSkew bounds how far the timestamp of a signed agent request
+// may be from the controller's clock.
+const agentRequestMaxSkew = 5 * time.Minute
+
+// authenticateAgent identifies the node making a REST request from its
+// X-Node-Address header. The request must carry a client certificate issued
+// for that address, or be signed with the signing key the node registered
+// on its first signed join (see protocol.AgentRequestSignedData). As for
+// the WebSocket, a certificate is required with tls.require_agent_cert.
+func (ctrl *Controller) authenticateAgent(c *gin.Context) (string, error) {
+	nodeAddr := c.GetHeader("X-Node-Address")
+	if nodeAddr == "" {
+		return "", errors.New("missing X-Node-Address")
+	}
+	if err := ctrl.checkAgentCert(c.Request, nodeAddr); err != nil {
+		return "", err
+	}
+	if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		return nodeAddr, nil // the certificate names the node
+	}
+
+	var node Node
+	if err := ctrl.db.Select("signing_key").First(&node, "address = ?", nodeAddr).Error; err != nil {
+		return "", errors.New("unknown node")
+	}
+	key, err := hex.DecodeString(node.SigningKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", errors.New("node has no registered signing key; connect once with a signing agent first")
+	}
+	ts, err := strconv.ParseInt(c.GetHeader("X-Timestamp"), 10, 64)
+	if err != nil {
+		return "", errors.New("missing or invalid X-Timestamp")
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > agentRequestMaxSkew || skew < -agentRequestMaxSkew {
+		return "", errors.New("X-Timestamp too far from the controller's clock")
+	}
+	data := protocol.AgentRequestSignedData(nodeAddr, ts, c.Request.Method, c.Request.URL.Path)
+	if sig, err := hex.DecodeString(c.GetHeader("X-Signature")); err != nil || !ed25519.Verify(key, data, sig) {
+		return "", errors.New("invalid request signature")
+	}
+	return nodeAddr, nil
+}
+
+// getAgentNetworkConfig returns the calling node's config for a network,
+// the payload agents otherwise get over the WebSocket, for integrations
+// that fetch it once over REST.
+func (ctrl *Controller) getAgentNetworkConfig(c *gin.Context) {
+	nodeAddr, err := ctrl.authenticateAgent(c)
+	if err != nil {
+		abortError(c, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, err.Error())
+		return
+	}
+
+	config, err := ctrl.ws.networkConfig(c.Param("id"), nodeAddr)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusForbidden, protocol.ErrCodeForbidden, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, config)
+}
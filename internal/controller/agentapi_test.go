@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// agentRequest sends a GET to path as the node of id, signed by signer at
+// time ts.
+func agentRequest(t *testing.T, ctrl *Controller, id, signer *identity.Identity, path string, ts time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+	addr := id.Address.String()
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("X-Node-Address", addr)
+	req.Header.Set("X-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+	req.Header.Set("X-Signature", hex.EncodeToString(signer.Sign(protocol.AgentRequestSignedData(addr, ts.Unix(), "GET", path))))
+	w := httptest.NewRecorder()
+	ctrl.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAgentNetworkConfig(t *testing.T) {
+	ctrl := newTestController(t)
+	id, peer := newTestIdentity(t), newTestIdentity(t)
+	addr := id.Address.String()
+	for _, n := range []*identity.Identity{id, peer} {
+		ctrl.db.Create(&Node{Address: n.Address.String(), PublicKey: n.PublicKeyHex(), SigningKey: hex.EncodeToString(n.SigningPublicKey())})
+	}
+	psk := strings.Repeat("ab", 32)
+	ctrl.db.Create(&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", MTU: 1400, PSK: psk})
+	ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: peer.Address.String(), Authorized: true, IPAddress: "10.1.0.3/24"})
+	const path = "/api/v1/networks/1/config"
+	now := time.Now()
+
+	// A node that is not a member yet gets a pending membership, as on the
+	// WebSocket
+	if w := agentRequest(t, ctrl, id, id, path, now); w.Code != http.StatusForbidden {
+		t.Fatalf("pending node: HTTP %d: %s", w.Code, w.Body)
+	}
+	var member Member
+	if err := ctrl.db.First(&member, "network_id = 1 AND node_address = ?", addr).Error; err != nil || member.Authorized {
+		t.Fatalf("membership after the first request = %+v, %v", member, err)
+	}
+
+	ctrl.db.Model(&member).Updates(map[string]interface{}{"authorized": true, "ip_address": "10.1.0.2/24"})
+	w := agentRequest(t, ctrl, id, id, path, now)
+	var cfg protocol.NetworkConfigMessage
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &cfg) != nil {
+		t.Fatalf("authorized node: HTTP %d: %s", w.Code, w.Body)
+	}
+	if cfg.NetworkID != "1" || cfg.PSK != psk || cfg.AssignedIP != "10.1.0.2/24" || cfg.MTU != 1400 {
+		t.Fatalf("config = %+v", cfg)
+	}
+	if len(cfg.Peers) != 1 || cfg.Peers[0].Address != peer.Address.String() || cfg.Peers[0].IP != "10.1.0.3/24" {
+		t.Fatalf("peers = %+v", cfg.Peers)
+	}
+
+	// Requests the node did not sign, or signed long ago, are refused
+	for name, w := range map[string]*httptest.ResponseRecorder{
+		"signed by another node": agentRequest(t, ctrl, id, peer, path, now),
+		"stale timestamp":        agentRequest(t, ctrl, id, id, path, now.Add(-agentRequestMaxSkew-time.Minute)),
+	} {
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: HTTP %d", name, w.Code)
+		}
+	}
+	if w := request(t, ctrl, "GET", path, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request: HTTP %d", w.Code)
+	}
+	if w := agentRequest(t, ctrl, id, id, "/api/v1/networks/9/config", now); w.Code != http.StatusNotFound {
+		t.Errorf("unknown network: HTTP %d", w.Code)
+	}
+}
@@ -1,38 +1,69 @@
 package controller
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
 	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"gorm.io/gorm"
 )
 
 // SetupRoutes configures all API routes.
 func (ctrl *Controller) SetupRoutes(r *gin.Engine) {
+	// Probes for load balancers and orchestrators
+	r.GET("/healthz", ctrl.handleHealthz)
+	r.GET("/readyz", ctrl.handleReadyz)
+
 	// Public routes
 	r.POST("/api/v1/auth/login", ctrl.handleLogin)
 	r.POST("/api/v1/auth/register", ctrl.handleRegister)
+	r.POST("/api/v1/auth/refresh", ctrl.handleRefresh)
+
+	// API documentation
+	r.GET("/api/v1/openapi.json", ctrl.serveOpenAPI)
+	r.GET("/docs", ctrl.serveDocs)
 
 	// Agent WebSocket (authenticated via headers)
 	r.GET("/api/v1/agent/connect", ctrl.ws.HandleAgentConnect)
+	// Identity rotation (authenticated by the node's signing key)
+	r.POST("/api/v1/agent/rotate-identity", ctrl.rotateIdentity)
+	// Network config over REST (authenticated by the node's signing key or
+	// client certificate)
+	r.GET("/api/v1/networks/:id/config", ctrl.getAgentNetworkConfig)
 
 	// Protected API routes
 	api := r.Group("/api/v1")
-	api.Use(AuthMiddleware(ctrl.jwtSecret))
+	api.Use(AuthMiddleware(ctrl.jwtSecret, ctrl.revoked))
 	{
+		api.POST("/auth/logout", ctrl.handleLogout)
+		api.POST("/auth/password", ctrl.handleChangePassword)
+
 		// Networks
 		api.GET("/networks", ctrl.listNetworks)
 		api.POST("/networks", ctrl.createNetwork)
 		api.GET("/networks/:id", ctrl.getNetwork)
 		api.PUT("/networks/:id", ctrl.updateNetwork)
-		api.DELETE("/networks/:id", ctrl.deleteNetwork)
+		api.DELETE("/networks/:id", RequireAdmin(), ctrl.deleteNetwork)
+		api.POST("/networks/:id/restore", RequireAdmin(), ctrl.restoreNetwork)
+		api.POST("/networks/:id/purge", RequireAdmin(), ctrl.purgeNetwork)
+		api.GET("/networks/:id/export", RequireAdmin(), ctrl.exportNetwork)
+		api.GET("/networks/:id/usage", ctrl.getNetworkUsage)
+		api.GET("/networks/:id/topology", ctrl.getNetworkTopology)
+		api.POST("/networks/import", RequireAdmin(), ctrl.importNetwork)
+		api.GET("/networks/:id/psk", RequireAdmin(), ctrl.getNetworkPSK)
+		api.POST("/networks/:id/psk/rotate", RequireAdmin(), ctrl.rotateNetworkPSK)
+		api.POST("/networks/:id/rules/evaluate", ctrl.evaluateRules)
 
 		// Members
 		api.GET("/networks/:id/members", ctrl.listMembers)
@@ -40,8 +71,16 @@ func (ctrl *Controller) SetupRoutes(r *gin.Engine) {
 		api.PUT("/networks/:id/members/:nid", ctrl.updateMember)
 		api.DELETE("/networks/:id/members/:nid", ctrl.removeMember)
 
+		// Nodes
+		api.PUT("/nodes/:address", RequireAdmin(), ctrl.updateNode)
+		api.DELETE("/nodes/:address", RequireAdmin(), ctrl.deleteNode)
+		api.POST("/nodes/:address/disconnect", RequireAdmin(), ctrl.disconnectNode)
+
 		// Peers (real-time status)
 		api.GET("/peers", ctrl.listPeers)
+
+		// Change events (Server-Sent Events)
+		api.GET("/events", ctrl.streamEvents)
 	}
 }
 
@@ -50,24 +89,60 @@ func (ctrl *Controller) SetupRoutes(r *gin.Engine) {
 func (ctrl *Controller) handleLogin(c *gin.Context) {
 	var req protocol.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
 	var user User
 	if err := ctrl.db.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		abortError(c, http.StatusUnauthorized, protocol.ErrCodeInvalidCredentials, "invalid credentials")
 		return
 	}
 
 	if !CheckPassword(req.Password, user.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		abortError(c, http.StatusUnauthorized, protocol.ErrCodeInvalidCredentials, "invalid credentials")
 		return
 	}
 
 	token, expiresAt, err := GenerateToken(&user, ctrl.jwtSecret)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "generate token failed"})
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "generate token failed")
+		return
+	}
+	refresh, refreshExpiresAt, err := IssueRefreshToken(ctrl.db, &user)
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "generate refresh token failed")
+		return
+	}
+
+	c.JSON(http.StatusOK, protocol.LoginResponse{
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpiresAt,
+
+		PasswordChangeRequired: user.MustChangePassword,
+	})
+}
+
+// handleRefresh exchanges a refresh token for a new access token. The
+// refresh token stays valid until it expires or is revoked.
+func (ctrl *Controller) handleRefresh(c *gin.Context) {
+	var req protocol.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	user, err := RedeemRefreshToken(ctrl.db, req.RefreshToken)
+	if err != nil {
+		abortError(c, http.StatusUnauthorized, protocol.ErrCodeInvalidToken, err.Error())
+		return
+	}
+
+	token, expiresAt, err := GenerateToken(user, ctrl.jwtSecret)
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "generate token failed")
 		return
 	}
 
@@ -77,10 +152,37 @@ func (ctrl *Controller) handleLogin(c *gin.Context) {
 	})
 }
 
+// handleLogout revokes the access token the request was made with and, if
+// given, a refresh token.
+func (ctrl *Controller) handleLogout(c *gin.Context) {
+	var req protocol.LogoutRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+
+	claims := c.MustGet("claims").(*Claims)
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := ctrl.revoked.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "revoke token failed")
+			return
+		}
+	}
+	if req.RefreshToken != "" {
+		if err := RevokeRefreshToken(ctrl.db, req.RefreshToken); err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "revoke refresh token failed")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": true})
+}
+
 func (ctrl *Controller) handleRegister(c *gin.Context) {
 	var req protocol.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
@@ -88,38 +190,95 @@ func (ctrl *Controller) handleRegister(c *gin.Context) {
 	var count int64
 	ctrl.db.Model(&User{}).Count(&count)
 	if count > 0 {
-		// Require authentication for subsequent registrations
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusForbidden, gin.H{"error": "registration requires admin authentication"})
+		// Subsequent registrations need a valid admin token
+		claims, err := ValidateToken(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "), ctrl.jwtSecret)
+		if err != nil || claims.Role != "admin" || claims.MustChangePassword || (claims.ID != "" && ctrl.revoked.IsRevoked(claims.ID)) {
+			abortError(c, http.StatusForbidden, protocol.ErrCodeAdminRequired, "registration requires admin authentication")
 			return
 		}
 	}
 
+	if err := ValidatePassword(req.Password); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
 	hash, err := HashPassword(req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "hash password failed"})
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "hash password failed")
 		return
 	}
 
+	// Registered users are never admins; see ensureAdminUser
 	user := User{
 		Username: req.Username,
 		Password: hash,
-		Role:     "admin",
+		Role:     "user",
 	}
 	if err := ctrl.db.Create(&user).Error; err != nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "username already exists"})
+		abortError(c, http.StatusConflict, protocol.ErrCodeUsernameTaken, "username already exists")
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{"id": user.ID, "username": user.Username})
 }
 
+// handleChangePassword sets a new password for the authenticated user after
+// checking the current one. Refresh tokens issued before the change are
+// revoked; access tokens stay valid until they expire or are logged out. It
+// also lifts a forced password change, from the next login on.
+func (ctrl *Controller) handleChangePassword(c *gin.Context) {
+	var req protocol.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	var user User
+	if err := ctrl.db.First(&user, c.GetUint("user_id")).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeUserNotFound, "user not found")
+		return
+	}
+	if !CheckPassword(req.CurrentPassword, user.Password) {
+		abortError(c, http.StatusForbidden, protocol.ErrCodeInvalidCredentials, "current password is incorrect")
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "new password must differ from the current one")
+		return
+	}
+	if err := ValidatePassword(req.NewPassword); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "hash password failed")
+		return
+	}
+	if err := ctrl.db.Model(&user).Updates(map[string]interface{}{"password": hash, "must_change_password": false}).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "update password failed")
+		return
+	}
+	if err := RevokeUserRefreshTokens(ctrl.db, user.ID); err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "revoke refresh tokens failed")
+		return
+	}
+	ctrl.requestLog(c).Info("password changed", "user", user.Username)
+
+	c.JSON(http.StatusOK, gin.H{"changed": true})
+}
+
 // --- Network handlers ---
 
 func (ctrl *Controller) listNetworks(c *gin.Context) {
 	var networks []Network
-	ctrl.db.Find(&networks)
+	if c.Query("deleted") == "true" {
+		ctrl.db.Unscoped().Where("deleted_at IS NOT NULL").Find(&networks)
+	} else {
+		ctrl.db.Find(&networks)
+	}
 
 	online := ctrl.ws.GetOnlineAgents()
 	result := make([]protocol.Network, 0, len(networks))
@@ -137,16 +296,25 @@ func (ctrl *Controller) listNetworks(c *gin.Context) {
 		}
 
 		result = append(result, protocol.Network{
-			ID:          n.ID,
-			Name:        n.Name,
-			Description: n.Description,
-			IPRange:     n.IPRange,
-			IP6Range:    n.IP6Range,
-			MTU:         n.MTU,
-			Multicast:   n.Multicast,
-			MemberCount: int(memberCount),
-			OnlineCount: onlineCount,
-			CreatedAt:   n.CreatedAt,
+			ID:             n.ID,
+			Name:           n.Name,
+			Description:    n.Description,
+			IPRange:        n.IPRange,
+			IP6Range:       n.IP6Range,
+			MTU:            n.MTU,
+			Multicast:      n.Multicast,
+			ReservedRanges: n.ReservedRanges,
+			GatewayIP:      n.GatewayIP,
+			DNSServers:     n.DNSServers,
+			SearchDomains:  n.SearchDomains,
+			Private:        n.Private,
+			MemberCount:    int(memberCount),
+			OnlineCount:    onlineCount,
+			CreatedAt:      n.CreatedAt,
+			DeletedAt:      n.DeletedAt.Time,
+
+			SourceValidation: n.SourceValidation,
+			Tables:           n.tableLimits(),
 		})
 	}
 	c.JSON(http.StatusOK, result)
@@ -155,7 +323,29 @@ func (ctrl *Controller) listNetworks(c *gin.Context) {
 func (ctrl *Controller) createNetwork(c *gin.Context) {
 	var req protocol.CreateNetworkRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := validateAddressPlan(req.IPRange, req.ReservedRanges, req.GatewayIP); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if req.IP6Range != "" {
+		if err := validateIP6Range(req.IP6Range); err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if err := validateDNS(req.DNSServers, req.SearchDomains); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	var tables protocol.TableLimits
+	if req.Tables != nil {
+		tables = *req.Tables
+	}
+	if err := validateTableLimits(tables); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
@@ -172,6 +362,7 @@ func (ctrl *Controller) createNetwork(c *gin.Context) {
 	if req.Multicast != nil {
 		multicast = *req.Multicast
 	}
+	private := req.Private != nil && *req.Private
 
 	// Generate per-network PSK (32 bytes)
 	var pskBytes [32]byte
@@ -179,41 +370,63 @@ func (ctrl *Controller) createNetwork(c *gin.Context) {
 	pskHex := hex.EncodeToString(pskBytes[:])
 
 	network := Network{
-		ID:          networkID,
-		Name:        req.Name,
-		Description: req.Description,
-		IPRange:     req.IPRange,
-		IP6Range:    req.IP6Range,
-		MTU:         mtu,
-		Multicast:   multicast,
-		PSK:         pskHex,
+		ID:             networkID,
+		Name:           req.Name,
+		Description:    req.Description,
+		IPRange:        req.IPRange,
+		IP6Range:       req.IP6Range,
+		MTU:            mtu,
+		Multicast:      multicast,
+		ReservedRanges: req.ReservedRanges,
+		GatewayIP:      req.GatewayIP,
+		DNSServers:     req.DNSServers,
+		SearchDomains:  req.SearchDomains,
+		PSK:            pskHex,
+		Private:        private,
+
+		SourceValidation: req.SourceValidation != nil && *req.SourceValidation,
 	}
+	network.setTableLimits(tables)
 
 	if err := ctrl.db.Create(&network).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "create network failed"})
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "create network failed")
 		return
 	}
 
+	ctrl.events.Publish(protocol.Event{
+		Type:      protocol.EventNetworkCreated,
+		NetworkID: network.ID,
+		Data:      gin.H{"name": network.Name, "ip_range": network.IPRange},
+	})
+
 	c.JSON(http.StatusCreated, protocol.Network{
-		ID:        network.ID,
-		Name:      network.Name,
-		IPRange:   network.IPRange,
-		MTU:       network.MTU,
-		Multicast: network.Multicast,
-		CreatedAt: network.CreatedAt,
+		ID:             network.ID,
+		Name:           network.Name,
+		IPRange:        network.IPRange,
+		MTU:            network.MTU,
+		Multicast:      network.Multicast,
+		ReservedRanges: network.ReservedRanges,
+		GatewayIP:      network.GatewayIP,
+		DNSServers:     network.DNSServers,
+		SearchDomains:  network.SearchDomains,
+		Private:        network.Private,
+		CreatedAt:      network.CreatedAt,
+
+		SourceValidation: network.SourceValidation,
+		Tables:           network.tableLimits(),
 	})
 }
 
 func (ctrl *Controller) getNetwork(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid network ID"})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
 		return
 	}
 
 	var network Network
 	if err := ctrl.db.First(&network, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "network not found"})
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
 		return
 	}
 
@@ -223,19 +436,19 @@ func (ctrl *Controller) getNetwork(c *gin.Context) {
 func (ctrl *Controller) updateNetwork(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid network ID"})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
 		return
 	}
 
 	var network Network
 	if err := ctrl.db.First(&network, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "network not found"})
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
 		return
 	}
 
 	var req protocol.CreateNetworkRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
@@ -255,20 +468,261 @@ func (ctrl *Controller) updateNetwork(c *gin.Context) {
 	if req.Multicast != nil {
 		updates["multicast"] = *req.Multicast
 	}
+	if req.Private != nil {
+		updates["private"] = *req.Private
+	}
+	guardChanged := req.SourceValidation != nil && *req.SourceValidation != network.SourceValidation
+	if req.SourceValidation != nil {
+		updates["source_validation"] = *req.SourceValidation
+	}
+	if req.GatewayIP != "" {
+		updates["gateway_ip"] = req.GatewayIP
+	}
+
+	ipRange := network.IPRange
+	if req.IPRange != "" {
+		ipRange = req.IPRange
+	}
+	reserved := network.ReservedRanges
+	if req.ReservedRanges != nil {
+		reserved = req.ReservedRanges
+	}
+	gatewayIP := network.GatewayIP
+	if req.GatewayIP != "" {
+		gatewayIP = req.GatewayIP
+	}
+	if err := validateAddressPlan(ipRange, reserved, gatewayIP); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	dnsServers := network.DNSServers
+	if req.DNSServers != nil {
+		dnsServers = req.DNSServers
+	}
+	searchDomains := network.SearchDomains
+	if req.SearchDomains != nil {
+		searchDomains = req.SearchDomains
+	}
+	if err := validateDNS(dnsServers, searchDomains); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Tables != nil {
+		if err := validateTableLimits(*req.Tables); err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
 
+	// Map updates bypass the JSON serializer, so lists go through the model
+	if req.ReservedRanges != nil {
+		network.ReservedRanges = req.ReservedRanges
+		ctrl.db.Model(&network).Select("reserved_ranges").Updates(&network)
+	}
+	tablesChanged := req.Tables != nil && *req.Tables != network.tableLimits()
+	if req.Tables != nil {
+		// Zero fields must be written too, so go through the model
+		network.setTableLimits(*req.Tables)
+		ctrl.db.Model(&network).Select("mac_table_size", "mac_table_expiry", "arp_cache_size", "arp_cache_expiry").Updates(&network)
+	}
+	dnsChanged := req.DNSServers != nil || req.SearchDomains != nil
+	if dnsChanged {
+		network.DNSServers = dnsServers
+		network.SearchDomains = searchDomains
+		ctrl.db.Model(&network).Select("dns_servers", "search_domains").Updates(&network)
+	}
 	ctrl.db.Model(&network).Updates(updates)
+	if req.ReservedRanges != nil {
+		updates["reserved_ranges"] = req.ReservedRanges
+	}
+	if dnsChanged {
+		updates["dns_servers"] = dnsServers
+		updates["search_domains"] = searchDomains
+	}
+	if req.Tables != nil {
+		updates["tables"] = *req.Tables
+	}
 	ctrl.db.First(&network, id)
 
+	// Members apply DNS, source validation and table settings from the
+	// network config
+	if dnsChanged || guardChanged || tablesChanged {
+		ctrl.ws.SendNetworkConfigToNetwork(network.ID)
+	}
+
+	ctrl.events.Publish(protocol.Event{
+		Type:      protocol.EventNetworkUpdated,
+		NetworkID: network.ID,
+		Data:      updates,
+	})
+
 	c.JSON(http.StatusOK, network)
 }
 
+// getNetworkPSK returns a network's pre-shared key.
+func (ctrl *Controller) getNetworkPSK(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.Select("id", "psk").First(&network, id).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, protocol.NetworkPSK{NetworkID: network.ID, PSK: network.PSK})
+}
+
+// rotateNetworkPSK replaces a network's pre-shared key and pushes it to the
+// online members. Established sessions survive, but handshakes between a
+// member that has the new key and one that does not fail until both do.
+func (ctrl *Controller) rotateNetworkPSK(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.First(&network, id).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
+		return
+	}
+
+	var pskBytes [32]byte
+	if _, err := rand.Read(pskBytes[:]); err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "generate PSK failed")
+		return
+	}
+	psk := hex.EncodeToString(pskBytes[:])
+	if err := ctrl.db.Model(&network).Update("psk", psk).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "rotate PSK failed")
+		return
+	}
+
+	ctrl.ws.SendNetworkConfigToNetwork(network.ID)
+	ctrl.events.Publish(protocol.Event{
+		Type:      protocol.EventNetworkUpdated,
+		NetworkID: network.ID,
+		Data:      gin.H{"psk_rotated": true},
+	})
+	ctrl.requestLog(c).Info("network PSK rotated", "network", network.ID, "by", c.GetString("username"))
+
+	c.JSON(http.StatusOK, protocol.NetworkPSK{NetworkID: network.ID, PSK: psk})
+}
+
 func (ctrl *Controller) deleteNetwork(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid network ID"})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.First(&network, id).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
 		return
 	}
-	ctrl.db.Delete(&Network{}, id)
+	var members []Member
+	ctrl.db.Select("node_address").Where("network_id = ?", network.ID).Find(&members)
+
+	// Soft delete: members and rules stay so the network can be restored,
+	// purgeNetwork removes them along with the network. Meanwhile queries
+	// that grant access skip them (inLiveNetwork).
+	if err := ctrl.db.Delete(&network).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "delete network failed")
+		return
+	}
+
+	ctrl.ws.SendNetworkDeleted(network.ID)
+	// Agents that predate network_deleted drop the peers one by one
+	for _, m := range members {
+		ctrl.ws.BroadcastPeerUpdate(network.ID, "remove", protocol.PeerInfo{Address: m.NodeAddress})
+	}
+	ctrl.events.Publish(protocol.Event{
+		Type:      protocol.EventNetworkDeleted,
+		NetworkID: network.ID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+// restoreNetwork undoes deleteNetwork and pushes the config to the members
+// that are still connected.
+func (ctrl *Controller) restoreNetwork(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.Unscoped().Where("deleted_at IS NOT NULL").First(&network, id).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "deleted network not found")
+		return
+	}
+	if err := ctrl.db.Unscoped().Model(&network).Update("deleted_at", nil).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "restore network failed")
+		return
+	}
+	network.DeletedAt = gorm.DeletedAt{}
+
+	ctrl.ws.SendNetworkConfigToNetwork(network.ID)
+	ctrl.events.Publish(protocol.Event{
+		Type:      protocol.EventNetworkRestored,
+		NetworkID: network.ID,
+	})
+
+	c.JSON(http.StatusOK, network)
+}
+
+// networkPurgeGrace is how long a deleted network stays restorable before
+// purgeNetwork may remove it for good.
+const networkPurgeGrace = 24 * time.Hour
+
+// purgeNetwork permanently removes a deleted network with its members, rules
+// and usage once the grace period has passed.
+func (ctrl *Controller) purgeNetwork(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.Unscoped().Where("deleted_at IS NOT NULL").First(&network, id).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "deleted network not found")
+		return
+	}
+	if purgeAt := network.DeletedAt.Time.Add(networkPurgeGrace); time.Now().Before(purgeAt) {
+		abortErrorDetails(c, http.StatusConflict, protocol.ErrCodeNotPurgeable,
+			"network can be purged after "+purgeAt.UTC().Format(time.RFC3339), gin.H{"purge_at": purgeAt.UTC()})
+		return
+	}
+
+	err = ctrl.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range networkModels() {
+			if err := tx.Where("network_id = ?", network.ID).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(&network).Error
+	})
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "purge network failed: "+err.Error())
+		return
+	}
+
+	ctrl.events.Publish(protocol.Event{
+		Type:      protocol.EventNetworkDeleted,
+		NetworkID: network.ID,
+		Data:      gin.H{"purged": true},
+	})
+	ctrl.requestLog(c).Info("network purged", "network", network.ID, "by", c.GetString("username"))
+
 	c.JSON(http.StatusOK, gin.H{"deleted": true})
 }
 
@@ -277,12 +731,35 @@ func (ctrl *Controller) deleteNetwork(c *gin.Context) {
 func (ctrl *Controller) listMembers(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid network ID"})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	q := ctrl.db.Model(&Member{}).Where("network_id = ?", id)
+	if search := c.Query("q"); search != "" {
+		pattern := likePattern(search)
+		q = q.Where("(LOWER(name) LIKE ? ESCAPE '\\' OR LOWER(node_address) LIKE ? ESCAPE '\\' OR node_address IN (?))",
+			pattern, pattern,
+			ctrl.db.Model(&Node{}).Select("address").Where("LOWER(name) LIKE ? ESCAPE '\\'", pattern))
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "list members failed")
 		return
 	}
 
 	var members []Member
-	ctrl.db.Where("network_id = ?", id).Preload("Node").Find(&members)
+	if err := q.Order("node_address").Limit(limit).Offset(offset).Preload("Node").Find(&members).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "list members failed")
+		return
+	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
 
 	online := ctrl.ws.GetOnlineAgents()
 	result := make([]protocol.Member, 0, len(members))
@@ -293,6 +770,10 @@ func (ctrl *Controller) listMembers(c *gin.Context) {
 			Authorized:  m.Authorized,
 			IPAddress:   m.IPAddress,
 			Name:        m.Name,
+			Gateway:     m.Gateway,
+			HasPSK:      m.PSK != "",
+			NodeName:    m.Node.Name,
+			NodeDesc:    m.Node.Description,
 			Online:      online[m.NodeAddress],
 			Platform:    m.Node.Platform,
 			LastSeen:    m.Node.LastSeen,
@@ -305,33 +786,28 @@ func (ctrl *Controller) listMembers(c *gin.Context) {
 func (ctrl *Controller) authorizeMember(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid network ID"})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
 		return
 	}
 
 	var req protocol.AuthorizeMemberRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if req.PSK != nil && *req.PSK != "" && !validPSK(*req.PSK) {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "psk must be 64 hex characters")
 		return
 	}
 
-	// Get network for IP allocation
+	// Get network for IP allocation. A deleted network keeps its members
+	// until purged but takes no new ones and authorizes none of them.
 	var network Network
 	if err := ctrl.db.First(&network, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "network not found"})
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
 		return
 	}
 
-	// Auto-allocate IP if authorizing and no IP specified
-	if req.Authorized && req.IPAddress == "" {
-		allocatedIP, err := ctrl.allocateIP(network)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "IP allocation failed: " + err.Error()})
-			return
-		}
-		req.IPAddress = allocatedIP
-	}
-
 	member := Member{
 		NetworkID:   uint32(id),
 		NodeAddress: req.NodeAddress,
@@ -340,25 +816,73 @@ func (ctrl *Controller) authorizeMember(c *gin.Context) {
 		Name:        req.Name,
 	}
 
-	result := ctrl.db.Where("network_id = ? AND node_address = ?", id, req.NodeAddress).
-		Assign(member).FirstOrCreate(&member)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "authorize member failed"})
+	// Allocation and member write must commit before the next allocation in
+	// this network reads the used IPs, or two requests can get the same IP
+	lock := ctrl.ipLock(network.ID)
+	lock.Lock()
+	err = ctrl.db.Transaction(func(tx *gorm.DB) error {
+		// Auto-allocate IP if authorizing and no IP specified
+		if req.Authorized && req.IPAddress == "" {
+			used, err := usedIPs(tx, network)
+			if err != nil {
+				return err
+			}
+			allocatedIP, err := AllocateIP(network.IPRange, used, network.ReservedRanges...)
+			if err != nil {
+				return fmt.Errorf("IP allocation failed: %w", err)
+			}
+			member.IPAddress = allocatedIP
+		}
+		return tx.Where("network_id = ? AND node_address = ?", id, req.NodeAddress).
+			Assign(member).FirstOrCreate(&member).Error
+	})
+	lock.Unlock()
+	if errors.Is(err, errIPExhausted) {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeIPExhausted, "authorize member failed: "+err.Error())
 		return
 	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "authorize member failed: "+err.Error())
+		return
+	}
+	if req.Gateway != nil {
+		if err := ctrl.setGateway(uint32(id), req.NodeAddress, *req.Gateway); err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "set gateway failed: "+err.Error())
+			return
+		}
+		member.Gateway = *req.Gateway
+	}
+	if req.PSK != nil {
+		if err := ctrl.setMemberPSK(uint32(id), req.NodeAddress, *req.PSK); err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "set PSK failed: "+err.Error())
+			return
+		}
+		member.PSK = *req.PSK
+	}
 
 	// If authorizing, push full network config to the agent and notify other peers
 	if req.Authorized {
+		ctrl.events.Publish(protocol.Event{
+			Type:        protocol.EventMemberAuthorized,
+			NetworkID:   uint32(id),
+			NodeAddress: req.NodeAddress,
+			Data:        gin.H{"ip_address": member.IPAddress, "name": member.Name},
+		})
+
 		var node Node
 		if err := ctrl.db.First(&node, "address = ?", req.NodeAddress).Error; err == nil {
 			// Push network config to the newly authorized agent
 			ctrl.ws.SendNetworkConfigToAgent(req.NodeAddress, fmt.Sprintf("%d", id))
 
+			// Have the new member and existing online peers punch simultaneously
+			ctrl.ws.CoordinatePunch(uint32(id), req.NodeAddress)
+
 			// Notify all other connected agents about the new peer
 			ctrl.ws.BroadcastPeerUpdate(uint32(id), "add", protocol.PeerInfo{
 				Address:   node.Address,
 				PublicKey: node.PublicKey,
 				Name:      req.Name,
+				IP:        member.IPAddress,
 			})
 		}
 	}
@@ -366,70 +890,21 @@ func (ctrl *Controller) authorizeMember(c *gin.Context) {
 	c.JSON(http.StatusOK, member)
 }
 
-// allocateIP finds the next available IP in the network's range.
-func (ctrl *Controller) allocateIP(network Network) (string, error) {
-	_, ipNet, err := net.ParseCIDR(network.IPRange)
-	if err != nil {
-		return "", fmt.Errorf("invalid IP range: %w", err)
-	}
-
-	// Get all used IPs in this network
-	var members []Member
-	ctrl.db.Where("network_id = ? AND ip_address != ''", network.ID).Find(&members)
-	usedIPs := make(map[string]bool)
-	for _, m := range members {
-		// Extract IP from CIDR notation if present
-		ip, _, err := net.ParseCIDR(m.IPAddress)
-		if err != nil {
-			ip = net.ParseIP(m.IPAddress)
-		}
-		if ip != nil {
-			usedIPs[ip.String()] = true
-		}
-	}
-
-	// Get mask size for CIDR notation
-	ones, _ := ipNet.Mask.Size()
-
-	// Iterate through IPs, skip network and broadcast
-	ip := make(net.IP, len(ipNet.IP))
-	copy(ip, ipNet.IP)
-	for inc(ip); ipNet.Contains(ip); inc(ip) {
-		// Skip broadcast (last IP)
-		ipCopy := make(net.IP, len(ip))
-		copy(ipCopy, ip)
-		inc(ipCopy)
-		if !ipNet.Contains(ipCopy) {
-			break // this is the broadcast address
-		}
-		if !usedIPs[ip.String()] {
-			return fmt.Sprintf("%s/%d", ip.String(), ones), nil
-		}
-	}
-	return "", fmt.Errorf("no available IPs in range %s", network.IPRange)
-}
-
-// inc increments an IP address by one.
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
-	}
-}
-
 func (ctrl *Controller) updateMember(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid network ID"})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
 		return
 	}
 	nodeAddr := c.Param("nid")
 
 	var req protocol.AuthorizeMemberRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if req.PSK != nil && *req.PSK != "" && !validPSK(*req.PSK) {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "psk must be 64 hex characters")
 		return
 	}
 
@@ -446,53 +921,529 @@ func (ctrl *Controller) updateMember(c *gin.Context) {
 		Where("network_id = ? AND node_address = ?", id, nodeAddr).
 		Updates(updates)
 	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		abortError(c, http.StatusNotFound, protocol.ErrCodeMemberNotFound, "member not found")
 		return
 	}
+	if req.Gateway != nil {
+		if err := ctrl.setGateway(uint32(id), nodeAddr, *req.Gateway); err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "set gateway failed: "+err.Error())
+			return
+		}
+		updates["gateway"] = *req.Gateway
+	}
+	if req.PSK != nil {
+		if err := ctrl.setMemberPSK(uint32(id), nodeAddr, *req.PSK); err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "set PSK failed: "+err.Error())
+			return
+		}
+		// Never echo the key into the event stream
+		updates["psk_override"] = *req.PSK != ""
+	}
 
 	var member Member
 	ctrl.db.First(&member, "network_id = ? AND node_address = ?", id, nodeAddr)
+
+	ctrl.events.Publish(protocol.Event{
+		Type:        protocol.EventMemberUpdated,
+		NetworkID:   uint32(id),
+		NodeAddress: nodeAddr,
+		Data:        updates,
+	})
+
 	c.JSON(http.StatusOK, member)
 }
 
+// setGateway makes a member the network's default gateway, replacing any
+// previous one, or clears the flag. Every member's routes depend on it, so
+// the config is pushed to the whole network.
+func (ctrl *Controller) setGateway(networkID uint32, nodeAddr string, gateway bool) error {
+	err := ctrl.db.Transaction(func(tx *gorm.DB) error {
+		if gateway {
+			if err := tx.Model(&Member{}).
+				Where("network_id = ? AND node_address != ? AND gateway = ?", networkID, nodeAddr, true).
+				Update("gateway", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&Member{}).
+			Where("network_id = ? AND node_address = ?", networkID, nodeAddr).
+			Update("gateway", gateway).Error
+	})
+	if err != nil {
+		return err
+	}
+	ctrl.ws.SendNetworkConfigToNetwork(networkID)
+	return nil
+}
+
+// setMemberPSK sets or clears a member's PSK override. It changes the key of
+// every pair the member is part of, so the config is pushed to the whole
+// network; like a network PSK rotation, established sessions keep their keys.
+func (ctrl *Controller) setMemberPSK(networkID uint32, nodeAddr, psk string) error {
+	err := ctrl.db.Model(&Member{}).
+		Where("network_id = ? AND node_address = ?", networkID, nodeAddr).
+		Update("psk", psk).Error
+	if err != nil {
+		return err
+	}
+	ctrl.ws.SendNetworkConfigToNetwork(networkID)
+	return nil
+}
+
 func (ctrl *Controller) removeMember(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid network ID"})
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
 		return
 	}
 	nodeAddr := c.Param("nid")
 
+	var removed Member
+	ctrl.db.Where("network_id = ? AND node_address = ?", id, nodeAddr).Limit(1).Find(&removed)
 	ctrl.db.Where("network_id = ? AND node_address = ?", id, nodeAddr).Delete(&Member{})
 
-	// Notify peers
+	// Notify peers; full-tunnel members must also drop a removed gateway
 	ctrl.ws.BroadcastPeerUpdate(uint32(id), "remove", protocol.PeerInfo{Address: nodeAddr})
+	if removed.Gateway {
+		ctrl.ws.SendNetworkConfigToNetwork(uint32(id))
+	}
+
+	ctrl.events.Publish(protocol.Event{
+		Type:        protocol.EventMemberRemoved,
+		NetworkID:   uint32(id),
+		NodeAddress: nodeAddr,
+	})
 
 	c.JSON(http.StatusOK, gin.H{"deleted": true})
 }
 
+// --- Node handlers ---
+
+func (ctrl *Controller) updateNode(c *gin.Context) {
+	addr := c.Param("address")
+
+	var req protocol.UpdateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	var node Node
+	if err := ctrl.db.First(&node, "address = ?", addr).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNodeNotFound, "node not found")
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+
+	if len(updates) > 0 {
+		if err := ctrl.db.Model(&node).Updates(updates).Error; err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "update node failed")
+			return
+		}
+	}
+	ctrl.db.First(&node, "address = ?", addr)
+
+	c.JSON(http.StatusOK, node)
+}
+
+// deleteNode decommissions a node: it removes the node and its memberships in
+// every network and tells the remaining peers to drop it. An online node is
+// only deleted with ?force=true, which also disconnects it.
+func (ctrl *Controller) deleteNode(c *gin.Context) {
+	addr := c.Param("address")
+	force := c.Query("force") == "true"
+
+	var node Node
+	if err := ctrl.db.First(&node, "address = ?", addr).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNodeNotFound, "node not found")
+		return
+	}
+	if ctrl.ws.GetOnlineAgents()[addr] && !force {
+		abortError(c, http.StatusConflict, protocol.ErrCodeNodeOnline, "node is online (use ?force=true to disconnect and delete it)")
+		return
+	}
+
+	var networkIDs, gatewayOf []uint32
+	err := ctrl.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Member{}).Where("node_address = ?", addr).Pluck("network_id", &networkIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Member{}).Where("node_address = ? AND gateway = ?", addr, true).Pluck("network_id", &gatewayOf).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_address = ?", addr).Delete(&Member{}).Error; err != nil {
+			return err
+		}
+		// Usage history is kept for reporting; only the running counters go
+		if err := tx.Where("node_address = ? OR peer_address = ?", addr, addr).Delete(&usageCounter{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&node).Error
+	})
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	if force {
+		ctrl.ws.Disconnect(addr, protocol.CloseDecommissioned, "node decommissioned")
+	}
+	for _, id := range networkIDs {
+		ctrl.ws.BroadcastPeerUpdate(id, "remove", protocol.PeerInfo{Address: addr})
+		ctrl.events.Publish(protocol.Event{
+			Type:        protocol.EventMemberRemoved,
+			NetworkID:   id,
+			NodeAddress: addr,
+		})
+	}
+	for _, id := range gatewayOf {
+		ctrl.ws.SendNetworkConfigToNetwork(id)
+	}
+	ctrl.events.Publish(protocol.Event{Type: protocol.EventNodeDeleted, NodeAddress: addr})
+
+	ctrl.requestLog(c).Info("node decommissioned", "addr", addr, "networks", len(networkIDs), "forced", force)
+	c.JSON(http.StatusOK, gin.H{"deleted": true, "networks": networkIDs})
+}
+
+// disconnectNode closes a node's controller connection with CloseKicked,
+// after which the agent waits out a cooldown before reconnecting. With
+// deauthorize set it also revokes the node's memberships and tells their
+// peers to drop it, so it stays out for good.
+func (ctrl *Controller) disconnectNode(c *gin.Context) {
+	addr := c.Param("address")
+
+	var req protocol.DisconnectNodeRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "disconnected by admin"
+	}
+	// Close frame payloads are limited to 125 bytes, 2 of them the code
+	if len(req.Reason) > 123 {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "reason must be at most 123 bytes")
+		return
+	}
+
+	var node Node
+	if err := ctrl.db.First(&node, "address = ?", addr).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNodeNotFound, "node not found")
+		return
+	}
+	if !ctrl.ws.GetOnlineAgents()[addr] && !req.Deauthorize {
+		abortError(c, http.StatusConflict, protocol.ErrCodeNodeOffline, "node is not online")
+		return
+	}
+
+	networkIDs := []uint32{}
+	if req.Deauthorize {
+		err := ctrl.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&Member{}).Where("node_address = ? AND authorized = ?", addr, true).Pluck("network_id", &networkIDs).Error; err != nil {
+				return err
+			}
+			return tx.Model(&Member{}).Where("node_address = ?", addr).Update("authorized", false).Error
+		})
+		if err != nil {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+			return
+		}
+	}
+
+	disconnected := ctrl.ws.Disconnect(addr, protocol.CloseKicked, req.Reason)
+	for _, id := range networkIDs {
+		ctrl.ws.BroadcastPeerUpdate(id, "remove", protocol.PeerInfo{Address: addr})
+		ctrl.events.Publish(protocol.Event{
+			Type:        protocol.EventMemberUpdated,
+			NetworkID:   id,
+			NodeAddress: addr,
+			Data:        map[string]interface{}{"authorized": false},
+		})
+	}
+
+	ctrl.requestLog(c).Info("node disconnected by admin", "addr", addr, "online", disconnected, "deauthorized", len(networkIDs), "reason", req.Reason)
+	c.JSON(http.StatusOK, gin.H{"disconnected": disconnected, "deauthorized": networkIDs})
+}
+
+var errAddressTaken = errors.New("new address is already registered")
+
+// rotateIdentity moves a node's memberships to a new identity at the
+// request of the node itself, which signs the request with the signing key
+// registered for its old identity. The old node is removed, so a
+// compromised old key no longer grants access to any network.
+func (ctrl *Controller) rotateIdentity(c *gin.Context) {
+	var req protocol.RotateIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	var node Node
+	if err := ctrl.db.First(&node, "address = ?", req.OldAddress).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNodeNotFound, "node not found")
+		return
+	}
+	oldKey, err := hex.DecodeString(node.SigningKey)
+	if err != nil || len(oldKey) != ed25519.PublicKeySize {
+		abortError(c, http.StatusForbidden, protocol.ErrCodeNoSigningKey, "node has no registered signing key; connect once with a signing agent first")
+		return
+	}
+	newPub, err := hex.DecodeString(req.NewPublicKey)
+	if err != nil || identity.ValidatePublicKey(newPub) != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid new_public_key")
+		return
+	}
+	newKey, err := hex.DecodeString(req.NewSigningKey)
+	if err != nil || len(newKey) != ed25519.PublicKeySize {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid new_signing_key")
+		return
+	}
+	if identity.AddressFromPublicKey(newPub).String() != req.NewAddress {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "new_address does not match new_public_key")
+		return
+	}
+
+	data := protocol.RotationSignedData(req.OldAddress, req.NewAddress, req.NewPublicKey, req.NewSigningKey)
+	if sig, err := hex.DecodeString(req.OldSignature); err != nil || !ed25519.Verify(oldKey, data, sig) {
+		abortError(c, http.StatusUnauthorized, protocol.ErrCodeInvalidSignature, "invalid old identity signature")
+		return
+	}
+	if sig, err := hex.DecodeString(req.NewSignature); err != nil || !ed25519.Verify(newKey, data, sig) {
+		abortError(c, http.StatusUnauthorized, protocol.ErrCodeInvalidSignature, "invalid new identity signature")
+		return
+	}
+
+	rotated := node
+	rotated.Address = req.NewAddress
+	rotated.PublicKey = req.NewPublicKey
+	rotated.SigningKey = req.NewSigningKey
+	rotated.CreatedAt = time.Time{}
+	rotated.LongAddress = ""
+	if ctrl.config.LongAddresses {
+		rotated.LongAddress = identity.LongAddressFromPublicKey(newPub).String()
+	}
+
+	var members []Member
+	err = ctrl.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&Node{}).Where("address = ?", rotated.Address).Count(&count)
+		if count > 0 {
+			return errAddressTaken
+		}
+		if err := tx.Create(&rotated).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Member{}).Where("node_address = ?", node.Address).
+			Update("node_address", rotated.Address).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("node_address = ? OR peer_address = ?", node.Address, node.Address).Delete(&usageCounter{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&node).Error; err != nil {
+			return err
+		}
+		return tx.Where("node_address = ?", rotated.Address).Find(&members).Error
+	})
+	if errors.Is(err, errAddressTaken) {
+		abortError(c, http.StatusConflict, protocol.ErrCodeAddressTaken, err.Error())
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "rotate identity failed: "+err.Error())
+		return
+	}
+
+	ctrl.ws.Disconnect(node.Address, protocol.CloseRotated, "identity rotated")
+	networkIDs := make([]uint32, 0, len(members))
+	for _, m := range members {
+		networkIDs = append(networkIDs, m.NetworkID)
+		ctrl.ws.BroadcastPeerUpdate(m.NetworkID, "remove", protocol.PeerInfo{Address: node.Address})
+		if m.Authorized {
+			ctrl.ws.BroadcastPeerUpdate(m.NetworkID, "add", protocol.PeerInfo{
+				Address:   rotated.Address,
+				PublicKey: rotated.PublicKey,
+				Name:      m.Name,
+				IP:        m.IPAddress,
+			})
+		}
+	}
+	ctrl.events.Publish(protocol.Event{
+		Type:        protocol.EventNodeRotated,
+		NodeAddress: node.Address,
+		Data:        gin.H{"new_address": rotated.Address, "networks": networkIDs},
+	})
+
+	ctrl.requestLog(c).Info("node identity rotated", "old", node.Address, "new", rotated.Address, "networks", len(networkIDs))
+	c.JSON(http.StatusOK, protocol.RotateIdentityResponse{Address: rotated.Address, Networks: networkIDs})
+}
+
 // --- Peer status ---
 
 func (ctrl *Controller) listPeers(c *gin.Context) {
-	online := ctrl.ws.GetOnlineAgents()
-	type PeerWithStatus struct {
-		Address  string    `json:"address"`
-		Platform string    `json:"platform"`
-		Online   bool      `json:"online"`
-		LastSeen time.Time `json:"last_seen"`
+	presence := ctrl.ws.GetAgentPresence()
+
+	q := ctrl.db.Model(&Node{})
+	if network := c.Query("network"); network != "" {
+		networkID, err := strconv.ParseUint(network, 10, 32)
+		if err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+			return
+		}
+		q = q.Where("address IN (?)", ctrl.db.Model(&Member{}).Select("node_address").Where("network_id = ?", networkID))
+	}
+	if platform := c.Query("platform"); platform != "" {
+		q = q.Where("platform = ?", platform)
+	}
+	if search := c.Query("q"); search != "" {
+		pattern := likePattern(search)
+		q = q.Where("(LOWER(name) LIKE ? ESCAPE '\\' OR LOWER(address) LIKE ? ESCAPE '\\')", pattern, pattern)
+	}
+	if v := c.Query("online"); v != "" {
+		wantOnline, err := strconv.ParseBool(v)
+		if err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "online must be true or false")
+			return
+		}
+		// Online status lives in memory, so filter by the connected addresses
+		addrs := make([]string, 0, len(presence))
+		for addr := range presence {
+			addrs = append(addrs, addr)
+		}
+		if wantOnline {
+			q = q.Where("address IN ?", addrs)
+		} else if len(addrs) > 0 {
+			q = q.Where("address NOT IN ?", addrs)
+		}
+	}
+
+	var total int64
+	if err := q.Count(&total).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "list peers failed")
+		return
+	}
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
 	}
 
 	var nodes []Node
-	ctrl.db.Find(&nodes)
+	if err := q.Order("address").Limit(limit).Offset(offset).Find(&nodes).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "list peers failed")
+		return
+	}
 
-	result := make([]PeerWithStatus, 0, len(nodes))
+	addrs := make([]string, 0, len(nodes))
 	for _, n := range nodes {
-		result = append(result, PeerWithStatus{
-			Address:  n.Address,
-			Platform: n.Platform,
-			Online:   online[n.Address],
-			LastSeen: n.LastSeen,
+		addrs = append(addrs, n.Address)
+	}
+	var members []Member
+	if err := ctrl.db.Where("node_address IN ?", addrs).Order("network_id").Find(&members).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "list peers failed")
+		return
+	}
+	networks := make(map[string][]protocol.PeerNetwork)
+	for _, m := range members {
+		networks[m.NodeAddress] = append(networks[m.NodeAddress], protocol.PeerNetwork{
+			NetworkID:  m.NetworkID,
+			IPAddress:  m.IPAddress,
+			Authorized: m.Authorized,
+		})
+	}
+
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	result := make([]protocol.Peer, 0, len(nodes))
+	for _, n := range nodes {
+		p, online := presence[n.Address]
+		if !online {
+			p.Status = protocol.PresenceOffline
+		}
+		result = append(result, protocol.Peer{
+			Address:     n.Address,
+			Name:        n.Name,
+			Description: n.Description,
+			Platform:    n.Platform,
+			Online:      online,
+			Status:      p.Status,
+			LatencyMs:   p.LatencyMs,
+			Path:        p.Path,
+			Networks:    networks[n.Address],
+			LastSeen:    n.LastSeen,
+			DeviceError: p.DeviceError,
 		})
 	}
 	c.JSON(http.StatusOK, result)
 }
+
+// --- Event stream ---
+
+// streamEvents streams controller change events as Server-Sent Events until
+// the client disconnects or is dropped for falling behind.
+func (ctrl *Controller) streamEvents(c *gin.Context) {
+	events, unsubscribe := ctrl.events.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case ev, ok := <-events:
+			if !ok {
+				return false // dropped as a slow consumer
+			}
+			c.SSEvent(string(ev.Type), ev)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"time": time.Now()})
+			return true
+		}
+	})
+}
+
+// parsePagination reads the limit and offset query parameters. A missing
+// limit means no limit (-1). On invalid input it writes a 400 and returns false.
+func parsePagination(c *gin.Context) (limit, offset int, ok bool) {
+	limit = -1
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "limit must be a positive integer")
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "offset must be a non-negative integer")
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// likePattern builds a case-insensitive substring LIKE pattern (for use with
+// LOWER(column) and ESCAPE '\'), escaping the wildcards in the search term.
+func likePattern(search string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(search))
+	return "%" + escaped + "%"
+}
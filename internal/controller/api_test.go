@@ -0,0 +1,957 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"gorm.io/gorm"
+)
+
+const testAdminPassword = "Correct-horse-9"
+
+// newTestController returns a controller with an empty database in a
+// temporary directory and the default admin account.
+func newTestController(t *testing.T) *Controller {
+	t.Helper()
+	return newTestControllerWith(t, nil)
+}
+
+// newTestControllerWith is newTestController with the config modified by
+// configure, if not nil.
+func newTestControllerWith(t *testing.T, configure func(*config.ControllerConfig)) *Controller {
+	t.Helper()
+	cfg := &config.ControllerConfig{
+		Database:  "sqlite://" + filepath.Join(t.TempDir(), "zerogo.db"),
+		JWTSecret: "test-secret",
+		Admin:     config.AdminConfig{Username: "admin", Password: testAdminPassword},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	ctrl, err := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctrl
+}
+
+// testToken returns an access token for a user with the given role.
+func testToken(t *testing.T, ctrl *Controller, role string) string {
+	t.Helper()
+	token, _, err := GenerateToken(&User{ID: 1, Username: role + "-user", Role: role}, ctrl.jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// request sends an API request to ctrl, encoding body as JSON if not nil.
+func request(t *testing.T, ctrl *Controller, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ctrl.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateNode(t *testing.T) {
+	ctrl := newTestController(t)
+	admin := testToken(t, ctrl, "admin")
+	node := Node{Address: "0123456789", PublicKey: "00", Name: "old", Description: "rack 4"}
+	if err := ctrl.db.Create(&node).Error; err != nil {
+		t.Fatal(err)
+	}
+	peerName := func() string {
+		t.Helper()
+		w := request(t, ctrl, "GET", "/api/v1/peers", admin, nil)
+		var peers []protocol.Peer
+		if err := json.Unmarshal(w.Body.Bytes(), &peers); err != nil || len(peers) != 1 {
+			t.Fatalf("peers: %s", w.Body)
+		}
+		return peers[0].Name
+	}
+	str := func(s string) *string { return &s }
+
+	// Only admins may rename nodes
+	if w := request(t, ctrl, "PUT", "/api/v1/nodes/0123456789", testToken(t, ctrl, "user"), protocol.UpdateNodeRequest{Name: str("evil")}); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin update: HTTP %d", w.Code)
+	}
+
+	// A rename flows into the peers listing and leaves the description
+	w := request(t, ctrl, "PUT", "/api/v1/nodes/0123456789", admin, protocol.UpdateNodeRequest{Name: str("db-1")})
+	if w.Code != http.StatusOK {
+		t.Fatalf("rename: HTTP %d: %s", w.Code, w.Body)
+	}
+	if name := peerName(); name != "db-1" {
+		t.Fatalf("peer name = %q, want db-1", name)
+	}
+	ctrl.db.First(&node, "address = ?", node.Address)
+	if node.Description != "rack 4" {
+		t.Fatalf("description = %q after a rename", node.Description)
+	}
+
+	// Empty strings clear both fields
+	w = request(t, ctrl, "PUT", "/api/v1/nodes/0123456789", admin, protocol.UpdateNodeRequest{Name: str(""), Description: str("")})
+	if w.Code != http.StatusOK {
+		t.Fatalf("clear: HTTP %d: %s", w.Code, w.Body)
+	}
+	ctrl.db.First(&node, "address = ?", node.Address)
+	if node.Name != "" || node.Description != "" {
+		t.Fatalf("after clearing: name %q, description %q", node.Name, node.Description)
+	}
+
+	if w := request(t, ctrl, "PUT", "/api/v1/nodes/9999999999", admin, protocol.UpdateNodeRequest{Name: str("x")}); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown node: HTTP %d", w.Code)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	ctrl := newTestController(t)
+	user := testToken(t, ctrl, "user")
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/api/v1/networks/1/psk"},
+		{"POST", "/api/v1/networks/1/psk/rotate"},
+		{"GET", "/api/v1/networks/1/export"},
+		{"POST", "/api/v1/networks/import"},
+		{"PUT", "/api/v1/nodes/0123456789"},
+		{"DELETE", "/api/v1/nodes/0123456789"},
+		{"POST", "/api/v1/networks/1/purge"},
+		{"DELETE", "/api/v1/networks/1"},
+		{"POST", "/api/v1/networks/1/restore"},
+	} {
+		if w := request(t, ctrl, route.method, route.path, user, struct{}{}); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s as a user: HTTP %d, want 403", route.method, route.path, w.Code)
+		}
+	}
+}
+
+func TestRegisterDoesNotGrantAdmin(t *testing.T) {
+	ctrl := newTestController(t)
+	var admin User
+	if err := ctrl.db.First(&admin, "username = ?", "admin").Error; err != nil || admin.Role != "admin" {
+		t.Fatalf("configured admin: role %q, %v", admin.Role, err)
+	}
+
+	body := protocol.LoginRequest{Username: "alice", Password: "Another-pass-7"}
+	for _, token := range []string{"", "not-a-token", testToken(t, ctrl, "user")} {
+		if w := request(t, ctrl, "POST", "/api/v1/auth/register", token, body); w.Code != http.StatusForbidden {
+			t.Errorf("register with token %q: HTTP %d, want 403", token, w.Code)
+		}
+	}
+	if w := request(t, ctrl, "POST", "/api/v1/auth/register", testToken(t, ctrl, "admin"), body); w.Code != http.StatusCreated {
+		t.Fatalf("register as admin: HTTP %d: %s", w.Code, w.Body)
+	}
+	var alice User
+	ctrl.db.First(&alice, "username = ?", "alice")
+	if alice.Role != "user" {
+		t.Fatalf("registered user has role %q", alice.Role)
+	}
+}
+
+// loginAs logs in and returns the response, failing the test otherwise.
+func loginAs(t *testing.T, ctrl *Controller, username, password string) protocol.LoginResponse {
+	t.Helper()
+	w := request(t, ctrl, "POST", "/api/v1/auth/login", "", protocol.LoginRequest{Username: username, Password: password})
+	var resp protocol.LoginResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("login as %s: HTTP %d: %s", username, w.Code, w.Body)
+	}
+	return resp
+}
+
+func TestWeakDefaultAdminPassword(t *testing.T) {
+	ctrl := newTestControllerWith(t, func(cfg *config.ControllerConfig) {
+		cfg.Admin = config.AdminConfig{Username: "admin", Password: "admin"}
+	})
+	var admin User
+	ctrl.db.First(&admin, "username = ?", "admin")
+	if admin.Role != "admin" || !admin.MustChangePassword {
+		t.Fatalf("admin/admin created as %+v, want an admin that must change its password", admin)
+	}
+
+	// The account logs in, but admin routes wait for a new password
+	session := loginAs(t, ctrl, "admin", "admin")
+	if !session.PasswordChangeRequired {
+		t.Fatal("login does not ask for a password change")
+	}
+	if w := request(t, ctrl, "GET", "/api/v1/networks", session.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("list networks: HTTP %d", w.Code)
+	}
+	w := request(t, ctrl, "POST", "/api/v1/networks/import", session.Token, struct{}{})
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), protocol.ErrCodePasswordChange) {
+		t.Fatalf("admin route before the change: HTTP %d: %s", w.Code, w.Body)
+	}
+	body := protocol.LoginRequest{Username: "alice", Password: "Another-pass-7"}
+	if w := request(t, ctrl, "POST", "/api/v1/auth/register", session.Token, body); w.Code != http.StatusForbidden {
+		t.Fatalf("register before the change: HTTP %d", w.Code)
+	}
+
+	change := protocol.ChangePasswordRequest{CurrentPassword: "admin", NewPassword: testAdminPassword}
+	if w := request(t, ctrl, "POST", "/api/v1/auth/password", session.Token, change); w.Code != http.StatusOK {
+		t.Fatalf("change password: HTTP %d: %s", w.Code, w.Body)
+	}
+	session = loginAs(t, ctrl, "admin", testAdminPassword)
+	if session.PasswordChangeRequired {
+		t.Fatal("password change still required after the change")
+	}
+	if w := request(t, ctrl, "POST", "/api/v1/auth/register", session.Token, body); w.Code != http.StatusCreated {
+		t.Fatalf("register after the change: HTTP %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminRoleRestored(t *testing.T) {
+	dir := t.TempDir()
+	configure := func(cfg *config.ControllerConfig) {
+		cfg.Database = "sqlite://" + filepath.Join(dir, "zerogo.db")
+	}
+	ctrl := newTestControllerWith(t, configure)
+	// A database left without an admin, where no route can create one
+	ctrl.db.Model(&User{}).Where("username = ?", "admin").Update("role", "user")
+	ctrl.db.Create(&User{Username: "bob", Password: "x", Role: "user"})
+
+	ctrl = newTestControllerWith(t, configure)
+	var admin, bob User
+	ctrl.db.First(&admin, "username = ?", "admin")
+	ctrl.db.First(&bob, "username = ?", "bob")
+	if admin.Role != "admin" || !admin.MustChangePassword {
+		t.Fatalf("configured account = %+v, want an admin that must change its password", admin)
+	}
+	if bob.Role != "user" {
+		t.Fatalf("other account promoted: %+v", bob)
+	}
+
+	// With an admin in place nothing changes on restart
+	ctrl.db.Model(&User{}).Where("username = ?", "admin").Update("role", "user")
+	ctrl.db.Model(&bob).Update("role", "admin")
+	ctrl = newTestControllerWith(t, configure)
+	ctrl.db.First(&admin, "username = ?", "admin")
+	if admin.Role != "user" {
+		t.Fatalf("configured account promoted next to an existing admin: %+v", admin)
+	}
+}
+
+func TestEnvSecretsNotLogged(t *testing.T) {
+	const jwtSecret, password = "env-jwt-secret-1234", "Env-admin-pass-9"
+	t.Setenv("ZEROGO_JWT_SECRET", jwtSecret)
+	t.Setenv("ZEROGO_ADMIN_PASSWORD", password)
+	t.Setenv("ZEROGO_DATABASE", "sqlite://"+filepath.Join(t.TempDir(), "zerogo.db"))
+	cfg := config.DefaultControllerConfig()
+	if err := config.ApplyEnv(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	ctrl, err := New(cfg, slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pw := range []string{password, "wrong-" + password} {
+		request(t, ctrl, "POST", "/api/v1/auth/login", "", protocol.LoginRequest{Username: "admin", Password: pw})
+	}
+	if w := request(t, ctrl, "POST", "/api/v1/auth/login", "", protocol.LoginRequest{Username: "admin", Password: password}); w.Code != http.StatusOK {
+		t.Fatalf("login with the password from the environment: HTTP %d: %s", w.Code, w.Body)
+	}
+	if strings.Contains(logs.String(), jwtSecret) || strings.Contains(logs.String(), password) {
+		t.Fatalf("secret in the logs:\n%s", logs.String())
+	}
+}
+
+// listAddresses GETs a list endpoint and returns the node addresses in it
+// and the X-Total-Count header.
+func listAddresses(t *testing.T, ctrl *Controller, path string) (addrs []string, total string) {
+	t.Helper()
+	w := request(t, ctrl, "GET", path, testToken(t, ctrl, "admin"), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: HTTP %d: %s", path, w.Code, w.Body)
+	}
+	var items []struct {
+		Address     string `json:"address"`
+		NodeAddress string `json:"node_address"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	for _, it := range items {
+		addrs = append(addrs, it.Address+it.NodeAddress)
+	}
+	return addrs, w.Header().Get("X-Total-Count")
+}
+
+func TestListPeersFilters(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	online := newTestIdentity(t)
+	onAddr := online.Address.String()
+	for _, n := range []Node{
+		{Address: "0000000001", PublicKey: "01", Platform: "linux"},
+		{Address: "0000000002", PublicKey: "02", Platform: "darwin"},
+		{Address: "0000000003", PublicKey: "03", Platform: "linux"},
+	} {
+		ctrl.db.Create(&n)
+	}
+	ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: "0000000002"})
+	ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: onAddr})
+	ctrl.db.Create(&Member{NetworkID: 2, NodeAddress: "0000000003"})
+
+	agent := dialAgent(t, srv, online)
+	agent.sendSigned(t, online, agent.join(t, online, online), nil)
+	waitForCond(t, "the agent to register", func() bool {
+		var node Node
+		ctrl.db.Limit(1).Find(&node, "address = ?", onAddr)
+		return node.Address != "" && ctrl.ws.online(onAddr)
+	})
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"0000000001", "0000000002", "0000000003", onAddr}},
+		{"?online=true", []string{onAddr}},
+		{"?online=false", []string{"0000000001", "0000000002", "0000000003"}},
+		{"?network=1", []string{"0000000002", onAddr}},
+		{"?network=1&online=false", []string{"0000000002"}},
+		{"?platform=linux", []string{"0000000001", "0000000003"}},
+		{"?network=3", nil},
+	}
+	for _, tt := range tests {
+		got, total := listAddresses(t, ctrl, "/api/v1/peers"+tt.query)
+		sort.Strings(got)
+		sort.Strings(tt.want)
+		if !reflect.DeepEqual(got, tt.want) || total != strconv.Itoa(len(tt.want)) {
+			t.Errorf("peers%s = %v (total %s), want %v", tt.query, got, total, tt.want)
+		}
+	}
+
+	// Pages of the filtered list, with the total of all matches
+	got, total := listAddresses(t, ctrl, "/api/v1/peers?online=false&limit=2&offset=1")
+	if !reflect.DeepEqual(got, []string{"0000000002", "0000000003"}) || total != "3" {
+		t.Errorf("second page = %v (total %s)", got, total)
+	}
+	if w := request(t, ctrl, "GET", "/api/v1/peers?online=maybe", testToken(t, ctrl, "admin"), nil); w.Code != http.StatusBadRequest {
+		t.Errorf("online=maybe: HTTP %d", w.Code)
+	}
+}
+
+func TestSearchMembersAndPeers(t *testing.T) {
+	ctrl := newTestController(t)
+	ctrl.db.Create(&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", PSK: "00"})
+	for _, n := range []Node{
+		{Address: "aa00000001", PublicKey: "01", Name: "Build-Server"},
+		{Address: "aa00000002", PublicKey: "02"},
+		{Address: "bb00000003", PublicKey: "03", Name: "printer_50%"},
+	} {
+		ctrl.db.Create(&n)
+		ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: n.Address})
+	}
+	ctrl.db.Model(&Member{}).Where("node_address = ?", "aa00000002").Update("name", "Alice's Laptop")
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"laptop", []string{"aa00000002"}},             // member name, any case
+		{"SERVER", []string{"aa00000001"}},             // node name
+		{"aa00", []string{"aa00000001", "aa00000002"}}, // address prefix
+		{"000003", []string{"bb00000003"}},
+		{"50%", []string{"bb00000003"}}, // wildcards match literally
+		{"_", []string{"bb00000003"}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		got, _ := listAddresses(t, ctrl, "/api/v1/networks/1/members?q="+url.QueryEscape(tt.query))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("members?q=%s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	// Search combines with pagination
+	got, total := listAddresses(t, ctrl, "/api/v1/networks/1/members?q=aa&limit=1&offset=1")
+	if !reflect.DeepEqual(got, []string{"aa00000002"}) || total != "2" {
+		t.Errorf("second page = %v (total %s)", got, total)
+	}
+
+	// The peers list matches node names and addresses
+	got, total = listAddresses(t, ctrl, "/api/v1/peers?q=build")
+	if !reflect.DeepEqual(got, []string{"aa00000001"}) || total != "1" {
+		t.Errorf("peers?q=build = %v (total %s)", got, total)
+	}
+	got, _ = listAddresses(t, ctrl, "/api/v1/peers?q=bb")
+	if !reflect.DeepEqual(got, []string{"bb00000003"}) {
+		t.Errorf("peers?q=bb = %v", got)
+	}
+}
+
+func TestDecommissionNode(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	admin := testToken(t, ctrl, "admin")
+	gone, watcher := newTestIdentity(t), newTestIdentity(t)
+	addr := gone.Address.String()
+	for _, id := range []uint32{1, 2} {
+		ctrl.db.Create(&Network{ID: id, Name: "net" + strconv.Itoa(int(id)), IPRange: "10." + strconv.Itoa(int(id)) + ".0.0/24", PSK: "00"})
+		for _, node := range []*identity.Identity{gone, watcher} {
+			ctrl.db.Create(&Member{NetworkID: id, NodeAddress: node.Address.String(), Authorized: true})
+		}
+	}
+	ctrl.db.Create(&Network{ID: 3, Name: "other", IPRange: "10.3.0.0/24", PSK: "00"})
+	ctrl.db.Create(&Member{NetworkID: 3, NodeAddress: watcher.Address.String(), Authorized: true})
+
+	agents := map[*identity.Identity]*testAgent{}
+	for id, networks := range map[*identity.Identity][]string{gone: {"1", "2"}, watcher: {"1", "2", "3"}} {
+		a := dialAgent(t, srv, id)
+		join := a.join(t, id, id)
+		join.Networks = networks
+		a.sendSigned(t, id, join, nil)
+		agents[id] = a
+	}
+	waitForCond(t, "both agents to register", func() bool {
+		var n int64
+		ctrl.db.Model(&Node{}).Count(&n)
+		return n == 2 && ctrl.ws.online(addr) && ctrl.ws.online(watcher.Address.String())
+	})
+
+	// An online node is only removed on purpose
+	if w := request(t, ctrl, "DELETE", "/api/v1/nodes/"+addr, admin, nil); w.Code != http.StatusConflict {
+		t.Fatalf("delete online node: HTTP %d", w.Code)
+	}
+
+	events, unsubscribe := ctrl.events.Subscribe()
+	defer unsubscribe()
+	if w := request(t, ctrl, "DELETE", "/api/v1/nodes/"+addr+"?force=true", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("forced delete: HTTP %d: %s", w.Code, w.Body)
+	}
+	if code := agents[gone].closeCode(t); code != protocol.CloseDecommissioned {
+		t.Errorf("decommissioned agent closed with %d, want %d", code, protocol.CloseDecommissioned)
+	}
+
+	var members, nodes int64
+	ctrl.db.Model(&Member{}).Where("node_address = ?", addr).Count(&members)
+	ctrl.db.Model(&Node{}).Where("address = ?", addr).Count(&nodes)
+	if members != 0 || nodes != 0 {
+		t.Fatalf("%d memberships and %d nodes left", members, nodes)
+	}
+
+	// Its peers in both networks are told to drop it
+	removed := map[string]bool{}
+	for range 2 {
+		var msg protocol.PeerUpdateMessage
+		agents[watcher].next(t, protocol.MsgTypePeerUpdate, &msg)
+		if msg.Action != "remove" || msg.Peer.Address != addr {
+			t.Fatalf("peer update = %+v", msg)
+		}
+		removed[msg.NetworkID] = true
+	}
+	if !removed["1"] || !removed["2"] {
+		t.Errorf("removed from networks %v, want 1 and 2", removed)
+	}
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case ev := <-events:
+			if ev.Type == protocol.EventMemberRemoved || ev.Type == protocol.EventNodeDeleted {
+				got = append(got, fmt.Sprintf("%s %d %s", ev.Type, ev.NetworkID, ev.NodeAddress))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("events = %q", got)
+		}
+	}
+	want := []string{
+		fmt.Sprintf("%s 1 %s", protocol.EventMemberRemoved, addr),
+		fmt.Sprintf("%s 2 %s", protocol.EventMemberRemoved, addr),
+		fmt.Sprintf("%s 0 %s", protocol.EventNodeDeleted, addr),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+
+	if w := request(t, ctrl, "DELETE", "/api/v1/nodes/"+addr, admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("second delete: HTTP %d", w.Code)
+	}
+}
+
+// rotationRequest returns a request moving oldID to newID, signed by signer
+// in place of oldID.
+func rotationRequest(oldID, newID, signer *identity.Identity) protocol.RotateIdentityRequest {
+	req := protocol.RotateIdentityRequest{
+		OldAddress:    oldID.Address.String(),
+		NewAddress:    newID.Address.String(),
+		NewPublicKey:  newID.PublicKeyHex(),
+		NewSigningKey: hex.EncodeToString(newID.SigningPublicKey()),
+	}
+	data := protocol.RotationSignedData(req.OldAddress, req.NewAddress, req.NewPublicKey, req.NewSigningKey)
+	req.OldSignature = hex.EncodeToString(signer.Sign(data))
+	req.NewSignature = hex.EncodeToString(newID.Sign(data))
+	return req
+}
+
+func TestRotateIdentity(t *testing.T) {
+	ctrl := newTestController(t)
+	oldID, newID := newTestIdentity(t), newTestIdentity(t)
+	oldAddr, newAddr := oldID.Address.String(), newID.Address.String()
+	ctrl.db.Create(&Node{Address: oldAddr, PublicKey: oldID.PublicKeyHex(), SigningKey: hex.EncodeToString(oldID.SigningPublicKey()), Name: "laptop"})
+	ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: oldAddr, Authorized: true, IPAddress: "10.1.0.5/24", Name: "alice"})
+	ctrl.db.Create(&Member{NetworkID: 2, NodeAddress: oldAddr})
+	const path = "/api/v1/agent/rotate-identity"
+
+	// Only the holder of the old signing key may move the node
+	forged := rotationRequest(oldID, newID, newTestIdentity(t))
+	if w := request(t, ctrl, "POST", path, "", forged); w.Code != http.StatusUnauthorized {
+		t.Fatalf("rotation signed by a stranger: HTTP %d", w.Code)
+	}
+	mismatched := rotationRequest(oldID, newID, oldID)
+	mismatched.NewSignature = mismatched.OldSignature
+	if w := request(t, ctrl, "POST", path, "", mismatched); w.Code != http.StatusUnauthorized {
+		t.Fatalf("rotation without the new key's signature: HTTP %d", w.Code)
+	}
+
+	w := request(t, ctrl, "POST", path, "", rotationRequest(oldID, newID, oldID))
+	var resp protocol.RotateIdentityResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+		t.Fatalf("rotation: HTTP %d: %s", w.Code, w.Body)
+	}
+	sort.Slice(resp.Networks, func(i, j int) bool { return resp.Networks[i] < resp.Networks[j] })
+	if resp.Address != newAddr || !reflect.DeepEqual(resp.Networks, []uint32{1, 2}) {
+		t.Fatalf("rotation response = %+v", resp)
+	}
+
+	var members []Member
+	ctrl.db.Order("network_id").Find(&members)
+	if len(members) != 2 || members[0].NodeAddress != newAddr || members[1].NodeAddress != newAddr {
+		t.Fatalf("members after rotation = %+v", members)
+	}
+	if m := members[0]; !m.Authorized || m.IPAddress != "10.1.0.5/24" || m.Name != "alice" || members[1].Authorized {
+		t.Fatalf("membership settings not carried over: %+v", members)
+	}
+	var node Node
+	if err := ctrl.db.First(&node, "address = ?", newAddr).Error; err != nil || node.PublicKey != newID.PublicKeyHex() || node.Name != "laptop" {
+		t.Fatalf("new node = %+v, %v", node, err)
+	}
+	var count int64
+	ctrl.db.Model(&Node{}).Where("address = ?", oldAddr).Count(&count)
+	if count != 0 {
+		t.Fatal("old node kept")
+	}
+
+	// The old identity is gone for good
+	if w := request(t, ctrl, "POST", path, "", rotationRequest(oldID, newTestIdentity(t), oldID)); w.Code != http.StatusNotFound {
+		t.Fatalf("second rotation of the old identity: HTTP %d", w.Code)
+	}
+}
+
+func TestSoftDeleteNetwork(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	admin := testToken(t, ctrl, "admin")
+	member, offline := newTestIdentity(t), newTestIdentity(t)
+	ctrl.db.Create(&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", PSK: "00"})
+	ctrl.db.Create(&Network{ID: 2, Name: "lab", IPRange: "10.2.0.0/24", PSK: "00"})
+	for _, node := range []*identity.Identity{member, offline} {
+		ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: node.Address.String(), Authorized: true})
+	}
+	ctrl.db.Create(&Rule{NetworkID: 1, Priority: 10, Action: "drop", Protocol: "tcp", PortRange: "22"})
+
+	a := dialAgent(t, srv, member)
+	join := a.join(t, member, member)
+	join.Networks = []string{"1"}
+	a.sendSigned(t, member, join, nil)
+	var cfg protocol.NetworkConfigMessage
+	a.next(t, protocol.MsgTypeNetworkConfig, &cfg)
+
+	networkNames := func(path string) []string {
+		t.Helper()
+		w := request(t, ctrl, "GET", path, admin, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: HTTP %d", path, w.Code)
+		}
+		var networks []protocol.Network
+		if err := json.Unmarshal(w.Body.Bytes(), &networks); err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, n := range networks {
+			names = append(names, n.Name)
+		}
+		return names
+	}
+
+	if w := request(t, ctrl, "DELETE", "/api/v1/networks/1", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: HTTP %d: %s", w.Code, w.Body)
+	}
+
+	// The connected member tears the network down and drops its peers
+	var deleted protocol.NetworkDeletedMessage
+	a.next(t, protocol.MsgTypeNetworkDeleted, &deleted)
+	if deleted.NetworkID != "1" {
+		t.Fatalf("network_deleted for network %q", deleted.NetworkID)
+	}
+	var update protocol.PeerUpdateMessage
+	for update.Peer.Address != offline.Address.String() {
+		a.next(t, protocol.MsgTypePeerUpdate, &update)
+		if update.Action != "remove" || update.NetworkID != "1" {
+			t.Fatalf("peer update = %+v", update)
+		}
+	}
+
+	// Hidden from the API but kept with its members and rules
+	if got := networkNames("/api/v1/networks"); !reflect.DeepEqual(got, []string{"lab"}) {
+		t.Errorf("networks after delete = %q", got)
+	}
+	if got := networkNames("/api/v1/networks?deleted=true"); !reflect.DeepEqual(got, []string{"office"}) {
+		t.Errorf("deleted networks = %q", got)
+	}
+	if w := request(t, ctrl, "GET", "/api/v1/networks/1", admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("get deleted network: HTTP %d", w.Code)
+	}
+	if w := request(t, ctrl, "DELETE", "/api/v1/networks/1", admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("second delete: HTTP %d", w.Code)
+	}
+	var members, rules int64
+	ctrl.db.Model(&Member{}).Where("network_id = 1").Count(&members)
+	ctrl.db.Model(&Rule{}).Where("network_id = 1").Count(&rules)
+	if members != 2 || rules != 1 {
+		t.Fatalf("%d members and %d rules kept, want 2 and 1", members, rules)
+	}
+
+	// A restore brings it back and sends its config again
+	w := request(t, ctrl, "POST", "/api/v1/networks/1/restore", admin, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: HTTP %d: %s", w.Code, w.Body)
+	}
+	a.next(t, protocol.MsgTypeNetworkConfig, &cfg)
+	if cfg.NetworkID != "1" || cfg.Name != "office" {
+		t.Errorf("config after restore = %+v", cfg)
+	}
+	if got := networkNames("/api/v1/networks"); !reflect.DeepEqual(got, []string{"office", "lab"}) {
+		t.Errorf("networks after restore = %q", got)
+	}
+	if got := networkNames("/api/v1/networks?deleted=true"); got != nil {
+		t.Errorf("deleted networks after restore = %q", got)
+	}
+	if w := request(t, ctrl, "POST", "/api/v1/networks/1/restore", admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("restore of a live network: HTTP %d", w.Code)
+	}
+}
+
+func TestPurgeNetworkLeavesNoOrphans(t *testing.T) {
+	ctrl := newTestController(t)
+	admin := testToken(t, ctrl, "admin")
+	for _, id := range []uint32{1, 2} {
+		ctrl.db.Create(&Network{ID: id, Name: "net" + strconv.Itoa(int(id)), IPRange: "10." + strconv.Itoa(int(id)) + ".0.0/24", PSK: "00"})
+		ctrl.db.Create(&Member{NetworkID: id, NodeAddress: "0000000001", Authorized: true})
+		ctrl.db.Create(&Rule{NetworkID: id, Priority: 10, Action: "drop", Protocol: "tcp", PortRange: "22"})
+		ctrl.db.Create(&Usage{NetworkID: id, NodeAddress: "0000000001", Bucket: time.Now().Truncate(time.Hour), BytesSent: 1})
+	}
+	rows := func(networkID uint32) (n int64) {
+		for _, m := range networkModels() {
+			var c int64
+			ctrl.db.Model(m).Where("network_id = ?", networkID).Count(&c)
+			n += c
+		}
+		return n
+	}
+
+	if w := request(t, ctrl, "POST", "/api/v1/networks/1/purge", admin, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("purge of a live network: HTTP %d", w.Code)
+	}
+	if w := request(t, ctrl, "DELETE", "/api/v1/networks/1", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: HTTP %d", w.Code)
+	}
+	if w := request(t, ctrl, "POST", "/api/v1/networks/1/purge", admin, nil); w.Code != http.StatusConflict {
+		t.Fatalf("purge within the grace period: HTTP %d", w.Code)
+	}
+	if n := rows(1); n != 3 {
+		t.Fatalf("%d rows of the deleted network, want 3 kept for a restore", n)
+	}
+
+	ctrl.db.Unscoped().Model(&Network{ID: 1}).Update("deleted_at", time.Now().Add(-networkPurgeGrace-time.Minute))
+	if w := request(t, ctrl, "POST", "/api/v1/networks/1/purge", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("purge: HTTP %d: %s", w.Code, w.Body)
+	}
+	var networks int64
+	ctrl.db.Unscoped().Model(&Network{}).Where("id = 1").Count(&networks)
+	if n := rows(1); networks != 0 || n != 0 {
+		t.Fatalf("%d networks and %d member, rule and usage rows left", networks, n)
+	}
+	if n := rows(2); n != 3 {
+		t.Errorf("purge took %d rows of another network", 3-n)
+	}
+
+	// A network reusing the ID starts empty
+	ctrl.db.Create(&Network{ID: 1, Name: "reused", IPRange: "10.9.0.0/24", PSK: "00"})
+	w := request(t, ctrl, "GET", "/api/v1/networks/1/members", admin, nil)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "0000000001") {
+		t.Errorf("members of the reused network: HTTP %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestDeletedNetworkGrantsNothing(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	admin := testToken(t, ctrl, "admin")
+	a, b := newTestIdentity(t), newTestIdentity(t)
+	aAddr, bAddr := a.Address.String(), b.Address.String()
+	ctrl.db.Create(&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", PSK: "00"})
+	for _, addr := range []string{aAddr, bAddr} {
+		ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: addr, Authorized: true})
+	}
+	agentA, agentB := dialAgent(t, srv, a), dialAgent(t, srv, b)
+	agentA.sendSigned(t, a, agentA.join(t, a, a), nil)
+	agentB.sendSigned(t, b, agentB.join(t, b, b), nil)
+	waitForCond(t, "both agents to join", func() bool { return ctrl.ws.online(aAddr) && ctrl.ws.online(bAddr) })
+	offer := func(relayAddr string) {
+		t.Helper()
+		msg := protocol.RelayOfferMessage{Type: protocol.MsgTypeRelayOffer, To: bAddr, RelayAddr: relayAddr}
+		if err := agentA.conn.WriteJSON(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if w := request(t, ctrl, "DELETE", "/api/v1/networks/1", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("delete: HTTP %d", w.Code)
+	}
+
+	// The members stay for a restore, but get no config, cannot be
+	// authorized again, and no longer share a network
+	if _, err := ctrl.ws.networkConfig("1", aAddr); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("config of a deleted network: err = %v, want not found", err)
+	}
+	body := protocol.AuthorizeMemberRequest{NodeAddress: bAddr, Authorized: true}
+	if w := request(t, ctrl, "POST", "/api/v1/networks/1/members", admin, body); w.Code != http.StatusNotFound {
+		t.Errorf("authorize in a deleted network: HTTP %d", w.Code)
+	}
+	if err := ctrl.recordUsage(aAddr, []protocol.PeerStatus{{Address: bAddr, BytesSent: 100}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var usage int64
+	ctrl.db.Model(&Usage{}).Count(&usage)
+	if usage != 0 {
+		t.Errorf("traffic attributed to the deleted network")
+	}
+	offer("192.0.2.9:1000")
+	// a's messages are handled in order: once its status is in, so is the offer
+	agentA.sendSigned(t, a, protocol.StatusMessage{Type: protocol.MsgTypeStatus, Endpoints: []string{"192.0.2.1:9993"}}, nil)
+	waitForCond(t, "the offer to be handled", func() bool {
+		ctrl.ws.mu.RLock()
+		defer ctrl.ws.mu.RUnlock()
+		return len(ctrl.ws.agents[aAddr].Endpoints()) > 0
+	})
+
+	// Restoring brings all of it back
+	if w := request(t, ctrl, "POST", "/api/v1/networks/1/restore", admin, nil); w.Code != http.StatusOK {
+		t.Fatalf("restore: HTTP %d", w.Code)
+	}
+	if cfg, err := ctrl.ws.networkConfig("1", aAddr); err != nil || len(cfg.Peers) != 1 {
+		t.Errorf("config after restore: %+v, %v", cfg, err)
+	}
+	offer("192.0.2.9:2000")
+	var got protocol.RelayOfferMessage
+	agentB.next(t, protocol.MsgTypeRelayOffer, &got)
+	if got.RelayAddr != "192.0.2.9:2000" || got.From != aAddr {
+		t.Errorf("b got relay offer %+v, want only the one sent after the restore", got)
+	}
+}
+
+func TestPrivateNetworkCreatesNoPendingMembers(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	admin := testToken(t, ctrl, "admin")
+	private := true
+	ids := make(map[string]string)
+	for _, req := range []protocol.CreateNetworkRequest{
+		{Name: "open", IPRange: "10.1.0.0/24"},
+		{Name: "private", IPRange: "10.2.0.0/24", Private: &private},
+	} {
+		w := request(t, ctrl, "POST", "/api/v1/networks", admin, req)
+		var network protocol.Network
+		if json.Unmarshal(w.Body.Bytes(), &network); w.Code != http.StatusCreated || network.Private != (req.Private != nil) {
+			t.Fatalf("create %s: HTTP %d: %s", req.Name, w.Code, w.Body)
+		}
+		ids[req.Name] = strconv.FormatUint(uint64(network.ID), 10)
+	}
+
+	// Both refuse a stranger, but only the open network records it as pending
+	node := newTestIdentity(t)
+	addr := node.Address.String()
+	a := dialAgent(t, srv, node)
+	join := a.join(t, node, node)
+	join.Networks = []string{ids["open"], ids["private"]}
+	a.sendSigned(t, node, join, nil)
+	for range 2 {
+		var msg protocol.ErrorMessage
+		a.next(t, protocol.MsgTypeError, &msg)
+		if msg.Code != http.StatusForbidden {
+			t.Fatalf("error = %+v, want 403", msg)
+		}
+	}
+	members := func(name string) []Member {
+		var ms []Member
+		ctrl.db.Where("network_id = ?", ids[name]).Find(&ms)
+		return ms
+	}
+	if ms := members("open"); len(ms) != 1 || ms[0].NodeAddress != addr || ms[0].Authorized {
+		t.Fatalf("open network members = %+v, want the node pending", ms)
+	}
+	if ms := members("private"); len(ms) != 0 {
+		t.Fatalf("private network members = %+v, want none", ms)
+	}
+
+	// An admin adds the node, which can then get the config
+	w := request(t, ctrl, "POST", "/api/v1/networks/"+ids["private"]+"/members", admin, protocol.AuthorizeMemberRequest{NodeAddress: addr, Authorized: true})
+	if w.Code >= 300 {
+		t.Fatalf("authorize: HTTP %d: %s", w.Code, w.Body)
+	}
+	join.Networks = []string{ids["private"]}
+	a.sendSigned(t, node, join, nil)
+	var cfg protocol.NetworkConfigMessage
+	a.next(t, protocol.MsgTypeNetworkConfig, &cfg)
+	if cfg.NetworkID != ids["private"] || cfg.AssignedIP == "" {
+		t.Fatalf("config = %+v", cfg)
+	}
+}
+
+func TestDisconnectNode(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	admin := testToken(t, ctrl, "admin")
+
+	connect := func() (*identity.Identity, *testAgent) {
+		id := newTestIdentity(t)
+		a := dialAgent(t, srv, id)
+		a.sendSigned(t, id, a.join(t, id, id), nil)
+		var welcome protocol.WelcomeMessage
+		a.next(t, protocol.MsgTypeWelcome, &welcome)
+		return id, a
+	}
+	kickedID, kicked := connect()
+	otherID, other := connect()
+	kickedAddr, otherAddr := kickedID.Address.String(), otherID.Address.String()
+
+	if w := request(t, ctrl, "POST", "/api/v1/nodes/"+kickedAddr+"/disconnect", testToken(t, ctrl, "user"), nil); w.Code != http.StatusForbidden {
+		t.Fatalf("non-admin disconnect: HTTP %d", w.Code)
+	}
+
+	w := request(t, ctrl, "POST", "/api/v1/nodes/"+kickedAddr+"/disconnect", admin, protocol.DisconnectNodeRequest{Reason: "incident 42"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"disconnected":true`) {
+		t.Fatalf("disconnect: HTTP %d %s", w.Code, w.Body)
+	}
+	kicked.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var closeErr *websocket.CloseError
+	if _, _, err := kicked.conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != protocol.CloseKicked || closeErr.Text != "incident 42" {
+		t.Fatalf("kicked connection ended with %v, want close code %d", err, protocol.CloseKicked)
+	}
+	waitForCond(t, "the kicked agent to go offline", func() bool { return !ctrl.ws.online(kickedAddr) })
+	if !ctrl.ws.online(otherAddr) {
+		t.Fatal("another agent was disconnected too")
+	}
+
+	// An offline node can only be deauthorized
+	if w := request(t, ctrl, "POST", "/api/v1/nodes/"+kickedAddr+"/disconnect", admin, nil); w.Code != http.StatusConflict {
+		t.Fatalf("disconnecting an offline node: HTTP %d", w.Code)
+	}
+	if w := request(t, ctrl, "POST", "/api/v1/nodes/00000000ff/disconnect", admin, nil); w.Code != http.StatusNotFound {
+		t.Fatalf("disconnecting an unknown node: HTTP %d", w.Code)
+	}
+
+	// Deauthorizing revokes every membership
+	for _, row := range []interface{}{
+		&Network{ID: 7, Name: "ops", IPRange: "10.7.0.0/24", PSK: strings.Repeat("ab", 32)},
+		&Member{NetworkID: 7, NodeAddress: otherAddr, Authorized: true, IPAddress: "10.7.0.2/24"},
+	} {
+		if err := ctrl.db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	w = request(t, ctrl, "POST", "/api/v1/nodes/"+otherAddr+"/disconnect", admin, protocol.DisconnectNodeRequest{Deauthorize: true})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deauthorized":[7]`) {
+		t.Fatalf("disconnect and deauthorize: HTTP %d %s", w.Code, w.Body)
+	}
+	if code := other.closeCode(t); code != protocol.CloseKicked {
+		t.Fatalf("close code %d, want %d", code, protocol.CloseKicked)
+	}
+	var member Member
+	ctrl.db.First(&member, "network_id = ? AND node_address = ?", 7, otherAddr)
+	if member.Authorized {
+		t.Fatal("membership still authorized")
+	}
+}
+
+func TestErrorResponseShape(t *testing.T) {
+	ctrl := newTestController(t)
+	admin, user := testToken(t, ctrl, "admin"), testToken(t, ctrl, "user")
+
+	tests := []struct {
+		name         string
+		method, path string
+		token        string
+		body         interface{}
+		status       int
+		code         string
+	}{
+		{"no token", "GET", "/api/v1/networks", "", nil, http.StatusUnauthorized, protocol.ErrCodeUnauthorized},
+		{"bad token", "GET", "/api/v1/networks", "not-a-jwt", nil, http.StatusUnauthorized, protocol.ErrCodeInvalidToken},
+		{"user on an admin route", "GET", "/api/v1/networks/1/psk", user, nil, http.StatusForbidden, protocol.ErrCodeAdminRequired},
+		{"unknown network", "GET", "/api/v1/networks/999", admin, nil, http.StatusNotFound, protocol.ErrCodeNetworkNotFound},
+		{"invalid body", "POST", "/api/v1/networks", admin, []int{1}, http.StatusBadRequest, protocol.ErrCodeInvalidRequest},
+		{"wrong password", "POST", "/api/v1/auth/login", "", protocol.LoginRequest{Username: "admin", Password: "wrong"}, http.StatusUnauthorized, protocol.ErrCodeInvalidCredentials},
+	}
+	for _, tt := range tests {
+		w := request(t, ctrl, tt.method, tt.path, tt.token, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: HTTP %d, want %d", tt.name, w.Code, tt.status)
+		}
+		// Only the APIError fields, with a code and a message
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: body %q: %v", tt.name, w.Body, err)
+			continue
+		}
+		for key := range body {
+			if key != "code" && key != "message" && key != "details" {
+				t.Errorf("%s: unexpected field %q in %s", tt.name, key, w.Body)
+			}
+		}
+		if body["code"] != tt.code || body["message"] == "" || body["message"] == nil {
+			t.Errorf("%s: body %s, want code %q with a message", tt.name, w.Body, tt.code)
+		}
+	}
+}
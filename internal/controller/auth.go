@@ -1,36 +1,59 @@
 package controller
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 const (
-	jwtExpiry = 24 * time.Hour
+	jwtExpiry     = 24 * time.Hour
+	refreshExpiry = 30 * 24 * time.Hour
+
+	minPasswordLength = 10
 )
 
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
 // Claims represents JWT claims.
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// MustChangePassword withholds admin routes until the password is
+	// changed and the user logs in again
+	MustChangePassword bool `json:"must_change_password,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a JWT token for a user.
+// GenerateToken creates a JWT token for a user. Each token carries a unique
+// jti so it can be revoked before it expires.
 func GenerateToken(user *User, secret string) (string, time.Time, error) {
+	var jti [16]byte
+	if _, err := rand.Read(jti[:]); err != nil {
+		return "", time.Time{}, err
+	}
 	expiresAt := time.Now().Add(jwtExpiry)
 	claims := Claims{
 		UserID:   user.ID,
 		Username: user.Username,
 		Role:     user.Role,
+
+		MustChangePassword: user.MustChangePassword,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti[:]),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "zerogo-controller",
@@ -59,41 +82,162 @@ func ValidateToken(tokenStr, secret string) (*Claims, error) {
 	return claims, nil
 }
 
+// refreshToken is an issued refresh token. Only the SHA-256 hash of the
+// token is stored, so a leaked database cannot be used to mint sessions.
+type refreshToken struct {
+	ID        uint   `gorm:"primarykey"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	UserID    uint   `gorm:"index;not null"`
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken creates and stores a refresh token for a user.
+func IssueRefreshToken(db *gorm.DB, user *User) (string, time.Time, error) {
+	var raw [32]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(raw[:])
+	expiresAt := time.Now().Add(refreshExpiry)
+	rt := refreshToken{
+		TokenHash: hashRefreshToken(token),
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&rt).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// RedeemRefreshToken returns the user a refresh token was issued to, if the
+// token exists and is neither expired nor revoked.
+func RedeemRefreshToken(db *gorm.DB, token string) (*User, error) {
+	var rt refreshToken
+	if err := db.Where("token_hash = ?", hashRefreshToken(token)).First(&rt).Error; err != nil {
+		return nil, errInvalidRefreshToken
+	}
+	if rt.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+	var user User
+	if err := db.First(&user, rt.UserID).Error; err != nil {
+		return nil, errInvalidRefreshToken
+	}
+	return &user, nil
+}
+
+// RevokeRefreshToken revokes a refresh token. Revoking an unknown or already
+// revoked token is not an error.
+func RevokeRefreshToken(db *gorm.DB, token string) error {
+	return db.Model(&refreshToken{}).
+		Where("token_hash = ? AND revoked_at IS NULL", hashRefreshToken(token)).
+		Update("revoked_at", time.Now()).Error
+}
+
 // HashPassword creates a bcrypt hash of a password.
 func HashPassword(password string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(hash), err
 }
 
+// ValidatePassword rejects passwords that are too short or made of a single
+// kind of character, such as only lowercase letters or only digits.
+func ValidatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	kinds := 0
+	for _, ok := range []bool{lower, upper, digit, other} {
+		if ok {
+			kinds++
+		}
+	}
+	if kinds < 2 {
+		return errors.New("password must mix at least two of lowercase, uppercase, digits and symbols")
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token issued to a user.
+func RevokeUserRefreshTokens(db *gorm.DB, userID uint) error {
+	return db.Model(&refreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
 // CheckPassword verifies a password against a bcrypt hash.
 func CheckPassword(password, hash string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
-// AuthMiddleware creates a Gin middleware for JWT authentication.
-func AuthMiddleware(secret string) gin.HandlerFunc {
+// AuthMiddleware creates a Gin middleware for JWT authentication. Tokens in
+// the revocation list, if one is given, are rejected.
+func AuthMiddleware(secret string, revoked *RevocationList) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
+			abortError(c, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, "missing authorization header")
 			return
 		}
 
 		tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenStr == authHeader {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization format"})
+			abortError(c, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, "invalid authorization format")
 			return
 		}
 
 		claims, err := ValidateToken(tokenStr, secret)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			abortError(c, http.StatusUnauthorized, protocol.ErrCodeInvalidToken, "invalid token")
+			return
+		}
+		if revoked != nil && claims.ID != "" && revoked.IsRevoked(claims.ID) {
+			abortError(c, http.StatusUnauthorized, protocol.ErrCodeTokenRevoked, "token revoked")
 			return
 		}
 
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
 		c.Set("role", claims.Role)
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects requests from users without the admin role, and from
+// admins that still have to change a weak configured password. It must run
+// after AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != "admin" {
+			abortError(c, http.StatusForbidden, protocol.ErrCodeAdminRequired, "admin role required")
+			return
+		}
+		if claims, _ := c.Get("claims"); claims.(*Claims).MustChangePassword {
+			abortError(c, http.StatusForbidden, protocol.ErrCodePasswordChange, "change the admin password and log in again")
+			return
+		}
 		c.Next()
 	}
 }
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// login logs in as the default admin.
+func login(t *testing.T, ctrl *Controller) protocol.LoginResponse {
+	t.Helper()
+	w := request(t, ctrl, "POST", "/api/v1/auth/login", "", protocol.LoginRequest{Username: "admin", Password: testAdminPassword})
+	var resp protocol.LoginResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || resp.Token == "" {
+		t.Fatalf("login: HTTP %d: %s", w.Code, w.Body)
+	}
+	return resp
+}
+
+func TestRefreshToken(t *testing.T) {
+	ctrl := newTestController(t)
+	session := login(t, ctrl)
+	if session.RefreshToken == "" || !session.RefreshExpiresAt.After(session.ExpiresAt) {
+		t.Fatalf("login response = %+v, want a longer-lived refresh token", session)
+	}
+
+	refresh := func(token string) *http.Response {
+		return request(t, ctrl, "POST", "/api/v1/auth/refresh", "", protocol.RefreshRequest{RefreshToken: token}).Result()
+	}
+	w := request(t, ctrl, "POST", "/api/v1/auth/refresh", "", protocol.RefreshRequest{RefreshToken: session.RefreshToken})
+	var renewed protocol.LoginResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &renewed) != nil {
+		t.Fatalf("refresh: HTTP %d: %s", w.Code, w.Body)
+	}
+	if renewed.Token == "" || renewed.Token == session.Token || renewed.RefreshToken != "" {
+		t.Fatalf("refresh response = %+v, want only a new access token", renewed)
+	}
+	if w := request(t, ctrl, "GET", "/api/v1/networks", renewed.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("renewed token rejected: HTTP %d", w.Code)
+	}
+	// The refresh token can be used again until it is revoked
+	if resp := refresh(session.RefreshToken); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second refresh: HTTP %d", resp.StatusCode)
+	}
+
+	// Only the hash is stored
+	var stored refreshToken
+	ctrl.db.First(&stored)
+	if stored.TokenHash != hashRefreshToken(session.RefreshToken) {
+		t.Fatal("refresh token not stored by its hash")
+	}
+
+	if err := RevokeRefreshToken(ctrl.db, session.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{session.RefreshToken, "not-a-token"} {
+		if resp := refresh(token); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("refresh with %q: HTTP %d, want 401", token, resp.StatusCode)
+		}
+	}
+
+	// Revoking all of a user's refresh tokens ends their other sessions
+	other := login(t, ctrl)
+	if err := RevokeUserRefreshTokens(ctrl.db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if resp := refresh(other.RefreshToken); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh after revoking the user's tokens: HTTP %d", resp.StatusCode)
+	}
+}
+
+func TestLogoutRevokesToken(t *testing.T) {
+	ctrl := newTestController(t)
+	session, other := login(t, ctrl), login(t, ctrl)
+
+	w := request(t, ctrl, "POST", "/api/v1/auth/logout", session.Token, protocol.LogoutRequest{RefreshToken: session.RefreshToken})
+	if w.Code >= 300 {
+		t.Fatalf("logout: HTTP %d: %s", w.Code, w.Body)
+	}
+	w = request(t, ctrl, "GET", "/api/v1/networks", session.Token, nil)
+	var apiErr protocol.APIError
+	if w.Code != http.StatusUnauthorized || json.Unmarshal(w.Body.Bytes(), &apiErr) != nil || apiErr.Code != protocol.ErrCodeTokenRevoked {
+		t.Fatalf("logged-out token: HTTP %d: %s", w.Code, w.Body)
+	}
+	w = request(t, ctrl, "POST", "/api/v1/auth/refresh", "", protocol.RefreshRequest{RefreshToken: session.RefreshToken})
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token of the logged-out session: HTTP %d", w.Code)
+	}
+	// Other sessions of the user are unaffected
+	if w := request(t, ctrl, "GET", "/api/v1/networks", other.Token, nil); w.Code != http.StatusOK {
+		t.Fatalf("other session: HTTP %d", w.Code)
+	}
+
+	// Revocations survive a restart
+	claims, err := ValidateToken(session.Token, ctrl.jwtSecret)
+	if err != nil || claims.ID == "" {
+		t.Fatalf("token claims = %+v, %v, want a jti", claims, err)
+	}
+	reloaded, err := NewRevocationList(ctrl.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsRevoked(claims.ID) {
+		t.Fatal("revocation lost on reload")
+	}
+}
+
+func TestRevocationCleanup(t *testing.T) {
+	ctrl := newTestController(t)
+	l := ctrl.revoked
+	if err := l.Revoke("expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Revoke("live", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	l.cleanup()
+	if l.IsRevoked("expired") || !l.IsRevoked("live") {
+		t.Fatal("cleanup kept an expired revocation or dropped a live one")
+	}
+	var rows []revokedToken
+	ctrl.db.Find(&rows)
+	if len(rows) != 1 || rows[0].JTI != "live" {
+		t.Fatalf("stored revocations = %+v", rows)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	for password, ok := range map[string]bool{
+		"":                    false,
+		"Short-1":             false,
+		"alllowercaseletters": false,
+		"12345678901234":      false,
+		"lowercase-and-dash":  true,
+		"Correct-horse-9":     true,
+		"passphrase 42":       true,
+	} {
+		if err := ValidatePassword(password); (err == nil) != ok {
+			t.Errorf("ValidatePassword(%q) = %v", password, err)
+		}
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	ctrl := newTestController(t)
+	session := login(t, ctrl)
+	change := func(current, next string) *httptest.ResponseRecorder {
+		return request(t, ctrl, "POST", "/api/v1/auth/password", session.Token,
+			protocol.ChangePasswordRequest{CurrentPassword: current, NewPassword: next})
+	}
+
+	if w := change("wrong-Password-1", "Brand-new-pass-2"); w.Code != http.StatusForbidden {
+		t.Errorf("wrong current password: HTTP %d, want 403", w.Code)
+	}
+	for _, weak := range []string{"Short-1", "onlylowercaseletters", testAdminPassword} {
+		if w := change(testAdminPassword, weak); w.Code != http.StatusBadRequest {
+			t.Errorf("new password %q: HTTP %d, want 400", weak, w.Code)
+		}
+	}
+	if w := request(t, ctrl, "POST", "/api/v1/auth/password", "", protocol.ChangePasswordRequest{CurrentPassword: testAdminPassword, NewPassword: "Brand-new-pass-2"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated change: HTTP %d", w.Code)
+	}
+
+	if w := change(testAdminPassword, "Brand-new-pass-2"); w.Code != http.StatusOK {
+		t.Fatalf("change: HTTP %d: %s", w.Code, w.Body)
+	}
+	for password, want := range map[string]int{testAdminPassword: http.StatusUnauthorized, "Brand-new-pass-2": http.StatusOK} {
+		w := request(t, ctrl, "POST", "/api/v1/auth/login", "", protocol.LoginRequest{Username: "admin", Password: password})
+		if w.Code != want {
+			t.Errorf("login with %q: HTTP %d, want %d", password, w.Code, want)
+		}
+	}
+	// Sessions started with the old password cannot be refreshed
+	if w := request(t, ctrl, "POST", "/api/v1/auth/refresh", "", protocol.RefreshRequest{RefreshToken: session.RefreshToken}); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after the change: HTTP %d", w.Code)
+	}
+
+	// Registration applies the same check
+	admin := testToken(t, ctrl, "admin")
+	if w := request(t, ctrl, "POST", "/api/v1/auth/register", admin, protocol.LoginRequest{Username: "bob", Password: "password"}); w.Code != http.StatusBadRequest {
+		t.Errorf("register with a weak password: HTTP %d", w.Code)
+	}
+}
+
+func TestAdminFromPasswordHash(t *testing.T) {
+	hash, err := HashPassword(testAdminPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl := newTestControllerWith(t, func(cfg *config.ControllerConfig) {
+		cfg.Admin = config.AdminConfig{Username: "admin", PasswordHash: hash}
+	})
+	login(t, ctrl)
+
+	var admin User
+	if err := ctrl.db.Where("username = ?", "admin").First(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	if admin.Password != hash || admin.Role != "admin" {
+		t.Fatalf("admin = %+v, want the configured hash stored as is", admin)
+	}
+}
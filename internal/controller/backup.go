@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// backupVersion is bumped when the archive layout changes.
+const backupVersion = 1
+
+// backupArchive is the on-disk backup format (gzip-compressed JSON).
+// Unlike the API representation it keeps password hashes and PSKs.
+type backupArchive struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Users     []backupUser    `json:"users"`
+	Networks  []backupNetwork `json:"networks"`
+	Nodes     []backupNode    `json:"nodes"`
+	Members   []backupMember  `json:"members"`
+	Rules     []backupRule    `json:"rules"`
+}
+
+// The backup* types mirror the GORM models field for field so they convert
+// directly; a model change that is not reflected here fails to compile.
+
+type backupUser struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+}
+
+type backupNetwork struct {
+	ID             uint32    `json:"id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitempty"`
+	IPRange        string    `json:"ip_range"`
+	IP6Range       string    `json:"ip6_range,omitempty"`
+	MTU            int       `json:"mtu"`
+	Multicast      bool      `json:"multicast"`
+	ReservedRanges []string  `json:"reserved_ranges,omitempty"`
+	GatewayIP      string    `json:"gateway_ip,omitempty"`
+	PSK            string    `json:"psk"`
+	CreatedAt      time.Time `json:"created_at"`
+	Members        []Member  `json:"-"`
+	Rules          []Rule    `json:"-"`
+
+	DNSServers    []string `json:"dns_servers,omitempty"`
+	SearchDomains []string `json:"search_domains,omitempty"`
+
+	Private bool `json:"private,omitempty"`
+
+	SourceValidation bool `json:"source_validation,omitempty"`
+
+	MACTableSize   int `json:"mac_table_size,omitempty"`
+	MACTableExpiry int `json:"mac_table_expiry,omitempty"`
+	ARPCacheSize   int `json:"arp_cache_size,omitempty"`
+	ARPCacheExpiry int `json:"arp_cache_expiry,omitempty"`
+
+	DeletedAt gorm.DeletedAt `json:"deleted_at"`
+}
+
+type backupNode struct {
+	Address     string    `json:"address"`
+	PublicKey   string    `json:"public_key"`
+	SigningKey  string    `json:"signing_key,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Platform    string    `json:"platform,omitempty"`
+	Endpoints   []string  `json:"endpoints,omitempty"`
+	EndpointsAt time.Time `json:"endpoints_at"`
+	LastSeen    time.Time `json:"last_seen"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	LongAddress string `json:"long_address,omitempty"`
+
+	Version         string `json:"version,omitempty"`
+	ProtocolVersion int    `json:"protocol_version,omitempty"`
+}
+
+type backupMember struct {
+	NetworkID   uint32    `json:"network_id"`
+	NodeAddress string    `json:"node_address"`
+	Authorized  bool      `json:"authorized"`
+	IPAddress   string    `json:"ip_address,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Gateway     bool      `json:"gateway,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Node        Node      `json:"-"`
+
+	PSK string `json:"psk,omitempty"`
+}
+
+type backupRule struct {
+	ID          uint      `json:"id"`
+	NetworkID   uint32    `json:"network_id"`
+	Priority    int       `json:"priority"`
+	Action      string    `json:"action"`
+	Src         string    `json:"src,omitempty"`
+	Dst         string    `json:"dst,omitempty"`
+	Protocol    string    `json:"protocol,omitempty"`
+	PortRange   string    `json:"port_range,omitempty"`
+	Days        string    `json:"days,omitempty"`
+	StartTime   string    `json:"start_time,omitempty"`
+	EndTime     string    `json:"end_time,omitempty"`
+	Timezone    string    `json:"timezone,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BackupDatabase writes a consistent snapshot of the database at dsn to path.
+func BackupDatabase(dsn, path string) error {
+	db, err := InitDB(dsn)
+	if err != nil {
+		return err
+	}
+
+	var (
+		users    []User
+		networks []Network
+		nodes    []Node
+		members  []Member
+		rules    []Rule
+	)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Unscoped keeps deleted networks, which can still be restored
+		for _, dst := range []interface{}{&users, &networks, &nodes, &members, &rules} {
+			if err := tx.Unscoped().Find(dst).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("read database: %w", err)
+	}
+
+	archive := backupArchive{
+		Version:   backupVersion,
+		CreatedAt: time.Now(),
+		Users:     make([]backupUser, len(users)),
+		Networks:  make([]backupNetwork, len(networks)),
+		Nodes:     make([]backupNode, len(nodes)),
+		Members:   make([]backupMember, len(members)),
+		Rules:     make([]backupRule, len(rules)),
+	}
+	for i := range users {
+		archive.Users[i] = backupUser(users[i])
+	}
+	for i := range networks {
+		archive.Networks[i] = backupNetwork(networks[i])
+	}
+	for i := range nodes {
+		archive.Nodes[i] = backupNode(nodes[i])
+	}
+	for i := range members {
+		archive.Members[i] = backupMember(members[i])
+	}
+	for i := range rules {
+		archive.Rules[i] = backupRule(rules[i])
+	}
+
+	// Write to a temp file and rename so a failed backup never truncates an old one
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("create backup: %w", err)
+	}
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	return nil
+}
+
+// RestoreDatabase loads a backup from path into the database at dsn. It refuses
+// to overwrite a non-empty database unless force is set, in which case all
+// existing rows are replaced.
+func RestoreDatabase(dsn, path string, force bool) error {
+	archive, err := readBackup(path)
+	if err != nil {
+		return err
+	}
+
+	db, err := InitDB(dsn)
+	if err != nil {
+		return err
+	}
+
+	models := []interface{}{&Rule{}, &Member{}, &Node{}, &Network{}, &User{}}
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Unscoped so deleted networks are counted and really removed; a new
+		// session so the statements below do not share one
+		tx = tx.Unscoped().Session(&gorm.Session{})
+		for _, m := range models {
+			var count int64
+			if err := tx.Model(m).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				continue
+			}
+			if !force {
+				return fmt.Errorf("database is not empty (use -force to replace its contents)")
+			}
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(m).Error; err != nil {
+				return fmt.Errorf("clear database: %w", err)
+			}
+		}
+
+		create := func(rows interface{}) error {
+			return tx.Omit(clause.Associations).CreateInBatches(rows, 100).Error
+		}
+
+		users := make([]User, len(archive.Users))
+		for i := range archive.Users {
+			users[i] = User(archive.Users[i])
+		}
+		networks := make([]Network, len(archive.Networks))
+		for i := range archive.Networks {
+			networks[i] = Network(archive.Networks[i])
+		}
+		nodes := make([]Node, len(archive.Nodes))
+		for i := range archive.Nodes {
+			nodes[i] = Node(archive.Nodes[i])
+		}
+		members := make([]Member, len(archive.Members))
+		for i := range archive.Members {
+			members[i] = Member(archive.Members[i])
+		}
+		rules := make([]Rule, len(archive.Rules))
+		for i := range archive.Rules {
+			rules[i] = Rule(archive.Rules[i])
+		}
+
+		if len(users) > 0 {
+			if err := create(users); err != nil {
+				return fmt.Errorf("restore users: %w", err)
+			}
+		}
+		if len(networks) > 0 {
+			if err := create(networks); err != nil {
+				return fmt.Errorf("restore networks: %w", err)
+			}
+		}
+		if len(nodes) > 0 {
+			if err := create(nodes); err != nil {
+				return fmt.Errorf("restore nodes: %w", err)
+			}
+		}
+		if len(members) > 0 {
+			if err := create(members); err != nil {
+				return fmt.Errorf("restore members: %w", err)
+			}
+		}
+		if len(rules) > 0 {
+			if err := create(rules); err != nil {
+				return fmt.Errorf("restore rules: %w", err)
+			}
+		}
+
+		// GORM substitutes column defaults for zero values on create (and writes
+		// them back into the rows); put back the ones whose zero value matters.
+		for _, n := range archive.Networks {
+			if !n.Multicast {
+				if err := tx.Model(&Network{}).Where("id = ?", n.ID).Update("multicast", false).Error; err != nil {
+					return fmt.Errorf("restore networks: %w", err)
+				}
+			}
+		}
+		for _, r := range archive.Rules {
+			if r.Priority == 0 {
+				if err := tx.Model(&Rule{}).Where("id = ?", r.ID).Update("priority", 0).Error; err != nil {
+					return fmt.Errorf("restore rules: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// readBackup decodes a backup archive, accepting gzip or plain JSON.
+func readBackup(path string) (*backupArchive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open backup: %w", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("open backup: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var archive backupArchive
+	if err := json.NewDecoder(r).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("decode backup: %w", err)
+	}
+	if archive.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", archive.Version)
+	}
+	return &archive, nil
+}
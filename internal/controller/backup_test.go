@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// dbContents is every row a backup covers, deleted networks included.
+type dbContents struct {
+	Users    []User
+	Networks []Network
+	Nodes    []Node
+	Members  []Member
+	Rules    []Rule
+}
+
+func readContents(t *testing.T, db *gorm.DB) dbContents {
+	t.Helper()
+	var c dbContents
+	for _, dst := range []interface{}{&c.Users, &c.Networks, &c.Nodes, &c.Members, &c.Rules} {
+		if err := db.Unscoped().Find(dst).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+// testDB opens a fresh database and returns it with its DSN.
+func testDB(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "zerogo.db")
+	db, err := InitDB(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, dsn
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	src, srcDSN := testDB(t)
+	rows := []interface{}{
+		&User{Username: "admin", Password: "$2a$10$hash", Role: "admin"},
+		&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", MTU: 1400, PSK: strings.Repeat("ab", 32), ReservedRanges: []string{"10.1.0.200-10.1.0.250"}},
+		&Network{ID: 2, Name: "quiet", IPRange: "10.2.0.0/24", PSK: strings.Repeat("cd", 32)},
+		&Node{Address: "0000000001", PublicKey: strings.Repeat("01", 32), SigningKey: strings.Repeat("02", 32), Endpoints: []string{"192.0.2.1:9993"}},
+		&Member{NetworkID: 1, NodeAddress: "0000000001", Authorized: true, IPAddress: "10.1.0.2/24", Name: "laptop", PSK: strings.Repeat("ef", 32)},
+		&Rule{NetworkID: 1, Priority: 0, Action: "drop", Src: "10.1.0.0/24", Days: "mon,fri", StartTime: "09:00", EndTime: "17:00"},
+		&Rule{NetworkID: 1, Priority: 20, Action: "allow", Protocol: "tcp", PortRange: "22"},
+	}
+	for _, row := range rows {
+		if err := src.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Zero values that differ from the column defaults, and a deleted network
+	src.Model(&Network{ID: 2}).Update("multicast", false)
+	src.Model(&Rule{}).Where("priority = ?", 100).Update("priority", 0)
+	src.Delete(&Network{ID: 2})
+	want := readContents(t, src)
+
+	path := filepath.Join(t.TempDir(), "backup.json.gz")
+	if err := BackupDatabase(srcDSN, path); err != nil {
+		t.Fatal(err)
+	}
+	dst, dstDSN := testDB(t)
+	if err := RestoreDatabase(dstDSN, path, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readContents(t, dst); !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored:\n%+v\nwant:\n%+v", got, want)
+	}
+}
+
+func TestRestoreRefusesNonEmptyDatabase(t *testing.T) {
+	src, srcDSN := testDB(t)
+	src.Create(&Network{ID: 1, Name: "backed-up", IPRange: "10.1.0.0/24", PSK: "00"})
+	path := filepath.Join(t.TempDir(), "backup.json.gz")
+	if err := BackupDatabase(srcDSN, path); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, dstDSN := testDB(t)
+	dst.Create(&Network{ID: 9, Name: "live", IPRange: "10.9.0.0/24", PSK: "00"})
+	if err := RestoreDatabase(dstDSN, path, false); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Fatalf("restore over a live database: err = %v", err)
+	}
+	if c := readContents(t, dst); len(c.Networks) != 1 || c.Networks[0].Name != "live" {
+		t.Fatalf("refused restore changed the database: %+v", c.Networks)
+	}
+
+	if err := RestoreDatabase(dstDSN, path, true); err != nil {
+		t.Fatal(err)
+	}
+	if c := readContents(t, dst); len(c.Networks) != 1 || c.Networks[0].Name != "backed-up" {
+		t.Fatalf("forced restore left %+v", c.Networks)
+	}
+}
@@ -1,14 +1,19 @@
 package controller
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/sdnotify"
 	"gorm.io/gorm"
 )
 
@@ -17,9 +22,18 @@ type Controller struct {
 	db        *gorm.DB
 	router    *gin.Engine
 	ws        *WSHandler
+	events    *EventBus
 	jwtSecret string
+	revoked   *RevocationList
 	config    *config.ControllerConfig
 	log       *slog.Logger
+	server    *http.Server
+
+	// tlsConfig serves HTTPS when set; see serverTLSConfig
+	tlsConfig *tls.Config
+
+	// ipLocks holds a *sync.Mutex per network ID serializing IP allocation
+	ipLocks sync.Map
 }
 
 // New creates a new Controller instance.
@@ -30,25 +44,38 @@ func New(cfg *config.ControllerConfig, log *slog.Logger) (*Controller, error) {
 		return nil, fmt.Errorf("init database: %w", err)
 	}
 
+	tlsConfig, err := serverTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
 	ctrl := &Controller{
 		db:        db,
 		jwtSecret: cfg.JWTSecret,
 		config:    cfg,
 		log:       log,
+		tlsConfig: tlsConfig,
 	}
 
 	// Create default admin user if none exists
-	if err := ctrl.ensureAdminUser(cfg.Admin.Username, cfg.Admin.Password); err != nil {
+	if err := ctrl.ensureAdminUser(cfg.Admin); err != nil {
 		return nil, fmt.Errorf("create admin user: %w", err)
 	}
 
+	ctrl.revoked, err = NewRevocationList(db)
+	if err != nil {
+		return nil, fmt.Errorf("load token revocations: %w", err)
+	}
+
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
+	router.Use(requestLogger(log))
 	router.Use(gin.Recovery())
-	router.Use(corsMiddleware())
+	router.Use(corsMiddleware(cfg.AllowedOrigins))
 
 	ctrl.router = router
+	ctrl.events = NewEventBus(log)
 	ctrl.ws = NewWSHandler(ctrl, log)
 	ctrl.SetupRoutes(router)
 
@@ -60,62 +87,135 @@ func New(cfg *config.ControllerConfig, log *slog.Logger) (*Controller, error) {
 
 // Run starts the controller HTTP server.
 func (ctrl *Controller) Run() error {
-	ctrl.log.Info("controller starting", "listen", ctrl.config.Listen)
-	return ctrl.router.Run(ctrl.config.Listen)
+	ctrl.log.Info("controller starting",
+		"listen", ctrl.config.Listen,
+		"tls", ctrl.tlsConfig != nil,
+		"require_agent_cert", ctrl.config.TLS.RequireAgentCert,
+	)
+	ln, err := net.Listen("tcp", ctrl.config.Listen)
+	if err != nil {
+		return err
+	}
+	if ctrl.tlsConfig != nil {
+		ln = tls.NewListener(ln, ctrl.tlsConfig)
+	}
+
+	if _, err := sdnotify.Notify(sdnotify.Ready); err != nil {
+		ctrl.log.Warn("systemd notify failed", "err", err)
+	}
+	if interval := sdnotify.WatchdogInterval(); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				sdnotify.Notify(sdnotify.Watchdog)
+			}
+		}()
+	}
+
+	ctrl.server = &http.Server{Handler: ctrl.router}
+	if err := ctrl.server.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown disconnects all agents with a close frame and stops the HTTP
+// server, waiting for in-flight requests until ctx is done.
+func (ctrl *Controller) Shutdown(ctx context.Context) error {
+	sdnotify.Notify(sdnotify.Stopping)
+	// Hijacked WebSocket connections are not tracked by http.Server
+	ctrl.ws.CloseAll()
+	if ctrl.server == nil {
+		return nil
+	}
+	return ctrl.server.Shutdown(ctx)
 }
 
-func (ctrl *Controller) ensureAdminUser(username, password string) error {
+// ensureAdminUser creates the configured account as an admin in an empty
+// database. A configured password that fails ValidatePassword, such as the
+// built-in admin/admin default, has to be changed before the account can use
+// admin routes; a password hash from `zerogo-controller init` is trusted.
+func (ctrl *Controller) ensureAdminUser(admin config.AdminConfig) error {
 	var count int64
 	ctrl.db.Model(&User{}).Count(&count)
 	if count > 0 {
-		return nil
+		return ctrl.restoreAdminRole(admin.Username)
 	}
 
-	hash, err := HashPassword(password)
-	if err != nil {
-		return err
-	}
 	user := User{
-		Username: username,
-		Password: hash,
+		Username: admin.Username,
+		Password: admin.PasswordHash,
 		Role:     "admin",
 	}
+	if user.Password == "" {
+		if err := ValidatePassword(admin.Password); err != nil {
+			ctrl.log.Warn("configured admin password is weak, it must be changed before admin routes can be used", "username", admin.Username, "err", err)
+			user.MustChangePassword = true
+		}
+		var err error
+		if user.Password, err = HashPassword(admin.Password); err != nil {
+			return err
+		}
+	}
 	return ctrl.db.Create(&user).Error
 }
 
-func corsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Node-Address, X-Public-Key")
+// restoreAdminRole makes the configured account an admin again in a database
+// without any, where no route could create one. It must change its password
+// before using admin routes.
+func (ctrl *Controller) restoreAdminRole(username string) error {
+	var admins int64
+	ctrl.db.Model(&User{}).Where("role = ?", "admin").Count(&admins)
+	if admins > 0 {
+		return nil
+	}
+	result := ctrl.db.Model(&User{}).Where("username = ?", username).
+		Updates(map[string]interface{}{"role": "admin", "must_change_password": true})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		ctrl.log.Warn("no admin account found, gave the configured account the admin role", "username", username)
+	}
+	return nil
+}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
+func corsMiddleware(allowedOrigins []string) gin.HandlerFunc {
+	wildcard := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			wildcard = true
+			continue
 		}
-		c.Next()
+		allowed[strings.TrimSuffix(o, "/")] = true
 	}
-}
 
-// setupStaticFiles configures static file serving for the web UI.
-func (ctrl *Controller) setupStaticFiles(router *gin.Engine) {
-	// Serve index.html for root and all non-API routes
-	router.NoRoute(func(c *gin.Context) {
-		// Don't handle API routes
-		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
-			c.JSON(http.StatusNotFound, gin.H{"error": "API endpoint not found"})
-			return
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		if len(allowed) > 0 {
+			h.Add("Vary", "Origin")
 		}
 
-		// In production, serve from embedded filesystem or configured path
-		// For development, we expect the web UI to be served separately
-		// You can configure a static path via environment variable
-		staticPath := "/usr/local/share/zerogo/web"
-		if envPath := os.Getenv("ZEROGO_WEB_PATH"); envPath != "" {
-			staticPath = envPath
+		origin := c.GetHeader("Origin")
+		switch {
+		case origin == "":
+		case allowed[origin]:
+			// A specific origin may send credentials; the wildcard may not
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		case wildcard:
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+		if h.Get("Access-Control-Allow-Origin") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Node-Address, X-Public-Key, X-Request-ID")
+			h.Set("Access-Control-Expose-Headers", "X-Total-Count, X-Request-ID")
 		}
 
-		// Try to serve index.html from static path
-		c.File(staticPath + "/index.html")
-	})
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
 }
@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsResponse sends a request with origin through corsMiddleware.
+func corsResponse(allowed []string, method, origin string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Use(corsMiddleware(allowed))
+	r.GET("/api/v1/networks", func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(method, "/api/v1/networks", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORS(t *testing.T) {
+	allowed := []string{"https://admin.example.com/"}
+	tests := []struct {
+		name        string
+		allowed     []string
+		origin      string
+		wantOrigin  string
+		credentials bool
+	}{
+		{"allowed origin echoed", allowed, "https://admin.example.com", "https://admin.example.com", true},
+		{"other origin refused", allowed, "https://evil.example", "", false},
+		{"same-origin request", allowed, "", "", false},
+		{"no origins configured", nil, "https://admin.example.com", "", false},
+		{"wildcard", []string{"*"}, "https://any.example", "*", false},
+		{"listed origin beats the wildcard", []string{"*", "https://admin.example.com"}, "https://admin.example.com", "https://admin.example.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := corsResponse(tt.allowed, "GET", tt.origin)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+				t.Errorf("Allow-Credentials = %v, want %v", got, tt.credentials)
+			}
+			if tt.wantOrigin == "" && w.Header().Get("Access-Control-Allow-Methods") != "" {
+				t.Error("CORS headers sent to a refused origin")
+			}
+		})
+	}
+
+	// Preflights end in the middleware
+	w := corsResponse(allowed, "OPTIONS", "https://admin.example.com")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Fatalf("preflight: HTTP %d, headers %v", w.Code, w.Header())
+	}
+}
@@ -17,34 +17,73 @@ type User struct {
 	ID        uint      `gorm:"primarykey" json:"id"`
 	Username  string    `gorm:"uniqueIndex;not null" json:"username"`
 	Password  string    `gorm:"not null" json:"-"` // bcrypt hash
-	Role      string    `gorm:"default:admin" json:"role"`
+	Role      string    `gorm:"default:user" json:"role"`
 	CreatedAt time.Time `json:"created_at"`
+
+	// MustChangePassword is set for an admin created with a weak configured
+	// password; see ensureAdminUser
+	MustChangePassword bool `json:"must_change_password"`
 }
 
 // Network represents a virtual network.
 type Network struct {
-	ID          uint32    `gorm:"primarykey" json:"id"`
-	Name        string    `gorm:"not null" json:"name"`
-	Description string    `json:"description,omitempty"`
-	IPRange     string    `gorm:"not null" json:"ip_range"`
-	IP6Range    string    `json:"ip6_range,omitempty"`
-	MTU         int       `gorm:"default:2800" json:"mtu"`
-	Multicast   bool      `gorm:"default:true" json:"multicast"`
-	PSK         string    `gorm:"not null" json:"-"` // Per-network PSK (hex), not exposed in JSON
-	CreatedAt   time.Time `json:"created_at"`
-	Members     []Member  `gorm:"foreignKey:NetworkID" json:"members,omitempty"`
-	Rules       []Rule    `gorm:"foreignKey:NetworkID" json:"rules,omitempty"`
+	ID          uint32 `gorm:"primarykey" json:"id"`
+	Name        string `gorm:"not null" json:"name"`
+	Description string `json:"description,omitempty"`
+	IPRange     string `gorm:"not null" json:"ip_range"`
+	IP6Range    string `json:"ip6_range,omitempty"`
+	MTU         int    `gorm:"default:2800" json:"mtu"`
+	Multicast   bool   `gorm:"default:true" json:"multicast"`
+	// ReservedRanges are CIDRs or "first-last" ranges auto-allocation skips
+	ReservedRanges []string  `gorm:"serializer:json" json:"reserved_ranges,omitempty"`
+	GatewayIP      string    `json:"gateway_ip,omitempty"` // never auto-allocated; advertised to members
+	PSK            string    `gorm:"not null" json:"-"`    // Per-network PSK (hex), not exposed in JSON
+	CreatedAt      time.Time `json:"created_at"`
+	Members        []Member  `gorm:"foreignKey:NetworkID" json:"members,omitempty"`
+	Rules          []Rule    `gorm:"foreignKey:NetworkID" json:"rules,omitempty"`
+
+	// DNSServers are pushed to members, which resolve SearchDomains via them
+	DNSServers    []string `gorm:"serializer:json" json:"dns_servers,omitempty"`
+	SearchDomains []string `gorm:"serializer:json" json:"search_domains,omitempty"`
+
+	// Private networks only admit nodes an admin added; requests from
+	// unknown nodes are refused instead of creating pending members
+	Private bool `json:"private,omitempty"`
+
+	// SourceValidation has members drop frames a peer sends from a MAC or
+	// IP assigned to someone else
+	SourceValidation bool `json:"source_validation,omitempty"`
+
+	// MAC table and ARP/NDP cache limits pushed to members; zero keeps the
+	// agent default. Expiries are in seconds.
+	MACTableSize   int `json:"mac_table_size,omitempty"`
+	MACTableExpiry int `json:"mac_table_expiry,omitempty"`
+	ARPCacheSize   int `gorm:"column:arp_cache_size" json:"arp_cache_size,omitempty"`
+	ARPCacheExpiry int `gorm:"column:arp_cache_expiry" json:"arp_cache_expiry,omitempty"`
+
+	// DeletedAt marks a deleted network, hidden until restored or purged
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // Node represents a registered device.
 type Node struct {
 	Address     string    `gorm:"primarykey" json:"address"`
 	PublicKey   string    `gorm:"not null" json:"public_key"`
+	SigningKey  string    `json:"signing_key,omitempty"` // Ed25519 (hex), registered on first signed join
 	Name        string    `json:"name,omitempty"`
 	Description string    `json:"description,omitempty"`
 	Platform    string    `json:"platform,omitempty"`
+	Endpoints   []string  `gorm:"serializer:json" json:"endpoints,omitempty"` // last reported by the agent
+	EndpointsAt time.Time `json:"endpoints_at,omitempty"`
 	LastSeen    time.Time `json:"last_seen,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
+
+	// LongAddress is the 80-bit address, recorded with long_addresses
+	LongAddress string `json:"long_address,omitempty"`
+
+	// Version and ProtocolVersion are what the agent reported at its last join
+	Version         string `json:"version,omitempty"`
+	ProtocolVersion int    `json:"protocol_version,omitempty"`
 }
 
 // Member represents network membership.
@@ -54,8 +93,12 @@ type Member struct {
 	Authorized  bool      `gorm:"default:false" json:"authorized"`
 	IPAddress   string    `json:"ip_address,omitempty"`
 	Name        string    `json:"name,omitempty"`
+	Gateway     bool      `json:"gateway"` // default gateway for full-tunnel members
 	CreatedAt   time.Time `json:"created_at"`
 	Node        Node      `gorm:"foreignKey:NodeAddress;references:Address" json:"node,omitempty"`
+
+	// PSK overrides the network PSK for the member's peer sessions; see pairPSK
+	PSK string `json:"-"`
 }
 
 // Rule represents an ACL rule.
@@ -68,10 +111,32 @@ type Rule struct {
 	Dst         string    `json:"dst,omitempty"`
 	Protocol    string    `json:"protocol,omitempty"`
 	PortRange   string    `json:"port_range,omitempty"`
+	Days        string    `json:"days,omitempty"`       // time window: e.g. "mon,tue,wed"
+	StartTime   string    `json:"start_time,omitempty"` // time window: "HH:MM"
+	EndTime     string    `json:"end_time,omitempty"`   // time window: "HH:MM"
+	Timezone    string    `json:"timezone,omitempty"`   // time window: IANA name, default UTC
 	Description string    `json:"description,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// models lists the tables the controller migrates.
+func models() []interface{} {
+	return []interface{}{&User{}, &Network{}, &Node{}, &Member{}, &Rule{}, &Usage{}, &usageCounter{}, &refreshToken{}, &revokedToken{}}
+}
+
+// networkModels lists the tables whose rows belong to one network and are
+// removed with it.
+func networkModels() []interface{} {
+	return []interface{}{&Member{}, &Rule{}, &Usage{}}
+}
+
+// inLiveNetwork limits a Member query to networks that are not deleted.
+// Deleting a network keeps its members and rules so that restoreNetwork can
+// bring it back; until purgeNetwork removes them they must grant nothing.
+func inLiveNetwork(db *gorm.DB) *gorm.DB {
+	return db.Where("network_id IN (?)", db.Session(&gorm.Session{NewDB: true}).Model(&Network{}).Select("id"))
+}
+
 // InitDB initializes the database connection and runs migrations.
 func InitDB(dsn string) (*gorm.DB, error) {
 	var db *gorm.DB
@@ -91,9 +156,27 @@ func InitDB(dsn string) (*gorm.DB, error) {
 	}
 
 	// Run migrations
-	if err := db.AutoMigrate(&User{}, &Network{}, &Node{}, &Member{}, &Rule{}); err != nil {
+	if err := db.AutoMigrate(models()...); err != nil {
 		return nil, fmt.Errorf("migrate database: %w", err)
 	}
+	if err := deleteOrphans(db); err != nil {
+		return nil, fmt.Errorf("delete orphaned rows: %w", err)
+	}
 
 	return db, nil
 }
+
+// deleteOrphans removes members, rules and usage of networks that no longer
+// exist, left behind by versions that deleted networks without them, so they
+// cannot resurface if a network ID is reused.
+func deleteOrphans(db *gorm.DB) error {
+	networks := db.Unscoped().Model(&Network{}).Select("id")
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range networkModels() {
+			if err := tx.Where("network_id NOT IN (?)", networks).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,26 @@
+package controller
+
+import "testing"
+
+func TestInitDBDeletesOrphans(t *testing.T) {
+	db, dsn := testDB(t)
+	// Rows of a network deleted by an older version, and of one that is
+	// only soft-deleted and may still be restored
+	db.Create(&Network{ID: 2, Name: "deleted", IPRange: "10.2.0.0/24", PSK: "00"})
+	db.Delete(&Network{ID: 2})
+	for _, id := range []uint32{1, 2} {
+		db.Create(&Member{NetworkID: id, NodeAddress: "0000000001"})
+		db.Create(&Rule{NetworkID: id, Priority: 10, Action: "drop"})
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	db, err := InitDB(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := readContents(t, db)
+	if len(c.Members) != 1 || c.Members[0].NetworkID != 2 || len(c.Rules) != 1 || c.Rules[0].NetworkID != 2 {
+		t.Fatalf("members %+v, rules %+v, want only those of network 2", c.Members, c.Rules)
+	}
+}
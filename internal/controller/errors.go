@@ -0,0 +1,16 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// abortError ends the request with a protocol.APIError body.
+func abortError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, protocol.APIError{Code: code, Message: message})
+}
+
+// abortErrorDetails is abortError with machine-readable details.
+func abortErrorDetails(c *gin.Context, status int, code, message string, details interface{}) {
+	c.AbortWithStatusJSON(status, protocol.APIError{Code: code, Message: message, Details: details})
+}
@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// eventBufferSize is how many undelivered events a subscriber may queue
+// before it is considered too slow and dropped.
+const eventBufferSize = 64
+
+// EventBus fans out controller change events to subscribers (SSE clients).
+type EventBus struct {
+	subs map[chan protocol.Event]struct{}
+	mu   sync.Mutex
+	log  *slog.Logger
+}
+
+// NewEventBus creates a new event bus.
+func NewEventBus(log *slog.Logger) *EventBus {
+	return &EventBus{
+		subs: make(map[chan protocol.Event]struct{}),
+		log:  log.With("component", "events"),
+	}
+}
+
+// Publish delivers an event to all subscribers without blocking.
+// Subscribers whose buffer is full are dropped and their channel closed.
+func (b *EventBus) Publish(ev protocol.Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+			b.log.Warn("dropping slow event subscriber")
+		}
+	}
+}
+
+// Subscribe registers a new subscriber. The returned function unsubscribes
+// and is safe to call after the bus has already dropped the subscriber.
+func (b *EventBus) Subscribe() (<-chan protocol.Event, func()) {
+	ch := make(chan protocol.Event, eventBufferSize)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
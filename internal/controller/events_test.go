@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func (b *EventBus) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func TestAuthorizeMemberStreamsEvent(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	admin := testToken(t, ctrl, "admin")
+	if err := ctrl.db.Create(&Network{ID: 3, Name: "net", IPRange: "10.3.0.0/24", PSK: "00"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer "+admin)
+	lines := make(chan string, 16)
+	go func() {
+		defer close(lines)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for ctrl.events.subscribers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("event stream did not subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	w := request(t, ctrl, "POST", "/api/v1/networks/3/members", admin, protocol.AuthorizeMemberRequest{NodeAddress: "0000000001", Authorized: true})
+	if w.Code >= 300 {
+		t.Fatalf("authorize: HTTP %d: %s", w.Code, w.Body)
+	}
+
+	timeout := time.After(2 * time.Second)
+	event := ""
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("event stream ended")
+			}
+			if name, found := strings.CutPrefix(line, "event:"); found {
+				event = name
+				continue
+			}
+			data, found := strings.CutPrefix(line, "data:")
+			if !found || event != string(protocol.EventMemberAuthorized) {
+				continue
+			}
+			var ev protocol.Event
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatal(err)
+			}
+			if ev.NetworkID != 3 || ev.NodeAddress != "0000000001" {
+				t.Fatalf("event = %+v", ev)
+			}
+			return
+		case <-timeout:
+			t.Fatal("no member_authorized event")
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"gorm.io/gorm"
+)
+
+// networkExportVersion is bumped when the export document changes shape.
+const networkExportVersion = 1
+
+var errNetworkIDTaken = errors.New("network ID already exists")
+
+// exportNetwork returns a JSON document that importNetwork can recreate the
+// network from. The PSK and member PSK overrides are only included with
+// ?include_psk=true for admins.
+func (ctrl *Controller) exportNetwork(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	includePSK := c.Query("include_psk") == "true"
+	if includePSK && c.GetString("role") != "admin" {
+		abortError(c, http.StatusForbidden, protocol.ErrCodeAdminRequired, "only admins may export the network PSK")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.Preload("Members.Node").Preload("Rules").First(&network, id).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
+		return
+	}
+
+	export := protocol.NetworkExport{
+		Version:    networkExportVersion,
+		ExportedAt: time.Now(),
+		Network: protocol.Network{
+			ID:             network.ID,
+			Name:           network.Name,
+			Description:    network.Description,
+			IPRange:        network.IPRange,
+			IP6Range:       network.IP6Range,
+			MTU:            network.MTU,
+			Multicast:      network.Multicast,
+			ReservedRanges: network.ReservedRanges,
+			GatewayIP:      network.GatewayIP,
+			DNSServers:     network.DNSServers,
+			SearchDomains:  network.SearchDomains,
+			Private:        network.Private,
+			CreatedAt:      network.CreatedAt,
+
+			SourceValidation: network.SourceValidation,
+			Tables:           network.tableLimits(),
+		},
+		Members: make([]protocol.ExportedMember, 0, len(network.Members)),
+		Rules:   make([]protocol.ExportedRule, 0, len(network.Rules)),
+	}
+	if includePSK {
+		export.PSK = network.PSK
+	}
+	for _, m := range network.Members {
+		em := protocol.ExportedMember{
+			NodeAddress: m.NodeAddress,
+			PublicKey:   m.Node.PublicKey,
+			Authorized:  m.Authorized,
+			IPAddress:   m.IPAddress,
+			Name:        m.Name,
+			Gateway:     m.Gateway,
+		}
+		if includePSK {
+			em.PSK = m.PSK
+		}
+		export.Members = append(export.Members, em)
+	}
+	for _, r := range network.Rules {
+		export.Rules = append(export.Rules, protocol.ExportedRule{
+			Priority:    r.Priority,
+			Action:      r.Action,
+			Src:         r.Src,
+			Dst:         r.Dst,
+			Protocol:    r.Protocol,
+			PortRange:   r.PortRange,
+			Days:        r.Days,
+			StartTime:   r.StartTime,
+			EndTime:     r.EndTime,
+			Timezone:    r.Timezone,
+			Description: r.Description,
+		})
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=network-"+strconv.FormatUint(id, 10)+".json")
+	c.JSON(http.StatusOK, export)
+}
+
+// importNetwork recreates a network from an export document. The exported ID
+// is kept with ?keep_id=true, otherwise a new one is generated. A PSK in the
+// document is reused; without one a fresh PSK is generated.
+func (ctrl *Controller) importNetwork(c *gin.Context) {
+	var req protocol.NetworkExport
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Version != networkExportVersion {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "unsupported export version "+strconv.Itoa(req.Version))
+		return
+	}
+	if req.Network.Name == "" || req.Network.IPRange == "" {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "network name and ip_range are required")
+		return
+	}
+	if _, _, err := net.ParseCIDR(req.Network.IPRange); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid ip_range")
+		return
+	}
+	if err := validateAddressPlan(req.Network.IPRange, req.Network.ReservedRanges, req.Network.GatewayIP); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := validateDNS(req.Network.DNSServers, req.Network.SearchDomains); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err := validateTableLimits(req.Network.Tables); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if req.PSK != "" && !validPSK(req.PSK) {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "psk must be 64 hex characters")
+		return
+	}
+	for _, m := range req.Members {
+		if m.PSK != "" && !validPSK(m.PSK) {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "member psk must be 64 hex characters")
+			return
+		}
+		if m.PublicKey != "" {
+			if err := validPublicKeyHex(m.PublicKey); err != nil {
+				abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "member "+m.NodeAddress+": invalid public key: "+err.Error())
+				return
+			}
+			if err := checkNodeKey(ctrl.db, m.NodeAddress, m.PublicKey); err != nil {
+				status, code := http.StatusBadRequest, protocol.ErrCodeInvalidRequest
+				if errors.Is(err, errAddressCollision) {
+					status, code = http.StatusConflict, protocol.ErrCodeAddressTaken
+				}
+				abortError(c, status, code, "member "+m.NodeAddress+": "+err.Error())
+				return
+			}
+		}
+	}
+
+	networkID := req.Network.ID
+	if c.Query("keep_id") != "true" || networkID == 0 {
+		var idBytes [4]byte
+		rand.Read(idBytes[:])
+		networkID = binary.BigEndian.Uint32(idBytes[:])
+	}
+
+	psk := req.PSK
+	if psk == "" {
+		var pskBytes [32]byte
+		rand.Read(pskBytes[:])
+		psk = hex.EncodeToString(pskBytes[:])
+	}
+
+	mtu := req.Network.MTU
+	if mtu == 0 {
+		mtu = 2800
+	}
+
+	network := Network{
+		ID:             networkID,
+		Name:           req.Network.Name,
+		Description:    req.Network.Description,
+		IPRange:        req.Network.IPRange,
+		IP6Range:       req.Network.IP6Range,
+		MTU:            mtu,
+		Multicast:      req.Network.Multicast,
+		ReservedRanges: req.Network.ReservedRanges,
+		GatewayIP:      req.Network.GatewayIP,
+		DNSServers:     req.Network.DNSServers,
+		SearchDomains:  req.Network.SearchDomains,
+		PSK:            psk,
+		Private:        req.Network.Private,
+
+		SourceValidation: req.Network.SourceValidation,
+	}
+	network.setTableLimits(req.Network.Tables)
+
+	err := ctrl.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Unscoped().Model(&Network{}).Where("id = ?", network.ID).Count(&count)
+		if count > 0 {
+			return errNetworkIDTaken
+		}
+		if err := tx.Create(&network).Error; err != nil {
+			return err
+		}
+		// GORM substitutes the column default for a zero value on create
+		if !req.Network.Multicast {
+			if err := tx.Model(&network).Update("multicast", false).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, m := range req.Members {
+			if m.PublicKey != "" {
+				node := Node{Address: m.NodeAddress, PublicKey: m.PublicKey}
+				if ctrl.config.LongAddresses {
+					node.LongAddress = longAddressHex(m.PublicKey)
+				}
+				if err := tx.Where("address = ?", m.NodeAddress).FirstOrCreate(&node).Error; err != nil {
+					return err
+				}
+			}
+			member := Member{
+				NetworkID:   network.ID,
+				NodeAddress: m.NodeAddress,
+				Authorized:  m.Authorized,
+				IPAddress:   m.IPAddress,
+				Name:        m.Name,
+				Gateway:     m.Gateway,
+				PSK:         m.PSK,
+			}
+			if err := tx.Create(&member).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, r := range req.Rules {
+			rule := Rule{
+				NetworkID:   network.ID,
+				Priority:    r.Priority,
+				Action:      r.Action,
+				Src:         r.Src,
+				Dst:         r.Dst,
+				Protocol:    r.Protocol,
+				PortRange:   r.PortRange,
+				Days:        r.Days,
+				StartTime:   r.StartTime,
+				EndTime:     r.EndTime,
+				Timezone:    r.Timezone,
+				Description: r.Description,
+			}
+			if err := tx.Create(&rule).Error; err != nil {
+				return err
+			}
+			if r.Priority == 0 {
+				if err := tx.Model(&rule).Update("priority", 0).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errNetworkIDTaken) {
+		abortError(c, http.StatusConflict, protocol.ErrCodeNetworkIDTaken, err.Error())
+		return
+	}
+	if err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "import network failed")
+		return
+	}
+
+	ctrl.events.Publish(protocol.Event{
+		Type:      protocol.EventNetworkCreated,
+		NetworkID: network.ID,
+		Data:      gin.H{"name": network.Name, "ip_range": network.IPRange, "imported": true},
+	})
+
+	c.JSON(http.StatusCreated, protocol.Network{
+		ID:             network.ID,
+		Name:           network.Name,
+		Description:    network.Description,
+		IPRange:        network.IPRange,
+		IP6Range:       network.IP6Range,
+		MTU:            network.MTU,
+		Multicast:      network.Multicast,
+		ReservedRanges: network.ReservedRanges,
+		GatewayIP:      network.GatewayIP,
+		DNSServers:     network.DNSServers,
+		SearchDomains:  network.SearchDomains,
+		Private:        network.Private,
+		MemberCount:    len(req.Members),
+		CreatedAt:      network.CreatedAt,
+
+		SourceValidation: network.SourceValidation,
+		Tables:           network.tableLimits(),
+	})
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// exportOf fetches the export document of network id from ctrl.
+func exportOf(t *testing.T, ctrl *Controller, id string, includePSK bool) protocol.NetworkExport {
+	t.Helper()
+	path := "/api/v1/networks/" + id + "/export"
+	if includePSK {
+		path += "?include_psk=true"
+	}
+	w := request(t, ctrl, "GET", path, testToken(t, ctrl, "admin"), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: HTTP %d: %s", w.Code, w.Body)
+	}
+	var export protocol.NetworkExport
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	return export
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := newTestController(t)
+	psk := "11223344556677889900aabbccddeeff00112233445566778899aabbccddeeff"
+	network := Network{ID: 42, Name: "office", Description: "HQ", IPRange: "10.42.0.0/24", MTU: 1400, PSK: psk, GatewayIP: "10.42.0.1", ReservedRanges: []string{"10.42.0.200-10.42.0.250"}}
+	if err := src.db.Create(&network).Error; err != nil {
+		t.Fatal(err)
+	}
+	a, b := newTestIdentity(t), newTestIdentity(t)
+	src.db.Create(&Node{Address: a.Address.String(), PublicKey: a.PublicKeyHex()})
+	src.db.Create(&Node{Address: b.Address.String(), PublicKey: b.PublicKeyHex()})
+	src.db.Create(&Member{NetworkID: 42, NodeAddress: a.Address.String(), Authorized: true, IPAddress: "10.42.0.2/24", Name: "laptop", Gateway: true})
+	src.db.Create(&Member{NetworkID: 42, NodeAddress: b.Address.String(), Authorized: false, Name: "pending"})
+	src.db.Create(&Rule{NetworkID: 42, Priority: 10, Action: "allow", Protocol: "tcp", PortRange: "22", Description: "ssh"})
+	src.db.Create(&Rule{NetworkID: 42, Priority: 200, Action: "drop", Src: "10.42.0.0/24"})
+	src.db.Create(&Rule{NetworkID: 42, Priority: 5, Action: "allow", Days: "mon,fri", StartTime: "09:00", EndTime: "17:00", Timezone: "Europe/Berlin"})
+
+	export := exportOf(t, src, "42", true)
+
+	// Import on a fresh controller, as when migrating, keeping the ID
+	dst := newTestController(t)
+	w := request(t, dst, "POST", "/api/v1/networks/import?keep_id=true", testToken(t, dst, "admin"), export)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("import: HTTP %d: %s", w.Code, w.Body)
+	}
+	var imported Network
+	if err := dst.db.First(&imported, 42).Error; err != nil {
+		t.Fatal(err)
+	}
+	if imported.PSK != psk || imported.Name != "office" || imported.MTU != 1400 || imported.GatewayIP != "10.42.0.1" {
+		t.Fatalf("imported network = %+v", imported)
+	}
+
+	// The export of the copy describes the same members and rules
+	again := exportOf(t, dst, "42", true)
+	if !reflect.DeepEqual(again.Members, export.Members) {
+		t.Errorf("members = %+v\nwant %+v", again.Members, export.Members)
+	}
+	if !reflect.DeepEqual(again.Rules, export.Rules) {
+		t.Errorf("rules = %+v\nwant %+v", again.Rules, export.Rules)
+	}
+	if len(export.Members) != 2 || len(export.Rules) != 3 {
+		t.Fatalf("export has %d members and %d rules, want 2 and 3", len(export.Members), len(export.Rules))
+	}
+	var windows int
+	for _, r := range again.Rules {
+		if r.Days == "mon,fri" && r.StartTime == "09:00" && r.EndTime == "17:00" && r.Timezone == "Europe/Berlin" {
+			windows++
+		}
+	}
+	if windows != 1 {
+		t.Errorf("time window lost in the round trip: %+v", again.Rules)
+	}
+
+	// The same ID cannot be imported twice; without keep_id it gets a new one
+	w = request(t, dst, "POST", "/api/v1/networks/import?keep_id=true", testToken(t, dst, "admin"), export)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("second import with the same ID: HTTP %d: %s", w.Code, w.Body)
+	}
+	w = request(t, dst, "POST", "/api/v1/networks/import", testToken(t, dst, "admin"), export)
+	var clone protocol.Network
+	if json.Unmarshal(w.Body.Bytes(), &clone); w.Code != http.StatusCreated || clone.ID == 42 || clone.MemberCount != 2 {
+		t.Fatalf("import as a clone: HTTP %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestImportWithoutPSKRegeneratesIt(t *testing.T) {
+	ctrl := newTestController(t)
+	psk := "aa00000000000000000000000000000000000000000000000000000000000000"
+	ctrl.db.Create(&Network{ID: 7, Name: "lab", IPRange: "10.7.0.0/24", PSK: psk})
+
+	export := exportOf(t, ctrl, "7", false)
+	if export.PSK != "" {
+		t.Fatal("PSK exported without include_psk")
+	}
+	w := request(t, ctrl, "POST", "/api/v1/networks/import", testToken(t, ctrl, "admin"), export)
+	var clone protocol.Network
+	if json.Unmarshal(w.Body.Bytes(), &clone); w.Code != http.StatusCreated {
+		t.Fatalf("import: HTTP %d: %s", w.Code, w.Body)
+	}
+	var network Network
+	ctrl.db.First(&network, clone.ID)
+	if !validPSK(network.PSK) || network.PSK == psk {
+		t.Fatalf("imported PSK = %q, want a fresh one", network.PSK)
+	}
+}
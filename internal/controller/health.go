@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readyTimeout bounds the database checks behind /readyz.
+const readyTimeout = 2 * time.Second
+
+var errNotMigrated = errors.New("database schema not migrated")
+
+// handleHealthz reports that the process is alive and serving HTTP.
+func (ctrl *Controller) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz reports whether the controller can serve requests: the
+// database answers a ping and the schema has been migrated.
+func (ctrl *Controller) handleReadyz(c *gin.Context) {
+	if err := ctrl.checkReady(c.Request.Context()); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (ctrl *Controller) checkReady(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	sqlDB, err := ctrl.db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return err
+	}
+
+	migrator := ctrl.db.WithContext(ctx).Migrator()
+	for _, model := range models() {
+		if !migrator.HasTable(model) {
+			return errNotMigrated
+		}
+	}
+	return nil
+}
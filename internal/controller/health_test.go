@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	ctrl := newTestController(t)
+	if w := request(t, ctrl, "GET", "/healthz", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("healthz: HTTP %d", w.Code)
+	}
+	if w := request(t, ctrl, "GET", "/readyz", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("readyz: HTTP %d: %s", w.Code, w.Body)
+	}
+
+	// A missing table means the schema was never migrated
+	if err := ctrl.db.Migrator().DropTable(&Rule{}); err != nil {
+		t.Fatal(err)
+	}
+	if w := request(t, ctrl, "GET", "/readyz", "", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz without the rules table: HTTP %d", w.Code)
+	}
+
+	sqlDB, err := ctrl.db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	if w := request(t, ctrl, "GET", "/readyz", "", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz with the database closed: HTTP %d", w.Code)
+	}
+	// The process itself is still alive
+	if w := request(t, ctrl, "GET", "/healthz", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("healthz with the database closed: HTTP %d", w.Code)
+	}
+}
@@ -0,0 +1,291 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl2"
+	"gorm.io/gorm"
+)
+
+var errIPExhausted = errors.New("no available IPs")
+
+// ipInterval is an inclusive range of addresses allocation must skip.
+type ipInterval struct {
+	first, last netip.Addr
+}
+
+// ipLock returns the mutex serializing IP allocation in a network.
+func (ctrl *Controller) ipLock(networkID uint32) *sync.Mutex {
+	lock, _ := ctrl.ipLocks.LoadOrStore(networkID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// usedIPs returns the member and gateway IPs of a network, which allocation
+// in it must skip along with its reserved ranges.
+func usedIPs(tx *gorm.DB, network Network) ([]string, error) {
+	var used []string
+	if err := tx.Model(&Member{}).Where("network_id = ? AND ip_address != ''", network.ID).Pluck("ip_address", &used).Error; err != nil {
+		return nil, err
+	}
+	if network.GatewayIP != "" {
+		used = append(used, network.GatewayIP)
+	}
+	return used, nil
+}
+
+// AllocateIP returns the lowest assignable address of cidr, as "ip/bits",
+// that is neither in used nor in a reserved range (see parseReservedRange).
+// used holds addresses with or without a "/bits" suffix; ones that do not
+// parse are ignored. The network and broadcast addresses are never
+// returned, except in a /31 or /32 (see hostRange). Callers allocating for
+// a network hold its ipLock and write the result within the transaction
+// that read used.
+func AllocateIP(cidr string, used []string, reserved ...string) (string, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid IP range: %w", err)
+	}
+	prefix = prefix.Masked()
+
+	taken := make([]ipInterval, 0, len(used)+len(reserved))
+	for _, s := range used {
+		if ip, err := parseHostIP(s); err == nil {
+			taken = append(taken, ipInterval{ip, ip})
+		}
+	}
+	for _, r := range reserved {
+		first, last, err := parseReservedRange(r)
+		if err != nil {
+			return "", err
+		}
+		taken = append(taken, ipInterval{first, last})
+	}
+	ip, ok := firstFreeIP(prefix, taken)
+	if !ok {
+		return "", fmt.Errorf("%w in range %s", errIPExhausted, cidr)
+	}
+	return fmt.Sprintf("%s/%d", ip, prefix.Bits()), nil
+}
+
+// firstFreeIP returns the lowest assignable address of prefix outside the
+// taken intervals, so allocation is deterministic: the same members always
+// get the same addresses, and the lowest freed address is reused first.
+// It walks the sorted intervals for the first gap, so the cost is
+// proportional to the number of intervals rather than the range size.
+func firstFreeIP(prefix netip.Prefix, taken []ipInterval) (netip.Addr, bool) {
+	first, last := hostRange(prefix)
+	taken = append([]ipInterval(nil), taken...)
+	sort.Slice(taken, func(i, j int) bool { return taken[i].first.Less(taken[j].first) })
+
+	candidate := first
+	for _, iv := range taken {
+		if iv.last.Less(candidate) {
+			continue // overlaps an earlier interval or lies below the range
+		}
+		if candidate.Less(iv.first) {
+			break
+		}
+		candidate = iv.last.Next()
+		if !candidate.IsValid() {
+			return netip.Addr{}, false
+		}
+	}
+	if last.Less(candidate) {
+		return netip.Addr{}, false
+	}
+	return candidate, true
+}
+
+// hostRange returns the first and last assignable addresses of prefix:
+// all but the network and broadcast addresses, except in a /31 or /32
+// (and IPv6 /127 or /128), where every address is a host (RFC 3021).
+func hostRange(prefix netip.Prefix) (first, last netip.Addr) {
+	prefix = prefix.Masked()
+	first, last = prefix.Addr(), lastAddr(prefix)
+	if prefix.Addr().BitLen()-prefix.Bits() <= 1 {
+		return first, last
+	}
+	return first.Next(), last.Prev()
+}
+
+// validateAddressPlan checks that reserved ranges and the gateway IP are well
+// formed and lie within the network's IP range.
+func validateAddressPlan(ipRange string, reserved []string, gatewayIP string) error {
+	prefix, err := netip.ParsePrefix(ipRange)
+	if err != nil {
+		return fmt.Errorf("invalid ip_range")
+	}
+	for _, r := range reserved {
+		first, last, err := parseReservedRange(r)
+		if err != nil {
+			return err
+		}
+		if !prefix.Contains(first) || !prefix.Contains(last) {
+			return fmt.Errorf("reserved range %s is outside %s", r, ipRange)
+		}
+	}
+	if gatewayIP != "" {
+		ip, err := parseHostIP(gatewayIP)
+		if err != nil {
+			return fmt.Errorf("invalid gateway_ip %q", gatewayIP)
+		}
+		if !prefix.Contains(ip) {
+			return fmt.Errorf("gateway_ip %s is outside %s", gatewayIP, ipRange)
+		}
+	}
+	return nil
+}
+
+// validateDNS checks that DNS servers are IP addresses and search domains
+// are valid domain names.
+func validateDNS(servers, domains []string) error {
+	for _, s := range servers {
+		if _, err := netip.ParseAddr(s); err != nil {
+			return fmt.Errorf("invalid dns server %q", s)
+		}
+	}
+	for _, d := range domains {
+		if !validDomain(strings.TrimSuffix(d, ".")) {
+			return fmt.Errorf("invalid search domain %q", d)
+		}
+	}
+	if len(domains) > 0 && len(servers) == 0 {
+		return fmt.Errorf("search domains require dns servers")
+	}
+	return nil
+}
+
+func validDomain(d string) bool {
+	if d == "" || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parseReservedRange accepts a CIDR ("10.0.0.0/28") or an inclusive address
+// range ("10.0.0.10-10.0.0.20").
+func parseReservedRange(s string) (netip.Addr, netip.Addr, error) {
+	if lo, hi, ok := strings.Cut(s, "-"); ok {
+		first, err1 := netip.ParseAddr(strings.TrimSpace(lo))
+		last, err2 := netip.ParseAddr(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || last.Less(first) {
+			return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid reserved range %q", s)
+		}
+		return first.Unmap(), last.Unmap(), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid reserved range %q", s)
+	}
+	prefix = prefix.Masked()
+	return prefix.Addr().Unmap(), lastAddr(prefix).Unmap(), nil
+}
+
+// parseHostIP parses an address with or without a "/bits" suffix.
+func parseHostIP(s string) (netip.Addr, error) {
+	addr, _, _ := strings.Cut(s, "/")
+	ip, err := netip.ParseAddr(addr)
+	return ip.Unmap(), err
+}
+
+// lastAddr returns the highest address in a prefix (the broadcast address).
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// nodeIP6Bits is how many low bits of a member's IPv6 address hold its node
+// address; an IPv6 range needs at least this many host bits.
+const nodeIP6Bits = 40
+
+// validateIP6Range checks that an IPv6 range is an IPv6 prefix with room for
+// node addresses.
+func validateIP6Range(ip6Range string) error {
+	prefix, err := netip.ParsePrefix(ip6Range)
+	if err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return fmt.Errorf("invalid ip6_range")
+	}
+	if prefix.Bits() > 128-nodeIP6Bits {
+		return fmt.Errorf("ip6_range %s is too small: need at most /%d", ip6Range, 128-nodeIP6Bits)
+	}
+	return nil
+}
+
+// nodeIP6 derives a member's IPv6 address from the network's IPv6 range and
+// the node address, which fills the low 40 bits. Node addresses are unique,
+// so the addresses never collide and need no allocation. Returns "" if the
+// network has no (valid) IPv6 range.
+func nodeIP6(ip6Range, nodeAddr string) string {
+	if ip6Range == "" || validateIP6Range(ip6Range) != nil {
+		return ""
+	}
+	addr, err := identity.AddressFromHex(nodeAddr)
+	if err != nil {
+		return ""
+	}
+	prefix := netip.MustParsePrefix(ip6Range).Masked()
+	b := prefix.Addr().As16()
+	copy(b[16-identity.AddressSize:], addr[:])
+	return fmt.Sprintf("%s/%d", netip.AddrFrom16(b), prefix.Bits())
+}
+
+// memberMAC returns the MAC a member's agent derives for the network (see
+// vl2.GenerateMAC), or "" for an invalid node address.
+func memberMAC(networkID uint32, nodeAddr string) string {
+	addr, err := identity.AddressFromHex(nodeAddr)
+	if err != nil {
+		return ""
+	}
+	return vl2.GenerateMAC(networkID, addr).String()
+}
+
+// validateTableLimits checks a network's table limits against the bounds
+// agents accept.
+func validateTableLimits(t protocol.TableLimits) error {
+	return vl2.TableLimits{
+		MACTableSize:   t.MACTableSize,
+		MACTableExpiry: time.Duration(t.MACTableExpiry) * time.Second,
+		ARPCacheSize:   t.ARPCacheSize,
+		ARPCacheExpiry: time.Duration(t.ARPCacheExpiry) * time.Second,
+	}.Validate()
+}
+
+// tableLimits returns the network's table limits as pushed to members.
+func (n *Network) tableLimits() protocol.TableLimits {
+	return protocol.TableLimits{
+		MACTableSize:   n.MACTableSize,
+		MACTableExpiry: n.MACTableExpiry,
+		ARPCacheSize:   n.ARPCacheSize,
+		ARPCacheExpiry: n.ARPCacheExpiry,
+	}
+}
+
+// setTableLimits sets the network's table limits.
+func (n *Network) setTableLimits(t protocol.TableLimits) {
+	n.MACTableSize = t.MACTableSize
+	n.MACTableExpiry = t.MACTableExpiry
+	n.ARPCacheSize = t.ARPCacheSize
+	n.ARPCacheExpiry = t.ARPCacheExpiry
+}
@@ -0,0 +1,201 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func TestAllocateIP(t *testing.T) {
+	// used16 fills 10.1.0.0/16 up to 10.1.0.255
+	var used16 []string
+	for i := 1; i < 256; i++ {
+		used16 = append(used16, fmt.Sprintf("10.1.0.%d/16", i))
+	}
+
+	tests := []struct {
+		name     string
+		cidr     string
+		used     []string
+		reserved []string
+		want     string // "" for an exhausted range
+	}{
+		{"/24 empty", "10.0.0.0/24", nil, nil, "10.0.0.1/24"},
+		{"/24 unmasked range", "10.0.0.77/24", nil, nil, "10.0.0.1/24"},
+		{"/24 next", "10.0.0.0/24", []string{"10.0.0.1/24", "10.0.0.2/24"}, nil, "10.0.0.3/24"},
+		{"/24 lowest freed", "10.0.0.0/24", []string{"10.0.0.1/24", "10.0.0.3/24"}, nil, "10.0.0.2/24"},
+		{"/24 reserved ranges", "10.0.0.0/24", []string{"10.0.0.11"}, []string{"10.0.0.0/29", "10.0.0.8-10.0.0.10"}, "10.0.0.12/24"},
+		{"/24 unparsable used", "10.0.0.0/24", []string{"", "bogus"}, nil, "10.0.0.1/24"},
+		{"/24 skips broadcast", "10.0.0.0/24", nil, []string{"10.0.0.1-10.0.0.253"}, "10.0.0.254/24"},
+		{"/24 full", "10.0.0.0/24", nil, []string{"10.0.0.1-10.0.0.254"}, ""},
+		{"/30", "192.168.5.4/30", []string{"192.168.5.5"}, nil, "192.168.5.6/30"},
+		{"/30 full", "192.168.5.4/30", []string{"192.168.5.5", "192.168.5.6"}, nil, ""},
+		{"/31 first", "192.168.5.0/31", nil, nil, "192.168.5.0/31"},
+		{"/31 second", "192.168.5.0/31", []string{"192.168.5.0/31"}, nil, "192.168.5.1/31"},
+		{"/31 full", "192.168.5.0/31", []string{"192.168.5.0", "192.168.5.1"}, nil, ""},
+		{"/32", "192.168.5.9/32", nil, nil, "192.168.5.9/32"},
+		{"/32 full", "192.168.5.9/32", []string{"192.168.5.9/32"}, nil, ""},
+		{"/16 crosses an octet", "10.1.0.0/16", used16, nil, "10.1.1.0/16"},
+		{"/16 last host", "10.1.0.0/16", nil, []string{"10.1.0.1-10.1.255.253"}, "10.1.255.254/16"},
+		{"/16 full", "10.1.0.0/16", nil, []string{"10.1.0.0/17", "10.1.128.0-10.1.255.254"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AllocateIP(tt.cidr, tt.used, tt.reserved...)
+			if tt.want == "" {
+				if !errors.Is(err, errIPExhausted) {
+					t.Fatalf("AllocateIP = %q, %v, want errIPExhausted", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("AllocateIP = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocateIPInvalid(t *testing.T) {
+	if _, err := AllocateIP("10.0.0.0", nil); err == nil {
+		t.Error("range without a prefix length allocated")
+	}
+	if _, err := AllocateIP("10.0.0.0/24", nil, "10.0.0.9-10.0.0.1"); err == nil {
+		t.Error("inverted reserved range accepted")
+	}
+}
+
+func TestAuthorizeMemberAllocatesIP(t *testing.T) {
+	ctrl := newTestController(t)
+	admin := testToken(t, ctrl, "admin")
+	network := Network{ID: 5, Name: "net", IPRange: "10.9.0.0/30", GatewayIP: "10.9.0.1", PSK: "00"}
+	if err := ctrl.db.Create(&network).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The /30 has two hosts and the gateway takes the first
+	w := request(t, ctrl, "POST", "/api/v1/networks/5/members", admin, protocol.AuthorizeMemberRequest{NodeAddress: "0000000001", Authorized: true})
+	if w.Code >= 300 {
+		t.Fatalf("authorize: HTTP %d: %s", w.Code, w.Body)
+	}
+	var m Member
+	ctrl.db.First(&m, "network_id = ? AND node_address = ?", 5, "0000000001")
+	if m.IPAddress != "10.9.0.2/30" {
+		t.Fatalf("allocated %q, want 10.9.0.2/30", m.IPAddress)
+	}
+
+	w = request(t, ctrl, "POST", "/api/v1/networks/5/members", admin, protocol.AuthorizeMemberRequest{NodeAddress: "0000000002", Authorized: true})
+	var apiErr protocol.APIError
+	if json.Unmarshal(w.Body.Bytes(), &apiErr); apiErr.Code != protocol.ErrCodeIPExhausted {
+		t.Fatalf("authorize in a full range: HTTP %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestConcurrentAuthorizationsGetUniqueIPs(t *testing.T) {
+	ctrl := newTestController(t)
+	admin := testToken(t, ctrl, "admin")
+	if err := ctrl.db.Create(&Network{ID: 6, Name: "net", IPRange: "10.6.0.0/24", PSK: "00"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 20
+	codes := make([]int, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			w := request(t, ctrl, "POST", "/api/v1/networks/6/members", admin, protocol.AuthorizeMemberRequest{NodeAddress: fmt.Sprintf("00000000%02x", i), Authorized: true})
+			codes[i] = w.Code
+		}()
+	}
+	close(start)
+	wg.Wait()
+	for i, code := range codes {
+		if code >= 300 {
+			t.Fatalf("authorization %d: HTTP %d", i, code)
+		}
+	}
+
+	var members []Member
+	ctrl.db.Find(&members, "network_id = ?", 6)
+	seen := make(map[string]string)
+	for _, m := range members {
+		if m.IPAddress == "" {
+			t.Fatalf("member %s has no IP", m.NodeAddress)
+		}
+		if other, dup := seen[m.IPAddress]; dup {
+			t.Fatalf("%s assigned to both %s and %s", m.IPAddress, other, m.NodeAddress)
+		}
+		seen[m.IPAddress] = m.NodeAddress
+	}
+	if len(seen) != n {
+		t.Fatalf("%d members with IPs, want %d", len(seen), n)
+	}
+}
+
+func TestAllocateIPLargeRange(t *testing.T) {
+	// Half of a /8 is reserved; the walk jumps the whole interval
+	got, err := AllocateIP("10.0.0.0/8", []string{"10.128.0.0/8", "10.128.0.2"}, "10.0.0.0/9")
+	if err != nil || got != "10.128.0.1/8" {
+		t.Fatalf("AllocateIP = %q, %v, want 10.128.0.1/8", got, err)
+	}
+}
+
+// BenchmarkAllocateIP allocates in a /16 whose lower half is in use, one
+// member per address.
+func BenchmarkAllocateIP(b *testing.B) {
+	used := make([]string, 0, 1<<15)
+	for i := 1; i < 1<<15; i++ {
+		used = append(used, fmt.Sprintf("10.1.%d.%d/16", i>>8, i&0xff))
+	}
+	for b.Loop() {
+		if got, err := AllocateIP("10.1.0.0/16", used); err != nil || got != "10.1.128.0/16" {
+			b.Fatalf("AllocateIP = %q, %v", got, err)
+		}
+	}
+}
+
+func TestAllocationSkipsReservedRangeAndGateway(t *testing.T) {
+	ctrl := newTestController(t)
+	admin := testToken(t, ctrl, "admin")
+	w := request(t, ctrl, "POST", "/api/v1/networks", admin, protocol.CreateNetworkRequest{
+		Name:           "net",
+		IPRange:        "10.8.0.0/28",
+		ReservedRanges: []string{"10.8.0.2-10.8.0.5", "10.8.0.8/30"},
+		GatewayIP:      "10.8.0.7",
+	})
+	var network protocol.Network
+	if json.Unmarshal(w.Body.Bytes(), &network); w.Code != http.StatusCreated || network.GatewayIP != "10.8.0.7" || len(network.ReservedRanges) != 2 {
+		t.Fatalf("create network: HTTP %d: %s", w.Code, w.Body)
+	}
+
+	// Hosts .1-.14 less the reservations and the gateway leave .1, .6, .12-.14
+	path := fmt.Sprintf("/api/v1/networks/%d/members", network.ID)
+	var got []string
+	for i := range 6 {
+		w := request(t, ctrl, "POST", path, admin, protocol.AuthorizeMemberRequest{NodeAddress: fmt.Sprintf("00000000%02x", i), Authorized: true})
+		var m protocol.Member
+		if json.Unmarshal(w.Body.Bytes(), &m); w.Code >= 300 {
+			got = append(got, "exhausted")
+			break
+		}
+		got = append(got, m.IPAddress)
+	}
+	want := []string{"10.8.0.1/28", "10.8.0.6/28", "10.8.0.12/28", "10.8.0.13/28", "10.8.0.14/28", "exhausted"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("allocated %v, want %v", got, want)
+	}
+
+	// Reservations must lie in the network's range
+	w = request(t, ctrl, "POST", "/api/v1/networks", admin, protocol.CreateNetworkRequest{Name: "bad", IPRange: "10.9.0.0/24", ReservedRanges: []string{"10.8.0.0/28"}})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("reserved range outside the network: HTTP %d: %s", w.Code, w.Body)
+	}
+}
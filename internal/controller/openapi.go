@@ -0,0 +1,278 @@
+package controller
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// openAPIOp describes the documented shape of one API operation, keyed by
+// handler method name. Routes without an entry are still listed in the spec.
+type openAPIOp struct {
+	Summary  string
+	Tag      string
+	Public   bool        // no bearer token required
+	Request  interface{} // request body type (zero value), or nil
+	Response interface{} // success response type (zero value), or nil
+	Status   int         // success status (default 200)
+}
+
+var openAPIOps = map[string]openAPIOp{
+	"handleLogin":    {Summary: "Log in and obtain a JWT", Tag: "auth", Public: true, Request: protocol.LoginRequest{}, Response: protocol.LoginResponse{}},
+	"handleRefresh":  {Summary: "Exchange a refresh token for a new JWT", Tag: "auth", Public: true, Request: protocol.RefreshRequest{}, Response: protocol.LoginResponse{}},
+	"handleLogout":   {Summary: "Revoke this JWT and optionally a refresh token", Tag: "auth", Request: protocol.LogoutRequest{}},
+	"handleRegister": {Summary: "Register a user (an admin token is required once one exists)", Tag: "auth", Public: true, Request: protocol.LoginRequest{}, Status: http.StatusCreated},
+
+	"handleChangePassword": {Summary: "Change the current user's password (lifts a forced change from the next login)", Tag: "auth", Request: protocol.ChangePasswordRequest{}},
+
+	"HandleAgentConnect": {Summary: "Agent control WebSocket", Tag: "agent", Public: true},
+	"rotateIdentity":     {Summary: "Move a node to a new identity (signed by its old and new keys)", Tag: "agent", Public: true, Request: protocol.RotateIdentityRequest{}, Response: protocol.RotateIdentityResponse{}},
+
+	"getAgentNetworkConfig": {Summary: "Get the calling node's network config (signed by the node, or mTLS)", Tag: "agent", Public: true, Response: protocol.NetworkConfigMessage{}},
+
+	"listNetworks":       {Summary: "List networks", Tag: "networks", Response: []protocol.Network{}},
+	"createNetwork":      {Summary: "Create a network", Tag: "networks", Request: protocol.CreateNetworkRequest{}, Response: protocol.Network{}, Status: http.StatusCreated},
+	"getNetwork":         {Summary: "Get a network", Tag: "networks", Response: protocol.Network{}},
+	"updateNetwork":      {Summary: "Update a network", Tag: "networks", Request: protocol.CreateNetworkRequest{}, Response: protocol.Network{}},
+	"deleteNetwork":      {Summary: "Delete a network, restorable until purged (admin)", Tag: "networks"},
+	"exportNetwork":      {Summary: "Export a network with members and rules (admin)", Tag: "networks", Response: protocol.NetworkExport{}},
+	"getNetworkUsage":    {Summary: "Traffic per member over a time range", Tag: "networks", Response: protocol.NetworkUsage{}},
+	"getNetworkTopology": {Summary: "Which members' agents report being connected to which", Tag: "networks", Response: protocol.NetworkTopology{}},
+	"importNetwork":      {Summary: "Recreate a network from an export (admin)", Tag: "networks", Request: protocol.NetworkExport{}, Response: protocol.Network{}, Status: http.StatusCreated},
+
+	"getNetworkPSK":    {Summary: "Show a network's PSK (admin)", Tag: "networks", Response: protocol.NetworkPSK{}},
+	"rotateNetworkPSK": {Summary: "Replace a network's PSK and push it to members (admin)", Tag: "networks", Response: protocol.NetworkPSK{}},
+
+	"evaluateRules":  {Summary: "Check which ACL rule would apply to a flow, without applying anything", Tag: "networks", Request: protocol.EvaluateRulesRequest{}, Response: protocol.EvaluateRulesResponse{}},
+	"restoreNetwork": {Summary: "Restore a deleted network (admin)", Tag: "networks", Response: protocol.Network{}},
+	"purgeNetwork":   {Summary: "Permanently remove a deleted network after its grace period (admin)", Tag: "networks"},
+
+	"listMembers":     {Summary: "List network members", Tag: "members", Response: []protocol.Member{}},
+	"authorizeMember": {Summary: "Add or authorize a member", Tag: "members", Request: protocol.AuthorizeMemberRequest{}, Response: protocol.Member{}},
+	"updateMember":    {Summary: "Update a member", Tag: "members", Request: protocol.AuthorizeMemberRequest{}, Response: protocol.Member{}},
+	"removeMember":    {Summary: "Remove a member", Tag: "members"},
+
+	"updateNode":   {Summary: "Rename or describe a node", Tag: "nodes", Request: protocol.UpdateNodeRequest{}},
+	"deleteNode":   {Summary: "Decommission a node and remove it from all networks", Tag: "nodes"},
+	"listPeers":    {Summary: "List nodes with online and data-plane status", Tag: "peers"},
+	"streamEvents": {Summary: "Stream change events (Server-Sent Events)", Tag: "events"},
+
+	"disconnectNode": {Summary: "Force-disconnect a node's agent, optionally deauthorizing it everywhere", Tag: "nodes", Request: protocol.DisconnectNodeRequest{}},
+
+	"serveOpenAPI": {Summary: "This OpenAPI document", Tag: "docs", Public: true},
+}
+
+var (
+	routeParamRe  = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+	handlerNameRe = regexp.MustCompile(`\.([A-Za-z0-9_]+)(-fm)?$`)
+	timeType      = reflect.TypeOf(time.Time{})
+)
+
+// serveOpenAPI serves the OpenAPI 3 document derived from the route table.
+func (ctrl *Controller) serveOpenAPI(c *gin.Context) {
+	c.JSON(http.StatusOK, buildOpenAPI(ctrl.router.Routes()))
+}
+
+// serveDocs serves a Swagger UI page pointed at the OpenAPI document.
+func (ctrl *Controller) serveDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
+
+// buildOpenAPI generates the spec from registered routes so it never drifts
+// from SetupRoutes.
+func buildOpenAPI(routes gin.RoutesInfo) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+	errorResp := map[string]interface{}{
+		"description": "Error",
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": schemaFor(reflect.TypeOf(protocol.APIError{}), schemas),
+			},
+		},
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, "/api/") {
+			continue
+		}
+		path := routeParamRe.ReplaceAllString(r.Path, "{$1}")
+		name := r.Handler
+		if m := handlerNameRe.FindStringSubmatch(r.Handler); m != nil {
+			name = m[1]
+		}
+		meta := openAPIOps[name]
+
+		op := map[string]interface{}{
+			"operationId": name,
+		}
+		if meta.Summary != "" {
+			op["summary"] = meta.Summary
+		}
+		if meta.Tag != "" {
+			op["tags"] = []string{meta.Tag}
+		}
+		if !meta.Public {
+			op["security"] = []map[string][]string{{"bearerAuth": {}}}
+		}
+
+		var params []map[string]interface{}
+		for _, m := range routeParamRe.FindAllStringSubmatch(r.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]string{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if meta.Request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": schemaFor(reflect.TypeOf(meta.Request), schemas),
+					},
+				},
+			}
+		}
+
+		status := meta.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		resp := map[string]interface{}{"description": http.StatusText(status)}
+		if meta.Response != nil {
+			resp["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{
+					"schema": schemaFor(reflect.TypeOf(meta.Response), schemas),
+				},
+			}
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(status): resp,
+			"default":            errorResp,
+		}
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(r.Method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "ZeroGo Controller API",
+			"version": "v1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+			},
+		},
+	}
+}
+
+// schemaFor returns a JSON schema for t, registering named structs under
+// components/schemas and returning a $ref to them.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		name := t.Name()
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		if _, done := schemas[name]; done {
+			return ref
+		}
+		schemas[name] = map[string]interface{}{} // guard against recursion
+
+		props := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			fieldName := strings.Split(tag, ",")[0]
+			if fieldName == "" {
+				fieldName = f.Name
+			}
+			props[fieldName] = schemaFor(f.Type, schemas)
+			if strings.Contains(f.Tag.Get("binding"), "required") {
+				required = append(required, fieldName)
+			}
+		}
+		obj := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			obj["required"] = required
+		}
+		schemas[name] = obj
+		return ref
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>ZeroGo Controller API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/api/v1/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
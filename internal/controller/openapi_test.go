@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIListsRoutes(t *testing.T) {
+	ctrl := newTestController(t)
+	w := request(t, ctrl, "GET", "/api/v1/openapi.json", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("HTTP %d", w.Code)
+	}
+	var spec struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range ctrl.router.Routes() {
+		if !strings.HasPrefix(r.Path, "/api/") {
+			continue
+		}
+		path := routeParamRe.ReplaceAllString(r.Path, "{$1}")
+		if _, ok := spec.Paths[path][strings.ToLower(r.Method)]; !ok {
+			t.Errorf("spec lacks %s %s", r.Method, path)
+		}
+	}
+
+	for schema, fields := range map[string][]string{
+		"LoginRequest":         {"username", "password"},
+		"LoginResponse":        {"token", "refresh_token"},
+		"CreateNetworkRequest": {"name", "ip_range"},
+		"Network":              {"id", "name", "ip_range"},
+	} {
+		for _, f := range fields {
+			if _, ok := spec.Components.Schemas[schema].Properties[f]; !ok {
+				t.Errorf("schema %s lacks %s", schema, f)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// validPSK reports whether s is a hex-encoded 32-byte pre-shared key.
+func validPSK(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// pairPSK returns the PSK two members derive their session keys from, given
+// their per-member overrides, or "" when neither has one and the network PSK
+// applies. A single override is used as is; two different overrides are
+// combined so that neither member learns the other's key.
+func pairPSK(a, b string) string {
+	switch {
+	case a == b, b == "":
+		return a
+	case a == "":
+		return b
+	}
+	if a > b {
+		a, b = b, a
+	}
+	h := sha256.New()
+	h.Write([]byte("zerogo pair psk"))
+	h.Write([]byte(a))
+	h.Write([]byte(b))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// pairPSKs returns, for each member of a network with a pair PSK towards
+// nodeAddr, that pair PSK keyed by the member's address. Members missing
+// from the map use the network PSK with nodeAddr.
+func (ctrl *Controller) pairPSKs(networkID uint32, nodeAddr string) map[string]string {
+	var members []Member
+	ctrl.db.Select("node_address", "psk").Where("network_id = ?", networkID).Find(&members)
+
+	var own string
+	for _, m := range members {
+		if m.NodeAddress == nodeAddr {
+			own = m.PSK
+		}
+	}
+	pairs := make(map[string]string)
+	for _, m := range members {
+		if m.NodeAddress == nodeAddr {
+			continue
+		}
+		if psk := pairPSK(own, m.PSK); psk != "" {
+			pairs[m.NodeAddress] = psk
+		}
+	}
+	return pairs
+}
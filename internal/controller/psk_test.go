@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPairPSKs(t *testing.T) {
+	ctrl := newTestController(t)
+	override, other := strings.Repeat("aa", 32), strings.Repeat("bb", 32)
+	ctrl.db.Create(&Network{ID: 1, Name: "net", IPRange: "10.1.0.0/24", PSK: strings.Repeat("00", 32)})
+	for addr, psk := range map[string]string{
+		"000000000a": override, // a less trusted member
+		"000000000b": "",
+		"000000000c": "",
+		"000000000d": other,
+	} {
+		ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: addr, Authorized: true, PSK: psk})
+	}
+
+	// Members without an override keep the network PSK between them
+	ofB := ctrl.pairPSKs(1, "000000000b")
+	if _, ok := ofB["000000000c"]; ok {
+		t.Errorf("b and c got a pair PSK: %v", ofB)
+	}
+	// A single override is used by both sides of each pair
+	if ofB["000000000a"] != override || ctrl.pairPSKs(1, "000000000a")["000000000b"] != override {
+		t.Errorf("a-b pair PSK: b has %q", ofB["000000000a"])
+	}
+	// Two overrides combine into a key neither member holds, the same on
+	// both sides
+	ofA, ofD := ctrl.pairPSKs(1, "000000000a"), ctrl.pairPSKs(1, "000000000d")
+	if ad := ofA["000000000d"]; ad == "" || ad == override || ad == other || ad != ofD["000000000a"] || !validPSK(ad) {
+		t.Errorf("a-d pair PSK = %q from a, %q from d", ad, ofD["000000000a"])
+	}
+}
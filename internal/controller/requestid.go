@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader carries the ID correlating a request with its log lines.
+// Clients may set it; otherwise the controller assigns one.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a client-supplied request ID.
+const maxRequestIDLength = 128
+
+// Gin context keys set by requestLogger.
+const (
+	ctxRequestID = "request_id"
+	ctxLogger    = "log"
+)
+
+// requestLogger assigns each request an ID, honoring a well-formed one from
+// the client, echoes it in the response and logs the request once it is
+// done. Handlers log through requestLog so their lines carry the ID.
+func requestLogger(log *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		reqLog := log.With("request_id", id)
+		c.Set(ctxRequestID, id)
+		c.Set(ctxLogger, reqLog)
+		c.Header(requestIDHeader, id)
+
+		c.Next()
+
+		level := slog.LevelInfo
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			level = slog.LevelError
+		case c.FullPath() == "/healthz" || c.FullPath() == "/readyz":
+			// Probes arrive every few seconds
+			level = slog.LevelDebug
+		}
+		reqLog.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"latency", time.Since(start),
+			"remote", c.ClientIP(),
+		)
+	}
+}
+
+// requestLog returns the logger of the request, tagged with its ID.
+func (ctrl *Controller) requestLog(c *gin.Context) *slog.Logger {
+	if l, ok := c.Get(ctxLogger); ok {
+		return l.(*slog.Logger)
+	}
+	return ctrl.log
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so a
+// client cannot inject anything into log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestIDEchoedOrGenerated(t *testing.T) {
+	ctrl := newTestController(t)
+	get := func(id string) string {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		w := httptest.NewRecorder()
+		ctrl.router.ServeHTTP(w, req)
+		return w.Header().Get(requestIDHeader)
+	}
+
+	if got := get("support-ticket-7"); got != "support-ticket-7" {
+		t.Errorf("client ID echoed as %q", got)
+	}
+	first, second := get(""), get("")
+	if len(first) != 16 || first == second {
+		t.Errorf("generated IDs %q and %q, want distinct random ones", first, second)
+	}
+	for _, bad := range []string{"has space", "new\nline", strings.Repeat("x", maxRequestIDLength+1)} {
+		if got := get(bad); got == bad || !validRequestID(got) {
+			t.Errorf("malformed ID %q answered with %q", bad, got)
+		}
+	}
+}
+
+func TestRequestLoggerTagsLogLines(t *testing.T) {
+	var logs bytes.Buffer
+	ctrl := &Controller{log: slog.New(slog.NewTextHandler(&logs, nil))}
+	r := gin.New()
+	r.Use(requestLogger(ctrl.log))
+	r.GET("/networks", func(c *gin.Context) {
+		ctrl.requestLog(c).Info("handler line")
+		c.Status(http.StatusTeapot)
+	})
+
+	req := httptest.NewRequest("GET", "/networks", nil)
+	req.Header.Set(requestIDHeader, "abc123")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("log:\n%s\nwant the handler line and the request line", logs.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "request_id=abc123") {
+			t.Errorf("line lacks the request ID: %s", line)
+		}
+	}
+	for _, want := range []string{"method=GET", "path=/networks", "status=418", "latency="} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("request line lacks %q: %s", want, lines[1])
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// revocationCleanupInterval bounds how often expired revocations are purged.
+const revocationCleanupInterval = time.Hour
+
+// revokedToken is an access token revoked before it expired. The row is kept
+// only until the token would have expired anyway.
+type revokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// RevocationList tracks revoked access tokens by jti. It is consulted on
+// every authenticated request, so revocations are held in memory and
+// persisted only to survive a restart.
+type RevocationList struct {
+	db *gorm.DB
+
+	mu          sync.RWMutex
+	revoked     map[string]time.Time // jti → token expiry
+	lastCleanup time.Time
+}
+
+// NewRevocationList loads the unexpired revocations from the database.
+func NewRevocationList(db *gorm.DB) (*RevocationList, error) {
+	l := &RevocationList{db: db, revoked: make(map[string]time.Time)}
+	now := time.Now()
+	if err := db.Where("expires_at <= ?", now).Delete(&revokedToken{}).Error; err != nil {
+		return nil, err
+	}
+	var rows []revokedToken
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		l.revoked[r.JTI] = r.ExpiresAt
+	}
+	l.lastCleanup = now
+	return l, nil
+}
+
+// Revoke rejects the token with the given jti until expiresAt.
+func (l *RevocationList) Revoke(jti string, expiresAt time.Time) error {
+	if err := l.db.Save(&revokedToken{JTI: jti, ExpiresAt: expiresAt}).Error; err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.revoked[jti] = expiresAt
+	cleanup := time.Since(l.lastCleanup) >= revocationCleanupInterval
+	if cleanup {
+		l.lastCleanup = time.Now()
+	}
+	l.mu.Unlock()
+
+	if cleanup {
+		l.cleanup()
+	}
+	return nil
+}
+
+// IsRevoked reports whether the token with the given jti was revoked.
+func (l *RevocationList) IsRevoked(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.revoked[jti]
+	return ok
+}
+
+// cleanup forgets revocations of tokens that have expired, which token
+// validation rejects on its own.
+func (l *RevocationList) cleanup() {
+	now := time.Now()
+	l.mu.Lock()
+	for jti, exp := range l.revoked {
+		if !exp.After(now) {
+			delete(l.revoked, jti)
+		}
+	}
+	l.mu.Unlock()
+	l.db.Where("expires_at <= ?", now).Delete(&revokedToken{})
+}
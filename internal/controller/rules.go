@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/vl2"
+)
+
+// ruleInfos returns a network's ACL rules as pushed to agents.
+func (ctrl *Controller) ruleInfos(networkID uint32) []protocol.RuleInfo {
+	var rules []Rule
+	ctrl.db.Where("network_id = ?", networkID).Order("priority, id").Find(&rules)
+	infos := make([]protocol.RuleInfo, 0, len(rules))
+	for _, r := range rules {
+		infos = append(infos, protocol.RuleInfo{
+			ID:        r.ID,
+			Priority:  r.Priority,
+			Action:    r.Action,
+			Src:       r.Src,
+			Dst:       r.Dst,
+			Protocol:  r.Protocol,
+			PortRange: r.PortRange,
+			Days:      r.Days,
+			StartTime: r.StartTime,
+			EndTime:   r.EndTime,
+			Timezone:  r.Timezone,
+		})
+	}
+	return infos
+}
+
+// evaluateRules reports what the network's agents would do with a flow: the
+// rules are parsed and matched by the same vl2 code the agents run, with
+// invalid rules skipped as agents skip them. Nothing is applied.
+func (ctrl *Controller) evaluateRules(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+	var req protocol.EvaluateRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	flow := vl2.Flow{Src: net.ParseIP(req.Src).To4(), Dst: net.ParseIP(req.Dst).To4()}
+	if flow.Src == nil || flow.Dst == nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "src and dst must be IPv4 addresses")
+		return
+	}
+	if flow.Protocol, err = vl2.ParseProtocol(req.Protocol); err != nil || flow.Protocol == 0 {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "protocol must be tcp, udp, icmp or a protocol number")
+		return
+	}
+	if req.Port < 0 || req.Port > 65535 || req.SrcPort < 0 || req.SrcPort > 65535 {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "ports must be between 0 and 65535")
+		return
+	}
+	flow.SrcPort, flow.DstPort = uint16(req.SrcPort), uint16(req.Port)
+
+	var network Network
+	if err := ctrl.db.First(&network, id).Error; err != nil {
+		abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
+		return
+	}
+
+	at := time.Now()
+	if req.At != nil {
+		at = *req.At
+	}
+	resp := protocol.EvaluateRulesResponse{Action: string(vl2.RuleAllow), At: at}
+
+	infos := ctrl.ruleInfos(network.ID)
+	rules := make([]vl2.Rule, 0, len(infos))
+	byID := make(map[uint]protocol.RuleInfo, len(infos))
+	for _, ri := range infos {
+		window, err := vl2.ParseTimeWindow(ri.Days, ri.StartTime, ri.EndTime, ri.Timezone)
+		if err == nil {
+			var rule vl2.Rule
+			rule, err = vl2.ParseRule(ri.ID, ri.Priority, ri.Action, ri.Src, ri.Dst, ri.Protocol, ri.PortRange, window)
+			if err == nil {
+				rules = append(rules, rule)
+				byID[ri.ID] = ri
+				continue
+			}
+		}
+		resp.SkippedRules = append(resp.SkippedRules, ri.ID)
+	}
+
+	if r := vl2.Evaluate(rules, flow, at); r != nil {
+		info := byID[r.ID]
+		resp.Action = string(r.Action)
+		resp.Rule = &info
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func TestEvaluateRules(t *testing.T) {
+	ctrl := newTestController(t)
+	token := testToken(t, ctrl, "user")
+	ctrl.db.Create(&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", PSK: "00"})
+	for _, r := range []Rule{
+		{ID: 1, NetworkID: 1, Priority: 10, Action: "allow", Src: "10.1.0.0/28", Protocol: "tcp", PortRange: "22"},
+		{ID: 2, NetworkID: 1, Priority: 20, Action: "drop", Protocol: "tcp", PortRange: "22"},
+		{ID: 3, NetworkID: 1, Priority: 30, Action: "drop", Protocol: "icmp", Days: "sat,sun"},
+		{ID: 4, NetworkID: 1, Priority: 5, Action: "reject", Protocol: "tcp"},
+	} {
+		ctrl.db.Create(&r)
+	}
+	wednesday := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	saturday := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		src      string
+		protocol string
+		port     int
+		at       time.Time
+		action   string
+		rule     uint // 0 = no rule matched
+	}{
+		{"ssh from the admin range", "10.1.0.5", "tcp", 22, wednesday, "allow", 1},
+		{"ssh from elsewhere", "10.1.0.50", "tcp", 22, wednesday, "drop", 2},
+		{"web", "10.1.0.50", "tcp", 80, wednesday, "allow", 0},
+		{"ping at the weekend", "10.1.0.50", "icmp", 0, saturday, "drop", 3},
+		{"ping on a weekday", "10.1.0.50", "icmp", 0, wednesday, "allow", 0},
+	}
+	for _, tt := range tests {
+		at := tt.at
+		w := request(t, ctrl, "POST", "/api/v1/networks/1/rules/evaluate", token, protocol.EvaluateRulesRequest{
+			Src: tt.src, Dst: "10.1.0.1", Protocol: tt.protocol, Port: tt.port, At: &at,
+		})
+		var resp protocol.EvaluateRulesResponse
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("%s: HTTP %d: %s", tt.name, w.Code, w.Body)
+		}
+		var rule uint
+		if resp.Rule != nil {
+			rule = resp.Rule.ID
+		}
+		if resp.Action != tt.action || rule != tt.rule {
+			t.Errorf("%s: %s by rule %d, want %s by rule %d", tt.name, resp.Action, rule, tt.action, tt.rule)
+		}
+		// The invalid rule is ignored, as agents do
+		if len(resp.SkippedRules) != 1 || resp.SkippedRules[0] != 4 {
+			t.Errorf("%s: skipped rules %v, want [4]", tt.name, resp.SkippedRules)
+		}
+	}
+
+	// Evaluation changes nothing agents see
+	var rules int64
+	ctrl.db.Model(&Rule{}).Count(&rules)
+	if rules != 4 {
+		t.Errorf("%d rules after evaluation", rules)
+	}
+
+	for _, tt := range []struct {
+		path string
+		req  protocol.EvaluateRulesRequest
+		code int
+	}{
+		{"/api/v1/networks/1/rules/evaluate", protocol.EvaluateRulesRequest{Src: "fd00::1", Dst: "10.1.0.1", Protocol: "tcp"}, http.StatusBadRequest},
+		{"/api/v1/networks/1/rules/evaluate", protocol.EvaluateRulesRequest{Src: "10.1.0.5", Dst: "10.1.0.1", Protocol: "sctp-ish"}, http.StatusBadRequest},
+		{"/api/v1/networks/1/rules/evaluate", protocol.EvaluateRulesRequest{Src: "10.1.0.5", Dst: "10.1.0.1", Protocol: "tcp", Port: 70000}, http.StatusBadRequest},
+		{"/api/v1/networks/9/rules/evaluate", protocol.EvaluateRulesRequest{Src: "10.1.0.5", Dst: "10.1.0.1", Protocol: "tcp"}, http.StatusNotFound},
+	} {
+		if w := request(t, ctrl, "POST", tt.path, token, tt.req); w.Code != tt.code {
+			t.Errorf("POST %s %+v: HTTP %d, want %d", tt.path, tt.req, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+)
+
+// serverTLSConfig builds the HTTPS configuration, or returns nil when no
+// server certificate is configured. With a client CA, certificates are
+// requested but not required at the TLS layer, since the web UI and CLI
+// share the listener; agent connections are checked by checkAgentCert.
+func serverTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	if cfg.Cert == "" && cfg.Key == "" {
+		if cfg.ClientCA != "" || cfg.RequireAgentCert {
+			return nil, errors.New("tls.client_ca and tls.require_agent_cert need tls.cert and tls.key")
+		}
+		return nil, nil
+	}
+	if cfg.RequireAgentCert && cfg.ClientCA == "" {
+		return nil, errors.New("tls.require_agent_cert needs tls.client_ca")
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCA != "" {
+		pool, err := loadCertPool(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("load client CA: %w", err)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsCfg, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// checkAgentCert verifies that an agent connection presents a client
+// certificate issued for nodeAddr. The TLS handshake has already rejected
+// certificates not signed by the client CA, so only the binding to the node
+// address is left to check.
+func (ctrl *Controller) checkAgentCert(r *http.Request, nodeAddr string) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		if ctrl.config.TLS.RequireAgentCert {
+			return errors.New("client certificate required")
+		}
+		return nil
+	}
+	leaf := r.TLS.VerifiedChains[0][0]
+	if !certMatchesAddress(leaf, nodeAddr) {
+		return fmt.Errorf("client certificate %q does not match node address %s", leaf.Subject.CommonName, nodeAddr)
+	}
+	return nil
+}
+
+// certMatchesAddress reports whether cert names the node address as its
+// common name or one of its DNS SANs.
+func certMatchesAddress(cert *x509.Certificate, nodeAddr string) bool {
+	if nodeAddr == "" {
+		return false
+	}
+	if strings.EqualFold(cert.Subject.CommonName, nodeAddr) {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, nodeAddr) {
+			return true
+		}
+	}
+	return false
+}
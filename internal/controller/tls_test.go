@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+)
+
+// testPKI is a CA issuing certificates for TLS tests, keeping its files in
+// a temporary directory.
+type testPKI struct {
+	dir    string
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	caPath string
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	p := &testPKI{dir: t.TempDir(), pool: x509.NewCertPool()}
+	var der []byte
+	der, p.key = p.create(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "zerogo test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	p.cert, _ = x509.ParseCertificate(der)
+	p.pool.AddCert(p.cert)
+	p.caPath = p.write(t, "ca.pem", "CERTIFICATE", der)
+	return p
+}
+
+// create signs tmpl with the CA, or with its own new key if there is no
+// CA yet, and returns the DER certificate and its key.
+func (p *testPKI) create(t *testing.T, tmpl *x509.Certificate) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := tmpl, key
+	if p.cert != nil {
+		parent, signer = p.cert, p.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func (p *testPKI) write(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue returns the certificate and key files of a certificate for name,
+// as its common name and a DNS SAN, valid for 127.0.0.1 as well.
+func (p *testPKI) issue(t *testing.T, name string) (certPath, keyPath string) {
+	t.Helper()
+	der, key := p.create(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{name},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.write(t, name+".pem", "CERTIFICATE", der), p.write(t, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestAgentMTLS(t *testing.T) {
+	pki, rogue := newTestPKI(t), newTestPKI(t)
+	serverCert, serverKey := pki.issue(t, "controller")
+	ctrl := newTestControllerWith(t, func(cfg *config.ControllerConfig) {
+		cfg.TLS = config.TLSConfig{Cert: serverCert, Key: serverKey, ClientCA: pki.caPath, RequireAgentCert: true}
+	})
+	srv := httptest.NewUnstartedServer(ctrl.router)
+	srv.TLS = ctrl.tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	id, other := newTestIdentity(t), newTestIdentity(t)
+	// dial connects as id with the client certificate files, if any, and
+	// returns the HTTP status of the upgrade
+	dial := func(certPath, keyPath string) (int, error) {
+		tlsCfg := &tls.Config{RootCAs: pki.pool}
+		if certPath != "" {
+			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+		header := http.Header{}
+		header.Set("X-Node-Address", id.Address.String())
+		header.Set("X-Public-Key", id.PublicKeyHex())
+		dialer := websocket.Dialer{TLSClientConfig: tlsCfg, HandshakeTimeout: 2 * time.Second}
+		conn, resp, err := dialer.Dial("wss"+strings.TrimPrefix(srv.URL, "https")+"/api/v1/agent/connect", header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			return 0, err
+		}
+		return resp.StatusCode, err
+	}
+
+	if code, _ := dial("", ""); code != http.StatusUnauthorized {
+		t.Errorf("without a client certificate: HTTP %d, want 401", code)
+	}
+	if code, _ := dial(pki.issue(t, other.Address.String())); code != http.StatusUnauthorized {
+		t.Errorf("with another node's certificate: HTTP %d, want 401", code)
+	}
+	if code, err := dial(rogue.issue(t, id.Address.String())); code != 0 || err == nil {
+		t.Errorf("with a certificate from another CA: HTTP %d, %v, want a failed handshake", code, err)
+	}
+	if code, err := dial(pki.issue(t, id.Address.String())); code != http.StatusSwitchingProtocols {
+		t.Errorf("with the node's certificate: HTTP %d, %v", code, err)
+	}
+}
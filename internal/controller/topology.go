@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"gorm.io/gorm"
+)
+
+// getNetworkTopology serves GET /networks/:id/topology: which of the
+// network's members are connected to which, from their agents' last status
+// reports.
+func (ctrl *Controller) getNetworkTopology(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.First(&network, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
+		} else {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	var members []string
+	if err := ctrl.db.Model(&Member{}).Where("network_id = ? AND authorized = ?", id, true).
+		Order("node_address").Pluck("node_address", &members).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+
+	topology := buildTopology(members, ctrl.ws.GetAgentPresence())
+	topology.NetworkID = uint32(id)
+	c.JSON(http.StatusOK, topology)
+}
+
+// buildTopology merges the peer reports of members' agents into one
+// undirected edge per connected pair. Peers outside members are left out,
+// since an agent in several networks reports peers from all of them.
+func buildTopology(members []string, presence map[string]AgentPresence) protocol.NetworkTopology {
+	topology := protocol.NetworkTopology{
+		Nodes: make([]protocol.TopologyNode, 0, len(members)),
+		Edges: []protocol.TopologyEdge{},
+	}
+	isMember := make(map[string]bool, len(members))
+	for _, addr := range members {
+		isMember[addr] = true
+		status := protocol.PresenceOffline
+		if p, ok := presence[addr]; ok {
+			status = p.Status
+		}
+		topology.Nodes = append(topology.Nodes, protocol.TopologyNode{Address: addr, Status: status})
+	}
+
+	type pair struct{ a, b string }
+	type sides struct {
+		reports        int
+		relay          bool
+		latency, count int64
+	}
+	edges := make(map[pair]*sides)
+	for _, addr := range members {
+		for _, peer := range presence[addr].peers {
+			if peer.Address == addr || !isMember[peer.Address] {
+				continue
+			}
+			key := pair{addr, peer.Address}
+			if key.b < key.a {
+				key = pair{key.b, key.a}
+			}
+			e := edges[key]
+			if e == nil {
+				e = &sides{}
+				edges[key] = e
+			}
+			e.reports++
+			if peer.Path == "relay" {
+				e.relay = true
+			}
+			if peer.LatencyMs > 0 {
+				e.latency += peer.LatencyMs
+				e.count++
+			}
+		}
+	}
+
+	for key, e := range edges {
+		edge := protocol.TopologyEdge{
+			A:         key.a,
+			B:         key.b,
+			Path:      "direct",
+			Symmetric: e.reports > 1,
+		}
+		if e.relay {
+			edge.Path = "relay"
+		}
+		if e.count > 0 {
+			edge.LatencyMs = e.latency / e.count
+		}
+		topology.Edges = append(topology.Edges, edge)
+	}
+	sort.Slice(topology.Edges, func(i, j int) bool {
+		if topology.Edges[i].A != topology.Edges[j].A {
+			return topology.Edges[i].A < topology.Edges[j].A
+		}
+		return topology.Edges[i].B < topology.Edges[j].B
+	})
+	return topology
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func TestTopologyEdgeFromMutualReports(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	a, b := newTestIdentity(t), newTestIdentity(t)
+	addrA, addrB := a.Address.String(), b.Address.String()
+	if err := ctrl.db.Create(&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", PSK: strings.Repeat("ab", 32)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{addrA, addrB} {
+		if err := ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: addr, Authorized: true}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each side measures the link a little differently
+	for _, side := range []struct {
+		id      *identity.Identity
+		peer    string
+		latency int64
+	}{
+		{a, addrB, 10},
+		{b, addrA, 20},
+	} {
+		agent := dialAgent(t, srv, side.id)
+		agent.sendSigned(t, side.id, agent.join(t, side.id, side.id), nil)
+		agent.sendSigned(t, side.id, protocol.StatusMessage{
+			Type:  protocol.MsgTypeStatus,
+			Peers: []protocol.PeerStatus{{Address: side.peer, LatencyMs: side.latency, Path: "direct"}},
+		}, nil)
+	}
+
+	admin := testToken(t, ctrl, "admin")
+	var topology protocol.NetworkTopology
+	waitForCond(t, "both reports", func() bool {
+		w := request(t, ctrl, "GET", "/api/v1/networks/1/topology", admin, nil)
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &topology) != nil {
+			t.Fatalf("topology: HTTP %d %s", w.Code, w.Body)
+		}
+		return len(topology.Edges) == 1 && topology.Edges[0].Symmetric
+	})
+	lo, hi := addrA, addrB
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+	want := protocol.TopologyEdge{A: lo, B: hi, Path: "direct", LatencyMs: 15, Symmetric: true}
+	if topology.NetworkID != 1 || topology.Edges[0] != want {
+		t.Fatalf("topology = %+v, want the single edge %+v", topology, want)
+	}
+
+	if w := request(t, ctrl, "GET", "/api/v1/networks/9/topology", admin, nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown network: HTTP %d", w.Code)
+	}
+}
+
+func TestBuildTopology(t *testing.T) {
+	report := func(peers ...protocol.PeerStatus) AgentPresence {
+		return AgentPresence{Status: protocol.PresenceConnected, peers: peers}
+	}
+	presence := map[string]AgentPresence{
+		// a reaches b directly and c only over a relay; b has not seen a yet
+		"a": report(
+			protocol.PeerStatus{Address: "b", LatencyMs: 8, Path: "direct"},
+			protocol.PeerStatus{Address: "c", LatencyMs: 40, Path: "relay"},
+			protocol.PeerStatus{Address: "outsider", Path: "direct"}, // from another network
+		),
+		"b": {Status: protocol.PresenceIsolated},
+		"c": report(protocol.PeerStatus{Address: "a", Path: "direct"}),
+	}
+	got := buildTopology([]string{"a", "b", "c", "d"}, presence)
+
+	wantNodes := []protocol.TopologyNode{
+		{Address: "a", Status: protocol.PresenceConnected},
+		{Address: "b", Status: protocol.PresenceIsolated},
+		{Address: "c", Status: protocol.PresenceConnected},
+		{Address: "d", Status: protocol.PresenceOffline},
+	}
+	wantEdges := []protocol.TopologyEdge{
+		{A: "a", B: "b", Path: "direct", LatencyMs: 8, Symmetric: false},
+		{A: "a", B: "c", Path: "relay", LatencyMs: 40, Symmetric: true},
+	}
+	if !reflect.DeepEqual(got.Nodes, wantNodes) {
+		t.Errorf("nodes = %+v, want %+v", got.Nodes, wantNodes)
+	}
+	if !reflect.DeepEqual(got.Edges, wantEdges) {
+		t.Errorf("edges = %+v, want %+v", got.Edges, wantEdges)
+	}
+
+	if empty := buildTopology(nil, presence); len(empty.Nodes) != 0 || empty.Edges == nil || len(empty.Edges) != 0 {
+		t.Errorf("topology without members = %+v", empty)
+	}
+}
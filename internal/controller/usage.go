@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// usageBucket is the granularity usage is stored and queried at.
+const usageBucket = time.Hour
+
+// defaultUsageRange is the range GET .../usage covers without ?from.
+const defaultUsageRange = 24 * time.Hour
+
+// Usage is the traffic a member sent and received in a network during one
+// usageBucket.
+type Usage struct {
+	NetworkID   uint32    `gorm:"primaryKey" json:"network_id"`
+	NodeAddress string    `gorm:"primaryKey" json:"node_address"`
+	Bucket      time.Time `gorm:"primaryKey" json:"bucket"`
+	BytesSent   int64     `json:"bytes_sent"`
+	BytesRecv   int64     `json:"bytes_recv"`
+}
+
+// usageCounter is the last cumulative byte count a node reported for a peer.
+// Agents report counters that only grow until the agent restarts, so usage
+// is accumulated from the difference between consecutive reports.
+type usageCounter struct {
+	NodeAddress string `gorm:"primaryKey"`
+	PeerAddress string `gorm:"primaryKey"`
+	BytesSent   int64
+	BytesRecv   int64
+	UpdatedAt   time.Time
+}
+
+// counterDelta returns the traffic since the previous report. A counter lower
+// than last means the agent restarted and counted from zero again.
+func counterDelta(last, cur int64) int64 {
+	if cur < last {
+		return cur
+	}
+	return cur - last
+}
+
+// recordUsage adds the traffic in a status report to the member usage of the
+// network the node shares with each peer.
+func (ctrl *Controller) recordUsage(nodeAddr string, peers []protocol.PeerStatus, now time.Time) error {
+	bucket := now.UTC().Truncate(usageBucket)
+	return ctrl.db.Transaction(func(tx *gorm.DB) error {
+		for _, p := range peers {
+			if p.BytesSent < 0 || p.BytesRecv < 0 {
+				continue
+			}
+
+			var last usageCounter
+			if err := tx.Where("node_address = ? AND peer_address = ?", nodeAddr, p.Address).
+				Limit(1).Find(&last).Error; err != nil {
+				return err
+			}
+			sent := counterDelta(last.BytesSent, p.BytesSent)
+			recv := counterDelta(last.BytesRecv, p.BytesRecv)
+
+			counter := usageCounter{NodeAddress: nodeAddr, PeerAddress: p.Address, BytesSent: p.BytesSent, BytesRecv: p.BytesRecv}
+			if err := tx.Save(&counter).Error; err != nil {
+				return err
+			}
+			if sent == 0 && recv == 0 {
+				continue
+			}
+
+			// Attribute the traffic to a network both ends are members of
+			var networkIDs []uint32
+			if err := tx.Model(&Member{}).Scopes(inLiveNetwork).
+				Where("node_address = ? AND network_id IN (?)", nodeAddr,
+					tx.Model(&Member{}).Select("network_id").Where("node_address = ?", p.Address)).
+				Order("network_id").Limit(1).Pluck("network_id", &networkIDs).Error; err != nil {
+				return err
+			}
+			if len(networkIDs) == 0 {
+				continue
+			}
+
+			usage := Usage{NetworkID: networkIDs[0], NodeAddress: nodeAddr, Bucket: bucket, BytesSent: sent, BytesRecv: recv}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "network_id"}, {Name: "node_address"}, {Name: "bucket"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"bytes_sent": gorm.Expr("bytes_sent + ?", sent),
+					"bytes_recv": gorm.Expr("bytes_recv + ?", recv),
+				}),
+			}).Create(&usage).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// getNetworkUsage serves GET /networks/:id/usage?from=&to= (RFC 3339). The
+// range defaults to the last 24 hours and is rounded to whole hours.
+func (ctrl *Controller) getNetworkUsage(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid network ID")
+		return
+	}
+
+	to := time.Now().UTC()
+	if s := c.Query("to"); s != "" {
+		if to, err = time.Parse(time.RFC3339, s); err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid to (want RFC 3339)")
+			return
+		}
+	}
+	from := to.Add(-defaultUsageRange)
+	if s := c.Query("from"); s != "" {
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid from (want RFC 3339)")
+			return
+		}
+	}
+	if !from.Before(to) {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "from must be before to")
+		return
+	}
+
+	var network Network
+	if err := ctrl.db.First(&network, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			abortError(c, http.StatusNotFound, protocol.ErrCodeNetworkNotFound, "network not found")
+		} else {
+			abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		}
+		return
+	}
+
+	result := protocol.NetworkUsage{
+		NetworkID: uint32(id),
+		From:      from.UTC().Truncate(usageBucket),
+		To:        to.UTC(),
+		Members:   []protocol.MemberUsage{},
+	}
+	var rows []protocol.MemberUsage
+	if err := ctrl.db.Model(&Usage{}).
+		Select("node_address, SUM(bytes_sent) AS bytes_sent, SUM(bytes_recv) AS bytes_recv").
+		Where("network_id = ? AND bucket >= ? AND bucket < ?", id, result.From, result.To).
+		Group("node_address").Order("node_address").
+		Scan(&rows).Error; err != nil {
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, err.Error())
+		return
+	}
+	for _, r := range rows {
+		result.BytesSent += r.BytesSent
+		result.BytesRecv += r.BytesRecv
+		result.Members = append(result.Members, r)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func TestUsageAccumulatesAcrossReset(t *testing.T) {
+	ctrl := newTestController(t)
+	ctrl.db.Create(&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", PSK: "00"})
+	for _, addr := range []string{"00000000aa", "00000000bb"} {
+		ctrl.db.Create(&Member{NetworkID: 1, NodeAddress: addr, Authorized: true})
+	}
+	hour := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	report := func(node, peer string, sent, recv int64, at time.Time) {
+		t.Helper()
+		if err := ctrl.recordUsage(node, []protocol.PeerStatus{{Address: peer, BytesSent: sent, BytesRecv: recv}}, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report("00000000aa", "00000000bb", 1000, 500, hour.Add(5*time.Minute))
+	report("00000000aa", "00000000bb", 1500, 700, hour.Add(15*time.Minute)) // +500, +200
+	report("00000000aa", "00000000bb", 100, 50, hour.Add(25*time.Minute))   // restarted: +100, +50
+	report("00000000bb", "00000000aa", 300, 400, hour.Add(30*time.Minute))
+	report("00000000aa", "00000000bb", 900, 50, hour.Add(75*time.Minute)) // next hour
+
+	usage := func(query string) protocol.NetworkUsage {
+		t.Helper()
+		w := request(t, ctrl, "GET", "/api/v1/networks/1/usage"+query, testToken(t, ctrl, "admin"), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("usage%s: HTTP %d: %s", query, w.Code, w.Body)
+		}
+		var u protocol.NetworkUsage
+		if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+
+	u := usage("?from=2026-10-14T10:00:00Z&to=2026-10-14T11:00:00Z")
+	want := []protocol.MemberUsage{
+		{NodeAddress: "00000000aa", BytesSent: 1600, BytesRecv: 750},
+		{NodeAddress: "00000000bb", BytesSent: 300, BytesRecv: 400},
+	}
+	if !reflect.DeepEqual(u.Members, want) || u.BytesSent != 1900 || u.BytesRecv != 1150 {
+		t.Fatalf("first hour usage = %+v, want members %+v", u, want)
+	}
+	u = usage("?from=2026-10-14T10:30:00Z&to=2026-10-14T12:00:00Z")
+	if u.BytesSent != 2700 || u.BytesRecv != 1150 || !u.From.Equal(hour) {
+		t.Fatalf("range rounded down to the hour = %+v", u)
+	}
+
+	for _, query := range []string{"?from=yesterday", "?from=2026-10-14T12:00:00Z&to=2026-10-14T10:00:00Z"} {
+		if w := request(t, ctrl, "GET", "/api/v1/networks/1/usage"+query, testToken(t, ctrl, "admin"), nil); w.Code != http.StatusBadRequest {
+			t.Errorf("usage%s: HTTP %d", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// webUI is the admin web UI served at / when enabled.
+//
+//go:embed webui
+var webUI embed.FS
+
+// setupStaticFiles serves the admin web UI for all non-API routes. The UI is
+// embedded in the binary; ZEROGO_WEB_PATH replaces it with a directory, e.g.
+// a build of the web/ frontend.
+func (ctrl *Controller) setupStaticFiles(router *gin.Engine) {
+	var files fs.FS
+	if envPath := os.Getenv("ZEROGO_WEB_PATH"); envPath != "" {
+		files = os.DirFS(envPath)
+	} else if ctrl.config.WebUI {
+		files, _ = fs.Sub(webUI, "webui")
+	}
+	fileServer := http.FileServer(http.FS(files))
+
+	router.NoRoute(func(c *gin.Context) {
+		// Don't handle API routes
+		if strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			abortError(c, http.StatusNotFound, protocol.ErrCodeNotFound, "API endpoint not found")
+			return
+		}
+		if files == nil {
+			abortError(c, http.StatusNotFound, protocol.ErrCodeUIDisabled, "web UI not enabled")
+			return
+		}
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			abortError(c, http.StatusMethodNotAllowed, protocol.ErrCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		// Unknown paths get the index, so client-side routes survive a reload
+		name := strings.TrimPrefix(path.Clean(c.Request.URL.Path), "/")
+		if name == "" {
+			name = "index.html"
+		}
+		if _, err := fs.Stat(files, name); err != nil {
+			c.FileFromFS("/", http.FS(files))
+			return
+		}
+		fileServer.ServeHTTP(c.Writer, c.Request)
+	})
+}
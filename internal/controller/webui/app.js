@@ -0,0 +1,212 @@
+// ZeroGo admin UI: a thin client over the /api/v1 endpoints.
+'use strict';
+
+const $ = (id) => document.getElementById(id);
+let token = sessionStorage.getItem('zerogo_token');
+let currentNetwork = null;
+let events = null; // AbortController of the event stream
+
+async function api(method, path, body) {
+  const res = await fetch('/api/v1' + path, {
+    method,
+    headers: {
+      'Authorization': 'Bearer ' + token,
+      ...(body ? { 'Content-Type': 'application/json' } : {}),
+    },
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  if (res.status === 401) {
+    logout();
+    throw new Error('session expired');
+  }
+  const data = res.status === 204 ? null : await res.json();
+  if (!res.ok) {
+    throw new Error((data && data.message) || res.statusText);
+  }
+  return data;
+}
+
+function el(tag, text, cls) {
+  const e = document.createElement(tag);
+  if (text !== undefined) e.textContent = text;
+  if (cls) e.className = cls;
+  return e;
+}
+
+function show(loggedIn) {
+  $('login-view').hidden = loggedIn;
+  $('app-view').hidden = !loggedIn;
+  $('logout').hidden = !loggedIn;
+  $('live').hidden = !loggedIn;
+}
+
+function logout() {
+  token = null;
+  sessionStorage.removeItem('zerogo_token');
+  if (events) events.abort();
+  show(false);
+}
+
+async function loadNetworks() {
+  const networks = await api('GET', '/networks');
+  const list = $('networks');
+  list.replaceChildren();
+  for (const n of networks) {
+    const li = el('li', `${n.name} (${n.ip_range})`);
+    if (currentNetwork && currentNetwork.id === n.id) li.className = 'active';
+    li.onclick = () => { currentNetwork = n; loadNetworks(); loadMembers(); };
+    list.append(li);
+  }
+  if (!currentNetwork && networks.length > 0) {
+    currentNetwork = networks[0];
+    list.firstChild.className = 'active';
+    loadMembers();
+  }
+}
+
+async function loadMembers() {
+  const body = $('members');
+  body.replaceChildren();
+  if (!currentNetwork) return;
+  $('members-title').textContent = `Members of ${currentNetwork.name}`;
+  const members = await api('GET', `/networks/${currentNetwork.id}/members`);
+  for (const m of members) {
+    const tr = el('tr');
+    tr.append(
+      el('td', m.node_address, 'mono'),
+      el('td', m.name || (m.node && m.node.name) || ''),
+      el('td', m.ip_address || ''),
+      el('td', m.authorized ? 'authorized' : 'pending'),
+    );
+    const actions = el('td');
+    const toggle = el('button', m.authorized ? 'Deauthorize' : 'Authorize');
+    toggle.onclick = () => memberAction(() => api('PUT', `/networks/${currentNetwork.id}/members/${m.node_address}`, { node_address: m.node_address, authorized: !m.authorized }));
+    const remove = el('button', 'Remove');
+    remove.onclick = () => {
+      if (confirm(`Remove ${m.node_address} from ${currentNetwork.name}?`)) {
+        memberAction(() => api('DELETE', `/networks/${currentNetwork.id}/members/${m.node_address}`));
+      }
+    };
+    actions.append(toggle, ' ', remove);
+    tr.append(actions);
+    body.append(tr);
+  }
+}
+
+async function memberAction(fn) {
+  $('members-error').textContent = '';
+  try {
+    await fn();
+    await loadMembers();
+  } catch (err) {
+    $('members-error').textContent = err.message;
+  }
+}
+
+async function loadPeers() {
+  const peers = await api('GET', '/peers');
+  const body = $('peers');
+  body.replaceChildren();
+  for (const p of peers) {
+    const tr = el('tr');
+    const online = el('td');
+    const status = p.status || (p.online ? 'connected' : 'offline');
+    online.append(el('span', status, 'badge ' + ({ connected: 'on', isolated: 'warn' }[status] || 'off')));
+    tr.append(
+      el('td', p.address, 'mono'),
+      el('td', p.name || ''),
+      el('td', p.platform || ''),
+      online,
+      el('td', p.last_seen && !p.last_seen.startsWith('0001') ? new Date(p.last_seen).toLocaleString() : ''),
+    );
+    body.append(tr);
+  }
+}
+
+// streamEvents follows the SSE stream with fetch, since EventSource cannot
+// send the Authorization header, and refreshes the affected views.
+async function streamEvents() {
+  events = new AbortController();
+  const live = $('live');
+  try {
+    const res = await fetch('/api/v1/events', {
+      headers: { 'Authorization': 'Bearer ' + token },
+      signal: events.signal,
+    });
+    if (!res.ok) throw new Error(res.statusText);
+    live.className = 'badge on';
+    const reader = res.body.getReader();
+    const decoder = new TextDecoder();
+    let buf = '';
+    for (;;) {
+      const { value, done } = await reader.read();
+      if (done) break;
+      buf += decoder.decode(value, { stream: true });
+      let i;
+      while ((i = buf.indexOf('\n\n')) >= 0) {
+        const block = buf.slice(0, i);
+        buf = buf.slice(i + 2);
+        const type = (block.match(/^event:(.*)$/m) || [])[1];
+        if (type) onEvent(type.trim());
+      }
+    }
+  } catch (err) {
+    if (events.signal.aborted) return;
+  }
+  live.className = 'badge off';
+  if (token) setTimeout(streamEvents, 5000);
+}
+
+function onEvent(type) {
+  if (type.startsWith('node_')) loadPeers();
+  if (type.startsWith('member_')) { loadMembers(); loadPeers(); }
+  if (type.startsWith('network_')) loadNetworks();
+}
+
+function start() {
+  show(true);
+  loadNetworks().catch((err) => { $('network-error').textContent = err.message; });
+  loadPeers().catch(() => {});
+  streamEvents();
+}
+
+$('login-form').onsubmit = async (e) => {
+  e.preventDefault();
+  const form = new FormData(e.target);
+  $('login-error').textContent = '';
+  const res = await fetch('/api/v1/auth/login', {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ username: form.get('username'), password: form.get('password') }),
+  });
+  const data = await res.json();
+  if (!res.ok) {
+    $('login-error').textContent = data.message || 'login failed';
+    return;
+  }
+  token = data.token;
+  sessionStorage.setItem('zerogo_token', token);
+  start();
+};
+
+$('network-form').onsubmit = async (e) => {
+  e.preventDefault();
+  const form = new FormData(e.target);
+  $('network-error').textContent = '';
+  try {
+    currentNetwork = await api('POST', '/networks', { name: form.get('name'), ip_range: form.get('ip_range') });
+    e.target.reset();
+    await loadNetworks();
+    await loadMembers();
+  } catch (err) {
+    $('network-error').textContent = err.message;
+  }
+};
+
+$('logout').onclick = () => {
+  // Revoke the token server-side; log out locally regardless
+  api('POST', '/auth/logout').catch(() => {});
+  logout();
+};
+
+if (token) start(); else show(false);
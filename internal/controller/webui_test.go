@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+func TestWebUI(t *testing.T) {
+	t.Setenv("ZEROGO_WEB_PATH", "")
+	ctrl := newTestControllerWith(t, func(cfg *config.ControllerConfig) { cfg.WebUI = true })
+
+	tests := []struct {
+		method, path string
+		code         int
+		want         string // in the body
+	}{
+		{"GET", "/", http.StatusOK, "<title>ZeroGo Admin</title>"},
+		{"GET", "/app.js", http.StatusOK, "/api/v1/"},
+		{"GET", "/networks/3", http.StatusOK, "<title>ZeroGo Admin</title>"}, // client-side route
+		{"POST", "/", http.StatusMethodNotAllowed, protocol.ErrCodeMethodNotAllowed},
+		// API routes still resolve to the API
+		{"GET", "/api/v1/networks", http.StatusUnauthorized, ""},
+		{"GET", "/api/v1/nope", http.StatusNotFound, protocol.ErrCodeNotFound},
+		{"GET", "/healthz", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		w := request(t, ctrl, tt.method, tt.path, "", nil)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("%s %s: HTTP %d %.80q, want %d with %q", tt.method, tt.path, w.Code, w.Body, tt.code, tt.want)
+		}
+		if strings.HasPrefix(tt.path, "/api/") && strings.Contains(w.Body.String(), "<html") {
+			t.Errorf("%s %s served the UI", tt.method, tt.path)
+		}
+	}
+
+	// Logging in through the API the UI uses works alongside it
+	w := request(t, ctrl, "POST", "/api/v1/auth/login", "", protocol.LoginRequest{Username: "admin", Password: testAdminPassword})
+	var login protocol.LoginResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &login) != nil || login.Token == "" {
+		t.Fatalf("login: HTTP %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestWebUIDisabled(t *testing.T) {
+	t.Setenv("ZEROGO_WEB_PATH", "")
+	ctrl := newTestController(t)
+	w := request(t, ctrl, "GET", "/", "", nil)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), protocol.ErrCodeUIDisabled) {
+		t.Fatalf("GET / with the UI disabled: HTTP %d: %s", w.Code, w.Body)
+	}
+}
@@ -1,22 +1,64 @@
 package controller
 
 import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
 	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+	"github.com/unicornultrafoundation/zerogo/internal/relay"
+	"gorm.io/gorm"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  4096,
-	WriteBufferSize: 4096,
-	CheckOrigin:     func(r *http.Request) bool { return true }, // Allow all origins in MVP
+// punchLeadTime gives both agents time to receive a punch message before
+// they start sending.
+const punchLeadTime = 2 * time.Second
+
+// isolatedAfter is how long an agent with no connected peers may go
+// without any peer traffic before it is reported as isolated.
+const isolatedAfter = 2 * time.Minute
+
+// endpointTTL is how long an offline node's last reported endpoints are
+// still handed out to peers.
+const endpointTTL = 10 * time.Minute
+
+// newAgentUpgrader returns the upgrader for agent WebSocket connections.
+// Agents are authenticated by their headers, not cookies, but a browser
+// could still be tricked into opening the endpoint, so only requests
+// without an Origin header (non-browser clients) or from allowedOrigins
+// are upgraded.
+func newAgentUpgrader(allowedOrigins []string) *websocket.Upgrader {
+	wildcard := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			wildcard = true
+			continue
+		}
+		allowed[strings.TrimSuffix(o, "/")] = true
+	}
+	return &websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || wildcard || allowed[origin]
+		},
+	}
 }
 
 // AgentConn represents a connected agent.
@@ -24,11 +66,51 @@ type AgentConn struct {
 	NodeAddr  string
 	PublicKey string
 	Platform  string
-	Endpoints []string
 	Networks  []string
+	RemoteIP  string // source IP of the WebSocket connection as seen by the controller
 	Conn      *websocket.Conn
 	LastSeen  time.Time
 	mu        sync.Mutex
+
+	// signingKey is the node's registered Ed25519 key; once known, join and
+	// status messages are only accepted in a valid signed envelope.
+	signingKey ed25519.PublicKey
+	lastSeq    uint64
+
+	// challenge is the key sent in X-Key-Challenge, against which the node
+	// proves it owns the signing key it registers
+	challenge *identity.Identity
+
+	// dataPlane summarizes the agent's last status report; nil until the
+	// first one arrives.
+	dataPlane atomic.Pointer[AgentPresence]
+
+	// endpoints are the UDP endpoints the agent last reported. Handlers for
+	// other agents read them, so a report replaces the slice, never changes it.
+	endpoints atomic.Pointer[[]string]
+}
+
+// Endpoints returns the UDP endpoints the agent last reported.
+func (ac *AgentConn) Endpoints() []string {
+	if eps := ac.endpoints.Load(); eps != nil {
+		return *eps
+	}
+	return nil
+}
+
+// AgentPresence is a connected agent's data-plane state as of its last
+// status report.
+type AgentPresence struct {
+	// Status is PresenceConnected, or PresenceIsolated while the agent has
+	// no connected peers and no recent peer traffic.
+	Status    string
+	LatencyMs int64  // mean latency to connected peers
+	Path      string // "direct" if any peer is reached directly, else "relay"
+
+	// DeviceError is why the agent has no network device, if it reported one
+	DeviceError string
+
+	peers []protocol.PeerStatus // the connected peers reported
 }
 
 // SendJSON sends a JSON message to the agent.
@@ -39,12 +121,23 @@ func (ac *AgentConn) SendJSON(v interface{}) error {
 	return ac.Conn.WriteJSON(v)
 }
 
+// Close sends a close frame with code and reason and then closes the
+// connection, so the agent can tell why it was dropped.
+func (ac *AgentConn) Close(code int, reason string) {
+	ac.mu.Lock()
+	ac.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	ac.mu.Unlock()
+	ac.Conn.Close()
+}
+
 // WSHandler manages WebSocket connections from agents.
 type WSHandler struct {
 	agents map[string]*AgentConn // nodeAddr → connection
 	mu     sync.RWMutex
 	ctrl   *Controller
 	log    *slog.Logger
+
+	upgrader *websocket.Upgrader
 }
 
 // NewWSHandler creates a new WebSocket handler.
@@ -53,6 +146,8 @@ func NewWSHandler(ctrl *Controller, log *slog.Logger) *WSHandler {
 		agents: make(map[string]*AgentConn),
 		ctrl:   ctrl,
 		log:    log.With("component", "ws"),
+
+		upgrader: newAgentUpgrader(ctrl.config.AgentOrigins),
 	}
 }
 
@@ -60,60 +155,126 @@ func NewWSHandler(ctrl *Controller, log *slog.Logger) *WSHandler {
 func (h *WSHandler) HandleAgentConnect(c *gin.Context) {
 	nodeAddr := c.GetHeader("X-Node-Address")
 	publicKey := c.GetHeader("X-Public-Key")
+	log := h.ctrl.requestLog(c)
+
+	if err := validPublicKeyHex(publicKey); err != nil {
+		abortError(c, http.StatusBadRequest, protocol.ErrCodeInvalidRequest, "invalid X-Public-Key: "+err.Error())
+		return
+	}
+	if err := checkNodeKey(h.ctrl.db, nodeAddr, publicKey); err != nil {
+		status, code := http.StatusBadRequest, protocol.ErrCodeInvalidRequest
+		if errors.Is(err, errAddressCollision) {
+			status, code = http.StatusConflict, protocol.ErrCodeAddressTaken
+			log.Warn("address collision", "addr", nodeAddr, "remote", c.Request.RemoteAddr, "long_address", longAddressHex(publicKey))
+		}
+		abortError(c, status, code, err.Error())
+		return
+	}
+	if err := h.ctrl.checkAgentCert(c.Request, nodeAddr); err != nil {
+		log.Warn("agent certificate rejected", "addr", nodeAddr, "remote", c.Request.RemoteAddr, "err", err)
+		abortError(c, http.StatusUnauthorized, protocol.ErrCodeUnauthorized, err.Error())
+		return
+	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	challenge, err := identity.Generate()
 	if err != nil {
-		h.log.Error("websocket upgrade failed", "err", err)
+		abortError(c, http.StatusInternalServerError, protocol.ErrCodeInternal, "generate key challenge failed")
+		return
+	}
+	respHeader := http.Header{}
+	respHeader.Set("X-Key-Challenge", challenge.PublicKeyHex())
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, respHeader)
+	if err != nil {
+		log.Error("websocket upgrade failed", "origin", c.GetHeader("Origin"), "err", err)
 		return
 	}
 
 	agentConn := &AgentConn{
 		NodeAddr:  nodeAddr,
 		PublicKey: publicKey,
+		RemoteIP:  c.ClientIP(),
 		Conn:      conn,
 		LastSeen:  time.Now(),
+		challenge: challenge,
+	}
+	var registered Node
+	if err := h.ctrl.db.Select("signing_key").First(&registered, "address = ?", nodeAddr).Error; err == nil && registered.SigningKey != "" {
+		if key, err := hex.DecodeString(registered.SigningKey); err == nil && len(key) == ed25519.PublicKeySize {
+			agentConn.signingKey = key
+		}
 	}
 
 	h.mu.Lock()
 	// Close existing connection from same node
 	if old, exists := h.agents[nodeAddr]; exists {
-		old.Conn.Close()
+		old.Close(protocol.CloseReplaced, "replaced by newer connection")
 	}
 	h.agents[nodeAddr] = agentConn
 	h.mu.Unlock()
 
-	h.log.Info("agent connected", "addr", nodeAddr, "remote", c.Request.RemoteAddr)
+	log.Info("agent connected", "addr", nodeAddr, "remote", c.Request.RemoteAddr)
+	h.ctrl.events.Publish(protocol.Event{Type: protocol.EventNodeOnline, NodeAddress: nodeAddr})
+
+	done := make(chan struct{})
+	if ttl := h.turnCredentialTTL(); ttl > 0 {
+		go h.refreshRelayCredentials(agentConn, ttl, done)
+	}
 
 	// Read loop
 	defer func() {
+		close(done)
 		h.mu.Lock()
-		delete(h.agents, nodeAddr)
+		// Only remove our own entry; a reconnect may already have replaced it
+		replaced := h.agents[nodeAddr] != agentConn
+		if !replaced {
+			delete(h.agents, nodeAddr)
+		}
 		h.mu.Unlock()
 		conn.Close()
-		h.log.Info("agent disconnected", "addr", nodeAddr)
+		log.Info("agent disconnected", "addr", nodeAddr)
+		if !replaced {
+			h.ctrl.events.Publish(protocol.Event{Type: protocol.EventNodeOffline, NodeAddress: nodeAddr})
+		}
 	}()
 
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				h.log.Debug("agent websocket error", "addr", nodeAddr, "err", err)
+				log.Debug("agent websocket error", "addr", nodeAddr, "err", err)
 			}
 			return
 		}
 
 		agentConn.LastSeen = time.Now()
-		h.handleMessage(agentConn, message)
+		h.handleMessage(agentConn, message, false)
 	}
 }
 
-func (h *WSHandler) handleMessage(agent *AgentConn, message []byte) {
+// handleMessage dispatches an agent message; signed is set for the payload
+// of a verified signed envelope.
+func (h *WSHandler) handleMessage(agent *AgentConn, message []byte, signed bool) {
 	var baseMsg protocol.Message
 	if err := json.Unmarshal(message, &baseMsg); err != nil {
 		h.log.Debug("unmarshal agent message", "err", err)
 		return
 	}
 
+	switch baseMsg.Type {
+	case protocol.MsgTypeJoin, protocol.MsgTypeStatus:
+		if !signed && agent.signingKey != nil {
+			h.rejectAgent(agent, "unsigned "+string(baseMsg.Type)+" from a node with a registered signing key")
+			return
+		}
+	case protocol.MsgTypeSigned:
+		if signed {
+			return // no nested envelopes
+		}
+		h.handleSigned(agent, message)
+		return
+	}
+
 	switch baseMsg.Type {
 	case protocol.MsgTypeJoin:
 		var msg protocol.JoinMessage
@@ -136,30 +297,235 @@ func (h *WSHandler) handleMessage(agent *AgentConn, message []byte) {
 		}
 		h.handleLeave(agent, &msg)
 
+	case protocol.MsgTypeRelayOffer:
+		var msg protocol.RelayOfferMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			return
+		}
+		h.handleRelayOffer(agent, &msg)
+
 	default:
 		h.log.Debug("unknown message type from agent", "type", baseMsg.Type, "addr", agent.NodeAddr)
 	}
 }
 
+// handleSigned verifies a signed envelope and dispatches its payload. The
+// first signed join of a node without a registered key is verified against
+// the key it carries, which handleJoin then registers. The join must prove,
+// with a KeyProof for the connection's challenge, that the node's identity
+// key vouches for it, so whoever connects with a node's public key cannot
+// register a signing key of their own.
+func (h *WSHandler) handleSigned(agent *AgentConn, message []byte) {
+	var env protocol.SignedMessage
+	if err := json.Unmarshal(message, &env); err != nil {
+		h.log.Debug("unmarshal signed message", "err", err)
+		return
+	}
+	if env.NodeAddr != agent.NodeAddr {
+		h.rejectAgent(agent, "signed message for another node address")
+		return
+	}
+	if env.Seq <= agent.lastSeq {
+		h.rejectAgent(agent, "replayed signed message")
+		return
+	}
+
+	key := agent.signingKey
+	if key == nil {
+		var join protocol.JoinMessage
+		if err := json.Unmarshal(env.Payload, &join); err != nil || join.Type != protocol.MsgTypeJoin {
+			h.rejectAgent(agent, "signed message before the signing key is known")
+			return
+		}
+		k, err := hex.DecodeString(join.SigningKey)
+		if err != nil || len(k) != ed25519.PublicKeySize {
+			h.rejectAgent(agent, "invalid signing key")
+			return
+		}
+		if !h.signingKeyProven(agent, &join) {
+			h.rejectAgent(agent, "signing key not proven by the identity key")
+			return
+		}
+		key = k
+	}
+
+	sig, err := hex.DecodeString(env.Signature)
+	if err != nil || !ed25519.Verify(key, protocol.SignedData(env.NodeAddr, env.Seq, env.Payload), sig) {
+		h.rejectAgent(agent, "invalid message signature")
+		return
+	}
+	agent.signingKey = key
+	agent.lastSeq = env.Seq
+
+	h.handleMessage(agent, env.Payload, true)
+}
+
+// signingKeyProven reports whether join carries a valid SigningKeyProof
+// from the identity key the agent connected with.
+func (h *WSHandler) signingKeyProven(agent *AgentConn, join *protocol.JoinMessage) bool {
+	if agent.challenge == nil || join.PublicKey != agent.PublicKey {
+		return false
+	}
+	pub, err := hex.DecodeString(agent.PublicKey)
+	if err != nil {
+		return false
+	}
+	proof, err := hex.DecodeString(join.SigningKeyProof)
+	if err != nil {
+		return false
+	}
+	want, err := agent.challenge.KeyProof(pub, protocol.SigningKeyProofData(join.NodeAddr, join.SigningKey))
+	return err == nil && subtle.ConstantTimeCompare(proof, want) == 1
+}
+
+// rejectAgent reports a protocol violation to the agent and drops its
+// connection.
+func (h *WSHandler) rejectAgent(agent *AgentConn, reason string) {
+	h.log.Warn("rejecting agent message", "addr", agent.NodeAddr, "remote", agent.RemoteIP, "reason", reason)
+	agent.SendJSON(protocol.ErrorMessage{
+		Type:    protocol.MsgTypeError,
+		Code:    401,
+		Message: reason,
+	})
+	agent.Close(protocol.CloseUnauthorized, "unauthorized")
+}
+
+// CloseAll disconnects every agent with a going-away close frame. Used on
+// controller shutdown.
+func (h *WSHandler) CloseAll() {
+	h.mu.RLock()
+	agents := make([]*AgentConn, 0, len(h.agents))
+	for _, a := range h.agents {
+		agents = append(agents, a)
+	}
+	h.mu.RUnlock()
+
+	for _, a := range agents {
+		a.Close(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// validPublicKeyHex checks a hex-encoded Curve25519 public key sent by an
+// agent, rejecting low-order keys that would give peers a predictable
+// shared secret.
+func validPublicKeyHex(s string) error {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return errors.New("not hex")
+	}
+	return identity.ValidatePublicKey(b)
+}
+
+var errAddressCollision = errors.New("address is already registered to a different public key")
+
+// checkNodeKey checks that address is derived from publicKey and, if a node
+// is already registered at address, that it was registered with the same
+// key. Since addresses are only 40 bits, two keys can share one; the later
+// key is refused rather than taking over the registered node.
+func checkNodeKey(db *gorm.DB, address, publicKey string) error {
+	addr, err := identity.AddressFromHex(address)
+	if err != nil {
+		return err
+	}
+	pub, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return err
+	}
+	if err := identity.VerifyAddress(addr, pub); err != nil {
+		return err
+	}
+	var node Node
+	if err := db.Select("public_key").Limit(1).Find(&node, "address = ?", address).Error; err != nil {
+		return err
+	}
+	if node.PublicKey != "" && !strings.EqualFold(node.PublicKey, publicKey) {
+		return errAddressCollision
+	}
+	return nil
+}
+
+// longAddressHex returns the 80-bit address of a hex public key, or "" if
+// the key does not decode.
+func longAddressHex(publicKey string) string {
+	pub, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return ""
+	}
+	return identity.LongAddressFromPublicKey(pub).String()
+}
+
 func (h *WSHandler) handleJoin(agent *AgentConn, msg *protocol.JoinMessage) {
+	if msg.NodeAddr != agent.NodeAddr {
+		h.rejectAgent(agent, "join for another node address")
+		return
+	}
+	if err := validPublicKeyHex(msg.PublicKey); err != nil {
+		h.rejectAgent(agent, "invalid public key: "+err.Error())
+		return
+	}
+	if err := checkNodeKey(h.ctrl.db, msg.NodeAddr, msg.PublicKey); err != nil {
+		if errors.Is(err, errAddressCollision) {
+			h.log.Warn("address collision", "addr", msg.NodeAddr, "long_address", longAddressHex(msg.PublicKey))
+		}
+		h.rejectAgent(agent, err.Error())
+		return
+	}
+
+	protoVersion := msg.ProtocolVersion
+	if protoVersion == 0 {
+		protoVersion = 1
+	}
+	if protoVersion < protocol.MinProtocolVersion {
+		h.log.Warn("rejecting agent with an old protocol version", "addr", msg.NodeAddr, "version", msg.Version, "protocol_version", protoVersion)
+		agent.SendJSON(protocol.ErrorMessage{
+			Type:    protocol.MsgTypeError,
+			Code:    426,
+			Message: fmt.Sprintf("agent protocol version %d is too old, this controller needs %d or newer", protoVersion, protocol.MinProtocolVersion),
+		})
+		agent.Close(protocol.CloseIncompatible, "incompatible protocol version")
+		return
+	}
+
 	h.log.Info("agent join request",
 		"addr", msg.NodeAddr,
 		"networks", msg.Networks,
 		"platform", msg.Platform,
+		"version", msg.Version,
+		"protocol_version", protoVersion,
 	)
 
 	agent.Platform = msg.Platform
-	agent.Endpoints = msg.Endpoints
+	agent.endpoints.Store(&msg.Endpoints)
 	agent.Networks = msg.Networks
 
 	// Register/update node in database
+	now := time.Now()
 	node := Node{
-		Address:   msg.NodeAddr,
-		PublicKey: msg.PublicKey,
-		Platform:  msg.Platform,
-		LastSeen:  time.Now(),
+		Address:     msg.NodeAddr,
+		PublicKey:   msg.PublicKey,
+		Platform:    msg.Platform,
+		Endpoints:   msg.Endpoints,
+		EndpointsAt: now,
+		LastSeen:    now,
+
+		Version:         msg.Version,
+		ProtocolVersion: protoVersion,
 	}
-	h.ctrl.db.Where("address = ?", msg.NodeAddr).Assign(node).FirstOrCreate(&node)
+	if agent.signingKey != nil {
+		node.SigningKey = hex.EncodeToString(agent.signingKey)
+	}
+	if h.ctrl.config.LongAddresses {
+		node.LongAddress = longAddressHex(msg.PublicKey)
+	}
+	h.ctrl.db.Where("address = ?", msg.NodeAddr).
+		Attrs(Node{Name: msg.Hostname}).
+		Assign(node).FirstOrCreate(&node)
+
+	agent.SendJSON(protocol.WelcomeMessage{
+		Type:            protocol.MsgTypeWelcome,
+		ProtocolVersion: protocol.ProtocolVersion,
+		Time:            time.Now(),
+	})
 
 	// For each requested network, send config if authorized
 	for _, netID := range msg.Networks {
@@ -168,8 +534,34 @@ func (h *WSHandler) handleJoin(agent *AgentConn, msg *protocol.JoinMessage) {
 }
 
 func (h *WSHandler) handleStatus(agent *AgentConn, msg *protocol.StatusMessage) {
-	// Update last seen
-	h.ctrl.db.Model(&Node{}).Where("address = ?", agent.NodeAddr).Update("last_seen", time.Now())
+	// Update last seen and keep the reported endpoints fresh. A struct update
+	// (zero fields skipped) so the endpoints go through their JSON serializer.
+	now := time.Now()
+	if len(msg.Endpoints) > 0 {
+		agent.endpoints.Store(&msg.Endpoints)
+	}
+	h.ctrl.db.Model(&Node{Address: agent.NodeAddr}).Updates(Node{
+		Endpoints:   msg.Endpoints,
+		EndpointsAt: now,
+		LastSeen:    now,
+	})
+
+	presence := summarizePeers(msg, now)
+	prev := agent.dataPlane.Swap(presence)
+	if prev == nil || prev.Status != presence.Status {
+		h.log.Info("agent data plane changed", "addr", agent.NodeAddr, "status", presence.Status)
+	}
+	if prev == nil || prev.DeviceError != presence.DeviceError {
+		if presence.DeviceError != "" {
+			h.log.Warn("agent cannot create its network device", "addr", agent.NodeAddr, "err", presence.DeviceError)
+		} else if prev != nil {
+			h.log.Info("agent network device created", "addr", agent.NodeAddr)
+		}
+	}
+
+	if err := h.ctrl.recordUsage(agent.NodeAddr, msg.Peers, now); err != nil {
+		h.log.Warn("record usage", "addr", agent.NodeAddr, "err", err)
+	}
 }
 
 func (h *WSHandler) handleLeave(agent *AgentConn, msg *protocol.LeaveMessage) {
@@ -185,42 +577,153 @@ func (h *WSHandler) handleLeave(agent *AgentConn, msg *protocol.LeaveMessage) {
 	}
 }
 
+// handleRelayOffer forwards a relay offer to the target agent if both nodes
+// are authorized members of a common network.
+func (h *WSHandler) handleRelayOffer(agent *AgentConn, msg *protocol.RelayOfferMessage) {
+	h.mu.RLock()
+	target, ok := h.agents[msg.To]
+	h.mu.RUnlock()
+	if !ok {
+		h.log.Debug("relay offer target offline", "from", agent.NodeAddr, "to", msg.To)
+		return
+	}
+
+	var shared int64
+	h.ctrl.db.Model(&Member{}).Scopes(inLiveNetwork).
+		Where("node_address = ? AND authorized = ?", msg.To, true).
+		Where("network_id IN (?)", h.ctrl.db.Model(&Member{}).Select("network_id").
+			Where("node_address = ? AND authorized = ?", agent.NodeAddr, true)).
+		Count(&shared)
+	if shared == 0 {
+		h.log.Warn("relay offer between nodes without a shared network", "from", agent.NodeAddr, "to", msg.To)
+		return
+	}
+
+	msg.From = agent.NodeAddr
+	target.SendJSON(msg)
+}
+
+// refreshRelayCredentials sends the agent new ephemeral TURN credentials
+// halfway through the lifetime of the previous ones, until done is closed.
+func (h *WSHandler) refreshRelayCredentials(agent *AgentConn, ttl time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			agent.SendJSON(protocol.RelayUpdateMessage{
+				Type:   protocol.MsgTypeRelayUpdate,
+				Relays: h.relayInfos(agent.NodeAddr),
+			})
+		}
+	}
+}
+
+// defaultTURNCredentialTTL is how long ephemeral TURN credentials last
+// when turn.credential_ttl is not set.
+const defaultTURNCredentialTTL = 24 * time.Hour
+
+// turnCredentialTTL is the lifetime of ephemeral TURN credentials, or 0 if
+// the static credentials are advertised.
+func (h *WSHandler) turnCredentialTTL() time.Duration {
+	turnCfg := h.ctrl.config.TURN
+	if turnCfg.Secret == "" {
+		return 0
+	}
+	if turnCfg.CredentialTTL > 0 {
+		return time.Duration(turnCfg.CredentialTTL) * time.Second
+	}
+	return defaultTURNCredentialTTL
+}
+
+// relayInfos returns the TURN servers advertised to the agent at nodeAddr,
+// with an ephemeral credential of its own if a TURN secret is configured.
+func (h *WSHandler) relayInfos(nodeAddr string) []protocol.RelayInfo {
+	turnCfg := h.ctrl.config.TURN
+	if turnCfg.Advertise == "" {
+		return nil
+	}
+	if ttl := h.turnCredentialTTL(); ttl > 0 {
+		username, password := relay.EphemeralCredential(turnCfg.Secret, nodeAddr, time.Now().Add(ttl))
+		return []protocol.RelayInfo{{URL: turnCfg.Advertise, Username: username, Password: password}}
+	}
+	if len(turnCfg.Credentials) == 0 {
+		return nil
+	}
+	users := make([]string, 0, len(turnCfg.Credentials))
+	for u := range turnCfg.Credentials {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return []protocol.RelayInfo{{
+		URL:      turnCfg.Advertise,
+		Username: users[0],
+		Password: turnCfg.Credentials[users[0]],
+	}}
+}
+
+// errNotAuthorized is returned by networkConfig for a node that is not an
+// authorized member of the network.
+var errNotAuthorized = errors.New("not authorized for this network")
+
+// errPrivateNetwork is returned by networkConfig for a node that is not a
+// member of a private network.
+var errPrivateNetwork = errors.New("network is private: an admin must add this node")
+
 func (h *WSHandler) sendNetworkConfig(agent *AgentConn, networkID string) {
-	var network Network
-	if err := h.ctrl.db.First(&network, "id = ?", networkID).Error; err != nil {
+	config, err := h.networkConfig(networkID, agent.NodeAddr)
+	if err != nil {
+		code := 403
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			code, err = 404, errors.New("network not found")
+		}
 		agent.SendJSON(protocol.ErrorMessage{
 			Type:    protocol.MsgTypeError,
-			Code:    404,
-			Message: "network not found",
+			Code:    code,
+			Message: err.Error(),
 		})
 		return
 	}
+	agent.SendJSON(config)
+}
+
+// networkConfig assembles the network config for the node at nodeAddr. A
+// node that is not yet a member gets a pending membership and, like any
+// unauthorized member, errNotAuthorized, unless the network is private,
+// which gives errPrivateNetwork and records nothing; an unknown or deleted
+// network gives gorm.ErrRecordNotFound, whatever members it kept.
+func (h *WSHandler) networkConfig(networkID, nodeAddr string) (*protocol.NetworkConfigMessage, error) {
+	var network Network
+	if err := h.ctrl.db.First(&network, "id = ?", networkID).Error; err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
 
 	// Check membership
 	var member Member
-	if err := h.ctrl.db.First(&member, "network_id = ? AND node_address = ?", networkID, agent.NodeAddr).Error; err != nil {
+	if err := h.ctrl.db.Scopes(inLiveNetwork).First(&member, "network_id = ? AND node_address = ?", networkID, nodeAddr).Error; err != nil {
+		if network.Private {
+			h.log.Info("non-member refused from private network", "network", networkID, "node", nodeAddr)
+			return nil, errPrivateNetwork
+		}
 		// Auto-create pending membership
 		member = Member{
 			NetworkID:   network.ID,
-			NodeAddress: agent.NodeAddr,
+			NodeAddress: nodeAddr,
 			Authorized:  false,
 		}
 		h.ctrl.db.Create(&member)
-		h.log.Info("new member pending authorization", "network", networkID, "node", agent.NodeAddr)
+		h.log.Info("new member pending authorization", "network", networkID, "node", nodeAddr)
 	}
 
 	if !member.Authorized {
-		agent.SendJSON(protocol.ErrorMessage{
-			Type:    protocol.MsgTypeError,
-			Code:    403,
-			Message: "not authorized for this network",
-		})
-		return
+		return nil, errNotAuthorized
 	}
 
 	// Gather peer list
 	var members []Member
-	h.ctrl.db.Where("network_id = ? AND node_address != ? AND authorized = ?", networkID, agent.NodeAddr, true).Find(&members)
+	h.ctrl.db.Scopes(inLiveNetwork).Where("network_id = ? AND node_address != ? AND authorized = ?", networkID, nodeAddr, true).Find(&members)
 
 	peers := make([]protocol.PeerInfo, 0, len(members))
 	for _, m := range members {
@@ -228,25 +731,34 @@ func (h *WSHandler) sendNetworkConfig(agent *AgentConn, networkID string) {
 		if err := h.ctrl.db.First(&node, "address = ?", m.NodeAddress).Error; err != nil {
 			continue
 		}
-		// Get endpoints from connected agent
-		h.mu.RLock()
-		peerConn, online := h.agents[m.NodeAddress]
-		h.mu.RUnlock()
-
-		var endpoints []string
-		if online {
-			endpoints = peerConn.Endpoints
-		}
-
+		endpoints, stale := h.nodeEndpoints(&node)
 		peers = append(peers, protocol.PeerInfo{
 			Address:   m.NodeAddress,
 			PublicKey: node.PublicKey,
 			Endpoints: endpoints,
 			Name:      m.Name,
+			Stale:     stale,
+			IP:        m.IPAddress,
+			PSK:       pairPSK(member.PSK, m.PSK),
+
+			IP6: nodeIP6(network.IP6Range, m.NodeAddress),
+			MAC: memberMAC(network.ID, m.NodeAddress),
 		})
 	}
 
-	agent.SendJSON(protocol.NetworkConfigMessage{
+	// Full-tunnel agents route everything via the network's gateway member
+	var defaultGateway string
+	var gateway Member
+	h.ctrl.db.Where("network_id = ? AND gateway = ? AND authorized = ?", networkID, true, true).Limit(1).Find(&gateway)
+	if gateway.NodeAddress != "" && gateway.NodeAddress != nodeAddr {
+		if ip, err := parseHostIP(gateway.IPAddress); err == nil {
+			defaultGateway = ip.String()
+		}
+	}
+
+	ruleInfos := h.ctrl.ruleInfos(network.ID)
+
+	return &protocol.NetworkConfigMessage{
 		Type:       protocol.MsgTypeNetworkConfig,
 		NetworkID:  networkID,
 		Name:       network.Name,
@@ -256,8 +768,41 @@ func (h *WSHandler) sendNetworkConfig(agent *AgentConn, networkID string) {
 		Multicast:  network.Multicast,
 		PSK:        network.PSK,
 		AssignedIP: member.IPAddress,
+		GatewayIP:  network.GatewayIP,
 		Peers:      peers,
-	})
+		Relays:     h.relayInfos(nodeAddr),
+		Rules:      ruleInfos,
+
+		AssignedIP6: nodeIP6(network.IP6Range, nodeAddr),
+
+		SourceValidation: network.SourceValidation,
+
+		Tables: network.tableLimits(),
+
+		DefaultGateway: defaultGateway,
+		IsGateway:      gateway.NodeAddress == nodeAddr,
+
+		ReservedRanges: network.ReservedRanges,
+
+		DNSServers:    network.DNSServers,
+		SearchDomains: network.SearchDomains,
+	}, nil
+}
+
+// nodeEndpoints returns a node's endpoints: live ones from its connection, or
+// the last reported ones (marked stale) if it went offline within endpointTTL.
+func (h *WSHandler) nodeEndpoints(node *Node) ([]string, bool) {
+	h.mu.RLock()
+	peerConn, online := h.agents[node.Address]
+	h.mu.RUnlock()
+
+	if online {
+		return peerConn.Endpoints(), false
+	}
+	if len(node.Endpoints) > 0 && time.Since(node.EndpointsAt) < endpointTTL {
+		return node.Endpoints, true
+	}
+	return nil, false
 }
 
 // SendNetworkConfigToAgent sends the full network config to a specific online agent.
@@ -271,20 +816,77 @@ func (h *WSHandler) SendNetworkConfigToAgent(nodeAddr string, networkID string)
 	h.sendNetworkConfig(agent, networkID)
 }
 
+// SendNetworkConfigToNetwork re-sends the network config to every online
+// agent in the network, for changes that affect all members.
+func (h *WSHandler) SendNetworkConfigToNetwork(networkID uint32) {
+	id := fmt.Sprintf("%d", networkID)
+	h.mu.RLock()
+	var agents []*AgentConn
+	for _, agent := range h.agents {
+		for _, netID := range agent.Networks {
+			if netID == id {
+				agents = append(agents, agent)
+				break
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, agent := range agents {
+		h.sendNetworkConfig(agent, id)
+	}
+}
+
+// SendNetworkDeleted tells the connected members of a network that it was
+// deleted, so they drop its peers.
+func (h *WSHandler) SendNetworkDeleted(networkID uint32) {
+	msg := protocol.NetworkDeletedMessage{
+		Type:      protocol.MsgTypeNetworkDeleted,
+		NetworkID: fmt.Sprintf("%d", networkID),
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, agent := range h.agents {
+		for _, netID := range agent.Networks {
+			if netID == msg.NetworkID {
+				agent.SendJSON(msg)
+				break
+			}
+		}
+	}
+}
+
 // BroadcastPeerUpdate notifies all agents in a network about a peer change.
+// Added peers carry the pair PSK each recipient shares with them, and the
+// addresses derived from the network and their node address.
 func (h *WSHandler) BroadcastPeerUpdate(networkID uint32, action string, peer protocol.PeerInfo) {
-	msg := protocol.PeerUpdateMessage{
-		Type:   protocol.MsgTypePeerUpdate,
-		Action: action,
-		Peer:   peer,
+	var pairs map[string]string
+	if action == "add" {
+		pairs = h.ctrl.pairPSKs(networkID, peer.Address)
+		var network Network
+		if h.ctrl.db.Select("ip6_range").Limit(1).Find(&network, networkID).Error == nil {
+			peer.IP6 = nodeIP6(network.IP6Range, peer.Address)
+		}
+		peer.MAC = memberMAC(networkID, peer.Address)
 	}
 
+	id := fmt.Sprintf("%d", networkID)
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	for _, agent := range h.agents {
 		for _, netID := range agent.Networks {
-			if netID == fmt.Sprintf("%d", networkID) {
+			if netID == id {
+				msg := protocol.PeerUpdateMessage{
+					Type:      protocol.MsgTypePeerUpdate,
+					Action:    action,
+					Peer:      peer,
+					NetworkID: id,
+				}
+				msg.Peer.PSK = pairs[agent.NodeAddr]
 				agent.SendJSON(msg)
 				break
 			}
@@ -292,6 +894,95 @@ func (h *WSHandler) BroadcastPeerUpdate(networkID uint32, action string, peer pr
 	}
 }
 
+// CoordinatePunch asks nodeAddr and every other online authorized member of the
+// network to send hellos to each other at the same moment, so peers behind
+// symmetric NATs open matching mappings.
+func (h *WSHandler) CoordinatePunch(networkID uint32, nodeAddr string) {
+	h.mu.RLock()
+	agent, ok := h.agents[nodeAddr]
+	h.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	var members []Member
+	h.ctrl.db.Where("network_id = ? AND node_address != ? AND authorized = ?", networkID, nodeAddr, true).Find(&members)
+	var self Member
+	h.ctrl.db.Select("psk").Where("network_id = ? AND node_address = ?", networkID, nodeAddr).Limit(1).Find(&self)
+
+	netID := fmt.Sprintf("%d", networkID)
+	at := time.Now().Add(punchLeadTime)
+	for _, m := range members {
+		h.mu.RLock()
+		peer, online := h.agents[m.NodeAddress]
+		h.mu.RUnlock()
+		if !online {
+			continue
+		}
+
+		psk := pairPSK(self.PSK, m.PSK)
+		peerInfo, agentInfo := peer.punchInfo(), agent.punchInfo()
+		peerInfo.PSK, agentInfo.PSK = psk, psk
+		agent.SendJSON(protocol.PunchMessage{
+			Type:      protocol.MsgTypePunch,
+			NetworkID: netID,
+			Peer:      peerInfo,
+			At:        at,
+		})
+		peer.SendJSON(protocol.PunchMessage{
+			Type:      protocol.MsgTypePunch,
+			NetworkID: netID,
+			Peer:      agentInfo,
+			At:        at,
+		})
+		h.log.Debug("hole punch coordinated", "network", netID, "a", nodeAddr, "b", m.NodeAddress, "at", at)
+	}
+}
+
+// punchInfo returns the agent's peer info with endpoints suitable for punching:
+// the reported endpoints plus the controller-observed IP on each reported port.
+func (ac *AgentConn) punchInfo() protocol.PeerInfo {
+	reported := ac.Endpoints()
+	endpoints := make([]string, 0, len(reported)*2)
+	seen := make(map[string]bool)
+	add := func(ep string) {
+		if !seen[ep] {
+			seen[ep] = true
+			endpoints = append(endpoints, ep)
+		}
+	}
+	for _, ep := range reported {
+		host, port, err := net.SplitHostPort(ep)
+		if err != nil {
+			continue
+		}
+		if host != "" {
+			add(ep)
+		}
+		if ac.RemoteIP != "" {
+			add(net.JoinHostPort(ac.RemoteIP, port))
+		}
+	}
+	return protocol.PeerInfo{
+		Address:   ac.NodeAddr,
+		PublicKey: ac.PublicKey,
+		Endpoints: endpoints,
+	}
+}
+
+// Disconnect closes the connection of an agent, if it is online, and
+// reports whether it was. The agent leaves the online map once its read
+// loop sees the connection close.
+func (h *WSHandler) Disconnect(nodeAddr string, code int, reason string) bool {
+	h.mu.RLock()
+	agent := h.agents[nodeAddr]
+	h.mu.RUnlock()
+	if agent != nil {
+		agent.Close(code, reason)
+	}
+	return agent != nil
+}
+
 // GetOnlineAgents returns connected agent addresses.
 func (h *WSHandler) GetOnlineAgents() map[string]bool {
 	h.mu.RLock()
@@ -303,3 +994,46 @@ func (h *WSHandler) GetOnlineAgents() map[string]bool {
 	return online
 }
 
+// GetAgentPresence returns the presence of each connected agent. Agents
+// that have not reported status yet are taken as connected; agents not in
+// the map are offline.
+func (h *WSHandler) GetAgentPresence() map[string]AgentPresence {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	presence := make(map[string]AgentPresence, len(h.agents))
+	for addr, agent := range h.agents {
+		if p := agent.dataPlane.Load(); p != nil {
+			presence[addr] = *p
+		} else {
+			presence[addr] = AgentPresence{Status: protocol.PresenceConnected}
+		}
+	}
+	return presence
+}
+
+// summarizePeers condenses a status report into the agent's presence.
+func summarizePeers(msg *protocol.StatusMessage, now time.Time) *AgentPresence {
+	if len(msg.Peers) == 0 {
+		status := protocol.PresenceConnected
+		if now.Sub(msg.LastFrameAt) > isolatedAfter {
+			status = protocol.PresenceIsolated
+		}
+		return &AgentPresence{Status: status, DeviceError: msg.DeviceError}
+	}
+
+	p := &AgentPresence{Status: protocol.PresenceConnected, Path: "relay", DeviceError: msg.DeviceError, peers: msg.Peers}
+	var total, n int64
+	for _, peer := range msg.Peers {
+		if peer.Path == "direct" {
+			p.Path = "direct"
+		}
+		if peer.LatencyMs > 0 {
+			total += peer.LatencyMs
+			n++
+		}
+	}
+	if n > 0 {
+		p.LatencyMs = total / n
+	}
+	return p
+}
@@ -0,0 +1,639 @@
+package controller
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unicornultrafoundation/zerogo/internal/config"
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+	"github.com/unicornultrafoundation/zerogo/internal/protocol"
+)
+
+// testAgent is an agent connection to a test controller.
+type testAgent struct {
+	id        *identity.Identity
+	conn      *websocket.Conn
+	challenge []byte // the controller's X-Key-Challenge
+	seq       uint64
+}
+
+// dialAgent connects to srv with the address and public key of id.
+func dialAgent(t *testing.T, srv *httptest.Server, id *identity.Identity) *testAgent {
+	t.Helper()
+	header := http.Header{}
+	header.Set("X-Node-Address", id.Address.String())
+	header.Set("X-Public-Key", id.PublicKeyHex())
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/agent/connect", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	challenge, err := hex.DecodeString(resp.Header.Get("X-Key-Challenge"))
+	if err != nil || len(challenge) != identity.PublicKeySize {
+		t.Fatalf("X-Key-Challenge = %q", resp.Header.Get("X-Key-Challenge"))
+	}
+	return &testAgent{id: id, conn: conn, challenge: challenge}
+}
+
+// join returns a join message of the agent, with a signing key proof if
+// prover is not nil.
+func (a *testAgent) join(t *testing.T, signer, prover *identity.Identity) protocol.JoinMessage {
+	t.Helper()
+	msg := protocol.JoinMessage{
+		Type:       protocol.MsgTypeJoin,
+		NodeAddr:   a.id.Address.String(),
+		PublicKey:  a.id.PublicKeyHex(),
+		SigningKey: hex.EncodeToString(signer.SigningPublicKey()),
+
+		ProtocolVersion: protocol.ProtocolVersion,
+	}
+	if prover != nil {
+		proof, err := prover.KeyProof(a.challenge, protocol.SigningKeyProofData(msg.NodeAddr, msg.SigningKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.SigningKeyProof = hex.EncodeToString(proof)
+	}
+	return msg
+}
+
+// sendSigned sends v in an envelope signed by signer, after letting tamper
+// modify the payload if it is not nil.
+func (a *testAgent) sendSigned(t *testing.T, signer *identity.Identity, v interface{}, tamper func([]byte) []byte) {
+	t.Helper()
+	payload, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.seq++
+	addr := a.id.Address.String()
+	env := protocol.SignedMessage{
+		Type:      protocol.MsgTypeSigned,
+		NodeAddr:  addr,
+		Seq:       a.seq,
+		Payload:   payload,
+		Signature: hex.EncodeToString(signer.Sign(protocol.SignedData(addr, a.seq, payload))),
+	}
+	if tamper != nil {
+		env.Payload = tamper(payload)
+	}
+	if err := a.conn.WriteJSON(env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// closeCode reads until the controller closes the connection and returns
+// the close code, or -1 if it is still open after a while.
+func (a *testAgent) closeCode(t *testing.T) int {
+	t.Helper()
+	a.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := a.conn.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return closeErr.Code
+		}
+		if err != nil {
+			return -1
+		}
+	}
+}
+
+// next reads messages until one of type typ arrives and decodes it into v.
+func (a *testAgent) next(t *testing.T, typ protocol.MessageType, v interface{}) {
+	t.Helper()
+	a.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := a.conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		var head struct {
+			Type protocol.MessageType `json:"type"`
+		}
+		if json.Unmarshal(data, &head) == nil && head.Type == typ {
+			if err := json.Unmarshal(data, v); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+}
+
+func newTestIdentity(t *testing.T) *identity.Identity {
+	t.Helper()
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSigningKeyRequiresIdentityProof(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	victim := newTestIdentity(t)
+	attacker := newTestIdentity(t)
+
+	// Connecting with a node's public key is not enough to register a
+	// signing key for it: the proof needs the node's private key
+	for name, prover := range map[string]*identity.Identity{"no proof": nil, "proof by another key": attacker} {
+		a := dialAgent(t, srv, victim)
+		a.sendSigned(t, attacker, a.join(t, attacker, prover), nil)
+		if code := a.closeCode(t); code != protocol.CloseUnauthorized {
+			t.Fatalf("%s: close code %d, want %d", name, code, protocol.CloseUnauthorized)
+		}
+	}
+	var node Node
+	if ctrl.db.Limit(1).Find(&node, "address = ?", victim.Address.String()); node.SigningKey != "" {
+		t.Fatal("an unproven signing key was registered")
+	}
+
+	// The node itself registers its key
+	a := dialAgent(t, srv, victim)
+	a.sendSigned(t, victim, a.join(t, victim, victim), nil)
+	want := hex.EncodeToString(victim.SigningPublicKey())
+	deadline := time.Now().Add(2 * time.Second)
+	for node.SigningKey != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("signing key = %q, want %q", node.SigningKey, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+		ctrl.db.Limit(1).Find(&node, "address = ?", victim.Address.String())
+	}
+}
+
+func TestTamperedStatusRejected(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	id := newTestIdentity(t)
+
+	a := dialAgent(t, srv, id)
+	a.sendSigned(t, id, a.join(t, id, id), nil)
+	status := protocol.StatusMessage{Type: protocol.MsgTypeStatus}
+	a.sendSigned(t, id, status, nil)
+	a.sendSigned(t, id, status, func(payload []byte) []byte {
+		return []byte(strings.Replace(string(payload), `"type":"status"`, `"type":"status","endpoints":["203.0.113.9:9993"]`, 1))
+	})
+	if code := a.closeCode(t); code != protocol.CloseUnauthorized {
+		t.Fatalf("close code %d, want %d", code, protocol.CloseUnauthorized)
+	}
+}
+
+// online reports whether the agent at addr is connected.
+func (h *WSHandler) online(addr string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.agents[addr]
+	return ok
+}
+
+func TestOfflinePeerEndpointsKeptForTTL(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	a, b := newTestIdentity(t), newTestIdentity(t)
+	ctrl.db.Create(&Network{ID: 3, Name: "net", IPRange: "10.3.0.0/24", PSK: "00"})
+	for _, id := range []*identity.Identity{a, b} {
+		ctrl.db.Create(&Member{NetworkID: 3, NodeAddress: id.Address.String(), Authorized: true})
+	}
+	bAddr := b.Address.String()
+	peerOfA := func() protocol.PeerInfo {
+		t.Helper()
+		cfg, err := ctrl.ws.networkConfig("3", a.Address.String())
+		if err != nil || len(cfg.Peers) != 1 {
+			t.Fatalf("network config: %+v, %v", cfg, err)
+		}
+		return cfg.Peers[0]
+	}
+
+	// b joins, reporting its endpoints, then disconnects
+	agent := dialAgent(t, srv, b)
+	join := agent.join(t, b, b)
+	join.Endpoints = []string{"198.51.100.7:41000"}
+	agent.sendSigned(t, b, join, nil)
+	waitForCond(t, "b to register", func() bool {
+		var node Node
+		ctrl.db.Limit(1).Find(&node, "address = ?", bAddr)
+		return len(node.Endpoints) > 0
+	})
+	if peer := peerOfA(); peer.Stale || len(peer.Endpoints) != 1 {
+		t.Fatalf("online peer = %+v", peer)
+	}
+	agent.conn.Close()
+	waitForCond(t, "b to go offline", func() bool { return !ctrl.ws.online(bAddr) })
+
+	peer := peerOfA()
+	if !peer.Stale || len(peer.Endpoints) != 1 || peer.Endpoints[0] != "198.51.100.7:41000" {
+		t.Fatalf("just-disconnected peer = %+v, want its last endpoints marked stale", peer)
+	}
+
+	// Past the TTL the endpoints are no longer worth trying
+	ctrl.db.Model(&Node{Address: bAddr}).Update("endpoints_at", time.Now().Add(-endpointTTL-time.Minute))
+	if peer := peerOfA(); peer.Stale || len(peer.Endpoints) != 0 {
+		t.Fatalf("peer offline past the TTL = %+v", peer)
+	}
+}
+
+func TestEndpointsReadWhileReported(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	a, b := newTestIdentity(t), newTestIdentity(t)
+	ctrl.db.Create(&Network{ID: 3, Name: "net", IPRange: "10.3.0.0/24", PSK: "00"})
+	for _, id := range []*identity.Identity{a, b} {
+		ctrl.db.Create(&Member{NetworkID: 3, NodeAddress: id.Address.String(), Authorized: true})
+	}
+	bAddr := b.Address.String()
+
+	agent := dialAgent(t, srv, b)
+	agent.sendSigned(t, b, agent.join(t, b, b), nil)
+	waitForCond(t, "b to join", func() bool { return ctrl.ws.online(bAddr) })
+	ctrl.ws.mu.RLock()
+	conn := ctrl.ws.agents[bAddr]
+	ctrl.ws.mu.RUnlock()
+
+	// b's reports are handled on its connection's goroutine while a's
+	// config and hole punches read them on this one (run with -race)
+	const reports = 50
+	endpoint := func(i int) string { return fmt.Sprintf("198.51.100.7:%d", 41000+i) }
+	for i := 0; i < reports; i++ {
+		agent.sendSigned(t, b, protocol.StatusMessage{Type: protocol.MsgTypeStatus, Endpoints: []string{endpoint(i)}}, nil)
+	}
+	waitForCond(t, "the last report", func() bool {
+		cfg, err := ctrl.ws.networkConfig("3", a.Address.String())
+		if err != nil || len(cfg.Peers) != 1 {
+			t.Fatalf("network config: %+v, %v", cfg, err)
+		}
+		info := conn.punchInfo()
+		return len(info.Endpoints) > 0 && info.Endpoints[0] == endpoint(reports-1) &&
+			slices.Equal(cfg.Peers[0].Endpoints, []string{endpoint(reports - 1)})
+	})
+}
+
+// waitForCond polls cond until it holds or two seconds pass.
+func waitForCond(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplacedAgentGetsCloseCode(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	id := newTestIdentity(t)
+
+	first := dialAgent(t, srv, id)
+	waitForCond(t, "the first connection", func() bool { return ctrl.ws.online(id.Address.String()) })
+	second := dialAgent(t, srv, id)
+
+	first.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := first.conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != protocol.CloseReplaced || closeErr.Text != "replaced by newer connection" {
+		t.Fatalf("replaced connection ended with %v, want close code %d", err, protocol.CloseReplaced)
+	}
+
+	// The newer connection is the one that stays
+	second.sendSigned(t, id, second.join(t, id, id), nil)
+	if code := second.closeCode(t); code != -1 {
+		t.Fatalf("newer connection closed with %d", code)
+	}
+	if !ctrl.ws.online(id.Address.String()) {
+		t.Fatal("agent offline after the replacement")
+	}
+}
+
+func TestLowOrderPublicKeyRejected(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	lowOrder := "e0eb7a7c3b41b8ae1656e3faf19fc46ada098deb9c32b1fd866205165f49b800"
+
+	// At connect time
+	header := http.Header{}
+	header.Set("X-Node-Address", "0000000001")
+	header.Set("X-Public-Key", strings.Repeat("00", identity.PublicKeySize))
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/agent/connect", header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("connect with a zero key: %v, %v", resp, err)
+	}
+
+	// And in the join message
+	id := newTestIdentity(t)
+	a := dialAgent(t, srv, id)
+	join := a.join(t, id, id)
+	join.PublicKey = lowOrder
+	a.sendSigned(t, id, join, nil)
+	if code := a.closeCode(t); code != protocol.CloseUnauthorized {
+		t.Fatalf("join with a low-order key: close code %d, want %d", code, protocol.CloseUnauthorized)
+	}
+	var nodes int64
+	ctrl.db.Model(&Node{}).Count(&nodes)
+	if nodes != 0 {
+		t.Fatalf("%d nodes registered", nodes)
+	}
+}
+
+func TestAddressCollisionRejected(t *testing.T) {
+	ctrl := newTestControllerWith(t, func(cfg *config.ControllerConfig) { cfg.LongAddresses = true })
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	id, other := newTestIdentity(t), newTestIdentity(t)
+	connect := func(addr, publicKey string) *http.Response {
+		header := http.Header{}
+		header.Set("X-Node-Address", addr)
+		header.Set("X-Public-Key", publicKey)
+		conn, resp, _ := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/agent/connect", header)
+		if conn != nil {
+			conn.Close()
+		}
+		return resp
+	}
+
+	// A node can only claim the address derived from its own key
+	if resp := connect(id.Address.String(), other.PublicKeyHex()); resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("address of another key: %v", resp)
+	}
+
+	// The node registers, recording its long address
+	a := dialAgent(t, srv, id)
+	a.sendSigned(t, id, a.join(t, id, id), nil)
+	var node Node
+	waitForCond(t, "the node to register", func() bool {
+		return ctrl.db.Limit(1).Find(&node, "address = ?", id.Address.String()).RowsAffected == 1
+	})
+	if want := identity.LongAddressFromPublicKey(id.PublicKey[:]).String(); node.LongAddress != want {
+		t.Errorf("long address = %q, want %q", node.LongAddress, want)
+	}
+
+	// A different key deriving the same address, which can't be made on
+	// purpose, is stood in for by rewriting the stored key
+	ctrl.db.Model(&Node{}).Where("address = ?", id.Address.String()).Update("public_key", other.PublicKeyHex())
+	resp := connect(id.Address.String(), id.PublicKeyHex())
+	if resp == nil || resp.StatusCode != http.StatusConflict {
+		t.Fatalf("colliding key: %v", resp)
+	}
+	var body protocol.APIError
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Code != protocol.ErrCodeAddressTaken {
+		t.Errorf("collision error = %+v, %v", body, err)
+	}
+	ctrl.db.Limit(1).Find(&node, "address = ?", id.Address.String())
+	if node.PublicKey != other.PublicKeyHex() {
+		t.Error("colliding key replaced the registered one")
+	}
+}
+
+func TestAgentOriginCheck(t *testing.T) {
+	ctrl := newTestControllerWith(t, func(cfg *config.ControllerConfig) {
+		cfg.AgentOrigins = []string{"https://ui.example/"}
+	})
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/v1/agent/connect"
+
+	// dial returns the HTTP status of an upgrade from origin ("" for none)
+	dial := func(origin string) int {
+		t.Helper()
+		id := newTestIdentity(t)
+		header := http.Header{}
+		header.Set("X-Node-Address", id.Address.String())
+		header.Set("X-Public-Key", id.PublicKeyHex())
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("origin %q: %v", origin, err)
+		}
+		return resp.StatusCode
+	}
+	for origin, want := range map[string]int{
+		"":                     http.StatusSwitchingProtocols, // a Go agent
+		"https://ui.example":   http.StatusSwitchingProtocols,
+		"https://evil.example": http.StatusForbidden,
+		"http://ui.example":    http.StatusForbidden,
+	} {
+		if got := dial(origin); got != want {
+			t.Errorf("origin %q: HTTP %d, want %d", origin, got, want)
+		}
+	}
+
+	// By default no browser origin is allowed
+	ctrl = newTestController(t)
+	srv2 := httptest.NewServer(ctrl.router)
+	defer srv2.Close()
+	url = "ws" + strings.TrimPrefix(srv2.URL, "http") + "/api/v1/agent/connect"
+	if got := dial("https://ui.example"); got != http.StatusForbidden {
+		t.Errorf("default config, browser origin: HTTP %d", got)
+	}
+	if got := dial(""); got != http.StatusSwitchingProtocols {
+		t.Errorf("default config, no origin: HTTP %d", got)
+	}
+}
+
+func TestProtocolVersionNegotiation(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+
+	// Agents from before negotiation send no version and count as version 1
+	for _, version := range []int{0, protocol.MinProtocolVersion - 1} {
+		id := newTestIdentity(t)
+		a := dialAgent(t, srv, id)
+		join := a.join(t, id, id)
+		join.ProtocolVersion = version
+		a.sendSigned(t, id, join, nil)
+
+		var errMsg protocol.ErrorMessage
+		a.next(t, protocol.MsgTypeError, &errMsg)
+		if errMsg.Code != 426 || !strings.Contains(errMsg.Message, "too old") {
+			t.Errorf("version %d: error = %+v", version, errMsg)
+		}
+		if code := a.closeCode(t); code != protocol.CloseIncompatible {
+			t.Errorf("version %d: close code %d, want %d", version, code, protocol.CloseIncompatible)
+		}
+		var n int64
+		ctrl.db.Model(&Node{}).Where("address = ?", id.Address.String()).Count(&n)
+		if n != 0 {
+			t.Errorf("version %d: rejected agent registered as a node", version)
+		}
+	}
+
+	// A current agent is welcomed and its version recorded
+	id := newTestIdentity(t)
+	a := dialAgent(t, srv, id)
+	a.sendSigned(t, id, a.join(t, id, id), nil)
+	var welcome protocol.WelcomeMessage
+	a.next(t, protocol.MsgTypeWelcome, &welcome)
+	if welcome.ProtocolVersion != protocol.ProtocolVersion {
+		t.Errorf("welcome protocol version %d, want %d", welcome.ProtocolVersion, protocol.ProtocolVersion)
+	}
+	var node Node
+	if err := ctrl.db.Where("address = ?", id.Address.String()).First(&node).Error; err != nil {
+		t.Fatal(err)
+	}
+	if node.ProtocolVersion != protocol.ProtocolVersion {
+		t.Errorf("node protocol version %d, want %d", node.ProtocolVersion, protocol.ProtocolVersion)
+	}
+}
+
+func TestIsolatedAgentPresence(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	admin := testToken(t, ctrl, "admin")
+	id := newTestIdentity(t)
+	addr := id.Address.String()
+
+	status := func() string {
+		t.Helper()
+		w := request(t, ctrl, "GET", "/api/v1/peers", admin, nil)
+		var peers []struct {
+			Address string `json:"address"`
+			Online  bool   `json:"online"`
+			Status  string `json:"status"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &peers); err != nil {
+			t.Fatalf("peers: HTTP %d %s", w.Code, w.Body)
+		}
+		for _, p := range peers {
+			if p.Address == addr {
+				if p.Online != (p.Status != protocol.PresenceOffline) {
+					t.Errorf("online = %v with status %q", p.Online, p.Status)
+				}
+				return p.Status
+			}
+		}
+		return ""
+	}
+	waitForStatus := func(want string) {
+		t.Helper()
+		waitForCond(t, "status "+want, func() bool { return status() == want })
+	}
+
+	a := dialAgent(t, srv, id)
+	a.sendSigned(t, id, a.join(t, id, id), nil)
+	waitForStatus(protocol.PresenceConnected)
+
+	// No connected peers and no peer traffic for a while
+	a.sendSigned(t, id, protocol.StatusMessage{Type: protocol.MsgTypeStatus, LastFrameAt: time.Now().Add(-isolatedAfter - time.Minute)}, nil)
+	waitForStatus(protocol.PresenceIsolated)
+
+	// Recent traffic from a peer still handshaking is enough
+	a.sendSigned(t, id, protocol.StatusMessage{Type: protocol.MsgTypeStatus, LastFrameAt: time.Now()}, nil)
+	waitForStatus(protocol.PresenceConnected)
+
+	a.sendSigned(t, id, protocol.StatusMessage{Type: protocol.MsgTypeStatus}, nil)
+	waitForStatus(protocol.PresenceIsolated)
+	a.sendSigned(t, id, protocol.StatusMessage{
+		Type:  protocol.MsgTypeStatus,
+		Peers: []protocol.PeerStatus{{Address: "00000000aa", Path: "direct"}},
+	}, nil)
+	waitForStatus(protocol.PresenceConnected)
+
+	a.conn.Close()
+	waitForStatus(protocol.PresenceOffline)
+}
+
+func TestListPeersReportsDataPlane(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	id := newTestIdentity(t)
+	addr := id.Address.String()
+	for _, row := range []interface{}{
+		&Network{ID: 1, Name: "office", IPRange: "10.1.0.0/24", PSK: strings.Repeat("ab", 32)},
+		&Network{ID: 2, Name: "lab", IPRange: "10.2.0.0/24", PSK: strings.Repeat("cd", 32)},
+		&Member{NetworkID: 1, NodeAddress: addr, Authorized: true, IPAddress: "10.1.0.2/24"},
+		&Member{NetworkID: 2, NodeAddress: addr},
+	} {
+		if err := ctrl.db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := dialAgent(t, srv, id)
+	a.sendSigned(t, id, a.join(t, id, id), nil)
+	a.sendSigned(t, id, protocol.StatusMessage{
+		Type: protocol.MsgTypeStatus,
+		Peers: []protocol.PeerStatus{
+			{Address: "00000000aa", LatencyMs: 10, Path: "relay"},
+			{Address: "00000000bb", LatencyMs: 30, Path: "direct"},
+		},
+	}, nil)
+
+	admin := testToken(t, ctrl, "admin")
+	var peer protocol.Peer
+	waitForCond(t, "the status report", func() bool {
+		var peers []protocol.Peer
+		w := request(t, ctrl, "GET", "/api/v1/peers", admin, nil)
+		if json.Unmarshal(w.Body.Bytes(), &peers) != nil || len(peers) != 1 {
+			return false
+		}
+		peer = peers[0]
+		return peer.LatencyMs != 0
+	})
+	want := protocol.Peer{
+		Address:   addr,
+		Online:    true,
+		Status:    protocol.PresenceConnected,
+		LatencyMs: 20,
+		Path:      "direct",
+		Networks: []protocol.PeerNetwork{
+			{NetworkID: 1, IPAddress: "10.1.0.2/24", Authorized: true},
+			{NetworkID: 2},
+		},
+	}
+	peer.LastSeen = time.Time{}
+	if !reflect.DeepEqual(peer, want) {
+		t.Fatalf("peer = %+v, want %+v", peer, want)
+	}
+}
+
+func TestListPeersReportsDeviceError(t *testing.T) {
+	ctrl := newTestController(t)
+	srv := httptest.NewServer(ctrl.router)
+	defer srv.Close()
+	id := newTestIdentity(t)
+	admin := testToken(t, ctrl, "admin")
+	deviceError := func() string {
+		var peers []protocol.Peer
+		w := request(t, ctrl, "GET", "/api/v1/peers", admin, nil)
+		if json.Unmarshal(w.Body.Bytes(), &peers) != nil || len(peers) != 1 {
+			return "?"
+		}
+		return peers[0].DeviceError
+	}
+
+	a := dialAgent(t, srv, id)
+	a.sendSigned(t, id, a.join(t, id, id), nil)
+	const failure = "create network device zt0: permission denied"
+	a.sendSigned(t, id, protocol.StatusMessage{Type: protocol.MsgTypeStatus, DeviceError: failure}, nil)
+	waitForCond(t, "the device error", func() bool { return deviceError() == failure })
+
+	// The next status after a successful retry clears it
+	a.sendSigned(t, id, protocol.StatusMessage{Type: protocol.MsgTypeStatus}, nil)
+	waitForCond(t, "the device error to clear", func() bool { return deviceError() == "" })
+}
@@ -1,9 +1,12 @@
 package identity
 
 import (
+	"encoding/base32"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/blake2s"
 )
@@ -11,8 +14,22 @@ import (
 const (
 	// AddressSize is the byte length of a node address (40 bits = 5 bytes).
 	AddressSize = 5
+
+	// LongAddressSize is the byte length of a long node address (80 bits).
+	LongAddressSize = 10
 )
 
+// base32Encoding is Crockford's base32 alphabet, which leaves out I, L, O
+// and U to avoid misreading. A 40-bit address encodes to 8 characters.
+var base32Encoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// base32Decoder maps the characters Crockford decoding treats as aliases.
+var base32Decoder = strings.NewReplacer("-", "", "I", "1", "L", "1", "O", "0")
+
+// ErrAddressMismatch is returned when an address is not the one derived
+// from the public key presented with it.
+var ErrAddressMismatch = errors.New("address does not match public key")
+
 // Address is a 40-bit node address derived from the public key.
 type Address [AddressSize]byte
 
@@ -29,6 +46,46 @@ func AddressFromPublicKey(pubKey []byte) Address {
 	return addr
 }
 
+// VerifyAddress checks that addr is the address derived from pubKey. The
+// derivation is deterministic, including the remap of a zero first byte,
+// so a node can only claim the address of its own key.
+func VerifyAddress(addr Address, pubKey []byte) error {
+	if AddressFromPublicKey(pubKey) != addr {
+		return ErrAddressMismatch
+	}
+	return nil
+}
+
+// LongAddress is an 80-bit node address derived like Address from more of
+// the public key hash. Two keys sharing a 40-bit address (a collision is
+// expected after about a million nodes) still differ here, so controllers
+// can tell colliding nodes apart.
+type LongAddress [LongAddressSize]byte
+
+// LongAddressFromPublicKey derives the 80-bit address of a public key. Its
+// first 5 bytes are the node's Address, with the same zero-byte remap.
+func LongAddressFromPublicKey(pubKey []byte) LongAddress {
+	hash := blake2s.Sum256(pubKey)
+	var addr LongAddress
+	copy(addr[:], hash[:LongAddressSize])
+	if addr[0] == 0 {
+		addr[0] = 1
+	}
+	return addr
+}
+
+// Short returns the 40-bit address the long address extends.
+func (a LongAddress) Short() Address {
+	var addr Address
+	copy(addr[:], a[:AddressSize])
+	return addr
+}
+
+// String returns the hex-encoded long address.
+func (a LongAddress) String() string {
+	return hex.EncodeToString(a[:])
+}
+
 // AddressFromHex parses a hex-encoded address string.
 func AddressFromHex(s string) (Address, error) {
 	var addr Address
@@ -43,11 +100,44 @@ func AddressFromHex(s string) (Address, error) {
 	return addr, nil
 }
 
-// String returns the hex-encoded address.
+// AddressFromBase32 parses a Crockford base32 address as returned by
+// Base32. It is case-insensitive, ignores hyphens and reads I and L as 1
+// and O as 0.
+func AddressFromBase32(s string) (Address, error) {
+	var addr Address
+	s = base32Decoder.Replace(strings.ToUpper(s))
+	if n := base32Encoding.EncodedLen(AddressSize); len(s) != n {
+		return addr, fmt.Errorf("base32 address must be %d characters, got %d", n, len(s))
+	}
+	b, err := base32Encoding.DecodeString(s)
+	if err != nil {
+		return addr, fmt.Errorf("invalid base32 address: %w", err)
+	}
+	copy(addr[:], b)
+	return addr, nil
+}
+
+// ParseAddress parses an address in either hex (10 characters) or base32
+// (8 characters, not counting hyphens).
+func ParseAddress(s string) (Address, error) {
+	if len(s) == hex.EncodedLen(AddressSize) {
+		return AddressFromHex(s)
+	}
+	return AddressFromBase32(s)
+}
+
+// String returns the hex-encoded address, the form used on the wire and in
+// storage.
 func (a Address) String() string {
 	return hex.EncodeToString(a[:])
 }
 
+// Base32 returns the address in Crockford base32 without padding, which is
+// easier to read aloud and type than hex.
+func (a Address) Base32() string {
+	return base32Encoding.EncodeToString(a[:])
+}
+
 // IsZero returns true if the address is all zeros.
 func (a Address) IsZero() bool {
 	return a == Address{}
@@ -0,0 +1,96 @@
+package identity
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+func TestAddressZeroByteRemap(t *testing.T) {
+	// Find a key whose hash starts with the reserved zero byte
+	var key [PublicKeySize]byte
+	var hash [blake2s.Size]byte
+	for i := uint64(0); ; i++ {
+		binary.LittleEndian.PutUint64(key[:], i)
+		if hash = blake2s.Sum256(key[:]); hash[0] == 0 {
+			break
+		}
+	}
+
+	addr := AddressFromPublicKey(key[:])
+	if addr[0] != 1 || string(addr[1:]) != string(hash[1:AddressSize]) {
+		t.Fatalf("address %s, want the hash %x with its first byte set to 1", addr, hash[:AddressSize])
+	}
+	if again := AddressFromPublicKey(key[:]); again != addr {
+		t.Fatalf("derivation not deterministic: %s, then %s", addr, again)
+	}
+	if err := VerifyAddress(addr, key[:]); err != nil {
+		t.Fatalf("VerifyAddress = %v", err)
+	}
+	var unmapped Address
+	copy(unmapped[:], hash[:AddressSize])
+	if err := VerifyAddress(unmapped, key[:]); !errors.Is(err, ErrAddressMismatch) {
+		t.Fatalf("VerifyAddress of the raw hash prefix = %v", err)
+	}
+
+	// The long address extends the short one, remap included
+	long := LongAddressFromPublicKey(key[:])
+	if long.Short() != addr || string(long[AddressSize:]) != string(hash[AddressSize:LongAddressSize]) {
+		t.Fatalf("long address %s does not extend %s", long, addr)
+	}
+}
+
+func TestVerifyAddress(t *testing.T) {
+	a, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAddress(a.Address, a.PublicKey[:]); err != nil {
+		t.Fatalf("own address: %v", err)
+	}
+	if err := VerifyAddress(a.Address, b.PublicKey[:]); !errors.Is(err, ErrAddressMismatch) {
+		t.Fatalf("another key's address: %v", err)
+	}
+}
+
+func TestAddressBase32(t *testing.T) {
+	addr := Address{0x01, 0x23, 0x45, 0x67, 0x89}
+	if got := addr.Base32(); got != "04HMASW9" {
+		t.Fatalf("Base32() = %q, want 04HMASW9", got)
+	}
+	for _, s := range []string{"04HMASW9", "04hmasw9", "04HM-ASW9", "O4HMASW9"} {
+		if got, err := AddressFromBase32(s); err != nil || got != addr {
+			t.Errorf("AddressFromBase32(%q) = %s, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "04HMASW", "04HMASW9X", "04HMASWU"} {
+		if _, err := AddressFromBase32(s); err == nil {
+			t.Errorf("AddressFromBase32(%q) accepted", s)
+		}
+	}
+	// I and L read as 1
+	for _, s := range []string{"1111111Z", "IiLl111Z"} {
+		if got, err := AddressFromBase32(s); err != nil || got.Base32() != "1111111Z" {
+			t.Errorf("AddressFromBase32(%q) = %s, %v", s, got.Base32(), err)
+		}
+	}
+
+	for range 1000 {
+		id, err := Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := id.Address
+		for _, s := range []string{a.Base32(), a.String()} {
+			if got, err := ParseAddress(s); err != nil || got != a {
+				t.Fatalf("ParseAddress(%q) = %s, %v, want %s", s, got, err, a)
+			}
+		}
+	}
+}
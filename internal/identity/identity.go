@@ -3,6 +3,7 @@ package identity
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -54,27 +55,80 @@ func FromPrivateKey(privKey [PrivateKeySize]byte) (*Identity, error) {
 	return id, nil
 }
 
+// ErrLowOrderKey is returned for public keys whose Diffie-Hellman result
+// does not depend on the private key, such as the all-zero key.
+var ErrLowOrderKey = errors.New("low-order public key")
+
+// lowOrderKeys are the Curve25519 u-coordinates of small order (0, 1, the
+// two points of order 8 and p-1), plus p and p+1, which X25519 reads as 0
+// and 1. A peer offering one of them would force a shared secret an
+// attacker can predict.
+var lowOrderKeys = [][PublicKeySize]byte{
+	{},
+	{1},
+	{0xe0, 0xeb, 0x7a, 0x7c, 0x3b, 0x41, 0xb8, 0xae, 0x16, 0x56, 0xe3, 0xfa, 0xf1, 0x9f, 0xc4, 0x6a, 0xda, 0x09, 0x8d, 0xeb, 0x9c, 0x32, 0xb1, 0xfd, 0x86, 0x62, 0x05, 0x16, 0x5f, 0x49, 0xb8, 0x00},
+	{0x5f, 0x9c, 0x95, 0xbc, 0xa3, 0x50, 0x8c, 0x24, 0xb1, 0xd0, 0xb1, 0x55, 0x9c, 0x83, 0xef, 0x5b, 0x04, 0x44, 0x5c, 0xc4, 0x58, 0x1c, 0x8e, 0x86, 0xd8, 0x22, 0x4e, 0xdd, 0xd0, 0x9f, 0x11, 0x57},
+	{0xec, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+	{0xed, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+	{0xee, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+}
+
+// ValidatePublicKey rejects keys of the wrong size and low-order keys. Call
+// it on every public key received from a peer or agent before using it.
+func ValidatePublicKey(pub []byte) error {
+	if len(pub) != PublicKeySize {
+		return fmt.Errorf("invalid public key: %d bytes, want %d", len(pub), PublicKeySize)
+	}
+	var u [PublicKeySize]byte
+	copy(u[:], pub)
+	u[31] &= 0x7f // X25519 ignores the top bit
+	for _, bad := range lowOrderKeys {
+		if u == bad {
+			return ErrLowOrderKey
+		}
+	}
+	return nil
+}
+
 // LoadOrGenerate loads an identity from file, or generates a new one.
 func LoadOrGenerate(path string) (*Identity, error) {
-	data, err := os.ReadFile(path)
-	if err == nil && len(data) == PrivateKeySize {
-		var privKey [PrivateKeySize]byte
-		copy(privKey[:], data)
-		return FromPrivateKey(privKey)
+	if id, err := Load(path); err == nil {
+		return id, nil
 	}
 	// Generate new identity
 	id, err := Generate()
 	if err != nil {
 		return nil, err
 	}
-	// Save to file
+	if err := id.Save(path); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// Load reads an identity from file.
+func Load(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read identity: %w", err)
+	}
+	if len(data) != PrivateKeySize {
+		return nil, fmt.Errorf("invalid identity file %s: %d bytes, want %d", path, len(data), PrivateKeySize)
+	}
+	var privKey [PrivateKeySize]byte
+	copy(privKey[:], data)
+	return FromPrivateKey(privKey)
+}
+
+// Save writes the identity's private key to file.
+func (id *Identity) Save(path string) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
-		return nil, fmt.Errorf("create identity directory: %w", err)
+		return fmt.Errorf("create identity directory: %w", err)
 	}
 	if err := os.WriteFile(path, id.PrivateKey[:], 0600); err != nil {
-		return nil, fmt.Errorf("save identity: %w", err)
+		return fmt.Errorf("save identity: %w", err)
 	}
-	return id, nil
+	return nil
 }
 
 // PublicKeyHex returns the public key as a hex string.
@@ -0,0 +1,36 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestValidatePublicKey(t *testing.T) {
+	id, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePublicKey(id.PublicKey[:]); err != nil {
+		t.Fatalf("fresh key rejected: %v", err)
+	}
+	if err := ValidatePublicKey(id.PublicKey[:31]); err == nil || errors.Is(err, ErrLowOrderKey) {
+		t.Errorf("short key: err = %v", err)
+	}
+
+	for _, bad := range lowOrderKeys {
+		// X25519 ignores the top bit, so both encodings are low order
+		high := bad
+		high[31] |= 0x80
+		for _, key := range [][PublicKeySize]byte{bad, high} {
+			if err := ValidatePublicKey(key[:]); !errors.Is(err, ErrLowOrderKey) {
+				t.Errorf("ValidatePublicKey(%x) = %v, want ErrLowOrderKey", key, err)
+			}
+			// Every one of them gives a shared secret of zero
+			if _, err := curve25519.X25519(id.PrivateKey[:], key[:]); err == nil {
+				t.Errorf("X25519 accepted %x", key)
+			}
+		}
+	}
+}
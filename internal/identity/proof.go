@@ -0,0 +1,29 @@
+package identity
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/curve25519"
+)
+
+// keyProofContext separates key proofs from other uses of the shared secret.
+const keyProofContext = "zerogo identity key proof v1"
+
+// KeyProof returns a MAC over data keyed with the Diffie-Hellman secret of
+// the identity and peerPub. Only the holders of the two private keys can
+// compute it, so the owner of peerPub can check that the identity's private
+// key vouches for data by computing the same proof on their side.
+func (id *Identity) KeyProof(peerPub, data []byte) ([]byte, error) {
+	if err := ValidatePublicKey(peerPub); err != nil {
+		return nil, err
+	}
+	secret, err := curve25519.X25519(id.PrivateKey[:], peerPub)
+	if err != nil {
+		return nil, fmt.Errorf("key proof: %w", err)
+	}
+	h, _ := blake2s.New256(secret)
+	h.Write([]byte(keyProofContext))
+	h.Write(data)
+	return h.Sum(nil), nil
+}
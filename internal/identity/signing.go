@@ -0,0 +1,30 @@
+package identity
+
+import (
+	"crypto/ed25519"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+// signingKeyContext separates the signing key derivation from other uses of
+// the private key.
+const signingKeyContext = "zerogo identity signing key v1"
+
+// SigningKey returns the node's Ed25519 signing key. Curve25519 keys cannot
+// sign, so the key is derived deterministically from the identity private
+// key and needs no separate storage.
+func (id *Identity) SigningKey() ed25519.PrivateKey {
+	h, _ := blake2s.New256(id.PrivateKey[:])
+	h.Write([]byte(signingKeyContext))
+	return ed25519.NewKeyFromSeed(h.Sum(nil))
+}
+
+// SigningPublicKey returns the public half of SigningKey.
+func (id *Identity) SigningPublicKey() ed25519.PublicKey {
+	return id.SigningKey().Public().(ed25519.PublicKey)
+}
+
+// Sign signs msg with the node's signing key.
+func (id *Identity) Sign(msg []byte) []byte {
+	return ed25519.Sign(id.SigningKey(), msg)
+}
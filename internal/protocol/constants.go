@@ -7,6 +7,8 @@ const (
 	DefaultControllerPort = 9394
 	// DefaultSTUNPort is the default STUN/TURN port.
 	DefaultSTUNPort = 3478
+	// DefaultAgentStatusAddr is the default local agent status listen address.
+	DefaultAgentStatusAddr = "127.0.0.1:9994"
 
 	// MaxFrameSize is the maximum Ethernet frame size supported.
 	MaxFrameSize = 9000
@@ -14,5 +16,31 @@ const (
 	DefaultMTU = 2800
 
 	// ProtocolVersion is the current protocol version.
-	ProtocolVersion = 1
+	ProtocolVersion = 3
+	// MinProtocolVersion is the oldest agent protocol the controller accepts.
+	// Version 1 agents derive session keys that version 2 peers reject, and
+	// version 2 hellos lack the handshake type version 3 peers expect.
+	MinProtocolVersion = 3
+)
+
+// WebSocket close codes sent by the controller in addition to the standard
+// 1001 (going away) used on shutdown. Codes 4000-4999 are reserved for
+// application use.
+const (
+	// CloseReplaced means a newer connection from the same node took over.
+	CloseReplaced = 4000
+	// CloseUnauthorized means the agent failed authentication; reconnecting
+	// with the same credentials will fail again.
+	CloseUnauthorized = 4001
+	// CloseDecommissioned means the node was deleted from the controller.
+	CloseDecommissioned = 4002
+	// CloseRotated means the node moved to a new identity; the old one is
+	// no longer known to the controller.
+	CloseRotated = 4003
+	// CloseIncompatible means the agent speaks a protocol version the
+	// controller no longer accepts; it needs upgrading.
+	CloseIncompatible = 4004
+	// CloseKicked means an admin disconnected the agent; it should stay
+	// away for a cooldown rather than reconnect at once.
+	CloseKicked = 4005
 )
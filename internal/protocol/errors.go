@@ -0,0 +1,51 @@
+package protocol
+
+// APIError is the body of every REST API error response. Code is stable
+// and meant for programs; Message is for people and may change.
+type APIError struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Error implements error, so clients can return an APIError as is.
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Message
+}
+
+// APIError codes.
+const (
+	// Generic
+	ErrCodeInvalidRequest   = "invalid_request" // malformed body or invalid parameter
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeInternal         = "internal_error"
+
+	// Authentication and authorization
+	ErrCodeUnauthorized       = "unauthorized" // missing or malformed credentials
+	ErrCodeInvalidCredentials = "invalid_credentials"
+	ErrCodeInvalidToken       = "invalid_token"
+	ErrCodeTokenRevoked       = "token_revoked"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeAdminRequired      = "admin_required"
+	ErrCodePasswordChange     = "password_change_required" // admin must replace a weak configured password
+	ErrCodeInvalidSignature   = "invalid_signature"
+	ErrCodeNoSigningKey       = "no_signing_key"
+
+	// Resources
+	ErrCodeNetworkNotFound = "network_not_found"
+	ErrCodeMemberNotFound  = "member_not_found"
+	ErrCodeNodeNotFound    = "node_not_found"
+	ErrCodeUserNotFound    = "user_not_found"
+	ErrCodeUsernameTaken   = "username_taken"
+	ErrCodeNetworkIDTaken  = "network_id_taken"
+	ErrCodeAddressTaken    = "address_taken"
+	ErrCodeIPExhausted     = "ip_exhausted"
+	ErrCodeNotPurgeable    = "not_purgeable" // deleted network still within its retention period
+	ErrCodeNodeOnline      = "node_online"
+	ErrCodeNodeOffline     = "node_offline"
+	ErrCodeUIDisabled      = "ui_disabled"
+)
@@ -1,20 +1,31 @@
 package protocol
 
-import "time"
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
 
 // MessageType identifies the control protocol message type.
 type MessageType string
 
 const (
 	// Agent → Controller
-	MsgTypeJoin         MessageType = "join"
-	MsgTypeStatus       MessageType = "status"
-	MsgTypeLeave        MessageType = "leave"
+	MsgTypeJoin   MessageType = "join"
+	MsgTypeStatus MessageType = "status"
+	MsgTypeLeave  MessageType = "leave"
+	MsgTypeSigned MessageType = "signed" // envelope around a signed join or status
 
 	// Controller → Agent
 	MsgTypeNetworkConfig MessageType = "network_config"
 	MsgTypePeerUpdate    MessageType = "peer_update"
+	MsgTypePunch         MessageType = "punch"
+	MsgTypeRelayOffer    MessageType = "relay_offer" // relayed via controller between agents
 	MsgTypeError         MessageType = "error"
+	MsgTypeWelcome       MessageType = "welcome" // join accepted
+	MsgTypeRelayUpdate   MessageType = "relay_update"
+	// The network was deleted; members drop its peers and settings
+	MsgTypeNetworkDeleted MessageType = "network_deleted"
 )
 
 // Message is the base control protocol message.
@@ -31,21 +42,83 @@ type JoinMessage struct {
 	Endpoints []string    `json:"endpoints"` // public-facing UDP endpoints
 	Platform  string      `json:"platform"`
 	Version   string      `json:"version"`
+	Hostname  string      `json:"hostname,omitempty"` // seeds the node name on first registration
+	// SigningKey is the agent's Ed25519 public key (hex), registered on first
+	// join and then required to verify the node's signed messages.
+	SigningKey string `json:"signing_key,omitempty"`
+	// SigningKeyProof binds SigningKey to PublicKey before it is registered:
+	// the identity's KeyProof (hex) over SigningKeyProofData for the
+	// controller's X-Key-Challenge key of this connection.
+	SigningKeyProof string `json:"signing_key_proof,omitempty"`
+	// ProtocolVersion is the agent's ProtocolVersion. Agents that predate
+	// version negotiation leave it out and count as version 1.
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+}
+
+// WelcomeMessage answers an accepted join with the controller's protocol
+// version, so the agent can adapt to an older controller, and its clock, a
+// time reference for agents without a reliable one (zero from controllers
+// that predate it).
+type WelcomeMessage struct {
+	Type            MessageType `json:"type"`
+	ProtocolVersion int         `json:"protocol_version"`
+	Time            time.Time   `json:"time"`
+}
+
+// SignedMessage wraps a control message signed with the sender's identity
+// signing key. Seq increases with every signed message on a connection so a
+// captured message cannot be replayed.
+type SignedMessage struct {
+	Type      MessageType     `json:"type"`
+	NodeAddr  string          `json:"node_addr"`
+	Seq       uint64          `json:"seq"`
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"` // hex Ed25519 signature over SignedData
+}
+
+// SignedData returns the bytes covered by a SignedMessage signature.
+func SignedData(nodeAddr string, seq uint64, payload []byte) []byte {
+	buf := make([]byte, 0, len(signedDataContext)+len(nodeAddr)+9+len(payload))
+	buf = append(buf, signedDataContext...)
+	buf = append(buf, nodeAddr...)
+	buf = append(buf, 0)
+	buf = binary.BigEndian.AppendUint64(buf, seq)
+	return append(buf, payload...)
 }
 
+const signedDataContext = "zerogo signed message v1\x00"
+
 // StatusMessage is periodically sent by agent to report status.
 type StatusMessage struct {
-	Type  MessageType  `json:"type"`
-	Peers []PeerStatus `json:"peers"`
+	Type      MessageType  `json:"type"`
+	Peers     []PeerStatus `json:"peers"`
+	Endpoints []string     `json:"endpoints,omitempty"` // current endpoints, if changed since join
+	// LastFrameAt is when the agent last heard from any peer over the data
+	// plane; zero if it never has.
+	LastFrameAt time.Time `json:"last_frame_at,omitzero"`
+	// DeviceError is why the agent could not create its network device;
+	// empty once it has one. The agent keeps retrying while it is set.
+	DeviceError string `json:"device_error,omitempty"`
 }
 
+// Presence values reported by the controller for a node. A node whose
+// WebSocket is up but which has no connected peers is isolated: the
+// controller can reach it, but its data plane is not carrying traffic.
+const (
+	PresenceConnected = "connected"
+	PresenceIsolated  = "isolated"
+	PresenceOffline   = "offline"
+)
+
 // PeerStatus reports connection status with one peer.
 type PeerStatus struct {
 	Address   string `json:"address"`
 	LatencyMs int64  `json:"latency_ms"`
 	Path      string `json:"path"` // "direct" or "relay"
-	BytesSent int64  `json:"bytes_sent"`
-	BytesRecv int64  `json:"bytes_recv"`
+	// Cumulative data bytes since the agent added the peer; they restart
+	// from zero when the agent restarts or re-adds the peer.
+	BytesSent int64 `json:"bytes_sent"`
+	BytesRecv int64 `json:"bytes_recv"`
 }
 
 // LeaveMessage is sent when agent leaves a network.
@@ -63,9 +136,97 @@ type NetworkConfigMessage struct {
 	IP6Range   string      `json:"ip6_range,omitempty"`
 	MTU        int         `json:"mtu"`
 	Multicast  bool        `json:"multicast"`
-	PSK        string      `json:"psk"`        // Network PSK for peer encryption (hex)
-	AssignedIP string      `json:"assigned_ip"` // IP/mask assigned to this node (CIDR)
+	PSK        string      `json:"psk"`                  // Network PSK for peer encryption (hex)
+	AssignedIP string      `json:"assigned_ip"`          // IP/mask assigned to this node (CIDR)
+	GatewayIP  string      `json:"gateway_ip,omitempty"` // network's designated gateway, for route-via
 	Peers      []PeerInfo  `json:"peers"`
+	Relays     []RelayInfo `json:"relays,omitempty"` // TURN servers for relay fallback
+	Rules      []RuleInfo  `json:"rules,omitempty"`  // ACL rules, enforced by each agent
+
+	// AssignedIP6 is the node's IPv6/prefix, derived from the network's
+	// IPv6 range and the node address; empty without an IPv6 range.
+	AssignedIP6 string `json:"assigned_ip6,omitempty"`
+
+	// SourceValidation asks agents to drop frames a peer sends from a MAC
+	// or IP other than its own, as listed in Peers.
+	SourceValidation bool `json:"source_validation,omitempty"`
+
+	// Tables sizes the agents' MAC table and ARP/NDP caches.
+	Tables TableLimits `json:"tables,omitzero"`
+
+	// DefaultGateway is the overlay IP of the member that full-tunnel agents
+	// route all traffic through; IsGateway is set for that member itself,
+	// which should forward and NAT the traffic.
+	DefaultGateway string `json:"default_gateway,omitempty"`
+	IsGateway      bool   `json:"is_gateway,omitempty"`
+
+	// ReservedRanges are the ranges the controller never assigns to members;
+	// an agent DHCP server leases from within them.
+	ReservedRanges []string `json:"reserved_ranges,omitempty"`
+
+	// DNSServers are the network's internal resolvers; agents point the
+	// overlay interface at them for SearchDomains.
+	DNSServers    []string `json:"dns_servers,omitempty"`
+	SearchDomains []string `json:"search_domains,omitempty"`
+}
+
+// TableLimits sizes the MAC table and ARP/NDP caches of a network's
+// members. Zero fields leave the agent defaults.
+type TableLimits struct {
+	MACTableSize   int `json:"mac_table_size,omitempty"`
+	MACTableExpiry int `json:"mac_table_expiry,omitempty"` // seconds
+	ARPCacheSize   int `json:"arp_cache_size,omitempty"`
+	ARPCacheExpiry int `json:"arp_cache_expiry,omitempty"` // seconds
+}
+
+// RuleInfo is an ACL rule as pushed to agents. The time window fields are
+// optional; a rule with a window only applies while it is open.
+type RuleInfo struct {
+	ID        uint   `json:"id"`
+	Priority  int    `json:"priority"`
+	Action    string `json:"action"` // allow, drop
+	Src       string `json:"src,omitempty"`
+	Dst       string `json:"dst,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+	PortRange string `json:"port_range,omitempty"`
+	Days      string `json:"days,omitempty"`       // e.g. "mon,tue,wed,thu,fri"; empty = every day
+	StartTime string `json:"start_time,omitempty"` // "HH:MM"
+	EndTime   string `json:"end_time,omitempty"`   // "HH:MM"; before StartTime wraps past midnight
+	Timezone  string `json:"timezone,omitempty"`   // IANA name; empty = UTC
+}
+
+// EvaluateRulesRequest is a flow to check against a network's ACL rules.
+type EvaluateRulesRequest struct {
+	Src      string `json:"src" binding:"required"`      // IPv4 address
+	Dst      string `json:"dst" binding:"required"`      // IPv4 address
+	Protocol string `json:"protocol" binding:"required"` // tcp, udp, icmp or a protocol number
+	SrcPort  int    `json:"src_port,omitempty"`
+	Port     int    `json:"port,omitempty"` // destination port (tcp, udp)
+	// At is when the flow happens, for rules with time windows; default now
+	At *time.Time `json:"at,omitempty"`
+}
+
+// EvaluateRulesResponse is what agents would do with an evaluated flow.
+type EvaluateRulesResponse struct {
+	Action string    `json:"action"`         // allow or drop
+	Rule   *RuleInfo `json:"rule,omitempty"` // matched rule; nil when no rule matches
+	At     time.Time `json:"at"`
+	// SkippedRules are invalid rules agents ignore, and so does evaluation
+	SkippedRules []uint `json:"skipped_rules,omitempty"`
+}
+
+// RelayInfo describes a TURN server agents may allocate relays on.
+type RelayInfo struct {
+	URL      string `json:"url"` // e.g. "turn:relay.example.com:3478"
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// RelayUpdateMessage replaces the TURN servers an agent was given, e.g.
+// with fresh ephemeral credentials before the old ones expire.
+type RelayUpdateMessage struct {
+	Type   MessageType `json:"type"`
+	Relays []RelayInfo `json:"relays"`
 }
 
 // PeerInfo contains information about a peer in a network.
@@ -74,6 +235,12 @@ type PeerInfo struct {
 	PublicKey string   `json:"public_key"`
 	Endpoints []string `json:"endpoints"`
 	Name      string   `json:"name,omitempty"`
+	Stale     bool     `json:"stale,omitempty"` // peer offline; endpoints are its last known ones
+	IP        string   `json:"ip,omitempty"`    // overlay IP/mask assigned to the peer
+	PSK       string   `json:"psk,omitempty"`   // pair PSK (hex) when it differs from the network PSK
+
+	IP6 string `json:"ip6,omitempty"` // overlay IPv6/prefix, when the network has an IPv6 range
+	MAC string `json:"mac,omitempty"` // MAC the peer's agent uses on the network
 }
 
 // PeerUpdateMessage is sent when peers join/leave a network.
@@ -81,6 +248,34 @@ type PeerUpdateMessage struct {
 	Type   MessageType `json:"type"`
 	Action string      `json:"action"` // "add" or "remove"
 	Peer   PeerInfo    `json:"peer"`
+
+	// NetworkID is the network the peer joined or left (empty from older
+	// controllers)
+	NetworkID string `json:"network_id,omitempty"`
+}
+
+// PunchMessage asks an agent to send hellos to a peer at a coordinated time,
+// so that both sides open their NAT mappings simultaneously.
+type PunchMessage struct {
+	Type      MessageType `json:"type"`
+	NetworkID string      `json:"network_id"`
+	Peer      PeerInfo    `json:"peer"`
+	At        time.Time   `json:"at"` // controller clock; start sending at this time
+}
+
+// NetworkDeletedMessage tells the members of a network that it was deleted.
+type NetworkDeletedMessage struct {
+	Type      MessageType `json:"type"`
+	NetworkID string      `json:"network_id"`
+}
+
+// RelayOfferMessage carries a TURN relayed address between two agents.
+// The controller fills in From with the sending agent's address.
+type RelayOfferMessage struct {
+	Type      MessageType `json:"type"`
+	From      string      `json:"from,omitempty"`
+	To        string      `json:"to"`
+	RelayAddr string      `json:"relay_addr"` // ip:port allocated on the TURN server
 }
 
 // ErrorMessage reports an error from the controller.
@@ -94,16 +289,25 @@ type ErrorMessage struct {
 
 // Network represents a virtual network in API responses.
 type Network struct {
-	ID          uint32    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description,omitempty"`
-	IPRange     string    `json:"ip_range"`
-	IP6Range    string    `json:"ip6_range,omitempty"`
-	MTU         int       `json:"mtu"`
-	Multicast   bool      `json:"multicast"`
-	MemberCount int       `json:"member_count,omitempty"`
-	OnlineCount int       `json:"online_count,omitempty"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID             uint32    `json:"id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitempty"`
+	IPRange        string    `json:"ip_range"`
+	IP6Range       string    `json:"ip6_range,omitempty"`
+	MTU            int       `json:"mtu"`
+	Multicast      bool      `json:"multicast"`
+	ReservedRanges []string  `json:"reserved_ranges,omitempty"`
+	GatewayIP      string    `json:"gateway_ip,omitempty"`
+	DNSServers     []string  `json:"dns_servers,omitempty"`
+	SearchDomains  []string  `json:"search_domains,omitempty"`
+	Private        bool      `json:"private,omitempty"`
+	MemberCount    int       `json:"member_count,omitempty"`
+	OnlineCount    int       `json:"online_count,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	DeletedAt      time.Time `json:"deleted_at,omitzero"` // set for deleted networks awaiting purge
+
+	SourceValidation bool        `json:"source_validation,omitempty"`
+	Tables           TableLimits `json:"tables,omitzero"`
 }
 
 // CreateNetworkRequest is the request body for creating a network.
@@ -114,6 +318,24 @@ type CreateNetworkRequest struct {
 	IP6Range    string `json:"ip6_range"`
 	MTU         int    `json:"mtu"`
 	Multicast   *bool  `json:"multicast"`
+	// Private refuses nodes that are not already members instead of
+	// adding them as pending; nil leaves it unchanged on update.
+	Private *bool `json:"private"`
+	// SourceValidation makes members drop frames whose source MAC or IP is
+	// not the sending member's own; nil leaves it unchanged on update.
+	SourceValidation *bool `json:"source_validation"`
+	// Tables sizes members' MAC table and ARP/NDP caches; nil leaves them
+	// unchanged on update.
+	Tables *TableLimits `json:"tables"`
+	// ReservedRanges are CIDRs or "first-last" ranges auto-allocation skips.
+	// On update, nil leaves them unchanged and an empty list clears them.
+	ReservedRanges []string `json:"reserved_ranges"`
+	GatewayIP      string   `json:"gateway_ip"`
+	// DNSServers and SearchDomains are pushed to members, which resolve the
+	// search domains via the servers. On update, nil leaves them unchanged
+	// and an empty list clears them.
+	DNSServers    []string `json:"dns_servers"`
+	SearchDomains []string `json:"search_domains"`
 }
 
 // Member represents a network member in API responses.
@@ -123,18 +345,170 @@ type Member struct {
 	Authorized  bool      `json:"authorized"`
 	IPAddress   string    `json:"ip_address,omitempty"`
 	Name        string    `json:"name,omitempty"`
+	Gateway     bool      `json:"gateway,omitempty"`
+	HasPSK      bool      `json:"has_psk,omitempty"` // member overrides the network PSK
+	NodeName    string    `json:"node_name,omitempty"`
+	NodeDesc    string    `json:"node_description,omitempty"`
 	Online      bool      `json:"online"`
 	Platform    string    `json:"platform,omitempty"`
 	LastSeen    time.Time `json:"last_seen,omitempty"`
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// Peer represents a node in the peers listing, with the data-plane state
+// its agent last reported.
+type Peer struct {
+	Address     string        `json:"address"`
+	Name        string        `json:"name,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Platform    string        `json:"platform"`
+	Online      bool          `json:"online"`
+	Status      string        `json:"status"`               // connected, isolated or offline
+	LatencyMs   int64         `json:"latency_ms,omitempty"` // mean over connected peers
+	Path        string        `json:"path,omitempty"`       // "direct" if any peer is reached directly, else "relay"
+	Networks    []PeerNetwork `json:"networks,omitempty"`
+	LastSeen    time.Time     `json:"last_seen"`
+	DeviceError string        `json:"device_error,omitempty"` // set while the agent cannot create its device
+}
+
+// PeerNetwork is a network a peer is a member of and its IP there.
+type PeerNetwork struct {
+	NetworkID  uint32 `json:"network_id"`
+	IPAddress  string `json:"ip_address,omitempty"`
+	Authorized bool   `json:"authorized"`
+}
+
+// NetworkPSK is a network's pre-shared key, returned to admins only.
+type NetworkPSK struct {
+	NetworkID uint32 `json:"network_id"`
+	PSK       string `json:"psk"` // hex
+}
+
 // AuthorizeMemberRequest is the request body for authorizing a member.
 type AuthorizeMemberRequest struct {
 	NodeAddress string `json:"node_address" binding:"required"`
 	Authorized  bool   `json:"authorized"`
 	IPAddress   string `json:"ip_address"`
 	Name        string `json:"name"`
+	// Gateway makes the member the network's default gateway (clearing any
+	// previous one); nil leaves it unchanged.
+	Gateway *bool `json:"gateway"`
+	// PSK overrides the network PSK for the member's peer sessions (64 hex
+	// characters); "" removes the override and nil leaves it unchanged.
+	PSK *string `json:"psk"`
+}
+
+// NetworkExport is a portable snapshot of a network, its members and rules.
+type NetworkExport struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Network    Network          `json:"network"`
+	PSK        string           `json:"psk,omitempty"` // only when exported with include_psk
+	Members    []ExportedMember `json:"members"`
+	Rules      []ExportedRule   `json:"rules"`
+}
+
+// ExportedMember is a member entry in a NetworkExport.
+type ExportedMember struct {
+	NodeAddress string `json:"node_address"`
+	PublicKey   string `json:"public_key,omitempty"`
+	Authorized  bool   `json:"authorized"`
+	IPAddress   string `json:"ip_address,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Gateway     bool   `json:"gateway,omitempty"`
+	PSK         string `json:"psk,omitempty"` // per-member override, exported with the network PSK
+}
+
+// ExportedRule is an ACL rule entry in a NetworkExport.
+type ExportedRule struct {
+	Priority    int    `json:"priority"`
+	Action      string `json:"action"`
+	Src         string `json:"src,omitempty"`
+	Dst         string `json:"dst,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	PortRange   string `json:"port_range,omitempty"`
+	Days        string `json:"days,omitempty"`
+	StartTime   string `json:"start_time,omitempty"`
+	EndTime     string `json:"end_time,omitempty"`
+	Timezone    string `json:"timezone,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// UpdateNodeRequest is the request body for renaming or describing a node.
+// A nil field is left unchanged; "" clears it.
+type UpdateNodeRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// DisconnectNodeRequest is the optional request body for force-disconnecting
+// a node. Deauthorize also revokes its memberships in every network, so it
+// cannot rejoin once the cooldown is over.
+type DisconnectNodeRequest struct {
+	Reason      string `json:"reason"`
+	Deauthorize bool   `json:"deauthorize"`
+}
+
+// RotateIdentityRequest moves a node's memberships to a new identity. The
+// old identity's signing key authorizes the move; the new one proves the
+// requester holds the new key. Both sign RotationSignedData.
+type RotateIdentityRequest struct {
+	OldAddress    string `json:"old_address" binding:"required"`
+	NewAddress    string `json:"new_address" binding:"required"`
+	NewPublicKey  string `json:"new_public_key" binding:"required"`  // Curve25519 (hex)
+	NewSigningKey string `json:"new_signing_key" binding:"required"` // Ed25519 (hex)
+	OldSignature  string `json:"old_signature" binding:"required"`
+	NewSignature  string `json:"new_signature" binding:"required"`
+}
+
+// RotationSignedData returns the bytes both keys sign in a
+// RotateIdentityRequest.
+func RotationSignedData(oldAddr, newAddr, newPublicKey, newSigningKey string) []byte {
+	var buf []byte
+	buf = append(buf, rotationContext...)
+	for _, s := range []string{oldAddr, newAddr, newPublicKey, newSigningKey} {
+		buf = append(buf, s...)
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+const rotationContext = "zerogo identity rotation v1\x00"
+
+// SigningKeyProofData returns the bytes a JoinMessage's SigningKeyProof
+// covers.
+func SigningKeyProofData(nodeAddr, signingKey string) []byte {
+	var buf []byte
+	buf = append(buf, signingKeyProofContext...)
+	for _, s := range []string{nodeAddr, signingKey} {
+		buf = append(buf, s...)
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+const signingKeyProofContext = "zerogo signing key proof v1\x00"
+
+// AgentRequestSignedData returns the bytes an agent signs with its Ed25519
+// signing key to authenticate a REST request to the controller. The
+// signature and timestamp (Unix seconds) go in the X-Signature and
+// X-Timestamp headers, next to X-Node-Address.
+func AgentRequestSignedData(nodeAddr string, timestamp int64, method, path string) []byte {
+	var buf []byte
+	buf = append(buf, agentRequestContext...)
+	for _, s := range []string{nodeAddr, method, path} {
+		buf = append(buf, s...)
+		buf = append(buf, 0)
+	}
+	return binary.BigEndian.AppendUint64(buf, uint64(timestamp))
+}
+
+const agentRequestContext = "zerogo agent request v1\x00"
+
+// RotateIdentityResponse lists the networks whose memberships moved.
+type RotateIdentityResponse struct {
+	Address  string   `json:"address"`
+	Networks []uint32 `json:"networks"`
 }
 
 // LoginRequest is the request body for authentication.
@@ -147,4 +521,201 @@ type LoginRequest struct {
 type LoginResponse struct {
 	Token     string    `json:"token"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// RefreshToken obtains new access tokens via /api/v1/auth/refresh
+	// without logging in again. Empty in refresh responses.
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitzero"`
+
+	// PasswordChangeRequired is set until the user replaces a weak
+	// configured password; admin routes are refused until then.
+	PasswordChangeRequired bool `json:"password_change_required,omitempty"`
+}
+
+// RefreshRequest exchanges a refresh token for a new access token.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// LogoutRequest optionally names a refresh token to revoke along with the
+// access token used for the logout request.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// ChangePasswordRequest replaces the password of the authenticated user.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// NetworkUsage is a network's traffic over a time range, as reported by its
+// members' agents.
+type NetworkUsage struct {
+	NetworkID uint32        `json:"network_id"`
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	BytesSent int64         `json:"bytes_sent"`
+	BytesRecv int64         `json:"bytes_recv"`
+	Members   []MemberUsage `json:"members"`
+}
+
+// MemberUsage is one member's traffic within a NetworkUsage range.
+type MemberUsage struct {
+	NodeAddress string `json:"node_address"`
+	BytesSent   int64  `json:"bytes_sent"`
+	BytesRecv   int64  `json:"bytes_recv"`
+}
+
+// NetworkTopology is a network's mesh as its members' agents last
+// reported it.
+type NetworkTopology struct {
+	NetworkID uint32         `json:"network_id"`
+	Nodes     []TopologyNode `json:"nodes"`
+	Edges     []TopologyEdge `json:"edges"`
+}
+
+// TopologyNode is an authorized member of the network.
+type TopologyNode struct {
+	Address string `json:"address"`
+	Status  string `json:"status"` // connected, isolated or offline
+}
+
+// TopologyEdge is a connection between two members, A < B. An edge only one
+// side reports, e.g. while the other is mid-handshake or has not reported
+// since, is not symmetric.
+type TopologyEdge struct {
+	A         string `json:"a"`
+	B         string `json:"b"`
+	Path      string `json:"path"`                 // "relay" if either side relays, else "direct"
+	LatencyMs int64  `json:"latency_ms,omitempty"` // mean of the sides' measurements
+	Symmetric bool   `json:"symmetric"`
+}
+
+// --- Agent local status types ---
+
+// AgentStatus is served by the agent's local status endpoint.
+type AgentStatus struct {
+	Address     string            `json:"address"`
+	Version     string            `json:"version,omitempty"`
+	AssignedIPs []string          `json:"assigned_ips"`
+	Networks    []string          `json:"networks"`
+	Peers       []AgentPeerStatus `json:"peers"`
+	Rules       []AgentRuleStatus `json:"rules,omitempty"`
+
+	Transport *AgentTransportStats `json:"transport,omitempty"`
+	Switch    *AgentSwitchStats    `json:"switch,omitempty"`
+
+	// ClockOffsetMs is the controller's clock minus the agent's, as of the
+	// last welcome
+	ClockOffsetMs int64 `json:"clock_offset_ms,omitempty"`
+
+	// DeviceError is why the network device could not be created, while
+	// the agent keeps retrying
+	DeviceError string `json:"device_error,omitempty"`
+}
+
+// AgentTransportStats counts the agent's VL1 (underlay UDP) traffic.
+type AgentTransportStats struct {
+	PacketsSent     uint64 `json:"packets_sent"`
+	PacketsReceived uint64 `json:"packets_received"`
+	BytesSent       uint64 `json:"bytes_sent"`
+	BytesReceived   uint64 `json:"bytes_received"`
+	ReadErrors      uint64 `json:"read_errors"`
+	WriteErrors     uint64 `json:"write_errors"`
+	// Drops counts received packets the agent discarded, by reason
+	// (e.g. "decrypt", "unknown_peer")
+	Drops map[string]uint64 `json:"drops,omitempty"`
+}
+
+// AgentSwitchStats counts how the agent's virtual switch forwarded frames.
+type AgentSwitchStats struct {
+	UnicastHits     uint64 `json:"unicast_hits"`
+	UnknownFloods   uint64 `json:"unknown_floods"`
+	BroadcastFloods uint64 `json:"broadcast_floods"`
+	Drops           uint64 `json:"drops"`
+	MACFlaps        uint64 `json:"mac_flaps"`
+	MACTableSize    int    `json:"mac_table_size"`
+}
+
+// AgentRuleStatus reports how often an ACL rule has matched on this agent.
+type AgentRuleStatus struct {
+	ID       uint   `json:"id"`
+	Priority int    `json:"priority"`
+	Action   string `json:"action"`
+	Hits     uint64 `json:"hits"`
+}
+
+// AgentPeerStatus is the agent's local view of one peer.
+type AgentPeerStatus struct {
+	Address   string    `json:"address"`
+	State     string    `json:"state"`
+	LatencyMs int64     `json:"latency_ms"`
+	Path      string    `json:"path"` // "direct", "ice" or "relay"
+	Endpoint  string    `json:"endpoint,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+
+	// Candidates is the recorded reachability of each endpoint tried
+	Candidates []AgentEndpointStatus `json:"candidates,omitempty"`
+
+	// Quality is the measured quality of the current path, once probed
+	Quality *AgentPathQuality `json:"quality,omitempty"`
+}
+
+// AgentPathQuality reports the measured quality of a path to a peer.
+type AgentPathQuality struct {
+	RTTMs   float64 `json:"rtt_ms"`   // moving average
+	Loss    float64 `json:"loss"`     // moving average share of lost probes
+	ScoreMs float64 `json:"score_ms"` // RTT plus loss penalty, lower is better; 0 if never answered
+	Samples int     `json:"samples"`
+}
+
+// AgentEndpointStatus reports how one candidate endpoint of a peer has
+// fared.
+type AgentEndpointStatus struct {
+	Endpoint    string    `json:"endpoint"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+	Failures    int       `json:"failures,omitempty"` // consecutive
+}
+
+// AgentPingResult is the reply of the agent's local ping endpoint.
+type AgentPingResult struct {
+	Peer  string  `json:"peer"`
+	RTTMs float64 `json:"rtt_ms"`
+	Path  string  `json:"path"` // "direct", "ice" or "relay"
+}
+
+// AgentRebindResult is the response of the agent's local rebind endpoint.
+type AgentRebindResult struct {
+	OldPort int `json:"old_port"`
+	Port    int `json:"port"`
+}
+
+// --- Controller event stream types ---
+
+// EventType identifies a controller change event.
+type EventType string
+
+const (
+	EventMemberAuthorized EventType = "member_authorized"
+	EventMemberUpdated    EventType = "member_updated"
+	EventMemberRemoved    EventType = "member_removed"
+	EventNodeOnline       EventType = "node_online"
+	EventNodeOffline      EventType = "node_offline"
+	EventNodeDeleted      EventType = "node_deleted"
+	EventNodeRotated      EventType = "node_rotated"
+	EventNetworkCreated   EventType = "network_created"
+	EventNetworkUpdated   EventType = "network_updated"
+	EventNetworkDeleted   EventType = "network_deleted"
+	EventNetworkRestored  EventType = "network_restored"
+)
+
+// Event is a controller change notification streamed to dashboards.
+type Event struct {
+	Type        EventType   `json:"type"`
+	NetworkID   uint32      `json:"network_id,omitempty"`
+	NodeAddress string      `json:"node_address,omitempty"`
+	Time        time.Time   `json:"time"`
+	Data        interface{} `json:"data,omitempty"`
 }
@@ -0,0 +1,61 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ephemeral credentials follow the TURN REST API scheme (coturn's
+// use-auth-secret): the username is "expiry:nodeaddr" with the expiry in
+// Unix seconds, and the password is base64(HMAC-SHA1(secret, username)).
+// The controller issues them and a relay that shares the secret checks
+// them without storing any per-user state.
+
+var (
+	errUnknownUser       = errors.New("unknown user")
+	errExpiredCredential = errors.New("expired credential")
+)
+
+// EphemeralCredential returns a TURN credential for nodeAddr that expires
+// at expires.
+func EphemeralCredential(secret, nodeAddr string, expires time.Time) (username, password string) {
+	username = strconv.FormatInt(expires.Unix(), 10) + ":" + nodeAddr
+	return username, ephemeralPassword(secret, username)
+}
+
+func ephemeralPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// checkEphemeral returns the password of an ephemeral username that has
+// not expired by now.
+func checkEphemeral(secret, username string, now time.Time) (string, error) {
+	ts, _, ok := strings.Cut(username, ":")
+	if !ok {
+		return "", errUnknownUser
+	}
+	expires, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", errUnknownUser
+	}
+	if now.Unix() >= expires {
+		return "", errExpiredCredential
+	}
+	return ephemeralPassword(secret, username), nil
+}
+
+// statsUser is the name an allocation is counted under: the node address
+// for an ephemeral username, which changes with every credential.
+func statsUser(username string) string {
+	if _, node, ok := strings.Cut(username, ":"); ok {
+		return node
+	}
+	return username
+}
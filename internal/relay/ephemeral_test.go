@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEphemeralCredentials(t *testing.T) {
+	const secret = "relay-secret"
+	s, addr := startTestRelay(t, Config{Secret: secret})
+
+	user, pass := EphemeralCredential(secret, "00000000a1", time.Now().Add(time.Hour))
+	if _, err := allocate(t, addr, user, pass); err != nil {
+		t.Fatalf("valid credential: %v", err)
+	}
+
+	expiredUser, expiredPass := EphemeralCredential(secret, "00000000a2", time.Now().Add(-time.Second))
+	if _, err := allocate(t, addr, expiredUser, expiredPass); err == nil {
+		t.Fatal("expired credential accepted")
+	}
+	otherUser, otherPass := EphemeralCredential("another-secret", "00000000a3", time.Now().Add(time.Hour))
+	if _, err := allocate(t, addr, otherUser, otherPass); err == nil {
+		t.Fatal("credential from another secret accepted")
+	}
+
+	st := s.Stats()
+	if st.AuthFailures != 2 {
+		t.Errorf("auth failures = %d, want 2", st.AuthFailures)
+	}
+	if want := map[string]int{"00000000a1": 1}; !reflect.DeepEqual(st.UserAllocations, want) {
+		t.Errorf("user allocations = %v, want %v", st.UserAllocations, want)
+	}
+}
+
+func TestCheckEphemeral(t *testing.T) {
+	now := time.Unix(1_800_000_000, 0)
+	user, pass := EphemeralCredential("s", "00000000a1", now.Add(time.Minute))
+
+	if got, err := checkEphemeral("s", user, now); err != nil || got != pass {
+		t.Errorf("valid credential: %q, %v", got, err)
+	}
+	if _, err := checkEphemeral("s", user, now.Add(time.Minute)); err != errExpiredCredential {
+		t.Errorf("at expiry: err = %v", err)
+	}
+	for _, bad := range []string{"zerogo", "soon:00000000a1", ""} {
+		if _, err := checkEphemeral("s", bad, now); err != errUnknownUser {
+			t.Errorf("username %q: err = %v", bad, err)
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package relay
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pion/turn/v3"
 )
@@ -16,20 +22,42 @@ type Config struct {
 	Realm       string
 	PublicIP    string            // Public IP for TURN relay address
 	Credentials map[string]string // username → password
+	// Secret validates ephemeral credentials issued by the controller
+	// ("" = static credentials only); see EphemeralCredential
+	Secret string
+	// StatusListen serves activity counters as JSON at /status ("" = off)
+	StatusListen string
 }
 
 // Server runs STUN and TURN services for NAT traversal.
 type Server struct {
 	config     Config
 	turnServer *turn.Server
+	statusSrv  *http.Server
 	log        *slog.Logger
+
+	statsMu      sync.Mutex
+	userAllocs   map[string]int // active allocations per username
+	lastUser     string         // user of the request being handled
+	bytesIn      atomic.Uint64
+	bytesOut     atomic.Uint64
+	authFailures atomic.Uint64
+
+	draining atomic.Bool
 }
 
+// drainPollInterval is how often Drain checks for remaining allocations.
+const drainPollInterval = 500 * time.Millisecond
+
+// errDraining refuses new allocations while the relay drains.
+var errDraining = errors.New("relay is draining")
+
 // New creates a new relay server.
 func New(cfg Config, log *slog.Logger) *Server {
 	return &Server{
-		config: cfg,
-		log:    log.With("component", "relay"),
+		config:     cfg,
+		log:        log.With("component", "relay"),
+		userAllocs: make(map[string]int),
 	}
 }
 
@@ -47,20 +75,17 @@ func (s *Server) Start() error {
 	}
 
 	turnConfig := turn.ServerConfig{
-		Realm: s.config.Realm,
-		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
-			password, ok := s.config.Credentials[username]
-			if !ok {
-				return nil, false
-			}
-			return turn.GenerateAuthKey(username, realm, password), true
-		},
+		Realm:       s.config.Realm,
+		AuthHandler: s.authenticate,
 		PacketConnConfigs: []turn.PacketConnConfig{
 			{
-				PacketConn: udpListener,
-				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
-					RelayAddress: net.ParseIP(publicIP),
-					Address:      "0.0.0.0",
+				PacketConn: &listenerConn{PacketConn: udpListener, s: s},
+				RelayAddressGenerator: &countingGenerator{
+					RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+						RelayAddress: net.ParseIP(publicIP),
+						Address:      "0.0.0.0",
+					},
+					s: s,
 				},
 			},
 		},
@@ -73,6 +98,13 @@ func (s *Server) Start() error {
 	}
 	s.turnServer = turnServer
 
+	if s.config.StatusListen != "" {
+		if err := s.startStatusServer(); err != nil {
+			turnServer.Close()
+			return err
+		}
+	}
+
 	s.log.Info("relay server started",
 		"listen", s.config.ListenAddr,
 		"stun", s.config.STUNEnabled,
@@ -82,8 +114,39 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop shuts down the relay server.
+// Drain stops accepting new allocations and waits until the existing ones
+// are deleted or expire, or until ctx is done. Refreshes keep working, so
+// clients can finish or move to another relay. It returns how many
+// allocations ended during the drain and how many were still active.
+func (s *Server) Drain(ctx context.Context) (drained, remaining int) {
+	if s.turnServer == nil {
+		return 0, 0
+	}
+	s.draining.Store(true)
+	start := s.turnServer.AllocationCount()
+	s.log.Info("draining relay", "allocations", start)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	remaining = start
+	for remaining > 0 {
+		select {
+		case <-ctx.Done():
+			s.log.Warn("relay drain cut short", "drained", start-remaining, "remaining", remaining)
+			return start - remaining, remaining
+		case <-ticker.C:
+			remaining = s.turnServer.AllocationCount()
+		}
+	}
+	s.log.Info("relay drained", "drained", start)
+	return start, 0
+}
+
+// Stop shuts down the relay server, dropping any remaining allocations.
 func (s *Server) Stop() error {
+	if s.statusSrv != nil {
+		s.statusSrv.Close()
+	}
 	if s.turnServer != nil {
 		return s.turnServer.Close()
 	}
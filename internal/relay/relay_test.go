@@ -0,0 +1,193 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v3"
+)
+
+const testRealm = "zerogo.test"
+
+func testLog() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// startTestRelay starts a TURN relay on a free loopback port and returns it
+// with the address it listens on.
+func startTestRelay(t *testing.T, cfg Config) (*Server, string) {
+	t.Helper()
+	probe, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr().String()
+	probe.Close()
+
+	cfg.ListenAddr = addr
+	cfg.PublicIP = "127.0.0.1"
+	cfg.Realm = testRealm
+	cfg.TURNEnabled = true
+	s := New(cfg, testLog())
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Stop() })
+	return s, addr
+}
+
+// allocate makes a TURN allocation on the relay at addr as username.
+func allocate(t *testing.T, addr, username, password string) (net.PacketConn, error) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: addr,
+		TURNServerAddr: addr,
+		Username:       username,
+		Password:       password,
+		Realm:          testRealm,
+		Conn:           conn,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+	if err := client.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	return client.Allocate()
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatusCountsAllocationsAndAuthFailures(t *testing.T) {
+	s, addr := startTestRelay(t, Config{
+		Credentials: map[string]string{"alice": "a-secret", "bob": "b-secret"},
+	})
+
+	alice, err := allocate(t, addr, "alice", "a-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allocate(t, addr, "bob", "b-secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allocate(t, addr, "alice", "wrong"); err == nil {
+		t.Fatal("allocation with a wrong password succeeded")
+	}
+	if _, err := allocate(t, addr, "mallory", "guess"); err == nil {
+		t.Fatal("allocation by an unknown user succeeded")
+	}
+
+	// Relay a datagram each way between alice's allocation and a peer
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	if _, err := alice.WriteTo([]byte("hello"), peer.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, relayed, err := peer.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("peer read %q, %v", buf[:n], err)
+	}
+	if _, err := peer.WriteTo([]byte("hello!"), relayed); err != nil {
+		t.Fatal(err)
+	}
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, _, err := alice.ReadFrom(buf); err != nil || string(buf[:n]) != "hello!" {
+		t.Fatalf("alice read %q, %v", buf[:n], err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleStatus(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var got Stats
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := Stats{
+		Allocations:     2,
+		UserAllocations: map[string]int{"alice": 1, "bob": 1},
+		BytesIn:         6,
+		BytesOut:        5,
+		AuthFailures:    2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("status = %+v, want %+v", got, want)
+	}
+
+	// A deleted allocation no longer counts against its user
+	alice.Close()
+	waitFor(t, "alice's allocation to go", func() bool {
+		st := s.Stats()
+		return st.Allocations == 1 && reflect.DeepEqual(st.UserAllocations, map[string]int{"bob": 1})
+	})
+
+	rec = httptest.NewRecorder()
+	s.handleStatus(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /status: HTTP %d", rec.Code)
+	}
+}
+
+func TestDrainWaitsForAllocations(t *testing.T) {
+	s, addr := startTestRelay(t, Config{
+		Credentials: map[string]string{"alice": "a-secret"},
+	})
+	alice, err := allocate(t, addr, "alice", "a-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An allocation that stays is waited for until the timeout
+	ctx, cancel := context.WithTimeout(t.Context(), 700*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	drained, remaining := s.Drain(ctx)
+	if waited := time.Since(start); waited < 700*time.Millisecond {
+		t.Errorf("drain returned after %v, before its timeout", waited)
+	}
+	if drained != 0 || remaining != 1 {
+		t.Fatalf("drain = %d drained, %d remaining, want 0 and 1", drained, remaining)
+	}
+	if !s.Stats().Draining {
+		t.Error("status does not show the relay draining")
+	}
+	if _, err := allocate(t, addr, "alice", "a-secret"); err == nil {
+		t.Fatal("new allocation accepted while draining")
+	}
+
+	// One that ends during the drain lets it finish early
+	time.AfterFunc(200*time.Millisecond, func() { alice.Close() })
+	drained, remaining = s.Drain(t.Context())
+	if drained != 1 || remaining != 0 {
+		t.Fatalf("drain = %d drained, %d remaining, want 1 and 0", drained, remaining)
+	}
+}
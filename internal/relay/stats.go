@@ -0,0 +1,206 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/turn/v3"
+)
+
+// Stats is a snapshot of the relay's activity.
+type Stats struct {
+	Allocations     int            `json:"allocations"`
+	UserAllocations map[string]int `json:"user_allocations"` // active allocations per TURN username (node address if ephemeral)
+	BytesIn         uint64         `json:"bytes_in"`         // received from peers on relayed addresses
+	BytesOut        uint64         `json:"bytes_out"`        // sent to peers from relayed addresses
+	AuthFailures    uint64         `json:"auth_failures"`    // unknown users, bad passwords, expired credentials
+	Draining        bool           `json:"draining,omitempty"`
+}
+
+// Stats returns the relay's current counters.
+func (s *Server) Stats() Stats {
+	st := Stats{
+		BytesIn:      s.bytesIn.Load(),
+		BytesOut:     s.bytesOut.Load(),
+		AuthFailures: s.authFailures.Load(),
+		Draining:     s.draining.Load(),
+	}
+	if s.turnServer != nil {
+		st.Allocations = s.turnServer.AllocationCount()
+	}
+	s.statsMu.Lock()
+	st.UserAllocations = make(map[string]int, len(s.userAllocs))
+	for user, n := range s.userAllocs {
+		st.UserAllocations[user] = n
+	}
+	s.statsMu.Unlock()
+	return st
+}
+
+// authenticate is the TURN server's auth handler. The TURN server handles
+// the requests of a listener one at a time, so the user it authenticates
+// last is the one an allocation made next belongs to.
+func (s *Server) authenticate(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	password, user, err := s.password(username)
+	if err != nil {
+		s.authFailed(username, srcAddr, err.Error())
+		return nil, false
+	}
+	s.statsMu.Lock()
+	s.lastUser = user
+	s.statsMu.Unlock()
+	return turn.GenerateAuthKey(username, realm, password), true
+}
+
+// password looks username up among the static credentials, then checks it
+// as an ephemeral credential if a secret is configured. It also returns
+// the name the user's allocations are counted under.
+func (s *Server) password(username string) (password, user string, err error) {
+	if password, ok := s.config.Credentials[username]; ok {
+		return password, username, nil
+	}
+	if s.config.Secret == "" {
+		return "", "", errUnknownUser
+	}
+	password, err = checkEphemeral(s.config.Secret, username, time.Now())
+	return password, statsUser(username), err
+}
+
+func (s *Server) authFailed(username string, srcAddr net.Addr, reason string) {
+	s.authFailures.Add(1)
+	s.log.Debug("TURN authentication failed", "user", username, "remote", srcAddr, "reason", reason)
+}
+
+// checkIntegrity counts requests from known users whose message integrity
+// does not verify, i.e. a wrong password. The TURN server rejects them
+// without telling the auth handler.
+func (s *Server) checkIntegrity(b []byte, srcAddr net.Addr) {
+	if !stun.IsMessage(b) {
+		return
+	}
+	m := &stun.Message{Raw: append([]byte(nil), b...)}
+	if m.Decode() != nil || !m.Contains(stun.AttrMessageIntegrity) {
+		return
+	}
+	var username stun.Username
+	var realm stun.Realm
+	if username.GetFrom(m) != nil || realm.GetFrom(m) != nil {
+		return
+	}
+	password, _, err := s.password(username.String())
+	if err != nil {
+		return // counted by authenticate
+	}
+	key := turn.GenerateAuthKey(username.String(), realm.String(), password)
+	if stun.MessageIntegrity(key).Check(m) != nil {
+		s.authFailed(username.String(), srcAddr, "bad message integrity")
+	}
+}
+
+// startStatusServer serves Stats as JSON on Config.StatusListen.
+func (s *Server) startStatusServer() error {
+	ln, err := net.Listen("tcp", s.config.StatusListen)
+	if err != nil {
+		return fmt.Errorf("listen status %s: %w", s.config.StatusListen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	s.statusSrv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := s.statusSrv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Warn("status server stopped", "err", err)
+		}
+	}()
+
+	s.log.Info("status endpoint listening", "addr", ln.Addr())
+	return nil
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Stats())
+}
+
+// listenerConn checks the integrity of requests read by the TURN server.
+type listenerConn struct {
+	net.PacketConn
+	s *Server
+}
+
+func (c *listenerConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		c.s.checkIntegrity(p[:n], addr)
+	}
+	return n, addr, err
+}
+
+// countingGenerator attributes each relayed socket to the user whose
+// request allocated it and counts the bytes relayed through it. It refuses
+// new sockets while the relay drains.
+type countingGenerator struct {
+	turn.RelayAddressGenerator
+	s *Server
+}
+
+func (g *countingGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	if g.s.draining.Load() {
+		return nil, nil, errDraining
+	}
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := g.s
+	s.statsMu.Lock()
+	user := s.lastUser
+	s.userAllocs[user]++
+	s.statsMu.Unlock()
+	return &relayedConn{PacketConn: conn, s: s, user: user}, addr, nil
+}
+
+// relayedConn is an allocation's relayed socket; the TURN server closes it
+// when the allocation expires or is deleted.
+type relayedConn struct {
+	net.PacketConn
+	s     *Server
+	user  string
+	close sync.Once
+}
+
+func (c *relayedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	c.s.bytesIn.Add(uint64(n))
+	return n, addr, err
+}
+
+func (c *relayedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	c.s.bytesOut.Add(uint64(n))
+	return n, err
+}
+
+func (c *relayedConn) Close() error {
+	c.close.Do(func() {
+		c.s.statsMu.Lock()
+		if c.s.userAllocs[c.user]--; c.s.userAllocs[c.user] <= 0 {
+			delete(c.s.userAllocs, c.user)
+		}
+		c.s.statsMu.Unlock()
+	})
+	return c.PacketConn.Close()
+}
@@ -0,0 +1,61 @@
+// Package sdnotify implements the systemd service notification protocol
+// (sd_notify) for Type=notify units. All functions are no-ops when the
+// process was not started by systemd.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states.
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Reloading = "RELOADING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the socket named by $NOTIFY_SOCKET. It returns
+// false without error if the variable is not set.
+func Notify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	if err := send(addr, state); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// send writes one datagram to a unixgram socket. A leading '@' names a
+// socket in the Linux abstract namespace.
+func send(addr, state string) error {
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// WatchdogInterval returns how often to send Watchdog pings: half of
+// $WATCHDOG_USEC, as systemd recommends. It returns 0 if the watchdog is not
+// enabled for this process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
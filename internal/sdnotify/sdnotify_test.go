@@ -0,0 +1,98 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen returns a unixgram socket standing in for systemd's.
+func listen(t *testing.T, name string) *net.UnixConn {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readState(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn := listen(t, path)
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	for _, state := range []string{Ready, Watchdog, Stopping} {
+		sent, err := Notify(state)
+		if !sent || err != nil {
+			t.Fatalf("Notify(%q) = %v, %v", state, sent, err)
+		}
+		if got := readState(t, conn); got != state {
+			t.Fatalf("socket got %q, want %q", got, state)
+		}
+	}
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are Linux only")
+	}
+	name := "zerogo-test-" + strconv.Itoa(os.Getpid())
+	conn := listen(t, "\x00"+name)
+	t.Setenv("NOTIFY_SOCKET", "@"+name)
+
+	if sent, err := Notify(Ready); !sent || err != nil {
+		t.Fatalf("Notify = %v, %v", sent, err)
+	}
+	if got := readState(t, conn); got != Ready {
+		t.Fatalf("socket got %q", got)
+	}
+}
+
+func TestNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Fatalf("Notify = %v, %v, want a silent no-op", sent, err)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if sent, err := Notify(Ready); sent || err == nil {
+		t.Fatalf("Notify to a missing socket = %v, %v", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"garbage", "", 0},
+		{"0", "", 0},
+		{"30000000", "", 15 * time.Second},
+		{"30000000", strconv.Itoa(os.Getpid()), 15 * time.Second},
+		{"30000000", "1", 0}, // meant for another process
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := WatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: interval %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package tap
+
+import (
+	"strconv"
+	"strings"
+)
+
+// agentPrefixes are the names the agent gives the devices it creates, each
+// followed by a number: "zt" for its TAP/TUN device (zt0 by default) and
+// "zgpreflight" for the one preflight creates to test TAP access.
+var agentPrefixes = []string{"zt", "zgpreflight"}
+
+// Link is a TUN/TAP interface present on the host.
+type Link struct {
+	Name     string
+	TUN      bool // layer 3 TUN rather than TAP
+	Attached bool // some process has the device open
+}
+
+// IsAgentDevice reports whether name follows the agent's device naming.
+func IsAgentDevice(name string) bool {
+	for _, prefix := range agentPrefixes {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(rest, 10, 32); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// StaleLinks returns the links in links that the agent would have created
+// but that no process holds open: leftovers from a crashed agent, or
+// persistent devices of an agent that no longer runs.
+func StaleLinks(links []Link) []Link {
+	var stale []Link
+	for _, l := range links {
+		if IsAgentDevice(l.Name) && !l.Attached {
+			stale = append(stale, l)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,78 @@
+//go:build linux && !android
+
+package tap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// iffTUN is the IFF_TUN bit of /sys/class/net/<dev>/tun_flags.
+const iffTUN = 0x0001
+
+// ListLinks returns the TUN/TAP interfaces on the host and whether a
+// process has each open. Finding the holders reads every process's
+// fdinfo, so without root only the caller's own devices show as attached.
+func ListLinks() ([]Link, error) {
+	entries, err := os.ReadDir("/sys/class/net")
+	if err != nil {
+		return nil, fmt.Errorf("list interfaces: %w", err)
+	}
+	attached := attachedLinks()
+
+	var links []Link
+	for _, e := range entries {
+		raw, err := os.ReadFile(filepath.Join("/sys/class/net", e.Name(), "tun_flags"))
+		if err != nil {
+			continue // not a TUN/TAP device
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 0, 32)
+		if err != nil {
+			continue
+		}
+		links = append(links, Link{
+			Name:     e.Name(),
+			TUN:      flags&iffTUN != 0,
+			Attached: attached[e.Name()],
+		})
+	}
+	return links, nil
+}
+
+// attachedLinks returns the names of the TUN/TAP devices open in some
+// process, from the "iff:" line the kernel adds to a tun fd's fdinfo.
+func attachedLinks() map[string]bool {
+	attached := make(map[string]bool)
+	files, _ := filepath.Glob("/proc/[0-9]*/fdinfo/*")
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if name, ok := strings.CutPrefix(scanner.Text(), "iff:"); ok {
+				attached[strings.TrimSpace(name)] = true
+			}
+		}
+		f.Close()
+	}
+	return attached
+}
+
+// DeleteLink removes the interface name.
+func DeleteLink(name string) error {
+	cmd := exec.Command("ip", "link", "delete", name)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("delete %s: %w (stderr: %s)", name, err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux || android
+
+package tap
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// ListLinks is only supported on Linux.
+func ListLinks() ([]Link, error) {
+	return nil, fmt.Errorf("listing TAP devices not supported on %s", runtime.GOOS)
+}
+
+// DeleteLink is only supported on Linux.
+func DeleteLink(name string) error {
+	return fmt.Errorf("deleting TAP devices not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,47 @@
+package tap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsAgentDevice(t *testing.T) {
+	for name, want := range map[string]bool{
+		"zt0":           true,
+		"zt12":          true,
+		"zgpreflight0":  true,
+		"zt":            false,
+		"zgpreflight":   false,
+		"zta":           false,
+		"zt0a":          false,
+		"zt-1":          false,
+		"ztabcdef12":    false, // ZeroTier's naming
+		"tun0":          false,
+		"tap0":          false,
+		"eth0":          false,
+		"zt99999999999": false, // beyond uint32
+	} {
+		if got := IsAgentDevice(name); got != want {
+			t.Errorf("IsAgentDevice(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestStaleLinks(t *testing.T) {
+	links := []Link{
+		{Name: "zt0", Attached: true},          // the running agent's
+		{Name: "zt1"},                          // left by a crashed agent
+		{Name: "zt2", TUN: true},               // a stale TUN device
+		{Name: "zgpreflight0"},                 // left by an interrupted preflight
+		{Name: "tun0"},                         // a VPN client's, not ours
+		{Name: "ztabcdef12"},                   // ZeroTier's
+		{Name: "zgpreflight1", Attached: true}, // a preflight in progress
+	}
+	want := []Link{{Name: "zt1"}, {Name: "zt2", TUN: true}, {Name: "zgpreflight0"}}
+	if got := StaleLinks(links); !reflect.DeepEqual(got, want) {
+		t.Errorf("StaleLinks = %+v, want %+v", got, want)
+	}
+	if got := StaleLinks(nil); got != nil {
+		t.Errorf("StaleLinks(nil) = %+v", got)
+	}
+}
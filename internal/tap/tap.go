@@ -58,3 +58,41 @@ type Device interface {
 	// Close shuts down and removes the TAP device.
 	Close() error
 }
+
+// Options configures how a TAP device is opened.
+type Options struct {
+	// Queues > 1 opens the device with IFF_MULTI_QUEUE (Linux).
+	Queues int
+	// Persistent creates the device with IFF_PERSIST, or reuses the existing
+	// persistent device of that name, and leaves it in place on Close so
+	// addresses and routes survive an agent restart (Linux).
+	Persistent bool
+}
+
+// QueueDevice is implemented by devices that support transmit queue tuning
+// and multiple queues (Linux TAP). It extends Device so existing
+// implementations need not change; callers type-assert for it.
+type QueueDevice interface {
+	Device
+
+	// SetTxQueueLen sets the interface transmit queue length in frames.
+	SetTxQueueLen(n int) error
+
+	// NumQueues returns the number of queues the device was opened with.
+	NumQueues() int
+
+	// ReadQueue reads an Ethernet frame from queue q (0 <= q < NumQueues).
+	// Write spreads frames across all queues.
+	ReadQueue(q int, buf []byte) (int, error)
+}
+
+// PersistentDevice is implemented by devices that can outlive Close.
+type PersistentDevice interface {
+	Device
+
+	// Persistent reports whether Close leaves the interface in place.
+	Persistent() bool
+
+	// Destroy closes the device and deletes the interface.
+	Destroy() error
+}
@@ -78,6 +78,10 @@ func (d *DarwinTAP) SetMACAddress(mac net.HardwareAddr) error {
 }
 
 func (d *DarwinTAP) AddIPAddress(ip net.IP, mask net.IPMask) error {
+	if ip.To4() == nil {
+		ones, _ := mask.Size()
+		return exec.Command("ifconfig", d.name, "inet6", ip.String(), "prefixlen", fmt.Sprint(ones), "alias").Run()
+	}
 	// macOS ifconfig syntax: ifconfig <iface> inet <ip> netmask <mask>
 	maskStr := fmt.Sprintf("%d.%d.%d.%d", mask[0], mask[1], mask[2], mask[3])
 	return exec.Command("ifconfig", d.name, "inet", ip.String(), "netmask", maskStr).Run()
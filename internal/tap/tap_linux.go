@@ -8,6 +8,7 @@ import (
 	"net"
 	"os/exec"
 	"strings"
+	"sync/atomic"
 
 	"github.com/songgao/water"
 )
@@ -16,25 +17,66 @@ import (
 type LinuxTAP struct {
 	iface *water.Interface
 	name  string
+
+	// Multiqueue devices have one file descriptor per queue; iface is
+	// queues[0]. Writes are spread across queues round-robin.
+	queues []*water.Interface
+	nextTx atomic.Uint32
+
+	// persistent devices are left in place by Close; see Destroy.
+	persistent bool
 }
 
 // NewTAP creates a new TAP device.
 // If name is empty, the OS assigns a name.
 func NewTAP(name string) (*LinuxTAP, error) {
+	return NewTAPWithOptions(name, Options{})
+}
+
+// NewMultiQueueTAP creates a TAP device with IFF_MULTI_QUEUE and the given
+// number of queues, so reads and writes can run in parallel (Linux 3.8+).
+func NewMultiQueueTAP(name string, queues int) (*LinuxTAP, error) {
+	return NewTAPWithOptions(name, Options{Queues: queues})
+}
+
+// NewTAPWithOptions creates a TAP device, or attaches to the persistent
+// device of that name if one exists.
+func NewTAPWithOptions(name string, opts Options) (*LinuxTAP, error) {
+	queues := opts.Queues
+	if queues == 0 {
+		queues = 1
+	}
+	if queues < 0 {
+		return nil, fmt.Errorf("invalid TAP queue count %d", queues)
+	}
 	config := water.Config{
 		DeviceType: water.TAP,
 	}
-	if name != "" {
-		config.Name = name
-	}
-	iface, err := water.New(config)
-	if err != nil {
-		return nil, fmt.Errorf("create TAP device: %w", err)
+	config.Name = name
+	config.MultiQueue = queues > 1
+	config.Persist = opts.Persistent
+
+	d := &LinuxTAP{persistent: opts.Persistent}
+	for i := 0; i < queues; i++ {
+		iface, err := water.New(config)
+		if err != nil {
+			for _, q := range d.queues {
+				q.Close()
+			}
+			if queues > 1 {
+				return nil, fmt.Errorf("open TAP queue %d: %w", i, err)
+			}
+			return nil, fmt.Errorf("create TAP device: %w", err)
+		}
+		if i == 0 {
+			// Further queues attach to the interface the kernel named
+			d.iface = iface
+			d.name = iface.Name()
+			config.Name = d.name
+		}
+		d.queues = append(d.queues, iface)
 	}
-	return &LinuxTAP{
-		iface: iface,
-		name:  iface.Name(),
-	}, nil
+	return d, nil
 }
 
 func (d *LinuxTAP) IsTUN() bool { return false }
@@ -48,9 +90,36 @@ func (d *LinuxTAP) Read(buf []byte) (int, error) {
 }
 
 func (d *LinuxTAP) Write(buf []byte) (int, error) {
+	if len(d.queues) > 1 {
+		q := d.nextTx.Add(1) % uint32(len(d.queues))
+		return d.queues[q].Write(buf)
+	}
 	return d.iface.Write(buf)
 }
 
+// NumQueues returns the number of device queues.
+func (d *LinuxTAP) NumQueues() int {
+	return len(d.queues)
+}
+
+// ReadQueue reads a frame from queue q. The kernel spreads outgoing flows
+// across queues, so each queue needs its own reader.
+func (d *LinuxTAP) ReadQueue(q int, buf []byte) (int, error) {
+	return d.queues[q].Read(buf)
+}
+
+// SetTxQueueLen sets the interface transmit queue length. The default of
+// 1000 frames drops bursts under high throughput.
+func (d *LinuxTAP) SetTxQueueLen(n int) error {
+	cmd := exec.Command("ip", "link", "set", "dev", d.name, "txqueuelen", fmt.Sprintf("%d", n))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("set txqueuelen to %d: %w (stderr: %s)", n, err, stderr.String())
+	}
+	return nil
+}
+
 func (d *LinuxTAP) SetMTU(mtu int) error {
 	cmd := exec.Command("ip", "link", "set", "dev", d.name, "mtu", fmt.Sprintf("%d", mtu))
 	var stderr bytes.Buffer
@@ -92,7 +161,12 @@ func (d *LinuxTAP) AddIPAddress(ip net.IP, mask net.IPMask) error {
 	ones, bits := mask.Size()
 	cidr := fmt.Sprintf("%s/%d", ip.String(), ones)
 
-	cmd := exec.Command("ip", "addr", "add", cidr, "dev", d.name)
+	// A persistent device may still carry the address from the last run
+	op := "add"
+	if d.persistent {
+		op = "replace"
+	}
+	cmd := exec.Command("ip", "addr", op, cidr, "dev", d.name)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
@@ -223,13 +297,37 @@ func (d *LinuxTAP) SetPeerARP(ip net.IP, mac net.HardwareAddr) error {
 	return nil
 }
 
-// Close closes the TAP device and cleans up resources.
+// Close closes the TAP device. A non-persistent device is deleted; a
+// persistent one keeps its addresses and routes for the next run.
 func (d *LinuxTAP) Close() error {
+	if !d.persistent {
+		d.deleteLink()
+	}
+	return d.closeQueues()
+}
+
+// Persistent reports whether the device outlives Close.
+func (d *LinuxTAP) Persistent() bool {
+	return d.persistent
+}
+
+// Destroy closes the device and deletes the interface, even if persistent.
+func (d *LinuxTAP) Destroy() error {
+	d.deleteLink()
+	return d.closeQueues()
+}
+
+func (d *LinuxTAP) deleteLink() {
 	// Try to delete the interface, but don't fail if it doesn't exist
 	cmd := exec.Command("ip", "link", "delete", d.name)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	_ = cmd.Run() // Ignore error - interface might already be gone
+}
 
+func (d *LinuxTAP) closeQueues() error {
+	for _, q := range d.queues[1:] {
+		q.Close()
+	}
 	return d.iface.Close()
 }
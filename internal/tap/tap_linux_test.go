@@ -0,0 +1,149 @@
+//go:build linux && !android
+
+package tap
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var testTAPSeq atomic.Uint32
+
+// testTAPName returns an interface name unique to this test process.
+func testTAPName() string {
+	return fmt.Sprintf("zgt%d_%d", os.Getpid()%100000, testTAPSeq.Add(1))
+}
+
+// newTestTAP opens a TAP device with opts, skipping the test where TAP
+// devices cannot be created (no root or no /dev/net/tun).
+func newTestTAP(tb testing.TB, name string, opts Options) *LinuxTAP {
+	tb.Helper()
+	d, err := NewTAPWithOptions(name, opts)
+	if err != nil {
+		tb.Skipf("cannot create TAP devices here: %v", err)
+	}
+	return d
+}
+
+// linkAttr reads an attribute of a network interface from sysfs.
+func linkAttr(t *testing.T, name, attr string) string {
+	t.Helper()
+	b, err := os.ReadFile("/sys/class/net/" + name + "/" + attr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func TestMultiQueueTAP(t *testing.T) {
+	d := newTestTAP(t, testTAPName(), Options{Queues: 4})
+	defer d.Close()
+
+	var dev Device = d
+	qd, ok := dev.(QueueDevice)
+	if !ok {
+		t.Fatal("LinuxTAP is not a QueueDevice")
+	}
+	if n := qd.NumQueues(); n != 4 {
+		t.Fatalf("NumQueues = %d, want 4", n)
+	}
+	if err := qd.SetTxQueueLen(5000); err != nil {
+		t.Fatal(err)
+	}
+	if got := linkAttr(t, d.Name(), "tx_queue_len"); got != "5000" {
+		t.Errorf("tx_queue_len = %s, want 5000", got)
+	}
+	if _, err := NewTAPWithOptions(testTAPName(), Options{Queues: -1}); err == nil {
+		t.Error("negative queue count accepted")
+	}
+}
+
+// benchFrame is a broadcast frame of an unused EtherType, which the kernel
+// drops after the TAP hands it over.
+func benchFrame() []byte {
+	frame := make([]byte, 1400)
+	copy(frame, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0, 0, 0, 0, 1, 0x88, 0xb5})
+	return frame
+}
+
+// BenchmarkTAPWrite compares frames written per second through one queue
+// with writes spread over several, from parallel writers.
+func BenchmarkTAPWrite(b *testing.B) {
+	for _, queues := range []int{1, 4} {
+		b.Run(fmt.Sprintf("queues=%d", queues), func(b *testing.B) {
+			d := newTestTAP(b, testTAPName(), Options{Queues: queues})
+			defer d.Close()
+			if err := d.SetUp(); err != nil {
+				b.Fatal(err)
+			}
+			frame := benchFrame()
+			b.SetBytes(int64(len(frame)))
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := d.Write(frame); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func linkExists(name string) bool {
+	_, err := os.Stat("/sys/class/net/" + name)
+	return err == nil
+}
+
+func TestPersistentTAPSurvivesClose(t *testing.T) {
+	name := testTAPName()
+	d := newTestTAP(t, name, Options{Persistent: true})
+	t.Cleanup(func() { exec.Command("ip", "link", "delete", name).Run() })
+	ip, mask := net.ParseIP("10.251.0.1"), net.CIDRMask(24, 32)
+	if err := d.AddIPAddress(ip, mask); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !linkExists(name) {
+		t.Fatal("persistent device deleted on Close")
+	}
+	out, err := exec.Command("ip", "-o", "addr", "show", "dev", name).Output()
+	if err != nil || !strings.Contains(string(out), "10.251.0.1/24") {
+		t.Fatalf("address lost on Close: %s %v", out, err)
+	}
+
+	// The next run attaches to the same device and may set the address again
+	d, err = NewTAPWithOptions(name, Options{Persistent: true})
+	if err != nil {
+		t.Fatalf("reopen persistent device: %v", err)
+	}
+	if d.Name() != name || !d.Persistent() {
+		t.Fatalf("reopened %q, persistent %v", d.Name(), d.Persistent())
+	}
+	if err := d.AddIPAddress(ip, mask); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	if linkExists(name) {
+		t.Fatal("device left in place by Destroy")
+	}
+}
+
+func TestTAPDeletedOnClose(t *testing.T) {
+	d := newTestTAP(t, testTAPName(), Options{})
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if linkExists(d.Name()) {
+		t.Fatal("non-persistent device left in place by Close")
+	}
+}
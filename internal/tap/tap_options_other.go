@@ -0,0 +1,22 @@
+//go:build !linux || android
+
+package tap
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NewMultiQueueTAP is only supported on Linux.
+func NewMultiQueueTAP(name string, queues int) (Device, error) {
+	return nil, fmt.Errorf("multiqueue TAP devices not supported on %s", runtime.GOOS)
+}
+
+// NewTAPWithOptions creates a TAP device. Multiqueue and persistent devices
+// are only supported on Linux.
+func NewTAPWithOptions(name string, opts Options) (Device, error) {
+	if opts.Queues > 1 || opts.Persistent {
+		return nil, fmt.Errorf("TAP options not supported on %s", runtime.GOOS)
+	}
+	return NewTAP(name)
+}
@@ -78,6 +78,17 @@ func (d *WindowsTAP) SetMACAddress(mac net.HardwareAddr) error {
 }
 
 func (d *WindowsTAP) AddIPAddress(ip net.IP, mask net.IPMask) error {
+	if ip.To4() == nil {
+		ones, _ := mask.Size()
+		cmd := exec.Command("netsh", "interface", "ipv6", "add", "address",
+			d.name, fmt.Sprintf("%s/%d", ip, ones))
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("set IPv6 %s/%d on %s: %w (stderr: %s)", ip, ones, d.name, err, stderr.String())
+		}
+		return nil
+	}
 	if len(mask) < 4 {
 		return fmt.Errorf("invalid mask length: %d (expected at least 4 bytes)", len(mask))
 	}
@@ -141,6 +141,9 @@ func (d *DarwinTUN) SetMACAddress(mac net.HardwareAddr) error {
 }
 
 func (d *DarwinTUN) AddIPAddress(ip net.IP, mask net.IPMask) error {
+	if ip.To4() == nil {
+		return d.addIPv6Address(ip, mask)
+	}
 	if len(mask) < 4 {
 		return fmt.Errorf("invalid mask length: %d (expected at least 4 bytes)", len(mask))
 	}
@@ -172,6 +175,27 @@ func (d *DarwinTUN) AddIPAddress(ip net.IP, mask net.IPMask) error {
 	return nil
 }
 
+// addIPv6Address assigns an IPv6 address and routes its prefix via the
+// interface, which utun does not do by itself.
+func (d *DarwinTUN) addIPv6Address(ip net.IP, mask net.IPMask) error {
+	ones, _ := mask.Size()
+	cmd := exec.Command("ifconfig", d.name, "inet6", ip.String(), "prefixlen", fmt.Sprint(ones), "alias")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("set IPv6 %s/%d on %s: %w (stderr: %s)", ip, ones, d.name, err, stderr.String())
+	}
+
+	cidr := fmt.Sprintf("%s/%d", ip.Mask(mask), ones)
+	_ = exec.Command("route", "-n", "delete", "-inet6", cidr).Run()
+	cmd = exec.Command("route", "-n", "add", "-inet6", cidr, "-interface", d.name)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("add subnet route %s via %s: %w (stderr: %s)", cidr, d.name, err, stderr.String())
+	}
+	return nil
+}
+
 func (d *DarwinTUN) SetUp() error {
 	cmd := exec.Command("ifconfig", d.name, "up")
 	var stderr bytes.Buffer
@@ -0,0 +1,11 @@
+//go:build !android
+
+package tap
+
+import "fmt"
+
+// NewTUNFromFD is only available on Android, where the TUN descriptor comes
+// from VpnService.
+func NewTUNFromFD(fd int, name string) (Device, error) {
+	return nil, fmt.Errorf("TUN from a file descriptor is only supported on Android")
+}
@@ -0,0 +1,111 @@
+package vl1
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// ControlType identifies a message carried in an encrypted PacketTypeControl
+// payload.
+type ControlType uint8
+
+const (
+	ControlPing ControlType = 0x01
+	ControlPong ControlType = 0x02
+)
+
+// controlSize is the encoded size of a ControlMessage: type + 8-byte ID.
+const controlSize = 1 + 8
+
+// ControlMessage is an agent-to-agent control message.
+type ControlMessage struct {
+	Type ControlType
+	ID   uint64
+}
+
+// Encode serializes the message.
+func (m ControlMessage) Encode() []byte {
+	buf := make([]byte, controlSize)
+	buf[0] = byte(m.Type)
+	binary.BigEndian.PutUint64(buf[1:], m.ID)
+	return buf
+}
+
+// DecodeControl parses a control message.
+func DecodeControl(b []byte) (ControlMessage, error) {
+	if len(b) < controlSize {
+		return ControlMessage{}, errors.New("control message too short")
+	}
+	return ControlMessage{Type: ControlType(b[0]), ID: binary.BigEndian.Uint64(b[1:controlSize])}, nil
+}
+
+// Pinger measures round-trip time to peers with control ping/pong messages.
+// Unlike keepalives these travel encrypted, so a pong proves the peer holds
+// the session keys and the whole path works, without needing the TAP device.
+type Pinger struct {
+	seq     atomic.Uint64
+	pending sync.Map // ping ID → *pendingPing
+}
+
+type pendingPing struct {
+	peer identity.Address
+	done chan time.Time
+}
+
+// Ping sends a ping to peer using send and waits for the matching pong or
+// for ctx to end.
+func (p *Pinger) Ping(ctx context.Context, peer identity.Address, send func(payload []byte) error) (time.Duration, error) {
+	id := p.seq.Add(1)
+	pp := &pendingPing{peer: peer, done: make(chan time.Time, 1)}
+	p.pending.Store(id, pp)
+	defer p.pending.Delete(id)
+
+	start := time.Now()
+	if err := send(ControlMessage{Type: ControlPing, ID: id}.Encode()); err != nil {
+		return 0, fmt.Errorf("send ping: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case at := <-pp.done:
+		return at.Sub(start), nil
+	}
+}
+
+// HandleControl processes a decrypted control payload from peer. It returns
+// the payload to send back, if any.
+func (p *Pinger) HandleControl(peer identity.Address, payload []byte) ([]byte, error) {
+	msg, err := DecodeControl(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg.Type {
+	case ControlPing:
+		return ControlMessage{Type: ControlPong, ID: msg.ID}.Encode(), nil
+	case ControlPong:
+		v, ok := p.pending.Load(msg.ID)
+		if !ok {
+			return nil, nil // late or unsolicited
+		}
+		pp := v.(*pendingPing)
+		if pp.peer != peer {
+			return nil, fmt.Errorf("pong %d from %s, expected %s", msg.ID, peer, pp.peer)
+		}
+		select {
+		case pp.done <- time.Now():
+		default:
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown control type 0x%02x", uint8(msg.Type))
+	}
+}
@@ -0,0 +1,82 @@
+package vl1
+
+import (
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// peerEventQueue is how many state changes may wait for subscribers; more
+// are dropped so a slow subscriber never blocks the data path.
+const peerEventQueue = 256
+
+// PeerEvent is a peer's transition from one state to another.
+type PeerEvent struct {
+	Peer    *Peer
+	Address identity.Address
+	From    PeerState
+	To      PeerState
+	At      time.Time
+}
+
+// Connected reports whether the peer just became connected.
+func (e PeerEvent) Connected() bool {
+	return e.To == PeerStateConnected && e.From != PeerStateConnected
+}
+
+// Disconnected reports whether the peer just lost its connection.
+func (e PeerEvent) Disconnected() bool {
+	return e.From == PeerStateConnected && e.To != PeerStateConnected
+}
+
+// Subscribe registers fn to be called for every peer state change, in the
+// order they happen. Calls come from a dispatcher goroutine, never from the
+// code changing the state, so fn may block briefly but should not stall:
+// events arriving while the queue is full are dropped.
+func (pm *PeerManager) Subscribe(fn func(PeerEvent)) {
+	pm.eventsMu.Lock()
+	defer pm.eventsMu.Unlock()
+	pm.subscribers = append(pm.subscribers, fn)
+	if pm.events == nil {
+		pm.events = make(chan PeerEvent, peerEventQueue)
+		go pm.dispatchEvents(pm.events)
+	}
+}
+
+// peerStateChanged queues an event for subscribers. It is installed as each
+// peer's state hook and runs with the peer's lock held, so it must not
+// block or take pm.mu (which is acquired before peer locks).
+func (pm *PeerManager) peerStateChanged(p *Peer, from, to PeerState) {
+	pm.eventsMu.RLock()
+	events := pm.events
+	pm.eventsMu.RUnlock()
+	if events == nil {
+		return
+	}
+	select {
+	case events <- PeerEvent{Peer: p, Address: p.Address, From: from, To: to, At: time.Now()}:
+	default:
+		pm.log.Warn("peer event queue full, dropping event", "peer", p.Address, "from", from, "to", to)
+	}
+}
+
+func (pm *PeerManager) dispatchEvents(events <-chan PeerEvent) {
+	for ev := range events {
+		pm.eventsMu.RLock()
+		subscribers := pm.subscribers
+		pm.eventsMu.RUnlock()
+		for _, fn := range subscribers {
+			fn(ev)
+		}
+	}
+}
+
+// setStateLocked moves the peer to state and tells the state hook about
+// the change. Called with p.mu held.
+func (p *Peer) setStateLocked(state PeerState) {
+	from := p.State
+	p.State = state
+	if from != state && p.stateHook != nil {
+		p.stateHook(p, from, state)
+	}
+}
@@ -0,0 +1,52 @@
+package vl1
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeerEventsFireOncePerTransition(t *testing.T) {
+	pm := NewPeerManager(testLog())
+	events := make(chan PeerEvent, 16)
+	pm.Subscribe(func(ev PeerEvent) { events <- ev })
+	key, addr := testKey(t)
+	p := pm.AddPeer(addr, key, udpAddr(t, "192.0.2.1:9993"))
+
+	p.StartHandshake()
+	p.SetCipher(testNetwork, NewNoiseCipher([32]byte{1}, [32]byte{2}))
+	// Neither another session nor a second handshake start is a transition
+	p.SetCipher(testNetwork+1, NewNoiseCipher([32]byte{3}, [32]byte{4}))
+	p.StartHandshake()
+	p.MarkDead()
+
+	want := []struct{ from, to PeerState }{
+		{PeerStateNew, PeerStateHandshake},
+		{PeerStateHandshake, PeerStateConnected},
+		{PeerStateConnected, PeerStateDead},
+	}
+	connected := 0
+	for i, w := range want {
+		select {
+		case ev := <-events:
+			if ev.Peer != p || ev.Address != addr || ev.From != w.from || ev.To != w.to || ev.At.IsZero() {
+				t.Fatalf("event %d = %v → %v for %v, want %v → %v", i, ev.From, ev.To, ev.Address, w.from, w.to)
+			}
+			if ev.Connected() {
+				connected++
+			}
+			if ev.Disconnected() != (w.from == PeerStateConnected) {
+				t.Fatalf("event %d: Disconnected = %v", i, ev.Disconnected())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d (%v → %v) not delivered", i, w.from, w.to)
+		}
+	}
+	if connected != 1 {
+		t.Fatalf("%d connected events, want 1", connected)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %v → %v", ev.From, ev.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
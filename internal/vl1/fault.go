@@ -0,0 +1,90 @@
+package vl1
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fault describes how a DroppingTransport degrades outgoing packets.
+type Fault struct {
+	Loss    float64       // fraction of packets silently dropped (0 = none, 1 = all)
+	Latency time.Duration // delay before a packet is handed to the wrapped transport
+}
+
+// DroppingTransport wraps a Transport and drops or delays the packets sent
+// through it, to exercise keepalive timeouts and relay fallback without a
+// real lossy network. Faults apply to sends only; wrap both ends of a path
+// to make it symmetric, or one end to simulate an asymmetric NAT.
+type DroppingTransport struct {
+	Transport
+
+	mu        sync.RWMutex
+	fault     Fault            // for destinations without their own fault
+	endpoints map[string]Fault // destination ip:port → fault
+
+	dropped atomic.Uint64
+}
+
+// NewDroppingTransport wraps inner, applying fault to every destination.
+func NewDroppingTransport(inner Transport, fault Fault) *DroppingTransport {
+	return &DroppingTransport{
+		Transport: inner,
+		fault:     fault,
+		endpoints: make(map[string]Fault),
+	}
+}
+
+// SetFault changes the fault for destinations without their own.
+func (d *DroppingTransport) SetFault(f Fault) {
+	d.mu.Lock()
+	d.fault = f
+	d.mu.Unlock()
+}
+
+// SetEndpointFault applies f to packets sent to addr only.
+func (d *DroppingTransport) SetEndpointFault(addr *net.UDPAddr, f Fault) {
+	d.mu.Lock()
+	d.endpoints[addr.String()] = f
+	d.mu.Unlock()
+}
+
+// ClearEndpointFault makes addr use the default fault again.
+func (d *DroppingTransport) ClearEndpointFault(addr *net.UDPAddr) {
+	d.mu.Lock()
+	delete(d.endpoints, addr.String())
+	d.mu.Unlock()
+}
+
+// Dropped returns the number of packets dropped so far.
+func (d *DroppingTransport) Dropped() uint64 {
+	return d.dropped.Load()
+}
+
+// SendTo drops, delays or forwards data according to the fault for addr. A
+// dropped packet is not an error, as with a real lossy path.
+func (d *DroppingTransport) SendTo(data []byte, addr *net.UDPAddr) error {
+	d.mu.RLock()
+	f, ok := d.endpoints[addr.String()]
+	if !ok {
+		f = d.fault
+	}
+	d.mu.RUnlock()
+
+	if f.Loss > 0 && rand.Float64() < f.Loss {
+		d.dropped.Add(1)
+		return nil
+	}
+	if f.Latency > 0 {
+		// Callers reuse their buffers once SendTo returns
+		buf := bytes.Clone(data)
+		time.AfterFunc(f.Latency, func() {
+			d.Transport.SendTo(buf, addr)
+		})
+		return nil
+	}
+	return d.Transport.SendTo(data, addr)
+}
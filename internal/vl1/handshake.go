@@ -0,0 +1,178 @@
+package vl1
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HandshakeType is the first byte of a handshake packet's payload and says
+// which message follows, so the PSK hello and the Noise messages, which
+// carry their type in the same byte, cannot be mistaken for each other.
+type HandshakeType uint8
+
+const (
+	HandshakeHello    HandshakeType = 0                    // PSK hello: public key, flags and timestamp
+	HandshakeInit     HandshakeType = handshakeMsgInit     // Noise IK initiation
+	HandshakeResponse HandshakeType = handshakeMsgResponse // Noise IK response
+	HandshakeCookie   HandshakeType = 3                    // cookie reply from a responder under load
+)
+
+func (t HandshakeType) String() string {
+	switch t {
+	case HandshakeHello:
+		return "hello"
+	case HandshakeInit:
+		return "init"
+	case HandshakeResponse:
+		return "response"
+	case HandshakeCookie:
+		return "cookie"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// HandshakeCookieSize is the size of a cookie reply: type, nonce and the
+// encrypted cookie with its tag.
+const HandshakeCookieSize = 1 + 24 + 16 + NoiseTagSize
+
+// HelloSize is the size of a hello: type, public key, flags, the sender's
+// session nonce and the time it was sent.
+const HelloSize = 1 + 32 + 1 + SessionNonceSize + timestampSize
+
+var (
+	// ErrShortHandshake rejects a handshake payload too short for its type.
+	ErrShortHandshake = errors.New("handshake message too short")
+
+	// ErrUnknownHandshake rejects a handshake payload of a type this
+	// version does not define.
+	ErrUnknownHandshake = errors.New("unknown handshake type")
+)
+
+// HelloFlagAwaitingReply, sent in the flags byte of a hello, marks the
+// sender as still handshaking: the receiver answers it even on an unchanged
+// path, since the sender retries until it hears back. Hellos from connected
+// peers leave it clear, so replies never bounce back and forth.
+const HelloFlagAwaitingReply byte = 0x01
+
+// ParseHandshakeType returns the type of a handshake payload, checking that
+// the payload is long enough for it. Unknown types are ErrUnknownHandshake.
+func ParseHandshakeType(payload []byte) (HandshakeType, error) {
+	if len(payload) == 0 {
+		return 0, ErrShortHandshake
+	}
+	t := HandshakeType(payload[0])
+	var min int
+	switch t {
+	case HandshakeHello:
+		min = HelloSize
+	case HandshakeInit:
+		min = HandshakeInitiationSize
+	case HandshakeResponse:
+		min = HandshakeResponseSize
+	case HandshakeCookie:
+		min = HandshakeCookieSize
+	default:
+		return t, fmt.Errorf("%w: %d", ErrUnknownHandshake, payload[0])
+	}
+	if len(payload) < min {
+		return t, fmt.Errorf("%w: %s is %d bytes, need %d", ErrShortHandshake, t, len(payload), min)
+	}
+	return t, nil
+}
+
+// NewHelloPayload returns a hello carrying pubKey, flags and the session
+// nonce the sender keys its next session with (see Peer.HelloNonce), sent at
+// now by the sender's clock.
+func NewHelloPayload(pubKey [32]byte, flags byte, nonce SessionNonce, now time.Time) []byte {
+	payload := make([]byte, 0, HelloSize)
+	payload = append(payload, byte(HandshakeHello))
+	payload = append(payload, pubKey[:]...)
+	payload = append(payload, flags)
+	payload = append(payload, nonce[:]...)
+	return appendTimestamp(payload, now)
+}
+
+// ParseHello returns the public key, flags, session nonce and send time of a
+// hello payload, which ParseHandshakeType has checked. The receiver judges
+// the send time with CheckClockSkew.
+func ParseHello(payload []byte) (pubKey [32]byte, flags byte, nonce SessionNonce, sent time.Time) {
+	copy(pubKey[:], payload[1:33])
+	flags = payload[33]
+	copy(nonce[:], payload[34:34+SessionNonceSize])
+	return pubKey, flags, nonce, parseTimestamp(payload[34+SessionNonceSize : HelloSize])
+}
+
+// HandshakeStep is what a handshake in progress needs next.
+type HandshakeStep int
+
+const (
+	HandshakeWait    HandshakeStep = iota // initiation sent recently, or no handshake running
+	HandshakeRetry                        // resend the initiation
+	HandshakeExpired                      // unanswered for too long; the peer is now dead
+)
+
+// HandshakeDeadline returns how long a handshake may go unanswered before
+// the peer is given up: HandshakeTimeout, or three retries if the retry
+// interval is configured longer than that allows.
+func (t Timers) HandshakeDeadline() time.Duration {
+	t = t.WithDefaults()
+	return max(HandshakeTimeout, 3*t.HandshakeRetryInterval)
+}
+
+// StartHandshake moves a peer that is not connected into PeerStateHandshake,
+// counting the initiation the caller sends next as the first attempt. On a
+// peer already handshaking (e.g. when a new path opens) it restarts the
+// deadline.
+func (p *Peer) StartHandshake() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.State == PeerStateConnected && p.hasSessionLocked() {
+		return
+	}
+	now := time.Now()
+	p.setStateLocked(PeerStateHandshake)
+	p.HandshakeAt = now
+	p.handshakeSent = now
+	p.handshakeAttempts = 1
+}
+
+// HandshakeTick advances a handshake in progress: it asks for a retry once
+// retryInterval has passed since the last initiation, and marks the peer
+// dead once timeout has passed since the handshake started. A peer that
+// connected in the meantime has nothing to do.
+func (p *Peer) HandshakeTick(retryInterval, timeout time.Duration) HandshakeStep {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.State != PeerStateHandshake {
+		return HandshakeWait
+	}
+	now := time.Now()
+	if now.Sub(p.HandshakeAt) >= timeout {
+		p.setStateLocked(PeerStateDead)
+		return HandshakeExpired
+	}
+	if now.Sub(p.handshakeSent) < retryInterval {
+		return HandshakeWait
+	}
+	p.handshakeSent = now
+	p.handshakeAttempts++
+	return HandshakeRetry
+}
+
+// HandshakeAttempts returns how many initiations the current (or last)
+// handshake sent.
+func (p *Peer) HandshakeAttempts() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.handshakeAttempts
+}
+
+// MarkDead moves the peer to PeerStateDead; CleanDead removes it once it
+// has also gone unseen for the peer timeout.
+func (p *Peer) MarkDead() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setStateLocked(PeerStateDead)
+}
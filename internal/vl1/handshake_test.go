@@ -0,0 +1,212 @@
+package vl1
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+func TestHelloRoundTrip(t *testing.T) {
+	pub, _ := testKey(t)
+	sent := time.Unix(1700000000, 123456789)
+	nonce := NewSessionNonce()
+	payload := NewHelloPayload(pub, HelloFlagAwaitingReply, nonce, sent)
+	if len(payload) != HelloSize {
+		t.Fatalf("hello is %d bytes, want %d", len(payload), HelloSize)
+	}
+	if typ, err := ParseHandshakeType(payload); err != nil || typ != HandshakeHello {
+		t.Fatalf("ParseHandshakeType = %v, %v", typ, err)
+	}
+	gotPub, flags, gotNonce, gotSent := ParseHello(payload)
+	if gotPub != pub || flags != HelloFlagAwaitingReply || gotNonce != nonce || !gotSent.Equal(sent) {
+		t.Fatalf("ParseHello = %x, %#x, %x, %v", gotPub[:4], flags, gotNonce, gotSent)
+	}
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	now := time.Now()
+	tol := DefaultMaxClockSkew
+	tests := []struct {
+		skew time.Duration
+		ok   bool
+		msg  string
+	}{
+		{0, true, ""},
+		{tol - time.Second, true, ""},
+		{-(tol - time.Second), true, ""},
+		{tol + time.Minute, false, "6m0s ahead of ours"},
+		{-2 * time.Hour, false, "2h0m0s behind ours"},
+	}
+	for _, tt := range tests {
+		err := CheckClockSkew(now.Add(tt.skew), now, tol)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("skew %v: %v", tt.skew, err)
+			}
+			continue
+		}
+		var skewErr *ClockSkewError
+		if !errors.As(err, &skewErr) || !errors.Is(err, ErrClockSkew) {
+			t.Fatalf("skew %v: err = %v, want a ClockSkewError", tt.skew, err)
+		}
+		if skewErr.Skew != tt.skew || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("skew %v: %v", tt.skew, err)
+		}
+	}
+}
+
+// testNoiseMessages returns a Noise IK initiation and its response.
+func testNoiseMessages(t *testing.T) (init, resp []byte) {
+	t.Helper()
+	initiator, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	psk := [32]byte{1}
+	hs := NewNoiseHandshake(initiator.PrivateKey, initiator.PublicKey, responder.PublicKey, psk, testNetwork)
+	if init, err = hs.CreateInitiation(); err != nil {
+		t.Fatal(err)
+	}
+	rs := NewNoiseHandshake(responder.PrivateKey, responder.PublicKey, [32]byte{}, psk, testNetwork)
+	if err := rs.ConsumeInitiation(init); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = rs.CreateResponse(); err != nil {
+		t.Fatal(err)
+	}
+	if len(init) != HandshakeInitiationSize || len(resp) != HandshakeResponseSize {
+		t.Fatalf("messages are %d and %d bytes, want %d and %d", len(init), len(resp), HandshakeInitiationSize, HandshakeResponseSize)
+	}
+	if err := hs.ConsumeResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+	return init, resp
+}
+
+func TestParseHandshakeType(t *testing.T) {
+	pub, _ := testKey(t)
+	hello := NewHelloPayload(pub, 0, NewSessionNonce(), time.Now())
+	init, resp := testNoiseMessages(t)
+	cookie := make([]byte, HandshakeCookieSize)
+	cookie[0] = byte(HandshakeCookie)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		typ     HandshakeType
+		err     error
+	}{
+		{"hello", hello, HandshakeHello, nil},
+		{"noise initiation", init, HandshakeInit, nil},
+		{"noise response", resp, HandshakeResponse, nil},
+		{"cookie", cookie, HandshakeCookie, nil},
+		{"empty", nil, 0, ErrShortHandshake},
+		{"truncated hello", hello[:HelloSize-1], HandshakeHello, ErrShortHandshake},
+		{"truncated initiation", init[:HandshakeInitiationSize-1], HandshakeInit, ErrShortHandshake},
+		{"truncated response", resp[:HandshakeResponseSize-1], HandshakeResponse, ErrShortHandshake},
+		{"truncated cookie", cookie[:HandshakeCookieSize-1], HandshakeCookie, ErrShortHandshake},
+		{"hello-sized initiation", append([]byte{byte(HandshakeInit)}, hello[1:]...), HandshakeInit, ErrShortHandshake},
+		{"unknown", []byte{0xff}, 0xff, ErrUnknownHandshake},
+	}
+	for _, tt := range tests {
+		typ, err := ParseHandshakeType(tt.payload)
+		if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if typ != tt.typ {
+			t.Errorf("%s: type = %v, want %v", tt.name, typ, tt.typ)
+		}
+	}
+}
+
+func TestNoiseRejectsLowOrderKeys(t *testing.T) {
+	initiator, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	psk := [32]byte{1}
+
+	// An initiator refuses a low-order responder key outright
+	var zero [32]byte
+	hs := NewNoiseHandshake(initiator.PrivateKey, initiator.PublicKey, zero, psk, testNetwork)
+	if _, err := hs.CreateInitiation(); !errors.Is(err, identity.ErrLowOrderKey) {
+		t.Fatalf("initiation to a zero key: err = %v", err)
+	}
+
+	// A responder refuses an initiation carrying a low-order ephemeral key
+	hs = NewNoiseHandshake(initiator.PrivateKey, initiator.PublicKey, responder.PublicKey, psk, testNetwork)
+	msg, err := hs.CreateInitiation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg[1] = 1
+	clear(msg[2:33])
+	resp := NewNoiseHandshake(responder.PrivateKey, responder.PublicKey, [32]byte{}, psk, testNetwork)
+	if err := resp.ConsumeInitiation(msg); !errors.Is(err, identity.ErrLowOrderKey) {
+		t.Fatalf("initiation with a low-order ephemeral key: err = %v", err)
+	}
+}
+
+func TestHandshakeTick(t *testing.T) {
+	key, addr := testKey(t)
+	p := NewPeer(addr, key, nil, testLog())
+	const retry, timeout = time.Second, 10 * time.Second
+	if step := p.HandshakeTick(retry, timeout); step != HandshakeWait {
+		t.Fatalf("tick without a handshake = %v", step)
+	}
+
+	p.StartHandshake()
+	if p.State != PeerStateHandshake || p.HandshakeAttempts() != 1 {
+		t.Fatalf("after start: state %v, %d attempts", p.State, p.HandshakeAttempts())
+	}
+	if step := p.HandshakeTick(retry, timeout); step != HandshakeWait {
+		t.Fatalf("tick right after the initiation = %v", step)
+	}
+	// Each retry interval asks for one more initiation
+	for want := 2; want <= 4; want++ {
+		p.handshakeSent = p.handshakeSent.Add(-retry)
+		if step := p.HandshakeTick(retry, timeout); step != HandshakeRetry || p.HandshakeAttempts() != want {
+			t.Fatalf("tick after the retry interval = %v, %d attempts, want retry %d", step, p.HandshakeAttempts(), want)
+		}
+		if step := p.HandshakeTick(retry, timeout); step != HandshakeWait {
+			t.Fatalf("second tick in the interval = %v", step)
+		}
+	}
+
+	// Past the deadline the peer is dead and the handshake stops
+	p.HandshakeAt = p.HandshakeAt.Add(-timeout)
+	if step := p.HandshakeTick(retry, timeout); step != HandshakeExpired || p.State != PeerStateDead {
+		t.Fatalf("tick past the deadline = %v, state %v", step, p.State)
+	}
+	p.handshakeSent = p.handshakeSent.Add(-retry)
+	if step := p.HandshakeTick(retry, timeout); step != HandshakeWait {
+		t.Fatalf("tick on a dead peer = %v", step)
+	}
+
+	// A connected peer is left alone
+	p.SetCipher(testNetwork, NewNoiseCipher([32]byte{1}, [32]byte{2}))
+	p.StartHandshake()
+	if p.State != PeerStateConnected || p.HandshakeTick(0, timeout) != HandshakeWait {
+		t.Fatalf("connected peer: state %v", p.State)
+	}
+}
+
+func TestHandshakeDeadline(t *testing.T) {
+	if got := (Timers{}).HandshakeDeadline(); got != HandshakeTimeout {
+		t.Errorf("default deadline = %v, want %v", got, HandshakeTimeout)
+	}
+	if got := (Timers{HandshakeRetryInterval: time.Minute}).HandshakeDeadline(); got != 3*time.Minute {
+		t.Errorf("deadline with a 1m retry interval = %v, want three retries", got)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
 	"golang.org/x/crypto/blake2s"
 	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/curve25519"
@@ -31,7 +33,7 @@ const (
 
 	// Handshake message sizes
 	HandshakeInitiationSize = 1 + 32 + 48 + 28 + 16 // type + ephemeral + static_enc + timestamp_enc + mac
-	HandshakeResponseSize   = 1 + 32 + 48 + 16       // type + ephemeral + empty_enc + mac
+	HandshakeResponseSize   = 1 + 32 + 16 + 16      // type + ephemeral + empty_enc (tag only) + mac
 
 	handshakeMsgInit     = 1
 	handshakeMsgResponse = 2
@@ -41,13 +43,60 @@ var (
 	// NoiseProtocolName is the Noise protocol identifier used for hashing.
 	NoiseProtocolName = []byte("Noise_IKpsk2_25519_ChaChaPoly_BLAKE2s")
 
-	// NoisePrologue is the protocol prologue.
-	NoisePrologue = []byte("zerogo-vl1-v1")
+	// NoisePrologue is the protocol prologue. The network ID is appended so
+	// a handshake for one network cannot complete in another.
+	NoisePrologue = []byte("zerogo-vl1-v2")
 
 	ErrInvalidHandshake = errors.New("invalid handshake message")
 	ErrDecryptFailed    = errors.New("decrypt failed")
+	ErrClockSkew        = errors.New("handshake timestamp outside clock skew tolerance")
 )
 
+// DefaultMaxClockSkew is how far the timestamp of a hello or initiation may
+// be from the receiver's clock. It absorbs ordinary drift; a device without
+// a real-time clock needs a time reference, such as the controller's clock.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// timestampSize is the size of a handshake timestamp: Unix seconds and
+// nanoseconds, big endian.
+const timestampSize = 12
+
+func appendTimestamp(b []byte, t time.Time) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(t.Unix()))
+	return binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+}
+
+func parseTimestamp(b []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(b[:8])), int64(binary.BigEndian.Uint32(b[8:12])))
+}
+
+// CheckClockSkew returns a *ClockSkewError if sent, a handshake timestamp by
+// the sender's clock, is more than tolerance away from now by ours.
+func CheckClockSkew(sent, now time.Time, tolerance time.Duration) error {
+	if skew := sent.Sub(now); skew > tolerance || skew < -tolerance {
+		return &ClockSkewError{Skew: skew, Tolerance: tolerance}
+	}
+	return nil
+}
+
+// ClockSkewError rejects a handshake whose timestamp is too far from the
+// receiver's clock. It unwraps to ErrClockSkew.
+type ClockSkewError struct {
+	Skew      time.Duration // initiator's clock minus ours; negative if behind
+	Tolerance time.Duration
+}
+
+func (e *ClockSkewError) Error() string {
+	dir := "ahead of"
+	skew := e.Skew
+	if skew < 0 {
+		dir, skew = "behind", -skew
+	}
+	return fmt.Sprintf("peer clock is %s %s ours (tolerance %s); check the clocks of both nodes", skew.Round(time.Second), dir, e.Tolerance)
+}
+
+func (e *ClockSkewError) Unwrap() error { return ErrClockSkew }
+
 // NoiseHandshake manages a Noise IK handshake between two peers.
 type NoiseHandshake struct {
 	// Local identity
@@ -64,6 +113,9 @@ type NoiseHandshake struct {
 	// Pre-shared key
 	psk [NoisePSKSize]byte
 
+	// Network the handshake is for (bound via the prologue)
+	networkID uint32
+
 	// Handshake state
 	chainingKey [blake2s.Size]byte
 	hash        [blake2s.Size]byte
@@ -74,12 +126,13 @@ type NoiseHandshake struct {
 }
 
 // NewNoiseHandshake creates a new handshake state.
-func NewNoiseHandshake(localPriv, localPub [32]byte, remoteStaticPub [32]byte, psk [32]byte) *NoiseHandshake {
+func NewNoiseHandshake(localPriv, localPub [32]byte, remoteStaticPub [32]byte, psk [32]byte, networkID uint32) *NoiseHandshake {
 	hs := &NoiseHandshake{
 		localStatic:     localPriv,
 		localStaticPub:  localPub,
 		remoteStaticPub: remoteStaticPub,
 		psk:             psk,
+		networkID:       networkID,
 	}
 	hs.initialize()
 	return hs
@@ -91,7 +144,8 @@ func (hs *NoiseHandshake) initialize() {
 	// ck = h (for Noise, initial chaining key = initial hash)
 	hs.chainingKey = hs.hash
 	// Mix in prologue
-	hs.mixHash(NoisePrologue)
+	prologue := binary.BigEndian.AppendUint32(append([]byte{}, NoisePrologue...), hs.networkID)
+	hs.mixHash(prologue)
 }
 
 // --- Initiator Side ---
@@ -99,6 +153,10 @@ func (hs *NoiseHandshake) initialize() {
 // CreateInitiation generates the first handshake message (initiator → responder).
 // The initiator knows the responder's static public key (IK pattern).
 func (hs *NoiseHandshake) CreateInitiation() ([]byte, error) {
+	if err := identity.ValidatePublicKey(hs.remoteStaticPub[:]); err != nil {
+		return nil, fmt.Errorf("remote static key: %w", err)
+	}
+
 	// Mix responder's static public key into hash (IK pattern: responder's key is pre-known)
 	hs.mixHash(hs.remoteStaticPub[:])
 
@@ -136,10 +194,7 @@ func (hs *NoiseHandshake) CreateInitiation() ([]byte, error) {
 	hs.mixKeyAndHash(hs.psk[:])
 
 	// Encrypt timestamp as payload (for replay protection)
-	timestamp := make([]byte, 12)
-	if _, err := rand.Read(timestamp); err != nil {
-		return nil, err
-	}
+	timestamp := appendTimestamp(make([]byte, 0, timestampSize), time.Now())
 	encrypted = hs.encryptAndHash(timestamp)
 	msg = append(msg, encrypted...)
 
@@ -168,6 +223,9 @@ func (hs *NoiseHandshake) ConsumeInitiation(msg []byte) error {
 	var remoteEphemeral [32]byte
 	copy(remoteEphemeral[:], msg[pos:pos+32])
 	pos += 32
+	if err := identity.ValidatePublicKey(remoteEphemeral[:]); err != nil {
+		return fmt.Errorf("remote ephemeral key: %w", err)
+	}
 	hs.mixHash(remoteEphemeral[:])
 
 	// es: DH(static, remote ephemeral)
@@ -184,6 +242,9 @@ func (hs *NoiseHandshake) ConsumeInitiation(msg []byte) error {
 	}
 	copy(hs.remoteStaticPub[:], decrypted)
 	pos += 48
+	if err := identity.ValidatePublicKey(hs.remoteStaticPub[:]); err != nil {
+		return fmt.Errorf("remote static key: %w", err)
+	}
 
 	// ss: DH(static, remote static)
 	ss, err := curve25519.X25519(hs.localStatic[:], hs.remoteStaticPub[:])
@@ -196,7 +257,7 @@ func (hs *NoiseHandshake) ConsumeInitiation(msg []byte) error {
 	hs.mixKeyAndHash(hs.psk[:])
 
 	// Decrypt timestamp payload
-	_, err = hs.decryptAndHash(msg[pos : pos+28])
+	timestamp, err := hs.decryptAndHash(msg[pos : pos+28])
 	if err != nil {
 		return fmt.Errorf("decrypt timestamp: %w", err)
 	}
@@ -208,6 +269,11 @@ func (hs *NoiseHandshake) ConsumeInitiation(msg []byte) error {
 		return ErrInvalidHandshake
 	}
 
+	// Only an authenticated timestamp is worth judging
+	if err := CheckClockSkew(parseTimestamp(timestamp), time.Now(), DefaultMaxClockSkew); err != nil {
+		return err
+	}
+
 	// Store remote ephemeral for response
 	// (we need it to generate our own ephemeral DH)
 	// Save to a temporary for CreateResponse
@@ -262,6 +328,9 @@ func (hs *NoiseHandshake) ConsumeResponse(msg []byte) error {
 	var remoteEphemeral [32]byte
 	copy(remoteEphemeral[:], msg[pos:pos+32])
 	pos += 32
+	if err := identity.ValidatePublicKey(remoteEphemeral[:]); err != nil {
+		return fmt.Errorf("remote ephemeral key: %w", err)
+	}
 	hs.mixHash(remoteEphemeral[:])
 
 	// Decrypt empty payload
@@ -386,11 +455,15 @@ type NoiseCipher struct {
 	sendAEAD  cipher.AEAD
 	recvAEAD  cipher.AEAD
 	sendNonce atomic.Uint64
-	recvNonce uint64
+	replay    replayWindow // counters received, guarded by recvMu
 	recvMu    sync.Mutex
+
+	// The session nonces the keys were derived from (see NewSessionCipher)
+	localNonce, remoteNonce SessionNonce
 }
 
-// NewNoiseCipher creates a cipher pair from handshake-derived keys.
+// NewNoiseCipher creates a cipher pair from handshake-derived keys. The send
+// counter starts at zero, so the keys must never have been used before.
 func NewNoiseCipher(sendKey, recvKey [32]byte) *NoiseCipher {
 	sAEAD, err := chacha20poly1305.New(sendKey[:])
 	if err != nil {
@@ -408,8 +481,19 @@ func NewNoiseCipher(sendKey, recvKey [32]byte) *NoiseCipher {
 	}
 }
 
-// Encrypt encrypts plaintext and prepends the 8-byte nonce counter.
-func (c *NoiseCipher) Encrypt(plaintext []byte) ([]byte, error) {
+// NewSessionCipher keys a session with remotePub in networkID from the PSK
+// and the session nonces both sides sent in their hellos.
+func NewSessionCipher(psk [32]byte, networkID uint32, localPub, remotePub [32]byte, local, remote SessionNonce) *NoiseCipher {
+	sendKey, recvKey := DeriveKeysFromPSK(psk, networkID, localPub, remotePub, local, remote)
+	c := NewNoiseCipher(sendKey, recvKey)
+	c.localNonce, c.remoteNonce = local, remote
+	return c
+}
+
+// Encrypt encrypts plaintext and prepends the 8-byte nonce counter. ad is
+// authenticated but not encrypted; pass the encoded packet header so it
+// cannot be altered in transit.
+func (c *NoiseCipher) Encrypt(plaintext, ad []byte) ([]byte, error) {
 	counter := c.sendNonce.Add(1) - 1
 	var nonce [NoiseNonceSize]byte
 	binary.LittleEndian.PutUint64(nonce[4:], counter)
@@ -417,37 +501,20 @@ func (c *NoiseCipher) Encrypt(plaintext []byte) ([]byte, error) {
 	// Output: 8-byte counter + ciphertext + tag
 	out := make([]byte, 8, 8+len(plaintext)+NoiseTagSize)
 	binary.LittleEndian.PutUint64(out, counter)
-	out = c.sendAEAD.Seal(out, nonce[:], plaintext, nil)
+	out = c.sendAEAD.Seal(out, nonce[:], plaintext, ad)
 	return out, nil
 }
 
-// Decrypt decrypts a message (8-byte counter prefix + ciphertext + tag).
-func (c *NoiseCipher) Decrypt(data []byte) ([]byte, error) {
-	if len(data) < 8+NoiseTagSize {
-		return nil, errors.New("ciphertext too short")
-	}
-	counter := binary.LittleEndian.Uint64(data[:8])
-	var nonce [NoiseNonceSize]byte
-	binary.LittleEndian.PutUint64(nonce[4:], counter)
-
-	plaintext, err := c.recvAEAD.Open(nil, nonce[:], data[8:], nil)
-	if err != nil {
-		return nil, ErrDecryptFailed
-	}
-
-	// Update receive nonce (allow some reordering)
-	c.recvMu.Lock()
-	if counter >= c.recvNonce {
-		c.recvNonce = counter + 1
-	}
-	c.recvMu.Unlock()
-
-	return plaintext, nil
+// Decrypt decrypts a message (8-byte counter prefix + ciphertext + tag). ad
+// must match what the sender passed to Encrypt. A counter already received,
+// or too far behind the newest, fails with ErrReplay.
+func (c *NoiseCipher) Decrypt(data, ad []byte) ([]byte, error) {
+	return c.DecryptTo(nil, data, ad)
 }
 
 // EncryptTo encrypts plaintext into dst (which must have capacity for 8 + len(plaintext) + NoiseTagSize).
-// Returns the number of bytes written to dst.
-func (c *NoiseCipher) EncryptTo(dst, plaintext []byte) (int, error) {
+// ad is authenticated as in Encrypt. Returns the number of bytes written to dst.
+func (c *NoiseCipher) EncryptTo(dst, plaintext, ad []byte) (int, error) {
 	counter := c.sendNonce.Add(1) - 1
 	var nonce [NoiseNonceSize]byte
 	binary.LittleEndian.PutUint64(nonce[4:], counter)
@@ -455,35 +522,55 @@ func (c *NoiseCipher) EncryptTo(dst, plaintext []byte) (int, error) {
 	// Write 8-byte counter prefix
 	binary.LittleEndian.PutUint64(dst[:8], counter)
 	// Seal appends ciphertext+tag after dst[:8]
-	out := c.sendAEAD.Seal(dst[:8], nonce[:], plaintext, nil)
+	out := c.sendAEAD.Seal(dst[:8], nonce[:], plaintext, ad)
 	return len(out), nil
 }
 
 // DecryptTo decrypts data (8-byte counter + ciphertext + tag) into dst.
-// Returns the plaintext as a sub-slice of dst.
-func (c *NoiseCipher) DecryptTo(dst, data []byte) ([]byte, error) {
+// ad must match what the sender authenticated. Returns the plaintext as a
+// sub-slice of dst. Replays are rejected as in Decrypt; the counter is
+// checked before and recorded after authentication, so a forged packet
+// cannot move the replay window.
+func (c *NoiseCipher) DecryptTo(dst, data, ad []byte) ([]byte, error) {
 	if len(data) < 8+NoiseTagSize {
 		return nil, errors.New("ciphertext too short")
 	}
 	counter := binary.LittleEndian.Uint64(data[:8])
+	c.recvMu.Lock()
+	fresh := c.replay.check(counter)
+	c.recvMu.Unlock()
+	if !fresh {
+		return nil, ErrReplay
+	}
+
 	var nonce [NoiseNonceSize]byte
 	binary.LittleEndian.PutUint64(nonce[4:], counter)
-
-	plaintext, err := c.recvAEAD.Open(dst[:0], nonce[:], data[8:], nil)
+	plaintext, err := c.recvAEAD.Open(dst[:0], nonce[:], data[8:], ad)
 	if err != nil {
 		return nil, ErrDecryptFailed
 	}
 
-	// Update receive nonce (allow some reordering)
 	c.recvMu.Lock()
-	if counter >= c.recvNonce {
-		c.recvNonce = counter + 1
-	}
+	fresh = c.replay.accept(counter)
 	c.recvMu.Unlock()
-
+	if !fresh {
+		return nil, ErrReplay // a copy raced us through Open
+	}
 	return plaintext, nil
 }
 
+// advancedBy reports whether data carries a counter newer than any received
+// under this cipher, so it cannot be a copy of an earlier packet.
+func (c *NoiseCipher) advancedBy(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	counter := binary.LittleEndian.Uint64(data[:8])
+	c.recvMu.Lock()
+	defer c.recvMu.Unlock()
+	return c.replay.advancedBy(counter)
+}
+
 // --- Utility functions ---
 
 func hmacBlake2s(key, data []byte) [blake2s.Size]byte {
@@ -510,12 +597,30 @@ func hmacBlake2s(key, data []byte) [blake2s.Size]byte {
 	return hmacBlake2s(keyHash[:], data)
 }
 
-// DeriveKeysFromPSK derives deterministic send/recv keys from a PSK and two
-// public keys. Both sides compute the same keys by sorting the public keys.
-// This is used for Phase 1 testing (no handshake round-trip needed).
+// SessionNonceSize is the size of the random nonce each side of a session
+// contributes to its keys.
+const SessionNonceSize = 16
+
+// SessionNonce is the random value a node sends in its hellos and mixes
+// into the keys of the session they open, so that no two sessions between
+// the same pair share keys even though the PSK never changes.
+type SessionNonce [SessionNonceSize]byte
+
+// NewSessionNonce returns a random session nonce.
+func NewSessionNonce() SessionNonce {
+	var n SessionNonce
+	if _, err := rand.Read(n[:]); err != nil {
+		panic("crypto/rand: " + err.Error())
+	}
+	return n
+}
+
+// DeriveKeysFromPSK derives send/recv keys from a PSK, two public keys and
+// the session nonces the two sides sent. Both sides compute the same keys by
+// sorting the public keys, and each nonce goes with its sender's key.
 // The peer with the lexicographically smaller public key gets (k1=send, k2=recv),
 // the other gets (k1=recv, k2=send).
-func DeriveKeysFromPSK(psk [32]byte, localPub, remotePub [32]byte) (sendKey, recvKey [32]byte) {
+func DeriveKeysFromPSK(psk [32]byte, networkID uint32, localPub, remotePub [32]byte, localNonce, remoteNonce SessionNonce) (sendKey, recvKey [32]byte) {
 	// Determine order: smaller pubkey is "initiator"
 	localIsSmaller := false
 	for i := 0; i < 32; i++ {
@@ -527,15 +632,25 @@ func DeriveKeysFromPSK(psk [32]byte, localPub, remotePub [32]byte) (sendKey, rec
 		}
 	}
 
-	// Derive master key: BLAKE2s(psk || pubkey_small || pubkey_large)
+	// Derive master key: BLAKE2s(psk || network_id || pubkey_small ||
+	// pubkey_large || nonce_small || nonce_large). The network ID keeps
+	// networks that share a PSK from sharing session keys; the nonces keep
+	// sessions from sharing them, so counters can start at zero.
 	h, _ := blake2s.New256(nil)
 	h.Write(psk[:])
+	var nwid [4]byte
+	binary.BigEndian.PutUint32(nwid[:], networkID)
+	h.Write(nwid[:])
 	if localIsSmaller {
 		h.Write(localPub[:])
 		h.Write(remotePub[:])
+		h.Write(localNonce[:])
+		h.Write(remoteNonce[:])
 	} else {
 		h.Write(remotePub[:])
 		h.Write(localPub[:])
+		h.Write(remoteNonce[:])
+		h.Write(localNonce[:])
 	}
 	master := h.Sum(nil)
 
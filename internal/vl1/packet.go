@@ -17,8 +17,19 @@ const (
 	// MaxPayloadSize is the maximum payload after header.
 	MaxPayloadSize = MaxPacketSize - HeaderSize
 
-	// Version is the current protocol version.
-	Version = 1
+	// DataOverhead is what VL1 adds to each frame it carries: the header,
+	// the 8-byte nonce counter and the AEAD tag.
+	DataOverhead = HeaderSize + 8 + NoiseTagSize
+
+	// Version is the current protocol version. Version 2 binds session keys
+	// to the network ID; version 3 starts handshake payloads with a
+	// HandshakeType; version 4 authenticates the header as associated data;
+	// version 5 names the network a hello keys a session for in its header
+	// and timestamps hellos; version 6 mixes random nonces from both hellos
+	// into the session keys.
+	// Peers on different versions cannot talk: DecodeHeader rejects their
+	// packets with ErrVersion.
+	Version = 6
 )
 
 // PacketType identifies the VL1 packet type.
@@ -66,6 +77,16 @@ func (h *Header) Encode(buf []byte) {
 	binary.BigEndian.PutUint16(buf[6:8], h.Reserved)
 }
 
+// Bytes returns the encoded header, used as AEAD associated data.
+func (h *Header) Bytes() [HeaderSize]byte {
+	var buf [HeaderSize]byte
+	h.Encode(buf[:])
+	return buf
+}
+
+// ErrVersion rejects a packet from a peer speaking another protocol version.
+var ErrVersion = errors.New("unsupported protocol version")
+
 // DecodeHeader parses a header from buf.
 func DecodeHeader(buf []byte) (Header, error) {
 	if len(buf) < HeaderSize {
@@ -78,7 +99,7 @@ func DecodeHeader(buf []byte) (Header, error) {
 		Reserved:  binary.BigEndian.Uint16(buf[6:8]),
 	}
 	if h.Version != Version {
-		return h, fmt.Errorf("unsupported version: %d", h.Version)
+		return h, fmt.Errorf("%w: %d, want %d", ErrVersion, h.Version, Version)
 	}
 	return h, nil
 }
@@ -131,12 +152,15 @@ func NewKeepalivePacket() *Packet {
 	}
 }
 
-// NewHandshakePacket creates a handshake packet carrying Noise protocol messages.
-func NewHandshakePacket(payload []byte) *Packet {
+// NewHandshakePacket creates a handshake packet carrying a hello or Noise
+// message for the session in networkID; payload starts with its
+// HandshakeType.
+func NewHandshakePacket(networkID uint32, payload []byte) *Packet {
 	return &Packet{
 		Header: Header{
-			Version: Version,
-			Type:    PacketTypeHandshake,
+			Version:   Version,
+			Type:      PacketTypeHandshake,
+			NetworkID: networkID,
 		},
 		Payload: payload,
 	}
@@ -0,0 +1,43 @@
+package vl1
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeHeaderRejectsOtherVersions(t *testing.T) {
+	for _, v := range []uint8{Version - 1, Version + 1} {
+		buf := NewDataPacket(7, []byte("x")).Encode()
+		buf[0] = v
+		if _, err := DecodePacket(buf); !errors.Is(err, ErrVersion) {
+			t.Errorf("version %d: err = %v, want ErrVersion", v, err)
+		}
+	}
+	if _, err := DecodePacket(NewDataPacket(7, []byte("x")).Encode()); err != nil {
+		t.Fatalf("current version: %v", err)
+	}
+}
+
+func TestHeaderIsAuthenticated(t *testing.T) {
+	k1, k2 := [32]byte{1}, [32]byte{2}
+	send := NewNoiseCipher(k1, k2)
+	hdr := Header{Version: Version, Type: PacketTypeData, NetworkID: 0x0a0b0c0d}
+	ad := hdr.Bytes()
+	ct, err := send.Encrypt([]byte("frame"), ad[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewriting any header byte, e.g. to inject the frame into another
+	// network, fails decryption
+	for i := range ad {
+		tampered := ad
+		tampered[i] ^= 0x01
+		if _, err := NewNoiseCipher(k2, k1).Decrypt(ct, tampered[:]); !errors.Is(err, ErrDecryptFailed) {
+			t.Errorf("header byte %d flipped: err = %v, want ErrDecryptFailed", i, err)
+		}
+	}
+	if pt, err := NewNoiseCipher(k2, k1).Decrypt(ct, ad[:]); err != nil || string(pt) != "frame" {
+		t.Fatalf("untampered packet: %q, %v", pt, err)
+	}
+}
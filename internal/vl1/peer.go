@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,10 +16,10 @@ import (
 type PeerState int
 
 const (
-	PeerStateNew        PeerState = iota // Just discovered, no handshake yet
-	PeerStateHandshake                   // Handshake in progress
-	PeerStateConnected                   // Handshake complete, exchanging data
-	PeerStateDead                        // Connection lost
+	PeerStateNew       PeerState = iota // Just discovered, no handshake yet
+	PeerStateHandshake                  // Handshake in progress
+	PeerStateConnected                  // Handshake complete, exchanging data
+	PeerStateDead                       // Connection lost
 )
 
 func (s PeerState) String() string {
@@ -48,8 +49,54 @@ const (
 	HandshakeTimeout = 10 * time.Second
 	// HandshakeRetryInterval is delay between handshake retries.
 	HandshakeRetryInterval = 3 * time.Second
+	// HelloReplyInterval is the least time between hellos sent to a peer in
+	// reply to its own.
+	HelloReplyInterval = time.Second
+	// DefaultMaxPeers bounds how many peers a PeerManager tracks.
+	DefaultMaxPeers = 1024
+	// EndpointReprobeInterval is how long a candidate endpoint that went
+	// unanswered is skipped before it is probed again. It doubles with each
+	// further failure, up to EndpointReprobeMax.
+	EndpointReprobeInterval = 30 * time.Second
+	EndpointReprobeMax      = 10 * time.Minute
 )
 
+// Timers are the per-agent peer liveness intervals; mobile and high-latency
+// links may need different values than the defaults. Zero fields mean the
+// default.
+type Timers struct {
+	KeepaliveInterval      time.Duration
+	PeerTimeout            time.Duration
+	HandshakeRetryInterval time.Duration
+}
+
+// WithDefaults returns t with zero fields set to the default constants.
+func (t Timers) WithDefaults() Timers {
+	if t.KeepaliveInterval == 0 {
+		t.KeepaliveInterval = KeepaliveInterval
+	}
+	if t.PeerTimeout == 0 {
+		t.PeerTimeout = PeerTimeout
+	}
+	if t.HandshakeRetryInterval == 0 {
+		t.HandshakeRetryInterval = HandshakeRetryInterval
+	}
+	return t
+}
+
+// Validate checks that the timers are usable together.
+func (t Timers) Validate() error {
+	t = t.WithDefaults()
+	if t.KeepaliveInterval < 0 || t.PeerTimeout < 0 || t.HandshakeRetryInterval < 0 {
+		return fmt.Errorf("peer timers must not be negative")
+	}
+	if t.PeerTimeout <= t.KeepaliveInterval {
+		return fmt.Errorf("peer timeout (%s) must be longer than the keepalive interval (%s)",
+			t.PeerTimeout, t.KeepaliveInterval)
+	}
+	return nil
+}
+
 // ICEState represents the ICE negotiation state.
 type ICEState int
 
@@ -90,28 +137,79 @@ type Peer struct {
 	Address   identity.Address
 	PublicKey [32]byte
 
+	// Trusted peers come from the controller or static config; only peers
+	// discovered from incoming hellos are evicted to make room for others
+	Trusted bool
+
 	// Connection state
 	State    PeerState
 	Endpoint *net.UDPAddr // Current best endpoint
 
-	// Encryption — cipher is stored atomically so EncryptTo can be lock-free.
-	cipher atomic.Pointer[NoiseCipher]
+	// Encryption: one session cipher per network ID, since each network
+	// keys the pair separately. The map is replaced, never modified, so
+	// EncryptTo can be lock-free.
+	ciphers atomic.Pointer[map[uint32]*NoiseCipher]
+
+	// Session keying (see KeySession), guarded by mu: the nonce the next
+	// session in each network is keyed with, and re-keyed sessions waiting
+	// for the peer to prove it holds them
+	nextNonces map[uint32]SessionNonce
+	pending    map[uint32]*NoiseCipher
 
 	// ICE connection
 	iceConn  net.Conn // ICE connection (set after successful ICE negotiation)
 	iceState ICEState
 
+	// TURN relay connection (fallback when neither direct nor ICE works)
+	relayConn net.Conn
+
 	// Timing
 	LastSeen          time.Time
 	LastSend          time.Time
 	LatencyMs         int64
 	HandshakeAt       time.Time
 	KeepaliveInterval time.Duration // configurable keepalive interval (0 = default)
+	Timeout           time.Duration // configurable dead-peer timeout (0 = default)
+
+	// Traffic counters (data payload bytes since the peer was added)
+	bytesSent atomic.Uint64
+	bytesRecv atomic.Uint64
+
+	lastHelloReply time.Time
+
+	// Handshake in progress: when the last initiation went out and how
+	// many have (see StartHandshake); HandshakeAt is when it started
+	handshakeSent     time.Time
+	handshakeAttempts int
+
+	// stateHook is told about every state change (see setStateLocked)
+	stateHook func(p *Peer, from, to PeerState)
+
+	// Reachability of each candidate endpoint, by "ip:port"
+	endpoints map[string]*EndpointState
+	// Measured quality of each path, by path name (see CurrentPath)
+	paths map[string]*PathQuality
 
 	mu  sync.RWMutex
 	log *slog.Logger
 }
 
+// EndpointState records how a candidate endpoint of a peer has fared.
+type EndpointState struct {
+	LastSuccess time.Time // last time the peer answered from it
+	LastFailure time.Time // last probe it left unanswered
+	Failures    int       // consecutive unanswered probes
+}
+
+// reprobeAt returns when a failed endpoint is worth probing again.
+func (s *EndpointState) reprobeAt() time.Time {
+	backoff := EndpointReprobeInterval
+	for i := 1; i < s.Failures && backoff < EndpointReprobeMax; i++ {
+		backoff *= 2
+	}
+	return s.LastFailure.Add(min(backoff, EndpointReprobeMax))
+}
+
 // NewPeer creates a new peer instance.
 func NewPeer(addr identity.Address, pubKey [32]byte, endpoint *net.UDPAddr, log *slog.Logger) *Peer {
 	return &Peer{
@@ -123,65 +221,262 @@ func NewPeer(addr identity.Address, pubKey [32]byte, endpoint *net.UDPAddr, log
 	}
 }
 
-// SetCipher sets the transport cipher after handshake completes.
-func (p *Peer) SetCipher(c *NoiseCipher) {
+// SetCipher sets the session cipher for networkID after a handshake
+// completes.
+func (p *Peer) SetCipher(networkID uint32, c *NoiseCipher) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.cipher.Store(c)
-	p.State = PeerStateConnected
+	p.setCipherLocked(networkID, c)
+}
+
+// KeySession keys the session with the peer in networkID from the PSK and a
+// hello carrying remoteNonce, and reports whether the peer became connected
+// there. A new session takes the nonce HelloNonce has been sending, and
+// every session gets a fresh one, so no two share keys.
+//
+// Hellos are not authenticated, so one never replaces the session of a
+// connected peer. A nonce other than the session's means the peer restarted
+// or lost its session, or someone is spoofing it: it only prepares a
+// pending session, which replaces the current one once a packet
+// authenticates under it (see DecryptTo).
+func (p *Peer) KeySession(networkID uint32, psk, localPub [32]byte, remoteNonce SessionNonce) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cur := p.cipher(networkID)
+	connected := p.State == PeerStateConnected && cur != nil
+	switch {
+	case cur != nil && cur.remoteNonce == remoteNonce:
+		// The peer still holds this session; revive it if it went dead
+		if connected {
+			return false
+		}
+		p.setCipherLocked(networkID, cur)
+		return true
+	case connected:
+		if pc := p.pending[networkID]; pc != nil && pc.remoteNonce == remoteNonce {
+			return false
+		}
+		if p.pending == nil {
+			p.pending = make(map[uint32]*NoiseCipher)
+		}
+		p.pending[networkID] = NewSessionCipher(psk, networkID, localPub, p.PublicKey, NewSessionNonce(), remoteNonce)
+		return false
+	}
+	local := p.nextNonceLocked(networkID)
+	delete(p.nextNonces, networkID)
+	p.setCipherLocked(networkID, NewSessionCipher(psk, networkID, localPub, p.PublicKey, local, remoteNonce))
+	return true
+}
+
+// HelloNonce returns the session nonce to send in hellos to the peer in
+// networkID: the one its pending or current session was keyed with, or
+// else the one KeySession keys the next session with.
+func (p *Peer) HelloNonce(networkID uint32) SessionNonce {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pc := p.pending[networkID]; pc != nil {
+		return pc.localNonce
+	}
+	if c := p.cipher(networkID); p.State == PeerStateConnected && c != nil {
+		return c.localNonce
+	}
+	return p.nextNonceLocked(networkID)
+}
+
+func (p *Peer) nextNonceLocked(networkID uint32) SessionNonce {
+	n, ok := p.nextNonces[networkID]
+	if !ok {
+		if p.nextNonces == nil {
+			p.nextNonces = make(map[uint32]SessionNonce)
+		}
+		n = NewSessionNonce()
+		p.nextNonces[networkID] = n
+	}
+	return n
+}
+
+// Rekeying reports whether a pending session in networkID is waiting for
+// the peer to use it.
+func (p *Peer) Rekeying(networkID uint32) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pending[networkID] != nil
+}
+
+// decryptPending decrypts ciphertext under the pending session in
+// networkID, if there is one, and on success makes it the peer's session:
+// only the peer could have keyed it.
+func (p *Peer) decryptPending(networkID uint32, dst, ciphertext, ad []byte) ([]byte, bool) {
+	p.mu.RLock()
+	pc := p.pending[networkID]
+	p.mu.RUnlock()
+	if pc == nil {
+		return nil, false
+	}
+	plaintext, err := pc.DecryptTo(dst, ciphertext, ad)
+	if err != nil {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[networkID] == pc {
+		p.log.Info("peer re-keyed", "network", networkID)
+		p.setCipherLocked(networkID, pc)
+	}
+	return plaintext, true
+}
+
+func (p *Peer) setCipherLocked(networkID uint32, c *NoiseCipher) {
+	delete(p.pending, networkID)
+	ciphers := make(map[uint32]*NoiseCipher)
+	if old := p.ciphers.Load(); old != nil {
+		for id, oc := range *old {
+			ciphers[id] = oc
+		}
+	}
+	ciphers[networkID] = c
+	p.ciphers.Store(&ciphers)
+	p.setStateLocked(PeerStateConnected)
 	p.LastSeen = time.Now()
-	p.log.Info("peer connected", "endpoint", p.Endpoint)
+	p.log.Info("peer connected", "endpoint", p.Endpoint, "network", networkID)
 }
 
-// Encrypt encrypts a payload for this peer.
-func (p *Peer) Encrypt(plaintext []byte) ([]byte, error) {
-	c := p.cipher.Load()
-	if c == nil {
-		return nil, fmt.Errorf("peer %s: no cipher (not connected)", p.Address)
+// DropSession forgets the session cipher for networkID, e.g. when the
+// network is deleted. A peer left without a session is marked dead.
+func (p *Peer) DropSession(networkID uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := p.ciphers.Load()
+	if old == nil || (*old)[networkID] == nil {
+		return
+	}
+	ciphers := make(map[uint32]*NoiseCipher, len(*old))
+	for id, c := range *old {
+		if id != networkID {
+			ciphers[id] = c
+		}
+	}
+	p.ciphers.Store(&ciphers)
+	delete(p.pending, networkID)
+	if len(ciphers) == 0 {
+		p.setStateLocked(PeerStateDead)
 	}
-	return c.Encrypt(plaintext)
 }
 
-// Decrypt decrypts a payload from this peer.
-func (p *Peer) Decrypt(ciphertext []byte) ([]byte, error) {
-	c := p.cipher.Load()
-	if c == nil {
-		return nil, fmt.Errorf("peer %s: no cipher (not connected)", p.Address)
+// cipher returns the session cipher for networkID, or nil.
+func (p *Peer) cipher(networkID uint32) *NoiseCipher {
+	if ciphers := p.ciphers.Load(); ciphers != nil {
+		return (*ciphers)[networkID]
 	}
-	return c.Decrypt(ciphertext)
+	return nil
 }
 
-// EncryptTo encrypts plaintext into dst for this peer (zero-allocation, lock-free path).
-// Safe because sendAEAD is immutable after construction and sendNonce uses atomic operations.
-func (p *Peer) EncryptTo(dst, plaintext []byte) (int, error) {
-	c := p.cipher.Load()
-	if c == nil {
-		return 0, fmt.Errorf("peer %s: no cipher (not connected)", p.Address)
+// hasSessionLocked reports whether the peer has a cipher in any network.
+func (p *Peer) hasSessionLocked() bool {
+	ciphers := p.ciphers.Load()
+	return ciphers != nil && len(*ciphers) > 0
+}
+
+// Networks returns the IDs of the networks the peer has a session in, in
+// ascending order.
+func (p *Peer) Networks() []uint32 {
+	ciphers := p.ciphers.Load()
+	if ciphers == nil {
+		return nil
 	}
-	return c.EncryptTo(dst, plaintext)
+	ids := make([]uint32, 0, len(*ciphers))
+	for id := range *ciphers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
 }
 
-// DecryptTo decrypts ciphertext into dst for this peer (zero-allocation path).
-func (p *Peer) DecryptTo(dst, ciphertext []byte) ([]byte, error) {
-	c := p.cipher.Load()
+// HasSession reports whether the peer is connected with a session cipher
+// for networkID.
+func (p *Peer) HasSession(networkID uint32) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.State == PeerStateConnected && p.cipher(networkID) != nil
+}
+
+func (p *Peer) sessionCipher(networkID uint32) (*NoiseCipher, error) {
+	c := p.cipher(networkID)
 	if c == nil {
-		return nil, fmt.Errorf("peer %s: no cipher (not connected)", p.Address)
+		return nil, fmt.Errorf("peer %s: no session in network %d", p.Address, networkID)
+	}
+	return c, nil
+}
+
+// Encrypt encrypts a payload for this peer in networkID.
+func (p *Peer) Encrypt(networkID uint32, plaintext, ad []byte) ([]byte, error) {
+	c, err := p.sessionCipher(networkID)
+	if err != nil {
+		return nil, err
+	}
+	return c.Encrypt(plaintext, ad)
+}
+
+// Decrypt decrypts a payload from this peer in networkID.
+func (p *Peer) Decrypt(networkID uint32, ciphertext, ad []byte) ([]byte, error) {
+	return p.DecryptTo(networkID, nil, ciphertext, ad)
+}
+
+// EncryptTo encrypts plaintext into dst for this peer in networkID
+// (zero-allocation, lock-free path). Safe because sendAEAD is immutable
+// after construction and sendNonce uses atomic operations.
+func (p *Peer) EncryptTo(networkID uint32, dst, plaintext, ad []byte) (int, error) {
+	c, err := p.sessionCipher(networkID)
+	if err != nil {
+		return 0, err
+	}
+	return c.EncryptTo(dst, plaintext, ad)
+}
+
+// DecryptTo decrypts ciphertext from this peer in networkID into dst
+// (zero-allocation path). Ciphertext that fails under the session is tried
+// under a pending one, which it then replaces (see KeySession).
+func (p *Peer) DecryptTo(networkID uint32, dst, ciphertext, ad []byte) ([]byte, error) {
+	c, err := p.sessionCipher(networkID)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := c.DecryptTo(dst, ciphertext, ad)
+	if err != nil {
+		if pt, ok := p.decryptPending(networkID, dst, ciphertext, ad); ok {
+			return pt, nil
+		}
 	}
-	return c.DecryptTo(dst, ciphertext)
+	return plaintext, err
+}
+
+// CountSent adds n bytes to the peer's sent counter.
+func (p *Peer) CountSent(n int) { p.bytesSent.Add(uint64(n)) }
+
+// CountRecv adds n bytes to the peer's received counter.
+func (p *Peer) CountRecv(n int) { p.bytesRecv.Add(uint64(n)) }
+
+// Bytes returns the data bytes sent to and received from the peer.
+func (p *Peer) Bytes() (sent, recv uint64) {
+	return p.bytesSent.Load(), p.bytesRecv.Load()
 }
 
 // IsConnected returns true if the peer has an active connection.
 func (p *Peer) IsConnected() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return p.State == PeerStateConnected && p.cipher.Load() != nil
+	return p.State == PeerStateConnected && p.hasSessionLocked()
 }
 
 // IsAlive returns true if the peer has been seen recently.
 func (p *Peer) IsAlive() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return time.Since(p.LastSeen) < PeerTimeout
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = PeerTimeout
+	}
+	return time.Since(p.LastSeen) < timeout
 }
 
 // Touch updates the last seen timestamp.
@@ -191,6 +486,13 @@ func (p *Peer) Touch() {
 	p.LastSeen = time.Now()
 }
 
+// MarkSent updates the last send timestamp.
+func (p *Peer) MarkSent() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.LastSend = time.Now()
+}
+
 // NeedsKeepalive returns true if it's time to send a keepalive.
 // If recent data was sent (within the keepalive interval), the data itself
 // serves as a keepalive and no explicit keepalive packet is needed.
@@ -204,6 +506,96 @@ func (p *Peer) NeedsKeepalive() bool {
 	return p.State == PeerStateConnected && time.Since(p.LastSend) > interval
 }
 
+// EndpointSucceeded records that the peer answered from ep.
+func (p *Peer) EndpointSucceeded(ep *net.UDPAddr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.endpointStateLocked(ep)
+	st.LastSuccess = time.Now()
+	st.Failures = 0
+}
+
+// EndpointFailed records that a probe of ep went unanswered.
+func (p *Peer) EndpointFailed(ep *net.UDPAddr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := p.endpointStateLocked(ep)
+	st.LastFailure = time.Now()
+	st.Failures++
+}
+
+func (p *Peer) endpointStateLocked(ep *net.UDPAddr) *EndpointState {
+	if p.endpoints == nil {
+		p.endpoints = make(map[string]*EndpointState)
+	}
+	key := ep.String()
+	st, ok := p.endpoints[key]
+	if !ok {
+		st = &EndpointState{}
+		p.endpoints[key] = st
+	}
+	return st
+}
+
+// OrderEndpoints orders candidate endpoints for a connection attempt:
+// endpoints the peer answered from before, most recent first, then untried
+// ones, then failed ones that are due for a re-probe. Failed endpoints still
+// backing off are left out, unless that would leave nothing to try.
+func (p *Peer) OrderEndpoints(candidates []*net.UDPAddr) []*net.UDPAddr {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	now := time.Now()
+
+	var proven, untried, retry []*net.UDPAddr
+	for _, ep := range candidates {
+		st := p.endpoints[ep.String()]
+		switch {
+		case st == nil:
+			untried = append(untried, ep)
+		case st.Failures == 0:
+			proven = append(proven, ep)
+		case !now.Before(st.reprobeAt()):
+			retry = append(retry, ep)
+		}
+	}
+	sort.SliceStable(proven, func(i, j int) bool {
+		return p.endpoints[proven[i].String()].LastSuccess.After(p.endpoints[proven[j].String()].LastSuccess)
+	})
+
+	ordered := append(append(proven, untried...), retry...)
+	if len(ordered) == 0 {
+		return candidates
+	}
+	return ordered
+}
+
+// EndpointStates returns the recorded reachability of the peer's candidate
+// endpoints, by "ip:port".
+func (p *Peer) EndpointStates() map[string]EndpointState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	states := make(map[string]EndpointState, len(p.endpoints))
+	for ep, st := range p.endpoints {
+		states[ep] = *st
+	}
+	return states
+}
+
+// AllowHelloReply reports whether a hello may be sent in reply to the peer's
+// hello now, and if so records it. Replies are limited to one per
+// HelloReplyInterval so two nodes that keep seeing each other on new paths
+// cannot bounce hellos back and forth.
+func (p *Peer) AllowHelloReply() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.Sub(p.lastHelloReply) < HelloReplyInterval {
+		return false
+	}
+	p.lastHelloReply = now
+	return true
+}
+
 // SetICEConn sets the ICE connection for this peer.
 func (p *Peer) SetICEConn(conn net.Conn) {
 	p.mu.Lock()
@@ -252,12 +644,95 @@ func (p *Peer) CloseICE() {
 	p.iceState = ICEStateClosed
 }
 
+// SetRelayConn sets the TURN relay connection for this peer.
+func (p *Peer) SetRelayConn(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.relayConn = conn
+	p.log.Info("relay connection established", "relay", conn.RemoteAddr())
+}
+
+// RelayConn returns the TURN relay connection, or nil if not relayed.
+func (p *Peer) RelayConn() net.Conn {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.relayConn
+}
+
+// HasRelay returns true if this peer is reached through a TURN relay.
+func (p *Peer) HasRelay() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.relayConn != nil
+}
+
+// CloseRelay closes the relay connection and releases its allocation.
+func (p *Peer) CloseRelay() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.relayConn != nil {
+		p.relayConn.Close()
+		p.relayConn = nil
+	}
+}
+
+// Close tears down the peer's sessions: the ciphers are dropped so nothing
+// more is encrypted to or decrypted from it, its ICE and relay connections are
+// closed and it is marked dead. Called when the peer is removed.
+func (p *Peer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ciphers.Store(nil)
+	if p.iceConn != nil {
+		p.iceConn.Close()
+		p.iceConn = nil
+	}
+	p.iceState = ICEStateClosed
+	if p.relayConn != nil {
+		p.relayConn.Close()
+		p.relayConn = nil
+	}
+	p.setStateLocked(PeerStateDead)
+}
+
+// TunnelConn returns the connection that carries this peer's packets when the
+// direct UDP endpoint is not used: ICE first, then TURN relay. Nil means direct.
+func (p *Peer) TunnelConn() net.Conn {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.iceConn != nil {
+		return p.iceConn
+	}
+	return p.relayConn
+}
+
+// NeedsRelay returns true if the peer has had no working path for longer than
+// RelayFallbackTimeout.
+func (p *Peer) NeedsRelay() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.iceConn != nil || p.relayConn != nil || p.PublicKey == [32]byte{} {
+		return false
+	}
+	return time.Since(p.LastSeen) > RelayFallbackTimeout
+}
+
 // PeerManager manages all known peers.
 type PeerManager struct {
 	peers       map[identity.Address]*Peer
 	endpointIdx map[string]*Peer // "ip:port" → Peer
+	timers      Timers           // applied to every peer
+	maxPeers    int
 	mu          sync.RWMutex
 	log         *slog.Logger
+
+	// Trial decryptions for packets from unrecognised endpoints (see Roam)
+	roamLimit roamLimiter
+
+	// State change subscribers (see Subscribe)
+	subscribers []func(PeerEvent)
+	events      chan PeerEvent
+	eventsMu    sync.RWMutex
 }
 
 // NewPeerManager creates a new peer manager.
@@ -265,37 +740,146 @@ func NewPeerManager(log *slog.Logger) *PeerManager {
 	return &PeerManager{
 		peers:       make(map[identity.Address]*Peer),
 		endpointIdx: make(map[string]*Peer),
+		timers:      Timers{}.WithDefaults(),
+		maxPeers:    DefaultMaxPeers,
 		log:         log.With("component", "peer-manager"),
 	}
 }
 
-// AddPeer adds or updates a peer.
+// SetTimers sets the keepalive and timeout intervals for all current and
+// future peers. Zero fields take the defaults.
+func (pm *PeerManager) SetTimers(t Timers) {
+	t = t.WithDefaults()
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.timers = t
+	for _, p := range pm.peers {
+		p.mu.Lock()
+		p.KeepaliveInterval = t.KeepaliveInterval
+		p.Timeout = t.PeerTimeout
+		p.mu.Unlock()
+	}
+}
+
+// Timers returns the intervals applied to peers.
+func (pm *PeerManager) Timers() Timers {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.timers
+}
+
+// SetMaxPeers sets the peer limit; n <= 0 restores DefaultMaxPeers.
+func (pm *PeerManager) SetMaxPeers(n int) {
+	if n <= 0 {
+		n = DefaultMaxPeers
+	}
+	pm.mu.Lock()
+	pm.maxPeers = n
+	pm.mu.Unlock()
+}
+
+// AddPeer adds a trusted peer, one provided by the controller or static
+// config, or updates an existing one and marks it trusted. At the peer limit
+// it evicts a discovered peer if there is one; trusted peers are admitted
+// regardless.
 func (pm *PeerManager) AddPeer(addr identity.Address, pubKey [32]byte, endpoint *net.UDPAddr) *Peer {
+	return pm.addPeer(addr, pubKey, endpoint, true)
+}
+
+// AddDiscoveredPeer adds a peer that announced itself with a hello, or
+// updates an existing one. At the peer limit it evicts a dead or
+// unconnected discovered peer, preferring dead ones and then the least
+// recently seen; if there is none it returns nil and the peer is ignored.
+func (pm *PeerManager) AddDiscoveredPeer(addr identity.Address, pubKey [32]byte, endpoint *net.UDPAddr) *Peer {
+	return pm.addPeer(addr, pubKey, endpoint, false)
+}
+
+func (pm *PeerManager) addPeer(addr identity.Address, pubKey [32]byte, endpoint *net.UDPAddr, trusted bool) *Peer {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	if p, exists := pm.peers[addr]; exists {
+		p.mu.Lock()
 		// Update endpoint if changed
 		if endpoint != nil {
-			p.mu.Lock()
 			// Remove old endpoint index entry
 			if p.Endpoint != nil {
 				delete(pm.endpointIdx, p.Endpoint.String())
 			}
 			p.Endpoint = endpoint
 			pm.endpointIdx[endpoint.String()] = p
-			p.mu.Unlock()
 		}
+		if trusted {
+			p.Trusted = true
+		}
+		p.mu.Unlock()
 		return p
 	}
+
+	if len(pm.peers) >= pm.maxPeers {
+		// A trusted peer may displace any discovered one, a discovered
+		// peer only one that is not working anyway
+		if victim := pm.evictionCandidateLocked(trusted); victim != nil {
+			pm.removeLocked(victim)
+			pm.log.Debug("peer evicted at peer limit", "addr", victim.Address, "for", addr, "max", pm.maxPeers)
+		} else if !trusted {
+			pm.log.Debug("peer limit reached, ignoring discovered peer", "addr", addr, "endpoint", endpoint, "max", pm.maxPeers)
+			return nil
+		} else {
+			pm.log.Warn("peer limit exceeded by trusted peer", "addr", addr, "peers", len(pm.peers), "max", pm.maxPeers)
+		}
+	}
+
 	p := NewPeer(addr, pubKey, endpoint, pm.log)
+	p.stateHook = pm.peerStateChanged
+	p.Trusted = trusted
+	p.KeepaliveInterval = pm.timers.KeepaliveInterval
+	p.Timeout = pm.timers.PeerTimeout
 	pm.peers[addr] = p
 	if endpoint != nil {
 		pm.endpointIdx[endpoint.String()] = p
 	}
-	pm.log.Info("peer added", "addr", addr, "endpoint", endpoint)
+	pm.log.Info("peer added", "addr", addr, "endpoint", endpoint, "trusted", trusted)
 	return p
 }
 
+// evictionCandidateLocked picks the discovered peer to drop for a new one:
+// a dead peer before a live one, then the least recently seen. Connected
+// live peers are only candidates if includeLive is set.
+func (pm *PeerManager) evictionCandidateLocked(includeLive bool) *Peer {
+	var victim *Peer
+	var victimDead bool
+	var victimSeen time.Time
+	for _, p := range pm.peers {
+		p.mu.RLock()
+		trusted, seen := p.Trusted, p.LastSeen
+		p.mu.RUnlock()
+		if trusted {
+			continue
+		}
+		dead := !p.IsAlive()
+		if !dead && !includeLive && p.IsConnected() {
+			continue
+		}
+		if victim == nil || (dead && !victimDead) || (dead == victimDead && seen.Before(victimSeen)) {
+			victim, victimDead, victimSeen = p, dead, seen
+		}
+	}
+	return victim
+}
+
+// Trust marks an existing peer as trusted, e.g. once the controller confirms
+// a peer that was first discovered from its hello.
+func (pm *PeerManager) Trust(addr identity.Address) {
+	pm.mu.RLock()
+	p := pm.peers[addr]
+	pm.mu.RUnlock()
+	if p != nil {
+		p.mu.Lock()
+		p.Trusted = true
+		p.mu.Unlock()
+	}
+}
+
 // GetPeer returns a peer by address.
 func (pm *PeerManager) GetPeer(addr identity.Address) *Peer {
 	pm.mu.RLock()
@@ -311,6 +895,8 @@ func (pm *PeerManager) GetPeerByEndpoint(addr *net.UDPAddr) *Peer {
 }
 
 // UpdatePeerEndpoint atomically updates a peer's endpoint and the endpoint index.
+// Only the endpoint changes: the peer's cipher, nonce counters and connection
+// state are preserved so an established session migrates transparently.
 func (pm *PeerManager) UpdatePeerEndpoint(addr identity.Address, newEndpoint *net.UDPAddr) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -319,6 +905,10 @@ func (pm *PeerManager) UpdatePeerEndpoint(addr identity.Address, newEndpoint *ne
 		return
 	}
 	p.mu.Lock()
+	if p.Endpoint != nil && newEndpoint != nil && p.Endpoint.String() == newEndpoint.String() {
+		p.mu.Unlock()
+		return
+	}
 	if p.Endpoint != nil {
 		delete(pm.endpointIdx, p.Endpoint.String())
 	}
@@ -329,6 +919,49 @@ func (pm *PeerManager) UpdatePeerEndpoint(addr identity.Address, newEndpoint *ne
 	p.mu.Unlock()
 }
 
+// Roam looks for a connected peer whose cipher for networkID, or pending one
+// (see KeySession), authenticates ciphertext that arrived from an
+// unrecognised endpoint. On success the peer's endpoint is
+// moved to from and the decrypted plaintext (a sub-slice of dst) is returned.
+//
+// Only a packet newer than any received from the peer can move it, so a
+// captured packet replayed from elsewhere cannot redirect the session; the
+// replay window in DecryptTo then rejects copies. Attempts are rate limited
+// per source IP (ErrRoamLimited) since each costs a trial decryption per
+// peer. Peers using ICE are skipped since their traffic does not arrive on
+// the UDP socket. Without a match Roam returns ErrDecryptFailed.
+func (pm *PeerManager) Roam(from *net.UDPAddr, networkID uint32, dst, ciphertext, ad []byte) (*Peer, []byte, error) {
+	if !pm.roamLimit.allow(from.IP, time.Now()) {
+		return nil, nil, ErrRoamLimited
+	}
+	for _, p := range pm.ConnectedPeers() {
+		if p.HasICE() {
+			continue
+		}
+		var (
+			plaintext []byte
+			ok        bool
+		)
+		if c := p.cipher(networkID); c != nil && c.advancedBy(ciphertext) {
+			var err error
+			plaintext, err = c.DecryptTo(dst, ciphertext, ad)
+			ok = err == nil
+		}
+		if !ok {
+			// A restarted peer at a new address uses its re-keyed session
+			plaintext, ok = p.decryptPending(networkID, dst, ciphertext, ad)
+		}
+		if !ok {
+			continue
+		}
+		old := p.Endpoint
+		pm.UpdatePeerEndpoint(p.Address, from)
+		pm.log.Info("peer roamed to new endpoint", "addr", p.Address, "old", old, "new", from)
+		return p, plaintext, nil
+	}
+	return nil, nil, ErrDecryptFailed
+}
+
 // GetPeerByNodeAddr finds a peer by its string node address (hex-encoded).
 func (pm *PeerManager) GetPeerByNodeAddr(nodeAddr string) *Peer {
 	addr, err := identity.AddressFromHex(nodeAddr)
@@ -343,16 +976,23 @@ func (pm *PeerManager) RemovePeer(addr identity.Address) {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	if p, exists := pm.peers[addr]; exists {
-		p.mu.RLock()
-		if p.Endpoint != nil {
-			delete(pm.endpointIdx, p.Endpoint.String())
-		}
-		p.mu.RUnlock()
-		delete(pm.peers, addr)
+		pm.removeLocked(p)
 	}
 	pm.log.Info("peer removed", "addr", addr)
 }
 
+// removeLocked drops p from the manager and tears down its data plane, so
+// frames still in flight for it are neither decrypted nor sent.
+func (pm *PeerManager) removeLocked(p *Peer) {
+	p.mu.RLock()
+	if p.Endpoint != nil && pm.endpointIdx[p.Endpoint.String()] == p {
+		delete(pm.endpointIdx, p.Endpoint.String())
+	}
+	p.mu.RUnlock()
+	delete(pm.peers, p.Address)
+	p.Close()
+}
+
 // ConnectedPeers returns all peers in connected state.
 func (pm *PeerManager) ConnectedPeers() []*Peer {
 	pm.mu.RLock()
@@ -377,19 +1017,18 @@ func (pm *PeerManager) AllPeers() []*Peer {
 	return result
 }
 
-// CleanDead removes dead peers.
+// CleanDead removes peers that are dead and have stayed silent past their
+// timeout, tearing down their sessions as RemovePeer does.
 func (pm *PeerManager) CleanDead() int {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 	removed := 0
-	for addr, p := range pm.peers {
-		if !p.IsAlive() && p.State == PeerStateDead {
-			p.mu.RLock()
-			if p.Endpoint != nil {
-				delete(pm.endpointIdx, p.Endpoint.String())
-			}
-			p.mu.RUnlock()
-			delete(pm.peers, addr)
+	for _, p := range pm.peers {
+		p.mu.RLock()
+		dead := p.State == PeerStateDead
+		p.mu.RUnlock()
+		if dead && !p.IsAlive() {
+			pm.removeLocked(p)
 			removed++
 		}
 	}
@@ -0,0 +1,193 @@
+package vl1
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// testNetwork is the network ID of test sessions.
+const testNetwork = 1
+
+func testLog() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// testKey returns a fresh public key and the address derived from it.
+func testKey(t *testing.T) ([32]byte, identity.Address) {
+	t.Helper()
+	id, err := identity.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id.PublicKey, id.Address
+}
+
+func udpAddr(t *testing.T, s string) *net.UDPAddr {
+	t.Helper()
+	addr, err := net.ResolveUDPAddr("udp", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+func TestCleanDeadClosesSession(t *testing.T) {
+	pm := NewPeerManager(testLog())
+	pub, addr := testKey(t)
+	ep := udpAddr(t, "192.0.2.1:9993")
+	p := pm.AddPeer(addr, pub, ep)
+	p.SetCipher(testNetwork, NewNoiseCipher([32]byte{1}, [32]byte{2}))
+
+	// A dead peer seen recently stays until its timeout passes
+	p.MarkDead()
+	if n := pm.CleanDead(); n != 0 {
+		t.Fatalf("CleanDead removed %d peers still within the timeout", n)
+	}
+
+	p.mu.Lock()
+	p.LastSeen = time.Now().Add(-2 * PeerTimeout)
+	p.mu.Unlock()
+	if n := pm.CleanDead(); n != 1 {
+		t.Fatalf("CleanDead removed %d peers, want 1", n)
+	}
+	if pm.GetPeer(addr) != nil {
+		t.Error("dead peer still known")
+	}
+	if pm.GetPeerByEndpoint(ep) != nil {
+		t.Error("dead peer still indexed by endpoint")
+	}
+	if _, err := p.Encrypt(testNetwork, []byte("frame"), nil); err == nil {
+		t.Error("removed peer still encrypts")
+	}
+	if p.IsConnected() {
+		t.Error("removed peer still connected")
+	}
+}
+
+func TestSessionsArePerNetwork(t *testing.T) {
+	psk := [32]byte{7}
+	pubA, _ := testKey(t)
+	pubB, addrB := testKey(t)
+
+	// The same pair sharing a PSK in two networks gets distinct keys
+	nonceA, nonceB := NewSessionNonce(), NewSessionNonce()
+	send1, recv1 := DeriveKeysFromPSK(psk, 1, pubA, pubB, nonceA, nonceB)
+	send2, recv2 := DeriveKeysFromPSK(psk, 2, pubA, pubB, nonceA, nonceB)
+	if send1 == send2 || recv1 == recv2 {
+		t.Fatal("networks sharing a PSK derived the same session keys")
+	}
+	bSend1, bRecv1 := DeriveKeysFromPSK(psk, 1, pubB, pubA, nonceB, nonceA)
+	if bSend1 != recv1 || bRecv1 != send1 {
+		t.Fatal("the two sides of network 1 derived mismatched keys")
+	}
+
+	p := NewPeer(addrB, pubB, nil, testLog())
+	p.SetCipher(1, NewNoiseCipher(send1, recv1))
+	p.SetCipher(2, NewNoiseCipher(send2, recv2))
+	if got := p.Networks(); len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("Networks() = %v, want [1 2]", got)
+	}
+
+	// A frame b sent in network 1 cannot be passed off as network 2 traffic
+	fromB := NewNoiseCipher(bSend1, bRecv1)
+	ct, _ := fromB.Encrypt([]byte("frame"), nil)
+	if _, err := p.Decrypt(2, ct, nil); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("network 1 frame in network 2: err = %v, want ErrDecryptFailed", err)
+	}
+	if pt, err := p.Decrypt(1, ct, nil); err != nil || string(pt) != "frame" {
+		t.Fatalf("network 1 frame: %q, %v", pt, err)
+	}
+	if _, err := p.Decrypt(3, ct, nil); err == nil {
+		t.Fatal("decrypted in a network without a session")
+	}
+
+	// Leaving one network keeps the other session
+	p.DropSession(1)
+	if p.HasSession(1) || !p.HasSession(2) || !p.IsConnected() {
+		t.Fatal("dropping network 1 affected network 2")
+	}
+	p.DropSession(2)
+	if p.IsConnected() {
+		t.Fatal("peer connected without any session")
+	}
+}
+
+func TestMaxPeersEvictsDeadFirst(t *testing.T) {
+	pm := NewPeerManager(testLog())
+	pm.SetMaxPeers(4)
+	// set backdates a peer's last packet and gives it a session if connected
+	set := func(p *Peer, seen time.Duration, timeout time.Duration, state PeerState) {
+		if state == PeerStateConnected {
+			p.SetCipher(testNetwork, NewNoiseCipher([32]byte{1}, [32]byte{2}))
+		}
+		p.mu.Lock()
+		p.LastSeen = time.Now().Add(-seen)
+		if timeout != 0 {
+			p.Timeout = timeout
+		}
+		p.State = state
+		p.mu.Unlock()
+	}
+	add := func(trusted bool) (*Peer, identity.Address) {
+		pub, addr := testKey(t)
+		if trusted {
+			return pm.AddPeer(addr, pub, nil), addr
+		}
+		return pm.AddDiscoveredPeer(addr, pub, nil), addr
+	}
+
+	// A trusted peer long gone, a connected discovered peer, one idle for
+	// a while and one that timed out recently on a short timeout
+	trusted, trustedAddr := add(true)
+	set(trusted, 2*PeerTimeout, 0, PeerStateDead)
+	live, liveAddr := add(false)
+	set(live, 0, 0, PeerStateConnected)
+	idle, idleAddr := add(false)
+	set(idle, PeerTimeout/2, 0, PeerStateHandshake)
+	dead, deadAddr := add(false)
+	set(dead, time.Second, time.Millisecond, PeerStateDead)
+
+	// Each newcomer replaces a discovered peer that isn't working: the dead
+	// one although seen more recently, then the idle one
+	first, firstAddr := add(false)
+	if first == nil || pm.GetPeer(deadAddr) != nil || pm.GetPeer(idleAddr) == nil {
+		t.Fatal("dead discovered peer not evicted first")
+	}
+	set(first, 0, 0, PeerStateConnected)
+	second, _ := add(false)
+	if second == nil || pm.GetPeer(idleAddr) != nil {
+		t.Fatal("idle discovered peer not evicted")
+	}
+	set(second, 0, 0, PeerStateConnected)
+
+	// With only working or trusted peers left a flood of hellos is ignored
+	for range 10 {
+		if p, _ := add(false); p != nil {
+			t.Fatal("discovered peer admitted over the limit")
+		}
+	}
+	if n := len(pm.AllPeers()); n != 4 {
+		t.Fatalf("%d peers, want 4", n)
+	}
+
+	// A trusted peer displaces the least recently seen discovered one,
+	// and is admitted over the limit once none is left
+	set(live, time.Second, 0, PeerStateConnected)
+	if p, _ := add(true); p == nil || pm.GetPeer(liveAddr) != nil || pm.GetPeer(firstAddr) == nil {
+		t.Fatal("trusted peer did not displace the stalest discovered peer")
+	}
+	add(true)
+	add(true)
+	if p, _ := add(true); p == nil || len(pm.AllPeers()) != 5 {
+		t.Fatalf("trusted peer over the limit: %v, %d peers", p, len(pm.AllPeers()))
+	}
+	if pm.GetPeer(trustedAddr) == nil {
+		t.Error("trusted peer evicted")
+	}
+}
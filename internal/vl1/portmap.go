@@ -0,0 +1,503 @@
+package vl1
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PortMapLifetime is the lease requested from the gateway.
+	PortMapLifetime = 2 * time.Hour
+
+	natPMPPort       = 5351
+	natPMPRetries    = 4
+	natPMPInitialRTO = 250 * time.Millisecond
+	ssdpAddr         = "239.255.255.250:1900"
+	ssdpWait         = 2 * time.Second
+	upnpHTTPTimeout  = 5 * time.Second
+)
+
+// PortMapping is an external mapping for the local UDP port.
+type PortMapping struct {
+	Method   string       // "natpmp" or "upnp"
+	External *net.UDPAddr // address peers can reach us on
+	Lifetime time.Duration
+}
+
+// PortMapper requests a UDP port forward from the local gateway via NAT-PMP
+// or UPnP-IGD and keeps it refreshed.
+type PortMapper struct {
+	localPort int
+	log       *slog.Logger
+
+	mu      sync.Mutex
+	mapping *PortMapping
+	gateway net.IP // NAT-PMP gateway
+	upnp    *upnpService
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPortMapper creates a port mapper for localPort.
+func NewPortMapper(localPort int, log *slog.Logger) *PortMapper {
+	return &PortMapper{
+		localPort: localPort,
+		log:       log.With("component", "portmap"),
+	}
+}
+
+// Start requests the initial mapping (NAT-PMP first, then UPnP) and refreshes
+// it in the background at half the granted lifetime.
+func (m *PortMapper) Start() error {
+	mapping, err := m.request()
+	if err != nil {
+		return err
+	}
+	m.setMapping(mapping)
+	m.log.Info("port mapped", "method", mapping.Method, "external", mapping.External, "lifetime", mapping.Lifetime)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.refreshLoop(ctx, mapping.Lifetime)
+	return nil
+}
+
+// External returns the mapped external address, or nil if none.
+func (m *PortMapper) External() *net.UDPAddr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.mapping == nil {
+		return nil
+	}
+	return m.mapping.External
+}
+
+// Close stops refreshing and removes the mapping from the gateway.
+func (m *PortMapper) Close() {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+	}
+
+	m.mu.Lock()
+	mapping := m.mapping
+	m.mapping = nil
+	m.mu.Unlock()
+	if mapping == nil {
+		return
+	}
+
+	var err error
+	switch mapping.Method {
+	case "natpmp":
+		_, err = natPMPMap(m.gateway, m.localPort, 0, 0)
+	case "upnp":
+		err = m.upnp.deletePortMapping(mapping.External.Port)
+	}
+	if err != nil {
+		m.log.Debug("remove port mapping", "err", err)
+		return
+	}
+	m.log.Info("port mapping removed", "external", mapping.External)
+}
+
+func (m *PortMapper) setMapping(mapping *PortMapping) {
+	m.mu.Lock()
+	m.mapping = mapping
+	m.mu.Unlock()
+}
+
+func (m *PortMapper) refreshLoop(ctx context.Context, lifetime time.Duration) {
+	defer close(m.done)
+	for {
+		wait := lifetime / 2
+		if wait < time.Minute {
+			wait = time.Minute
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		mapping, err := m.request()
+		if err != nil {
+			m.log.Warn("port mapping refresh failed", "err", err)
+			lifetime = 2 * time.Minute // retry sooner
+			continue
+		}
+		if old := m.External(); old == nil || old.String() != mapping.External.String() {
+			m.log.Info("port mapping changed", "external", mapping.External)
+		}
+		m.setMapping(mapping)
+		lifetime = mapping.Lifetime
+	}
+}
+
+// request tries NAT-PMP, then UPnP-IGD.
+func (m *PortMapper) request() (*PortMapping, error) {
+	var errs []error
+
+	if gw, err := defaultGateway(); err == nil {
+		m.gateway = gw
+		mapping, err := natPMPMap(gw, m.localPort, m.localPort, PortMapLifetime)
+		if err == nil {
+			return mapping, nil
+		}
+		errs = append(errs, fmt.Errorf("natpmp: %w", err))
+	} else {
+		errs = append(errs, fmt.Errorf("natpmp: %w", err))
+	}
+
+	if m.upnp == nil {
+		svc, err := discoverUPnP()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("upnp: %w", err))
+			return nil, errors.Join(errs...)
+		}
+		m.upnp = svc
+	}
+	mapping, err := m.upnp.addPortMapping(m.localPort, PortMapLifetime)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("upnp: %w", err))
+		return nil, errors.Join(errs...)
+	}
+	return mapping, nil
+}
+
+// --- NAT-PMP (RFC 6886) ---
+
+// natPMPMap maps internalPort over UDP. A zero lifetime deletes the mapping.
+func natPMPMap(gateway net.IP, internalPort, externalPort int, lifetime time.Duration) (*PortMapping, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: gateway, Port: natPMPPort})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// External address first: the map response does not carry it.
+	resp, err := natPMPCall(conn, []byte{0, 0}, 12)
+	if err != nil {
+		return nil, fmt.Errorf("external address: %w", err)
+	}
+	extIP, err := parseNATPMPAddress(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	req := make([]byte, 12)
+	req[1] = 1 // map UDP
+	binary.BigEndian.PutUint16(req[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(req[6:8], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:12], uint32(lifetime/time.Second))
+	resp, err = natPMPCall(conn, req, 16)
+	if err != nil {
+		return nil, fmt.Errorf("map port: %w", err)
+	}
+	port, granted, err := parseNATPMPMap(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortMapping{
+		Method:   "natpmp",
+		External: &net.UDPAddr{IP: extIP, Port: port},
+		Lifetime: granted,
+	}, nil
+}
+
+// natPMPCall sends req with the RFC's doubling retransmission schedule.
+func natPMPCall(conn *net.UDPConn, req []byte, respLen int) ([]byte, error) {
+	buf := make([]byte, 16)
+	rto := natPMPInitialRTO
+	for i := 0; i < natPMPRetries; i++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(rto))
+		n, err := conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				rto *= 2
+				continue
+			}
+			return nil, err
+		}
+		if n < respLen || buf[1] != req[1]|0x80 {
+			continue // stale or unrelated response
+		}
+		return buf[:n], nil
+	}
+	return nil, fmt.Errorf("no response from gateway")
+}
+
+// parseNATPMPAddress decodes an external address response.
+func parseNATPMPAddress(resp []byte) (net.IP, error) {
+	if len(resp) < 12 || resp[1] != 128 {
+		return nil, fmt.Errorf("malformed NAT-PMP address response")
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return nil, fmt.Errorf("NAT-PMP result code %d", code)
+	}
+	return net.IPv4(resp[8], resp[9], resp[10], resp[11]), nil
+}
+
+// parseNATPMPMap decodes a UDP mapping response.
+func parseNATPMPMap(resp []byte) (int, time.Duration, error) {
+	if len(resp) < 16 || resp[1] != 129 {
+		return 0, 0, fmt.Errorf("malformed NAT-PMP map response")
+	}
+	if code := binary.BigEndian.Uint16(resp[2:4]); code != 0 {
+		return 0, 0, fmt.Errorf("NAT-PMP result code %d", code)
+	}
+	port := int(binary.BigEndian.Uint16(resp[10:12]))
+	lifetime := time.Duration(binary.BigEndian.Uint32(resp[12:16])) * time.Second
+	return port, lifetime, nil
+}
+
+// defaultGateway reads the IPv4 default route from /proc/net/route.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("read routes: %w", err)
+	}
+	defer f.Close()
+	return parseDefaultGateway(f)
+}
+
+func parseDefaultGateway(r io.Reader) (net.IP, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// Little-endian on every platform the kernel exports this for.
+		return net.IPv4(raw[3], raw[2], raw[1], raw[0]), nil
+	}
+	return nil, fmt.Errorf("no default route")
+}
+
+// --- UPnP-IGD ---
+
+// upnpService is a WANIPConnection/WANPPPConnection control endpoint.
+type upnpService struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+}
+
+// discoverUPnP finds an Internet Gateway Device with SSDP.
+func discoverUPnP() (*upnpService, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	dst, _ := net.ResolveUDPAddr("udp4", ssdpAddr)
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), dst); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(ssdpWait))
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no gateway answered SSDP")
+		}
+		location := parseSSDPLocation(buf[:n])
+		if location == "" {
+			continue
+		}
+		svc, err := fetchUPnPService(location)
+		if err != nil {
+			continue
+		}
+		return svc, nil
+	}
+}
+
+// parseSSDPLocation extracts the LOCATION header from an SSDP response.
+func parseSSDPLocation(resp []byte) string {
+	resp = append(resp, '\r', '\n') // ReadResponse wants a terminated header block
+	r, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return ""
+	}
+	return r.Header.Get("Location")
+}
+
+type upnpRoot struct {
+	Device upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// findWANService searches the device tree for a WAN connection service.
+func (d *upnpDevice) findWANService() (serviceType, controlURL string) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, "WANIPConnection") || strings.Contains(s.ServiceType, "WANPPPConnection") {
+			return s.ServiceType, s.ControlURL
+		}
+	}
+	for i := range d.Devices {
+		if st, cu := d.Devices[i].findWANService(); cu != "" {
+			return st, cu
+		}
+	}
+	return "", ""
+}
+
+func fetchUPnPService(location string) (*upnpService, error) {
+	client := &http.Client{Timeout: upnpHTTPTimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var root upnpRoot
+	if err := xml.NewDecoder(resp.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("parse device description: %w", err)
+	}
+	serviceType, controlURL := root.Device.findWANService()
+	if controlURL == "" {
+		return nil, fmt.Errorf("no WAN connection service at %s", location)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	ctl, err := base.Parse(controlURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Local address the gateway sees us on, for NewInternalClient.
+	probe, err := net.Dial("udp4", base.Host)
+	if err != nil {
+		return nil, err
+	}
+	localIP := probe.LocalAddr().(*net.UDPAddr).IP
+	probe.Close()
+
+	return &upnpService{controlURL: ctl.String(), serviceType: serviceType, localIP: localIP}, nil
+}
+
+func (s *upnpService) addPortMapping(port int, lifetime time.Duration) (*PortMapping, error) {
+	args := fmt.Sprintf("<NewRemoteHost></NewRemoteHost>"+
+		"<NewExternalPort>%d</NewExternalPort>"+
+		"<NewProtocol>UDP</NewProtocol>"+
+		"<NewInternalPort>%d</NewInternalPort>"+
+		"<NewInternalClient>%s</NewInternalClient>"+
+		"<NewEnabled>1</NewEnabled>"+
+		"<NewPortMappingDescription>zerogo</NewPortMappingDescription>"+
+		"<NewLeaseDuration>%d</NewLeaseDuration>",
+		port, port, s.localIP, int(lifetime/time.Second))
+	if _, err := s.soap("AddPortMapping", args); err != nil {
+		return nil, err
+	}
+
+	body, err := s.soap("GetExternalIPAddress", "")
+	if err != nil {
+		return nil, err
+	}
+	extIP := net.ParseIP(xmlElement(body, "NewExternalIPAddress"))
+	if extIP == nil {
+		return nil, fmt.Errorf("gateway returned no external address")
+	}
+
+	return &PortMapping{
+		Method:   "upnp",
+		External: &net.UDPAddr{IP: extIP, Port: port},
+		Lifetime: lifetime,
+	}, nil
+}
+
+func (s *upnpService) deletePortMapping(port int) error {
+	args := fmt.Sprintf("<NewRemoteHost></NewRemoteHost>"+
+		"<NewExternalPort>%d</NewExternalPort>"+
+		"<NewProtocol>UDP</NewProtocol>", port)
+	_, err := s.soap("DeletePortMapping", args)
+	return err
+}
+
+// soap invokes action on the control URL and returns the response body.
+func (s *upnpService) soap(action, args string) (string, error) {
+	envelope := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + s.serviceType + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+
+	req, err := http.NewRequest(http.MethodPost, s.controlURL, strings.NewReader(envelope))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+s.serviceType+"#"+action+`"`)
+
+	client := &http.Client{Timeout: upnpHTTPTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: HTTP %d (UPnP error %s)", action, resp.StatusCode, xmlElement(string(body), "errorCode"))
+	}
+	return string(body), nil
+}
+
+// xmlElement returns the text of the first <name> element in body.
+func xmlElement(body, name string) string {
+	start := strings.Index(body, "<"+name+">")
+	if start < 0 {
+		return ""
+	}
+	start += len(name) + 2
+	end := strings.Index(body[start:], "</"+name+">")
+	if end < 0 {
+		return ""
+	}
+	return strings.TrimSpace(body[start : start+end])
+}
@@ -0,0 +1,194 @@
+package vl1
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockNATPMP answers NAT-PMP requests on loopback, granting every mapping
+// on externalPort for the requested lifetime.
+func mockNATPMP(t *testing.T, externalIP net.IP, externalPort uint16) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var resp []byte
+			switch {
+			case n == 2 && buf[1] == 0:
+				resp = make([]byte, 12)
+				resp[1] = 128
+				copy(resp[8:12], externalIP.To4())
+			case n == 12 && buf[1] == 1:
+				resp = make([]byte, 16)
+				resp[1] = 129
+				copy(resp[8:10], buf[4:6])
+				binary.BigEndian.PutUint16(resp[10:12], externalPort)
+				copy(resp[12:16], buf[8:12])
+			default:
+				continue
+			}
+			conn.WriteToUDP(resp, from)
+		}
+	}()
+	return conn
+}
+
+func TestNATPMPExchange(t *testing.T) {
+	gw := mockNATPMP(t, net.IPv4(203, 0, 113, 9), 40000)
+	conn, err := net.DialUDP("udp4", nil, gw.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := natPMPCall(conn, []byte{0, 0}, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := parseNATPMPAddress(resp)
+	if err != nil || !ip.Equal(net.IPv4(203, 0, 113, 9)) {
+		t.Fatalf("external address = %v, %v", ip, err)
+	}
+
+	req := make([]byte, 12)
+	req[1] = 1
+	binary.BigEndian.PutUint16(req[4:6], 9993)
+	binary.BigEndian.PutUint16(req[6:8], 9993)
+	binary.BigEndian.PutUint32(req[8:12], 7200)
+	if resp, err = natPMPCall(conn, req, 16); err != nil {
+		t.Fatal(err)
+	}
+	port, lifetime, err := parseNATPMPMap(resp)
+	if err != nil || port != 40000 || lifetime != 2*time.Hour {
+		t.Fatalf("mapping = %d for %v, %v", port, lifetime, err)
+	}
+}
+
+func TestParseNATPMPErrors(t *testing.T) {
+	failed := make([]byte, 16)
+	failed[1] = 129
+	binary.BigEndian.PutUint16(failed[2:4], 3) // network failure
+	if _, _, err := parseNATPMPMap(failed); err == nil || !strings.Contains(err.Error(), "result code 3") {
+		t.Errorf("failed mapping: err = %v", err)
+	}
+	if _, _, err := parseNATPMPMap(failed[:12]); err == nil {
+		t.Error("short map response accepted")
+	}
+	if _, err := parseNATPMPAddress([]byte{0, 129, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}); err == nil {
+		t.Error("address response with the wrong opcode accepted")
+	}
+}
+
+func TestParseDefaultGateway(t *testing.T) {
+	routes := "Iface\tDestination\tGateway\tFlags\n" +
+		"eth0\t0000A8C0\t00000000\t0001\n" +
+		"eth0\t00000000\t0101A8C0\t0003\n"
+	gw, err := parseDefaultGateway(strings.NewReader(routes))
+	if err != nil || !gw.Equal(net.IPv4(192, 168, 1, 1)) {
+		t.Fatalf("gateway = %v, %v", gw, err)
+	}
+	if _, err := parseDefaultGateway(strings.NewReader(routes[:strings.LastIndex(routes, "eth0")])); err == nil {
+		t.Error("routes without a default route gave a gateway")
+	}
+}
+
+func TestParseSSDPLocation(t *testing.T) {
+	resp := "HTTP/1.1 200 OK\r\nST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\nLOCATION: http://192.168.1.1:5000/desc.xml\r\n"
+	if got := parseSSDPLocation([]byte(resp)); got != "http://192.168.1.1:5000/desc.xml" {
+		t.Errorf("location = %q", got)
+	}
+	if got := parseSSDPLocation([]byte("garbage")); got != "" {
+		t.Errorf("location of garbage = %q", got)
+	}
+}
+
+// upnpDescription nests the WAN service two devices deep, as real gateways do.
+const upnpDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0"><device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<controlURL>/ctl/IPConn</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device></root>`
+
+func TestUPnPPortMapping(t *testing.T) {
+	var actions []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, upnpDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		actions = append(actions, action)
+		switch {
+		case strings.HasSuffix(action, `#AddPortMapping"`):
+			if !strings.Contains(string(body), "<NewInternalPort>9993</NewInternalPort>") ||
+				!strings.Contains(string(body), "<NewLeaseDuration>7200</NewLeaseDuration>") {
+				w.WriteHeader(http.StatusInternalServerError)
+				io.WriteString(w, "<errorCode>402</errorCode>")
+			}
+		case strings.HasSuffix(action, `#GetExternalIPAddress"`):
+			io.WriteString(w, "<NewExternalIPAddress>203.0.113.9</NewExternalIPAddress>")
+		case strings.HasSuffix(action, `#DeletePortMapping"`):
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "<errorCode>401</errorCode>")
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	svc, err := fetchUPnPService(srv.URL + "/desc.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.controlURL != srv.URL+"/ctl/IPConn" || svc.serviceType != "urn:schemas-upnp-org:service:WANIPConnection:1" {
+		t.Fatalf("service = %+v", svc)
+	}
+
+	mapping, err := svc.addPortMapping(9993, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapping.Method != "upnp" || mapping.External.String() != "203.0.113.9:9993" || mapping.Lifetime != 2*time.Hour {
+		t.Fatalf("mapping = %+v", mapping)
+	}
+	if err := svc.deletePortMapping(9993); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"AddPortMapping", "GetExternalIPAddress", "DeletePortMapping"}
+	for i, action := range actions {
+		if i >= len(want) || action != fmt.Sprintf(`"%s#%s"`, svc.serviceType, want[i]) {
+			t.Fatalf("SOAP actions = %v, want %v", actions, want)
+		}
+	}
+
+	// Gateway errors carry the UPnP error code
+	if _, err := svc.soap("Bogus", ""); err == nil || !strings.Contains(err.Error(), "UPnP error 401") {
+		t.Errorf("unknown action: err = %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package vl1
+
+import "time"
+
+const (
+	// PathQualityAlpha is the weight of a new sample in the path RTT and
+	// loss averages.
+	PathQualityAlpha = 0.25
+	// PathLossPenalty is the latency a path's score adds per unit of loss:
+	// 10% loss counts as 50ms.
+	PathLossPenalty = 500 * time.Millisecond
+	// PathMinSamples is how many probes a path needs before it can replace
+	// the current one.
+	PathMinSamples = 3
+	// PathSwitchRatio and PathSwitchMinGain give path selection hysteresis:
+	// a path replaces the current one only if it scores below
+	// PathSwitchRatio of the current score and at least PathSwitchMinGain
+	// better, so paths of similar quality do not flap.
+	PathSwitchRatio   = 0.8
+	PathSwitchMinGain = 10 * time.Millisecond
+)
+
+// Path names for the tunnel paths of a peer; direct paths are named by
+// their "ip:port" endpoint.
+const (
+	PathICE   = "ice"
+	PathRelay = "relay"
+)
+
+// PathQuality is the measured quality of one path to a peer, from probe
+// round trips: moving averages of the RTT and of the share of probes lost.
+type PathQuality struct {
+	RTT       time.Duration
+	Loss      float64 // 0 (none) to 1 (all)
+	Samples   int     // probes sent, answered or not
+	LastProbe time.Time
+}
+
+// observe folds one probe result into the averages. The first sample sets
+// them outright.
+func (q *PathQuality) observe(rtt time.Duration, lost bool) {
+	loss := 0.0
+	if lost {
+		loss = 1
+	}
+	switch {
+	case q.Samples == 0:
+		q.Loss = loss
+		if !lost {
+			q.RTT = rtt
+		}
+	default:
+		q.Loss += PathQualityAlpha * (loss - q.Loss)
+		if !lost {
+			if q.RTT == 0 {
+				q.RTT = rtt
+			} else {
+				q.RTT += time.Duration(PathQualityAlpha * float64(rtt-q.RTT))
+			}
+		}
+	}
+	q.Samples++
+	q.LastProbe = time.Now()
+}
+
+// Score rates the path as an effective latency; lower is better. A path
+// that never answered scores worst.
+func (q PathQuality) Score() time.Duration {
+	if q.RTT == 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return q.RTT + time.Duration(q.Loss*float64(PathLossPenalty))
+}
+
+// BetterPath reports whether a path of quality candidate should replace the
+// current one. The candidate needs PathMinSamples probes and must beat the
+// current score by the switch margins; a current path without samples is
+// replaced by any measured one.
+func BetterPath(current, candidate PathQuality) bool {
+	if candidate.Samples < PathMinSamples || candidate.RTT == 0 {
+		return false
+	}
+	if current.Samples == 0 {
+		return true
+	}
+	cur, cand := current.Score(), candidate.Score()
+	return float64(cand) < PathSwitchRatio*float64(cur) && cur-cand >= PathSwitchMinGain
+}
+
+// ObservePath records the result of a probe over path; rtt is ignored when
+// lost is set.
+func (p *Peer) ObservePath(path string, rtt time.Duration, lost bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paths == nil {
+		p.paths = make(map[string]*PathQuality)
+	}
+	q, ok := p.paths[path]
+	if !ok {
+		q = &PathQuality{}
+		p.paths[path] = q
+	}
+	q.observe(rtt, lost)
+}
+
+// PathQualities returns the measured quality of each path probed so far.
+func (p *Peer) PathQualities() map[string]PathQuality {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	qs := make(map[string]PathQuality, len(p.paths))
+	for path, q := range p.paths {
+		qs[path] = *q
+	}
+	return qs
+}
+
+// CurrentPath names the path the peer's packets take now: PathICE,
+// PathRelay, the direct endpoint, or "" if there is none.
+func (p *Peer) CurrentPath() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	switch {
+	case p.iceConn != nil:
+		return PathICE
+	case p.relayConn != nil:
+		return PathRelay
+	case p.Endpoint != nil:
+		return p.Endpoint.String()
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package vl1
+
+import (
+	"testing"
+	"time"
+)
+
+// measured returns a path quality after n probes answered in rtt.
+func measured(rtt time.Duration, n int) PathQuality {
+	var q PathQuality
+	for range n {
+		q.observe(rtt, false)
+	}
+	return q
+}
+
+func TestPathQualityAverages(t *testing.T) {
+	var q PathQuality
+	q.observe(0, true)
+	if q.Loss != 1 || q.RTT != 0 || q.Score() != time.Duration(1<<63-1) {
+		t.Fatalf("after a lost first probe: %+v, score %v", q, q.Score())
+	}
+	q.observe(100*time.Millisecond, false)
+	q.observe(20*time.Millisecond, false)
+	if q.RTT != 80*time.Millisecond || q.Samples != 3 {
+		t.Fatalf("RTT = %v after %d samples, want 80ms", q.RTT, q.Samples)
+	}
+	if want := 0.5625; q.Loss != want {
+		t.Fatalf("loss = %v, want %v", q.Loss, want)
+	}
+	if want := q.RTT + time.Duration(q.Loss*float64(PathLossPenalty)); q.Score() != want {
+		t.Fatalf("score = %v, want %v", q.Score(), want)
+	}
+}
+
+func TestBetterPath(t *testing.T) {
+	lossy := measured(20*time.Millisecond, PathMinSamples)
+	lossy.observe(0, true)
+	tests := []struct {
+		name               string
+		current, candidate PathQuality
+		want               bool
+	}{
+		{"clearly faster", measured(80*time.Millisecond, 5), measured(20*time.Millisecond, PathMinSamples), true},
+		{"too few samples", measured(80*time.Millisecond, 5), measured(20*time.Millisecond, PathMinSamples-1), false},
+		{"within the ratio", measured(80*time.Millisecond, 5), measured(70*time.Millisecond, 5), false},
+		{"below the minimum gain", measured(20*time.Millisecond, 5), measured(12*time.Millisecond, 5), false},
+		{"faster but lossy", measured(60*time.Millisecond, 5), lossy, false},
+		{"current never probed", PathQuality{}, measured(90*time.Millisecond, PathMinSamples), true},
+		{"candidate never answered", measured(80*time.Millisecond, 5), PathQuality{Samples: 5, Loss: 1}, false},
+	}
+	for _, tt := range tests {
+		if got := BetterPath(tt.current, tt.candidate); got != tt.want {
+			t.Errorf("%s: BetterPath = %v, want %v (scores %v, %v)", tt.name, got, tt.want, tt.current.Score(), tt.candidate.Score())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package vl1
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v3"
+	"github.com/pion/turn/v3"
+)
+
+// RelayFallbackTimeout is how long a peer may stay silent on its direct path
+// before the agent falls back to a TURN relay.
+const RelayFallbackTimeout = 20 * time.Second
+
+// RelayAllocation is a TURN allocation used to tunnel VL1 packets to a single
+// peer when no direct or ICE path works.
+type RelayAllocation struct {
+	client  *turn.Client
+	base    net.PacketConn // socket towards the TURN server
+	relayed net.PacketConn // allocated relay transport address
+	server  string
+	log     *slog.Logger
+
+	closeOnce sync.Once
+}
+
+// AllocateRelay connects to the TURN server and allocates a relayed address.
+func AllocateRelay(server TURNServer, log *slog.Logger) (*RelayAllocation, error) {
+	u, err := stun.ParseURI(server.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse TURN URI %s: %w", server.URL, err)
+	}
+	serverAddr := net.JoinHostPort(u.Host, strconv.Itoa(u.Port))
+
+	base, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen relay socket: %w", err)
+	}
+
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       server.Username,
+		Password:       server.Password,
+		Conn:           base,
+	})
+	if err != nil {
+		base.Close()
+		return nil, fmt.Errorf("create TURN client: %w", err)
+	}
+	if err := client.Listen(); err != nil {
+		client.Close()
+		base.Close()
+		return nil, fmt.Errorf("TURN client listen: %w", err)
+	}
+
+	relayed, err := client.Allocate()
+	if err != nil {
+		client.Close()
+		base.Close()
+		return nil, fmt.Errorf("TURN allocate on %s: %w", serverAddr, err)
+	}
+
+	r := &RelayAllocation{
+		client:  client,
+		base:    base,
+		relayed: relayed,
+		server:  serverAddr,
+		log:     log.With("component", "relay", "server", serverAddr),
+	}
+	r.log.Info("TURN relay allocated", "relayed", relayed.LocalAddr())
+	return r, nil
+}
+
+// RelayedAddr returns the address peers should send to in order to reach us.
+func (r *RelayAllocation) RelayedAddr() net.Addr {
+	return r.relayed.LocalAddr()
+}
+
+// Conn returns a connection that exchanges packets with remote (the peer's
+// own relayed address) through this allocation. Closing it releases the allocation.
+func (r *RelayAllocation) Conn(remote *net.UDPAddr) net.Conn {
+	return &relayConn{alloc: r, remote: remote}
+}
+
+// Close releases the allocation and the underlying socket.
+func (r *RelayAllocation) Close() error {
+	r.closeOnce.Do(func() {
+		r.relayed.Close()
+		r.client.Close()
+		r.base.Close()
+		r.log.Info("TURN relay released")
+	})
+	return nil
+}
+
+// relayConn adapts a relay allocation to net.Conn for one remote address.
+type relayConn struct {
+	alloc  *RelayAllocation
+	remote *net.UDPAddr
+}
+
+func (c *relayConn) Read(b []byte) (int, error) {
+	for {
+		n, from, err := c.alloc.relayed.ReadFrom(b)
+		if err != nil {
+			return 0, err
+		}
+		if udp, ok := from.(*net.UDPAddr); ok && udp.IP.Equal(c.remote.IP) && udp.Port == c.remote.Port {
+			return n, nil
+		}
+		// Drop packets from anyone else that obtained a permission.
+	}
+}
+
+func (c *relayConn) Write(b []byte) (int, error) {
+	return c.alloc.relayed.WriteTo(b, c.remote)
+}
+
+func (c *relayConn) Close() error                       { return c.alloc.Close() }
+func (c *relayConn) LocalAddr() net.Addr                { return c.alloc.relayed.LocalAddr() }
+func (c *relayConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *relayConn) SetDeadline(t time.Time) error      { return c.alloc.relayed.SetDeadline(t) }
+func (c *relayConn) SetReadDeadline(t time.Time) error  { return c.alloc.relayed.SetReadDeadline(t) }
+func (c *relayConn) SetWriteDeadline(t time.Time) error { return c.alloc.relayed.SetWriteDeadline(t) }
@@ -0,0 +1,113 @@
+package vl1
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ReplayWindowSize is how far behind the newest counter received a packet
+// may arrive and still be accepted, to tolerate reordering on the path.
+const ReplayWindowSize = 2048
+
+// ErrReplay rejects a packet whose counter was already received or has
+// fallen behind the replay window.
+var ErrReplay = errors.New("replayed or stale packet counter")
+
+// replayWindow tracks the counters received under one key: a sliding bitmap
+// of the last ReplayWindowSize counters (RFC 6479). The zero value accepts
+// any first counter.
+type replayWindow struct {
+	started bool
+	highest uint64
+	bits    [ReplayWindowSize / 64]uint64
+}
+
+// check reports whether counter is new and within the window, without
+// recording it.
+func (w *replayWindow) check(counter uint64) bool {
+	if !w.started || counter > w.highest {
+		return true
+	}
+	if w.highest-counter >= ReplayWindowSize {
+		return false
+	}
+	i := counter % ReplayWindowSize
+	return w.bits[i/64]&(1<<(i%64)) == 0
+}
+
+// accept records counter and reports whether it was new and within the
+// window. Only counters of packets that authenticated may be recorded.
+func (w *replayWindow) accept(counter uint64) bool {
+	if !w.check(counter) {
+		return false
+	}
+	if !w.started || counter > w.highest {
+		if !w.started || counter-w.highest >= ReplayWindowSize {
+			w.bits = [ReplayWindowSize / 64]uint64{}
+		} else {
+			for c := w.highest + 1; c < counter; c++ {
+				i := c % ReplayWindowSize
+				w.bits[i/64] &^= 1 << (i % 64)
+			}
+		}
+		w.started = true
+		w.highest = counter
+	}
+	i := counter % ReplayWindowSize
+	w.bits[i/64] |= 1 << (i % 64)
+	return true
+}
+
+// advancedBy reports whether counter is beyond every counter received so
+// far, i.e. the packet cannot be a copy of an earlier one.
+func (w *replayWindow) advancedBy(counter uint64) bool {
+	return !w.started || counter > w.highest
+}
+
+// Roaming limits: each Roam costs a trial decryption per connected peer, so
+// packets from unrecognised endpoints are limited per source IP and overall.
+const (
+	RoamAttemptsPerSource = 8   // per second from one IP
+	RoamAttemptsTotal     = 256 // per second from all sources
+	roamMaxSources        = 4096
+)
+
+// ErrRoamLimited rejects a packet from an unrecognised endpoint because its
+// source IP, or all sources together, exceeded the roaming attempt limit.
+var ErrRoamLimited = errors.New("too many packets from unrecognised endpoints")
+
+// roamLimiter counts Roam attempts in one-second windows.
+type roamLimiter struct {
+	mu      sync.Mutex
+	window  time.Time
+	total   int
+	sources map[netip.Addr]int
+}
+
+// allow records an attempt from ip and reports whether it is within the
+// limits.
+func (l *roamLimiter) allow(ip net.IP, now time.Time) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.window) >= time.Second || l.sources == nil {
+		l.window = now
+		l.total = 0
+		l.sources = make(map[netip.Addr]int)
+	}
+	n, seen := l.sources[addr]
+	if l.total >= RoamAttemptsTotal || n >= RoamAttemptsPerSource || (!seen && len(l.sources) >= roamMaxSources) {
+		return false
+	}
+	l.sources[addr] = n + 1
+	l.total++
+	return true
+}
@@ -0,0 +1,270 @@
+package vl1
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	steps := []struct {
+		counter uint64
+		want    bool
+	}{
+		{100, true},  // any first counter
+		{100, false}, // duplicate
+		{101, true},
+		{99, true}, // reordered, within the window
+		{99, false},
+		{100 + ReplayWindowSize, true},
+		{101, false}, // seen, at the edge of the window
+		{102, true},  // never received, still inside
+		{99, false},  // fell out of the window
+		{103 + ReplayWindowSize - 1, true},
+		{150 + ReplayWindowSize, true},
+		{100 + ReplayWindowSize, false}, // still inside and already seen
+		{1 << 62, true},                 // jump past the whole window
+		{1<<62 - 1, true},
+		{150 + ReplayWindowSize, false},
+	}
+	for i, s := range steps {
+		if got := w.accept(s.counter); got != s.want {
+			t.Fatalf("step %d: accept(%d) = %v, want %v", i, s.counter, got, s.want)
+		}
+	}
+}
+
+func TestCipherRejectsReplay(t *testing.T) {
+	k1, k2 := [32]byte{1}, [32]byte{2}
+	send, recv := NewNoiseCipher(k1, k2), NewNoiseCipher(k2, k1)
+	ad := []byte("header")
+
+	var packets [][]byte
+	for i := 0; i < 3; i++ {
+		ct, err := send.Encrypt([]byte{byte(i)}, ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, ct)
+	}
+
+	// Reordered delivery is fine, a second copy is not
+	for _, i := range []int{1, 0, 2} {
+		pt, err := recv.Decrypt(packets[i], ad)
+		if err != nil || !bytes.Equal(pt, []byte{byte(i)}) {
+			t.Fatalf("packet %d: got %v, %v", i, pt, err)
+		}
+	}
+	for i := range packets {
+		if _, err := recv.Decrypt(packets[i], ad); !errors.Is(err, ErrReplay) {
+			t.Errorf("replay of packet %d: err = %v, want ErrReplay", i, err)
+		}
+	}
+
+	// A forged packet with a far-ahead counter must not move the window
+	forged := bytes.Clone(packets[2])
+	binary.LittleEndian.PutUint64(forged, 1<<63)
+	if _, err := recv.Decrypt(forged, ad); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("forged packet: err = %v, want ErrDecryptFailed", err)
+	}
+	next, _ := send.Encrypt([]byte("next"), ad)
+	if _, err := recv.Decrypt(next, ad); err != nil {
+		t.Fatalf("packet after forgery: %v", err)
+	}
+}
+
+// handshake has a's record of b key a session from b's hello nonce after
+// b's record of a took a's, as when a says hello and b answers.
+func handshake(t *testing.T, psk [32]byte, atA, atB *Peer) {
+	t.Helper()
+	atB.KeySession(testNetwork, psk, atA.PublicKey, atA.HelloNonce(testNetwork))
+	if !atA.KeySession(testNetwork, psk, atB.PublicKey, atB.HelloNonce(testNetwork)) {
+		t.Fatal("hello answer did not key a session")
+	}
+}
+
+func TestSessionsNeverShareKeyAndNonce(t *testing.T) {
+	psk := [32]byte{7}
+	pubA, addrA := testKey(t)
+	pubB, addrB := testKey(t)
+	ad := []byte("header")
+	atB := NewPeer(addrA, pubA, nil, testLog()) // b's record of a
+
+	// used records the key and nonce of every packet sealed
+	used := make(map[[32 + 8]byte]bool)
+	send := func(from, to *Peer, msg string) []byte {
+		t.Helper()
+		ct, err := from.Encrypt(testNetwork, []byte(msg), ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kn [32 + 8]byte
+		copy(kn[:32], from.cipher(testNetwork).sendKey[:])
+		copy(kn[32:], ct[:8])
+		if used[kn] {
+			t.Fatalf("%s: sealed under a key and nonce already used", msg)
+		}
+		used[kn] = true
+		if pt, err := to.Decrypt(testNetwork, ct, ad); err != nil || string(pt) != msg {
+			t.Fatalf("%s: %q, %v", msg, pt, err)
+		}
+		return ct
+	}
+
+	// a restarts twice, without any state, while b stays up; counters
+	// start at zero in every session
+	var captured [][]byte
+	for run := 0; run < 3; run++ {
+		atA := NewPeer(addrB, pubB, nil, testLog())
+		handshake(t, psk, atA, atB)
+		for i := 0; i < 3; i++ {
+			captured = append(captured, send(atA, atB, fmt.Sprintf("run %d: a to b %d", run, i)))
+			send(atB, atA, fmt.Sprintf("run %d: b to a %d", run, i))
+		}
+		if atB.Rekeying(testNetwork) {
+			t.Fatalf("run %d: b still holds a pending session", run)
+		}
+
+		// Nothing a sent before, in this session or an earlier one, is
+		// accepted again
+		for i, ct := range captured {
+			if _, err := atB.Decrypt(testNetwork, ct, ad); err == nil {
+				t.Fatalf("run %d: replay of packet %d accepted", run, i)
+			}
+		}
+	}
+}
+
+func TestHelloDoesNotReplaceSession(t *testing.T) {
+	psk := [32]byte{7}
+	pubA, addrA := testKey(t)
+	pubB, addrB := testKey(t)
+	atA, atB := NewPeer(addrB, pubB, nil, testLog()), NewPeer(addrA, pubA, nil, testLog())
+	handshake(t, psk, atA, atB)
+	ad := []byte("header")
+
+	// Anyone can send b a hello claiming to be a with a new nonce
+	atB.KeySession(testNetwork, psk, pubB, NewSessionNonce())
+	if !atB.Rekeying(testNetwork) {
+		t.Fatal("hello with a new nonce prepared no session")
+	}
+	// a never saw it and carries on under the session, which b keeps
+	for _, dir := range []struct{ from, to *Peer }{{atA, atB}, {atB, atA}} {
+		ct, _ := dir.from.Encrypt(testNetwork, []byte("frame"), ad)
+		if _, err := dir.to.Decrypt(testNetwork, ct, ad); err != nil {
+			t.Fatalf("session broken by the hello: %v", err)
+		}
+	}
+	if !atB.Rekeying(testNetwork) {
+		t.Fatal("pending session taken without the peer using it")
+	}
+}
+
+// roamPair returns a peer manager with one connected peer at ep, and the
+// cipher that peer sends with.
+func roamPair(t *testing.T, ep *net.UDPAddr) (*PeerManager, *Peer, *NoiseCipher) {
+	t.Helper()
+	pm := NewPeerManager(testLog())
+	pub, addr := testKey(t)
+	p := pm.AddPeer(addr, pub, ep)
+	k1, k2 := [32]byte{1}, [32]byte{2}
+	p.SetCipher(testNetwork, NewNoiseCipher(k1, k2))
+	return pm, p, NewNoiseCipher(k2, k1)
+}
+
+func TestRoamAfterPortChange(t *testing.T) {
+	oldEP := udpAddr(t, "192.0.2.1:9993")
+	newEP := udpAddr(t, "192.0.2.1:40001")
+	pm, p, sender := roamPair(t, oldEP)
+	ad := []byte("header")
+	buf := make([]byte, MaxPacketSize)
+
+	captured, _ := sender.Encrypt([]byte("captured"), ad)
+	if _, err := p.DecryptTo(testNetwork, buf, captured, ad); err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer's NAT picks a new source port: one authenticated packet
+	// moves it, and the following ones arrive on the fast path
+	ct, _ := sender.Encrypt([]byte("roamed"), ad)
+	got, pt, err := pm.Roam(newEP, testNetwork, buf, ct, ad)
+	if err != nil || got != p || string(pt) != "roamed" {
+		t.Fatalf("Roam = %v, %q, %v", got, pt, err)
+	}
+	if pm.GetPeerByEndpoint(newEP) != p || pm.GetPeerByEndpoint(oldEP) != nil {
+		t.Fatal("endpoint index not moved")
+	}
+	for i := 0; i < 3; i++ {
+		ct, _ := sender.Encrypt([]byte("flow"), ad)
+		if _, err := pm.GetPeerByEndpoint(newEP).DecryptTo(testNetwork, buf, ct, ad); err != nil {
+			t.Fatalf("packet %d after roaming: %v", i, err)
+		}
+	}
+
+	// Neither an old capture nor a copy of the roaming packet, sent from an
+	// attacker's address, can take the endpoint
+	attacker := udpAddr(t, "203.0.113.5:5555")
+	for _, replay := range [][]byte{captured, ct} {
+		if got, _, err := pm.Roam(attacker, testNetwork, buf, replay, ad); got != nil || err == nil {
+			t.Fatalf("replay roamed the peer (err %v)", err)
+		}
+	}
+	if ep := p.Endpoint; ep.String() != newEP.String() {
+		t.Fatalf("endpoint = %v after replays, want %v", ep, newEP)
+	}
+}
+
+func TestRoamRateLimitedPerSource(t *testing.T) {
+	pm, _, _ := roamPair(t, udpAddr(t, "192.0.2.1:9993"))
+	buf := make([]byte, MaxPacketSize)
+	junk := make([]byte, 64)
+
+	for i := 0; i < RoamAttemptsPerSource; i++ {
+		from := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 1000 + i}
+		if _, _, err := pm.Roam(from, testNetwork, buf, junk, nil); !errors.Is(err, ErrDecryptFailed) {
+			t.Fatalf("attempt %d: err = %v, want ErrDecryptFailed", i, err)
+		}
+	}
+	// Changing ports does not get around the limit; another source is
+	// still served
+	from := &net.UDPAddr{IP: net.ParseIP("203.0.113.5"), Port: 9}
+	if _, _, err := pm.Roam(from, testNetwork, buf, junk, nil); !errors.Is(err, ErrRoamLimited) {
+		t.Fatalf("over the limit: err = %v, want ErrRoamLimited", err)
+	}
+	other := &net.UDPAddr{IP: net.ParseIP("203.0.113.6"), Port: 9}
+	if _, _, err := pm.Roam(other, testNetwork, buf, junk, nil); !errors.Is(err, ErrDecryptFailed) {
+		t.Fatalf("other source: err = %v, want ErrDecryptFailed", err)
+	}
+}
+
+func TestEndpointChangeKeepsSession(t *testing.T) {
+	oldEP := udpAddr(t, "192.0.2.1:9993")
+	newEP := udpAddr(t, "192.0.2.1:40001")
+	pm, p, sender := roamPair(t, oldEP)
+	ad := []byte("header")
+	buf := make([]byte, MaxPacketSize)
+
+	captured, _ := sender.Encrypt([]byte("before"), ad)
+	if _, err := p.DecryptTo(testNetwork, buf, captured, ad); err != nil {
+		t.Fatal(err)
+	}
+	sentBefore, _ := p.Encrypt(testNetwork, []byte("out"), ad)
+
+	pm.UpdatePeerEndpoint(p.Address, newEP)
+
+	// A hello handshake after the move must not rekey the live session
+	if p.KeySession(testNetwork, [32]byte{1}, [32]byte{}, SessionNonce{}) || p.KeySession(testNetwork, [32]byte{1}, [32]byte{}, NewSessionNonce()) {
+		t.Fatal("KeySession replaced the cipher of a connected peer")
+	}
+	if _, err := p.DecryptTo(testNetwork, buf, captured, ad); !errors.Is(err, ErrReplay) {
+		t.Fatalf("replay after the move: err = %v, want ErrReplay", err)
+	}
+	sentAfter, _ := p.Encrypt(testNetwork, []byte("out"), ad)
+	if binary.LittleEndian.Uint64(sentAfter) <= binary.LittleEndian.Uint64(sentBefore) {
+		t.Fatal("send counter went back after the move")
+	}
+}
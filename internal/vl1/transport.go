@@ -1,24 +1,83 @@
 package vl1
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
-// Transport manages the UDP socket for VL1 communication.
-type Transport struct {
+// Transport carries VL1 packets. UDPTransport is the real socket; wrappers
+// such as DroppingTransport inject faults for testing relay and NAT paths.
+type Transport interface {
+	// Port returns the local port peers reach this node on.
+	Port() int
+
+	// ReadFrom blocks for the next packet and returns its size and sender.
+	ReadFrom(buf []byte) (int, *net.UDPAddr, error)
+
+	// SendTo sends one packet to addr.
+	SendTo(data []byte, addr *net.UDPAddr) error
+
+	// LocalAddr returns the local address packets are sent from.
+	LocalAddr() net.Addr
+
+	// Close stops the transport; a blocked ReadFrom returns an error.
+	Close() error
+
+	// Stats returns the transport's packet and error counters.
+	Stats() TransportStats
+}
+
+// RebindableTransport is implemented by transports that can move to another
+// local port while running. Callers type-assert for it.
+type RebindableTransport interface {
+	Transport
+
+	// Rebind moves the transport to port (0 = any). The new socket is bound
+	// before the old one is closed; on error the transport is unchanged.
+	Rebind(port int) error
+}
+
+// TransportStats counts the traffic through a transport since it started.
+type TransportStats struct {
+	PacketsSent     uint64
+	PacketsReceived uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+	ReadErrors      uint64 // failed reads, not counting those after Close
+	WriteErrors     uint64
+}
+
+// UDPTransport manages the UDP socket for VL1 communication.
+type UDPTransport struct {
 	conn   *net.UDPConn
 	port   int
 	mu     sync.RWMutex
 	closed bool
 	log    *slog.Logger
+
+	packetsSent     atomic.Uint64
+	packetsReceived atomic.Uint64
+	bytesSent       atomic.Uint64
+	bytesReceived   atomic.Uint64
+	readErrors      atomic.Uint64
+	writeErrors     atomic.Uint64
+
+	// Socket options applied so far, reapplied to the socket after Rebind
+	rcvBuf, sndBuf, dscp int
+
+	// SocketProtect, if set, exempts a socket from the device's VPN routing
+	// (Android's VpnService.protect) so VL1 traffic doesn't loop into the
+	// TUN. It reports whether the socket was protected.
+	SocketProtect func(fd int) bool
 }
 
-// NewTransport creates and binds a UDP socket on the given port.
-func NewTransport(port int, log *slog.Logger) (*Transport, error) {
+// NewUDPTransport creates and binds a UDP socket on the given port.
+func NewUDPTransport(port int, log *slog.Logger) (*UDPTransport, error) {
 	addr := &net.UDPAddr{Port: port}
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
@@ -27,7 +86,7 @@ func NewTransport(port int, log *slog.Logger) (*Transport, error) {
 	// Get the actual port (useful if port was 0)
 	actualPort := conn.LocalAddr().(*net.UDPAddr).Port
 	log.Info("VL1 transport listening", "port", actualPort)
-	return &Transport{
+	return &UDPTransport{
 		conn: conn,
 		port: actualPort,
 		log:  log,
@@ -35,34 +94,117 @@ func NewTransport(port int, log *slog.Logger) (*Transport, error) {
 }
 
 // Port returns the bound port number.
-func (t *Transport) Port() int {
+func (t *UDPTransport) Port() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.port
 }
 
-// ReadFrom reads a raw UDP packet. Returns the data, sender address, and error.
-func (t *Transport) ReadFrom(buf []byte) (int, *net.UDPAddr, error) {
-	n, addr, err := t.conn.ReadFromUDP(buf)
-	return n, addr, err
+// ReadFrom reads a raw UDP packet. Returns the data, sender address, and
+// error. A read blocked on a socket that Rebind replaced carries on with the
+// new one.
+func (t *UDPTransport) ReadFrom(buf []byte) (int, *net.UDPAddr, error) {
+	for {
+		t.mu.RLock()
+		conn := t.conn
+		t.mu.RUnlock()
+
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				t.mu.RLock()
+				rebound := !t.closed && t.conn != conn
+				t.mu.RUnlock()
+				if rebound {
+					continue
+				}
+			} else {
+				t.readErrors.Add(1)
+			}
+			return n, addr, err
+		}
+		t.packetsReceived.Add(1)
+		t.bytesReceived.Add(uint64(n))
+		return n, addr, nil
+	}
+}
+
+// Rebind moves the transport to port (0 = any), keeping its socket options.
+// Packets in flight to the old port are lost; peers learn the new one from
+// the next packet they receive.
+func (t *UDPTransport) Rebind(port int) error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+	if err != nil {
+		return fmt.Errorf("bind UDP port %d: %w", port, err)
+	}
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("transport closed")
+	}
+	if err := setSocketBuffers(conn, t.rcvBuf, t.sndBuf); err != nil {
+		t.log.Warn("socket buffers not kept on rebind", "err", err)
+	}
+	if t.dscp != 0 {
+		if err := setDSCP(conn, t.dscp); err != nil {
+			t.log.Warn("DSCP not kept on rebind", "err", err)
+		}
+	}
+	if t.SocketProtect != nil {
+		if err := protectSocket(conn, t.SocketProtect); err != nil {
+			t.log.Warn("socket not protected after rebind", "err", err)
+		}
+	}
+	old, oldPort := t.conn, t.port
+	t.conn = conn
+	t.port = conn.LocalAddr().(*net.UDPAddr).Port
+	newPort := t.port
+	t.mu.Unlock()
+
+	// Closing the old socket wakes ReadFrom, which moves to the new one
+	old.Close()
+	t.log.Info("VL1 transport rebound", "old_port", oldPort, "port", newPort)
+	return nil
 }
 
 // SendTo sends raw data to a specific UDP address.
-func (t *Transport) SendTo(data []byte, addr *net.UDPAddr) error {
+func (t *UDPTransport) SendTo(data []byte, addr *net.UDPAddr) error {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 	if t.closed {
 		return fmt.Errorf("transport closed")
 	}
-	_, err := t.conn.WriteToUDP(data, addr)
-	return err
+	n, err := t.conn.WriteToUDP(data, addr)
+	if err != nil {
+		t.writeErrors.Add(1)
+		return err
+	}
+	t.packetsSent.Add(1)
+	t.bytesSent.Add(uint64(n))
+	return nil
+}
+
+// Stats returns the transport's packet and error counters.
+func (t *UDPTransport) Stats() TransportStats {
+	return TransportStats{
+		PacketsSent:     t.packetsSent.Load(),
+		PacketsReceived: t.packetsReceived.Load(),
+		BytesSent:       t.bytesSent.Load(),
+		BytesReceived:   t.bytesReceived.Load(),
+		ReadErrors:      t.readErrors.Load(),
+		WriteErrors:     t.writeErrors.Load(),
+	}
 }
 
 // SendPacket encodes and sends a VL1 packet to a specific address.
-func (t *Transport) SendPacket(pkt *Packet, addr *net.UDPAddr) error {
+func (t *UDPTransport) SendPacket(pkt *Packet, addr *net.UDPAddr) error {
 	return t.SendTo(pkt.Encode(), addr)
 }
 
 // Close shuts down the transport.
-func (t *Transport) Close() error {
+func (t *UDPTransport) Close() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.closed = true
@@ -70,8 +212,18 @@ func (t *Transport) Close() error {
 }
 
 // SetSocketBuffers sets the send and receive buffer sizes on the UDP socket.
-func (t *Transport) SetSocketBuffers(rcvBuf, sndBuf int) error {
-	rawConn, err := t.conn.SyscallConn()
+func (t *UDPTransport) SetSocketBuffers(rcvBuf, sndBuf int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := setSocketBuffers(t.conn, rcvBuf, sndBuf); err != nil {
+		return err
+	}
+	t.rcvBuf, t.sndBuf = rcvBuf, sndBuf
+	return nil
+}
+
+func setSocketBuffers(conn *net.UDPConn, rcvBuf, sndBuf int) error {
+	rawConn, err := conn.SyscallConn()
 	if err != nil {
 		return fmt.Errorf("get raw conn: %w", err)
 	}
@@ -98,8 +250,18 @@ func (t *Transport) SetSocketBuffers(rcvBuf, sndBuf int) error {
 
 // SetDSCP sets the DSCP value (Differentiated Services Code Point) on the UDP socket.
 // The dscp value is shifted into the TOS byte (dscp << 2).
-func (t *Transport) SetDSCP(dscp int) error {
-	rawConn, err := t.conn.SyscallConn()
+func (t *UDPTransport) SetDSCP(dscp int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := setDSCP(t.conn, dscp); err != nil {
+		return err
+	}
+	t.dscp = dscp
+	return nil
+}
+
+func setDSCP(conn *net.UDPConn, dscp int) error {
+	rawConn, err := conn.SyscallConn()
 	if err != nil {
 		return fmt.Errorf("get raw conn: %w", err)
 	}
@@ -116,7 +278,38 @@ func (t *Transport) SetDSCP(dscp int) error {
 	return setErr
 }
 
+// ProtectSocket passes the socket to SocketProtect. Rebind protects the new
+// socket itself.
+func (t *UDPTransport) ProtectSocket() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.SocketProtect == nil {
+		return nil
+	}
+	return protectSocket(t.conn, t.SocketProtect)
+}
+
+func protectSocket(conn *net.UDPConn, protect func(fd int) bool) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("get raw conn: %w", err)
+	}
+	ok := false
+	err = rawConn.Control(func(fd uintptr) {
+		ok = protect(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("socket protect refused")
+	}
+	return nil
+}
+
 // LocalAddr returns the local address of the UDP socket.
-func (t *Transport) LocalAddr() net.Addr {
+func (t *UDPTransport) LocalAddr() net.Addr {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.conn.LocalAddr()
 }
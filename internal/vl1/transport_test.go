@@ -0,0 +1,131 @@
+package vl1
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestUDPTransport(t *testing.T) *UDPTransport {
+	t.Helper()
+	tr, err := NewUDPTransport(0, testLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestTransportStats(t *testing.T) {
+	a, b := newTestUDPTransport(t), newTestUDPTransport(t)
+	toB := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: b.Port()}
+
+	for _, size := range []int{100, 200, 300} {
+		if err := a.SendTo(make([]byte, size), toB); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]byte, MaxPacketSize)
+	for range 3 {
+		b.conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, _, err := b.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Larger than any UDP datagram
+	if err := a.SendTo(make([]byte, 70000), toB); err == nil {
+		t.Fatal("oversized datagram sent")
+	}
+
+	if got, want := a.Stats(), (TransportStats{PacketsSent: 3, BytesSent: 600, WriteErrors: 1}); got != want {
+		t.Errorf("sender stats = %+v, want %+v", got, want)
+	}
+	if got, want := b.Stats(), (TransportStats{PacketsReceived: 3, BytesReceived: 600}); got != want {
+		t.Errorf("receiver stats = %+v, want %+v", got, want)
+	}
+
+	// A timed out read is an error; a read ended by Close is not
+	b.conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, _, err := b.ReadFrom(buf); err == nil {
+		t.Fatal("read without traffic succeeded")
+	}
+	b.conn.SetReadDeadline(time.Time{})
+	done := make(chan struct{})
+	go func() {
+		b.ReadFrom(buf)
+		close(done)
+	}()
+	b.Close()
+	<-done
+	if got := b.Stats().ReadErrors; got != 1 {
+		t.Errorf("read errors = %d, want 1", got)
+	}
+}
+
+func TestTransportRebind(t *testing.T) {
+	tr, sender := newTestUDPTransport(t), newTestUDPTransport(t)
+	to := func(port int) *net.UDPAddr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port} }
+	oldPort := tr.Port()
+
+	// A read blocked on the old socket carries on with the new one
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		n, _, err := tr.ReadFrom(buf)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		got <- string(buf[:n])
+	}()
+	time.Sleep(20 * time.Millisecond) // let the read block
+	if err := tr.Rebind(0); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Port() == oldPort {
+		t.Fatalf("still on port %d after rebind", oldPort)
+	}
+	if err := sender.SendTo([]byte("hello"), to(tr.Port())); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-got:
+		if s != "hello" {
+			t.Fatalf("read %q, want the packet sent to the new port", s)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read did not move to the new socket")
+	}
+	if tr.Stats().ReadErrors != 0 {
+		t.Errorf("rebind counted as a read error")
+	}
+
+	// The old port is released
+	if l, err := net.ListenUDP("udp", &net.UDPAddr{Port: oldPort}); err != nil {
+		t.Errorf("old port still bound: %v", err)
+	} else {
+		l.Close()
+	}
+
+	// A busy port leaves the transport where it is
+	current := tr.Port()
+	if err := tr.Rebind(sender.Port()); err == nil {
+		t.Fatal("rebind to a busy port succeeded")
+	}
+	if tr.Port() != current {
+		t.Fatalf("port %d after a failed rebind, want %d", tr.Port(), current)
+	}
+	if err := sender.SendTo([]byte("still here"), to(current)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxPacketSize)
+	tr.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := tr.ReadFrom(buf); err != nil || string(buf[:n]) != "still here" {
+		t.Fatalf("read after a failed rebind: %q, %v", buf[:n], err)
+	}
+
+	tr.Close()
+	if err := tr.Rebind(0); err == nil {
+		t.Fatal("closed transport rebound")
+	}
+}
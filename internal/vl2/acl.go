@@ -0,0 +1,434 @@
+package vl2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// aclLogInterval limits rule hit logging to one line per rule per interval.
+const aclLogInterval = time.Second
+
+// IP protocol numbers understood by rule matching.
+const (
+	ProtoICMP = 1
+	ProtoTCP  = 6
+	ProtoUDP  = 17
+)
+
+// RuleAction is what happens to a frame matched by a rule.
+type RuleAction string
+
+const (
+	RuleAllow RuleAction = "allow"
+	RuleDrop  RuleAction = "drop"
+)
+
+// TimeWindow restricts a rule to certain days and times of day. A rule with a
+// window only applies while the window is open.
+type TimeWindow struct {
+	// Days the window is open on; empty means every day.
+	Days []time.Weekday
+	// Start and End are offsets from midnight. If End <= Start the window
+	// wraps past midnight (e.g. 22:00-06:00).
+	Start time.Duration
+	End   time.Duration
+	// Location the days and times are interpreted in.
+	Location *time.Location
+}
+
+// ParseTimeWindow builds a TimeWindow from its wire form: days as a comma
+// separated list ("mon,tue,wed"), start and end as "HH:MM" and an IANA
+// timezone name (empty = UTC). It returns nil if all fields are empty.
+func ParseTimeWindow(days, start, end, timezone string) (*TimeWindow, error) {
+	if days == "" && start == "" && end == "" && timezone == "" {
+		return nil, nil
+	}
+
+	w := &TimeWindow{Location: time.UTC}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+		}
+		w.Location = loc
+	}
+
+	if days != "" {
+		for _, d := range strings.Split(days, ",") {
+			day, ok := weekdays[strings.ToLower(strings.TrimSpace(d))]
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q", d)
+			}
+			w.Days = append(w.Days, day)
+		}
+	}
+
+	var err error
+	if start != "" {
+		if w.Start, err = parseTimeOfDay(start); err != nil {
+			return nil, err
+		}
+	}
+	w.End = 24 * time.Hour
+	if end != "" {
+		if w.End, err = parseTimeOfDay(end); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseTimeOfDay parses "HH:MM" into an offset from midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Active reports whether the window is open at now.
+func (w *TimeWindow) Active(now time.Time) bool {
+	now = now.In(w.Location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, w.Location)
+	offset := now.Sub(midnight)
+
+	day := now.Weekday()
+	var inWindow bool
+	if w.Start < w.End {
+		inWindow = offset >= w.Start && offset < w.End
+	} else {
+		// Wraps past midnight: the early-morning part belongs to the previous day
+		switch {
+		case offset >= w.Start:
+			inWindow = true
+		case offset < w.End:
+			inWindow = true
+			day = (day + 6) % 7
+		}
+	}
+	return inWindow && w.onDay(day)
+}
+
+func (w *TimeWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule is one ACL rule. Zero-valued match fields match anything.
+type Rule struct {
+	ID       uint // controller rule ID, reported with hit counters
+	Priority int  // lower is evaluated first
+	Action   RuleAction
+	Src      *net.IPNet
+	Dst      *net.IPNet
+	Protocol uint8 // IP protocol number, 0 = any
+	PortMin  uint16
+	PortMax  uint16 // 0 = any port
+	Window   *TimeWindow
+}
+
+// ParseRule builds a Rule from the string fields used by the controller.
+// Protocol is "tcp", "udp", "icmp", a protocol number or empty; portRange is
+// "80" or "8000-8080".
+func ParseRule(id uint, priority int, action, src, dst, protocol, portRange string, window *TimeWindow) (Rule, error) {
+	r := Rule{ID: id, Priority: priority, Action: RuleAction(action), Window: window}
+	if r.Action != RuleAllow && r.Action != RuleDrop {
+		return r, fmt.Errorf("invalid action %q", action)
+	}
+
+	var err error
+	if r.Src, err = parseRuleNet(src); err != nil {
+		return r, err
+	}
+	if r.Dst, err = parseRuleNet(dst); err != nil {
+		return r, err
+	}
+
+	if r.Protocol, err = ParseProtocol(protocol); err != nil {
+		return r, err
+	}
+
+	if portRange != "" {
+		lo, hi, found := strings.Cut(portRange, "-")
+		if !found {
+			hi = lo
+		}
+		min, err1 := strconv.ParseUint(lo, 10, 16)
+		max, err2 := strconv.ParseUint(hi, 10, 16)
+		if err1 != nil || err2 != nil || min > max || max == 0 {
+			return r, fmt.Errorf("invalid port range %q", portRange)
+		}
+		r.PortMin, r.PortMax = uint16(min), uint16(max)
+	}
+	return r, nil
+}
+
+// ParseProtocol parses "tcp", "udp", "icmp" or an IP protocol number; empty
+// and "any" give 0.
+func ParseProtocol(protocol string) (uint8, error) {
+	switch strings.ToLower(protocol) {
+	case "", "any":
+		return 0, nil
+	case "icmp":
+		return ProtoICMP, nil
+	case "tcp":
+		return ProtoTCP, nil
+	case "udp":
+		return ProtoUDP, nil
+	}
+	n, err := strconv.ParseUint(protocol, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol %q", protocol)
+	}
+	return uint8(n), nil
+}
+
+// parseRuleNet accepts a CIDR or a bare IPv4 address; empty means any.
+func parseRuleNet(s string) (*net.IPNet, error) {
+	if s == "" || s == "any" {
+		return nil, nil
+	}
+	if !strings.Contains(s, "/") {
+		s += "/32"
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	return ipNet, nil
+}
+
+// matches reports whether the rule applies to an IPv4 packet.
+func (r *Rule) matches(pkt *ipv4Packet) bool {
+	if r.Src != nil && !r.Src.Contains(pkt.src) {
+		return false
+	}
+	if r.Dst != nil && !r.Dst.Contains(pkt.dst) {
+		return false
+	}
+	if r.Protocol != 0 && r.Protocol != pkt.proto {
+		return false
+	}
+	if r.PortMax != 0 {
+		if !pkt.hasPorts || pkt.dstPort < r.PortMin || pkt.dstPort > r.PortMax {
+			return false
+		}
+	}
+	return true
+}
+
+// ACL filters IPv4 frames against an ordered rule set. Rules are evaluated
+// by ascending priority and the first match decides; frames matching no rule,
+// and non-IPv4 frames (ARP, IPv6), are allowed. Rules with a time window are
+// skipped while the window is closed.
+type ACL struct {
+	mu    sync.RWMutex
+	rules []Rule
+	stats []ruleStats // parallel to rules
+	now   func() time.Time
+	log   *slog.Logger
+}
+
+// ruleStats counts matches of one rule.
+type ruleStats struct {
+	hits    atomic.Uint64
+	lastLog atomic.Int64 // unix nanos of the last hit log line
+}
+
+// RuleHits is a rule's match counter.
+type RuleHits struct {
+	ID       uint
+	Priority int
+	Action   RuleAction
+	Hits     uint64
+}
+
+// NewACL creates an empty ACL that allows everything.
+func NewACL(log *slog.Logger) *ACL {
+	return &ACL{
+		now: time.Now,
+		log: log.With("component", "acl"),
+	}
+}
+
+// SetClock replaces the clock used to evaluate time windows.
+func (acl *ACL) SetClock(now func() time.Time) {
+	acl.mu.Lock()
+	acl.now = now
+	acl.mu.Unlock()
+}
+
+// SetRules replaces the rule set.
+func (acl *ACL) SetRules(rules []Rule) {
+	sorted := sortRules(rules)
+
+	acl.mu.Lock()
+	acl.rules = sorted
+	acl.stats = make([]ruleStats, len(sorted))
+	acl.mu.Unlock()
+	acl.log.Info("ACL rules updated", "rules", len(sorted))
+}
+
+// Allow reports whether a frame may pass.
+func (acl *ACL) Allow(frame *EthernetFrame) bool {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+
+	if len(acl.rules) == 0 || frame.EtherType != EtherTypeIPv4 {
+		return true
+	}
+	pkt, ok := parseIPv4Packet(frame.Payload)
+	if !ok {
+		return true
+	}
+
+	now := acl.now()
+	if i := firstMatch(acl.rules, &pkt, now); i >= 0 {
+		r := &acl.rules[i]
+		acl.hit(i, r, &pkt, now)
+		return r.Action == RuleAllow
+	}
+	return true
+}
+
+// sortRules returns a copy of rules in evaluation order.
+func sortRules(rules []Rule) []Rule {
+	sorted := make([]Rule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	return sorted
+}
+
+// firstMatch returns the index of the first rule in sorted rules that
+// applies to pkt at now, or -1.
+func firstMatch(rules []Rule, pkt *ipv4Packet, now time.Time) int {
+	for i := range rules {
+		r := &rules[i]
+		if r.Window != nil && !r.Window.Active(now) {
+			continue
+		}
+		if r.matches(pkt) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Flow is the part of an IPv4 packet rules match on. Ports only count for
+// TCP and UDP, as in real packets.
+type Flow struct {
+	Src      net.IP
+	Dst      net.IP
+	Protocol uint8
+	SrcPort  uint16
+	DstPort  uint16
+}
+
+// Evaluate returns the rule an ACL holding rules would apply to flow at now,
+// or nil if none matches and the flow is allowed, without counting hits. It
+// lets the controller check flows against the rules agents enforce.
+func Evaluate(rules []Rule, flow Flow, now time.Time) *Rule {
+	src, dst := flow.Src.To4(), flow.Dst.To4()
+	if src == nil || dst == nil {
+		return nil // only IPv4 is filtered
+	}
+	pkt := ipv4Packet{
+		src:     src,
+		dst:     dst,
+		proto:   flow.Protocol,
+		srcPort: flow.SrcPort,
+		dstPort: flow.DstPort,
+	}
+	pkt.hasPorts = pkt.proto == ProtoTCP || pkt.proto == ProtoUDP
+
+	sorted := sortRules(rules)
+	if i := firstMatch(sorted, &pkt, now); i >= 0 {
+		return &sorted[i]
+	}
+	return nil
+}
+
+// hit counts a match and logs it at debug level, at most once per
+// aclLogInterval for each rule.
+func (acl *ACL) hit(i int, r *Rule, pkt *ipv4Packet, now time.Time) {
+	st := &acl.stats[i]
+	hits := st.hits.Add(1)
+
+	last := st.lastLog.Load()
+	if now.UnixNano()-last < int64(aclLogInterval) || !st.lastLog.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	acl.log.Debug("ACL rule matched",
+		"rule", r.ID,
+		"action", r.Action,
+		"proto", pkt.proto,
+		"src", net.JoinHostPort(pkt.src.String(), strconv.Itoa(int(pkt.srcPort))),
+		"dst", net.JoinHostPort(pkt.dst.String(), strconv.Itoa(int(pkt.dstPort))),
+		"hits", hits,
+	)
+}
+
+// Hits returns the match counters of the current rules in evaluation order.
+// Counters reset when the rules are replaced.
+func (acl *ACL) Hits() []RuleHits {
+	acl.mu.RLock()
+	defer acl.mu.RUnlock()
+	out := make([]RuleHits, len(acl.rules))
+	for i, r := range acl.rules {
+		out[i] = RuleHits{ID: r.ID, Priority: r.Priority, Action: r.Action, Hits: acl.stats[i].hits.Load()}
+	}
+	return out
+}
+
+// ipv4Packet holds the header fields rules match on.
+type ipv4Packet struct {
+	src, dst net.IP
+	proto    uint8
+	srcPort  uint16
+	dstPort  uint16
+	hasPorts bool
+}
+
+func parseIPv4Packet(b []byte) (ipv4Packet, bool) {
+	var p ipv4Packet
+	if len(b) < 20 || b[0]>>4 != 4 {
+		return p, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if ihl < 20 || len(b) < ihl {
+		return p, false
+	}
+	p.src = net.IP(b[12:16])
+	p.dst = net.IP(b[16:20])
+	p.proto = b[9]
+
+	// Ports are only present in the first fragment
+	fragOffset := binary.BigEndian.Uint16(b[6:8]) & 0x1fff
+	if (p.proto == ProtoTCP || p.proto == ProtoUDP) && fragOffset == 0 && len(b) >= ihl+4 {
+		p.srcPort = binary.BigEndian.Uint16(b[ihl : ihl+2])
+		p.dstPort = binary.BigEndian.Uint16(b[ihl+2 : ihl+4])
+		p.hasPorts = true
+	}
+	return p, true
+}
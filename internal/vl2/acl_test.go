@@ -0,0 +1,150 @@
+package vl2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tcpFrame builds an IPv4 TCP frame from src to dst:port.
+func tcpFrame(t *testing.T, src, dst string, port uint16) *EthernetFrame {
+	t.Helper()
+	ip := make([]byte, 24)
+	ip[0] = 0x45
+	ip[9] = ProtoTCP
+	copy(ip[12:16], net.ParseIP(src).To4())
+	copy(ip[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(ip[20:22], 40000)
+	binary.BigEndian.PutUint16(ip[22:24], port)
+	return &EthernetFrame{EtherType: EtherTypeIPv4, Payload: ip}
+}
+
+func mustRule(t *testing.T, priority int, action, protocol, ports string, window *TimeWindow) Rule {
+	t.Helper()
+	r, err := ParseRule(uint(priority), priority, action, "", "", protocol, ports, window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestTimeWindowRule(t *testing.T) {
+	office, err := ParseTimeWindow("mon,tue,wed,thu,fri", "09:00", "17:00", "Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	// SSH is allowed in office hours and dropped otherwise
+	acl := NewACL(testLog())
+	acl.SetRules([]Rule{
+		mustRule(t, 10, "allow", "tcp", "22", office),
+		mustRule(t, 20, "drop", "tcp", "22", nil),
+	})
+	var now time.Time
+	acl.SetClock(func() time.Time { return now })
+	ssh := tcpFrame(t, "10.0.0.2", "10.0.0.3", 22)
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		allow bool
+	}{
+		{"wednesday morning", time.Date(2026, 10, 14, 9, 0, 0, 0, berlin), true},
+		{"wednesday afternoon", time.Date(2026, 10, 14, 16, 59, 0, 0, berlin), true},
+		{"wednesday evening", time.Date(2026, 10, 14, 17, 0, 0, 0, berlin), false},
+		{"before opening", time.Date(2026, 10, 14, 8, 59, 0, 0, berlin), false},
+		{"saturday noon", time.Date(2026, 10, 17, 12, 0, 0, 0, berlin), false},
+		{"office hours in UTC", time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC), true},
+		{"after hours in UTC", time.Date(2026, 10, 14, 16, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		now = tt.at
+		if got := acl.Allow(ssh); got != tt.allow {
+			t.Errorf("%s (%v): allow = %v, want %v", tt.name, tt.at, got, tt.allow)
+		}
+	}
+}
+
+func TestTimeWindowWrapsMidnight(t *testing.T) {
+	// A friday night window stays open into saturday morning
+	night, err := ParseTimeWindow("fri", "22:00", "06:00", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		at   time.Time
+		open bool
+	}{
+		{time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC), true},  // friday
+		{time.Date(2026, 10, 17, 5, 59, 0, 0, time.UTC), true},  // saturday morning
+		{time.Date(2026, 10, 17, 23, 0, 0, 0, time.UTC), false}, // saturday night
+		{time.Date(2026, 10, 16, 5, 0, 0, 0, time.UTC), false},  // thursday's night
+		{time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), false},
+	} {
+		if got := night.Active(tt.at); got != tt.open {
+			t.Errorf("Active(%v) = %v, want %v", tt.at, got, tt.open)
+		}
+	}
+}
+
+func TestParseTimeWindowInvalid(t *testing.T) {
+	for _, tt := range [][4]string{
+		{"funday", "09:00", "17:00", ""},
+		{"", "9am", "17:00", ""},
+		{"", "09:00", "25:00", ""},
+		{"", "09:00", "17:00", "Mars/Olympus"},
+	} {
+		if _, err := ParseTimeWindow(tt[0], tt[1], tt[2], tt[3]); err == nil {
+			t.Errorf("ParseTimeWindow%q accepted", tt)
+		}
+	}
+	if w, err := ParseTimeWindow("", "", "", ""); w != nil || err != nil {
+		t.Errorf("empty window = %v, %v, want none", w, err)
+	}
+}
+
+func TestRuleHitsAndLog(t *testing.T) {
+	var logs bytes.Buffer
+	acl := NewACL(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	acl.SetRules([]Rule{
+		mustRule(t, 10, "allow", "tcp", "22", nil),
+		mustRule(t, 20, "drop", "tcp", "", nil),
+	})
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	acl.SetClock(func() time.Time { return now })
+
+	if acl.Allow(tcpFrame(t, "10.0.0.2", "10.0.0.3", 80)) {
+		t.Fatal("frame to port 80 allowed")
+	}
+	acl.Allow(tcpFrame(t, "10.0.0.2", "10.0.0.3", 443)) // within the log interval
+
+	hits := acl.Hits()
+	if len(hits) != 2 || hits[0].Hits != 0 || hits[1].ID != 20 || hits[1].Action != RuleDrop || hits[1].Hits != 2 {
+		t.Fatalf("hits = %+v, want 2 on the drop rule", hits)
+	}
+	out := logs.String()
+	if n := strings.Count(out, "ACL rule matched"); n != 1 {
+		t.Fatalf("%d hit log lines, want 1 per interval:\n%s", n, out)
+	}
+	for _, want := range []string{"rule=20", "action=drop", "src=10.0.0.2:40000", "dst=10.0.0.3:80", "proto=6"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+
+	// A later hit logs again; replacing the rules resets the counters
+	now = now.Add(aclLogInterval)
+	acl.Allow(tcpFrame(t, "10.0.0.2", "10.0.0.3", 443))
+	if n := strings.Count(logs.String(), "ACL rule matched"); n != 2 {
+		t.Fatalf("%d hit log lines after the interval, want 2", n)
+	}
+	acl.SetRules([]Rule{mustRule(t, 20, "drop", "tcp", "", nil)})
+	if hits := acl.Hits(); hits[0].Hits != 0 {
+		t.Fatalf("hits after new rules = %+v", hits)
+	}
+}
@@ -10,11 +10,11 @@ import (
 
 // ARP constants
 const (
-	ARPHeaderSize    = 28 // ARP header for IPv4/Ethernet
-	ARPRequest       = 1
-	ARPReply         = 2
-	ARPCacheExpiry   = 5 * time.Minute
-	ARPCacheMaxSize  = 1024
+	ARPHeaderSize   = 28 // ARP header for IPv4/Ethernet
+	ARPRequest      = 1
+	ARPReply        = 2
+	ARPCacheExpiry  = 5 * time.Minute // default; see TableLimits
+	ARPCacheMaxSize = 1024            // default; see TableLimits
 )
 
 // ARPEntry maps an IP address to a MAC address.
@@ -27,16 +27,49 @@ type ARPEntry struct {
 // ARPProxy intercepts ARP requests and replies from cache when possible,
 // reducing broadcast traffic across the virtual network.
 type ARPProxy struct {
-	cache map[[4]byte]*ARPEntry // IPv4 → MAC
-	mu    sync.RWMutex
-	log   *slog.Logger
+	cache   map[[4]byte]*ARPEntry // IPv4 → MAC
+	maxSize int
+	expiry  time.Duration
+	mu      sync.RWMutex
+	log     *slog.Logger
 }
 
-// NewARPProxy creates a new ARP proxy.
-func NewARPProxy(log *slog.Logger) *ARPProxy {
+// NewARPProxy creates a new ARP proxy whose cache is sized from limits.
+func NewARPProxy(limits TableLimits, log *slog.Logger) *ARPProxy {
+	limits = limits.WithDefaults()
 	return &ARPProxy{
-		cache: make(map[[4]byte]*ARPEntry),
-		log:   log.With("component", "arp-proxy"),
+		cache:   make(map[[4]byte]*ARPEntry),
+		maxSize: limits.ARPCacheSize,
+		expiry:  limits.ARPCacheExpiry,
+		log:     log.With("component", "arp-proxy"),
+	}
+}
+
+// SetLimits changes the cache size and expiry, evicting the oldest
+// unpinned entries if the cache is over the new size.
+func (a *ARPProxy) SetLimits(limits TableLimits) {
+	limits = limits.WithDefaults()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxSize = limits.ARPCacheSize
+	a.expiry = limits.ARPCacheExpiry
+	for len(a.cache) > a.maxSize && a.evictOldest() {
+	}
+}
+
+// fresh reports whether a cache entry is still valid.
+func (a *ARPProxy) fresh(e *ARPEntry) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return time.Since(e.LastSeen) < a.expiry
+}
+
+// makeRoom evicts entries until one more fits. Called with a.mu held.
+func (a *ARPProxy) makeRoom() {
+	for len(a.cache) >= a.maxSize {
+		if !a.evictOldest() {
+			return
+		}
 	}
 }
 
@@ -49,11 +82,11 @@ func (a *ARPProxy) HandleARP(frame *EthernetFrame) []byte {
 	payload := frame.Payload
 
 	// Parse ARP header
-	htype := binary.BigEndian.Uint16(payload[0:2])  // Hardware type
-	ptype := binary.BigEndian.Uint16(payload[2:4])  // Protocol type
-	hlen := payload[4]                               // Hardware addr length
-	plen := payload[5]                               // Protocol addr length
-	oper := binary.BigEndian.Uint16(payload[6:8])    // Operation
+	htype := binary.BigEndian.Uint16(payload[0:2]) // Hardware type
+	ptype := binary.BigEndian.Uint16(payload[2:4]) // Protocol type
+	hlen := payload[4]                             // Hardware addr length
+	plen := payload[5]                             // Protocol addr length
+	oper := binary.BigEndian.Uint16(payload[6:8])  // Operation
 
 	// We only handle Ethernet (1) + IPv4 (0x0800)
 	if htype != 1 || ptype != 0x0800 || hlen != 6 || plen != 4 {
@@ -74,7 +107,7 @@ func (a *ARPProxy) HandleARP(frame *EthernetFrame) []byte {
 		entry, found := a.cache[targetIP]
 		a.mu.RUnlock()
 
-		if found && time.Since(entry.LastSeen) < ARPCacheExpiry {
+		if found && a.fresh(entry) {
 			a.log.Debug("ARP proxy hit", "ip", net.IP(targetIP[:]), "mac", entry.MAC)
 			return a.buildARPReply(frame, entry.MAC, senderMAC, senderIP, targetIP)
 		}
@@ -120,7 +153,7 @@ func (a *ARPProxy) Lookup(ip net.IP) net.HardwareAddr {
 	a.mu.RLock()
 	entry, found := a.cache[key]
 	a.mu.RUnlock()
-	if found && time.Since(entry.LastSeen) < ARPCacheExpiry {
+	if found && a.fresh(entry) {
 		return entry.MAC
 	}
 	return nil
@@ -137,9 +170,7 @@ func (a *ARPProxy) Learn(ip net.IP, mac net.HardwareAddr) {
 	copy(key[:], ip4)
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if len(a.cache) >= ARPCacheMaxSize {
-		a.evictOldest()
-	}
+	a.makeRoom()
 	macCopy := make(net.HardwareAddr, 6)
 	copy(macCopy, mac)
 	a.cache[key] = &ARPEntry{
@@ -153,9 +184,7 @@ func (a *ARPProxy) Learn(ip net.IP, mac net.HardwareAddr) {
 func (a *ARPProxy) learn(ip [4]byte, mac net.HardwareAddr) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if len(a.cache) >= ARPCacheMaxSize {
-		a.evictOldest()
-	}
+	a.makeRoom()
 	macCopy := make(net.HardwareAddr, 6)
 	copy(macCopy, mac)
 	a.cache[ip] = &ARPEntry{
@@ -169,14 +198,14 @@ func (a *ARPProxy) buildARPReply(originalFrame *EthernetFrame, targetMAC, sender
 	frame := make([]byte, EthernetHeaderSize+ARPHeaderSize)
 
 	// Ethernet header
-	copy(frame[0:6], senderMAC)   // dst: original sender
-	copy(frame[6:12], targetMAC)  // src: the resolved MAC
+	copy(frame[0:6], senderMAC)  // dst: original sender
+	copy(frame[6:12], targetMAC) // src: the resolved MAC
 	binary.BigEndian.PutUint16(frame[12:14], EtherTypeARP)
 
 	// ARP reply
 	arp := frame[EthernetHeaderSize:]
-	binary.BigEndian.PutUint16(arp[0:2], 1)      // htype: Ethernet
-	binary.BigEndian.PutUint16(arp[2:4], 0x0800)  // ptype: IPv4
+	binary.BigEndian.PutUint16(arp[0:2], 1)        // htype: Ethernet
+	binary.BigEndian.PutUint16(arp[2:4], 0x0800)   // ptype: IPv4
 	arp[4] = 6                                     // hlen
 	arp[5] = 4                                     // plen
 	binary.BigEndian.PutUint16(arp[6:8], ARPReply) // operation
@@ -188,7 +217,7 @@ func (a *ARPProxy) buildARPReply(originalFrame *EthernetFrame, targetMAC, sender
 	return frame
 }
 
-func (a *ARPProxy) evictOldest() {
+func (a *ARPProxy) evictOldest() bool {
 	var oldestKey [4]byte
 	var oldestTime time.Time
 	first := true
@@ -205,6 +234,7 @@ func (a *ARPProxy) evictOldest() {
 	if !first {
 		delete(a.cache, oldestKey)
 	}
+	return !first
 }
 
 // CleanExpired removes expired entries from the ARP cache.
@@ -212,7 +242,7 @@ func (a *ARPProxy) evictOldest() {
 func (a *ARPProxy) CleanExpired() int {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	cutoff := time.Now().Add(-ARPCacheExpiry)
+	cutoff := time.Now().Add(-a.expiry)
 	removed := 0
 	for k, v := range a.cache {
 		if !v.Pinned && v.LastSeen.Before(cutoff) {
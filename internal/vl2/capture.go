@@ -0,0 +1,257 @@
+package vl2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Capture defaults.
+const (
+	DefaultCaptureSnapLen = 65535
+	// captureQueue is how many frames may wait for the writer; frames
+	// beyond it are dropped so a slow writer never stalls the switch.
+	captureQueue = 1024
+
+	pcapMagic        = 0xa1b2c3d4
+	pcapLinkEthernet = 1
+	pcapHeaderSize   = 24
+	pcapRecordHeader = 16
+)
+
+// CaptureFilter selects the frames a capture records. Zero fields match
+// any frame.
+type CaptureFilter struct {
+	Host      net.HardwareAddr // source or destination MAC
+	Src       net.HardwareAddr
+	Dst       net.HardwareAddr
+	EtherType uint16
+}
+
+// ParseCaptureFilter parses a small subset of tcpdump filter syntax: terms
+// joined by "and", each one of "ether host MAC", "ether src MAC",
+// "ether dst MAC", "ether proto N", "arp", "ip" or "ip6". An empty string
+// matches everything.
+func ParseCaptureFilter(s string) (CaptureFilter, error) {
+	var f CaptureFilter
+	fields := strings.Fields(strings.ToLower(s))
+	for len(fields) > 0 {
+		var n int
+		switch fields[0] {
+		case "arp":
+			f.EtherType, n = EtherTypeARP, 1
+		case "ip":
+			f.EtherType, n = EtherTypeIPv4, 1
+		case "ip6":
+			f.EtherType, n = EtherTypeIPv6, 1
+		case "ether":
+			if len(fields) < 3 {
+				return f, fmt.Errorf("incomplete filter term %q", strings.Join(fields, " "))
+			}
+			n = 3
+			if fields[1] == "proto" {
+				v, err := strconv.ParseUint(strings.TrimPrefix(fields[2], "0x"), 16, 16)
+				if err != nil {
+					return f, fmt.Errorf("invalid ethertype %q", fields[2])
+				}
+				f.EtherType = uint16(v)
+				break
+			}
+			mac, err := net.ParseMAC(fields[2])
+			if err != nil {
+				return f, fmt.Errorf("invalid MAC %q", fields[2])
+			}
+			switch fields[1] {
+			case "host":
+				f.Host = mac
+			case "src":
+				f.Src = mac
+			case "dst":
+				f.Dst = mac
+			default:
+				return f, fmt.Errorf("unknown filter term \"ether %s\"", fields[1])
+			}
+		default:
+			return f, fmt.Errorf("unknown filter term %q", fields[0])
+		}
+		fields = fields[n:]
+		if len(fields) > 0 {
+			if fields[0] != "and" || len(fields) == 1 {
+				return f, fmt.Errorf("expected \"and\" between filter terms")
+			}
+			fields = fields[1:]
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether a raw Ethernet frame passes the filter.
+func (f CaptureFilter) Match(frame []byte) bool {
+	if len(frame) < EthernetHeaderSize {
+		return false
+	}
+	dst, src := frame[0:6], frame[6:12]
+	if f.Host != nil && !bytes.Equal(dst, f.Host) && !bytes.Equal(src, f.Host) {
+		return false
+	}
+	if f.Src != nil && !bytes.Equal(src, f.Src) {
+		return false
+	}
+	if f.Dst != nil && !bytes.Equal(dst, f.Dst) {
+		return false
+	}
+	return f.EtherType == 0 || binary.BigEndian.Uint16(frame[12:14]) == f.EtherType
+}
+
+// CaptureOptions configures a Capture.
+type CaptureOptions struct {
+	Filter CaptureFilter
+	// SnapLen truncates recorded frames (0 = DefaultCaptureSnapLen).
+	SnapLen int
+	// MaxBytes stops the capture once this much pcap output is written
+	// (0 = unlimited).
+	MaxBytes int64
+}
+
+// Capture mirrors frames into a pcap stream, e.g. a file or the agent's
+// status endpoint. Frames are queued and written by a background goroutine.
+type Capture struct {
+	w    io.Writer
+	opts CaptureOptions
+
+	frames  chan capturedFrame
+	done    chan struct{}
+	mu      sync.RWMutex // held for writing to close frames
+	stopped bool
+	full    atomic.Bool
+	written int64 // only touched by the writer goroutine
+	err     error
+
+	captured atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+type capturedFrame struct {
+	at     time.Time
+	data   []byte
+	length int
+}
+
+// NewCapture writes the pcap file header to w and starts recording frames
+// passed to Mirror.
+func NewCapture(w io.Writer, opts CaptureOptions) (*Capture, error) {
+	if opts.SnapLen <= 0 || opts.SnapLen > DefaultCaptureSnapLen {
+		opts.SnapLen = DefaultCaptureSnapLen
+	}
+	if opts.MaxBytes > 0 && opts.MaxBytes < pcapHeaderSize {
+		return nil, fmt.Errorf("capture size cap %d is smaller than the pcap header", opts.MaxBytes)
+	}
+
+	var hdr [pcapHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2) // version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], uint32(opts.SnapLen))
+	binary.LittleEndian.PutUint32(hdr[20:24], pcapLinkEthernet)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, fmt.Errorf("write pcap header: %w", err)
+	}
+
+	c := &Capture{
+		w:       w,
+		opts:    opts,
+		frames:  make(chan capturedFrame, captureQueue),
+		done:    make(chan struct{}),
+		written: pcapHeaderSize,
+	}
+	go c.writeLoop()
+	return c, nil
+}
+
+// Mirror records a copy of frame if it passes the filter. It never blocks:
+// frames arriving faster than they can be written are counted as dropped.
+func (c *Capture) Mirror(frame []byte) {
+	if c.full.Load() || !c.opts.Filter.Match(frame) {
+		return
+	}
+	n := min(len(frame), c.opts.SnapLen)
+	cf := capturedFrame{at: time.Now(), data: make([]byte, n), length: len(frame)}
+	copy(cf.data, frame)
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.stopped {
+		return
+	}
+	select {
+	case c.frames <- cf:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+func (c *Capture) writeLoop() {
+	defer close(c.done)
+	for cf := range c.frames {
+		if c.err != nil {
+			continue // drain after a write error or the size cap
+		}
+		size := int64(pcapRecordHeader + len(cf.data))
+		if c.opts.MaxBytes > 0 && c.written+size > c.opts.MaxBytes {
+			c.err = errCaptureFull
+			c.full.Store(true)
+			continue
+		}
+		var rec [pcapRecordHeader]byte
+		binary.LittleEndian.PutUint32(rec[0:4], uint32(cf.at.Unix()))
+		binary.LittleEndian.PutUint32(rec[4:8], uint32(cf.at.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(rec[8:12], uint32(len(cf.data)))
+		binary.LittleEndian.PutUint32(rec[12:16], uint32(cf.length))
+		if _, err := c.w.Write(append(rec[:], cf.data...)); err != nil {
+			c.err = err
+			c.full.Store(true)
+			continue
+		}
+		c.written += size
+		c.captured.Add(1)
+	}
+}
+
+// errCaptureFull stops a capture that reached its size cap.
+var errCaptureFull = errors.New("capture size cap reached")
+
+// Full reports whether the capture stopped recording, because it reached
+// its size cap or its writer failed.
+func (c *Capture) Full() bool {
+	return c.full.Load()
+}
+
+// Close stops recording and waits for queued frames to be written. It
+// returns the writer's error, if any; reaching the size cap is not one.
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	if !c.stopped {
+		c.stopped = true
+		close(c.frames)
+	}
+	c.mu.Unlock()
+	<-c.done
+	if c.err == errCaptureFull {
+		return nil
+	}
+	return c.err
+}
+
+// Stats returns how many frames were written and how many were dropped
+// because the writer fell behind.
+func (c *Capture) Stats() (captured, dropped uint64) {
+	return c.captured.Load(), c.dropped.Load()
+}
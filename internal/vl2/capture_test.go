@@ -0,0 +1,125 @@
+package vl2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+type pcapRecord struct {
+	at            time.Time
+	data          []byte
+	inclLen, orig int
+}
+
+// readPcap checks a pcap stream's file header and returns its records.
+func readPcap(t *testing.T, b []byte, snapLen int) []pcapRecord {
+	t.Helper()
+	if len(b) < pcapHeaderSize {
+		t.Fatalf("pcap stream of %d bytes", len(b))
+	}
+	le := binary.LittleEndian
+	if le.Uint32(b[0:4]) != pcapMagic || le.Uint16(b[4:6]) != 2 || le.Uint16(b[6:8]) != 4 ||
+		le.Uint32(b[16:20]) != uint32(snapLen) || le.Uint32(b[20:24]) != pcapLinkEthernet {
+		t.Fatalf("pcap header % x", b[:pcapHeaderSize])
+	}
+	var recs []pcapRecord
+	for b = b[pcapHeaderSize:]; len(b) > 0; {
+		if len(b) < pcapRecordHeader {
+			t.Fatalf("truncated record header: % x", b)
+		}
+		r := pcapRecord{
+			at:      time.Unix(int64(le.Uint32(b[0:4])), int64(le.Uint32(b[4:8]))*1000),
+			inclLen: int(le.Uint32(b[8:12])),
+			orig:    int(le.Uint32(b[12:16])),
+		}
+		b = b[pcapRecordHeader:]
+		if r.inclLen > len(b) || r.inclLen > snapLen || r.inclLen > r.orig {
+			t.Fatalf("record lengths %d/%d with %d bytes left", r.inclLen, r.orig, len(b))
+		}
+		r.data, b = b[:r.inclLen], b[r.inclLen:]
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+func TestCaptureWritesPcap(t *testing.T) {
+	n := NewNetwork(NetworkConfig{ID: 1, Name: "test"}, identity.Address{1}, newRecordingSender(), testLog())
+	local := mustMAC(t, "02:00:00:00:00:01")
+	remote := mustMAC(t, "02:00:00:00:00:02")
+	filter, err := ParseCaptureFilter("ip and ether host 02:00:00:00:00:02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	c, err := NewCapture(&out, CaptureOptions{Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !n.Switch.AttachMirror(c) {
+		t.Fatal("mirror not attached")
+	}
+
+	start := time.Now().Truncate(time.Second)
+	outbound, inbound := ethFrame(remote, local), ethFrame(local, remote)
+	arp := ethFrame(remote, local)
+	binary.BigEndian.PutUint16(arp[12:14], EtherTypeARP)
+	n.Switch.HandleLocalFrame(outbound)
+	n.Switch.HandleRemoteFrame(identity.Address{2}, inbound)
+	n.Switch.HandleLocalFrame(arp)                                              // filtered out
+	n.Switch.HandleLocalFrame(ethFrame(mustMAC(t, "02:00:00:00:00:03"), local)) // another host
+	n.Switch.DetachMirror(c)
+	n.Switch.HandleLocalFrame(outbound) // after detaching
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := readPcap(t, out.Bytes(), DefaultCaptureSnapLen)
+	if len(recs) != 2 {
+		t.Fatalf("%d records, want 2", len(recs))
+	}
+	for i, want := range [][]byte{outbound, inbound} {
+		r := recs[i]
+		if !bytes.Equal(r.data, want) || r.orig != len(want) || r.at.Before(start) || r.at.After(time.Now()) {
+			t.Errorf("record %d: % x (%d bytes) at %v, want % x", i, r.data, r.orig, r.at, want)
+		}
+	}
+	if captured, dropped := c.Stats(); captured != 2 || dropped != 0 {
+		t.Errorf("stats = %d captured, %d dropped", captured, dropped)
+	}
+}
+
+func TestCaptureSnapLenAndSizeCap(t *testing.T) {
+	var out bytes.Buffer
+	const snapLen = 20
+	c, err := NewCapture(&out, CaptureOptions{SnapLen: snapLen, MaxBytes: pcapHeaderSize + 2*(pcapRecordHeader+snapLen)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := ethFrame(mustMAC(t, "02:00:00:00:00:02"), mustMAC(t, "02:00:00:00:00:01"))
+	for range 3 {
+		c.Mirror(frame)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Full() {
+		t.Error("capture not full after passing its size cap")
+	}
+	recs := readPcap(t, out.Bytes(), snapLen)
+	if len(recs) != 2 || recs[0].inclLen != snapLen || recs[0].orig != len(frame) || !bytes.Equal(recs[0].data, frame[:snapLen]) {
+		t.Fatalf("records = %+v, want 2 truncated to %d bytes", recs, snapLen)
+	}
+
+	if _, err := NewCapture(&out, CaptureOptions{MaxBytes: pcapHeaderSize - 1}); err == nil {
+		t.Error("size cap below the header accepted")
+	}
+	for _, s := range []string{"tcp", "ether host", "ether proto zz", "ether src nope", "ip and", "ip arp"} {
+		if _, err := ParseCaptureFilter(s); err == nil {
+			t.Errorf("filter %q accepted", s)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package vl2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DHCP constants
+const (
+	DHCPServerPort       = 67
+	DHCPClientPort       = 68
+	DHCPDefaultLeaseTime = time.Hour
+	// DHCPOfferTimeout is how long an offered address is held for the client
+	// to request it.
+	DHCPOfferTimeout = time.Minute
+
+	dhcpHeaderSize  = 236 // fixed BOOTP fields, before the magic cookie
+	dhcpMinSize     = 300 // minimum BOOTP message size clients expect
+	dhcpMagicCookie = 0x63825363
+)
+
+// DHCP message types (option 53)
+const (
+	DHCPDiscover = 1
+	DHCPOffer    = 2
+	DHCPRequest  = 3
+	DHCPDecline  = 4
+	DHCPAck      = 5
+	DHCPNak      = 6
+	DHCPRelease  = 7
+)
+
+// DHCP options used by the server
+const (
+	dhcpOptSubnetMask    = 1
+	dhcpOptRouter        = 3
+	dhcpOptRequestedIP   = 50
+	dhcpOptLeaseTime     = 51
+	dhcpOptMessageType   = 53
+	dhcpOptServerID      = 54
+	dhcpOptRenewalTime   = 58
+	dhcpOptRebindingTime = 59
+	dhcpOptPad           = 0
+	dhcpOptEnd           = 255
+)
+
+// DHCPConfig configures a DHCP server for one network.
+type DHCPConfig struct {
+	ServerIP  netip.Addr   // address replies come from (this member's overlay IP)
+	Subnet    netip.Prefix // the network's IP range
+	PoolStart netip.Addr   // first address handed out
+	PoolEnd   netip.Addr   // last address handed out (inclusive)
+	Router    netip.Addr   // optional default gateway advertised to clients
+	LeaseTime time.Duration
+}
+
+// DHCPLease is an address leased (or offered) to a client.
+type DHCPLease struct {
+	MAC     net.HardwareAddr
+	IP      netip.Addr
+	Expires time.Time
+	Bound   bool // false while only offered
+}
+
+// DHCPServer leases overlay addresses to hosts behind a TAP device. The pool
+// should be a reserved range of the network so the controller never assigns
+// its addresses to members; addresses the controller did assign are also
+// excluded via SetInUse.
+type DHCPServer struct {
+	cfg      DHCPConfig
+	mac      net.HardwareAddr // source MAC of replies
+	leases   map[MACKey]*DHCPLease
+	byIP     map[netip.Addr]MACKey
+	inUse    map[netip.Addr]bool      // assigned to members by the controller
+	declined map[netip.Addr]time.Time // reported in use by a client, until
+	now      func() time.Time
+	mu       sync.Mutex
+	log      *slog.Logger
+}
+
+// NewDHCPServer creates a DHCP server answering from mac.
+func NewDHCPServer(cfg DHCPConfig, mac net.HardwareAddr, log *slog.Logger) (*DHCPServer, error) {
+	if !cfg.ServerIP.Is4() || !cfg.Subnet.Addr().Is4() {
+		return nil, fmt.Errorf("DHCP needs an IPv4 server address and subnet")
+	}
+	cfg.Subnet = cfg.Subnet.Masked()
+	if !cfg.PoolStart.Is4() || !cfg.PoolEnd.Is4() || cfg.PoolEnd.Less(cfg.PoolStart) {
+		return nil, fmt.Errorf("invalid DHCP pool %s-%s", cfg.PoolStart, cfg.PoolEnd)
+	}
+	if !cfg.Subnet.Contains(cfg.PoolStart) || !cfg.Subnet.Contains(cfg.PoolEnd) {
+		return nil, fmt.Errorf("DHCP pool %s-%s is outside %s", cfg.PoolStart, cfg.PoolEnd, cfg.Subnet)
+	}
+	if cfg.LeaseTime <= 0 {
+		cfg.LeaseTime = DHCPDefaultLeaseTime
+	}
+	return &DHCPServer{
+		cfg:      cfg,
+		mac:      append(net.HardwareAddr{}, mac...),
+		leases:   make(map[MACKey]*DHCPLease),
+		byIP:     make(map[netip.Addr]MACKey),
+		inUse:    make(map[netip.Addr]bool),
+		declined: make(map[netip.Addr]time.Time),
+		now:      time.Now,
+		log:      log.With("component", "dhcp"),
+	}, nil
+}
+
+// SetInUse replaces the set of addresses assigned to members by the
+// controller. Leases on those addresses are revoked, so the clients get a
+// NAK on renewal and pick a new address.
+func (s *DHCPServer) SetInUse(addrs []netip.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse = make(map[netip.Addr]bool, len(addrs))
+	for _, a := range addrs {
+		s.markInUseLocked(a)
+	}
+}
+
+// AddInUse marks one more address as assigned by the controller.
+func (s *DHCPServer) AddInUse(addr netip.Addr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markInUseLocked(addr)
+}
+
+func (s *DHCPServer) markInUseLocked(addr netip.Addr) {
+	addr = addr.Unmap()
+	s.inUse[addr] = true
+	if key, ok := s.byIP[addr]; ok {
+		s.log.Warn("DHCP lease conflicts with a member address, revoking", "ip", addr, "mac", net.HardwareAddr(key[:]).String())
+		s.removeLocked(key)
+	}
+}
+
+// Leases returns the current bound leases.
+func (s *DHCPServer) Leases() []DHCPLease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var out []DHCPLease
+	for _, l := range s.leases {
+		if l.Bound && now.Before(l.Expires) {
+			out = append(out, *l)
+		}
+	}
+	return out
+}
+
+// IsDHCPRequest reports whether frame is a UDP datagram to the DHCP server
+// port.
+func IsDHCPRequest(frame *EthernetFrame) bool {
+	_, ok := dhcpPayload(frame)
+	return ok
+}
+
+// HandleDHCP processes a DHCP client message and returns the reply frame, or
+// nil if there is nothing to send.
+func (s *DHCPServer) HandleDHCP(frame *EthernetFrame) []byte {
+	msg, ok := dhcpPayload(frame)
+	if !ok || len(msg) < dhcpHeaderSize+4 {
+		return nil
+	}
+	// BOOTREQUEST over Ethernet only
+	if msg[0] != 1 || msg[1] != 1 || msg[2] != 6 {
+		return nil
+	}
+	if binary.BigEndian.Uint32(msg[dhcpHeaderSize:]) != dhcpMagicCookie {
+		return nil
+	}
+	opts := parseDHCPOptions(msg[dhcpHeaderSize+4:])
+	if len(opts[dhcpOptMessageType]) != 1 {
+		return nil
+	}
+	chaddr := net.HardwareAddr(msg[28:34])
+	ciaddr := addrFrom4(msg[12:16])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch opts[dhcpOptMessageType][0] {
+	case DHCPDiscover:
+		ip, ok := s.allocateLocked(chaddr, addrFrom4(opts[dhcpOptRequestedIP]))
+		if !ok {
+			s.log.Warn("DHCP pool exhausted", "mac", chaddr.String())
+			return nil
+		}
+		s.log.Debug("DHCP offer", "mac", chaddr.String(), "ip", ip)
+		return s.reply(msg, DHCPOffer, ip)
+
+	case DHCPRequest:
+		if id := addrFrom4(opts[dhcpOptServerID]); id.IsValid() && id != s.cfg.ServerIP {
+			// The client chose another server's offer
+			if l, ok := s.leases[MACToKey(chaddr)]; ok && !l.Bound {
+				s.removeLocked(MACToKey(chaddr))
+			}
+			return nil
+		}
+		// SELECTING and INIT-REBOOT carry the address in option 50;
+		// RENEWING and REBINDING in ciaddr
+		ip := addrFrom4(opts[dhcpOptRequestedIP])
+		if !ip.IsValid() {
+			ip = ciaddr
+		}
+		if !ip.IsValid() || !s.bindLocked(chaddr, ip) {
+			s.log.Debug("DHCP nak", "mac", chaddr.String(), "requested", ip)
+			return s.reply(msg, DHCPNak, netip.Addr{})
+		}
+		s.log.Info("DHCP lease", "mac", chaddr.String(), "ip", ip, "expires_in", s.cfg.LeaseTime)
+		return s.reply(msg, DHCPAck, ip)
+
+	case DHCPDecline:
+		if ip := addrFrom4(opts[dhcpOptRequestedIP]); ip.IsValid() {
+			s.log.Warn("DHCP address declined by client", "mac", chaddr.String(), "ip", ip)
+			s.declined[ip] = s.now().Add(s.cfg.LeaseTime)
+		}
+		s.removeLocked(MACToKey(chaddr))
+
+	case DHCPRelease:
+		if l, ok := s.leases[MACToKey(chaddr)]; ok && l.IP == ciaddr {
+			s.log.Debug("DHCP release", "mac", chaddr.String(), "ip", ciaddr)
+			s.removeLocked(MACToKey(chaddr))
+		}
+	}
+	return nil
+}
+
+// allocateLocked picks an address for a DISCOVER: the client's current lease,
+// then the address it asked for, then the first free one in the pool. The
+// address is held for DHCPOfferTimeout.
+func (s *DHCPServer) allocateLocked(mac net.HardwareAddr, requested netip.Addr) (netip.Addr, bool) {
+	key := MACToKey(mac)
+	now := s.now()
+
+	ip := netip.Addr{}
+	if l, ok := s.leases[key]; ok && s.availableLocked(l.IP, key, now) {
+		ip = l.IP
+	} else if requested.IsValid() && s.availableLocked(requested, key, now) {
+		ip = requested
+	} else {
+		for a := s.cfg.PoolStart; a.IsValid() && !s.cfg.PoolEnd.Less(a); a = a.Next() {
+			if s.availableLocked(a, key, now) {
+				ip = a
+				break
+			}
+		}
+	}
+	if !ip.IsValid() {
+		return ip, false
+	}
+
+	l := s.setLocked(key, mac, ip)
+	if !l.Bound || l.Expires.Before(now.Add(DHCPOfferTimeout)) {
+		l.Expires = now.Add(DHCPOfferTimeout)
+	}
+	return ip, true
+}
+
+// bindLocked commits a lease of ip to mac if the address is free for it.
+func (s *DHCPServer) bindLocked(mac net.HardwareAddr, ip netip.Addr) bool {
+	key := MACToKey(mac)
+	now := s.now()
+	if !s.availableLocked(ip, key, now) {
+		return false
+	}
+	l := s.setLocked(key, mac, ip)
+	l.Bound = true
+	l.Expires = now.Add(s.cfg.LeaseTime)
+	return true
+}
+
+// availableLocked reports whether ip may be leased to the client key.
+func (s *DHCPServer) availableLocked(ip netip.Addr, key MACKey, now time.Time) bool {
+	if ip.Less(s.cfg.PoolStart) || s.cfg.PoolEnd.Less(ip) {
+		return false
+	}
+	if ip == s.cfg.ServerIP || ip == s.cfg.Router || s.inUse[ip] {
+		return false
+	}
+	if until, ok := s.declined[ip]; ok {
+		if now.Before(until) {
+			return false
+		}
+		delete(s.declined, ip)
+	}
+	owner, ok := s.byIP[ip]
+	return !ok || owner == key || !now.Before(s.leases[owner].Expires)
+}
+
+// setLocked points the client's lease at ip, taking it over from an expired
+// holder if needed.
+func (s *DHCPServer) setLocked(key MACKey, mac net.HardwareAddr, ip netip.Addr) *DHCPLease {
+	if owner, ok := s.byIP[ip]; ok && owner != key {
+		s.removeLocked(owner)
+	}
+	l, ok := s.leases[key]
+	if !ok {
+		l = &DHCPLease{MAC: append(net.HardwareAddr{}, mac...)}
+		s.leases[key] = l
+	}
+	if l.IP != ip {
+		if l.IP.IsValid() {
+			delete(s.byIP, l.IP)
+			l.Bound = false
+		}
+		l.IP = ip
+		s.byIP[ip] = key
+	}
+	return l
+}
+
+func (s *DHCPServer) removeLocked(key MACKey) {
+	if l, ok := s.leases[key]; ok {
+		delete(s.byIP, l.IP)
+		delete(s.leases, key)
+	}
+}
+
+// reply builds the Ethernet frame answering req. ip is the address offered
+// or acknowledged (invalid for a NAK).
+func (s *DHCPServer) reply(req []byte, msgType byte, ip netip.Addr) []byte {
+	msg := make([]byte, dhcpHeaderSize+4, dhcpMinSize)
+	msg[0] = 2 // BOOTREPLY
+	msg[1] = 1
+	msg[2] = 6
+	copy(msg[4:8], req[4:8])     // xid
+	copy(msg[10:12], req[10:12]) // flags
+	if msgType != DHCPNak {
+		copy(msg[12:16], req[12:16]) // ciaddr
+		yiaddr := ip.As4()
+		copy(msg[16:20], yiaddr[:])
+	}
+	copy(msg[24:28], req[24:28]) // giaddr
+	copy(msg[28:44], req[28:44]) // chaddr
+	binary.BigEndian.PutUint32(msg[dhcpHeaderSize:], dhcpMagicCookie)
+
+	serverID := s.cfg.ServerIP.As4()
+	msg = append(msg, dhcpOptMessageType, 1, msgType)
+	msg = append(msg, dhcpOptServerID, 4)
+	msg = append(msg, serverID[:]...)
+	if msgType != DHCPNak {
+		lease := uint32(s.cfg.LeaseTime / time.Second)
+		msg = appendDHCPUint32(msg, dhcpOptLeaseTime, lease)
+		msg = appendDHCPUint32(msg, dhcpOptRenewalTime, lease/2)
+		msg = appendDHCPUint32(msg, dhcpOptRebindingTime, lease/8*7)
+		mask := net.CIDRMask(s.cfg.Subnet.Bits(), 32)
+		msg = append(msg, dhcpOptSubnetMask, 4)
+		msg = append(msg, mask...)
+		if s.cfg.Router.Is4() {
+			router := s.cfg.Router.As4()
+			msg = append(msg, dhcpOptRouter, 4)
+			msg = append(msg, router[:]...)
+		}
+	}
+	msg = append(msg, dhcpOptEnd)
+	for len(msg) < dhcpMinSize {
+		msg = append(msg, dhcpOptPad)
+	}
+
+	// Renewing clients have an address and get a unicast reply; everyone
+	// else gets a broadcast, since they cannot receive unicast yet
+	dst := netip.AddrFrom4([4]byte{255, 255, 255, 255})
+	if ci := addrFrom4(req[12:16]); ci.IsValid() && msgType != DHCPNak {
+		dst = ci
+	}
+	return buildUDPFrame(net.HardwareAddr(req[28:34]), s.mac, s.cfg.ServerIP, dst, DHCPServerPort, DHCPClientPort, msg)
+}
+
+// dhcpPayload returns the UDP payload of an IPv4 frame sent to the DHCP
+// server port.
+func dhcpPayload(frame *EthernetFrame) ([]byte, bool) {
+	if frame.EtherType != EtherTypeIPv4 {
+		return nil, false
+	}
+	b := frame.Payload
+	if len(b) < 20 || b[0]>>4 != 4 || b[9] != ProtoUDP {
+		return nil, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if ihl < 20 || len(b) < ihl+8 || binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+		return nil, false
+	}
+	udp := b[ihl:]
+	if binary.BigEndian.Uint16(udp[2:4]) != DHCPServerPort {
+		return nil, false
+	}
+	end := int(binary.BigEndian.Uint16(udp[4:6]))
+	if end < 8 || end > len(udp) {
+		end = len(udp)
+	}
+	return udp[8:end], true
+}
+
+// parseDHCPOptions parses the options area into a code → value map.
+func parseDHCPOptions(b []byte) map[byte][]byte {
+	opts := make(map[byte][]byte)
+	for i := 0; i < len(b); {
+		code := b[i]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			i++
+			continue
+		}
+		if i+1 >= len(b) || i+2+int(b[i+1]) > len(b) {
+			break
+		}
+		opts[code] = b[i+2 : i+2+int(b[i+1])]
+		i += 2 + int(b[i+1])
+	}
+	return opts
+}
+
+func appendDHCPUint32(b []byte, code byte, v uint32) []byte {
+	b = append(b, code, 4)
+	return binary.BigEndian.AppendUint32(b, v)
+}
+
+// addrFrom4 converts a 4-byte field to an address; all zeros and short
+// fields yield the invalid address.
+func addrFrom4(b []byte) netip.Addr {
+	if len(b) != 4 {
+		return netip.Addr{}
+	}
+	a := netip.AddrFrom4([4]byte(b))
+	if a.IsUnspecified() {
+		return netip.Addr{}
+	}
+	return a
+}
+
+// buildUDPFrame wraps payload in UDP, IPv4 and Ethernet headers. The UDP
+// checksum is left zero, which IPv4 allows.
+func buildUDPFrame(dstMAC, srcMAC net.HardwareAddr, src, dst netip.Addr, srcPort, dstPort uint16, payload []byte) []byte {
+	const ipHeaderSize, udpHeaderSize = 20, 8
+	frame := make([]byte, EthernetHeaderSize+ipHeaderSize+udpHeaderSize+len(payload))
+
+	copy(frame[0:6], dstMAC)
+	copy(frame[6:12], srcMAC)
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypeIPv4)
+
+	ip := frame[EthernetHeaderSize:]
+	ip[0] = 0x45 // version 4, 20-byte header
+	binary.BigEndian.PutUint16(ip[2:4], uint16(ipHeaderSize+udpHeaderSize+len(payload)))
+	ip[8] = 64 // TTL
+	ip[9] = ProtoUDP
+	src4, dst4 := src.As4(), dst.As4()
+	copy(ip[12:16], src4[:])
+	copy(ip[16:20], dst4[:])
+	binary.BigEndian.PutUint16(ip[10:12], ipChecksum(ip[:ipHeaderSize]))
+
+	udp := ip[ipHeaderSize:]
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(udpHeaderSize+len(payload)))
+	copy(udp[udpHeaderSize:], payload)
+	return frame
+}
+
+// ipChecksum computes the IPv4 header checksum.
+func ipChecksum(hdr []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(hdr); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,145 @@
+package vl2
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// dhcpClientFrame builds a DHCP client message of msgType from mac.
+func dhcpClientFrame(t *testing.T, mac net.HardwareAddr, xid uint32, msgType byte, ciaddr, requested, serverID netip.Addr) *EthernetFrame {
+	t.Helper()
+	msg := make([]byte, dhcpHeaderSize+4)
+	msg[0], msg[1], msg[2] = 1, 1, 6
+	binary.BigEndian.PutUint32(msg[4:8], xid)
+	if ciaddr.IsValid() {
+		ci := ciaddr.As4()
+		copy(msg[12:16], ci[:])
+	}
+	copy(msg[28:34], mac)
+	binary.BigEndian.PutUint32(msg[dhcpHeaderSize:], dhcpMagicCookie)
+	msg = append(msg, dhcpOptMessageType, 1, msgType)
+	for code, addr := range map[byte]netip.Addr{dhcpOptRequestedIP: requested, dhcpOptServerID: serverID} {
+		if addr.IsValid() {
+			a := addr.As4()
+			msg = append(msg, code, 4)
+			msg = append(msg, a[:]...)
+		}
+	}
+	msg = append(msg, dhcpOptEnd)
+
+	src := ciaddr
+	if !src.IsValid() {
+		src = netip.IPv4Unspecified()
+	}
+	bcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	frame, err := ParseEthernetFrame(buildUDPFrame(bcast, mac, src, netip.AddrFrom4([4]byte{255, 255, 255, 255}), DHCPClientPort, DHCPServerPort, msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+// dhcpReply is the part of a server reply the tests check.
+type dhcpReply struct {
+	msgType byte
+	xid     uint32
+	yiaddr  netip.Addr
+	dstIP   netip.Addr
+	opts    map[byte][]byte
+}
+
+func parseDHCPReply(t *testing.T, frame []byte) dhcpReply {
+	t.Helper()
+	if frame == nil {
+		t.Fatal("no DHCP reply")
+	}
+	ip := frame[EthernetHeaderSize:]
+	msg := ip[28:]
+	opts := parseDHCPOptions(msg[dhcpHeaderSize+4:])
+	r := dhcpReply{
+		xid:    binary.BigEndian.Uint32(msg[4:8]),
+		yiaddr: addrFrom4(msg[16:20]),
+		dstIP:  netip.AddrFrom4([4]byte(ip[16:20])),
+		opts:   opts,
+	}
+	if len(opts[dhcpOptMessageType]) == 1 {
+		r.msgType = opts[dhcpOptMessageType][0]
+	}
+	return r
+}
+
+func newTestDHCPServer(t *testing.T) *DHCPServer {
+	t.Helper()
+	s, err := NewDHCPServer(DHCPConfig{
+		ServerIP:  netip.MustParseAddr("10.1.0.1"),
+		Subnet:    netip.MustParsePrefix("10.1.0.0/24"),
+		PoolStart: netip.MustParseAddr("10.1.0.100"),
+		PoolEnd:   netip.MustParseAddr("10.1.0.102"),
+		Router:    netip.MustParseAddr("10.1.0.1"),
+	}, mustMAC(t, "02:00:00:00:00:01"), testLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDHCPDiscoverToAck(t *testing.T) {
+	s := newTestDHCPServer(t)
+	server := netip.MustParseAddr("10.1.0.1")
+	// The controller gave the first pool address to a member
+	s.SetInUse([]netip.Addr{netip.MustParseAddr("10.1.0.100")})
+	client := mustMAC(t, "02:00:00:00:00:aa")
+
+	offer := parseDHCPReply(t, s.HandleDHCP(dhcpClientFrame(t, client, 7, DHCPDiscover, netip.Addr{}, netip.Addr{}, netip.Addr{})))
+	want := netip.MustParseAddr("10.1.0.101")
+	if offer.msgType != DHCPOffer || offer.xid != 7 || offer.yiaddr != want {
+		t.Fatalf("offer = %+v, want %v", offer, want)
+	}
+	if got := net.IP(offer.opts[dhcpOptSubnetMask]).String(); got != "255.255.255.0" {
+		t.Errorf("subnet mask = %s", got)
+	}
+	if got := addrFrom4(offer.opts[dhcpOptRouter]); got != server {
+		t.Errorf("router = %v", got)
+	}
+	if len(s.Leases()) != 0 {
+		t.Fatal("an offer counts as a lease")
+	}
+
+	ack := parseDHCPReply(t, s.HandleDHCP(dhcpClientFrame(t, client, 8, DHCPRequest, netip.Addr{}, offer.yiaddr, server)))
+	if ack.msgType != DHCPAck || ack.yiaddr != want || ack.dstIP != netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		t.Fatalf("ack = %+v", ack)
+	}
+	if got := binary.BigEndian.Uint32(ack.opts[dhcpOptLeaseTime]); time.Duration(got)*time.Second != DHCPDefaultLeaseTime {
+		t.Errorf("lease time = %ds", got)
+	}
+	leases := s.Leases()
+	if len(leases) != 1 || leases[0].IP != want || leases[0].MAC.String() != client.String() {
+		t.Fatalf("leases = %+v", leases)
+	}
+
+	// Renewal from the leased address is answered unicast
+	renew := parseDHCPReply(t, s.HandleDHCP(dhcpClientFrame(t, client, 9, DHCPRequest, want, netip.Addr{}, netip.Addr{})))
+	if renew.msgType != DHCPAck || renew.yiaddr != want || renew.dstIP != want {
+		t.Fatalf("renewal = %+v", renew)
+	}
+
+	// Another client cannot take the leased address
+	other := mustMAC(t, "02:00:00:00:00:bb")
+	nak := parseDHCPReply(t, s.HandleDHCP(dhcpClientFrame(t, other, 10, DHCPRequest, netip.Addr{}, want, server)))
+	if nak.msgType != DHCPNak || nak.yiaddr.IsValid() {
+		t.Fatalf("request for a leased address = %+v, want a NAK", nak)
+	}
+	offer = parseDHCPReply(t, s.HandleDHCP(dhcpClientFrame(t, other, 11, DHCPDiscover, netip.Addr{}, want, netip.Addr{})))
+	if offer.yiaddr != netip.MustParseAddr("10.1.0.102") {
+		t.Fatalf("second client offered %v", offer.yiaddr)
+	}
+
+	// With the pool used up, a third client gets no offer
+	s.HandleDHCP(dhcpClientFrame(t, other, 12, DHCPRequest, netip.Addr{}, offer.yiaddr, server))
+	if reply := s.HandleDHCP(dhcpClientFrame(t, mustMAC(t, "02:00:00:00:00:cc"), 13, DHCPDiscover, netip.Addr{}, netip.Addr{}, netip.Addr{})); reply != nil {
+		t.Fatalf("offer from an exhausted pool: %+v", parseDHCPReply(t, reply))
+	}
+}
@@ -0,0 +1,160 @@
+package vl2
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// PeerAddresses is what a member is allowed to send from: the MAC its
+// agent derives for the network and the overlay IPs assigned to it.
+type PeerAddresses struct {
+	MAC net.HardwareAddr
+	IPs []netip.Addr
+}
+
+// SpoofGuard drops frames a peer injects with a source address that is not
+// its own. While enabled, a peer's frames must carry its assigned MAC, and
+// may not claim an IP assigned to a different member; addresses nobody owns
+// (DHCP clients, hosts bridged behind a gateway) still pass.
+type SpoofGuard struct {
+	enabled atomic.Bool
+	peers   map[identity.Address]PeerAddresses
+	owners  map[netip.Addr]identity.Address
+	mu      sync.RWMutex
+	log     *slog.Logger
+
+	drops atomic.Uint64
+}
+
+// NewSpoofGuard creates a disabled spoof guard.
+func NewSpoofGuard(log *slog.Logger) *SpoofGuard {
+	return &SpoofGuard{
+		peers:  make(map[identity.Address]PeerAddresses),
+		owners: make(map[netip.Addr]identity.Address),
+		log:    log.With("component", "spoof-guard"),
+	}
+}
+
+// SetEnabled turns validation on or off.
+func (g *SpoofGuard) SetEnabled(on bool) {
+	if g.enabled.Swap(on) != on {
+		g.log.Info("source validation changed", "enabled", on)
+	}
+}
+
+// Enabled reports whether frames are being validated.
+func (g *SpoofGuard) Enabled() bool {
+	return g.enabled.Load()
+}
+
+// Drops returns how many frames were dropped as spoofed.
+func (g *SpoofGuard) Drops() uint64 {
+	return g.drops.Load()
+}
+
+// SetPeers replaces all peer assignments.
+func (g *SpoofGuard) SetPeers(peers map[identity.Address]PeerAddresses) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.peers = make(map[identity.Address]PeerAddresses, len(peers))
+	g.owners = make(map[netip.Addr]identity.Address)
+	for addr, pa := range peers {
+		g.setPeer(addr, pa)
+	}
+}
+
+// SetPeer adds or replaces one peer's assignment.
+func (g *SpoofGuard) SetPeer(addr identity.Address, pa PeerAddresses) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removePeer(addr)
+	g.setPeer(addr, pa)
+}
+
+// RemovePeer forgets a peer; its frames are dropped while enabled.
+func (g *SpoofGuard) RemovePeer(addr identity.Address) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removePeer(addr)
+}
+
+func (g *SpoofGuard) setPeer(addr identity.Address, pa PeerAddresses) {
+	g.peers[addr] = pa
+	for _, ip := range pa.IPs {
+		g.owners[ip] = addr
+	}
+}
+
+func (g *SpoofGuard) removePeer(addr identity.Address) {
+	pa, ok := g.peers[addr]
+	if !ok {
+		return
+	}
+	delete(g.peers, addr)
+	for _, ip := range pa.IPs {
+		if g.owners[ip] == addr {
+			delete(g.owners, ip)
+		}
+	}
+}
+
+// Allow reports whether a frame received from peer may be delivered.
+func (g *SpoofGuard) Allow(peer identity.Address, f *EthernetFrame) bool {
+	if !g.enabled.Load() {
+		return true
+	}
+	g.mu.RLock()
+	pa, known := g.peers[peer]
+	var owner identity.Address
+	var owned bool
+	src, hasSrc := frameSrcIP(f)
+	if hasSrc {
+		owner, owned = g.owners[src]
+	}
+	g.mu.RUnlock()
+
+	switch {
+	case !known:
+		g.drop("unknown peer", peer, f, src)
+		return false
+	case !bytes.Equal(f.SrcMAC, pa.MAC):
+		g.drop("spoofed source MAC", peer, f, src)
+		return false
+	case owned && owner != peer:
+		g.drop("spoofed source IP", peer, f, src)
+		return false
+	}
+	return true
+}
+
+func (g *SpoofGuard) drop(reason string, peer identity.Address, f *EthernetFrame, src netip.Addr) {
+	g.drops.Add(1)
+	g.log.Debug("dropping frame", "reason", reason, "peer", peer, "src_mac", f.SrcMAC, "src_ip", src)
+}
+
+// frameSrcIP returns the source address of an IPv4 or IPv6 packet, or the
+// sender protocol address of an IPv4 ARP message.
+func frameSrcIP(f *EthernetFrame) (netip.Addr, bool) {
+	p := f.Payload
+	switch f.EtherType {
+	case EtherTypeIPv4:
+		if len(p) >= 20 {
+			return netip.AddrFrom4([4]byte(p[12:16])), true
+		}
+	case EtherTypeIPv6:
+		if len(p) >= IPv6HeaderSize {
+			return netip.AddrFrom16([16]byte(p[8:24])), true
+		}
+	case EtherTypeARP:
+		if len(p) >= 28 && p[2] == 0x08 && p[3] == 0x00 {
+			return netip.AddrFrom4([4]byte(p[14:18])), true
+		}
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,72 @@
+package vl2
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+func TestSpoofGuardDropsForeignSource(t *testing.T) {
+	sender := newRecordingSender()
+	n := NewNetwork(NetworkConfig{ID: 1, Name: "test"}, identity.Address{1}, sender, testLog())
+	p1, p2 := identity.Address{2}, identity.Address{3}
+	local := GenerateMAC(1, identity.Address{1})
+	mac1, mac2 := GenerateMAC(1, p1), GenerateMAC(1, p2)
+	n.Guard.SetPeers(map[identity.Address]PeerAddresses{
+		p1: {MAC: mac1, IPs: []netip.Addr{netip.MustParseAddr("10.0.0.2")}},
+		p2: {MAC: mac2, IPs: []netip.Addr{netip.MustParseAddr("10.0.0.3")}},
+	})
+	// from builds an IPv4 frame to the local member from mac and srcIP
+	from := func(mac net.HardwareAddr, srcIP string) []byte {
+		frame := ethFrame(local, mac)
+		copy(frame[EthernetHeaderSize+12:], net.ParseIP(srcIP).To4())
+		return frame
+	}
+
+	// Disabled, anything goes
+	if out, err := n.Switch.HandleRemoteFrame(p1, from(mac2, "10.0.0.3")); err != nil || out == nil {
+		t.Fatalf("spoofed frame with validation off: %v, %v", out, err)
+	}
+	n.Switch.RemovePeer(p1)
+
+	n.Guard.SetEnabled(true)
+	tests := []struct {
+		name  string
+		peer  identity.Address
+		frame []byte
+		allow bool
+	}{
+		{"own addresses", p1, from(mac1, "10.0.0.2"), true},
+		{"unassigned source IP", p1, from(mac1, "192.168.1.50"), true},
+		{"another peer's MAC", p1, from(mac2, "10.0.0.2"), false},
+		{"another peer's IP", p1, from(mac1, "10.0.0.3"), false},
+		{"unknown peer", identity.Address{9}, from(GenerateMAC(1, identity.Address{9}), "10.0.0.9"), false},
+	}
+	drops := 0
+	for _, tt := range tests {
+		out, err := n.Switch.HandleRemoteFrame(tt.peer, tt.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (out != nil) != tt.allow {
+			t.Errorf("%s: delivered = %v, want %v", tt.name, out != nil, tt.allow)
+		}
+		if !tt.allow {
+			drops++
+		}
+	}
+	if got := n.Guard.Drops(); got != uint64(drops) {
+		t.Errorf("Drops = %d, want %d", got, drops)
+	}
+
+	// The spoofed MAC was not learned behind p1: frames to it still flood
+	// rather than going to the impostor
+	if err := n.Switch.HandleLocalFrame(ethFrame(mac2, local)); err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := sender.counts(p1); sent != 0 {
+		t.Fatalf("%d frames for %v sent to the impostor", sent, mac2)
+	}
+}
@@ -1,6 +1,7 @@
 package vl2
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"net"
 
@@ -12,25 +13,29 @@ import (
 //
 // Format: 02:XX:XX:XX:XX:XX
 //   - Byte 0: 0x02 (locally administered, unicast)
-//   - Bytes 1-2: derived from Network ID
-//   - Bytes 3-5: derived from Node Address (first 3 bytes of 5-byte address)
+//   - Bytes 1-5: the first 40 bits of SHA-256(network ID || node address)
+//
+// Hashing the whole address keeps nodes whose addresses share a prefix
+// apart. Distinct nodes still collide by chance: among n members of a
+// network the probability is about n²/2⁴¹, roughly 1 in 2 million for
+// 1000 members. The unicast bit keeps the MAC usable for IPv6 interface
+// identifiers.
+//
+// Releases before protocol 3 put the network ID and the first three address
+// bytes in the MAC instead, so upgrading changes every member's MAC. Peers
+// relearn it from traffic, but DHCP reservations, static neighbor entries
+// and firewall rules pinned to an old MAC must be updated, and agents
+// enforcing source validation drop frames from members still on the old
+// scheme until the whole network is upgraded.
 func GenerateMAC(networkID uint32, nodeAddr identity.Address) net.HardwareAddr {
-	mac := make(net.HardwareAddr, 6)
+	var input [4 + identity.AddressSize]byte
+	binary.BigEndian.PutUint32(input[:4], networkID)
+	copy(input[4:], nodeAddr[:])
+	sum := sha256.Sum256(input[:])
 
-	// Byte 0: locally administered unicast
+	mac := make(net.HardwareAddr, 6)
 	mac[0] = 0x02
-
-	// Bytes 1-2: from network ID
-	var netIDBytes [4]byte
-	binary.BigEndian.PutUint32(netIDBytes[:], networkID)
-	mac[1] = netIDBytes[2]
-	mac[2] = netIDBytes[3]
-
-	// Bytes 3-5: from node address
-	mac[3] = nodeAddr[0]
-	mac[4] = nodeAddr[1]
-	mac[5] = nodeAddr[2]
-
+	copy(mac[1:], sum[:5])
 	return mac
 }
 
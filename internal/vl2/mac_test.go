@@ -0,0 +1,30 @@
+package vl2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+func TestGenerateMACSharedPrefix(t *testing.T) {
+	// Addresses differing only after their third byte used to share a MAC
+	a := identity.Address{0x12, 0x34, 0x56, 0x00, 0x01}
+	b := identity.Address{0x12, 0x34, 0x56, 0x00, 0x02}
+	macA, macB := GenerateMAC(7, a), GenerateMAC(7, b)
+	if bytes.Equal(macA, macB) {
+		t.Fatalf("%s and %s share MAC %s", a, b, macA)
+	}
+
+	for _, mac := range [][]byte{macA, macB, GenerateMAC(8, a)} {
+		if mac[0]&0x02 == 0 || mac[0]&0x01 != 0 {
+			t.Errorf("MAC %x is not locally administered unicast", mac)
+		}
+	}
+	if bytes.Equal(GenerateMAC(8, a), macA) {
+		t.Error("MAC does not depend on the network")
+	}
+	if !bytes.Equal(GenerateMAC(7, a), macA) {
+		t.Error("MAC is not deterministic")
+	}
+}
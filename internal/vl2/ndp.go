@@ -0,0 +1,281 @@
+package vl2
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// NDP constants (RFC 4861)
+const (
+	IPv6HeaderSize          = 40
+	ICMPv6Protocol          = 58
+	NDPNeighborSolicitation = 135
+	NDPNeighborAdvert       = 136
+	ndpMessageSize          = 24 // type, code, checksum, flags/reserved, target
+	ndpOptSourceLLAddr      = 1
+	ndpOptTargetLLAddr      = 2
+	ndpFlagSolicited        = 0x40
+	ndpFlagOverride         = 0x20
+)
+
+// NDPProxy is the IPv6 counterpart of ARPProxy: it answers neighbor
+// solicitations from cache and learns from the neighbor discovery traffic
+// it sees, so address resolution does not have to flood the network.
+type NDPProxy struct {
+	cache   map[[16]byte]*ARPEntry // IPv6 → MAC
+	maxSize int
+	expiry  time.Duration
+	mu      sync.RWMutex
+	log     *slog.Logger
+}
+
+// NewNDPProxy creates a new NDP proxy whose cache is sized from limits,
+// like the ARP cache.
+func NewNDPProxy(limits TableLimits, log *slog.Logger) *NDPProxy {
+	limits = limits.WithDefaults()
+	return &NDPProxy{
+		cache:   make(map[[16]byte]*ARPEntry),
+		maxSize: limits.ARPCacheSize,
+		expiry:  limits.ARPCacheExpiry,
+		log:     log.With("component", "ndp-proxy"),
+	}
+}
+
+// SetLimits changes the cache size and expiry, evicting the oldest
+// unpinned entries if the cache is over the new size.
+func (n *NDPProxy) SetLimits(limits TableLimits) {
+	limits = limits.WithDefaults()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.maxSize = limits.ARPCacheSize
+	n.expiry = limits.ARPCacheExpiry
+	for len(n.cache) > n.maxSize && n.evictOldest() {
+	}
+}
+
+// fresh reports whether a cache entry is still valid.
+func (n *NDPProxy) fresh(e *ARPEntry) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return time.Since(e.LastSeen) < n.expiry
+}
+
+// IsNDP returns true if this is an ICMPv6 neighbor solicitation or
+// advertisement.
+func (f *EthernetFrame) IsNDP() bool {
+	if f.EtherType != EtherTypeIPv6 || len(f.Payload) < IPv6HeaderSize+ndpMessageSize {
+		return false
+	}
+	if f.Payload[6] != ICMPv6Protocol {
+		return false
+	}
+	t := f.Payload[IPv6HeaderSize]
+	return t == NDPNeighborSolicitation || t == NDPNeighborAdvert
+}
+
+// HandleNDP processes a neighbor discovery frame. If it's a solicitation
+// and we have the answer cached, returns an advertisement frame. Otherwise
+// returns nil (let it flood).
+func (n *NDPProxy) HandleNDP(frame *EthernetFrame) []byte {
+	if !frame.IsNDP() {
+		return nil
+	}
+	ip6 := frame.Payload
+	icmp := ip6[IPv6HeaderSize:]
+	var srcIP, target [16]byte
+	copy(srcIP[:], ip6[8:24])
+	copy(target[:], icmp[8:24])
+
+	switch icmp[0] {
+	case NDPNeighborSolicitation:
+		// The unspecified source of duplicate address detection has no
+		// address to learn or to answer to.
+		if srcIP == ([16]byte{}) {
+			return nil
+		}
+		senderMAC := ndpLinkLayerOption(icmp[ndpMessageSize:], ndpOptSourceLLAddr)
+		if senderMAC == nil {
+			senderMAC = frame.SrcMAC
+		}
+		n.learn(srcIP, senderMAC)
+
+		n.mu.RLock()
+		entry, found := n.cache[target]
+		n.mu.RUnlock()
+		if found && n.fresh(entry) {
+			n.log.Debug("NDP proxy hit", "ip", net.IP(target[:]), "mac", entry.MAC)
+			return buildNeighborAdvert(entry.MAC, senderMAC, target, srcIP)
+		}
+		return nil
+
+	case NDPNeighborAdvert:
+		mac := ndpLinkLayerOption(icmp[ndpMessageSize:], ndpOptTargetLLAddr)
+		if mac == nil {
+			mac = frame.SrcMAC
+		}
+		n.learn(target, mac)
+	}
+	return nil
+}
+
+// Lookup returns the cached MAC for an IPv6 address, or nil if not found.
+func (n *NDPProxy) Lookup(ip net.IP) net.HardwareAddr {
+	ip16 := ip.To16()
+	if ip16 == nil || ip.To4() != nil {
+		return nil
+	}
+	var key [16]byte
+	copy(key[:], ip16)
+	n.mu.RLock()
+	entry, found := n.cache[key]
+	n.mu.RUnlock()
+	if found && n.fresh(entry) {
+		return entry.MAC
+	}
+	return nil
+}
+
+// Learn adds or updates a neighbor cache entry (public API for seeding).
+// Seeded entries are pinned and never expire.
+func (n *NDPProxy) Learn(ip net.IP, mac net.HardwareAddr) {
+	if ip.To4() != nil || ip.To16() == nil {
+		return
+	}
+	var key [16]byte
+	copy(key[:], ip.To16())
+	n.learn(key, mac)
+	n.mu.Lock()
+	n.cache[key].Pinned = true
+	n.mu.Unlock()
+}
+
+// learn adds or updates a neighbor cache entry.
+func (n *NDPProxy) learn(ip [16]byte, mac net.HardwareAddr) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if e, ok := n.cache[ip]; ok && e.Pinned {
+		return
+	}
+	for len(n.cache) >= n.maxSize {
+		if !n.evictOldest() {
+			break
+		}
+	}
+	macCopy := make(net.HardwareAddr, 6)
+	copy(macCopy, mac)
+	n.cache[ip] = &ARPEntry{
+		MAC:      macCopy,
+		LastSeen: time.Now(),
+	}
+}
+
+func (n *NDPProxy) evictOldest() bool {
+	var oldestKey [16]byte
+	var oldestTime time.Time
+	first := true
+	for k, v := range n.cache {
+		if v.Pinned {
+			continue
+		}
+		if first || v.LastSeen.Before(oldestTime) {
+			oldestKey = k
+			oldestTime = v.LastSeen
+			first = false
+		}
+	}
+	if !first {
+		delete(n.cache, oldestKey)
+	}
+	return !first
+}
+
+// CleanExpired removes expired entries from the neighbor cache.
+// Pinned entries (seeded via Learn) are never expired.
+func (n *NDPProxy) CleanExpired() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	cutoff := time.Now().Add(-n.expiry)
+	removed := 0
+	for k, v := range n.cache {
+		if !v.Pinned && v.LastSeen.Before(cutoff) {
+			delete(n.cache, k)
+			removed++
+		}
+	}
+	return removed
+}
+
+// ndpLinkLayerOption returns the link-layer address in the first NDP option
+// of the given type, or nil.
+func ndpLinkLayerOption(opts []byte, optType byte) net.HardwareAddr {
+	for len(opts) >= 8 {
+		length := int(opts[1]) * 8
+		if length == 0 || length > len(opts) {
+			return nil
+		}
+		if opts[0] == optType {
+			return net.HardwareAddr(opts[2:8])
+		}
+		opts = opts[length:]
+	}
+	return nil
+}
+
+// buildNeighborAdvert constructs a solicited neighbor advertisement frame
+// telling dstMAC/dstIP that target is at targetMAC.
+func buildNeighborAdvert(targetMAC, dstMAC net.HardwareAddr, target, dstIP [16]byte) []byte {
+	const icmpLen = ndpMessageSize + 8 // + target link-layer address option
+	frame := make([]byte, EthernetHeaderSize+IPv6HeaderSize+icmpLen)
+
+	// Ethernet header
+	copy(frame[0:6], dstMAC)
+	copy(frame[6:12], targetMAC)
+	binary.BigEndian.PutUint16(frame[12:14], EtherTypeIPv6)
+
+	// IPv6 header
+	ip6 := frame[EthernetHeaderSize:]
+	ip6[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(ip6[4:6], icmpLen)
+	ip6[6] = ICMPv6Protocol
+	ip6[7] = 255 // hop limit; receivers drop NDP with any other
+	copy(ip6[8:24], target[:])
+	copy(ip6[24:40], dstIP[:])
+
+	// Neighbor advertisement
+	icmp := ip6[IPv6HeaderSize:]
+	icmp[0] = NDPNeighborAdvert
+	icmp[4] = ndpFlagSolicited | ndpFlagOverride
+	copy(icmp[8:24], target[:])
+	icmp[24] = ndpOptTargetLLAddr
+	icmp[25] = 1 // length in units of 8 bytes
+	copy(icmp[26:32], targetMAC)
+
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(target, dstIP, icmp))
+	return frame
+}
+
+// icmpv6Checksum computes the ICMPv6 checksum over the IPv6 pseudo-header
+// and the message (whose checksum field must be zero).
+func icmpv6Checksum(src, dst [16]byte, msg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src[:])
+	add(dst[:])
+	sum += uint32(len(msg))
+	sum += ICMPv6Protocol
+	add(msg)
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,91 @@
+package vl2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// ndpFrame builds a neighbor solicitation or advertisement from src (at
+// mac) about target, with a link-layer address option naming mac.
+func ndpFrame(t *testing.T, typ byte, mac net.HardwareAddr, src, target string) *EthernetFrame {
+	t.Helper()
+	const icmpLen = ndpMessageSize + 8
+	raw := make([]byte, EthernetHeaderSize+IPv6HeaderSize+icmpLen)
+	copy(raw[0:6], net.HardwareAddr{0x33, 0x33, 0xff, 0, 0, 1})
+	copy(raw[6:12], mac)
+	binary.BigEndian.PutUint16(raw[12:14], EtherTypeIPv6)
+	ip6 := raw[EthernetHeaderSize:]
+	ip6[0] = 0x60
+	binary.BigEndian.PutUint16(ip6[4:6], icmpLen)
+	ip6[6] = ICMPv6Protocol
+	ip6[7] = 255
+	copy(ip6[8:24], net.ParseIP(src))
+	copy(ip6[24:40], net.ParseIP("ff02::1:ff00:1"))
+	icmp := ip6[IPv6HeaderSize:]
+	icmp[0] = typ
+	copy(icmp[8:24], net.ParseIP(target))
+	icmp[24] = ndpOptSourceLLAddr
+	if typ == NDPNeighborAdvert {
+		icmp[24] = ndpOptTargetLLAddr
+	}
+	icmp[25] = 1
+	copy(icmp[26:32], mac)
+	f, err := ParseEthernetFrame(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestNDPProxy(t *testing.T) {
+	ndp := NewNDPProxy(TableLimits{}, testLog())
+	macA := mustMAC(t, "02:00:00:00:00:0a")
+	macB := mustMAC(t, "02:00:00:00:00:0b")
+
+	// Unknown targets flood; the solicitation and B's advertisement teach
+	// the proxy both addresses
+	solicit := ndpFrame(t, NDPNeighborSolicitation, macA, "fd00::a", "fd00::b")
+	if !solicit.IsNDP() || ndp.HandleNDP(solicit) != nil {
+		t.Fatal("solicitation for an unknown target answered")
+	}
+	if ndp.HandleNDP(ndpFrame(t, NDPNeighborAdvert, macB, "fd00::b", "fd00::b")) != nil {
+		t.Fatal("advertisement answered")
+	}
+	if got := ndp.Lookup(net.ParseIP("fd00::a")); !bytes.Equal(got, macA) {
+		t.Fatalf("Lookup(fd00::a) = %v, want %v", got, macA)
+	}
+
+	reply := ndp.HandleNDP(solicit)
+	if reply == nil {
+		t.Fatal("solicitation for a cached target not answered")
+	}
+	f, err := ParseEthernetFrame(reply)
+	if err != nil || !f.IsNDP() {
+		t.Fatalf("reply is not NDP: %v", err)
+	}
+	ip6, icmp := f.Payload, f.Payload[IPv6HeaderSize:]
+	if !bytes.Equal(f.DstMAC, macA) || !bytes.Equal(f.SrcMAC, macB) || icmp[0] != NDPNeighborAdvert || ip6[7] != 255 {
+		t.Fatalf("reply %s type %d hop limit %d", f, icmp[0], ip6[7])
+	}
+	if !net.IP(ip6[8:24]).Equal(net.ParseIP("fd00::b")) || !net.IP(ip6[24:40]).Equal(net.ParseIP("fd00::a")) || !net.IP(icmp[8:24]).Equal(net.ParseIP("fd00::b")) {
+		t.Fatalf("reply addresses: src %v dst %v target %v", net.IP(ip6[8:24]), net.IP(ip6[24:40]), net.IP(icmp[8:24]))
+	}
+	if got := ndpLinkLayerOption(icmp[ndpMessageSize:], ndpOptTargetLLAddr); !bytes.Equal(got, macB) {
+		t.Fatalf("target link-layer address = %v", got)
+	}
+	var src, dst [16]byte
+	copy(src[:], ip6[8:24])
+	copy(dst[:], ip6[24:40])
+	sum := binary.BigEndian.Uint16(icmp[2:4])
+	binary.BigEndian.PutUint16(icmp[2:4], 0)
+	if want := icmpv6Checksum(src, dst, icmp); sum != want {
+		t.Fatalf("checksum %#04x, want %#04x", sum, want)
+	}
+
+	// Duplicate address detection has no source to answer to
+	if ndp.HandleNDP(ndpFrame(t, NDPNeighborSolicitation, macA, "::", "fd00::b")) != nil {
+		t.Fatal("DAD probe answered")
+	}
+}
@@ -1,7 +1,9 @@
 package vl2
 
 import (
+	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/unicornultrafoundation/zerogo/internal/identity"
 )
@@ -14,13 +16,76 @@ type NetworkConfig struct {
 	IP6Range  string // optional IPv6 CIDR
 	MTU       int
 	Multicast bool
+	Limits    TableLimits
+}
+
+// Bounds on configured table sizes and expiries.
+const (
+	MinTableSize   = 64
+	MaxTableSize   = 1 << 20
+	MinTableExpiry = 30 * time.Second
+	MaxTableExpiry = 24 * time.Hour
+)
+
+// TableLimits sizes a network's MAC table and ARP/NDP caches; large
+// networks need more entries than the defaults. Zero fields mean the
+// default.
+type TableLimits struct {
+	MACTableSize   int
+	MACTableExpiry time.Duration
+	ARPCacheSize   int
+	ARPCacheExpiry time.Duration
+}
+
+// WithDefaults returns l with zero fields set to the default constants.
+func (l TableLimits) WithDefaults() TableLimits {
+	if l.MACTableSize == 0 {
+		l.MACTableSize = MACTableMaxSize
+	}
+	if l.MACTableExpiry == 0 {
+		l.MACTableExpiry = MACTableExpiry
+	}
+	if l.ARPCacheSize == 0 {
+		l.ARPCacheSize = ARPCacheMaxSize
+	}
+	if l.ARPCacheExpiry == 0 {
+		l.ARPCacheExpiry = ARPCacheExpiry
+	}
+	return l
+}
+
+// Validate checks that the sizes and expiries are within sane bounds.
+func (l TableLimits) Validate() error {
+	l = l.WithDefaults()
+	for _, size := range []struct {
+		name string
+		n    int
+	}{{"MAC table size", l.MACTableSize}, {"ARP cache size", l.ARPCacheSize}} {
+		if size.n < MinTableSize || size.n > MaxTableSize {
+			return fmt.Errorf("%s %d must be between %d and %d", size.name, size.n, MinTableSize, MaxTableSize)
+		}
+	}
+	for _, expiry := range []struct {
+		name string
+		d    time.Duration
+	}{{"MAC table expiry", l.MACTableExpiry}, {"ARP cache expiry", l.ARPCacheExpiry}} {
+		if expiry.d < MinTableExpiry || expiry.d > MaxTableExpiry {
+			return fmt.Errorf("%s %s must be between %s and %s", expiry.name, expiry.d, MinTableExpiry, MaxTableExpiry)
+		}
+	}
+	return nil
 }
 
 // Network represents a virtual L2 network instance on a node.
 type Network struct {
 	Config   NetworkConfig
 	Switch   *Switch
+	Router   *Router // used instead of Switch in TUN (L3) mode
 	ARP      *ARPProxy
+	NDP      *NDPProxy
+	ACL      *ACL
+	Guard    *SpoofGuard
+	DHCP     *DHCPServer // nil unless the member serves DHCP
 	LocalMAC [6]byte
 	log      *slog.Logger
 }
@@ -31,11 +96,51 @@ func NewNetwork(config NetworkConfig, nodeAddr identity.Address, sender PeerSend
 	mac := GenerateMAC(config.ID, nodeAddr)
 	var macArr [6]byte
 	copy(macArr[:], mac)
+	sw := NewSwitch(config.ID, sender, config.Limits, netLog)
+	acl := NewACL(netLog)
+	sw.SetACL(acl)
+	router := NewRouter(config.ID, mac, sender, netLog)
+	router.SetACL(acl)
+	guard := NewSpoofGuard(netLog)
+	sw.SetGuard(guard)
+	router.SetGuard(guard)
 	return &Network{
 		Config:   config,
-		Switch:   NewSwitch(config.ID, sender, netLog),
-		ARP:      NewARPProxy(netLog),
+		Switch:   sw,
+		Router:   router,
+		ARP:      NewARPProxy(config.Limits, netLog),
+		NDP:      NewNDPProxy(config.Limits, netLog),
+		ACL:      acl,
+		Guard:    guard,
 		LocalMAC: macArr,
 		log:      netLog,
 	}
 }
+
+// EnableDHCP starts serving DHCP on the network, answering from the local MAC.
+func (n *Network) EnableDHCP(cfg DHCPConfig) error {
+	srv, err := NewDHCPServer(cfg, n.LocalMAC[:], n.log)
+	if err != nil {
+		return err
+	}
+	n.DHCP = srv
+	return nil
+}
+
+// RemovePeer drops everything the network knows about a peer that left it:
+// the MACs learned behind it, its routes and its source-validation entry.
+func (n *Network) RemovePeer(peer identity.Address) {
+	if removed := n.Switch.RemovePeer(peer); removed > 0 {
+		n.log.Debug("purged MAC entries of removed peer", "peer", peer, "entries", removed)
+	}
+	n.Router.RemovePeer(peer)
+	n.Guard.RemovePeer(peer)
+}
+
+// SetLimits resizes the network's MAC table and ARP/NDP caches.
+func (n *Network) SetLimits(limits TableLimits) {
+	n.Config.Limits = limits
+	n.Switch.SetLimits(limits)
+	n.ARP.SetLimits(limits)
+	n.NDP.SetLimits(limits)
+}
@@ -0,0 +1,105 @@
+package vl2
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// fillTables has a peer teach n's switch and ARP cache count addresses.
+func fillTables(t *testing.T, n *Network, count int) {
+	t.Helper()
+	local := mustMAC(t, "02:00:00:00:00:01")
+	for i := range count {
+		mac := net.HardwareAddr{0x02, 0x10, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(mac[2:], uint32(i))
+		if _, err := n.Switch.HandleRemoteFrame(identity.Address{2}, ethFrame(local, mac)); err != nil {
+			t.Fatal(err)
+		}
+		var ip [4]byte
+		binary.BigEndian.PutUint32(ip[:], 0x0a000000+uint32(i))
+		n.ARP.learn(ip, mac)
+	}
+}
+
+func arpCacheSize(a *ARPProxy) int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.cache)
+}
+
+func TestTableLimits(t *testing.T) {
+	small := TableLimits{MACTableSize: MinTableSize, ARPCacheSize: MinTableSize}
+	large := TableLimits{MACTableSize: 2 * MACTableMaxSize, ARPCacheSize: 2 * MACTableMaxSize}
+	for _, l := range []TableLimits{small, large} {
+		if err := l.Validate(); err != nil {
+			t.Fatalf("%+v: %v", l, err)
+		}
+	}
+
+	// A small network evicts past its limit; a large one holds more than
+	// the defaults
+	const count = MACTableMaxSize + 100
+	for _, tt := range []struct {
+		name     string
+		limits   TableLimits
+		mac, arp int
+	}{
+		{"small", small, MinTableSize, MinTableSize},
+		{"default", TableLimits{}, MACTableMaxSize, ARPCacheMaxSize},
+		{"large", large, count, count},
+	} {
+		n := NewNetwork(NetworkConfig{ID: 1, Name: tt.name, Limits: tt.limits}, identity.Address{1}, newRecordingSender(), testLog())
+		fillTables(t, n, count)
+		if got := n.Switch.MACTableSize(); got != tt.mac {
+			t.Errorf("%s: MAC table holds %d, want %d", tt.name, got, tt.mac)
+		}
+		if got := arpCacheSize(n.ARP); got != tt.arp {
+			t.Errorf("%s: ARP cache holds %d, want %d", tt.name, got, tt.arp)
+		}
+		if tt.name == "large" {
+			// Shrinking evicts down to the new size
+			n.SetLimits(small)
+			if n.Switch.MACTableSize() != MinTableSize || arpCacheSize(n.ARP) != MinTableSize {
+				t.Errorf("after shrinking: %d MACs, %d ARP entries", n.Switch.MACTableSize(), arpCacheSize(n.ARP))
+			}
+		}
+	}
+
+	// A short expiry ages entries out sooner
+	for _, tt := range []struct {
+		expiry  time.Duration
+		removed int
+	}{{0, 0}, {MinTableExpiry, 10}} {
+		n := NewNetwork(NetworkConfig{ID: 1, Limits: TableLimits{MACTableExpiry: tt.expiry, ARPCacheExpiry: tt.expiry}}, identity.Address{1}, newRecordingSender(), testLog())
+		fillTables(t, n, 10)
+		old := time.Now().Add(-MinTableExpiry - time.Second)
+		n.Switch.mu.Lock()
+		for _, e := range n.Switch.macTable {
+			e.LastSeen = old
+		}
+		n.Switch.mu.Unlock()
+		n.ARP.mu.Lock()
+		for _, e := range n.ARP.cache {
+			e.LastSeen = old
+		}
+		n.ARP.mu.Unlock()
+		if mac, arp := n.Switch.CleanExpired(), n.ARP.CleanExpired(); mac != tt.removed || arp != tt.removed {
+			t.Errorf("expiry %v: removed %d MACs and %d ARP entries, want %d", tt.expiry, mac, arp, tt.removed)
+		}
+	}
+
+	for _, l := range []TableLimits{
+		{MACTableSize: MinTableSize - 1},
+		{ARPCacheSize: MaxTableSize + 1},
+		{MACTableExpiry: time.Second},
+		{ARPCacheExpiry: 2 * MaxTableExpiry},
+	} {
+		if err := l.Validate(); err == nil {
+			t.Errorf("Validate(%+v) accepted", l)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package vl2
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// Router forwards IP packets to peers by destination address, for TUN (L3)
+// mode where there is no Ethernet segment to learn MACs or resolve ARP on.
+// Routes come from the controller's member IP assignments. Packets still
+// travel as Ethernet frames so TAP and TUN members interoperate; the router
+// addresses them to the destination peer's deterministic MAC.
+type Router struct {
+	networkID uint32
+	localMAC  net.HardwareAddr
+	routes    map[netip.Addr]identity.Address
+	mu        sync.RWMutex
+	sender    PeerSender
+	acl       *ACL
+	guard     *SpoofGuard
+	log       *slog.Logger
+}
+
+// NewRouter creates an L3 router for the given network.
+func NewRouter(networkID uint32, localMAC net.HardwareAddr, sender PeerSender, log *slog.Logger) *Router {
+	return &Router{
+		networkID: networkID,
+		localMAC:  localMAC,
+		routes:    make(map[netip.Addr]identity.Address),
+		sender:    sender,
+		log:       log.With("component", "router", "network", networkID),
+	}
+}
+
+// SetACL installs the ACL packets are filtered through in both directions.
+func (r *Router) SetACL(acl *ACL) {
+	r.acl = acl
+}
+
+// SetGuard installs the spoof guard remote packets are validated against.
+func (r *Router) SetGuard(g *SpoofGuard) {
+	r.guard = g
+}
+
+// SetRoutes replaces the IP → peer table.
+func (r *Router) SetRoutes(routes map[netip.Addr]identity.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = make(map[netip.Addr]identity.Address, len(routes))
+	for ip, peer := range routes {
+		r.routes[ip.Unmap()] = peer
+	}
+}
+
+// AddRoute routes ip to peer.
+func (r *Router) AddRoute(ip netip.Addr, peer identity.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes[ip.Unmap()] = peer
+}
+
+// RemovePeer drops all routes to peer.
+func (r *Router) RemovePeer(peer identity.Address) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ip, p := range r.routes {
+		if p == peer {
+			delete(r.routes, ip)
+		}
+	}
+}
+
+// Lookup returns the peer owning ip.
+func (r *Router) Lookup(ip netip.Addr) (identity.Address, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	peer, ok := r.routes[ip.Unmap()]
+	return peer, ok
+}
+
+// HandleLocalPacket routes a packet read from the local TUN device (wrapped
+// in an Ethernet frame) to the peer owning its destination IP. Broadcast and
+// multicast destinations go to all peers; packets for unknown destinations
+// are dropped rather than flooded.
+func (r *Router) HandleLocalPacket(frame []byte) error {
+	parsed, err := ParseEthernetFrame(frame)
+	if err != nil {
+		return err
+	}
+	if r.acl != nil && !r.acl.Allow(parsed) {
+		return nil
+	}
+	dst, ok := packetDst(parsed)
+	if !ok {
+		return nil
+	}
+
+	copy(frame[6:12], r.localMAC)
+	if dst.IsMulticast() || dst == netip.AddrFrom4([4]byte{255, 255, 255, 255}) {
+		copy(frame[0:6], net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+		return r.sender.BroadcastToPeers(r.networkID, frame, identity.Address{})
+	}
+
+	peer, found := r.Lookup(dst)
+	if !found {
+		r.log.Debug("no route for packet, dropping", "dst", dst)
+		return nil
+	}
+	copy(frame[0:6], GenerateMAC(r.networkID, peer))
+	return r.sender.SendToPeer(peer, r.networkID, frame)
+}
+
+// HandleRemotePacket processes a frame received from a remote peer. It
+// returns the frame to write to the local TUN device, or nil if the packet
+// was filtered or forwarded to the peer owning its destination.
+func (r *Router) HandleRemotePacket(peerAddr identity.Address, frame []byte) ([]byte, error) {
+	parsed, err := ParseEthernetFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	if r.guard != nil && !r.guard.Allow(peerAddr, parsed) {
+		return nil, nil
+	}
+	if r.acl != nil && !r.acl.Allow(parsed) {
+		return nil, nil
+	}
+	dst, ok := packetDst(parsed)
+	if !ok {
+		return nil, nil // ARP and other non-IP traffic has no meaning here
+	}
+	if owner, found := r.Lookup(dst); found && owner != peerAddr {
+		copy(frame[0:6], GenerateMAC(r.networkID, owner))
+		_ = r.sender.SendToPeer(owner, r.networkID, frame)
+		return nil, nil
+	}
+	return frame, nil
+}
+
+// packetDst returns the destination address of an IPv4 or IPv6 frame.
+func packetDst(f *EthernetFrame) (netip.Addr, bool) {
+	switch f.EtherType {
+	case EtherTypeIPv4:
+		if len(f.Payload) < 20 {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom4([4]byte(f.Payload[16:20])), true
+	case EtherTypeIPv6:
+		if len(f.Payload) < 40 {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom16([16]byte(f.Payload[24:40])), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,75 @@
+package vl2
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+// ipFrame wraps an IPv4 packet from src to dst the way the TUN device does,
+// with zero MAC addresses.
+func ipFrame(src, dst string) []byte {
+	frame := ethFrame(make(net.HardwareAddr, 6), make(net.HardwareAddr, 6))
+	ip := frame[EthernetHeaderSize:]
+	ip[0] = 0x45
+	copy(ip[12:16], net.ParseIP(src).To4())
+	copy(ip[16:20], net.ParseIP(dst).To4())
+	return frame
+}
+
+func TestTUNRoutesByDestinationIP(t *testing.T) {
+	sender := newRecordingSender()
+	n := NewNetwork(NetworkConfig{ID: 1, Name: "test"}, identity.Address{1}, sender, testLog())
+	p1, p2 := identity.Address{2}, identity.Address{3}
+	n.Router.SetRoutes(map[netip.Addr]identity.Address{
+		netip.MustParseAddr("10.1.0.2"): p1,
+		netip.MustParseAddr("10.1.0.3"): p2,
+	})
+
+	if err := n.Router.HandleLocalPacket(ipFrame("10.1.0.1", "10.1.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := sender.counts(p2); sent != 1 {
+		t.Fatalf("packets to the destination's peer = %d, want 1", sent)
+	}
+	if sent, _ := sender.counts(p1); sent != 0 {
+		t.Fatal("packet sent to another peer")
+	}
+	// Addressed to the peer's deterministic MAC, with nothing learned
+	if dst := net.HardwareAddr(sender.last[0:6]); !bytes.Equal(dst, GenerateMAC(1, p2)) {
+		t.Errorf("destination MAC = %v, want %v", dst, GenerateMAC(1, p2))
+	}
+	if src := net.HardwareAddr(sender.last[6:12]); !bytes.Equal(src, n.LocalMAC[:]) {
+		t.Errorf("source MAC = %v, want the local MAC", src)
+	}
+	if size := n.Switch.MACTableSize(); size != 0 {
+		t.Errorf("MAC table has %d entries in TUN mode", size)
+	}
+
+	// Unknown destinations are dropped rather than flooded
+	if err := n.Router.HandleLocalPacket(ipFrame("10.1.0.1", "10.1.0.9")); err != nil {
+		t.Fatal(err)
+	}
+	if _, floods := sender.counts(p1); floods != 0 {
+		t.Fatalf("%d floods for an unknown destination", floods)
+	}
+
+	// A peer's packet for us is delivered; one for another member goes on
+	if frame, err := n.Router.HandleRemotePacket(p1, ipFrame("10.1.0.2", "10.1.0.1")); err != nil || frame == nil {
+		t.Fatalf("packet for the local member: %v, %v", frame, err)
+	}
+	if frame, err := n.Router.HandleRemotePacket(p1, ipFrame("10.1.0.2", "10.1.0.3")); err != nil || frame != nil {
+		t.Fatalf("packet for another member delivered locally: %v", err)
+	}
+	if sent, _ := sender.counts(p2); sent != 2 {
+		t.Fatalf("packets forwarded to p2 = %d, want 2", sent)
+	}
+
+	n.Router.RemovePeer(p2)
+	if _, ok := n.Router.Lookup(netip.MustParseAddr("10.1.0.3")); ok {
+		t.Fatal("route to a removed peer kept")
+	}
+}
@@ -4,16 +4,27 @@ import (
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/unicornultrafoundation/zerogo/internal/identity"
 )
 
 const (
-	// MACTableExpiry is how long a MAC table entry lives without refresh.
+	// MACTableExpiry is how long a MAC table entry lives without refresh,
+	// unless TableLimits says otherwise.
 	MACTableExpiry = 5 * time.Minute
-	// MACTableMaxSize limits MAC table size to prevent memory exhaustion.
+	// MACTableMaxSize is the default MAC table size limit, which prevents
+	// memory exhaustion.
 	MACTableMaxSize = 4096
+
+	// MACFlapWindow is the window peer changes of one MAC are counted in.
+	MACFlapWindow = 10 * time.Second
+	// MACFlapThreshold is how many peer changes within MACFlapWindow are
+	// tolerated before the entry is pinned.
+	MACFlapThreshold = 5
+	// MACFlapHoldDown is how long a flapping entry ignores further moves.
+	MACFlapHoldDown = 30 * time.Second
 )
 
 // MACEntry tracks where a MAC address was last seen.
@@ -24,6 +35,17 @@ type MACEntry struct {
 	LastSeen time.Time
 	// IsLocal indicates the MAC belongs to the local TAP.
 	IsLocal bool
+
+	// Moves counts peer changes since MovesSince.
+	Moves      int
+	MovesSince time.Time
+	// PinnedUntil is when a dampened entry accepts moves again.
+	PinnedUntil time.Time
+}
+
+// sameLocation reports whether the entry already points at peerAddr.
+func (e *MACEntry) sameLocation(peerAddr identity.Address, isLocal bool) bool {
+	return e.IsLocal == isLocal && e.PeerAddr == peerAddr
 }
 
 // PeerSender is the interface for sending frames to a remote peer.
@@ -38,28 +60,100 @@ type PeerSender interface {
 type Switch struct {
 	networkID uint32
 	macTable  map[MACKey]*MACEntry
+	maxSize   int
+	expiry    time.Duration
 	mu        sync.RWMutex
 	sender    PeerSender
+	acl       *ACL
+	guard     *SpoofGuard
 	log       *slog.Logger
+
+	flaps           atomic.Uint64
+	unicastHits     atomic.Uint64
+	unknownFloods   atomic.Uint64
+	broadcastFloods atomic.Uint64
+	drops           atomic.Uint64
+
+	mirror atomic.Pointer[Capture]
 }
 
-// NewSwitch creates a new virtual switch for the given network.
-func NewSwitch(networkID uint32, sender PeerSender, log *slog.Logger) *Switch {
+// SwitchStats counts how a switch forwarded frames since it was created.
+type SwitchStats struct {
+	UnicastHits     uint64 // unicast frames whose destination was in the MAC table
+	UnknownFloods   uint64 // unicast frames flooded for an unknown destination
+	BroadcastFloods uint64 // broadcast and multicast frames flooded
+	Drops           uint64 // frames filtered by the ACL or spoof guard, or with nowhere to go
+	MACFlaps        uint64
+	MACTableSize    int
+}
+
+// NewSwitch creates a new virtual switch for the given network, sizing its
+// MAC table from limits.
+func NewSwitch(networkID uint32, sender PeerSender, limits TableLimits, log *slog.Logger) *Switch {
+	limits = limits.WithDefaults()
 	return &Switch{
 		networkID: networkID,
 		macTable:  make(map[MACKey]*MACEntry),
+		maxSize:   limits.MACTableSize,
+		expiry:    limits.MACTableExpiry,
 		sender:    sender,
 		log:       log.With("component", "switch", "network", networkID),
 	}
 }
 
+// SetLimits changes the MAC table size and expiry, evicting the oldest
+// entries if the table is over the new size.
+func (sw *Switch) SetLimits(limits TableLimits) {
+	limits = limits.WithDefaults()
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.maxSize = limits.MACTableSize
+	sw.expiry = limits.MACTableExpiry
+	for len(sw.macTable) > sw.maxSize && sw.evictOldest() {
+	}
+}
+
+// SetACL installs the ACL frames are filtered through in both directions.
+func (sw *Switch) SetACL(acl *ACL) {
+	sw.acl = acl
+}
+
+// SetGuard installs the spoof guard remote frames are validated against.
+func (sw *Switch) SetGuard(g *SpoofGuard) {
+	sw.guard = g
+}
+
+// AttachMirror starts copying every frame entering the switch, from the TAP
+// or from peers, to c. One capture runs at a time: it returns false if
+// another is attached.
+func (sw *Switch) AttachMirror(c *Capture) bool {
+	return sw.mirror.CompareAndSwap(nil, c)
+}
+
+// Mirroring reports whether a capture is attached.
+func (sw *Switch) Mirroring() bool {
+	return sw.mirror.Load() != nil
+}
+
+// DetachMirror stops copying frames to c.
+func (sw *Switch) DetachMirror(c *Capture) {
+	sw.mirror.CompareAndSwap(c, nil)
+}
+
 // HandleLocalFrame processes a frame coming from the local TAP device.
 // It learns the source MAC and forwards based on destination.
 func (sw *Switch) HandleLocalFrame(frame []byte) error {
+	if m := sw.mirror.Load(); m != nil {
+		m.Mirror(frame)
+	}
 	parsed, err := ParseEthernetFrame(frame)
 	if err != nil {
 		return err
 	}
+	if sw.acl != nil && !sw.acl.Allow(parsed) {
+		sw.drops.Add(1)
+		return nil
+	}
 
 	// Learn source MAC as local
 	sw.learn(parsed.SrcMAC, identity.Address{}, true)
@@ -67,6 +161,7 @@ func (sw *Switch) HandleLocalFrame(frame []byte) error {
 	// Forward based on destination
 	if parsed.IsBroadcast() || parsed.IsMulticast() {
 		// Flood to all peers
+		sw.broadcastFloods.Add(1)
 		return sw.sender.BroadcastToPeers(sw.networkID, frame, identity.Address{})
 	}
 
@@ -77,26 +172,36 @@ func (sw *Switch) HandleLocalFrame(frame []byte) error {
 
 	if found && !entry.IsLocal {
 		// Known remote peer: send directly
+		sw.unicastHits.Add(1)
 		return sw.sender.SendToPeer(entry.PeerAddr, sw.networkID, frame)
 	}
 
 	if !found {
 		// Unknown destination: flood (will learn on reply)
 		sw.log.Debug("unknown dst MAC, flooding", "dst", parsed.DstMAC)
+		sw.unknownFloods.Add(1)
 		return sw.sender.BroadcastToPeers(sw.networkID, frame, identity.Address{})
 	}
 
 	// Destination is local — drop (shouldn't happen for TAP-originated frames)
+	sw.drops.Add(1)
 	return nil
 }
 
 // HandleRemoteFrame processes a frame received from a remote peer via VL1.
 // Returns the raw frame to inject into the local TAP device.
 func (sw *Switch) HandleRemoteFrame(peerAddr identity.Address, frame []byte) ([]byte, error) {
+	if m := sw.mirror.Load(); m != nil {
+		m.Mirror(frame)
+	}
 	parsed, err := ParseEthernetFrame(frame)
 	if err != nil {
 		return nil, err
 	}
+	if (sw.guard != nil && !sw.guard.Allow(peerAddr, parsed)) || (sw.acl != nil && !sw.acl.Allow(parsed)) {
+		sw.drops.Add(1)
+		return nil, nil
+	}
 
 	// Learn source MAC → remote peer
 	sw.learn(parsed.SrcMAC, peerAddr, false)
@@ -104,6 +209,7 @@ func (sw *Switch) HandleRemoteFrame(peerAddr identity.Address, frame []byte) ([]
 	// If broadcast/multicast or destined for a local MAC, inject into TAP
 	if parsed.IsBroadcast() || parsed.IsMulticast() {
 		// Also flood to other remote peers (not back to sender)
+		sw.broadcastFloods.Add(1)
 		_ = sw.sender.BroadcastToPeers(sw.networkID, frame, peerAddr)
 		return frame, nil
 	}
@@ -115,40 +221,94 @@ func (sw *Switch) HandleRemoteFrame(peerAddr identity.Address, frame []byte) ([]
 
 	if found && entry.IsLocal {
 		// Destination is local: inject into TAP
+		sw.unicastHits.Add(1)
 		return frame, nil
 	}
 
 	if found && !entry.IsLocal {
 		// Destination is another remote peer: forward
+		sw.unicastHits.Add(1)
 		_ = sw.sender.SendToPeer(entry.PeerAddr, sw.networkID, frame)
 		return nil, nil // Don't inject into local TAP
 	}
 
 	// Unknown: inject locally (might be for us) and flood
+	sw.unknownFloods.Add(1)
 	_ = sw.sender.BroadcastToPeers(sw.networkID, frame, peerAddr)
 	return frame, nil
 }
 
-// learn adds or updates a MAC table entry.
+// learn adds or updates a MAC table entry. A MAC that moves between peers more
+// than MACFlapThreshold times within MACFlapWindow is pinned to its current
+// location for MACFlapHoldDown, so a spoofed or looping frame cannot keep
+// redirecting its traffic.
 func (sw *Switch) learn(mac net.HardwareAddr, peerAddr identity.Address, isLocal bool) {
 	key := MACToKey(mac)
+	now := time.Now()
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
 
-	// Enforce table size limit
-	if len(sw.macTable) >= MACTableMaxSize {
-		sw.evictOldest()
+	old, exists := sw.macTable[key]
+	if !exists {
+		// Enforce table size limit
+		for len(sw.macTable) >= sw.maxSize {
+			if !sw.evictOldest() {
+				break
+			}
+		}
+		sw.macTable[key] = &MACEntry{
+			PeerAddr: peerAddr,
+			LastSeen: now,
+			IsLocal:  isLocal,
+		}
+		return
 	}
 
-	sw.macTable[key] = &MACEntry{
-		PeerAddr: peerAddr,
-		LastSeen: time.Now(),
-		IsLocal:  isLocal,
+	// Entries are replaced rather than modified, since lookups read them
+	// after releasing the lock.
+	entry := *old
+	switch {
+	case entry.sameLocation(peerAddr, isLocal):
+		entry.LastSeen = now
+	case now.Before(entry.PinnedUntil):
+		return // dampened: ignore the move
+	default:
+		if now.Sub(entry.MovesSince) > MACFlapWindow {
+			entry.Moves = 0
+			entry.MovesSince = now
+		}
+		entry.Moves++
+		if entry.Moves > MACFlapThreshold {
+			entry.PinnedUntil = now.Add(MACFlapHoldDown)
+			entry.Moves = 0
+			entry.MovesSince = time.Time{}
+			sw.flaps.Add(1)
+			sw.log.Warn("MAC flapping between peers, pinning entry",
+				"mac", mac.String(), "pinned_to", entryLocation(old), "moving_to", location(peerAddr, isLocal),
+				"hold_down", MACFlapHoldDown)
+		} else {
+			entry.PeerAddr = peerAddr
+			entry.IsLocal = isLocal
+			entry.LastSeen = now
+		}
 	}
+	sw.macTable[key] = &entry
 }
 
-// evictOldest removes the oldest entry from the MAC table.
-func (sw *Switch) evictOldest() {
+func location(peerAddr identity.Address, isLocal bool) string {
+	if isLocal {
+		return "local"
+	}
+	return peerAddr.String()
+}
+
+func entryLocation(e *MACEntry) string {
+	return location(e.PeerAddr, e.IsLocal)
+}
+
+// evictOldest removes the oldest entry from the MAC table, reporting
+// whether there was one.
+func (sw *Switch) evictOldest() bool {
 	var oldestKey MACKey
 	var oldestTime time.Time
 	first := true
@@ -162,13 +322,14 @@ func (sw *Switch) evictOldest() {
 	if !first {
 		delete(sw.macTable, oldestKey)
 	}
+	return !first
 }
 
 // CleanExpired removes expired MAC table entries.
 func (sw *Switch) CleanExpired() int {
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
-	cutoff := time.Now().Add(-MACTableExpiry)
+	cutoff := time.Now().Add(-sw.expiry)
 	removed := 0
 	for k, v := range sw.macTable {
 		if v.LastSeen.Before(cutoff) && !v.IsLocal {
@@ -179,9 +340,42 @@ func (sw *Switch) CleanExpired() int {
 	return removed
 }
 
+// RemovePeer forgets every MAC learned behind a peer, so frames for those
+// addresses flood (to the remaining peers) instead of being sent to it. It
+// returns how many entries were removed.
+func (sw *Switch) RemovePeer(peerAddr identity.Address) int {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	removed := 0
+	for k, v := range sw.macTable {
+		if !v.IsLocal && v.PeerAddr == peerAddr {
+			delete(sw.macTable, k)
+			removed++
+		}
+	}
+	return removed
+}
+
 // MACTableSize returns the current MAC table size.
 func (sw *Switch) MACTableSize() int {
 	sw.mu.RLock()
 	defer sw.mu.RUnlock()
 	return len(sw.macTable)
 }
+
+// MACFlaps returns how many times a flapping MAC entry has been dampened.
+func (sw *Switch) MACFlaps() uint64 {
+	return sw.flaps.Load()
+}
+
+// Stats returns the switch's forwarding counters.
+func (sw *Switch) Stats() SwitchStats {
+	return SwitchStats{
+		UnicastHits:     sw.unicastHits.Load(),
+		UnknownFloods:   sw.unknownFloods.Load(),
+		BroadcastFloods: sw.broadcastFloods.Load(),
+		Drops:           sw.drops.Load(),
+		MACFlaps:        sw.flaps.Load(),
+		MACTableSize:    sw.MACTableSize(),
+	}
+}
@@ -0,0 +1,194 @@
+package vl2
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unicornultrafoundation/zerogo/internal/identity"
+)
+
+func testLog() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// recordingSender records the frames a switch sends.
+type recordingSender struct {
+	mu         sync.Mutex
+	unicast    map[identity.Address]int
+	broadcasts int
+	last       []byte // the last frame sent to a peer
+}
+
+func newRecordingSender() *recordingSender {
+	return &recordingSender{unicast: make(map[identity.Address]int)}
+}
+
+func (s *recordingSender) SendToPeer(peer identity.Address, networkID uint32, frame []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unicast[peer]++
+	s.last = append([]byte(nil), frame...)
+	return nil
+}
+
+func (s *recordingSender) BroadcastToPeers(networkID uint32, frame []byte, exclude identity.Address) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.broadcasts++
+	return nil
+}
+
+func (s *recordingSender) counts(peer identity.Address) (unicast, broadcasts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unicast[peer], s.broadcasts
+}
+
+// ethFrame builds a minimal IPv4 Ethernet frame from src to dst.
+func ethFrame(dst, src net.HardwareAddr) []byte {
+	frame := make([]byte, EthernetHeaderSize+20)
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	frame[12], frame[13] = 0x08, 0x00
+	return frame
+}
+
+func mustMAC(t *testing.T, s string) net.HardwareAddr {
+	t.Helper()
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mac
+}
+
+func TestRemovedPeerMACIsNotUnicast(t *testing.T) {
+	sender := newRecordingSender()
+	n := NewNetwork(NetworkConfig{ID: 1, Name: "test"}, identity.Address{1}, sender, testLog())
+	peer := identity.Address{2}
+	local := mustMAC(t, "02:00:00:00:00:01")
+	remote := mustMAC(t, "02:00:00:00:00:02")
+
+	// Learn the remote MAC behind peer, then unicast to it
+	if _, err := n.Switch.HandleRemoteFrame(peer, ethFrame(local, remote)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Switch.HandleLocalFrame(ethFrame(remote, local)); err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := sender.counts(peer); sent != 1 {
+		t.Fatalf("frames unicast to peer = %d, want 1", sent)
+	}
+
+	n.RemovePeer(peer)
+	_, floodsBefore := sender.counts(peer)
+	if err := n.Switch.HandleLocalFrame(ethFrame(remote, local)); err != nil {
+		t.Fatal(err)
+	}
+	sent, floods := sender.counts(peer)
+	if sent != 1 {
+		t.Errorf("frame unicast to removed peer")
+	}
+	if floods != floodsBefore+1 {
+		t.Errorf("frame to removed peer's MAC not flooded as unknown")
+	}
+}
+
+func TestMACFlapDampening(t *testing.T) {
+	sender := newRecordingSender()
+	n := NewNetwork(NetworkConfig{ID: 1, Name: "test"}, identity.Address{1}, sender, testLog())
+	sw := n.Switch
+	p1, p2 := identity.Address{2}, identity.Address{3}
+	local := mustMAC(t, "02:00:00:00:00:01")
+	flapping := mustMAC(t, "02:00:00:00:00:02")
+
+	// The MAC shows up behind alternating peers; the sixth move pins it
+	// where it was
+	for i := range 20 {
+		from := p1
+		if i%2 == 1 {
+			from = p2
+		}
+		if _, err := sw.HandleRemoteFrame(from, ethFrame(local, flapping)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := sw.MACFlaps(); got != 1 {
+		t.Fatalf("MACFlaps = %d, want 1", got)
+	}
+	if got := sw.Stats().MACFlaps; got != 1 {
+		t.Fatalf("Stats().MACFlaps = %d, want 1", got)
+	}
+	if err := sw.HandleLocalFrame(ethFrame(flapping, local)); err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := sender.counts(p2); sent != 1 {
+		t.Fatalf("frames to the pinned peer = %d, want 1", sent)
+	}
+	if sent, _ := sender.counts(p1); sent != 0 {
+		t.Fatalf("pinned MAC moved to %v", p1)
+	}
+
+	// Once the hold-down passes the MAC may move again
+	key := MACToKey(flapping)
+	sw.mu.Lock()
+	entry := *sw.macTable[key]
+	entry.PinnedUntil = time.Now().Add(-time.Second)
+	sw.macTable[key] = &entry
+	sw.mu.Unlock()
+	if _, err := sw.HandleRemoteFrame(p1, ethFrame(local, flapping)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.HandleLocalFrame(ethFrame(flapping, local)); err != nil {
+		t.Fatal(err)
+	}
+	if sent, _ := sender.counts(p1); sent != 1 {
+		t.Fatal("MAC did not move after the hold-down")
+	}
+}
+
+func TestSwitchStats(t *testing.T) {
+	sender := newRecordingSender()
+	n := NewNetwork(NetworkConfig{ID: 1, Name: "test"}, identity.Address{1}, sender, testLog())
+	sw := n.Switch
+	peer := identity.Address{2}
+	local := mustMAC(t, "02:00:00:00:00:01")
+	remote := mustMAC(t, "02:00:00:00:00:02")
+	broadcast := mustMAC(t, "ff:ff:ff:ff:ff:ff")
+
+	// Before the remote MAC is known, a frame to it floods
+	if err := sw.HandleLocalFrame(ethFrame(remote, local)); err != nil {
+		t.Fatal(err)
+	}
+	if s := sw.Stats(); s.UnknownFloods != 1 || s.UnicastHits != 0 {
+		t.Fatalf("after an unknown destination: %+v", s)
+	}
+
+	// Its reply teaches the switch both MACs; the reply itself hits the
+	// local MAC, and the next frame out is a known unicast
+	if _, err := sw.HandleRemoteFrame(peer, ethFrame(local, remote)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.HandleLocalFrame(ethFrame(remote, local)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.HandleLocalFrame(ethFrame(broadcast, local)); err != nil {
+		t.Fatal(err)
+	}
+	n.Guard.SetEnabled(true) // with no peer assignments, every remote frame drops
+	if _, err := sw.HandleRemoteFrame(peer, ethFrame(local, remote)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := SwitchStats{UnicastHits: 2, UnknownFloods: 1, BroadcastFloods: 1, Drops: 1, MACTableSize: 2}
+	if s := sw.Stats(); s != want {
+		t.Fatalf("Stats = %+v, want %+v", s, want)
+	}
+	if sent, broadcasts := sender.counts(peer); sent != 1 || broadcasts != 2 {
+		t.Fatalf("sent %d unicast and %d floods, want 1 and 2", sent, broadcasts)
+	}
+}